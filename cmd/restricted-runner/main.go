@@ -0,0 +1,285 @@
+// Command restricted-runner provides operational tools for the runners of
+// go-restricted-runner.
+//
+// Usage:
+//
+//	restricted-runner selftest [-runner firejail,landrun] [-json] [-v]
+//	restricted-runner support [-json]
+//	restricted-runner gc [-engine podman] [-images 'acme/*'] [-pin 'alpine'] [-all] [-json]
+//	restricted-runner lint [-strict] [-policy] [-json] <config.json>...
+//
+// The selftest command runs canary commands through every runner available
+// on the host, verifying their restrictions are enforced. It exits with 1
+// when any check fails.
+//
+// The support command reports the runner types available on the host, with
+// the versions of their tools and the features they support.
+//
+// The gc command removes the old containers of the Docker runner and, when
+// the disk of the engine is filling up, the images that can be removed. It
+// can be run periodically on execution hosts, e.g. from cron.
+//
+// The lint command checks runner configuration files (see
+// runner.LoadRunnerConfig) before they are deployed, e.g. in CI: unknown or
+// invalid options, missing paths, overly broad grants and contradictory
+// rules. It exits with 1 when any error is found (or any warning, with
+// -strict), and can print the policy the options translate to.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+	"github.com/inercia/go-restricted-runner/pkg/runner"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  selftest    verify the restrictions of the runners available on this host\n")
+	fmt.Fprintf(os.Stderr, "  support     report the runner types available on this host\n")
+	fmt.Fprintf(os.Stderr, "  gc          remove the old containers and images of the container runners\n")
+	fmt.Fprintf(os.Stderr, "  lint        check runner configuration files before deploying them\n")
+}
+
+func main() {
+	// the selftest command runs the Landrun checks in a helper process
+	runner.MaybeRunHelper()
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "selftest":
+		os.Exit(selfTest(os.Args[2:]))
+	case "support":
+		os.Exit(support(os.Args[2:]))
+	case "gc":
+		os.Exit(gc(os.Args[2:]))
+	case "lint":
+		os.Exit(lint(os.Args[2:]))
+	case "-h", "-help", "--help", "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+}
+
+// selfTest runs the selftest command and returns the exit code
+func selfTest(args []string) int {
+	flags := flag.NewFlagSet("selftest", flag.ExitOnError)
+	runners := flags.String("runner", "", "comma separated runner types to test (default: all)")
+	asJSON := flags.Bool("json", false, "write the report as JSON")
+	verbose := flags.Bool("v", false, "log the commands run")
+	_ = flags.Parse(args)
+
+	level := common.LogLevelError
+	if *verbose {
+		level = common.LogLevelDebug
+	}
+	logger, err := common.NewLogger("", "", level, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create the logger: %v\n", err)
+		return 2
+	}
+	defer logger.Close()
+
+	var types []runner.Type
+	if *runners != "" {
+		for _, t := range strings.Split(*runners, ",") {
+			types = append(types, runner.Type(strings.TrimSpace(t)))
+		}
+	}
+
+	report, err := runner.SelfTest(context.Background(), logger, types...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "self test failed: %v\n", err)
+		return 2
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write the report: %v\n", err)
+		return 2
+	}
+
+	if !report.Passed() {
+		return 1
+	}
+	return 0
+}
+
+// support runs the support command and returns the exit code
+func support(args []string) int {
+	flags := flag.NewFlagSet("support", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "write the report as JSON")
+	_ = flags.Parse(args)
+
+	logger, err := common.NewLogger("", "", common.LogLevelError, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create the logger: %v\n", err)
+		return 2
+	}
+	defer logger.Close()
+
+	report := runner.SupportMatrix(context.Background(), logger)
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	} else {
+		err = report.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write the report: %v\n", err)
+		return 2
+	}
+	return 0
+}
+
+// gc runs the gc command and returns the exit code
+func gc(args []string) int {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	engine := flags.String("engine", runner.EngineDocker, "container engine (docker or podman)")
+	images := flags.String("images", "", "comma separated patterns of the images that can be removed")
+	pinned := flags.String("pin", "", "comma separated patterns of the images never removed")
+	high := flags.Float64("high", 0, "percentage of the disk used above which images are removed (default 85)")
+	low := flags.Float64("low", 0, "percentage of the disk used images are removed until (default 70)")
+	all := flags.Bool("all", false, "remove all the images that can be removed, whatever the disk usage")
+	asJSON := flags.Bool("json", false, "write the report as JSON")
+	verbose := flags.Bool("v", false, "log the containers and images removed")
+	_ = flags.Parse(args)
+
+	level := common.LogLevelError
+	if *verbose {
+		level = common.LogLevelDebug
+	}
+	logger, err := common.NewLogger("", "", level, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create the logger: %v\n", err)
+		return 2
+	}
+	defer logger.Close()
+
+	collector, err := runner.NewImageGC(runner.ImageGCOptions{
+		Engine:        *engine,
+		HighWatermark: *high,
+		LowWatermark:  *low,
+		Images:        splitList(*images),
+		PinnedImages:  splitList(*pinned),
+	}, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	var report *runner.ImageGCReport
+	if *all {
+		report, err = collector.Prune(context.Background())
+	} else {
+		report, err = collector.Collect(context.Background())
+	}
+	if report == nil {
+		fmt.Fprintf(os.Stderr, "garbage collection failed: %v\n", err)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "some containers or images could not be removed: %v\n", err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(report)
+	} else {
+		fmt.Printf("Removed %d containers and %d images: %.1f%% of %s used (was %.1f%%)\n",
+			len(report.RemovedContainers), len(report.RemovedImages), report.After.Used(), report.After.Path,
+			report.Before.Used())
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// lint runs the lint command and returns the exit code
+func lint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	strict := flags.Bool("strict", false, "fail on warnings too")
+	policy := flags.Bool("policy", false, "write the policy the options translate to")
+	asJSON := flags.Bool("json", false, "write the reports as JSON")
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s lint [-strict] [-policy] [-json] <config.json>...\n", os.Args[0])
+		return 2
+	}
+
+	logger, err := common.NewLogger("", "", common.LogLevelError, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create the logger: %v\n", err)
+		return 2
+	}
+	defer logger.Close()
+
+	code := 0
+	reports := []*runner.LintReport{}
+	for _, path := range flags.Args() {
+		report, err := runner.LintFile(path, logger)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+		if !report.Passed() || (*strict && len(report.Issues) > 0) {
+			code = 1
+		}
+		if !*policy {
+			report.Policy = ""
+		}
+		reports = append(reports, report)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(reports)
+	} else {
+		for _, report := range reports {
+			if err = report.WriteText(os.Stdout); err != nil {
+				break
+			}
+			if report.Policy != "" {
+				fmt.Printf("\n%s\n", report.Policy)
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write the reports: %v\n", err)
+		return 2
+	}
+	return code
+}
+
+// splitList splits a comma separated list, ignoring empty items
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
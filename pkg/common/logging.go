@@ -2,6 +2,7 @@
 package common
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -52,6 +53,12 @@ type Logger struct {
 	filePath string
 	// The log file handle (if used)
 	file *os.File
+	// The ID tagging all the messages (see WithLogID)
+	id string
+	// The category of the messages (see Category), subject to sampling
+	category string
+	// The sampling rules and counters, shared with the derived loggers
+	sampler *sampler
 }
 
 // NewLogger creates a new Logger instance
@@ -102,6 +109,7 @@ func NewLogger(prefix string, filePath string, level LogLevel, truncate bool) (*
 		level:    level,
 		filePath: filePath,
 		file:     file,
+		sampler:  newSampler(),
 	}
 
 	// Log the initialization
@@ -123,30 +131,49 @@ func (l *Logger) Close() error {
 
 // Debug logs a message at debug level
 func (l *Logger) Debug(format string, v ...interface{}) {
-	if l.level >= LogLevelDebug {
-		l.Printf("[DEBUG] "+format, v...)
-	}
+	l.logf(LogLevelDebug, "[DEBUG] ", format, v...)
 }
 
 // Info logs a message at info level
 func (l *Logger) Info(format string, v ...interface{}) {
-	if l.level >= LogLevelInfo {
-		l.Printf("[INFO] "+format, v...)
-	}
+	l.logf(LogLevelInfo, "[INFO] ", format, v...)
 }
 
 // Warn logs a warning message
 func (l *Logger) Warn(format string, v ...interface{}) {
-	if l.level >= LogLevelInfo {
-		l.Printf("[WARN] "+format, v...)
-	}
+	l.logf(LogLevelInfo, "[WARN] ", format, v...)
 }
 
 // Error logs a message at error level
 func (l *Logger) Error(format string, v ...interface{}) {
-	if l.level >= LogLevelError {
-		l.Printf("[ERROR] "+format, v...)
+	l.logf(LogLevelError, "[ERROR] ", format, v...)
+}
+
+// logf logs a message if the logger level is at least level, and the
+// sampling rule of the category of the logger (if any) lets it through.
+// The registered secrets are redacted (see RegisterSecret).
+func (l *Logger) logf(level LogLevel, label string, format string, v ...interface{}) {
+	if l.level < level {
+		return
+	}
+	if l.category != "" {
+		ok, dropped := l.sampler.sample(l.category)
+		if dropped > 0 {
+			l.Printf("%s%s(%d similar %q messages dropped)", label, l.tag(), dropped, l.category)
+		}
+		if !ok {
+			return
+		}
 	}
+	l.Printf("%s%s%s", label, l.tag(), RedactSecrets(fmt.Sprintf(format, v...)))
+}
+
+// tag returns the prefix added to the messages for the ID of the logger
+func (l *Logger) tag() string {
+	if l.id == "" {
+		return ""
+	}
+	return "[" + l.id + "] "
 }
 
 // FilePath returns the current log file path
@@ -187,3 +214,69 @@ func GetLogger() *Logger {
 func SetLogger(logger *Logger) {
 	globalLogger = logger
 }
+
+//////////////////////////////////////////////////////////////////////
+
+// logContextKey is the context key of the logging overrides of a request
+type logContextKey struct{}
+
+// logOverride holds the logging overrides of a request
+type logOverride struct {
+	level LogLevel
+	id    string
+}
+
+// WithLogLevel returns a context that raises the logging level to level for
+// the calls made with it (e.g. to debug a single problematic execution),
+// without changing the level of the logger used for other calls.
+// The level is never lowered: the context can only make logging more verbose.
+func WithLogLevel(ctx context.Context, level LogLevel) context.Context {
+	o := logOverrideFrom(ctx)
+	o.level = level
+	return context.WithValue(ctx, logContextKey{}, o)
+}
+
+// WithLogID returns a context that tags the lines logged for the calls made
+// with it with id (e.g. an execution ID). It only has an effect along with
+// WithLogLevel.
+func WithLogID(ctx context.Context, id string) context.Context {
+	o := logOverrideFrom(ctx)
+	o.id = id
+	return context.WithValue(ctx, logContextKey{}, o)
+}
+
+// LogIDFromContext returns the ID set with WithLogID, if any
+func LogIDFromContext(ctx context.Context) string {
+	return logOverrideFrom(ctx).id
+}
+
+// HasLogLevel returns whether the context has a logging level set with WithLogLevel
+func HasLogLevel(ctx context.Context) bool {
+	return logOverrideFrom(ctx).level != LogLevelNone
+}
+
+// logOverrideFrom returns the overrides of the context (zero if none)
+func logOverrideFrom(ctx context.Context) logOverride {
+	o, _ := ctx.Value(logContextKey{}).(logOverride)
+	return o
+}
+
+// ContextLogger returns the logger to use for a call made with ctx: base
+// itself, or a logger writing to the same destination with the level and ID
+// set with WithLogLevel and WithLogID.
+func ContextLogger(ctx context.Context, base *Logger) *Logger {
+	o := logOverrideFrom(ctx)
+	if o.level <= base.level {
+		return base
+	}
+
+	return &Logger{
+		Logger:   log.New(base.Writer(), base.Prefix(), base.Flags()),
+		level:    o.level,
+		filePath: base.filePath,
+		// the file is owned (and closed) by the base logger
+		id:       o.id,
+		category: base.category,
+		sampler:  base.sampler,
+	}
+}
@@ -2,22 +2,147 @@
 package common
 
 import (
-	"os/exec"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 )
 
-// CheckExecutableExists checks if a command is available in the system PATH.
+// ErrExecutableNotFound is returned by ResolveExecutable when the executable
+// is not found
+var ErrExecutableNotFound = errors.New("executable not found")
+
+// CheckExecutableExists checks if a command is available in the system PATH
+// or in the extra search paths (see ResolveExecutable).
 //
 // Parameters:
 //   - executableName: The name of the executable to check
+//   - extraPaths: Folders searched after the PATH
 //
 // Returns:
 //   - true if the executable exists and is accessible, false otherwise
-func CheckExecutableExists(executableName string) bool {
-	_, err := exec.LookPath(executableName)
+func CheckExecutableExists(executableName string, extraPaths ...string) bool {
+	_, err := ResolveExecutable(executableName, extraPaths...)
 	return err == nil
 }
 
+// ResolveExecutable returns the absolute path of an executable.
+//
+// The name can be quoted (e.g. `"C:\Program Files\Git\bin\git.exe"`).
+// Names with a path separator are resolved from the current directory, and
+// the other names are searched in the PATH and then in extraPaths. On
+// Windows, names without one of the extensions of PATHEXT (".COM;.EXE;.BAT;
+// .CMD" by default) are tried with each of them.
+//
+// Parameters:
+//   - executableName: The name or path of the executable
+//   - extraPaths: Folders searched after the PATH
+//
+// Returns:
+//   - The absolute path of the executable, or an error wrapping
+//     ErrExecutableNotFound
+func ResolveExecutable(executableName string, extraPaths ...string) (string, error) {
+	return resolveExecutable(runtime.GOOS, os.Getenv("PATH"), os.Getenv("PATHEXT"), executableName, extraPaths)
+}
+
+// resolveExecutable implements ResolveExecutable for an OS, PATH and PATHEXT
+func resolveExecutable(goos string, path string, pathExt string, executableName string, extraPaths []string) (string, error) {
+	name := unquote(strings.TrimSpace(executableName))
+	if name == "" {
+		return "", fmt.Errorf("%w: empty name", ErrExecutableNotFound)
+	}
+
+	exts := []string{""}
+	if goos == "windows" {
+		exts = windowsExtensions(name, pathExt)
+	}
+
+	var folders []string
+	if strings.ContainsRune(name, '/') || (goos == "windows" && strings.ContainsAny(name, `\:`)) {
+		folders = []string{""}
+	} else {
+		folders = append(filepath.SplitList(path), extraPaths...)
+	}
+
+	for _, folder := range folders {
+		if folder == "" && len(folders) > 1 {
+			// empty PATH entries are not searched, as with exec.LookPath
+			continue
+		}
+		for _, ext := range exts {
+			candidate := name + ext
+			if folder != "" {
+				candidate = filepath.Join(folder, candidate)
+			}
+			if isExecutable(goos, candidate, pathExt) {
+				return filepath.Abs(candidate)
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrExecutableNotFound, name)
+}
+
+// unquote removes the double or single quotes around a name
+func unquote(name string) string {
+	if len(name) >= 2 && (name[0] == '"' || name[0] == '\'') && name[len(name)-1] == name[0] {
+		return name[1 : len(name)-1]
+	}
+	return name
+}
+
+// pathExtensions returns the extensions of PATHEXT, in lower case
+func pathExtensions(pathExt string) []string {
+	if pathExt == "" {
+		pathExt = ".COM;.EXE;.BAT;.CMD"
+	}
+	var exts []string
+	for _, ext := range strings.Split(strings.ToLower(pathExt), ";") {
+		if ext = strings.TrimSpace(ext); ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts = append(exts, ext)
+	}
+	return exts
+}
+
+// windowsExtensions returns the extensions a name is tried with on Windows:
+// none when it already has one of PATHEXT, or else each of them
+func windowsExtensions(name string, pathExt string) []string {
+	exts := pathExtensions(pathExt)
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range exts {
+		if ext == e {
+			return []string{""}
+		}
+	}
+	return exts
+}
+
+// isExecutable returns whether path is an executable file: on Windows, a file
+// with one of the extensions of PATHEXT, and elsewhere a file with any
+// execute permission bit
+func isExecutable(goos string, path string, pathExt string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	if goos == "windows" {
+		ext := strings.ToLower(filepath.Ext(path))
+		for _, e := range pathExtensions(pathExt) {
+			if ext == e {
+				return true
+			}
+		}
+		return false
+	}
+	return info.Mode()&0o111 != 0
+}
+
 // CheckOSMatches checks if the current operating system matches the required OS.
 //
 // Parameters:
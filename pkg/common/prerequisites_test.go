@@ -0,0 +1,93 @@
+package common
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// writeFile creates a file with a mode for the tests
+func writeFile(t *testing.T, path string, mode os.FileMode) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveExecutable_unix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the execute permission bits are not kept on Windows")
+	}
+	bin := t.TempDir()
+	extra := t.TempDir()
+	writeFile(t, filepath.Join(bin, "tool"), 0o755)
+	writeFile(t, filepath.Join(bin, "data"), 0o644)
+	writeFile(t, filepath.Join(extra, "tool"), 0o755)
+	writeFile(t, filepath.Join(extra, "extra-tool"), 0o755)
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "tool", want: filepath.Join(bin, "tool")},
+		{name: `"tool"`, want: filepath.Join(bin, "tool")},
+		{name: "extra-tool", want: filepath.Join(extra, "extra-tool")},
+		{name: filepath.Join(extra, "tool"), want: filepath.Join(extra, "tool")},
+		{name: "'" + filepath.Join(extra, "tool") + "'", want: filepath.Join(extra, "tool")},
+		{name: "data", wantErr: true},
+		{name: "missing", wantErr: true},
+		{name: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveExecutable("linux", bin, "", tt.name, []string{extra})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveExecutable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrExecutableNotFound) {
+				t.Errorf("resolveExecutable() error = %v, want ErrExecutableNotFound", err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveExecutable() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveExecutable_windows(t *testing.T) {
+	bin := t.TempDir()
+	writeFile(t, filepath.Join(bin, "git.exe"), 0o644)
+	writeFile(t, filepath.Join(bin, "build.cmd"), 0o644)
+	writeFile(t, filepath.Join(bin, "script.ps1"), 0o644)
+
+	tests := []struct {
+		name    string
+		pathExt string
+		want    string
+		wantErr bool
+	}{
+		{name: "git", pathExt: ".COM;.EXE;.BAT;.CMD", want: "git.exe"},
+		{name: "git.exe", pathExt: ".COM;.EXE", want: "git.exe"},
+		{name: "build", want: "build.cmd"},
+		{name: "build", pathExt: ".EXE", wantErr: true},
+		{name: "script.ps1", wantErr: true},
+		{name: "script", pathExt: ".EXE;.PS1", want: "script.ps1"},
+		{name: `"` + filepath.Join(bin, "git") + `"`, want: "git.exe"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveExecutable("windows", bin, tt.pathExt, tt.name, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveExecutable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want != "" && got != filepath.Join(bin, tt.want) {
+				t.Errorf("resolveExecutable() = %q, want %q", got, filepath.Join(bin, tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RedactedSecret replaces the registered secrets in the log messages
+const RedactedSecret = "[REDACTED]"
+
+// minSecretLength is the length of the shortest secret redacted: shorter
+// values would make the messages unreadable
+const minSecretLength = 4
+
+// secrets are the values redacted from the log messages
+var secrets struct {
+	sync.RWMutex
+	values []string
+}
+
+// RegisterSecret registers values (such as the secrets resolved for the
+// options of the runners) to be replaced by RedactedSecret in all the log
+// messages. Values shorter than 4 characters are ignored.
+func RegisterSecret(values ...string) {
+	secrets.Lock()
+	defer secrets.Unlock()
+
+	for _, value := range values {
+		if len(value) < minSecretLength || containsString(secrets.values, value) {
+			continue
+		}
+		secrets.values = append(secrets.values, value)
+	}
+	// longest first, so a secret containing another one is fully replaced
+	sort.Slice(secrets.values, func(i, j int) bool { return len(secrets.values[i]) > len(secrets.values[j]) })
+}
+
+// Secrets returns the registered secrets
+func Secrets() []string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	return append([]string(nil), secrets.values...)
+}
+
+// RedactSecrets replaces the registered secrets in s
+func RedactSecrets(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+
+	for _, secret := range secrets.values {
+		s = strings.ReplaceAll(s, secret, RedactedSecret)
+	}
+	return s
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
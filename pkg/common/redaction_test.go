@@ -0,0 +1,23 @@
+package common
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestRegisterSecret(t *testing.T) {
+	RegisterSecret("abc", "hunter2", "hunter2-long")
+
+	if got := RedactSecrets("password hunter2-long and hunter2, short abc"); got != "password [REDACTED] and [REDACTED], short abc" {
+		t.Errorf("RedactSecrets() = %q", got)
+	}
+
+	var buf bytes.Buffer
+	logger := &Logger{Logger: log.New(&buf, "", 0), level: LogLevelDebug}
+	logger.Debug("logging in with %s", "hunter2")
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Errorf("the secret was logged: %q", buf.String())
+	}
+}
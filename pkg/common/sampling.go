@@ -0,0 +1,107 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// SamplingRule limits the number of messages logged for a category
+//
+// In every Interval, the First messages are logged, and then only one
+// of every Thereafter messages. When messages have been dropped, a line
+// with the number of dropped messages is logged at the start of the next
+// interval with activity.
+type SamplingRule struct {
+	// Interval is the sampling period (defaults to one second)
+	Interval time.Duration
+	// First is the number of messages logged in each period
+	First int
+	// Thereafter logs one of every Thereafter messages after the first ones
+	// (0 drops all of them)
+	Thereafter int
+}
+
+// sampler holds the sampling rules and counters of a logger
+type sampler struct {
+	mu       sync.Mutex
+	rules    map[string]SamplingRule
+	counters map[string]*sampleCounter
+}
+
+// sampleCounter counts the messages of a category in the current period
+type sampleCounter struct {
+	start   time.Time
+	count   int
+	dropped int
+}
+
+func newSampler() *sampler {
+	return &sampler{
+		rules:    map[string]SamplingRule{},
+		counters: map[string]*sampleCounter{},
+	}
+}
+
+// sample returns whether a message of the category must be logged, and the
+// number of messages dropped in the previous period when a new one starts
+func (s *sampler) sample(category string) (bool, int) {
+	if s == nil {
+		return true, 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rule, ok := s.rules[category]
+	if !ok {
+		return true, 0
+	}
+	interval := rule.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	now := time.Now()
+	c, ok := s.counters[category]
+	if !ok {
+		c = &sampleCounter{start: now}
+		s.counters[category] = c
+	}
+
+	dropped := 0
+	if now.Sub(c.start) >= interval {
+		dropped = c.dropped
+		*c = sampleCounter{start: now}
+	}
+
+	c.count++
+	if c.count <= rule.First ||
+		(rule.Thereafter > 0 && (c.count-rule.First)%rule.Thereafter == 0) {
+		return true, dropped
+	}
+	c.dropped++
+	return false, dropped
+}
+
+// SetSampling sets the sampling rule for the messages of a category (see
+// Category). It applies to the logger and to all the loggers derived from it.
+func (l *Logger) SetSampling(category string, rule SamplingRule) {
+	if l.sampler == nil {
+		l.sampler = newSampler()
+	}
+	l.sampler.mu.Lock()
+	defer l.sampler.mu.Unlock()
+	l.sampler.rules[category] = rule
+	delete(l.sampler.counters, category)
+}
+
+// Category returns a logger for the messages of a category, which are
+// subject to the sampling rule set for it with SetSampling.
+//
+// The returned logger shares the destination of l and must not be closed.
+func (l *Logger) Category(category string) *Logger {
+	c := *l
+	c.category = category
+	c.file = nil
+	return &c
+}
@@ -0,0 +1,239 @@
+// Package history records the executions of the runners in an embedded
+// SQLite database, so the commands that ran on a host can be investigated
+// later without any external infrastructure.
+package history
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	// pure Go SQLite driver, registered as "sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// DefaultMaxOutputBytes is the default amount of output kept per execution
+const DefaultMaxOutputBytes = 4096
+
+// schema creates the tables of the store
+const schema = `
+CREATE TABLE IF NOT EXISTS executions (
+	id          TEXT PRIMARY KEY,
+	runner      TEXT NOT NULL,
+	command     TEXT NOT NULL,
+	args        TEXT NOT NULL,
+	fingerprint TEXT NOT NULL,
+	exit_code   INTEGER NOT NULL,
+	error       TEXT NOT NULL,
+	started_at  INTEGER NOT NULL,
+	finished_at INTEGER NOT NULL,
+	output      TEXT NOT NULL,
+	truncated   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS executions_started_at ON executions (started_at);
+CREATE INDEX IF NOT EXISTS executions_fingerprint ON executions (fingerprint);
+`
+
+// Record is an execution recorded in the store
+type Record struct {
+	// ID is the ID of the execution
+	ID string `json:"id"`
+
+	// Runner is the type (or the registry name) of the runner
+	Runner string `json:"runner"`
+
+	// Command and Args are the command executed
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+
+	// Fingerprint is the policy fingerprint of the runner (see runner.Fingerprint)
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// ExitCode is the exit code of the command, or -1 if unknown
+	ExitCode int `json:"exit_code"`
+
+	// Error is the error returned by the execution, if any
+	Error string `json:"error,omitempty"`
+
+	// StartedAt and FinishedAt are when the command started and completed
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+
+	// Output is the beginning of the output of the command (stdout and stderr)
+	Output string `json:"output,omitempty"`
+
+	// Truncated is set when the output was longer than the store keeps
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Duration returns how long the command ran
+func (r *Record) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// Options is the options for a Store
+type Options struct {
+	// MaxOutputBytes is the amount of output kept per execution
+	// (DefaultMaxOutputBytes by default, negative to keep none)
+	MaxOutputBytes int
+}
+
+// Store is an execution history stored in a SQLite database.
+// It is safe for concurrent use.
+type Store struct {
+	db      *sql.DB
+	options Options
+}
+
+// Open opens (or creates) the history stored in the SQLite database at path
+func Open(path string, options Options) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history: %w", err)
+	}
+	// SQLite serializes the writers anyway
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create history schema: %w", err)
+	}
+
+	if options.MaxOutputBytes == 0 {
+		options.MaxOutputBytes = DefaultMaxOutputBytes
+	}
+	return &Store{db: db, options: options}, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Add records an execution, truncating its output
+func (s *Store) Add(ctx context.Context, rec Record) error {
+	if rec.ID == "" {
+		return fmt.Errorf("execution record requires an ID")
+	}
+
+	max := s.options.MaxOutputBytes
+	if max < 0 {
+		max = 0
+	}
+	if len(rec.Output) > max {
+		rec.Output = rec.Output[:max]
+		rec.Truncated = true
+	}
+
+	args, err := json.Marshal(rec.Args)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT OR REPLACE INTO executions
+		(id, runner, command, args, fingerprint, exit_code, error, started_at, finished_at, output, truncated)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rec.ID, rec.Runner, rec.Command, string(args), rec.Fingerprint, rec.ExitCode, rec.Error,
+		rec.StartedAt.UnixNano(), rec.FinishedAt.UnixNano(), rec.Output, rec.Truncated)
+	if err != nil {
+		return fmt.Errorf("failed to record execution %s: %w", rec.ID, err)
+	}
+	return nil
+}
+
+// Filter selects executions in Query. Zero fields do not filter.
+type Filter struct {
+	// Since and Until bound the time the executions started at
+	Since time.Time
+	Until time.Time
+
+	// Runner and Fingerprint select the executions of a runner or a policy
+	Runner      string
+	Fingerprint string
+
+	// CommandContains selects the executions whose command contains the string
+	CommandContains string
+
+	// FailedOnly selects the executions that did not exit with 0
+	FailedOnly bool
+
+	// Limit is the maximum number of records returned (100 by default)
+	Limit int
+}
+
+// Query returns the executions matching the filter, most recent first
+func (s *Store) Query(ctx context.Context, f Filter) ([]Record, error) {
+	var where []string
+	var args []interface{}
+	if !f.Since.IsZero() {
+		where = append(where, "started_at >= ?")
+		args = append(args, f.Since.UnixNano())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "started_at < ?")
+		args = append(args, f.Until.UnixNano())
+	}
+	if f.Runner != "" {
+		where = append(where, "runner = ?")
+		args = append(args, f.Runner)
+	}
+	if f.Fingerprint != "" {
+		where = append(where, "fingerprint = ?")
+		args = append(args, f.Fingerprint)
+	}
+	if f.CommandContains != "" {
+		where = append(where, "instr(command, ?) > 0")
+		args = append(args, f.CommandContains)
+	}
+	if f.FailedOnly {
+		where = append(where, "(exit_code != 0 OR error != '')")
+	}
+
+	query := `SELECT id, runner, command, args, fingerprint, exit_code, error, started_at, finished_at, output, truncated
+		FROM executions`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	query += " ORDER BY started_at DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var records []Record
+	for rows.Next() {
+		var rec Record
+		var argsJSON string
+		var started, finished int64
+		if err := rows.Scan(&rec.ID, &rec.Runner, &rec.Command, &argsJSON, &rec.Fingerprint, &rec.ExitCode,
+			&rec.Error, &started, &finished, &rec.Output, &rec.Truncated); err != nil {
+			return nil, fmt.Errorf("failed to read history: %w", err)
+		}
+		if err := json.Unmarshal([]byte(argsJSON), &rec.Args); err != nil {
+			return nil, fmt.Errorf("failed to read history: %w", err)
+		}
+		rec.StartedAt = time.Unix(0, started)
+		rec.FinishedAt = time.Unix(0, finished)
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// Prune removes the executions started before the given time, and returns
+// how many were removed
+func (s *Store) Prune(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM executions WHERE started_at < ?", before.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune history: %w", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,123 @@
+package history
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/runner"
+)
+
+func openTestStore(t *testing.T, options Options) *Store {
+	t.Helper()
+
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"), options)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestStore_AddQuery(t *testing.T) {
+	s := openTestStore(t, Options{MaxOutputBytes: 5})
+	ctx := context.Background()
+	now := time.Now()
+
+	records := []Record{
+		{ID: "1", Runner: "docker", Command: "make build", Fingerprint: "aaa", StartedAt: now.Add(-10 * 24 * time.Hour)},
+		{ID: "2", Runner: "docker", Command: "make test", Args: []string{"-j4"}, Fingerprint: "aaa",
+			ExitCode: 2, StartedAt: now.Add(-time.Hour), FinishedAt: now.Add(-time.Hour + time.Minute), Output: "FAIL: TestX"},
+		{ID: "3", Runner: "landrun", Command: "ls", Fingerprint: "bbb", StartedAt: now.Add(-time.Minute)},
+	}
+	for _, rec := range records {
+		if err := s.Add(ctx, rec); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	ids := func(recs []Record) string {
+		var out []string
+		for _, r := range recs {
+			out = append(out, r.ID)
+		}
+		return strings.Join(out, ",")
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{"all", Filter{}, "3,2,1"},
+		{"last week", Filter{Since: now.Add(-7 * 24 * time.Hour)}, "3,2"},
+		{"until", Filter{Until: now.Add(-2 * time.Hour)}, "1"},
+		{"runner", Filter{Runner: "docker"}, "2,1"},
+		{"fingerprint", Filter{Fingerprint: "bbb"}, "3"},
+		{"command", Filter{CommandContains: "make"}, "2,1"},
+		{"failed", Filter{FailedOnly: true}, "2"},
+		{"limit", Filter{Limit: 1}, "3"},
+	}
+	for _, tt := range tests {
+		got, err := s.Query(ctx, tt.filter)
+		if err != nil {
+			t.Fatalf("%s: Query failed: %v", tt.name, err)
+		}
+		if ids(got) != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, ids(got))
+		}
+	}
+
+	got, err := s.Query(ctx, Filter{FailedOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := got[0]
+	if rec.Output != "FAIL:" || !rec.Truncated {
+		t.Errorf("expected the output to be truncated, got %q (truncated %v)", rec.Output, rec.Truncated)
+	}
+	if len(rec.Args) != 1 || rec.Args[0] != "-j4" || rec.Duration() != time.Minute {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+
+	removed, err := s.Prune(ctx, now.Add(-24*time.Hour))
+	if err != nil || removed != 1 {
+		t.Errorf("expected 1 record pruned, got %d, %v", removed, err)
+	}
+}
+
+func TestStore_Track(t *testing.T) {
+	s := openTestStore(t, Options{})
+	r, err := runner.NewExec(runner.Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"-c", "echo hello; exit 3"}
+	e, err := runner.Start(context.Background(), r, "sh", args, nil, nil,
+		s.Track(Record{Runner: "exec", Command: "sh", Args: args, Fingerprint: runner.Fingerprint(r)}))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	_, _ = io.ReadAll(e.Stdout)
+	_, _ = io.ReadAll(e.Stderr)
+	_ = e.Wait()
+
+	recs, err := s.Query(context.Background(), Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(recs))
+	}
+	rec := recs[0]
+	if rec.ID != e.ID || rec.ExitCode != 3 || rec.Output != "hello\n" || rec.Fingerprint != runner.Fingerprint(r) {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+	if rec.StartedAt.IsZero() || rec.FinishedAt.Before(rec.StartedAt) {
+		t.Errorf("unexpected times: %v - %v", rec.StartedAt, rec.FinishedAt)
+	}
+}
@@ -0,0 +1,59 @@
+package history
+
+import (
+	"context"
+	"sync"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+	"github.com/inercia/go-restricted-runner/pkg/runner"
+)
+
+// Track returns an option for runner.Start that records the execution in the
+// store once it has completed. The runner, command, args and fingerprint are
+// taken from rec; the ID, times, exit status and output are filled from the
+// execution (the output as read by the caller).
+//
+// Track sets the event handler of the execution, so it cannot be combined
+// with runner.WithEventHandler: use Tracker to forward the events.
+func (s *Store) Track(rec Record) runner.ExecOption {
+	return runner.WithEventHandler(s.Tracker(rec, nil))
+}
+
+// Tracker returns an event handler recording the execution in the store,
+// which forwards the events to next (if not nil)
+func (s *Store) Tracker(rec Record, next runner.EventHandler) runner.EventHandler {
+	var mu sync.Mutex
+	var output []byte
+	limit := s.options.MaxOutputBytes
+
+	return func(ev runner.Event) {
+		mu.Lock()
+		switch ev.Type {
+		case runner.EventPreparing:
+			rec.ID = ev.ExecutionID
+			rec.StartedAt = ev.Time
+		case runner.EventStarted:
+			rec.StartedAt = ev.Time
+		case runner.EventOutputChunk:
+			// one byte more than kept tells Add the output was truncated
+			if room := limit + 1 - len(output); room > 0 {
+				output = append(output, ev.Data[:min(room, len(ev.Data))]...)
+			}
+		case runner.EventExited:
+			rec.FinishedAt = ev.Time
+			rec.ExitCode = ev.ExitCode
+			if ev.Err != nil {
+				rec.Error = ev.Err.Error()
+			}
+			rec.Output = string(output)
+			if err := s.Add(context.Background(), rec); err != nil {
+				common.GetLogger().Debug("History: %v", err)
+			}
+		}
+		mu.Unlock()
+
+		if next != nil {
+			next(ev)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/inercia/go-restricted-runner/pkg/runner"
+)
+
+// ErrUnauthorized is returned by authorizers for the requests a client is not allowed to make
+var ErrUnauthorized = errors.New("request not authorized")
+
+// Client is the authenticated client of a request
+type Client struct {
+	// ID identifies the client: the common name of its TLS certificate, or
+	// the client ID of its execution token when it does not use one
+	ID string
+}
+
+// Authorizer decides whether a client can make a request
+type Authorizer interface {
+	// Authorize returns an error wrapping ErrUnauthorized when the client
+	// is not allowed to make the request
+	Authorize(client Client, req *RunRequest) error
+}
+
+// AuthorizerFunc adapts a function to the Authorizer interface
+type AuthorizerFunc func(client Client, req *RunRequest) error
+
+// Authorize implements Authorizer
+func (f AuthorizerFunc) Authorize(client Client, req *RunRequest) error {
+	return f(client, req)
+}
+
+// ClientPolicy is what a client is allowed to run
+type ClientPolicy struct {
+	// Types are the runner types the client can use
+	Types []runner.Type
+
+	// Images are the Docker images the client can use. An entry ending
+	// with "*" matches any image with that prefix (e.g. "alpine:*").
+	Images []string
+
+	// Options are the keys of the options the client can set. Requests
+	// with any other option are refused, so new options of the runners
+	// are never available to the clients until they are reviewed and
+	// added. The "image" option is allowed when Images is set.
+	Options []string
+
+	// PathRoots are the host directories the paths of the options of the
+	// runners (allowed folders and files, mounts, root filesystems) must be
+	// in. When empty, any path is allowed.
+	PathRoots []string
+}
+
+// PolicyAuthorizer authorizes requests with per-client policies. Clients
+// without a policy are not allowed to run anything.
+type PolicyAuthorizer struct {
+	Policies map[string]ClientPolicy
+}
+
+// pathOptions are the options holding host paths, or lists of host paths
+var pathOptions = []string{
+	"allow_read_folders",
+	"allow_read_exec_folders",
+	"allow_write_folders",
+	"allow_write_exec_folders",
+	"allow_read_files",
+	"allow_write_files",
+	"rootfs",
+	"mounts",
+	"binds",
+	"system_folders",
+	"workdir",
+	"venv",
+	"python",
+	"canary_read_paths",
+	"pinned_executables",
+	"env_files",
+	"ca_bundle",
+	"seccomp_profile",
+	"hermetic_tools",
+	"extra_path",
+	"core_dump_dir",
+	"faketime_library",
+	"busybox_path",
+	"adb_path",
+	"deno_path",
+	"nsjail_path",
+	"proot_path",
+	"unshare_path",
+}
+
+// unconfinedOptions are the options that can give access to paths out of
+// the path roots, so they are refused when the roots are restricted. The
+// requirements are installed by pip in the host, without a sandbox.
+var unconfinedOptions = []string{
+	"unrestricted_filesystem",
+	"allow_user_folders",
+	"custom_profile",
+	"docker_run_opts",
+	"requirements",
+	"requirements_file",
+}
+
+// guestWorkDirTypes are the runner types whose working directory is a path
+// in the container or the device, not in the host
+var guestWorkDirTypes = []runner.Type{runner.TypeDocker, runner.TypePodman, runner.TypeADB}
+
+// Authorize implements Authorizer
+func (a *PolicyAuthorizer) Authorize(client Client, req *RunRequest) error {
+	policy, ok := a.Policies[client.ID]
+	if !ok {
+		return fmt.Errorf("%w: unknown client %q", ErrUnauthorized, client.ID)
+	}
+
+	if !slices.Contains(policy.Types, req.Type) {
+		return fmt.Errorf("%w: runner type %q not allowed for client %q", ErrUnauthorized, req.Type, client.ID)
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(req.Options)) {
+		if !slices.Contains(policy.Options, key) && (key != "image" || len(policy.Images) == 0) {
+			return fmt.Errorf("%w: option %s not allowed for client %q", ErrUnauthorized, key, client.ID)
+		}
+	}
+
+	if image, ok := req.Options["image"].(string); ok && image != "" {
+		if !matchImage(policy.Images, image) {
+			return fmt.Errorf("%w: image %q not allowed for client %q", ErrUnauthorized, image, client.ID)
+		}
+	}
+
+	if len(policy.PathRoots) > 0 {
+		for _, key := range unconfinedOptions {
+			if v, ok := req.Options[key]; ok && !isZero(v) {
+				return fmt.Errorf("%w: option %s not allowed for client %q", ErrUnauthorized, key, client.ID)
+			}
+		}
+		for _, key := range pathOptions {
+			if key == "workdir" && slices.Contains(guestWorkDirTypes, req.Type) {
+				continue
+			}
+			for _, p := range optionPaths(key, req.Options[key]) {
+				if !inRoots(policy.PathRoots, p) {
+					return fmt.Errorf("%w: path %q of option %s not allowed for client %q",
+						ErrUnauthorized, p, key, client.ID)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// matchImage returns whether the image matches any of the patterns
+func matchImage(patterns []string, image string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(image, prefix) {
+				return true
+			}
+		} else if pattern == image {
+			return true
+		}
+	}
+	return false
+}
+
+// optionPaths returns the paths of an option holding a path, a list of them
+// or a map keyed by them. For mounts and binds ("hostpath:guestpath"), the
+// host paths are returned, and the values that are not paths (the built-in
+// and inline seccomp profiles, the names of the executables searched in the
+// PATH) are skipped.
+func optionPaths(key string, value interface{}) []string {
+	var paths []string
+	switch v := value.(type) {
+	case string:
+		paths = []string{v}
+	case []string:
+		paths = v
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				paths = append(paths, s)
+			} else {
+				// unexpected values must not slip through unchecked
+				paths = append(paths, fmt.Sprint(item))
+			}
+		}
+	case map[string]string:
+		paths = slices.Sorted(maps.Keys(v))
+	case map[string]interface{}:
+		paths = slices.Sorted(maps.Keys(v))
+	}
+
+	switch key {
+	case "mounts", "binds":
+		for i, m := range paths {
+			paths[i], _, _ = strings.Cut(m, ":")
+		}
+	case "seccomp_profile":
+		paths = slices.DeleteFunc(paths, func(p string) bool {
+			return p == "default" || p == "strict" || strings.HasPrefix(strings.TrimSpace(p), "{")
+		})
+	case "hermetic_tools", "pinned_executables", "python":
+		// the names are searched in the PATH and extra_path, which are checked
+		paths = slices.DeleteFunc(paths, func(p string) bool { return !strings.ContainsAny(p, `/\`) })
+	}
+	return slices.DeleteFunc(paths, func(p string) bool { return p == "" })
+}
+
+// inRoots returns whether the path is in one of the roots. Paths must be
+// absolute and cannot be templates, as their final value is unknown.
+func inRoots(roots []string, p string) bool {
+	if !filepath.IsAbs(p) || strings.Contains(p, "{{") {
+		return false
+	}
+	p = filepath.Clean(p)
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if p == root || strings.HasPrefix(p, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isZero returns whether an option value is empty (e.g. false or "")
+func isZero(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
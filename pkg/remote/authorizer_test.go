@@ -0,0 +1,146 @@
+package remote
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/inercia/go-restricted-runner/pkg/runner"
+)
+
+func TestPolicyAuthorizer(t *testing.T) {
+	a := &PolicyAuthorizer{Policies: map[string]ClientPolicy{
+		"ci": {
+			Types:     []runner.Type{runner.TypeDocker, runner.TypeLandrun},
+			Images:    []string{"alpine:*", "busybox"},
+			PathRoots: []string{"/srv/ci"},
+			Options: []string{"allow_read_folders", "allow_write_folders", "mounts", "unrestricted_filesystem",
+				"env_files", "seccomp_profile", "hermetic_tools"},
+		},
+		"admin": {
+			Types: []runner.Type{runner.TypeExec},
+		},
+		"dev": {
+			Types: []runner.Type{runner.TypeDocker, runner.TypeProot, runner.TypeNsjail, runner.TypePython,
+				runner.TypeUnshare},
+			PathRoots: []string{"/srv/dev"},
+			Options: []string{"rootfs", "binds", "system_folders", "workdir", "venv", "python", "canary_read_paths",
+				"pinned_executables", "requirements", "requirements_file"},
+		},
+	}}
+
+	tests := []struct {
+		name    string
+		client  string
+		req     RunRequest
+		allowed bool
+	}{
+		{"unknown client", "other", RunRequest{Type: runner.TypeExec}, false},
+		{"type not allowed", "ci", RunRequest{Type: runner.TypeExec}, false},
+		{"allowed type", "admin", RunRequest{Type: runner.TypeExec}, true},
+		{"image prefix", "ci", RunRequest{Type: runner.TypeDocker, Options: runner.Options{"image": "alpine:3.20"}}, true},
+		{"exact image", "ci", RunRequest{Type: runner.TypeDocker, Options: runner.Options{"image": "busybox"}}, true},
+		{"image not allowed", "ci", RunRequest{Type: runner.TypeDocker, Options: runner.Options{"image": "ubuntu"}}, false},
+		{"path in root", "ci", RunRequest{Type: runner.TypeLandrun, Options: runner.Options{
+			"allow_write_folders": []interface{}{"/srv/ci/job-1", "/srv/ci"},
+		}}, true},
+		{"path out of root", "ci", RunRequest{Type: runner.TypeLandrun, Options: runner.Options{
+			"allow_read_folders": []interface{}{"/srv/ci/../../etc"},
+		}}, false},
+		{"path with common prefix", "ci", RunRequest{Type: runner.TypeLandrun, Options: runner.Options{
+			"allow_read_folders": []interface{}{"/srv/ci-other"},
+		}}, false},
+		{"relative path", "ci", RunRequest{Type: runner.TypeLandrun, Options: runner.Options{
+			"allow_read_folders": []interface{}{"srv/ci"},
+		}}, false},
+		{"templated path", "ci", RunRequest{Type: runner.TypeLandrun, Options: runner.Options{
+			"allow_read_folders": []interface{}{"/srv/ci/{{.dir}}"},
+		}}, false},
+		{"mount in root", "ci", RunRequest{Type: runner.TypeDocker, Options: runner.Options{
+			"image": "busybox", "mounts": []interface{}{"/srv/ci/data:/data:ro"},
+		}}, true},
+		{"mount out of root", "ci", RunRequest{Type: runner.TypeDocker, Options: runner.Options{
+			"image": "busybox", "mounts": []interface{}{"/:/host"},
+		}}, false},
+		{"unconfined option", "ci", RunRequest{Type: runner.TypeLandrun, Options: runner.Options{
+			"unrestricted_filesystem": true,
+		}}, false},
+		{"env file out of root", "ci", RunRequest{Type: runner.TypeLandrun, Options: runner.Options{
+			"env_files": []interface{}{"/etc/shadow"},
+		}}, false},
+		{"env file in root", "ci", RunRequest{Type: runner.TypeLandrun, Options: runner.Options{
+			"env_files": []interface{}{"/srv/ci/.env"},
+		}}, true},
+		{"built-in seccomp profile", "ci", RunRequest{Type: runner.TypeLandrun, Options: runner.Options{
+			"seccomp_profile": "strict",
+		}}, true},
+		{"seccomp profile out of root", "ci", RunRequest{Type: runner.TypeLandrun, Options: runner.Options{
+			"seccomp_profile": "/etc/profile.json",
+		}}, false},
+		{"hermetic tools", "ci", RunRequest{Type: runner.TypeLandrun, Options: runner.Options{
+			"hermetic_tools": []interface{}{"git", "/srv/ci/bin/make"},
+		}}, true},
+		{"hermetic tool out of root", "ci", RunRequest{Type: runner.TypeLandrun, Options: runner.Options{
+			"hermetic_tools": []interface{}{"/usr/bin/sudo"},
+		}}, false},
+		{"option not allowed", "ci", RunRequest{Type: runner.TypeDocker, Options: runner.Options{
+			"image": "busybox", "device_read_bps": []interface{}{"/dev/sda:10mb"},
+		}}, false},
+		{"image without allowed images", "admin", RunRequest{Type: runner.TypeExec, Options: runner.Options{
+			"image": "busybox",
+		}}, false},
+		{"unconfined option disabled", "ci", RunRequest{Type: runner.TypeLandrun, Options: runner.Options{
+			"unrestricted_filesystem": false,
+		}}, true},
+		{"binds in root", "dev", RunRequest{Type: runner.TypeProot, Options: runner.Options{
+			"rootfs": "/srv/dev/rootfs", "binds": []interface{}{"/srv/dev/src:/src", "/srv/dev/cache"},
+		}}, true},
+		{"bind out of root", "dev", RunRequest{Type: runner.TypeProot, Options: runner.Options{
+			"binds": []interface{}{"/etc:/srv/dev/etc"},
+		}}, false},
+		{"system folder out of root", "dev", RunRequest{Type: runner.TypeNsjail, Options: runner.Options{
+			"system_folders": []interface{}{"/srv/dev/lib", "/home"},
+		}}, false},
+		{"workdir out of root", "dev", RunRequest{Type: runner.TypeUnshare, Options: runner.Options{
+			"workdir": "/root",
+		}}, false},
+		{"workdir in the container", "dev", RunRequest{Type: runner.TypeDocker, Options: runner.Options{
+			"workdir": "/app",
+		}}, true},
+		{"venv out of root", "dev", RunRequest{Type: runner.TypePython, Options: runner.Options{
+			"venv": "/opt/shared-venv",
+		}}, false},
+		{"python name", "dev", RunRequest{Type: runner.TypePython, Options: runner.Options{
+			"python": "python3", "venv": "/srv/dev/venv",
+		}}, true},
+		{"python out of root", "dev", RunRequest{Type: runner.TypePython, Options: runner.Options{
+			"python": "/usr/local/bin/python3",
+		}}, false},
+		{"canary read path out of root", "dev", RunRequest{Type: runner.TypeUnshare, Options: runner.Options{
+			"canary_read_paths": []interface{}{"/etc/shadow"},
+		}}, false},
+		{"pinned executable out of root", "dev", RunRequest{Type: runner.TypeUnshare, Options: runner.Options{
+			"pinned_executables": map[string]interface{}{"git": "abc", "/usr/bin/sudo": "def"},
+		}}, false},
+		{"pinned executable name", "dev", RunRequest{Type: runner.TypeUnshare, Options: runner.Options{
+			"pinned_executables": map[string]interface{}{"git": "abc", "/srv/dev/bin/make": "def"},
+		}}, true},
+		{"requirements", "dev", RunRequest{Type: runner.TypePython, Options: runner.Options{
+			"requirements": []interface{}{"requests"},
+		}}, false},
+		{"requirements file", "dev", RunRequest{Type: runner.TypePython, Options: runner.Options{
+			"requirements_file": "/srv/dev/requirements.txt",
+		}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := a.Authorize(Client{ID: tt.client}, &tt.req)
+			if tt.allowed && err != nil {
+				t.Errorf("expected the request to be allowed, got %v", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrUnauthorized) {
+				t.Errorf("expected ErrUnauthorized, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+	"github.com/inercia/go-restricted-runner/pkg/runner"
+)
+
+// TokenHeader is the HTTP header carrying the execution token of a request
+const TokenHeader = "X-Execution-Token"
+
+// defaultMaxRequestBytes is the default maximum size of a request body
+const defaultMaxRequestBytes = 1 << 20
+
+// RunRequest is a request to run a command, with the parameters of Runner.Run
+type RunRequest struct {
+	Type    runner.Type            `json:"type"`
+	Options runner.Options         `json:"options,omitempty"`
+	Shell   string                 `json:"shell,omitempty"`
+	Command string                 `json:"command"`
+	Env     []string               `json:"env,omitempty"`
+	Params  map[string]interface{} `json:"params,omitempty"`
+	Tmpfile bool                   `json:"tmpfile,omitempty"`
+}
+
+// RunResponse is the result of a RunRequest
+type RunResponse struct {
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ServerOptions is the options for a Server
+type ServerOptions struct {
+	// Authorizer decides what each client can run (required)
+	Authorizer Authorizer
+
+	// Tokens, when set, requires every request to carry a valid execution
+	// token in the TokenHeader header
+	Tokens *TokenVerifier
+
+	// MaxRequestBytes is the maximum size of a request (1MiB by default)
+	MaxRequestBytes int64
+}
+
+// Server is an http.Handler running the commands requested by clients with
+// the runners of the host, at POST /v1/run.
+//
+// Clients are identified by their TLS certificate, so the server should be
+// served with a configuration from ServerTLSConfig when it is exposed to
+// the network.
+type Server struct {
+	logger  runner.Logger
+	options ServerOptions
+}
+
+// NewServer creates a new Server with the provided logger.
+// If logger is nil, a default logger is created.
+func NewServer(options ServerOptions, logger runner.Logger) (*Server, error) {
+	// a nil *common.Logger is not a nil Logger
+	if cl, ok := logger.(*common.Logger); logger == nil || (ok && cl == nil) {
+		logger = common.GetLogger()
+	}
+	if options.Authorizer == nil {
+		return nil, fmt.Errorf("remote server requires an authorizer")
+	}
+	if options.MaxRequestBytes <= 0 {
+		options.MaxRequestBytes = defaultMaxRequestBytes
+	}
+	return &Server{logger: logger, options: options}, nil
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/run" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.options.MaxRequestBytes))
+	if err != nil {
+		http.Error(w, "failed to read request", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	client, status, err := s.authenticate(r, body)
+	if err != nil {
+		s.logger.Info("Remote: rejected request from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	var req RunRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.options.Authorizer.Authorize(client, &req); err != nil {
+		s.logger.Info("Remote: client %q not authorized: %v", client.ID, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	s.logger.Debug("Remote: client %q runs %q with the %s runner", client.ID, req.Command, req.Type)
+	r2, err := runner.New(req.Type, req.Options, s.logger)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to create runner: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var resp RunResponse
+	resp.Output, err = r2.Run(r.Context(), req.Shell, req.Command, req.Env, req.Params, req.Tmpfile)
+	if err != nil {
+		resp.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.logger.Debug("Remote: failed to write response: %v", err)
+	}
+}
+
+// authenticate identifies the client of a request, verifying its token when
+// tokens are required. It returns the HTTP status for the errors.
+func (s *Server) authenticate(r *http.Request, body []byte) (Client, int, error) {
+	var client Client
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		client.ID = r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	if s.options.Tokens == nil {
+		return client, 0, nil
+	}
+
+	token := r.Header.Get(TokenHeader)
+	if token == "" {
+		return client, http.StatusUnauthorized, errors.New("missing execution token")
+	}
+	claims, err := s.options.Tokens.Verify(token, body)
+	if err != nil {
+		return client, http.StatusUnauthorized, err
+	}
+
+	switch {
+	case client.ID == "":
+		client.ID = claims.ClientID
+	case claims.ClientID != "" && claims.ClientID != client.ID:
+		return client, http.StatusUnauthorized, fmt.Errorf("%w: minted for client %q", ErrTokenMismatch, claims.ClientID)
+	}
+	return client, 0, nil
+}
@@ -0,0 +1,234 @@
+package remote
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+	"github.com/inercia/go-restricted-runner/pkg/runner"
+)
+
+// testPKI writes a CA and the certificates signed by it to a directory
+type testPKI struct {
+	t    *testing.T
+	dir  string
+	ca   *x509.Certificate
+	key  *ecdsa.PrivateKey
+	next int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+
+	p := &testPKI{t: t, dir: t.TempDir(), next: 1}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(p.next),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ca, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	p.key = key
+	p.write("ca.pem", "CERTIFICATE", der)
+	return p
+}
+
+func (p *testPKI) write(name, blockType string, der []byte) string {
+	path := filepath.Join(p.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		p.t.Fatal(err)
+	}
+	return path
+}
+
+// issue creates a certificate for the common name, returning its files
+func (p *testPKI) issue(cn string, usage x509.ExtKeyUsage) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	p.next++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(p.next),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.ca, &key.PublicKey, p.key)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return p.write(cn+".pem", "CERTIFICATE", der), p.write(cn+"-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+// startTestServer serves a Server with mutual TLS, returning its URL and the CA file
+func startTestServer(t *testing.T, pki *testPKI, options ServerOptions) string {
+	t.Helper()
+
+	srv, err := NewServer(options, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := pki.issue("server", x509.ExtKeyUsageServerAuth)
+	tlsConfig, err := ServerTLSConfig(certFile, keyFile, filepath.Join(pki.dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewUnstartedServer(srv)
+	ts.TLS = tlsConfig
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// newTestClient returns an HTTP client authenticated with a certificate for cn
+func newTestClient(t *testing.T, pki *testPKI, cn string) *http.Client {
+	t.Helper()
+
+	certFile, keyFile := pki.issue(cn, x509.ExtKeyUsageClientAuth)
+	tlsConfig, err := ClientTLSConfig(certFile, keyFile, filepath.Join(pki.dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+}
+
+func postRun(t *testing.T, client *http.Client, url string, body []byte, token string) (*http.Response, RunResponse) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, url+"/v1/run", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set(TokenHeader, token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var result RunResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, result
+}
+
+func TestServer_MutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	url := startTestServer(t, pki, ServerOptions{
+		Authorizer: &PolicyAuthorizer{Policies: map[string]ClientPolicy{
+			"admin": {Types: []runner.Type{runner.TypeExec}},
+		}},
+	})
+	body, _ := json.Marshal(RunRequest{Type: runner.TypeExec, Command: "echo hello"})
+
+	resp, result := postRun(t, newTestClient(t, pki, "admin"), url, body, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %s", resp.Status)
+	}
+	if result.Output != "hello" || result.Error != "" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	// a client without a policy
+	resp, _ = postRun(t, newTestClient(t, pki, "guest"), url, body, "")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403, got %s", resp.Status)
+	}
+
+	// a client without a certificate cannot connect
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs: func() *x509.CertPool { p := x509.NewCertPool(); p.AddCert(pki.ca); return p }(),
+	}}}
+	req, _ := http.NewRequest(http.MethodPost, url+"/v1/run", bytes.NewReader(body))
+	if resp, err := anonymous.Do(req); err == nil {
+		_ = resp.Body.Close()
+		t.Error("expected the TLS handshake to fail without a client certificate")
+	}
+}
+
+func TestServer_Tokens(t *testing.T) {
+	pki := newTestPKI(t)
+	m, v := newTestTokens(t, time.Minute)
+	url := startTestServer(t, pki, ServerOptions{
+		Authorizer: &PolicyAuthorizer{Policies: map[string]ClientPolicy{
+			"admin": {Types: []runner.Type{runner.TypeExec}},
+		}},
+		Tokens: v,
+	})
+	client := newTestClient(t, pki, "admin")
+	body, _ := json.Marshal(RunRequest{Type: runner.TypeExec, Command: "echo hello"})
+
+	if resp, _ := postRun(t, client, url, body, ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %s", resp.Status)
+	}
+
+	token, err := m.Mint("admin", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, result := postRun(t, client, url, body, token); resp.StatusCode != http.StatusOK || result.Output != "hello" {
+		t.Errorf("expected the command to run, got %s %+v", resp.Status, result)
+	}
+	if resp, _ := postRun(t, client, url, body, token); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a replayed token, got %s", resp.Status)
+	}
+
+	// tokens are bound to the client they were minted for
+	token, err = m.Mint("someone-else", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := postRun(t, client, url, body, token); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a token of another client, got %s", resp.Status)
+	}
+}
+
+func TestNewServer_nilCommonLogger(t *testing.T) {
+	s, err := NewServer(ServerOptions{Authorizer: &PolicyAuthorizer{}}, (*common.Logger)(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.logger.Info("the default logger is used")
+}
@@ -0,0 +1,214 @@
+// Package remote provides the building blocks for executing commands with a
+// runner on a remote host: single-use execution tokens, and the server
+// exposing the runners of the host.
+package remote
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidToken is returned for tokens that are malformed or whose
+	// signature does not match
+	ErrInvalidToken = errors.New("invalid execution token")
+
+	// ErrTokenExpired is returned for tokens used after their expiry
+	ErrTokenExpired = errors.New("execution token expired")
+
+	// ErrTokenReplayed is returned for tokens that have already been used
+	ErrTokenReplayed = errors.New("execution token already used")
+
+	// ErrTokenMismatch is returned for tokens minted for a different request
+	ErrTokenMismatch = errors.New("execution token does not match the request")
+)
+
+// tokenVersion is the version of the format of the tokens
+const tokenVersion = "v1"
+
+// TokenClaims is the content of an execution token
+type TokenClaims struct {
+	// ClientID identifies the client the token was minted for
+	ClientID string `json:"client_id,omitempty"`
+
+	// Digest is the SHA-256 of the request the token authorizes (see RequestDigest)
+	Digest string `json:"digest"`
+
+	// Nonce makes the token unique, so it can only be used once
+	Nonce string `json:"nonce"`
+
+	// IssuedAt and ExpiresAt bound the validity of the token
+	IssuedAt  time.Time `json:"iat"`
+	ExpiresAt time.Time `json:"exp"`
+}
+
+// RequestDigest returns the digest identifying a request body, which binds
+// a token to the request it was minted for
+func RequestDigest(request []byte) string {
+	sum := sha256.Sum256(request)
+	return hex.EncodeToString(sum[:])
+}
+
+// TokenMinter mints signed execution tokens
+type TokenMinter struct {
+	key []byte
+	ttl time.Duration
+	now func() time.Time
+}
+
+// NewTokenMinter creates a minter signing tokens with key (which must be
+// shared with the verifier) that are valid for ttl
+func NewTokenMinter(key []byte, ttl time.Duration) (*TokenMinter, error) {
+	if len(key) < 32 {
+		return nil, fmt.Errorf("token key must be at least 32 bytes long")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("token TTL must be positive")
+	}
+	return &TokenMinter{key: key, ttl: ttl, now: time.Now}, nil
+}
+
+// Mint returns a token authorizing one execution of the given request by the client
+func (m *TokenMinter) Mint(clientID string, request []byte) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	now := m.now()
+	claims := TokenClaims{
+		ClientID:  clientID,
+		Digest:    RequestDigest(request),
+		Nonce:     hex.EncodeToString(nonce),
+		IssuedAt:  now.UTC(),
+		ExpiresAt: now.Add(m.ttl).UTC(),
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return tokenVersion + "." + encoded + "." + sign(m.key, encoded), nil
+}
+
+// sign returns the signature of the encoded claims
+func sign(key []byte, encoded string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(tokenVersion + "." + encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NonceStore records the nonces of the tokens already used
+type NonceStore interface {
+	// Use records the nonce, valid until expiresAt, and returns false if it
+	// had already been used
+	Use(nonce string, expiresAt time.Time) (bool, error)
+}
+
+// MemoryNonceStore is a NonceStore keeping the nonces in memory until their
+// tokens expire. It is only suitable for a single verifying process.
+type MemoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	now    func() time.Time
+}
+
+// NewMemoryNonceStore creates an empty MemoryNonceStore
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: map[string]time.Time{}, now: time.Now}
+}
+
+// Use implements NonceStore
+func (s *MemoryNonceStore) Use(nonce string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// expired tokens are rejected anyway, so their nonces can be forgotten
+	now := s.now()
+	for n, exp := range s.nonces {
+		if now.After(exp) {
+			delete(s.nonces, n)
+		}
+	}
+
+	if _, used := s.nonces[nonce]; used {
+		return false, nil
+	}
+	s.nonces[nonce] = expiresAt
+	return true, nil
+}
+
+// TokenVerifier verifies execution tokens, accepting each one only once
+type TokenVerifier struct {
+	key    []byte
+	maxTTL time.Duration
+	nonces NonceStore
+	now    func() time.Time
+}
+
+// NewTokenVerifier creates a verifier for the tokens signed with key.
+// Tokens valid for longer than maxTTL are rejected, which bounds the time
+// their nonces must be remembered. If nonces is nil, a MemoryNonceStore is used.
+func NewTokenVerifier(key []byte, maxTTL time.Duration, nonces NonceStore) (*TokenVerifier, error) {
+	if len(key) < 32 {
+		return nil, fmt.Errorf("token key must be at least 32 bytes long")
+	}
+	if maxTTL <= 0 {
+		return nil, fmt.Errorf("maximum token TTL must be positive")
+	}
+	if nonces == nil {
+		nonces = NewMemoryNonceStore()
+	}
+	return &TokenVerifier{key: key, maxTTL: maxTTL, nonces: nonces, now: time.Now}, nil
+}
+
+// Verify checks that the token is valid for the request and has not been
+// used before, and returns its claims
+func (v *TokenVerifier) Verify(token string, request []byte) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenVersion {
+		return nil, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(sign(v.key, parts[1]))) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.Nonce == "" || claims.ExpiresAt.Sub(claims.IssuedAt) > v.maxTTL {
+		return nil, ErrInvalidToken
+	}
+
+	if v.now().After(claims.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	if !hmac.Equal([]byte(claims.Digest), []byte(RequestDigest(request))) {
+		return nil, ErrTokenMismatch
+	}
+
+	// the nonce is only consumed by valid tokens, so invalid requests cannot burn it
+	fresh, err := v.nonces.Use(claims.Nonce, claims.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record token nonce: %w", err)
+	}
+	if !fresh {
+		return nil, ErrTokenReplayed
+	}
+	return &claims, nil
+}
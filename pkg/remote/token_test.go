@@ -0,0 +1,138 @@
+package remote
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func newTestTokens(t *testing.T, ttl time.Duration) (*TokenMinter, *TokenVerifier) {
+	t.Helper()
+
+	m, err := NewTokenMinter(testKey, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewTokenVerifier(testKey, time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, v
+}
+
+func TestToken_SingleUse(t *testing.T) {
+	m, v := newTestTokens(t, time.Minute)
+	request := []byte(`{"command":"ls"}`)
+
+	token, err := m.Mint("client-a", request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := v.Verify(token, request)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if claims.ClientID != "client-a" {
+		t.Errorf("expected client-a, got %q", claims.ClientID)
+	}
+
+	if _, err := v.Verify(token, request); !errors.Is(err, ErrTokenReplayed) {
+		t.Errorf("expected ErrTokenReplayed, got %v", err)
+	}
+}
+
+func TestToken_Mismatch(t *testing.T) {
+	m, v := newTestTokens(t, time.Minute)
+
+	token, err := m.Mint("", []byte(`{"command":"ls"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(token, []byte(`{"command":"rm -rf /"}`)); !errors.Is(err, ErrTokenMismatch) {
+		t.Errorf("expected ErrTokenMismatch, got %v", err)
+	}
+
+	// a rejected token can still be used for its own request
+	if _, err := v.Verify(token, []byte(`{"command":"ls"}`)); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+}
+
+func TestToken_Expired(t *testing.T) {
+	m, v := newTestTokens(t, time.Minute)
+	v.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+
+	token, err := m.Mint("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(token, nil); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("expected ErrTokenExpired, got %v", err)
+	}
+}
+
+func TestToken_Invalid(t *testing.T) {
+	m, v := newTestTokens(t, time.Minute)
+	token, err := m.Mint("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := NewTokenMinter([]byte("another key of at least 32 bytes"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := other.Mint("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+	tests := map[string]string{
+		"empty":          "",
+		"wrong key":      forged,
+		"bad version":    "v0." + parts[1] + "." + parts[2],
+		"tampered claim": parts[0] + "." + parts[1] + "x." + parts[2],
+	}
+	for name, token := range tests {
+		if _, err := v.Verify(token, nil); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+
+	// tokens valid for longer than the verifier accepts are rejected
+	long, err := NewTokenMinter(testKey, 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err = long.Mint("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(token, nil); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for a long lived token, got %v", err)
+	}
+}
+
+func TestMemoryNonceStore_ForgetsExpired(t *testing.T) {
+	s := NewMemoryNonceStore()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	if ok, _ := s.Use("a", now.Add(time.Second)); !ok {
+		t.Fatal("expected a new nonce to be accepted")
+	}
+	if ok, _ := s.Use("a", now.Add(time.Second)); ok {
+		t.Fatal("expected a used nonce to be rejected")
+	}
+
+	now = now.Add(time.Minute)
+	_, _ = s.Use("b", now.Add(time.Second))
+	if _, ok := s.nonces["a"]; ok {
+		t.Error("expected the expired nonce to be forgotten")
+	}
+}
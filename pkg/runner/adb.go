@@ -0,0 +1,336 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// ADB implements the Runner interface by executing commands on a connected
+// Android device or emulator through `adb shell`.
+//
+// Commands never run on the host: the command string is sent to the device
+// shell, optionally wrapped with `run-as` (to execute as a debuggable
+// application's user) and `runcon` (to execute in a specific SELinux context).
+type ADB struct {
+	logger  Logger
+	options ADBOptions
+}
+
+// ADBOptions is the options for the ADB runner
+type ADBOptions struct {
+	// ADBPath is the adb executable to use (defaults to "adb" in PATH)
+	ADBPath string `json:"adb_path"`
+
+	// Serial selects the target device when several are connected (adb -s)
+	Serial string `json:"serial"`
+
+	// RunAs is the package name of a debuggable application whose user the command runs as
+	RunAs string `json:"run_as"`
+
+	// SELinuxContext is the SELinux context the command is started in (through runcon)
+	SELinuxContext string `json:"selinux_context"`
+
+	// WorkDir is the working directory on the device
+	WorkDir string `json:"workdir"`
+
+	// Shell is the shell used on the device (defaults to "sh")
+	Shell string `json:"shell"`
+
+	// Clock and locale settings
+	DeterminismOptions
+
+	// Variables of the commands read from .env files
+	EnvFileOptions
+
+	// Experimental features
+	ExperimentalOptions
+
+	// Timeout of the commands
+	TimeoutOptions
+}
+
+// NewADBOptions creates a new ADBOptions from Options
+func NewADBOptions(options Options) (ADBOptions, error) {
+	var opts ADBOptions
+	jsonStr, err := options.ToJSON()
+	if err != nil {
+		return ADBOptions{}, err
+	}
+	err = json.Unmarshal([]byte(jsonStr), &opts)
+	return opts, err
+}
+
+// NewADB creates a new ADB runner with the provided logger.
+// If logger is nil, a default logger is created.
+func NewADB(options Options, logger Logger) (*ADB, error) {
+	logger = defaultLogger(logger)
+
+	adbOpts, err := NewADBOptions(options)
+	if err != nil {
+		logger.Debug("Failed to parse adb options: %v", err)
+		return nil, fmt.Errorf("failed to parse adb options: %w", err)
+	}
+	if err := adbOpts.validateTimeouts(); err != nil {
+		return nil, fmt.Errorf("invalid adb options: %w", err)
+	}
+	if err := adbOpts.loadEnvFiles(); err != nil {
+		return nil, fmt.Errorf("invalid adb options: %w", err)
+	}
+
+	return &ADB{
+		logger:  logger,
+		options: adbOpts,
+	}, nil
+}
+
+// adbPath returns the adb executable to use
+func (r *ADB) adbPath() string {
+	if r.options.ADBPath != "" {
+		return r.options.ADBPath
+	}
+	return "adb"
+}
+
+// adbArgs returns the adb arguments for running remoteCmd with `adb shell`.
+// The -T flag disables PTY allocation so stdin/stdout are not mangled and the
+// remote exit status is propagated.
+func (r *ADB) adbArgs(remoteCmd string) []string {
+	var args []string
+	if r.options.Serial != "" {
+		args = append(args, "-s", r.options.Serial)
+	}
+	return append(args, "shell", "-T", remoteCmd)
+}
+
+// remoteCommand builds the command line executed by the device shell.
+//
+// The script (environment exports, working directory change and the command
+// itself) is run by the device shell, wrapped with runcon and run-as when
+// those options are set.
+func (r *ADB) remoteCommand(shell string, command string, env []string, params map[string]interface{}) (string, error) {
+	var script strings.Builder
+
+	for _, e := range env {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		// the name is written unquoted in the script
+		if !envNamePattern.MatchString(parts[0]) {
+			return "", fmt.Errorf("invalid environment variable name %q", parts[0])
+		}
+		fmt.Fprintf(&script, "export %s=%s; ", parts[0], shellQuote(parts[1]))
+	}
+
+	if r.options.WorkDir != "" {
+		workDir, err := common.ProcessTemplate(r.options.WorkDir, params)
+		if err != nil {
+			return "", fmt.Errorf("failed to process workdir template: %w", err)
+		}
+		fmt.Fprintf(&script, "cd %s && ", shellQuote(workDir))
+	}
+	script.WriteString(strings.TrimSpace(command))
+
+	deviceShell := shell
+	if deviceShell == "" {
+		deviceShell = r.options.Shell
+	}
+	if deviceShell == "" {
+		deviceShell = "sh"
+	}
+
+	parts := []string{deviceShell, "-c", shellQuote(script.String())}
+	if r.options.SELinuxContext != "" {
+		parts = append([]string{"runcon", shellQuote(r.options.SELinuxContext)}, parts...)
+	}
+	if r.options.RunAs != "" {
+		parts = append([]string{"run-as", shellQuote(r.options.RunAs)}, parts...)
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+// Run executes a command on the device and returns the output.
+// It implements the Runner interface.
+//
+// note: tmpfile is ignored for adb because the command is always sent inline
+func (r *ADB) Run(ctx context.Context, shell string, command string,
+	env []string, params map[string]interface{}, tmpfile bool,
+) (string, error) {
+	return r.options.runWithTimeout(ctx, func(ctx context.Context) (string, error) {
+		return r.run(ctx, shell, command, env, params, tmpfile)
+	})
+}
+
+// run is Run, with the context bounded by the timeout options
+func (r *ADB) run(ctx context.Context, shell string, command string,
+	env []string, params map[string]interface{}, tmpfile bool,
+) (string, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+
+	// Check if context is done
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	default:
+		// Continue execution
+	}
+
+	remoteCmd, err := r.remoteCommand(shell, command, env, params)
+	if err != nil {
+		return "", err
+	}
+
+	execCmd := commandContext(ctx, r.adbPath(), r.adbArgs(remoteCmd)...)
+	logger.Debug("Created command: %s", execCmd.String())
+
+	// Capture output
+	var stdout, stderr bytes.Buffer
+	execCmd.Stdout, execCmd.Stderr = captureRunOutput(ctx, &stdout, &stderr)
+
+	// Run the command
+	logger.Debug("Executing command on device")
+
+	if err := execCmd.Run(); err != nil {
+		// If there's error output, include it in the error
+		if stderr.Len() > 0 {
+			errMsg := strings.TrimSpace(stderr.String())
+			logger.Debug("Command failed with stderr: %s", errMsg)
+			return "", newCommandError(errMsg, err)
+		}
+		logger.Debug("Command failed with error: %v", err)
+		return "", err
+	}
+
+	// Get the output
+	outputStr := strings.TrimSpace(stdout.String())
+
+	logger.Debug("Command executed successfully, output length: %d bytes", len(outputStr))
+	if stderr.Len() > 0 {
+		logger.Debug("Command generated stderr (but no error): %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return outputStr, nil
+}
+
+// RunEx executes a command on the device like Run, returning its exit code and
+// both output streams. It implements the Runner interface.
+func (r *ADB) RunEx(ctx context.Context, req RunRequest) (*RunResult, error) {
+	return runEx(ctx, r, req)
+}
+
+// RunWithPipes executes a command on the device with access to stdin/stdout/stderr pipes.
+// It implements the Runner interface for interactive process communication.
+//
+// The command and its arguments are quoted and executed by the device shell, so
+// the same run-as and SELinux context restrictions as Run() apply.
+func (r *ADB) RunWithPipes(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (
+	stdin io.WriteCloser,
+	stdout io.ReadCloser,
+	stderr io.ReadCloser,
+	wait func() error,
+	err error,
+) {
+	e, err := r.start(ctx, cmd, args, env, params)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return e.Stdin, e.Stdout, e.Stderr, e.Wait, nil
+}
+
+// start executes a command on the device and returns an execution handle for it.
+// It is used by RunWithPipes and Start.
+func (r *ADB) start(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	return r.options.startWithTimeout(ctx, func(ctx context.Context) (*Execution, error) {
+		return r.startCommand(ctx, cmd, args, env, params)
+	})
+}
+
+// startCommand is start, with the context bounded by the timeout options
+func (r *ADB) startCommand(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+
+	// Check if context is already done
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+		// Continue execution
+	}
+
+	logger.Debug("RunWithPipes: executing command on device: %s with args: %v", cmd, args)
+
+	if inputsDir(params) != "" {
+		return nil, fmt.Errorf("input files cannot be staged on the device: %w", ErrNotSupported)
+	}
+	if len(writableDirs(params)) > 0 {
+		return nil, fmt.Errorf("host directories cannot be shared with the device: %w", ErrNotSupported)
+	}
+	if err := checkNoExtraFiles(ctx, "adb"); err != nil {
+		return nil, err
+	}
+	if loopbackNetworkFrom(ctx) {
+		return nil, fmt.Errorf("the network of the device cannot be isolated: %w", ErrNotSupported)
+	}
+
+	// Quote the command and its arguments so the device shell does not re-split them
+	quoted := []string{shellQuote(cmd)}
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+
+	remoteCmd, err := r.remoteCommand("", "exec "+strings.Join(quoted, " "), env, params)
+	if err != nil {
+		return nil, err
+	}
+
+	execCmd := commandContext(ctx, r.adbPath(), r.adbArgs(remoteCmd)...)
+
+	e, err := startProcess(logger, execCmd, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stopping the local adb client would not suspend the command on the device
+	e.backend = nil
+	return e, nil
+}
+
+// CheckImplicitRequirements checks if the runner meets its implicit requirements.
+// ADB runner requires the adb executable and a reachable device.
+func (r *ADB) CheckImplicitRequirements() error {
+	if !common.CheckExecutableExists(r.adbPath()) {
+		return fmt.Errorf("adb executable not found in PATH")
+	}
+
+	// Check that the target device is connected and online
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var args []string
+	if r.options.Serial != "" {
+		args = append(args, "-s", r.options.Serial)
+	}
+	args = append(args, "get-state")
+
+	output, err := commandContext(ctx, r.adbPath(), args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("no android device available: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	if state := strings.TrimSpace(string(output)); state != "device" {
+		return fmt.Errorf("android device is not ready (state: %s)", state)
+	}
+
+	return nil
+}
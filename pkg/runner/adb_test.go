@@ -0,0 +1,183 @@
+package runner
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// checkADBDevice verifies that adb is installed and a device is connected
+func checkADBDevice() bool {
+	if !common.CheckExecutableExists("adb") {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "adb", "get-state").Output()
+	return err == nil && string(output) == "device\n"
+}
+
+func TestNewADBOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		want    ADBOptions
+		wantErr bool
+	}{
+		{
+			name:    "empty options",
+			options: Options{},
+			want:    ADBOptions{},
+		},
+		{
+			name: "all options",
+			options: Options{
+				"adb_path":        "/opt/android/adb",
+				"serial":          "emulator-5554",
+				"run_as":          "com.example.app",
+				"selinux_context": "u:r:untrusted_app:s0",
+				"workdir":         "/data/local/tmp",
+				"shell":           "/system/bin/sh",
+			},
+			want: ADBOptions{
+				ADBPath:        "/opt/android/adb",
+				Serial:         "emulator-5554",
+				RunAs:          "com.example.app",
+				SELinuxContext: "u:r:untrusted_app:s0",
+				WorkDir:        "/data/local/tmp",
+				Shell:          "/system/bin/sh",
+			},
+		},
+		{
+			name:    "wrong type",
+			options: Options{"serial": 123},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewADBOptions(tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewADBOptions() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewADBOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestADB_remoteCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		shell   string
+		command string
+		env     []string
+		params  map[string]interface{}
+		want    string
+	}{
+		{
+			name:    "plain command",
+			options: Options{},
+			command: "ls /sdcard",
+			want:    `sh -c 'ls /sdcard'`,
+		},
+		{
+			name:    "custom shell and env",
+			options: Options{},
+			shell:   "/system/bin/sh",
+			command: "echo $FOO",
+			env:     []string{"FOO=hello world"},
+			want:    `/system/bin/sh -c 'export FOO='"'"'hello world'"'"'; echo $FOO'`,
+		},
+		{
+			name:    "run-as with selinux context",
+			options: Options{"run_as": "com.example.app", "selinux_context": "u:r:untrusted_app:s0"},
+			command: "id",
+			want:    `run-as com.example.app runcon u:r:untrusted_app:s0 sh -c id`,
+		},
+		{
+			name:    "templated workdir",
+			options: Options{"workdir": "/data/local/tmp/{{.job}}"},
+			command: "pwd",
+			params:  map[string]interface{}{"job": "j1"},
+			want:    `sh -c 'cd /data/local/tmp/j1 && pwd'`,
+		},
+	}
+
+	logger, _ := common.NewLogger("test-adb: ", "", common.LogLevelInfo, false)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewADB(tt.options, logger)
+			if err != nil {
+				t.Fatalf("Failed to create adb runner: %v", err)
+			}
+
+			got, err := r.remoteCommand(tt.shell, tt.command, tt.env, tt.params)
+			if err != nil {
+				t.Fatalf("remoteCommand() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("remoteCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestADB_remoteCommandInvalidEnv(t *testing.T) {
+	logger, _ := common.NewLogger("test-adb: ", "", common.LogLevelInfo, false)
+	r, err := NewADB(Options{}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create adb runner: %v", err)
+	}
+	for _, name := range []string{"FOO;id", "1FOO", "FOO BAR", "$(id)"} {
+		if _, err := r.remoteCommand("", "true", []string{name + "=x"}, nil); err == nil {
+			t.Errorf("remoteCommand() should fail with the variable name %q", name)
+		}
+	}
+}
+
+func TestADB_adbArgs(t *testing.T) {
+	logger, _ := common.NewLogger("test-adb: ", "", common.LogLevelInfo, false)
+
+	r, err := NewADB(Options{"serial": "emulator-5554"}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create adb runner: %v", err)
+	}
+
+	want := []string{"-s", "emulator-5554", "shell", "-T", "id"}
+	if got := r.adbArgs("id"); !reflect.DeepEqual(got, want) {
+		t.Errorf("adbArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestADB_Run(t *testing.T) {
+	if !checkADBDevice() {
+		t.Skip("adb not installed or no device connected, skipping test")
+	}
+
+	logger, _ := common.NewLogger("test-adb: ", "", common.LogLevelInfo, false)
+
+	r, err := NewADB(Options{}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create adb runner: %v", err)
+	}
+
+	output, err := r.Run(context.Background(), "", "echo hello from device", nil, nil, false)
+	if err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+	if output != "hello from device" {
+		t.Errorf("Expected output %q, got %q", "hello from device", output)
+	}
+}
@@ -0,0 +1,186 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// AgentOptions controls whether the command can reach the credential agents
+// of the host: ssh-agent, gpg-agent and the keyrings (the kernel keyrings
+// and the desktop keyring daemons). All of them are blocked by default, as
+// they let the command use (or exfiltrate) the credentials of the user.
+//
+// Blocking removes the variables pointing to the agents from the environment
+// of the command and, in the runners that can deny paths, the agent sockets.
+type AgentOptions struct {
+	// AllowSSHAgent keeps SSH_AUTH_SOCK and the ssh-agent socket
+	AllowSSHAgent bool `json:"allow_ssh_agent"`
+
+	// AllowGPGAgent keeps GPG_AGENT_INFO and the gpg-agent sockets
+	AllowGPGAgent bool `json:"allow_gpg_agent"`
+
+	// AllowKeyring keeps access to the kernel keyrings and the keyring daemons
+	AllowKeyring bool `json:"allow_keyring"`
+}
+
+// sshAgentEnvVars, gpgAgentEnvVars and keyringEnvVars are the variables
+// pointing to each agent
+var (
+	sshAgentEnvVars = []string{"SSH_AUTH_SOCK", "SSH_AGENT_PID"}
+	gpgAgentEnvVars = []string{"GPG_AGENT_INFO"}
+	keyringEnvVars  = []string{"GNOME_KEYRING_CONTROL", "GNOME_KEYRING_PID"}
+)
+
+// blockedAgentEnvVars returns the variables of the blocked agents
+func (o AgentOptions) blockedAgentEnvVars() []string {
+	var vars []string
+	if !o.AllowSSHAgent {
+		vars = append(vars, sshAgentEnvVars...)
+	}
+	if !o.AllowGPGAgent {
+		vars = append(vars, gpgAgentEnvVars...)
+	}
+	if !o.AllowKeyring {
+		vars = append(vars, keyringEnvVars...)
+	}
+	return vars
+}
+
+// scrubAgentEnv returns env without the variables of the blocked agents
+func (o AgentOptions) scrubAgentEnv(env []string) []string {
+	blocked := o.blockedAgentEnvVars()
+	if len(blocked) == 0 {
+		return env
+	}
+
+	var res []string
+	for _, e := range env {
+		name, _, _ := strings.Cut(e, "=")
+		if !contains(blocked, name) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// agentEnv returns the environment of a local command without the variables
+// of the blocked agents. A nil environment, inherited by the command, is
+// taken from the current process.
+func (o AgentOptions) agentEnv(cmdEnv []string) []string {
+	if cmdEnv == nil {
+		cmdEnv = os.Environ()
+	}
+	return o.scrubAgentEnv(cmdEnv)
+}
+
+// BlockedAgentPaths returns the existing sockets (and socket folders) of the
+// blocked agents, denied by the runners (and profile templates) that can
+// deny access to paths
+func (o AgentOptions) BlockedAgentPaths() []string {
+	var paths []string
+	if !o.AllowSSHAgent {
+		if sock := os.Getenv("SSH_AUTH_SOCK"); filepath.IsAbs(sock) {
+			paths = append(paths, sock)
+		}
+	}
+	if !o.AllowGPGAgent {
+		for _, dir := range gpgSocketDirs() {
+			sockets, _ := filepath.Glob(filepath.Join(dir, "S.gpg-agent*"))
+			paths = append(paths, sockets...)
+		}
+	}
+	if !o.AllowKeyring {
+		paths = append(paths, keyringPaths()...)
+	}
+
+	return existingPaths(paths)
+}
+
+// checkAgentMount returns an error when mounting the source path would
+// expose the socket of a blocked agent
+func (o AgentOptions) checkAgentMount(source string) error {
+	if path := exposedPath(source, o.BlockedAgentPaths()); path != "" {
+		return fmt.Errorf("mount %s exposes the agent socket %s (see allow_ssh_agent, allow_gpg_agent and allow_keyring)", source, path)
+	}
+	return nil
+}
+
+// existingPaths returns the paths that exist, without duplicates
+func existingPaths(paths []string) []string {
+	var existing []string
+	for _, path := range paths {
+		if _, err := os.Lstat(path); err == nil && !contains(existing, path) {
+			existing = append(existing, path)
+		}
+	}
+	return existing
+}
+
+// exposedPath returns the blocked path that a mount of the source path would
+// expose, or "" if none
+func exposedPath(source string, blocked []string) string {
+	if !filepath.IsAbs(source) {
+		// named volumes
+		return ""
+	}
+	source = filepath.Clean(source)
+	for _, path := range blocked {
+		if isSubPath(source, path) || isSubPath(path, source) {
+			return path
+		}
+	}
+	return ""
+}
+
+// isSubPath returns whether path is parent or inside parent
+func isSubPath(parent string, path string) bool {
+	rel, err := filepath.Rel(parent, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// gpgSocketDirs returns the folders where gpg-agent creates its sockets
+func gpgSocketDirs() []string {
+	var dirs []string
+	if runtimeDir := userRuntimeDir(); runtimeDir != "" {
+		dirs = append(dirs, filepath.Join(runtimeDir, "gnupg"))
+	}
+	if home := os.Getenv("GNUPGHOME"); home != "" {
+		dirs = append(dirs, home)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".gnupg"))
+	}
+	return dirs
+}
+
+// keyringPaths returns the sockets and folders of the keyring daemons
+func keyringPaths() []string {
+	var paths []string
+	if control := os.Getenv("GNOME_KEYRING_CONTROL"); filepath.IsAbs(control) {
+		paths = append(paths, control)
+	}
+	if runtimeDir := userRuntimeDir(); runtimeDir != "" {
+		paths = append(paths, filepath.Join(runtimeDir, "keyring"))
+	}
+	if runtime.GOOS == "darwin" {
+		if home, err := os.UserHomeDir(); err == nil {
+			paths = append(paths, filepath.Join(home, "Library", "Keychains"))
+		}
+	}
+	return paths
+}
+
+// userRuntimeDir returns the runtime folder of the current user
+// (XDG_RUNTIME_DIR, or /run/user/<uid> on Linux)
+func userRuntimeDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir
+	}
+	if runtime.GOOS == "linux" {
+		return filepath.Join("/run/user", strconv.Itoa(os.Getuid()))
+	}
+	return ""
+}
@@ -0,0 +1,154 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestAgentOptions_scrubAgentEnv(t *testing.T) {
+	env := []string{
+		"PATH=/usr/bin",
+		"SSH_AUTH_SOCK=/tmp/ssh-agent.sock",
+		"SSH_AGENT_PID=42",
+		"GPG_AGENT_INFO=/run/user/1000/gnupg/S.gpg-agent:0:1",
+		"GNOME_KEYRING_CONTROL=/run/user/1000/keyring",
+	}
+
+	got := AgentOptions{}.scrubAgentEnv(env)
+	if !reflect.DeepEqual(got, []string{"PATH=/usr/bin"}) {
+		t.Errorf("expected all the agents to be blocked, got %v", got)
+	}
+
+	got = AgentOptions{AllowSSHAgent: true}.scrubAgentEnv(env)
+	expected := []string{"PATH=/usr/bin", "SSH_AUTH_SOCK=/tmp/ssh-agent.sock", "SSH_AGENT_PID=42"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the ssh-agent to be kept, got %v", got)
+	}
+
+	all := AgentOptions{AllowSSHAgent: true, AllowGPGAgent: true, AllowKeyring: true}
+	if got := all.scrubAgentEnv(env); !reflect.DeepEqual(got, env) {
+		t.Errorf("expected the environment to be unchanged, got %v", got)
+	}
+}
+
+func TestAgentOptions_agentEnv(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "/tmp/ssh-agent.sock")
+
+	for _, e := range (AgentOptions{}).agentEnv(nil) {
+		if strings.HasPrefix(e, "SSH_AUTH_SOCK=") {
+			t.Errorf("expected SSH_AUTH_SOCK to be removed from the inherited environment")
+		}
+	}
+	if !contains(AgentOptions{AllowSSHAgent: true}.agentEnv(nil), "SSH_AUTH_SOCK=/tmp/ssh-agent.sock") {
+		t.Errorf("expected SSH_AUTH_SOCK to be inherited")
+	}
+}
+
+// setupTestAgents creates fake ssh-agent and gpg-agent sockets, returning their paths
+func setupTestAgents(t *testing.T) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	sshSock := filepath.Join(dir, "ssh-agent.sock")
+	gnupgHome := filepath.Join(dir, "gnupg")
+	if err := os.Mkdir(gnupgHome, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	gpgSock := filepath.Join(gnupgHome, "S.gpg-agent")
+	for _, path := range []string{sshSock, gpgSock} {
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", sshSock)
+	t.Setenv("GNUPGHOME", gnupgHome)
+	t.Setenv("XDG_RUNTIME_DIR", filepath.Join(dir, "runtime"))
+	return sshSock, gpgSock
+}
+
+func TestAgentOptions_BlockedAgentPaths(t *testing.T) {
+	sshSock, gpgSock := setupTestAgents(t)
+
+	paths := AgentOptions{AllowKeyring: true}.BlockedAgentPaths()
+	if !reflect.DeepEqual(paths, []string{sshSock, gpgSock}) {
+		t.Errorf("unexpected blocked paths: %v", paths)
+	}
+
+	paths = AgentOptions{AllowSSHAgent: true, AllowKeyring: true}.BlockedAgentPaths()
+	if !reflect.DeepEqual(paths, []string{gpgSock}) {
+		t.Errorf("unexpected blocked paths: %v", paths)
+	}
+}
+
+func TestAgentOptions_checkAgentMount(t *testing.T) {
+	sshSock, _ := setupTestAgents(t)
+	o := AgentOptions{}
+
+	for _, source := range []string{sshSock, filepath.Dir(sshSock)} {
+		if err := o.checkAgentMount(source); err == nil {
+			t.Errorf("expected mounting %s to be rejected", source)
+		}
+	}
+	if err := o.checkAgentMount(t.TempDir()); err != nil {
+		t.Errorf("expected an unrelated mount to be accepted, got %v", err)
+	}
+	if err := o.checkAgentMount("cache-volume"); err != nil {
+		t.Errorf("expected a named volume to be accepted, got %v", err)
+	}
+	if err := (AgentOptions{AllowSSHAgent: true, AllowGPGAgent: true}).checkAgentMount(sshSock); err != nil {
+		t.Errorf("expected an allowed agent to be mountable, got %v", err)
+	}
+}
+
+func TestGuestPaths(t *testing.T) {
+	sshSock, _ := setupTestAgents(t)
+	paths := AgentOptions{AllowGPGAgent: true, AllowKeyring: true}.BlockedAgentPaths()
+
+	if got := guestPaths(paths, "/", nil); !reflect.DeepEqual(got, []string{sshSock}) {
+		t.Errorf("expected the socket to be hidden in the host root, got %v", got)
+	}
+	if got := guestPaths(paths, "/srv/rootfs", nil); len(got) != 0 {
+		t.Errorf("expected nothing to hide in a separate root, got %v", got)
+	}
+	got := guestPaths(paths, "/srv/rootfs", []string{filepath.Dir(sshSock) + ":/agents"})
+	if !reflect.DeepEqual(got, []string{"/agents/ssh-agent.sock"}) {
+		t.Errorf("expected the bound socket to be hidden, got %v", got)
+	}
+}
+
+func TestExec_agentEnvironment(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a Unix shell")
+	}
+	t.Setenv("SSH_AUTH_SOCK", "/tmp/ssh-agent.sock")
+
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := r.Run(context.Background(), "sh", "echo \"sock=$SSH_AUTH_SOCK\"", nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "sock=" {
+		t.Errorf("expected SSH_AUTH_SOCK to be blocked, got %q", output)
+	}
+
+	r, err = NewExec(Options{"allow_ssh_agent": true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err = r.Run(context.Background(), "sh", "echo \"sock=$SSH_AUTH_SOCK\"", nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "sock=/tmp/ssh-agent.sock" {
+		t.Errorf("expected SSH_AUTH_SOCK to be allowed, got %q", output)
+	}
+}
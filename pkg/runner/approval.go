@@ -0,0 +1,493 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// ErrApprovalDenied is returned for the executions whose approval was
+// denied, or could not be obtained
+var ErrApprovalDenied = errors.New("execution not approved")
+
+// ErrApprovalTimeout is returned (wrapped with ErrApprovalDenied) for the
+// executions that were not approved in time
+var ErrApprovalTimeout = errors.New("approval timed out")
+
+// DefaultApprovalTimeout is the time an execution waits for its approval
+// when the policy does not set one
+const DefaultApprovalTimeout = 15 * time.Minute
+
+// ApprovalRequest is an execution waiting for the approval of an Approver
+type ApprovalRequest struct {
+	// ID identifies the request
+	ID string `json:"id"`
+
+	// Runner is the type of the runner
+	Runner Type `json:"runner"`
+
+	// Options are the options of the runner
+	Options Options `json:"options,omitempty"`
+
+	// Command is the command line of the execution
+	Command string `json:"command"`
+
+	// EnvNames are the names of the environment variables of the
+	// execution (their values are not disclosed)
+	EnvNames []string `json:"env_names,omitempty"`
+
+	// Rules are the names of the rules the execution matched
+	Rules []string `json:"rules"`
+
+	// Prompt is the prompt of the command waiting for the approval, and
+	// Output the output before it, for the approval of prompts (see
+	// WithPromptApproval)
+	Prompt string `json:"prompt,omitempty"`
+	Output string `json:"output,omitempty"`
+
+	// Requested is when the approval was requested
+	Requested time.Time `json:"requested"`
+}
+
+// ApprovalDecision is the decision of an Approver
+type ApprovalDecision struct {
+	// Approved is whether the execution can run
+	Approved bool `json:"approved"`
+
+	// Approver identifies who (or what) made the decision
+	Approver string `json:"approver,omitempty"`
+
+	// Reason explains the decision
+	Reason string `json:"reason,omitempty"`
+
+	// Input is written to the command to answer an approved prompt,
+	// instead of the input of its rule (see WithPromptApproval). It is
+	// not audited.
+	Input string `json:"input,omitempty"`
+}
+
+// Approver approves the executions matching the rules of an ApprovalPolicy,
+// such as a human reviewer or a policy engine. Approve can block until the
+// decision is made, or ctx is done.
+type Approver interface {
+	Approve(ctx context.Context, req ApprovalRequest) (ApprovalDecision, error)
+}
+
+// ApproverFunc adapts a function to the Approver interface
+type ApproverFunc func(ctx context.Context, req ApprovalRequest) (ApprovalDecision, error)
+
+// Approve implements Approver
+func (f ApproverFunc) Approve(ctx context.Context, req ApprovalRequest) (ApprovalDecision, error) {
+	return f(ctx, req)
+}
+
+// ApprovalRule selects the executions requiring an approval. All the
+// criteria set must match; a rule without criteria matches all executions.
+type ApprovalRule struct {
+	// Name identifies the rule in the requests and the audit
+	Name string `json:"name"`
+
+	// Runners are the runner types the rule applies to (default: all)
+	Runners []Type `json:"runners,omitempty"`
+
+	// Command is a regular expression matching the command line
+	Command string `json:"command,omitempty"`
+
+	// Image is a regular expression matching the image option (Docker)
+	Image string `json:"image,omitempty"`
+
+	// Options are elevated options: the rule matches when any of them is
+	// set to a non-empty value (e.g. "allow_networking", "mounts")
+	Options []string `json:"options,omitempty"`
+
+	command *regexp.Regexp
+	image   *regexp.Regexp
+}
+
+// ApprovalRecord is the audit of an approval
+type ApprovalRecord struct {
+	// Request is the approval request
+	Request ApprovalRequest `json:"request"`
+
+	// Decision is the decision of the approver
+	Decision ApprovalDecision `json:"decision"`
+
+	// Error is why the approval could not be obtained, if it failed
+	Error string `json:"error,omitempty"`
+
+	// Waited is how long the execution waited for the decision
+	Waited time.Duration `json:"waited"`
+}
+
+// ApprovalPolicy is the configuration of an ApprovalGate
+type ApprovalPolicy struct {
+	// Rules select the executions requiring an approval
+	Rules []ApprovalRule
+
+	// Approver decides on the executions matching the rules
+	Approver Approver
+
+	// Timeout is the time to wait for a decision, after which the
+	// execution is denied (default: DefaultApprovalTimeout)
+	Timeout time.Duration
+
+	// Audit receives every decision, or failure to obtain one
+	Audit func(ApprovalRecord)
+}
+
+// ApprovalGate is a Runner requiring the approval of an Approver for the
+// executions matching the rules of its policy (dual control). The other
+// executions run without delay.
+type ApprovalGate struct {
+	runner     Runner
+	runnerType Type
+	options    Options
+	policy     ApprovalPolicy
+	logger     Logger
+}
+
+// NewApprovalGate wraps a runner with an approval policy. If logger is nil,
+// a default logger is created.
+func NewApprovalGate(r Runner, policy ApprovalPolicy, logger Logger) (*ApprovalGate, error) {
+	if policy.Approver == nil {
+		return nil, fmt.Errorf("approval policy requires an approver")
+	}
+	if policy.Timeout == 0 {
+		policy.Timeout = DefaultApprovalTimeout
+	}
+	rules := make([]ApprovalRule, len(policy.Rules))
+	for i, rule := range policy.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("approval rule %d has no name", i)
+		}
+		var err error
+		if rule.Command != "" {
+			if rule.command, err = regexp.Compile(rule.Command); err != nil {
+				return nil, fmt.Errorf("invalid command of approval rule %s: %w", rule.Name, err)
+			}
+		}
+		if rule.Image != "" {
+			if rule.image, err = regexp.Compile(rule.Image); err != nil {
+				return nil, fmt.Errorf("invalid image of approval rule %s: %w", rule.Name, err)
+			}
+		}
+		rules[i] = rule
+	}
+	policy.Rules = rules
+
+	runnerType, options, err := runnerOptions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ApprovalGate{
+		runner:     r,
+		runnerType: runnerType,
+		options:    options,
+		policy:     policy,
+		logger:     defaultLogger(logger),
+	}, nil
+}
+
+// Run executes a command once approved. It implements the Runner interface.
+func (g *ApprovalGate) Run(ctx context.Context, shell string, command string, env []string, params map[string]interface{}, tmpfile bool) (string, error) {
+	if err := g.approve(ctx, command, env); err != nil {
+		return "", err
+	}
+	return g.runner.Run(ctx, shell, command, env, params, tmpfile)
+}
+
+// RunEx executes a command once approved, like Run, returning its exit code
+// and both output streams. It implements the Runner interface.
+func (g *ApprovalGate) RunEx(ctx context.Context, req RunRequest) (*RunResult, error) {
+	if err := g.approve(ctx, req.Command, req.Env); err != nil {
+		return nil, err
+	}
+	return RunEx(ctx, g.runner, req)
+}
+
+// RunWithPipes starts a command once approved. It implements the Runner
+// interface.
+func (g *ApprovalGate) RunWithPipes(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (
+	stdin io.WriteCloser,
+	stdout io.ReadCloser,
+	stderr io.ReadCloser,
+	wait func() error,
+	err error,
+) {
+	if err := g.approve(ctx, commandLine(cmd, args), env); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return g.runner.RunWithPipes(ctx, cmd, args, env, params)
+}
+
+// CheckImplicitRequirements checks the requirements of the runner
+func (g *ApprovalGate) CheckImplicitRequirements() error {
+	return g.runner.CheckImplicitRequirements()
+}
+
+// Fingerprint returns the fingerprint of the runner. It implements the
+// Fingerprinter interface.
+func (g *ApprovalGate) Fingerprint() string {
+	return Fingerprint(g.runner)
+}
+
+// start starts a command once approved, with the execution handle of the
+// runner (see Start)
+func (g *ApprovalGate) start(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	if err := g.approve(ctx, commandLine(cmd, args), env); err != nil {
+		return nil, err
+	}
+	return startExecution(ctx, g.runner, cmd, args, env, params)
+}
+
+// openSession opens a session of the runner where every command started
+// requires the approval of the policy, like those run by the gate
+func (g *ApprovalGate) openSession(ctx context.Context, params map[string]interface{}) (sessionBackend, error) {
+	opener, ok := g.runner.(sessionOpener)
+	if !ok {
+		return nil, fmt.Errorf("sessions: %w", ErrNotSupported)
+	}
+	backend, err := opener.openSession(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return &approvalSessionBackend{sessionBackend: backend, gate: g}, nil
+}
+
+// approvalSessionBackend is the sandbox of a session of an ApprovalGate
+type approvalSessionBackend struct {
+	sessionBackend
+	gate *ApprovalGate
+}
+
+// start starts a command in the sandbox once approved
+func (b *approvalSessionBackend) start(ctx context.Context, cmd string, args []string, env []string) (*Execution, error) {
+	if err := b.gate.approve(ctx, commandLine(cmd, args), env); err != nil {
+		return nil, err
+	}
+	return b.sessionBackend.start(ctx, cmd, args, env)
+}
+
+// fileChangeFolders returns the folders of the runner reporting file changes
+func (g *ApprovalGate) fileChangeFolders(params map[string]interface{}) []string {
+	if fr, ok := g.runner.(fileChangeReporter); ok {
+		return fr.fileChangeFolders(params)
+	}
+	return nil
+}
+
+// policyWarnings returns the warnings of the runner
+func (g *ApprovalGate) policyWarnings(ctx context.Context) []PolicyWarning {
+	if w, ok := g.runner.(policyWarner); ok {
+		return w.policyWarnings(ctx)
+	}
+	return nil
+}
+
+// redactOptions returns a copy of the options with the registered secrets
+// (e.g. resolved from secret references or encrypted options) redacted, as
+// they are sent to the approvers
+func redactOptions(options Options) Options {
+	if options == nil {
+		return nil
+	}
+	return Options(redactOptionValue(map[string]interface{}(options)).(map[string]interface{}))
+}
+
+// redactOptionValue redacts the registered secrets of an option value,
+// returning a copy of maps and lists
+func redactOptionValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return common.RedactSecrets(v)
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			res[key] = redactOptionValue(item)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			res[i] = redactOptionValue(item)
+		}
+		return res
+	case []string:
+		res := make([]string, len(v))
+		for i, item := range v {
+			res[i] = common.RedactSecrets(item)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+// approve returns nil when the execution matches no rule or is approved,
+// and an error wrapping ErrApprovalDenied otherwise
+func (g *ApprovalGate) approve(ctx context.Context, command string, env []string) error {
+	var matched []string
+	for _, rule := range g.policy.Rules {
+		if g.matches(rule, command) {
+			matched = append(matched, rule.Name)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+
+	req := ApprovalRequest{
+		ID:        newExecutionID(),
+		Runner:    g.runnerType,
+		Options:   redactOptions(g.options),
+		Command:   command,
+		EnvNames:  envNames(env),
+		Rules:     matched,
+		Requested: time.Now(),
+	}
+	g.logger.Info("Execution %s requires approval (rules %s): %s", req.ID, strings.Join(matched, ", "), command)
+
+	approveCtx, cancel := context.WithTimeout(ctx, g.policy.Timeout)
+	defer cancel()
+	decision, err := g.policy.Approver.Approve(approveCtx, req)
+	if err == nil && approveCtx.Err() != nil {
+		err = approveCtx.Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		err = ErrApprovalTimeout
+	}
+
+	record := ApprovalRecord{Request: req, Decision: decision, Waited: time.Since(req.Requested)}
+	if err != nil {
+		record.Decision = ApprovalDecision{}
+		record.Error = err.Error()
+	}
+	if g.policy.Audit != nil {
+		g.policy.Audit(record)
+	}
+
+	switch {
+	case err != nil:
+		g.logger.Warn("Execution %s not approved: %v", req.ID, err)
+		return fmt.Errorf("%w: %w", ErrApprovalDenied, err)
+	case !decision.Approved:
+		g.logger.Warn("Execution %s denied by %s: %s", req.ID, decision.Approver, decision.Reason)
+		return fmt.Errorf("%w by %s: %s", ErrApprovalDenied, decision.Approver, decision.Reason)
+	}
+	g.logger.Info("Execution %s approved by %s: %s", req.ID, decision.Approver, decision.Reason)
+	return nil
+}
+
+// matches returns whether an execution matches all the criteria of a rule
+func (g *ApprovalGate) matches(rule ApprovalRule, command string) bool {
+	if len(rule.Runners) > 0 && !containsType(rule.Runners, g.runnerType) {
+		return false
+	}
+	if rule.command != nil && !rule.command.MatchString(command) {
+		return false
+	}
+	if rule.image != nil {
+		image, _ := g.options["image"].(string)
+		if !rule.image.MatchString(image) {
+			return false
+		}
+	}
+	if len(rule.Options) > 0 {
+		elevated := false
+		for _, key := range rule.Options {
+			if value, ok := g.options[key]; ok && !isEmptyValue(value) {
+				elevated = true
+				break
+			}
+		}
+		if !elevated {
+			return false
+		}
+	}
+	return true
+}
+
+// isEmptyValue returns whether a JSON option value is not set: null, false,
+// zero, or an empty string, list or map
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// commandLine returns the command line of a command and its arguments
+func commandLine(cmd string, args []string) string {
+	return strings.TrimSpace(cmd + " " + strings.Join(args, " "))
+}
+
+// WebhookApprover requests the approvals to an HTTP service: the
+// ApprovalRequest is POSTed as JSON, and the service responds with the
+// ApprovalDecision once it is made, e.g. after a human approved it.
+type WebhookApprover struct {
+	// URL of the approval service
+	URL string
+
+	// Headers are added to the requests (e.g. Authorization)
+	Headers map[string]string
+
+	// Client is the HTTP client (default: http.DefaultClient). The request
+	// is canceled when the approval times out.
+	Client *http.Client
+}
+
+// Approve implements Approver
+func (a WebhookApprover) Approve(ctx context.Context, req ApprovalRequest) (ApprovalDecision, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return ApprovalDecision{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, bytes.NewReader(body))
+	if err != nil {
+		return ApprovalDecision{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for key, value := range a.Headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return ApprovalDecision{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return ApprovalDecision{}, fmt.Errorf("approval service %s returned %s", a.URL, resp.Status)
+	}
+
+	var decision ApprovalDecision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return ApprovalDecision{}, fmt.Errorf("invalid response of approval service %s: %w", a.URL, err)
+	}
+	return decision, nil
+}
@@ -0,0 +1,293 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+func TestApprovalGate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+
+	var mu sync.Mutex
+	var requests []ApprovalRequest
+	var records []ApprovalRecord
+	approver := ApproverFunc(func(ctx context.Context, req ApprovalRequest) (ApprovalDecision, error) {
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+		if strings.Contains(req.Command, "rm") {
+			return ApprovalDecision{Approved: false, Approver: "alice", Reason: "too risky"}, nil
+		}
+		return ApprovalDecision{Approved: true, Approver: "alice", Reason: "ok"}, nil
+	})
+	gate, err := NewApprovalGate(r, ApprovalPolicy{
+		Rules: []ApprovalRule{
+			{Name: "destructive", Command: `\b(rm|dd|echo approved)\b`},
+			{Name: "docker-only", Runners: []Type{TypeDocker}},
+		},
+		Approver: approver,
+		Audit: func(record ApprovalRecord) {
+			mu.Lock()
+			records = append(records, record)
+			mu.Unlock()
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewApprovalGate failed: %v", err)
+	}
+	ctx := context.Background()
+
+	// not matching any rule
+	output, err := gate.Run(ctx, "/bin/sh", "echo hello", nil, nil, false)
+	if err != nil || output != "hello" {
+		t.Errorf("Run() = %q, %v", output, err)
+	}
+	if len(requests) != 0 {
+		t.Errorf("commands not matching any rule should not require approval: %+v", requests)
+	}
+
+	output, err = gate.Run(ctx, "/bin/sh", "echo approved", []string{"TOKEN=secret"}, nil, false)
+	if err != nil || output != "approved" {
+		t.Errorf("Run() of an approved command = %q, %v", output, err)
+	}
+	if len(requests) != 1 || requests[0].Runner != TypeExec || requests[0].Rules[0] != "destructive" ||
+		len(requests[0].EnvNames) != 1 || requests[0].EnvNames[0] != "TOKEN" {
+		t.Errorf("unexpected approval requests: %+v", requests)
+	}
+
+	_, err = gate.Run(ctx, "/bin/sh", "rm -rf /tmp/nothing", nil, nil, false)
+	if !errors.Is(err, ErrApprovalDenied) || !strings.Contains(err.Error(), "too risky") {
+		t.Errorf("Run() of a denied command = %v, want ErrApprovalDenied", err)
+	}
+
+	_, _, _, _, err = gate.RunWithPipes(ctx, "rm", []string{"-rf", "/tmp/nothing"}, nil, nil)
+	if !errors.Is(err, ErrApprovalDenied) {
+		t.Errorf("RunWithPipes() of a denied command = %v, want ErrApprovalDenied", err)
+	}
+
+	result, err := gate.RunEx(ctx, RunRequest{Shell: "/bin/sh", Command: "echo approved; exit 2"})
+	if err != nil || result.ExitCode != 2 {
+		t.Errorf("RunEx() of an approved command = %+v, %v", result, err)
+	}
+
+	if len(records) != 4 || !records[0].Decision.Approved || records[1].Decision.Approved || records[1].Decision.Reason != "too risky" {
+		t.Errorf("unexpected audit records: %+v", records)
+	}
+	if typ, _ := runnerPolicy(gate); typ != TypeExec {
+		t.Errorf("runnerPolicy() of the gate = %q, want %q", typ, TypeExec)
+	}
+	if fp := Fingerprint(gate); fp == "" || fp != Fingerprint(r) {
+		t.Errorf("Fingerprint() = %q, want the fingerprint of the runner", fp)
+	}
+}
+
+func TestApprovalGateSession(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	exec, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	r := &fakeSessionRunner{Exec: exec}
+	gate, err := NewApprovalGate(r, ApprovalPolicy{
+		Rules: []ApprovalRule{{Name: "destructive", Command: `\brm\b`}},
+		Approver: ApproverFunc(func(ctx context.Context, req ApprovalRequest) (ApprovalDecision, error) {
+			return ApprovalDecision{Approved: false, Reason: "too risky"}, nil
+		}),
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewApprovalGate failed: %v", err)
+	}
+
+	ctx := context.Background()
+	s, err := OpenSession(ctx, gate, nil)
+	if err != nil {
+		t.Fatalf("OpenSession() of the gate failed: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	if output, err := s.Run(ctx, "", "echo hello", nil, nil, false); err != nil || strings.TrimSpace(output) != "hello" {
+		t.Errorf("Run() = %q, %v", output, err)
+	}
+	if _, err := s.Run(ctx, "", "rm -rf /tmp/nothing", nil, nil, false); !errors.Is(err, ErrApprovalDenied) {
+		t.Errorf("Run() of a denied command in the session = %v, want ErrApprovalDenied", err)
+	}
+
+	unsupported, err := NewApprovalGate(exec, ApprovalPolicy{Approver: ApproverFunc(nil)}, nil)
+	if err != nil {
+		t.Fatalf("NewApprovalGate failed: %v", err)
+	}
+	if _, err := OpenSession(ctx, unsupported, nil); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("OpenSession() of a gate of a runner without sessions = %v, want ErrNotSupported", err)
+	}
+}
+
+func TestApprovalGateTimeout(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	var record ApprovalRecord
+	gate, err := NewApprovalGate(r, ApprovalPolicy{
+		Rules: []ApprovalRule{{Name: "all"}},
+		Approver: ApproverFunc(func(ctx context.Context, req ApprovalRequest) (ApprovalDecision, error) {
+			<-ctx.Done()
+			return ApprovalDecision{}, ctx.Err()
+		}),
+		Timeout: 20 * time.Millisecond,
+		Audit:   func(r ApprovalRecord) { record = r },
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewApprovalGate failed: %v", err)
+	}
+	_, err = gate.Run(context.Background(), "", "echo hello", nil, nil, false)
+	if !errors.Is(err, ErrApprovalDenied) || !errors.Is(err, ErrApprovalTimeout) {
+		t.Errorf("Run() = %v, want ErrApprovalTimeout", err)
+	}
+	if record.Error == "" || record.Decision.Approved || record.Waited < 20*time.Millisecond {
+		t.Errorf("unexpected audit record: %+v", record)
+	}
+}
+
+func TestApprovalRuleMatches(t *testing.T) {
+	rule := ApprovalRule{Name: "elevated", Runners: []Type{TypeDocker}, Image: `^alpine:`, Options: []string{"allow_networking", "mounts"}}
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	gate, err := NewApprovalGate(r, ApprovalPolicy{
+		Rules:    []ApprovalRule{rule},
+		Approver: ApproverFunc(func(context.Context, ApprovalRequest) (ApprovalDecision, error) { return ApprovalDecision{}, nil }),
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewApprovalGate failed: %v", err)
+	}
+	rule = gate.policy.Rules[0]
+
+	tests := []struct {
+		name       string
+		runnerType Type
+		options    Options
+		want       bool
+	}{
+		{name: "other runner", runnerType: TypeExec, options: Options{"image": "alpine:3", "allow_networking": true}},
+		{name: "other image", runnerType: TypeDocker, options: Options{"image": "ubuntu:22.04", "allow_networking": true}},
+		{name: "not elevated", runnerType: TypeDocker, options: Options{"image": "alpine:3", "allow_networking": false, "mounts": []interface{}{}}},
+		{name: "networking", runnerType: TypeDocker, options: Options{"image": "alpine:3", "allow_networking": true}, want: true},
+		{name: "mounts", runnerType: TypeDocker, options: Options{"image": "alpine:3", "mounts": []interface{}{"/src:/src"}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &ApprovalGate{runnerType: tt.runnerType, options: tt.options}
+			if got := g.matches(rule, "echo hello"); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewApprovalGateErrors(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	approver := ApproverFunc(func(ctx context.Context, req ApprovalRequest) (ApprovalDecision, error) {
+		return ApprovalDecision{Approved: true}, nil
+	})
+	policies := map[string]ApprovalPolicy{
+		"no approver":   {Rules: []ApprovalRule{{Name: "all"}}},
+		"unnamed rule":  {Rules: []ApprovalRule{{Command: "rm"}}, Approver: approver},
+		"invalid regex": {Rules: []ApprovalRule{{Name: "bad", Image: "("}}, Approver: approver},
+	}
+	for name, policy := range policies {
+		if _, err := NewApprovalGate(r, policy, nil); err == nil {
+			t.Errorf("NewApprovalGate() with %s should fail", name)
+		}
+	}
+}
+
+func TestApprovalGateRedactsSecrets(t *testing.T) {
+	vault := newFakeVault(t)
+	ctx := context.Background()
+	options, err := ResolveSecretRefs(ctx, Options{"shell": "${vault:secret/ci#token}"},
+		VaultResolver{Address: vault.URL, Token: "root"})
+	if err != nil {
+		t.Fatalf("ResolveSecretRefs failed: %v", err)
+	}
+	r, err := NewExec(options, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ = io.ReadAll(req.Body)
+		_ = json.NewEncoder(w).Encode(ApprovalDecision{Approved: false, Approver: "bob"})
+	}))
+	defer server.Close()
+
+	gate, err := NewApprovalGate(r, ApprovalPolicy{
+		Rules:    []ApprovalRule{{Name: "all"}},
+		Approver: WebhookApprover{URL: server.URL},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewApprovalGate failed: %v", err)
+	}
+	if _, err := gate.Run(ctx, "", "true", nil, nil, false); !errors.Is(err, ErrApprovalDenied) {
+		t.Fatalf("Run() = %v, want ErrApprovalDenied", err)
+	}
+	if len(body) == 0 || strings.Contains(string(body), "vault-kv1-token") {
+		t.Errorf("the webhook received the secret of the options: %s", body)
+	}
+	if !strings.Contains(string(body), common.RedactedSecret) {
+		t.Errorf("the webhook did not receive the redacted options: %s", body)
+	}
+	if !strings.Contains(fmt.Sprint(gate.options), "vault-kv1-token") {
+		t.Errorf("the options of the gate were modified: %v", gate.options)
+	}
+}
+
+func TestWebhookApprover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req ApprovalRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(ApprovalDecision{Approved: req.Command == "ls", Approver: "bob"})
+	}))
+	defer server.Close()
+
+	approver := WebhookApprover{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}}
+	decision, err := approver.Approve(context.Background(), ApprovalRequest{Command: "ls"})
+	if err != nil || !decision.Approved || decision.Approver != "bob" {
+		t.Errorf("Approve() = %+v, %v", decision, err)
+	}
+
+	approver.Headers = nil
+	if _, err := approver.Approve(context.Background(), ApprovalRequest{Command: "ls"}); err == nil {
+		t.Errorf("Approve() with an error response should fail")
+	}
+}
@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// archBinary is the macOS tool running a command as an architecture
+const archBinary = "/usr/bin/arch"
+
+// rosettaRuntime is installed with Rosetta 2 on Apple Silicon
+const rosettaRuntime = "/Library/Apple/usr/share/rosetta/rosetta"
+
+// ArchitectureOptions selects the architecture of the commands on macOS.
+// Universal binaries run as the native architecture of the host by default,
+// and some tools only ship Intel binaries: on Apple Silicon, they can be run
+// as x86_64 under Rosetta.
+type ArchitectureOptions struct {
+	// Architecture is the architecture the commands run as (macOS only):
+	// "x86_64" (under Rosetta on Apple Silicon) or "arm64" (Apple Silicon
+	// only). By default, the architecture is chosen by macOS.
+	Architecture string `json:"architecture"`
+}
+
+// validateArchitecture checks the architecture option, only supported on
+// macOS
+func (o ArchitectureOptions) validateArchitecture() error {
+	switch o.Architecture {
+	case "":
+		return nil
+	case "x86_64", "arm64":
+	default:
+		return fmt.Errorf("invalid architecture %q: must be x86_64 or arm64", o.Architecture)
+	}
+	if runtime.GOOS != "darwin" {
+		return fmt.Errorf("architecture requires macOS: %w", ErrNotSupported)
+	}
+	return nil
+}
+
+// checkArchitecture checks that the commands can run as the architecture on
+// this host
+func (o ArchitectureOptions) checkArchitecture() error {
+	if o.Architecture == "" {
+		return nil
+	}
+	if !contains(SupportedArchitectures(), o.Architecture) {
+		if o.Architecture == "x86_64" {
+			return fmt.Errorf("architecture x86_64 is not available: Rosetta is not installed (softwareupdate --install-rosetta)")
+		}
+		return fmt.Errorf("architecture %s is not available on this host", o.Architecture)
+	}
+	return nil
+}
+
+// SupportedArchitectures returns the architectures the commands can run as
+// with the architecture option: "arm64" and, when Rosetta is installed,
+// "x86_64" on Apple Silicon, and "x86_64" on Intel. It returns nil in other
+// operating systems.
+func SupportedArchitectures() []string {
+	if runtime.GOOS != "darwin" {
+		return nil
+	}
+	if !appleSilicon() {
+		return []string{"x86_64"}
+	}
+	if _, err := os.Stat(rosettaRuntime); err == nil {
+		return []string{"arm64", "x86_64"}
+	}
+	return []string{"arm64"}
+}
+
+// applyArchitecture makes a command, not started yet, run as the
+// architecture with arch(1)
+func (o ArchitectureOptions) applyArchitecture(logger Logger, cmd *exec.Cmd) {
+	if o.Architecture == "" {
+		return
+	}
+	logger.Debug("Running command as %s: %s", o.Architecture, cmd.Path)
+	cmd.Args = append([]string{"arch", "-" + o.Architecture, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = archBinary
+}
@@ -0,0 +1,12 @@
+//go:build darwin
+
+package runner
+
+import "golang.org/x/sys/unix"
+
+// appleSilicon returns whether this host has an Apple Silicon processor, even
+// when this process runs under Rosetta
+func appleSilicon() bool {
+	arm64, err := unix.SysctlUint32("hw.optional.arm64")
+	return err == nil && arm64 == 1
+}
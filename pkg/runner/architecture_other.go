@@ -0,0 +1,8 @@
+//go:build !darwin
+
+package runner
+
+// appleSilicon returns whether this host has an Apple Silicon processor
+func appleSilicon() bool {
+	return false
+}
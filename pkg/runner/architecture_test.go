@@ -0,0 +1,44 @@
+package runner
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestArchitectureOptions(t *testing.T) {
+	if err := (ArchitectureOptions{Architecture: "ppc"}).validateArchitecture(); err == nil {
+		t.Errorf("validateArchitecture() of an invalid architecture should fail")
+	}
+	err := (ArchitectureOptions{Architecture: "x86_64"}).validateArchitecture()
+	if runtime.GOOS == "darwin" && err != nil {
+		t.Errorf("validateArchitecture() failed: %v", err)
+	}
+	if runtime.GOOS != "darwin" {
+		if !errors.Is(err, ErrNotSupported) {
+			t.Errorf("validateArchitecture() outside macOS = %v, want ErrNotSupported", err)
+		}
+		if _, err := NewExec(Options{"architecture": "arm64"}, nil); err == nil {
+			t.Errorf("NewExec() with an architecture should fail outside macOS")
+		}
+		if SupportedArchitectures() != nil {
+			t.Errorf("SupportedArchitectures() = %v outside macOS", SupportedArchitectures())
+		}
+	}
+}
+
+func TestApplyArchitecture(t *testing.T) {
+	cmd := exec.Command("/bin/echo", "hello", "world")
+	(ArchitectureOptions{}).applyArchitecture(defaultLogger(nil), cmd)
+	if cmd.Path != "/bin/echo" {
+		t.Errorf("the command was changed without an architecture: %v", cmd.Args)
+	}
+
+	(ArchitectureOptions{Architecture: "x86_64"}).applyArchitecture(defaultLogger(nil), cmd)
+	want := []string{"arch", "-x86_64", "/bin/echo", "hello", "world"}
+	if cmd.Path != archBinary || !reflect.DeepEqual(cmd.Args, want) {
+		t.Errorf("applyArchitecture() = %s %v, want %s %v", cmd.Path, cmd.Args, archBinary, want)
+	}
+}
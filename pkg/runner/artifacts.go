@@ -0,0 +1,246 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrArtifactNotFound is returned by Execution.Artifacts when a required
+// artifact was not produced by the command
+var ErrArtifactNotFound = errors.New("artifact not found")
+
+// ArtifactSpec declares output files expected from an execution
+type ArtifactSpec struct {
+	// Pattern is a glob (as in filepath.Match) of the files, as seen by the
+	// command. Relative patterns are resolved from the working directory of
+	// the command.
+	Pattern string
+
+	// Optional artifacts do not make Artifacts fail when no file matches
+	Optional bool
+
+	// Checksum computes the SHA-256 of the files
+	Checksum bool
+
+	// LoadContent reads the content of the files into the Artifact
+	LoadContent bool
+}
+
+// Artifact is an output file collected after an execution
+type Artifact struct {
+	// Pattern is the pattern of the spec that matched the file
+	Pattern string `json:"pattern"`
+
+	// Path is the path of the file as seen by the command
+	Path string `json:"path"`
+
+	// LocalPath is where the file can be read on the host. It is the same as
+	// Path for runners executing on the host, the host side of a mount, or a
+	// copy for files collected from containers.
+	LocalPath string `json:"local_path"`
+
+	// Size is the size of the file in bytes
+	Size int64 `json:"size"`
+
+	// SHA256 is the hex encoded checksum, when requested
+	SHA256 string `json:"sha256,omitempty"`
+
+	// Content is the content of the file, when requested
+	Content []byte `json:"content,omitempty"`
+}
+
+// WithArtifacts declares the artifacts to collect once the execution has
+// completed (see Execution.Artifacts)
+func WithArtifacts(specs ...ArtifactSpec) ExecOption {
+	return func(c *execConfig) {
+		c.artifacts = append(c.artifacts, specs...)
+	}
+}
+
+// WithArtifactsDir sets the host directory where artifacts are copied when
+// they are not directly readable from the host (e.g. files in a container
+// that are not in a mount). By default a temporary directory is created,
+// which the caller is responsible for removing.
+func WithArtifactsDir(dir string) ExecOption {
+	return func(c *execConfig) {
+		c.artifactsDir = dir
+	}
+}
+
+// Artifacts returns the artifacts declared with WithArtifacts. It must be
+// called after Wait.
+//
+// An error wrapping ErrArtifactNotFound is returned (along with the
+// artifacts found) when no file matches a pattern that is not optional.
+func (e *Execution) Artifacts() ([]Artifact, error) {
+	return e.artifacts, e.artifactsErr
+}
+
+// collectArtifacts finds the files matching the specs, from the host or from
+// the container of the execution
+func collectArtifacts(e *Execution, cfg *execConfig) ([]Artifact, error) {
+	var artifacts []Artifact
+	var missing []string
+
+	for _, spec := range cfg.artifacts {
+		var found []Artifact
+		var err error
+		if cb, ok := e.backend.(*containerBackend); ok {
+			found, err = cb.collectArtifacts(spec, cfg)
+		} else {
+			found, err = collectHostArtifacts(spec)
+		}
+		if err != nil {
+			return artifacts, fmt.Errorf("failed to collect artifacts %q: %w", spec.Pattern, err)
+		}
+
+		e.logger.Debug("Collected %d artifacts for %q", len(found), spec.Pattern)
+		if len(found) == 0 && !spec.Optional {
+			missing = append(missing, spec.Pattern)
+		}
+		artifacts = append(artifacts, found...)
+	}
+
+	if len(missing) > 0 {
+		return artifacts, fmt.Errorf("%w: %s", ErrArtifactNotFound, strings.Join(missing, ", "))
+	}
+	return artifacts, nil
+}
+
+// collectHostArtifacts finds the regular files matching a spec on the host
+func collectHostArtifacts(spec ArtifactSpec) ([]Artifact, error) {
+	matches, err := filepath.Glob(spec.Pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var artifacts []Artifact
+	for _, path := range matches {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		if info, err := os.Stat(abs); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		artifact, err := loadArtifact(spec, abs, abs)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return artifacts, nil
+}
+
+// loadArtifact builds the artifact for a file readable at localPath
+func loadArtifact(spec ArtifactSpec, path string, localPath string) (Artifact, error) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return Artifact{}, err
+	}
+
+	artifact := Artifact{
+		Pattern:   spec.Pattern,
+		Path:      path,
+		LocalPath: localPath,
+		Size:      info.Size(),
+	}
+	if !spec.Checksum && !spec.LoadContent {
+		return artifact, nil
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return Artifact{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	var r io.Reader = f
+	if spec.Checksum {
+		r = io.TeeReader(f, h)
+	}
+
+	if spec.LoadContent {
+		if artifact.Content, err = io.ReadAll(r); err != nil {
+			return Artifact{}, err
+		}
+	} else if _, err := io.Copy(io.Discard, r); err != nil {
+		return Artifact{}, err
+	}
+
+	if spec.Checksum {
+		artifact.SHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	return artifact, nil
+}
+
+// collectArtifacts finds the regular files matching a spec in the container.
+// Files in a bind mount are read from the host, and the rest are copied to
+// the artifacts directory with `docker cp`.
+func (b *containerBackend) collectArtifacts(spec ArtifactSpec, cfg *execConfig) ([]Artifact, error) {
+	// The pattern is expanded by the shell of the container, from its working directory
+	script := fmt.Sprintf(`for f in %s; do [ -f "$f" ] || continue; case "$f" in /*) echo "$f" ;; *) echo "$PWD/$f" ;; esac; done`,
+		spec.Pattern)
+	output, err := exec.Command(b.engine, "exec", b.container, "sh", "-c", script).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files in container: %w", err)
+	}
+
+	var artifacts []Artifact
+	for _, path := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if path == "" {
+			continue
+		}
+
+		localPath := b.mountedPath(path)
+		if localPath == "" {
+			if cfg.artifactsDir == "" {
+				if cfg.artifactsDir, err = os.MkdirTemp("", "go-restricted-runner-artifacts-*"); err != nil {
+					return nil, err
+				}
+			}
+			localPath = filepath.Join(cfg.artifactsDir, filepath.FromSlash(strings.TrimPrefix(path, "/")))
+			if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+				return nil, err
+			}
+			if output, err := exec.Command(b.engine, "cp", b.container+":"+path, localPath).CombinedOutput(); err != nil {
+				return nil, fmt.Errorf("%s cp failed: %w: %s", b.engine, err, string(output))
+			}
+		}
+
+		artifact, err := loadArtifact(spec, path, localPath)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return artifacts, nil
+}
+
+// mountedPath returns the host path of a container path that is in a bind
+// mount, or "" if the path is only in the container filesystem
+func (b *containerBackend) mountedPath(path string) string {
+	for _, mount := range b.mounts {
+		parts := strings.Split(mount, ":")
+		if len(parts) < 2 || !filepath.IsAbs(parts[0]) {
+			// named volumes are not readable from the host
+			continue
+		}
+		hostDir, containerDir := parts[0], strings.TrimSuffix(parts[1], "/")
+		if path == containerDir {
+			return hostDir
+		}
+		if strings.HasPrefix(path, containerDir+"/") {
+			return filepath.Join(hostDir, filepath.FromSlash(strings.TrimPrefix(path, containerDir+"/")))
+		}
+	}
+	return ""
+}
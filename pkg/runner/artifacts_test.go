@@ -0,0 +1,99 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// TestStart_Artifacts tests that declared artifacts are collected after Wait
+func TestStart_Artifacts(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, _ := common.NewLogger("", "", common.LogLevelDebug, false)
+	runner, err := NewExec(Options{}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+
+	e, err := Start(context.Background(), runner, "sh", []string{"-c", "printf hello > " + filepath.Join(dir, "out.txt")}, nil, nil,
+		WithArtifacts(
+			ArtifactSpec{Pattern: filepath.Join(dir, "*.txt"), Checksum: true, LoadContent: true},
+			ArtifactSpec{Pattern: filepath.Join(dir, "*.log"), Optional: true},
+		))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	_ = e.Stdin.Close()
+	_, _ = io.ReadAll(e.Stdout)
+	_, _ = io.ReadAll(e.Stderr)
+	if err := e.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	artifacts, err := e.Artifacts()
+	if err != nil {
+		t.Fatalf("Artifacts failed: %v", err)
+	}
+	if len(artifacts) != 1 {
+		t.Fatalf("Expected 1 artifact, got %d", len(artifacts))
+	}
+
+	a := artifacts[0]
+	if a.Path != filepath.Join(dir, "out.txt") || a.LocalPath != a.Path {
+		t.Errorf("Unexpected artifact paths: %q, %q", a.Path, a.LocalPath)
+	}
+	if a.Size != 5 || string(a.Content) != "hello" {
+		t.Errorf("Unexpected artifact size/content: %d, %q", a.Size, string(a.Content))
+	}
+	// sha256("hello")
+	if a.SHA256 != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("Unexpected checksum: %s", a.SHA256)
+	}
+}
+
+// TestStart_ArtifactsMissing tests that missing required artifacts are reported
+func TestStart_ArtifactsMissing(t *testing.T) {
+	logger, _ := common.NewLogger("", "", common.LogLevelDebug, false)
+	runner, err := NewExec(Options{}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+
+	e, err := Start(context.Background(), runner, "true", nil, nil, nil,
+		WithArtifacts(ArtifactSpec{Pattern: filepath.Join(t.TempDir(), "*.bin")}))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	_ = e.Stdin.Close()
+	_, _ = io.ReadAll(e.Stdout)
+	_, _ = io.ReadAll(e.Stderr)
+	if err := e.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	if _, err := e.Artifacts(); !errors.Is(err, ErrArtifactNotFound) {
+		t.Errorf("Expected ErrArtifactNotFound, got %v", err)
+	}
+}
+
+// TestContainerBackend_mountedPath tests the mapping of container paths to mounts
+func TestContainerBackend_mountedPath(t *testing.T) {
+	b := &containerBackend{mounts: []string{"/host/out:/out", "/host/data:/data:ro", "cache:/cache"}}
+
+	tests := map[string]string{
+		"/out/report.pdf":  filepath.Join("/host/out", "report.pdf"),
+		"/data/a/b.txt":    filepath.Join("/host/data", "a", "b.txt"),
+		"/cache/file":      "",
+		"/output/file.txt": "",
+	}
+	for path, expected := range tests {
+		if got := b.mountedPath(path); got != expected {
+			t.Errorf("mountedPath(%q) = %q, want %q", path, got, expected)
+		}
+	}
+}
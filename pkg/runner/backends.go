@@ -0,0 +1,133 @@
+package runner
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Factory creates a runner from its options, with the provided logger (nil
+// for the default logger)
+type Factory func(options Options, logger Logger) (Runner, error)
+
+// Backend is a runner type provided by another module, such as a farm of
+// virtual machines or a proprietary sandbox (see RegisterBackend)
+type Backend struct {
+	// New creates the runners of the type
+	New Factory
+
+	// Options is the struct the options of the type are parsed into, whose
+	// JSON keys make its schema (see SchemaFor). Without it, the options of
+	// the type are not checked for unknown keys.
+	Options interface{}
+
+	// SelfTest makes SelfTest run its canaries through the runners of the
+	// type, if not nil
+	SelfTest *SelfTestSpec
+}
+
+// SelfTestSpec describes how SelfTest checks the runners of a registered
+// type
+type SelfTestSpec struct {
+	// Options returns the options of the runner tested: no network, and
+	// only the writable folder allowed
+	Options func(writable string) Options
+
+	// HostFilesystem is whether the commands see the filesystem of the host,
+	// so writing outside the writable folder must be denied
+	HostFilesystem bool
+
+	// ReadDenied is whether the runner denies reading the files of the host
+	// outside the allowed folders
+	ReadDenied bool
+
+	// Interfaces checks the network is blocked from the network interfaces
+	// seen by the command (only lo), instead of with a connection to the host
+	Interfaces bool
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[Type]Backend{}
+)
+
+// Register makes a runner type provided by another module available to New,
+// and so to the Registry and everything else taking a Type. It is usually
+// called from the init function of the module providing the type. The
+// built-in types and the types already registered cannot be registered.
+func Register(runnerType Type, factory Factory) error {
+	return RegisterBackend(runnerType, Backend{New: factory})
+}
+
+// RegisterBackend registers a runner type like Register, also providing the
+// schema of its options and the way SelfTest checks it
+func RegisterBackend(runnerType Type, backend Backend) error {
+	if runnerType == "" {
+		return fmt.Errorf("cannot register a runner with an empty type")
+	}
+	if backend.New == nil {
+		return fmt.Errorf("cannot register the %s runner without a factory", runnerType)
+	}
+	if backend.SelfTest != nil && backend.SelfTest.Options == nil {
+		return fmt.Errorf("cannot register the self test of the %s runner without options", runnerType)
+	}
+	if backend.Options != nil && reflect.Indirect(reflect.ValueOf(backend.Options)).Kind() != reflect.Struct {
+		return fmt.Errorf("the options of the %s runner must be a struct", runnerType)
+	}
+	if _, builtin := optionStructs[runnerType]; builtin {
+		return fmt.Errorf("cannot register the built-in %s runner", runnerType)
+	}
+
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if _, exists := backends[runnerType]; exists {
+		return fmt.Errorf("the %s runner is already registered", runnerType)
+	}
+	backends[runnerType] = backend
+	return nil
+}
+
+// registeredBackend returns the backend registered for a type
+func registeredBackend(runnerType Type) (Backend, bool) {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	backend, ok := backends[runnerType]
+	return backend, ok
+}
+
+// Types returns the runner types available to New: the built-in types,
+// followed by the registered types sorted by name
+func Types() []Type {
+	types := []Type{TypeExec, TypeSandboxExec, TypeFirejail, TypeLandrun, TypeNsjail, TypeDocker, TypePodman, TypeADB, TypeProot, TypeDeno, TypePython, TypeWindowsRestricted, TypeUnshare}
+
+	backendsMu.RLock()
+	var registered []Type
+	for t := range backends {
+		registered = append(registered, t)
+	}
+	backendsMu.RUnlock()
+
+	sort.Slice(registered, func(i, j int) bool { return registered[i] < registered[j] })
+	return append(types, registered...)
+}
+
+// registeredSelfTestBackends returns the self tests of the registered types
+func registeredSelfTestBackends() []selfTestBackend {
+	var tested []selfTestBackend
+	for _, t := range Types() {
+		backend, ok := registeredBackend(t)
+		if !ok || backend.SelfTest == nil {
+			continue
+		}
+		spec := backend.SelfTest
+		tested = append(tested, selfTestBackend{
+			runner:         t,
+			options:        func(env *selfTestEnv) Options { return spec.Options(env.writable) },
+			hostFilesystem: spec.HostFilesystem,
+			readDenied:     spec.ReadDenied,
+			interfaces:     spec.Interfaces,
+		})
+	}
+	return tested
+}
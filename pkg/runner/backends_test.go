@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// vmOptions are the options of the runner type registered by the tests
+type vmOptions struct {
+	Pool  string `json:"pool"`
+	Image string `json:"image"`
+}
+
+// registerTestBackend registers a runner type running the commands with the
+// Exec runner, unregistered when the test ends
+func registerTestBackend(t *testing.T, runnerType Type, backend Backend) {
+	t.Helper()
+	if err := RegisterBackend(runnerType, backend); err != nil {
+		t.Fatalf("RegisterBackend failed: %v", err)
+	}
+	t.Cleanup(func() {
+		backendsMu.Lock()
+		delete(backends, runnerType)
+		backendsMu.Unlock()
+	})
+}
+
+func TestRegister(t *testing.T) {
+	var got Options
+	registerTestBackend(t, "test-vm", Backend{
+		New: func(options Options, logger Logger) (Runner, error) {
+			got = options
+			return NewExec(Options{}, logger)
+		},
+		Options: vmOptions{},
+	})
+
+	r, err := New("test-vm", Options{"pool": "ci", "image": "ubuntu-24.04"}, nil)
+	if err != nil {
+		t.Fatalf("New() of a registered type failed: %v", err)
+	}
+	if r == nil || got["pool"] != "ci" {
+		t.Errorf("the factory got the options %v", got)
+	}
+
+	types := Types()
+	if types[0] != TypeExec || types[len(types)-1] != "test-vm" {
+		t.Errorf("Types() = %v", types)
+	}
+
+	schema, err := SchemaFor("test-vm")
+	if err != nil || strings.Join(schema.Keys, ",") != "image,options_version,pool,profile" {
+		t.Errorf("SchemaFor() = %v, %v", schema, err)
+	}
+	if _, warnings, _ := Migrate("test-vm", Options{"pol": "ci"}); len(warnings) != 1 {
+		t.Errorf("Migrate() warnings = %v, want the unknown key", warnings)
+	}
+}
+
+func TestRegister_invalid(t *testing.T) {
+	factory := func(options Options, logger Logger) (Runner, error) { return NewExec(options, logger) }
+	registerTestBackend(t, "test-farm", Backend{New: factory})
+
+	if err := Register("test-farm", factory); err == nil {
+		t.Errorf("Register() of a registered type should fail")
+	}
+	if err := Register(TypeDocker, factory); err == nil {
+		t.Errorf("Register() of a built-in type should fail")
+	}
+	if err := Register("test-nil", nil); err == nil {
+		t.Errorf("Register() without a factory should fail")
+	}
+	if err := RegisterBackend("test-opts", Backend{New: factory, Options: "pool"}); err == nil {
+		t.Errorf("RegisterBackend() with options that are not a struct should fail")
+	}
+	if _, warnings, err := Migrate("test-farm", Options{"anything": true}); err != nil || len(warnings) != 0 {
+		t.Errorf("Migrate() of a type without options = %v, %v", warnings, err)
+	}
+	if _, err := New("test-missing", Options{}, nil); err == nil {
+		t.Errorf("New() of an unknown type should fail")
+	}
+}
+
+func TestRegister_selfTest(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	registerTestBackend(t, "test-sandbox", Backend{
+		New: func(options Options, logger Logger) (Runner, error) { return NewExec(options, logger) },
+		SelfTest: &SelfTestSpec{
+			Options: func(writable string) Options { return Options{} },
+		},
+	})
+
+	report, err := SelfTest(context.Background(), nil, "test-sandbox")
+	if err != nil {
+		t.Fatalf("SelfTest failed: %v", err)
+	}
+	if len(report.Checks) == 0 || report.Checks[0].Runner != "test-sandbox" || report.Checks[0].Status != SelfTestPass {
+		t.Errorf("SelfTest() = %+v, want the registered runner checked", report.Checks)
+	}
+}
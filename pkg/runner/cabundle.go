@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// caBundleGuestPath is where the CA bundle is made visible to commands that
+// run with their own root filesystem (Docker containers and proot guests)
+const caBundleGuestPath = "/etc/ssl/certs/runner-ca-bundle.pem"
+
+// caBundleEnvVars are the variables pointing common TLS stacks (OpenSSL and
+// Go, Python requests, Node.js, curl, git and Deno) to the CA bundle
+var caBundleEnvVars = []string{
+	"SSL_CERT_FILE",
+	"REQUESTS_CA_BUNDLE",
+	"NODE_EXTRA_CA_CERTS",
+	"CURL_CA_BUNDLE",
+	"GIT_SSL_CAINFO",
+	"DENO_CERT",
+}
+
+// CABundleOptions makes commands trust a custom set of certificate
+// authorities, as required when their egress goes through a TLS
+// intercepting proxy. It is embedded in the options of the runners, so its
+// fields are set with the same keys as any other option.
+type CABundleOptions struct {
+	// CABundle is a PEM file in the host with the CA certificates trusted by
+	// the command. Most TLS stacks use it instead of the system store, so it
+	// should also contain the public CAs the command needs.
+	CABundle string `json:"ca_bundle"`
+}
+
+// validateCABundle checks that the CA bundle contains certificates, and
+// makes its path absolute
+func (o *CABundleOptions) validateCABundle() error {
+	if o.CABundle == "" {
+		return nil
+	}
+
+	abs, err := filepath.Abs(o.CABundle)
+	if err != nil {
+		return fmt.Errorf("invalid ca_bundle: %w", err)
+	}
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return fmt.Errorf("failed to read ca_bundle: %w", err)
+	}
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			return fmt.Errorf("ca_bundle %s contains no PEM certificates", abs)
+		}
+		if block.Type == "CERTIFICATE" {
+			break
+		}
+	}
+
+	o.CABundle = abs
+	return nil
+}
+
+// caBundleVars returns the variables pointing to the CA bundle at path, as
+// seen by the command, or nil when there is no CA bundle
+func (o CABundleOptions) caBundleVars(path string) []string {
+	if o.CABundle == "" {
+		return nil
+	}
+	vars := make([]string, 0, len(caBundleEnvVars))
+	for _, name := range caBundleEnvVars {
+		vars = append(vars, name+"="+path)
+	}
+	return vars
+}
+
+// caBundleFiles returns the CA bundle, for the fingerprint of the runner
+func (o CABundleOptions) caBundleFiles() []string {
+	if o.CABundle == "" {
+		return nil
+	}
+	return []string{o.CABundle}
+}
+
+// caBundleEnv returns env with the variables pointing to the CA bundle at
+// path. Variables already set in env are kept.
+func (o CABundleOptions) caBundleEnv(env []string, path string) []string {
+	vars := o.caBundleVars(path)
+	if len(vars) == 0 {
+		return env
+	}
+
+	set := map[string]bool{}
+	for _, e := range env {
+		set[strings.SplitN(e, "=", 2)[0]] = true
+	}
+	res := make([]string, 0, len(vars)+len(env))
+	for _, v := range vars {
+		if !set[strings.SplitN(v, "=", 2)[0]] {
+			res = append(res, v)
+		}
+	}
+	return append(res, env...)
+}
@@ -0,0 +1,133 @@
+package runner
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestCABundle writes a PEM file with a self-signed CA certificate
+func writeTestCABundle(t *testing.T) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Proxy CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCABundleOptions_validateCABundle(t *testing.T) {
+	if err := (&CABundleOptions{}).validateCABundle(); err != nil {
+		t.Errorf("expected no error without a CA bundle, got %v", err)
+	}
+
+	o := &CABundleOptions{CABundle: writeTestCABundle(t)}
+	if err := o.validateCABundle(); err != nil {
+		t.Errorf("expected a valid CA bundle, got %v", err)
+	}
+
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&CABundleOptions{CABundle: notPEM}).validateCABundle(); err == nil {
+		t.Error("expected an error for a file without certificates")
+	}
+
+	if err := (&CABundleOptions{CABundle: filepath.Join(t.TempDir(), "missing.pem")}).validateCABundle(); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestCABundleOptions_caBundleEnv(t *testing.T) {
+	o := CABundleOptions{CABundle: "/certs/ca.pem"}
+	env := o.caBundleEnv([]string{"SSL_CERT_FILE=/mine.pem", "FOO=bar"}, caBundleGuestPath)
+
+	want := []string{
+		"REQUESTS_CA_BUNDLE=" + caBundleGuestPath,
+		"NODE_EXTRA_CA_CERTS=" + caBundleGuestPath,
+		"CURL_CA_BUNDLE=" + caBundleGuestPath,
+		"GIT_SSL_CAINFO=" + caBundleGuestPath,
+		"DENO_CERT=" + caBundleGuestPath,
+		"SSL_CERT_FILE=/mine.pem",
+		"FOO=bar",
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("caBundleEnv() = %v, want %v", env, want)
+	}
+
+	if got := (CABundleOptions{}).caBundleEnv([]string{"FOO=bar"}, ""); !reflect.DeepEqual(got, []string{"FOO=bar"}) {
+		t.Errorf("expected env to be unchanged without a CA bundle, got %v", got)
+	}
+}
+
+func TestDockerOptions_CABundleMount(t *testing.T) {
+	opts := &DockerOptions{Image: "alpine:latest", CABundleOptions: CABundleOptions{CABundle: "/my certs/ca.pem; id"}}
+	cmd := strings.Join(opts.GetBaseDockerCommand(nil), " ")
+	if want := "-v '/my certs/ca.pem; id:" + caBundleGuestPath + ":ro'"; !strings.Contains(cmd, want) {
+		t.Errorf("expected the quoted mount %s of the CA bundle in %q", want, cmd)
+	}
+}
+
+func TestExec_CABundle(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+
+	bundle := writeTestCABundle(t)
+	r, err := NewExec(Options{"ca_bundle": bundle}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+
+	out, err := r.Run(context.Background(), "sh", "echo $SSL_CERT_FILE $NODE_EXTRA_CA_CERTS", nil, nil, false)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if want := bundle + " " + bundle; strings.TrimSpace(out) != want {
+		t.Errorf("expected %q, got %q", want, out)
+	}
+}
+
+func TestDocker_CABundle(t *testing.T) {
+	bundle := writeTestCABundle(t)
+	opts, err := NewDockerOptions(Options{"image": "alpine", "ca_bundle": bundle})
+	if err != nil {
+		t.Fatalf("NewDockerOptions failed: %v", err)
+	}
+	cmd := strings.Join(opts.GetBaseDockerCommand(nil), " ")
+	if want := "-v " + bundle + ":" + caBundleGuestPath + ":ro"; !strings.Contains(cmd, want) {
+		t.Errorf("expected %q in %q", want, cmd)
+	}
+}
@@ -0,0 +1,268 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSandboxNotEnforced is returned when the canaries run before a command
+// (see CanaryOptions) find that the restrictions of the runner are not
+// enforced, e.g. because Landlock silently degraded with best_effort
+var ErrSandboxNotEnforced = errors.New("sandbox restrictions not enforced")
+
+// CanaryOptions verify the sandbox before running commands
+type CanaryOptions struct {
+	// VerifySandbox runs a canary command with the same restrictions before
+	// every command, and aborts the command when the canary can read a
+	// forbidden file or connect to the host
+	VerifySandbox bool `json:"verify_sandbox"`
+
+	// CanaryReadPaths are files the canary must not be able to read (e.g.
+	// "~/.ssh/id_ed25519")
+	CanaryReadPaths []string `json:"canary_read_paths"`
+}
+
+// validateCanaries checks the canary paths
+func (o CanaryOptions) validateCanaries() error {
+	if len(o.CanaryReadPaths) > 0 && !o.VerifySandbox {
+		return fmt.Errorf("canary_read_paths requires verify_sandbox")
+	}
+	for _, path := range o.CanaryReadPaths {
+		if path = expandHome(path); !filepath.IsAbs(path) {
+			return fmt.Errorf("canary read path %q must be absolute", path)
+		}
+	}
+	return nil
+}
+
+// canaryPolicy is what the canary of a command verifies
+type canaryPolicy struct {
+	// plant is whether the command must not read a file planted outside the
+	// allowed folders
+	plant bool
+
+	// allowed are the folders the command can read, where the canary file
+	// is not expected to be denied
+	allowed []string
+
+	// networkDenied is whether connections to the host must fail
+	networkDenied bool
+
+	// loopbackOnly is whether the command must only see the loopback
+	// interface, when connections to the host cannot be checked (e.g. in a
+	// container)
+	loopbackOnly bool
+}
+
+// canaryStarter starts the canary command like the commands of the runner
+type canaryStarter func(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error)
+
+// canaryRunKey is the context key marking the canary commands, so they are
+// not verified themselves
+type canaryRunKey struct{}
+
+// verifySandbox runs the canary with the runner when verify_sandbox is set,
+// returning ErrSandboxNotEnforced when the restrictions of the policy are
+// not enforced
+func (o CanaryOptions) verifySandbox(ctx context.Context, logger Logger, policy canaryPolicy,
+	start canaryStarter, params map[string]interface{}) error {
+	if !o.VerifySandbox || ctx.Value(canaryRunKey{}) != nil {
+		return nil
+	}
+	ctx = context.WithValue(ctx, canaryRunKey{}, true)
+
+	var denied []string
+	for _, path := range o.CanaryReadPaths {
+		denied = append(denied, expandHome(path))
+	}
+	if policy.plant {
+		if path, err := plantCanaryFile(); err != nil {
+			logger.Debug("Cannot plant the sandbox canary file: %v", err)
+		} else if within := pathWithin(path, policy.allowed); within != "" {
+			logger.Debug("The sandbox canary file %s is in the allowed folder %s", path, within)
+		} else {
+			denied = append(denied, path)
+		}
+	}
+
+	// the PATH of the commands may be hermetic (see PathOptions)
+	var script strings.Builder
+	script.WriteString("PATH=\"$PATH:/usr/local/bin:/usr/bin:/bin\"\n")
+	for _, path := range denied {
+		fmt.Fprintf(&script, "if cat -- %s >/dev/null 2>&1; then echo 'canary-read '%s; fi\n", shellQuote(path), shellQuote(path))
+	}
+	var listener *canaryListener
+	if policy.networkDenied {
+		var err error
+		if listener, err = newCanaryListener(); err != nil {
+			return err
+		}
+		defer listener.close()
+		fmt.Fprintf(&script, "( %s ) >/dev/null 2>&1\n", listener.connectScript())
+	}
+	if policy.loopbackOnly {
+		script.WriteString("echo canary-interfaces $(ls /sys/class/net 2>/dev/null)\n")
+	}
+	if len(denied) == 0 && !policy.networkDenied && !policy.loopbackOnly {
+		logger.Debug("No sandbox canary applies to the restrictions of the runner")
+		return nil
+	}
+	script.WriteString("true\n")
+
+	logger.Debug("Verifying the sandbox with a canary")
+	var connections int64
+	if listener != nil {
+		connections = listener.connections.Load()
+	}
+	output, err := runCanary(ctx, start, script.String(), params)
+	if err != nil {
+		return fmt.Errorf("failed to run the sandbox canary: %w", err)
+	}
+
+	var violations []string
+	for _, line := range strings.Split(output, "\n") {
+		if path, ok := strings.CutPrefix(line, "canary-read "); ok {
+			violations = append(violations, "read "+path)
+		}
+		if ifaces, ok := strings.CutPrefix(line, "canary-interfaces"); ok {
+			for _, iface := range strings.Fields(ifaces) {
+				if iface != "lo" {
+					violations = append(violations, "network interface "+iface)
+				}
+			}
+		}
+	}
+	if listener != nil && listener.connectedSince(connections) {
+		violations = append(violations, "connected to the host")
+	}
+	if len(violations) > 0 {
+		logger.Error("The sandbox canary was not restricted: %s", strings.Join(violations, ", "))
+		return fmt.Errorf("%w: the canary %s", ErrSandboxNotEnforced, strings.Join(violations, ", "))
+	}
+	logger.Debug("The sandbox canary was restricted")
+	return nil
+}
+
+// runCanary runs the canary script and returns its output
+func runCanary(ctx context.Context, start canaryStarter, script string, params map[string]interface{}) (string, error) {
+	e, err := start(ctx, "/bin/sh", []string{"-c", script}, nil, params)
+	if err != nil {
+		return "", err
+	}
+	_ = e.Stdin.Close()
+	go func() { _, _ = io.Copy(io.Discard, e.Stderr) }()
+	output, err := io.ReadAll(e.Stdout)
+	if err != nil {
+		_ = e.Wait()
+		return "", err
+	}
+	return string(output), e.Wait()
+}
+
+// canaryFileName is the name of the file planted for the canaries, in the
+// cache folder of the user. It is kept between runs, as the processes
+// restricted by Landlock cannot write it again.
+const canaryFileName = "sandbox-canary"
+
+// plantCanaryFile returns the canary file, creating it when it is missing
+func plantCanaryFile() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(cache, "go-restricted-runner", canaryFileName)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	content := "This file is read by the sandbox canaries of go-restricted-runner, which must not be able to.\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// pathWithin returns the folder of folders containing path, if any
+func pathWithin(path string, folders []string) string {
+	for _, folder := range folders {
+		if folder != "" && isSubPath(filepath.Clean(folder), path) {
+			return folder
+		}
+	}
+	return ""
+}
+
+// expandHome replaces a leading "~/" with the home folder of the user
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// canaryListener is a listener of the host counting the connections of the
+// canaries
+type canaryListener struct {
+	listener    net.Listener
+	connections atomic.Int64
+}
+
+// newCanaryListener listens on a random port of the loopback interface
+func newCanaryListener() (*canaryListener, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the network canary: %w", err)
+	}
+	l := &canaryListener{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			l.connections.Add(1)
+			_ = conn.Close()
+		}
+	}()
+	return l, nil
+}
+
+// close stops the listener
+func (l *canaryListener) close() {
+	_ = l.listener.Close()
+}
+
+// connectScript is the shell script connecting to the listener with the
+// first client found
+func (l *canaryListener) connectScript() string {
+	port := l.listener.Addr().(*net.TCPAddr).Port
+	return fmt.Sprintf(`if command -v curl >/dev/null; then curl -s -m 3 --noproxy "*" http://127.0.0.1:%[1]d/
+elif command -v nc >/dev/null; then echo canary | nc -w 3 127.0.0.1 %[1]d
+else bash -c 'echo canary > /dev/tcp/127.0.0.1/%[1]d'; fi`, port)
+}
+
+// connectedSince returns whether the listener got more connections than
+// before, waiting briefly as the connections may be accepted after the
+// client exits
+func (l *canaryListener) connectedSince(before int64) bool {
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		if l.connections.Load() > before {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return l.connections.Load() > before
+}
@@ -0,0 +1,133 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCanaryOptions_validateCanaries(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    CanaryOptions
+		wantErr bool
+	}{
+		{name: "disabled", opts: CanaryOptions{}},
+		{name: "enabled", opts: CanaryOptions{VerifySandbox: true}},
+		{name: "paths", opts: CanaryOptions{VerifySandbox: true, CanaryReadPaths: []string{"/etc/shadow", "~/.ssh/id_ed25519"}}},
+		{name: "relative path", opts: CanaryOptions{VerifySandbox: true, CanaryReadPaths: []string{"secret"}}, wantErr: true},
+		{name: "paths without verification", opts: CanaryOptions{CanaryReadPaths: []string{"/etc/shadow"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.validateCanaries()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateCanaries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestCanaryOptions_verifySandbox checks the canaries detect a runner
+// enforcing no restrictions
+func TestCanaryOptions_verifySandbox(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts := CanaryOptions{VerifySandbox: true}
+	ctx := context.Background()
+	logger := defaultLogger(nil)
+
+	if err := (CanaryOptions{}).verifySandbox(ctx, logger, canaryPolicy{plant: true}, r.start, nil); err != nil {
+		t.Errorf("verifySandbox() without verify_sandbox = %v, want nil", err)
+	}
+
+	err = opts.verifySandbox(ctx, logger, canaryPolicy{plant: true}, r.start, nil)
+	if !errors.Is(err, ErrSandboxNotEnforced) || !strings.Contains(err.Error(), canaryFileName) {
+		t.Errorf("verifySandbox() with a planted file = %v, want the file read", err)
+	}
+	cache, _ := os.UserCacheDir()
+	if err := opts.verifySandbox(ctx, logger, canaryPolicy{plant: true, allowed: []string{cache}}, r.start, nil); err != nil {
+		t.Errorf("verifySandbox() with the planted file allowed = %v, want nil", err)
+	}
+
+	withPath := CanaryOptions{VerifySandbox: true, CanaryReadPaths: []string{secret, "/nonexistent/secret"}}
+	err = withPath.verifySandbox(ctx, logger, canaryPolicy{}, r.start, nil)
+	if !errors.Is(err, ErrSandboxNotEnforced) || !strings.Contains(err.Error(), secret) || strings.Contains(err.Error(), "nonexistent") {
+		t.Errorf("verifySandbox() with canary paths = %v, want %s read", err, secret)
+	}
+
+	// the canaries are not verified themselves
+	canaryCtx := context.WithValue(ctx, canaryRunKey{}, true)
+	if err := opts.verifySandbox(canaryCtx, logger, canaryPolicy{plant: true}, r.start, nil); err != nil {
+		t.Errorf("verifySandbox() of a canary = %v, want nil", err)
+	}
+
+	if runtime.GOOS == "linux" {
+		ifaces, _ := os.ReadDir("/sys/class/net")
+		err = opts.verifySandbox(ctx, logger, canaryPolicy{loopbackOnly: true}, r.start, nil)
+		if len(ifaces) > 1 && !errors.Is(err, ErrSandboxNotEnforced) {
+			t.Errorf("verifySandbox() with the interfaces of the host = %v, want an error", err)
+		}
+	}
+}
+
+func TestCanaryOptions_verifySandboxNetwork(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	listener, err := newCanaryListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.close()
+	_, _ = r.Run(context.Background(), "", listener.connectScript(), nil, nil, false)
+	if !listener.connectedSince(0) {
+		t.Skip("no client (curl, nc or bash) can connect to the host")
+	}
+
+	err = CanaryOptions{VerifySandbox: true}.verifySandbox(context.Background(), defaultLogger(nil),
+		canaryPolicy{networkDenied: true}, r.start, nil)
+	if !errors.Is(err, ErrSandboxNotEnforced) || !strings.Contains(err.Error(), "connected to the host") {
+		t.Errorf("verifySandbox() = %v, want a connection to the host", err)
+	}
+}
+
+func TestNewDockerOptions_canaries(t *testing.T) {
+	opts, err := NewDockerOptions(Options{
+		"image":             "alpine",
+		"verify_sandbox":    true,
+		"canary_read_paths": []interface{}{"/etc/shadow"},
+	})
+	if err != nil {
+		t.Fatalf("NewDockerOptions failed: %v", err)
+	}
+	if !opts.VerifySandbox || strings.Join(opts.CanaryReadPaths, ",") != "/etc/shadow" {
+		t.Errorf("NewDockerOptions() = %+v, want the canary options", opts.CanaryOptions)
+	}
+
+	if _, err := NewDockerOptions(Options{"image": "alpine", "canary_read_paths": []string{"/etc/shadow"}}); err == nil {
+		t.Errorf("NewDockerOptions() should fail with canary_read_paths but no verify_sandbox")
+	}
+}
@@ -0,0 +1,197 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// checkpointManifestFile is the file describing a checkpoint in its folder
+const checkpointManifestFile = "checkpoint.json"
+
+// checkpointName is the name of the checkpoints of containers
+const checkpointName = "go-restricted-runner"
+
+// Kinds of checkpoints
+const (
+	checkpointProcess   = "process"
+	checkpointContainer = "container"
+)
+
+// CheckpointOptions configures Checkpoint
+type CheckpointOptions struct {
+	// LeaveRunning keeps the command running after the checkpoint. By
+	// default the command is stopped once its state has been saved.
+	LeaveRunning bool
+}
+
+// checkpointManifest describes a checkpoint, for restoring it
+type checkpointManifest struct {
+	// Kind is the kind of checkpoint (process or container)
+	Kind string `json:"kind"`
+
+	// Created is when the checkpoint was created
+	Created time.Time `json:"created"`
+
+	// Stdio are the standard input, output and error of a process (e.g.
+	// "pipe:[1234]"), replaced with new pipes when it is restored
+	Stdio []string `json:"stdio,omitempty"`
+
+	// Engine and Container identify the container of a container checkpoint
+	Engine    string `json:"engine,omitempty"`
+	Container string `json:"container,omitempty"`
+}
+
+// checkpointBackend is implemented by the backends that can save the state
+// of the command to a folder
+type checkpointBackend interface {
+	checkpoint(ctx context.Context, logger Logger, dir string, options CheckpointOptions) (checkpointManifest, error)
+}
+
+// Checkpoint saves the state of the running command to dir, so it can be
+// continued with Restore (e.g. after restarting the service running it).
+// The command is stopped once its state has been saved, unless
+// options.LeaveRunning is set. Checkpoints are experimental.
+//
+// Local processes are checkpointed with CRIU (Linux only, requiring root or
+// CAP_CHECKPOINT_RESTORE), and containers with `docker checkpoint` (which
+// requires the experimental mode of the Docker daemon) or `podman container
+// checkpoint` (which requires rootful podman). ErrNotSupported is returned
+// for other backends.
+func (e *Execution) Checkpoint(ctx context.Context, dir string, options CheckpointOptions) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b, ok := e.backend.(checkpointBackend)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create checkpoint folder: %w", err)
+	}
+
+	e.logger.Debug("Checkpointing execution %s to %s", e.ID, dir)
+	manifest, err := b.checkpoint(ctx, e.logger, dir, options)
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint execution %s: %w", e.ID, err)
+	}
+	manifest.Created = time.Now()
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, checkpointManifestFile), data, 0o600); err != nil {
+		return fmt.Errorf("failed to write checkpoint manifest: %w", err)
+	}
+	return nil
+}
+
+// Restore continues a command from a checkpoint created with Checkpoint,
+// returning a new execution handle for it. Checkpoints are experimental.
+//
+// Restored processes get new pipes for their standard input, output and
+// error. Restored containers run again with the state of the commands
+// executed in them, but their pipes are not restored: Wait returns once the
+// restored commands have exited, without their exit status.
+func Restore(ctx context.Context, dir string) (*Execution, error) {
+	data, err := os.ReadFile(filepath.Join(dir, checkpointManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint manifest: %w", err)
+	}
+	var manifest checkpointManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint manifest: %w", err)
+	}
+
+	logger := defaultLogger(nil)
+	id := newExecutionID()
+	if common.LogIDFromContext(ctx) == "" {
+		ctx = common.WithLogID(ctx, id)
+	}
+	logger = contextLogger(ctx, logger)
+
+	var e *Execution
+	switch manifest.Kind {
+	case checkpointProcess:
+		e, err = restoreProcess(ctx, logger, dir, manifest)
+	case checkpointContainer:
+		e, err = restoreContainer(ctx, logger, dir, manifest)
+	default:
+		err = fmt.Errorf("unknown checkpoint kind %q", manifest.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.ID = id
+	return e, nil
+}
+
+// checkpoint saves the container with `docker checkpoint` (or `podman
+// container checkpoint`)
+func (b *containerBackend) checkpoint(ctx context.Context, logger Logger, dir string, options CheckpointOptions) (checkpointManifest, error) {
+	args := []string{"checkpoint", "create", "--checkpoint-dir", dir}
+	if options.LeaveRunning {
+		args = append(args, "--leave-running")
+	}
+	args = append(args, b.container, checkpointName)
+	if b.engine == EnginePodman {
+		// podman keeps the checkpoint with the container
+		args = []string{"container", "checkpoint", "--keep"}
+		if options.LeaveRunning {
+			args = append(args, "--leave-running")
+		}
+		args = append(args, b.container)
+	}
+	logger.Debug("Checkpointing container: %s %v", b.engine, args)
+	if output, err := exec.CommandContext(ctx, b.engine, args...).CombinedOutput(); err != nil {
+		return checkpointManifest{}, fmt.Errorf("%s checkpoint failed: %w: %s", b.engine, err, strings.TrimSpace(string(output)))
+	}
+	// the stopped container is kept for Restore, which removes it afterwards
+	if !options.LeaveRunning {
+		b.checkpointed.Store(true)
+	}
+	return checkpointManifest{Kind: checkpointContainer, Engine: b.engine, Container: b.container}, nil
+}
+
+// checkpoint is not supported in shared containers, as it would stop the
+// other commands of the session
+func (b *sharedContainerBackend) checkpoint(context.Context, Logger, string, CheckpointOptions) (checkpointManifest, error) {
+	return checkpointManifest{}, ErrNotSupported
+}
+
+// containerRestoreWaitScript waits until the init process (and the script
+// itself) are the only processes left in the container
+const containerRestoreWaitScript = `while :; do n=0; for p in /proc/[0-9]*; do n=$((n+1)); done; [ "$n" -le 2 ] && exit 0; sleep 1; done`
+
+// restoreContainer starts the container from the checkpoint, and waits for
+// the restored commands with a script run in the container
+func restoreContainer(ctx context.Context, logger Logger, dir string, manifest checkpointManifest) (*Execution, error) {
+	args := []string{"start", "--checkpoint", checkpointName, "--checkpoint-dir", dir, manifest.Container}
+	if manifest.Engine == EnginePodman {
+		args = []string{"container", "restore", "--keep", manifest.Container}
+	}
+	logger.Debug("Restoring container: %s %v", manifest.Engine, args)
+	if output, err := exec.CommandContext(ctx, manifest.Engine, args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to restore container %s: %w: %s", manifest.Container, err, strings.TrimSpace(string(output)))
+	}
+
+	removeContainer := func() {
+		if output, err := exec.Command(manifest.Engine, "rm", "-f", manifest.Container).CombinedOutput(); err != nil {
+			logger.Debug("Warning: failed to remove container %s: %v, output: %s", manifest.Container, err, string(output))
+		}
+	}
+	waitCmd := commandContext(ctx, manifest.Engine, "exec", manifest.Container, "sh", "-c", containerRestoreWaitScript)
+	e, err := startProcess(logger, waitCmd, removeContainer)
+	if err != nil {
+		return nil, err
+	}
+	e.backend = &containerBackend{engine: manifest.Engine, container: manifest.Container}
+	return e, nil
+}
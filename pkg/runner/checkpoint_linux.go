@@ -0,0 +1,70 @@
+//go:build linux
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// checkpointImagesDir is the folder of the CRIU images in a checkpoint
+const checkpointImagesDir = "images"
+
+// checkpoint dumps the process tree of the command with CRIU
+func (b *processBackend) checkpoint(ctx context.Context, logger Logger, dir string, options CheckpointOptions) (checkpointManifest, error) {
+	criu, err := exec.LookPath("criu")
+	if err != nil {
+		return checkpointManifest{}, fmt.Errorf("criu is required for checkpointing processes: %w", err)
+	}
+
+	// the pipes of the command are external to the dumped tree, so they are
+	// recorded for replacing them when restoring it
+	stdio := make([]string, 3)
+	for fd := range stdio {
+		if stdio[fd], err = os.Readlink(fmt.Sprintf("/proc/%d/fd/%d", b.pid, fd)); err != nil {
+			return checkpointManifest{}, err
+		}
+	}
+
+	images := filepath.Join(dir, checkpointImagesDir)
+	if err := os.MkdirAll(images, 0o700); err != nil {
+		return checkpointManifest{}, err
+	}
+	// the command is in the session of the runner, so it is dumped as a shell job
+	args := []string{"dump", "--tree", strconv.Itoa(b.pid), "--images-dir", images, "--shell-job",
+		"--log-file", "dump.log"}
+	if options.LeaveRunning {
+		args = append(args, "--leave-running")
+	}
+	logger.Debug("Checkpointing process: criu %v", args)
+	if output, err := exec.CommandContext(ctx, criu, args...).CombinedOutput(); err != nil {
+		return checkpointManifest{}, fmt.Errorf("criu dump failed: %w: %s (see %s)", err,
+			strings.TrimSpace(string(output)), filepath.Join(images, "dump.log"))
+	}
+	return checkpointManifest{Kind: checkpointProcess, Stdio: stdio}, nil
+}
+
+// restoreProcess restores the process tree with CRIU. CRIU stays as the
+// parent of the restored tree, and its own pipes replace those of the
+// command, so the execution is handled like any local process.
+func restoreProcess(ctx context.Context, logger Logger, dir string, manifest checkpointManifest) (*Execution, error) {
+	criu, err := exec.LookPath("criu")
+	if err != nil {
+		return nil, fmt.Errorf("criu is required for restoring processes: %w", err)
+	}
+
+	images := filepath.Join(dir, checkpointImagesDir)
+	args := []string{"restore", "--images-dir", images, "--shell-job", "--log-file", "restore.log"}
+	for fd, file := range manifest.Stdio {
+		if strings.HasPrefix(file, "pipe:") {
+			args = append(args, "--inherit-fd", fmt.Sprintf("fd[%d]:%s", fd, file))
+		}
+	}
+	logger.Debug("Restoring process: criu %v", args)
+	return startProcess(logger, commandContext(ctx, criu, args...), nil)
+}
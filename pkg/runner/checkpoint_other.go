@@ -0,0 +1,13 @@
+//go:build !linux
+
+package runner
+
+import (
+	"context"
+	"fmt"
+)
+
+// restoreProcess is only supported on Linux, where CRIU is available
+func restoreProcess(ctx context.Context, logger Logger, dir string, manifest checkpointManifest) (*Execution, error) {
+	return nil, fmt.Errorf("restoring processes requires Linux: %w", ErrNotSupported)
+}
@@ -0,0 +1,146 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// fakeCheckpointEngine returns a container engine logging its arguments to
+// the returned file, where commands executed in the container exit at once
+func fakeCheckpointEngine(t *testing.T) (string, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	dir := t.TempDir()
+	engine := filepath.Join(dir, "engine")
+	log := filepath.Join(dir, "engine.log")
+	script := "#!/bin/sh\necho \"$*\" >> " + log + "\n"
+	if err := os.WriteFile(engine, []byte(script), 0o755); err != nil {
+		t.Fatalf("failed to write the fake engine: %v", err)
+	}
+	return engine, log
+}
+
+func TestExecution_Checkpoint_container(t *testing.T) {
+	engine, log := fakeCheckpointEngine(t)
+	dir := filepath.Join(t.TempDir(), "checkpoint")
+	ctx := context.Background()
+
+	backend := &containerBackend{engine: engine, container: "test"}
+	e := newExecution(common.GetLogger(), nil, nil, nil, func() error { return nil }, backend)
+	if err := e.Checkpoint(ctx, dir, CheckpointOptions{}); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	if !backend.checkpointed.Load() {
+		t.Errorf("the container stopped by the checkpoint is not kept")
+	}
+	if _, err := os.Stat(filepath.Join(dir, checkpointManifestFile)); err != nil {
+		t.Errorf("the manifest was not written: %v", err)
+	}
+
+	restored, err := Restore(ctx, dir)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	_, _ = io.ReadAll(restored.Stdout)
+	_, _ = io.ReadAll(restored.Stderr)
+	if err := restored.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(log)
+	calls := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []string{
+		"checkpoint create --checkpoint-dir " + dir + " test " + checkpointName,
+		"start --checkpoint " + checkpointName + " --checkpoint-dir " + dir + " test",
+		"exec test sh -c " + containerRestoreWaitScript,
+		"rm -f test",
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("engine calls = %q, want %q", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("engine call %d = %q, want %q", i, calls[i], want[i])
+		}
+	}
+}
+
+func TestExecution_Checkpoint_notSupported(t *testing.T) {
+	e := newExecution(common.GetLogger(), nil, nil, nil, func() error { return nil },
+		&sharedContainerBackend{containerBackend{engine: "docker", container: "test"}})
+	if err := e.Checkpoint(context.Background(), t.TempDir(), CheckpointOptions{}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Checkpoint() of a shared container = %v, want ErrNotSupported", err)
+	}
+
+	e = newExecution(common.GetLogger(), nil, nil, nil, func() error { return nil }, nil)
+	if err := e.Checkpoint(context.Background(), t.TempDir(), CheckpointOptions{}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Checkpoint() without a backend = %v, want ErrNotSupported", err)
+	}
+}
+
+func TestRestore_invalid(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Restore(context.Background(), dir); err == nil {
+		t.Errorf("Restore() without a manifest should fail")
+	}
+	if err := os.WriteFile(filepath.Join(dir, checkpointManifestFile), []byte(`{"kind":"vm"}`), 0o600); err != nil {
+		t.Fatalf("failed to write the manifest: %v", err)
+	}
+	if _, err := Restore(context.Background(), dir); err == nil || !strings.Contains(err.Error(), "vm") {
+		t.Errorf("Restore() of an unknown kind = %v", err)
+	}
+}
+
+func TestExecution_Checkpoint_process(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("CRIU requires Linux")
+	}
+	if _, err := exec.LookPath("criu"); err != nil {
+		t.Skip("criu not installed")
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("CRIU requires root")
+	}
+
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	e, err := Start(context.Background(), r, "sh", []string{"-c", "read line; echo \"got $line\""}, nil, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	dir := t.TempDir()
+	if err := e.Checkpoint(context.Background(), dir, CheckpointOptions{}); err != nil {
+		_ = e.Stdin.Close()
+		_ = e.Wait()
+		t.Skipf("CRIU cannot checkpoint in this environment: %v", err)
+	}
+	_ = e.Wait()
+
+	restored, err := Restore(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	_, _ = io.WriteString(restored.Stdin, "hello\n")
+	_ = restored.Stdin.Close()
+	output, _ := io.ReadAll(restored.Stdout)
+	_, _ = io.ReadAll(restored.Stderr)
+	if err := restored.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if strings.TrimSpace(string(output)) != "got hello" {
+		t.Errorf("restored output = %q", output)
+	}
+}
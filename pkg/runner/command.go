@@ -0,0 +1,30 @@
+package runner
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// commandWaitDelay is the time a cancelled command has to exit after being
+// asked to terminate, before it is killed. It also bounds the time waiting
+// for its output once it has exited, as the pipes can be kept open by
+// children left running in the background.
+const commandWaitDelay = 5 * time.Second
+
+// commandContext is exec.CommandContext, but cancelling the context asks the
+// command (and, when it runs in its own process group, all its children) to
+// terminate with SIGTERM, killing it only if it is still running after
+// commandWaitDelay (or the kill grace period of the context, see
+// TimeoutOptions). Waiting for the command never hangs on output pipes held
+// open by its children for longer: the pipes are closed, and Wait returns
+// exec.ErrWaitDelay if the command had otherwise succeeded.
+func commandContext(ctx context.Context, name string, arg ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, arg...)
+	cmd.Cancel = func() error {
+		return terminateProcess(cmd)
+	}
+	cmd.WaitDelay = killGracePeriodFrom(ctx)
+	reproRecorderFrom(ctx).recordCommand(cmd)
+	return cmd
+}
@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCommandContext_Terminate tests that cancelled commands receive SIGTERM
+func TestCommandContext_Terminate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a Unix shell and signals")
+	}
+
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	script := `trap 'echo terminated; exit 0' TERM; echo ready; while :; do sleep 0.1; done`
+	e, err := Start(ctx, r, "sh", []string{"-c", script}, nil, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	_ = e.Stdin.Close()
+
+	scanner := bufio.NewScanner(e.Stdout)
+	if !scanner.Scan() || scanner.Text() != "ready" {
+		t.Fatalf("expected the command to be ready, got %q", scanner.Text())
+	}
+	cancel()
+	if !scanner.Scan() || scanner.Text() != "terminated" {
+		t.Errorf("expected the command to handle SIGTERM, got %q", scanner.Text())
+	}
+	_ = e.Wait()
+}
+
+// TestCommandContext_Children tests that cancelling a command in its own
+// process group does not wait for its children holding the output open
+func TestCommandContext_Children(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a Unix shell and signals")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := commandContext(ctx, "sh", "-c", "sleep 30 & echo started; wait")
+	setProcessGroup(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "started" {
+		t.Fatalf("expected the command to start, got %q: %v", line, err)
+	}
+
+	start := time.Now()
+	cancel()
+	if err := cmd.Wait(); err == nil {
+		t.Errorf("expected the cancelled command to fail")
+	}
+	if elapsed := time.Since(start); elapsed >= commandWaitDelay {
+		t.Errorf("expected the command and its children to be terminated, waited %v", elapsed)
+	}
+}
@@ -0,0 +1,154 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ConnectSpec is one of the commands connected by Connect
+type ConnectSpec struct {
+	// Runner is the runner used to execute the command
+	Runner Runner
+
+	// Cmd, Args, Env and Params are passed to Start
+	Cmd    string
+	Args   []string
+	Env    []string
+	Params map[string]interface{}
+
+	// Options are additional options for the execution
+	Options []ExecOption
+}
+
+// Connection is a pair of commands where the standard output of the first
+// one (the producer) is streamed to the standard input of the second one
+// (the consumer), as in a shell pipe.
+//
+// The caller writes to From.Stdin (closing it when done, or right away if
+// the producer does not read its input), reads To.Stdout and the standard
+// error of both, and calls Wait.
+type Connection struct {
+	// From is the execution of the producer
+	From *Execution
+	// To is the execution of the consumer
+	To *Execution
+
+	cancelFrom context.CancelFunc
+
+	copyDone     chan struct{}
+	consumerGone bool
+
+	waitOnce sync.Once
+	waitErr  error
+}
+
+// Connect starts two commands, possibly with different runners (e.g. an
+// untrusted parser in a strict sandbox and a trusted validator), streaming
+// the standard output of the first one to the standard input of the second.
+//
+// Writes to the consumer block while it does not read its input, so the
+// producer is slowed down to the pace of the consumer. When the consumer
+// exits (or stops reading its input), the producer is killed, as with
+// SIGPIPE in a shell.
+func Connect(ctx context.Context, from, to ConnectSpec) (*Connection, error) {
+	if from.Runner == nil || to.Runner == nil {
+		return nil, fmt.Errorf("connected commands require a runner")
+	}
+
+	fromCtx, cancelFrom := context.WithCancel(ctx)
+	fromE, err := Start(fromCtx, from.Runner, from.Cmd, from.Args, from.Env, from.Params, from.Options...)
+	if err != nil {
+		cancelFrom()
+		return nil, fmt.Errorf("failed to start producer: %w", err)
+	}
+
+	toE, err := Start(ctx, to.Runner, to.Cmd, to.Args, to.Env, to.Params, to.Options...)
+	if err != nil {
+		cancelFrom()
+		_ = fromE.Stdin.Close()
+		go func() { _, _ = io.Copy(io.Discard, fromE.Stderr) }()
+		_, _ = io.Copy(io.Discard, fromE.Stdout)
+		_ = fromE.Wait()
+		return nil, fmt.Errorf("failed to start consumer: %w", err)
+	}
+
+	c := &Connection{
+		From:       fromE,
+		To:         toE,
+		cancelFrom: cancelFrom,
+		copyDone:   make(chan struct{}),
+	}
+	go c.copy()
+	return c, nil
+}
+
+// copy streams the output of the producer to the consumer
+func (c *Connection) copy() {
+	defer close(c.copyDone)
+
+	_, err := io.Copy(c.To.Stdin, &producerReader{r: c.From.Stdout})
+	if closeErr := c.To.Stdin.Close(); err == nil {
+		err = closeErr
+	}
+
+	var readErr *producerReadError
+	if err != nil && !errors.As(err, &readErr) {
+		// the consumer is gone: stop the producer, and let it exit
+		c.To.logger.Debug("Consumer %s stopped reading its input: %v", c.To.ID, err)
+		c.consumerGone = true
+		c.cancelFrom()
+		_, _ = io.Copy(io.Discard, c.From.Stdout)
+	}
+}
+
+// producerReader marks the errors reading from the producer, to tell them
+// apart from the errors writing to the consumer
+type producerReader struct {
+	r io.Reader
+}
+
+func (p *producerReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err != nil && err != io.EOF {
+		err = &producerReadError{err: err}
+	}
+	return n, err
+}
+
+// producerReadError is an error reading from the producer
+type producerReadError struct {
+	err error
+}
+
+func (e *producerReadError) Error() string { return e.err.Error() }
+func (e *producerReadError) Unwrap() error { return e.err }
+
+// Wait waits for both commands to complete and releases their resources.
+//
+// It returns the error of the consumer if it failed, or the error of the
+// producer (as with pipefail in a shell). A producer killed because the
+// consumer exited successfully without reading all its input (e.g. `head`)
+// is not an error.
+func (c *Connection) Wait() error {
+	c.waitOnce.Do(func() {
+		toErr := c.To.Wait()
+		if toErr != nil {
+			c.cancelFrom()
+		}
+
+		<-c.copyDone
+		fromErr := c.From.Wait()
+		c.cancelFrom()
+
+		switch {
+		case toErr != nil:
+			c.waitErr = fmt.Errorf("consumer failed: %w", toErr)
+		case fromErr != nil && !c.consumerGone:
+			c.waitErr = fmt.Errorf("producer failed: %w", fromErr)
+		}
+	})
+	return c.waitErr
+}
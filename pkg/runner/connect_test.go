@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readConnection closes the input of the producer and reads all the output
+func readConnection(c *Connection) string {
+	_ = c.From.Stdin.Close()
+	go func() { _, _ = io.Copy(io.Discard, c.From.Stderr) }()
+	go func() { _, _ = io.Copy(io.Discard, c.To.Stderr) }()
+	out, _ := io.ReadAll(c.To.Stdout)
+	return string(out)
+}
+
+func TestConnect(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Connect(context.Background(),
+		ConnectSpec{Runner: r, Cmd: "sh", Args: []string{"-c", "printf 'b\\na\\nc\\n'"}},
+		ConnectSpec{Runner: r, Cmd: "sort"})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if out := readConnection(c); out != "a\nb\nc\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+	if err := c.Wait(); err != nil {
+		t.Errorf("Wait failed: %v", err)
+	}
+}
+
+func TestConnect_ConsumerExitsEarly(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the producer would run forever, but is stopped once head exits
+	start := time.Now()
+	c, err := Connect(context.Background(),
+		ConnectSpec{Runner: r, Cmd: "sh", Args: []string{"-c", "while :; do echo y; done"}},
+		ConnectSpec{Runner: r, Cmd: "head", Args: []string{"-n", "2"}})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	if out := readConnection(c); out != "y\ny\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+	if err := c.Wait(); err != nil {
+		t.Errorf("Wait failed: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("the producer was not stopped")
+	}
+}
+
+func TestConnect_Errors(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{"producer fails", "echo data; exit 2", "cat > /dev/null", "producer failed"},
+		{"consumer fails", "echo data", "cat > /dev/null; exit 3", "consumer failed"},
+	}
+	for _, tt := range tests {
+		c, err := Connect(context.Background(),
+			ConnectSpec{Runner: r, Cmd: "sh", Args: []string{"-c", tt.from}},
+			ConnectSpec{Runner: r, Cmd: "sh", Args: []string{"-c", tt.to}})
+		if err != nil {
+			t.Fatalf("%s: Connect failed: %v", tt.name, err)
+		}
+		readConnection(c)
+		if err := c.Wait(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CoreDumpPolicy is what happens to the core dumps of the crashing commands
+type CoreDumpPolicy string
+
+const (
+	// CoreDumpsInherit keeps the core file size limit of the current process
+	CoreDumpsInherit CoreDumpPolicy = ""
+
+	// CoreDumpsDisabled sets the core file size limit of the command to 0
+	CoreDumpsDisabled CoreDumpPolicy = "disabled"
+
+	// CoreDumpsCapture moves the core dumps of the command to a folder
+	CoreDumpsCapture CoreDumpPolicy = "capture"
+)
+
+// CoreDumpOptions is the core dump policy of the runners that execute
+// commands on Unix hosts. It is embedded in their options, so its fields are
+// set with the same keys as any other option.
+type CoreDumpOptions struct {
+	// CoreDumps is the core dump policy ("disabled" or "capture").
+	// When empty the command inherits the core file size limit of the current process.
+	CoreDumps CoreDumpPolicy `json:"core_dumps"`
+
+	// CoreDumpDir is the folder the core dumps are moved to with "capture"
+	CoreDumpDir string `json:"core_dump_dir"`
+
+	// CoreDumpMaxSize is the maximum size of a core dump (e.g. "256m"), set
+	// as the core file size limit of the command
+	CoreDumpMaxSize string `json:"core_dump_max_size"`
+
+	// CoreDumpDirMaxSize is the maximum total size of CoreDumpDir (e.g. "1g"):
+	// the oldest dumps are removed to make room for the new ones
+	CoreDumpDirMaxSize string `json:"core_dump_dir_max_size"`
+}
+
+// validateCoreDumps checks the core dump policy and its sizes
+func (o CoreDumpOptions) validateCoreDumps() error {
+	switch o.CoreDumps {
+	case CoreDumpsInherit, CoreDumpsDisabled, CoreDumpsCapture:
+	default:
+		return fmt.Errorf("invalid core_dumps %q: must be %q or %q", o.CoreDumps, CoreDumpsDisabled, CoreDumpsCapture)
+	}
+	if _, err := parseByteSize(o.CoreDumpMaxSize); err != nil {
+		return fmt.Errorf("invalid core_dump_max_size: %w", err)
+	}
+	if _, err := parseByteSize(o.CoreDumpDirMaxSize); err != nil {
+		return fmt.Errorf("invalid core_dump_dir_max_size: %w", err)
+	}
+	if o.CoreDumps == CoreDumpsDisabled && o.CoreDumpMaxSize != "" {
+		return fmt.Errorf("core_dump_max_size cannot be used with disabled core dumps")
+	}
+	if o.CoreDumps == CoreDumpsCapture {
+		if !filepath.IsAbs(o.CoreDumpDir) {
+			return fmt.Errorf("capturing core dumps requires an absolute core_dump_dir")
+		}
+	} else if o.CoreDumpDir != "" || o.CoreDumpDirMaxSize != "" {
+		return fmt.Errorf("core_dump_dir and core_dump_dir_max_size require capturing core dumps")
+	}
+	return nil
+}
+
+// parseByteSize parses a size in bytes, with an optional unit ("b", "k",
+// "m", "g" or "t", in powers of 1024), returning 0 for an empty one
+func parseByteSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	number, unit := strings.ToLower(size), int64(1)
+	for i, suffix := range []string{"b", "k", "m", "g", "t"} {
+		if strings.HasSuffix(number, suffix) {
+			number, unit = strings.TrimSuffix(number, suffix), int64(1)<<(10*i)
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/unit {
+		return 0, fmt.Errorf("invalid size %q: must be a number of bytes, optionally followed by b, k, m, g or t", size)
+	}
+	return n * unit, nil
+}
+
+// coreLimit returns the core file size limit of the command, in the 512
+// bytes blocks of the ulimit builtin of POSIX shells, or "" when the limit
+// is inherited
+func (o CoreDumpOptions) coreLimit() string {
+	maxSize, _ := parseByteSize(o.CoreDumpMaxSize)
+	switch {
+	case o.CoreDumps == CoreDumpsDisabled:
+		return "0"
+	case maxSize > 0:
+		return strconv.FormatInt((maxSize+511)/512, 10)
+	case o.CoreDumps == CoreDumpsCapture:
+		// the soft limit is usually 0: raise it to the hard limit
+		return `"$(ulimit -H -c)"`
+	}
+	return ""
+}
+
+// dockerUlimit returns the core ulimit of containers (in bytes), or "" when
+// the limit is inherited from the docker daemon
+func (o CoreDumpOptions) dockerUlimit() string {
+	maxSize, _ := parseByteSize(o.CoreDumpMaxSize)
+	switch {
+	case o.CoreDumps == CoreDumpsDisabled:
+		return "core=0"
+	case maxSize > 0:
+		return fmt.Sprintf("core=%d", maxSize)
+	}
+	return ""
+}
+
+// coreLimitScript is the shell script that sets the core file size limit
+// and executes its arguments, with the command as $0 (see umaskScript)
+const coreLimitScript = `ulimit -c %s && exec "$0" "$@"`
+
+// applyCoreDumps makes a command, not started yet, run with the core dump
+// policy, and returns the function to call once the command has completed,
+// which moves its core dumps to the capture folder.
+//
+// The limit is set for both the soft and the hard limits, so the command
+// cannot raise it.
+func (o CoreDumpOptions) applyCoreDumps(logger Logger, execCmd *exec.Cmd) func() {
+	limit := o.coreLimit()
+	if limit == "" || runtime.GOOS == "windows" || execCmd.Err != nil {
+		return func() {}
+	}
+	logger.Debug("Running command with core file size limit %s", limit)
+	execCmd.Args = append([]string{"/bin/sh", "-c", fmt.Sprintf(coreLimitScript, limit), execCmd.Path}, execCmd.Args[1:]...)
+	execCmd.Path = "/bin/sh"
+
+	if o.CoreDumps != CoreDumpsCapture {
+		return func() {}
+	}
+	dir := execCmd.Dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	started := time.Now().Truncate(time.Second)
+	return func() {
+		o.captureCoreDumps(logger, dir, started)
+	}
+}
+
+// captureCoreDumps moves the core dumps written since the command started
+// to the capture folder, and removes the oldest dumps of the folder over its
+// maximum size. dir is the working directory of the command, where the
+// kernel writes the core dumps with a relative core pattern.
+func (o CoreDumpOptions) captureCoreDumps(logger Logger, dir string, started time.Time) {
+	pattern, err := corePattern()
+	if err != nil {
+		logger.Debug("Cannot capture core dumps: %v", err)
+		return
+	}
+	if strings.HasPrefix(pattern, "|") {
+		logger.Debug("Cannot capture core dumps: they are piped to %s", strings.Fields(pattern[1:])[0])
+		return
+	}
+
+	glob := corePatternGlob(pattern)
+	if !filepath.IsAbs(glob) {
+		glob = filepath.Join(dir, glob)
+	}
+	matches, _ := filepath.Glob(glob)
+
+	var captured bool
+	for _, path := range matches {
+		info, err := os.Lstat(path)
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().Before(started) {
+			continue
+		}
+		if err := os.MkdirAll(o.CoreDumpDir, 0o700); err != nil {
+			logger.Error("Failed to create the core dump folder: %v", err)
+			return
+		}
+		dest := filepath.Join(o.CoreDumpDir, started.Format("20060102T150405")+"-"+filepath.Base(path))
+		if err := moveFile(path, dest); err != nil {
+			logger.Error("Failed to capture the core dump %s: %v", path, err)
+			continue
+		}
+		logger.Info("Captured core dump %s (%d bytes)", dest, info.Size())
+		captured = true
+	}
+	if captured {
+		o.pruneCoreDumps(logger)
+	}
+}
+
+// corePatternGlob returns the glob matching the files of a core pattern,
+// with every specifier (e.g. %p, the PID) matching anything
+func corePatternGlob(pattern string) string {
+	var glob strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '%' && i+1 < len(pattern) && pattern[i+1] == '%':
+			glob.WriteByte('%')
+			i++
+		case c == '%':
+			glob.WriteByte('*')
+			i++
+		case strings.IndexByte(`*?[\`, c) >= 0:
+			glob.WriteByte('\\')
+			glob.WriteByte(c)
+		default:
+			glob.WriteByte(c)
+		}
+	}
+	return glob.String()
+}
+
+// moveFile moves a file, copying it when it is on another filesystem
+func moveFile(src, dest string) error {
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		_ = os.Remove(dest)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(dest)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// pruneCoreDumps removes the oldest core dumps of the capture folder until
+// it fits in its maximum size
+func (o CoreDumpOptions) pruneCoreDumps(logger Logger) {
+	maxSize, _ := parseByteSize(o.CoreDumpDirMaxSize)
+	if maxSize == 0 {
+		return
+	}
+	entries, err := os.ReadDir(o.CoreDumpDir)
+	if err != nil {
+		return
+	}
+
+	var dumps []os.FileInfo
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		dumps = append(dumps, info)
+		total += info.Size()
+	}
+	sort.Slice(dumps, func(i, j int) bool { return dumps[i].ModTime().Before(dumps[j].ModTime()) })
+
+	for _, dump := range dumps {
+		if total <= maxSize {
+			break
+		}
+		path := filepath.Join(o.CoreDumpDir, dump.Name())
+		if err := os.Remove(path); err != nil {
+			logger.Debug("Failed to remove the core dump %s: %v", path, err)
+			continue
+		}
+		logger.Info("Removed core dump %s: the core dump folder exceeds %s", path, o.CoreDumpDirMaxSize)
+		total -= dump.Size()
+	}
+}
@@ -0,0 +1,21 @@
+package runner
+
+import (
+	"os"
+	"strings"
+)
+
+// corePattern returns the pattern of the core dump files of the kernel
+// (see core(5)), with the PID appended as core_uses_pid does
+func corePattern() (string, error) {
+	data, err := os.ReadFile("/proc/sys/kernel/core_pattern")
+	if err != nil {
+		return "", err
+	}
+	pattern := strings.TrimSpace(string(data))
+	if usesPID, err := os.ReadFile("/proc/sys/kernel/core_uses_pid"); err == nil &&
+		strings.TrimSpace(string(usesPID)) == "1" && !strings.HasPrefix(pattern, "|") && !strings.Contains(pattern, "%p") {
+		pattern += ".%p"
+	}
+	return pattern, nil
+}
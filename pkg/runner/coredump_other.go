@@ -0,0 +1,17 @@
+//go:build !linux
+
+package runner
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// corePattern returns the pattern of the core dump files: the default of
+// macOS (kern.corefile), where the folder must be writable by the user
+func corePattern() (string, error) {
+	if runtime.GOOS != "darwin" {
+		return "", fmt.Errorf("core dumps cannot be captured on %s: %w", runtime.GOOS, ErrNotSupported)
+	}
+	return "/cores/core.%P", nil
+}
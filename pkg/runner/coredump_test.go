@@ -0,0 +1,204 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{size: "", want: 0},
+		{size: "512", want: 512},
+		{size: "100b", want: 100},
+		{size: "64k", want: 64 << 10},
+		{size: "256M", want: 256 << 20},
+		{size: "2g", want: 2 << 30},
+		{size: "1t", want: 1 << 40},
+		{size: "-1", wantErr: true},
+		{size: "1.5g", wantErr: true},
+		{size: "lots", wantErr: true},
+		{size: "99999999999t", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			got, err := parseByteSize(tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseByteSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseByteSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoreDumpOptions_validateCoreDumps(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    CoreDumpOptions
+		wantErr bool
+	}{
+		{name: "inherit", opts: CoreDumpOptions{}},
+		{name: "disabled", opts: CoreDumpOptions{CoreDumps: CoreDumpsDisabled}},
+		{name: "max size", opts: CoreDumpOptions{CoreDumpMaxSize: "64m"}},
+		{name: "capture", opts: CoreDumpOptions{CoreDumps: CoreDumpsCapture, CoreDumpDir: "/var/crash", CoreDumpDirMaxSize: "1g"}},
+		{name: "unknown policy", opts: CoreDumpOptions{CoreDumps: "keep"}, wantErr: true},
+		{name: "invalid size", opts: CoreDumpOptions{CoreDumpMaxSize: "big"}, wantErr: true},
+		{name: "disabled with size", opts: CoreDumpOptions{CoreDumps: CoreDumpsDisabled, CoreDumpMaxSize: "1m"}, wantErr: true},
+		{name: "capture without dir", opts: CoreDumpOptions{CoreDumps: CoreDumpsCapture}, wantErr: true},
+		{name: "capture relative dir", opts: CoreDumpOptions{CoreDumps: CoreDumpsCapture, CoreDumpDir: "crash"}, wantErr: true},
+		{name: "dir without capture", opts: CoreDumpOptions{CoreDumpDir: "/var/crash"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.validateCoreDumps()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateCoreDumps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCoreDumpOptions_dockerUlimit(t *testing.T) {
+	tests := []struct {
+		opts CoreDumpOptions
+		want string
+	}{
+		{opts: CoreDumpOptions{}, want: ""},
+		{opts: CoreDumpOptions{CoreDumps: CoreDumpsDisabled}, want: "core=0"},
+		{opts: CoreDumpOptions{CoreDumpMaxSize: "1k"}, want: "core=1024"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.opts.dockerUlimit(); got != tt.want {
+			t.Errorf("dockerUlimit(%+v) = %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestCorePatternGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{pattern: "core", want: "core"},
+		{pattern: "core.%p", want: "core.*"},
+		{pattern: "/var/crash/%e-%p-%t.core", want: "/var/crash/*-*-*.core"},
+		{pattern: "100%%-[%p]", want: `100%-\[*]`},
+	}
+
+	for _, tt := range tests {
+		if got := corePatternGlob(tt.pattern); got != tt.want {
+			t.Errorf("corePatternGlob(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestCoreDumpOptions_pruneCoreDumps(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"old", "middle", "new"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, 1024), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := now.Add(time.Duration(i-3) * time.Minute)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	o := CoreDumpOptions{CoreDumps: CoreDumpsCapture, CoreDumpDir: dir, CoreDumpDirMaxSize: "2k"}
+	o.pruneCoreDumps(defaultLogger(nil))
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, ",") != "middle,new" {
+		t.Errorf("pruneCoreDumps() kept %v, want the two newest dumps", names)
+	}
+}
+
+func TestExec_coreDumpsDisabled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("core dumps are not supported on Windows")
+	}
+
+	r, err := NewExec(Options{"core_dumps": "disabled"}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	// both limits are set, so the command cannot raise them
+	output, err := r.Run(context.Background(), "", "ulimit -c; ulimit -H -c", nil, nil, false)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if output != "0\n0" {
+		t.Errorf("core file size limits = %q, want 0", output)
+	}
+}
+
+func TestExec_coreDumpMaxSize(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the limits are read from /proc")
+	}
+
+	r, err := NewExec(Options{"core_dump_max_size": "64k"}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	output, err := r.Run(context.Background(), "", "grep 'Max core file size' /proc/self/limits", nil, nil, false)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if fields := strings.Fields(output); len(fields) < 6 || fields[4] != "65536" || fields[5] != "65536" {
+		t.Errorf("core file size limits = %q, want 65536 bytes", output)
+	}
+}
+
+func TestExec_coreDumpsCapture(t *testing.T) {
+	pattern, err := corePattern()
+	if err != nil || strings.HasPrefix(pattern, "|") || filepath.IsAbs(pattern) {
+		t.Skipf("core dumps are not written to the working directory (pattern %q, error %v)", pattern, err)
+	}
+	// the core dumps left behind if the capture fails
+	t.Cleanup(func() {
+		leftovers, _ := filepath.Glob(corePatternGlob(pattern))
+		for _, path := range leftovers {
+			_ = os.Remove(path)
+		}
+	})
+
+	dir := filepath.Join(t.TempDir(), "cores")
+	r, err := NewExec(Options{"core_dumps": "capture", "core_dump_dir": dir}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	if _, err := r.Run(context.Background(), "", "kill -SEGV $$", nil, nil, false); err == nil {
+		t.Fatalf("Run should fail when the command crashes")
+	}
+
+	dumps, _ := os.ReadDir(dir)
+	if len(dumps) == 0 {
+		t.Skip("no core dump was written (the hard core file size limit may be 0)")
+	}
+	if len(dumps) != 1 {
+		t.Errorf("captured %d core dumps, want 1", len(dumps))
+	}
+}
@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"errors"
+	"io/fs"
+	"os/exec"
+)
+
+// ErrPermissionDenied is matched (with errors.Is) by the errors of commands
+// that were denied an operation by their sandbox (see IsPermissionDenied)
+var ErrPermissionDenied = errors.New("permission denied")
+
+// exitCodeCannotExecute is the exit status of shells (and of `docker run`)
+// when a command is found but cannot be executed, e.g. because the sandbox
+// denies executing it
+const exitCodeCannotExecute = 126
+
+// commandError is the error of a command that failed writing to stderr.
+// Its message is the output of the command, and it wraps the error of the
+// process, so the failure can be classified without parsing the output.
+type commandError struct {
+	stderr string
+	err    error
+}
+
+// newCommandError returns the error of a command that failed with err,
+// writing stderr
+func newCommandError(stderr string, err error) error {
+	return &commandError{stderr: stderr, err: err}
+}
+
+func (e *commandError) Error() string { return e.stderr }
+
+func (e *commandError) Unwrap() error { return e.err }
+
+// IsPermissionDenied returns whether err shows that a command was denied an
+// operation by its sandbox.
+//
+// The error is classified from the error number or the exit status of the
+// command, never from its output, so it works with any locale and with
+// minimal userlands (e.g. BusyBox in containers):
+//
+//   - EACCES or EPERM, e.g. when the command itself cannot be started
+//   - exit status 126, returned by shells when a command cannot be executed
+//   - killed by SIGSYS, the signal of seccomp filters (not on Windows)
+//
+// Commands that handle a denied operation themselves (e.g. a script
+// reporting that a file cannot be read) exit with their own status, which
+// cannot be told apart from other failures.
+//
+// The errors matching ErrQuarantined or ErrTCCDenied are not denials of the
+// sandbox, but of macOS, and return false.
+func IsPermissionDenied(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrQuarantined) || errors.Is(err, ErrTCCDenied) {
+		// denied by macOS, not by the sandbox
+		return false
+	}
+	if errors.Is(err, ErrPermissionDenied) || errors.Is(err, fs.ErrPermission) {
+		return true
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.ExitCode() == exitCodeCannotExecute {
+			return true
+		}
+		return killedBySeccomp(exitErr)
+	}
+	var containerErr *containerExitError
+	return errors.As(err, &containerErr) && containerErr.code == exitCodeCannotExecute
+}
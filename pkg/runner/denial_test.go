@@ -0,0 +1,73 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestIsPermissionDenied(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(command string) error {
+		_, err := r.Run(context.Background(), "sh", command, nil, nil, false)
+		return err
+	}
+
+	notExecutable := filepath.Join(t.TempDir(), "script.sh")
+	if err := os.WriteFile(notExecutable, []byte("#!/bin/sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, startErr := Start(context.Background(), r, notExecutable, nil, nil, nil)
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "sentinel", err: fmt.Errorf("wrapped: %w", ErrPermissionDenied), want: true},
+		{name: "errno", err: &fs.PathError{Op: "open", Path: "/etc/shadow", Err: fs.ErrPermission}, want: true},
+		{name: "not executable", err: startErr, want: true},
+		{name: "cannot execute status", err: run("echo 'Zugriff verweigert' >&2; exit 126"), want: true},
+		{name: "killed by seccomp", err: run("kill -SYS $$"), want: true},
+		{name: "other failure", err: run("echo 'Permission denied' >&2; exit 1"), want: false},
+		{name: "other error", err: errors.New("permission denied"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPermissionDenied(tt.err); got != tt.want {
+				t.Errorf("IsPermissionDenied(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.Run(context.Background(), "sh", "echo 'no such thing' >&2; exit 3", nil, nil, false)
+	if err == nil || err.Error() != "no such thing" {
+		t.Fatalf("expected the stderr of the command as the error message, got %v", err)
+	}
+	if exitCode(err) != 3 {
+		t.Errorf("expected the exit status to be kept, got %d", exitCode(err))
+	}
+}
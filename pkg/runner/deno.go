@@ -0,0 +1,392 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// denoScriptExtensions are the file extensions Run treats as script references
+var denoScriptExtensions = []string{".js", ".mjs", ".cjs", ".jsx", ".ts", ".mts", ".cts", ".tsx"}
+
+// Deno implements the Runner interface for JavaScript/TypeScript tools using Deno.
+//
+// Restrictions are enforced by the Deno permission system: the runner derives
+// the `--allow-*` flags from the same restriction options used by the other
+// runners (allow_read_folders, allow_write_folders, allow_networking...), and
+// everything not explicitly allowed is denied without prompting.
+type Deno struct {
+	logger  Logger
+	options DenoOptions
+}
+
+// DenoOptions is the options for the Deno runner
+type DenoOptions struct {
+	// DenoPath is the deno executable to use (defaults to "deno" in PATH)
+	DenoPath string `json:"deno_path"`
+
+	// Filesystem access
+	AllowReadFolders  []string `json:"allow_read_folders"`
+	AllowWriteFolders []string `json:"allow_write_folders"`
+	AllowReadFiles    []string `json:"allow_read_files"`
+	AllowWriteFiles   []string `json:"allow_write_files"`
+
+	// Network access: AllowNetworking grants unrestricted access, while
+	// AllowNetHosts grants access to specific hosts ("host" or "host:port")
+	AllowNetworking bool     `json:"allow_networking"`
+	AllowNetHosts   []string `json:"allow_net_hosts"`
+
+	// AllowEnv lists extra environment variables the script can read. The
+	// variables passed in the env parameter are always readable.
+	AllowEnv []string `json:"allow_env"`
+
+	// AllowRun lists the subprocesses the script can spawn
+	AllowRun []string `json:"allow_run"`
+
+	// ReportFileChanges records the files changed in AllowWriteFolders (see Execution.FileChanges)
+	ReportFileChanges bool `json:"report_file_changes"`
+
+	// Clock and locale settings
+	DeterminismOptions
+
+	// Variables of the commands read from .env files
+	EnvFileOptions
+
+	// Experimental features
+	ExperimentalOptions
+
+	// CA certificates trusted by the command
+	CABundleOptions
+
+	// Access to the credential agents of the host
+	AgentOptions
+
+	// Access to the display server of the host
+	DisplayOptions
+
+	// Timeout of the commands
+	TimeoutOptions
+}
+
+// NewDenoOptions creates a new DenoOptions from Options
+func NewDenoOptions(options Options) (DenoOptions, error) {
+	var opts DenoOptions
+	jsonStr, err := options.ToJSON()
+	if err != nil {
+		return DenoOptions{}, err
+	}
+	err = json.Unmarshal([]byte(jsonStr), &opts)
+	return opts, err
+}
+
+// NewDeno creates a new Deno runner with the provided logger.
+// If logger is nil, a default logger is created.
+func NewDeno(options Options, logger Logger) (*Deno, error) {
+	logger = defaultLogger(logger)
+
+	denoOpts, err := NewDenoOptions(options)
+	if err != nil {
+		logger.Debug("Failed to parse deno options: %v", err)
+		return nil, fmt.Errorf("failed to parse deno options: %w", err)
+	}
+	if err := denoOpts.resolveFakeTime(); err != nil {
+		return nil, fmt.Errorf("invalid deno options: %w", err)
+	}
+	if err := denoOpts.validateCABundle(); err != nil {
+		return nil, fmt.Errorf("invalid deno options: %w", err)
+	}
+	if err := denoOpts.validateTimeouts(); err != nil {
+		return nil, fmt.Errorf("invalid deno options: %w", err)
+	}
+	if err := denoOpts.loadEnvFiles(); err != nil {
+		return nil, fmt.Errorf("invalid deno options: %w", err)
+	}
+
+	return &Deno{
+		logger:  logger,
+		options: denoOpts,
+	}, nil
+}
+
+// denoPath returns the deno executable to use
+func (r *Deno) denoPath() string {
+	if r.options.DenoPath != "" {
+		return r.options.DenoPath
+	}
+	return "deno"
+}
+
+// loopbackNetFlags replaces the network permissions in flags with access to localhost only
+func loopbackNetFlags(flags []string) []string {
+	var result []string
+	for _, flag := range flags {
+		if flag != "--allow-net" && !strings.HasPrefix(flag, "--allow-net=") {
+			result = append(result, flag)
+		}
+	}
+	return append(result, "--allow-net=localhost,127.0.0.1,[::1]")
+}
+
+// permissionFlags translates the restriction options into Deno permission flags.
+// Template variables in paths are replaced with the given params, and the names
+// of the variables in env are added to the readable environment.
+func (r *Deno) permissionFlags(env []string, params map[string]interface{}) []string {
+	flags := []string{"--no-prompt"}
+
+	readPaths := append(common.ProcessTemplateListFlexible(r.options.AllowReadFolders, params),
+		common.ProcessTemplateListFlexible(r.options.AllowReadFiles, params)...)
+	readPaths = withWritableDirs(withInputsDir(readPaths, params), params)
+	if r.options.CABundle != "" {
+		readPaths = append(readPaths, r.options.CABundle)
+	}
+	if len(readPaths) > 0 {
+		flags = append(flags, "--allow-read="+strings.Join(readPaths, ","))
+	}
+
+	writePaths := append(common.ProcessTemplateListFlexible(r.options.AllowWriteFolders, params),
+		common.ProcessTemplateListFlexible(r.options.AllowWriteFiles, params)...)
+	writePaths = withWritableDirs(writePaths, params)
+	if len(writePaths) > 0 {
+		flags = append(flags, "--allow-write="+strings.Join(writePaths, ","))
+	}
+
+	if r.options.AllowNetworking {
+		flags = append(flags, "--allow-net")
+	} else if len(r.options.AllowNetHosts) > 0 {
+		flags = append(flags, "--allow-net="+strings.Join(r.options.AllowNetHosts, ","))
+	}
+
+	envNames := append([]string{}, r.options.AllowEnv...)
+	for _, e := range env {
+		name := strings.SplitN(e, "=", 2)[0]
+		if name != "" && !contains(envNames, name) {
+			envNames = append(envNames, name)
+		}
+	}
+	if len(envNames) > 0 {
+		flags = append(flags, "--allow-env="+strings.Join(envNames, ","))
+	}
+
+	if len(r.options.AllowRun) > 0 {
+		flags = append(flags, "--allow-run="+strings.Join(r.options.AllowRun, ","))
+	}
+
+	return flags
+}
+
+// isDenoScriptReference checks if the command is a single script path or URL
+// (instead of inline source code)
+func isDenoScriptReference(command string) bool {
+	cmd := strings.TrimSpace(command)
+	if cmd == "" || strings.ContainsAny(cmd, " \t\n;(){}'\"`") {
+		return false
+	}
+	for _, ext := range denoScriptExtensions {
+		if strings.HasSuffix(cmd, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run executes a JavaScript/TypeScript program with Deno and returns the output.
+// It implements the Runner interface.
+//
+// The command is either a reference to a script (a local path or a URL ending
+// in a known JS/TS extension) or inline source code, which is written to a
+// temporary TypeScript module before being executed.
+//
+// note: shell and tmpfile are ignored for deno
+func (r *Deno) Run(ctx context.Context, shell string, command string,
+	env []string, params map[string]interface{}, tmpfile bool,
+) (string, error) {
+	return r.options.runWithTimeout(ctx, func(ctx context.Context) (string, error) {
+		return r.run(ctx, shell, command, env, params, tmpfile)
+	})
+}
+
+// run is Run, with the context bounded by the timeout options
+func (r *Deno) run(ctx context.Context, shell string, command string,
+	env []string, params map[string]interface{}, tmpfile bool,
+) (string, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+	env = r.options.caBundleEnv(env, r.options.CABundle)
+
+	// Check if context is done
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	default:
+		// Continue execution
+	}
+
+	script := strings.TrimSpace(command)
+	if !isDenoScriptReference(script) {
+		tmpScript, err := os.CreateTemp("", "deno-command-*.ts")
+		if err != nil {
+			logger.Debug("Failed to create temporary script file: %v", err)
+			return "", fmt.Errorf("failed to create temporary script file: %w", err)
+		}
+		script = tmpScript.Name()
+
+		// Ensure temporary file is deleted when this function exits
+		defer func() {
+			if err := os.Remove(script); err != nil {
+				logger.Debug("Warning: failed to remove temporary script file: %v", err)
+			}
+		}()
+
+		if _, err := tmpScript.WriteString(command); err != nil {
+			_ = tmpScript.Close()
+			logger.Debug("Failed to write temporary script file: %v", err)
+			return "", fmt.Errorf("failed to write temporary script file: %w", err)
+		}
+		if err := tmpScript.Close(); err != nil {
+			logger.Debug("Failed to close temporary script file: %v", err)
+			return "", fmt.Errorf("failed to close temporary script file: %w", err)
+		}
+		logger.Debug("Created temporary script file at: %s", script)
+	}
+
+	args := append([]string{"run"}, r.permissionFlags(env, params)...)
+	args = append(args, script)
+
+	execCmd := commandContext(ctx, r.denoPath(), args...)
+	logger.Debug("Created command: %s", execCmd.String())
+
+	// Set environment variables if provided
+	if len(env) > 0 {
+		logger.Debug("Adding %d environment variables to command", len(env))
+		for _, e := range env {
+			categoryLogger(logger, LogCategoryEnvironment).Debug("... adding environment variable: %s", e)
+		}
+		execCmd.Env = append(os.Environ(), env...)
+	}
+	execCmd.Env = r.options.agentEnv(execCmd.Env)
+	execCmd.Env = r.options.scrubDisplayEnv(execCmd.Env)
+
+	// Capture output
+	var stdout, stderr bytes.Buffer
+	execCmd.Stdout, execCmd.Stderr = captureRunOutput(ctx, &stdout, &stderr)
+
+	// Run the command
+	logger.Debug("Executing command")
+
+	if err := execCmd.Run(); err != nil {
+		// If there's error output, include it in the error
+		if stderr.Len() > 0 {
+			errMsg := strings.TrimSpace(stderr.String())
+			logger.Debug("Command failed with stderr: %s", errMsg)
+			return "", newCommandError(errMsg, err)
+		}
+		logger.Debug("Command failed with error: %v", err)
+		return "", err
+	}
+
+	// Get the output
+	outputStr := strings.TrimSpace(stdout.String())
+
+	logger.Debug("Command executed successfully, output length: %d bytes", len(outputStr))
+	if stderr.Len() > 0 {
+		logger.Debug("Command generated stderr (but no error): %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return outputStr, nil
+}
+
+// RunEx executes a command with Deno like Run, returning its exit code and
+// both output streams. It implements the Runner interface.
+func (r *Deno) RunEx(ctx context.Context, req RunRequest) (*RunResult, error) {
+	return runEx(ctx, r, req)
+}
+
+// RunWithPipes executes a Deno script with access to stdin/stdout/stderr pipes.
+// It implements the Runner interface for interactive process communication.
+//
+// The cmd parameter is the script to run (a local path or a URL) and args are
+// passed to the script (available as Deno.args).
+func (r *Deno) RunWithPipes(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (
+	stdin io.WriteCloser,
+	stdout io.ReadCloser,
+	stderr io.ReadCloser,
+	wait func() error,
+	err error,
+) {
+	e, err := r.start(ctx, cmd, args, env, params)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return e.Stdin, e.Stdout, e.Stderr, e.Wait, nil
+}
+
+// start executes a command with deno and returns an execution handle for it.
+// It is used by RunWithPipes and Start.
+func (r *Deno) start(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	return r.options.startWithTimeout(ctx, func(ctx context.Context) (*Execution, error) {
+		return r.startCommand(ctx, cmd, args, env, params)
+	})
+}
+
+// startCommand is start, with the context bounded by the timeout options
+func (r *Deno) startCommand(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+	env = r.options.caBundleEnv(env, r.options.CABundle)
+
+	// Check if context is already done
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+		// Continue execution
+	}
+
+	logger.Debug("RunWithPipes: executing script with deno: %s with args: %v", cmd, args)
+
+	flags := r.permissionFlags(env, params)
+	if loopbackNetworkFrom(ctx) {
+		flags = loopbackNetFlags(flags)
+	}
+	denoArgs := append([]string{"run"}, flags...)
+	denoArgs = append(denoArgs, cmd)
+	denoArgs = append(denoArgs, args...)
+
+	execCmd := commandContext(ctx, r.denoPath(), denoArgs...)
+
+	// Set environment variables if provided
+	if len(env) > 0 {
+		logger.Debug("Adding %d environment variables to command", len(env))
+		execCmd.Env = append(os.Environ(), env...)
+	}
+	execCmd.Env = r.options.agentEnv(execCmd.Env)
+	execCmd.Env = r.options.scrubDisplayEnv(execCmd.Env)
+	execCmd.ExtraFiles = extraFilesFrom(ctx)
+
+	return startProcess(logger, execCmd, nil)
+}
+
+// fileChangeFolders returns the writable folders when file changes must be reported
+func (r *Deno) fileChangeFolders(params map[string]interface{}) []string {
+	if !r.options.ReportFileChanges {
+		return nil
+	}
+	return common.ProcessTemplateListFlexible(r.options.AllowWriteFolders, params)
+}
+
+// CheckImplicitRequirements checks if the runner meets its implicit requirements.
+// Deno runner requires the deno executable.
+func (r *Deno) CheckImplicitRequirements() error {
+	if !common.CheckExecutableExists(r.denoPath()) {
+		return fmt.Errorf("deno executable not found in PATH")
+	}
+	return nil
+}
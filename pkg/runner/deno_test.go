@@ -0,0 +1,124 @@
+package runner
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+func TestDeno_permissionFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		env     []string
+		params  map[string]interface{}
+		want    []string
+	}{
+		{
+			name:    "deny everything by default",
+			options: Options{},
+			want:    []string{"--no-prompt"},
+		},
+		{
+			name: "filesystem access with templates",
+			options: Options{
+				"allow_read_folders":  []interface{}{"{{.project}}"},
+				"allow_read_files":    []interface{}{"/etc/hosts"},
+				"allow_write_folders": []interface{}{"/tmp/out"},
+			},
+			params: map[string]interface{}{"project": "/home/user/project"},
+			want: []string{
+				"--no-prompt",
+				"--allow-read=/home/user/project,/etc/hosts",
+				"--allow-write=/tmp/out",
+			},
+		},
+		{
+			name: "specific hosts, env and subprocesses",
+			options: Options{
+				"allow_net_hosts": []interface{}{"api.example.com:443"},
+				"allow_env":       []interface{}{"HOME"},
+				"allow_run":       []interface{}{"git"},
+			},
+			env: []string{"TOKEN=secret", "HOME=/root"},
+			want: []string{
+				"--no-prompt",
+				"--allow-net=api.example.com:443",
+				"--allow-env=HOME,TOKEN",
+				"--allow-run=git",
+			},
+		},
+		{
+			name: "unrestricted networking wins over host list",
+			options: Options{
+				"allow_networking": true,
+				"allow_net_hosts":  []interface{}{"api.example.com"},
+			},
+			want: []string{"--no-prompt", "--allow-net"},
+		},
+	}
+
+	logger, _ := common.NewLogger("test-deno: ", "", common.LogLevelInfo, false)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewDeno(tt.options, logger)
+			if err != nil {
+				t.Fatalf("Failed to create deno runner: %v", err)
+			}
+			if got := r.permissionFlags(tt.env, tt.params); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("permissionFlags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsDenoScriptReference(t *testing.T) {
+	tests := []struct {
+		command string
+		want    bool
+	}{
+		{"main.ts", true},
+		{"./tools/lint.js", true},
+		{"https://deno.land/std/examples/welcome.ts", true},
+		{"console.log('hi')", false},
+		{"main.ts --flag", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isDenoScriptReference(tt.command); got != tt.want {
+			t.Errorf("isDenoScriptReference(%q) = %v, want %v", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestDeno_Run(t *testing.T) {
+	if !common.CheckExecutableExists("deno") {
+		t.Skip("Skipping deno test if deno is not available")
+	}
+
+	logger, _ := common.NewLogger("test-deno: ", "", common.LogLevelInfo, false)
+
+	r, err := NewDeno(Options{}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create deno runner: %v", err)
+	}
+
+	output, err := r.Run(context.Background(), "", "console.log(Deno.env.get('GREETING'))", []string{"GREETING=hello"}, nil, false)
+	if err != nil {
+		t.Fatalf("Failed to run script: %v", err)
+	}
+	if output != "hello" {
+		t.Errorf("Expected output %q, got %q", "hello", output)
+	}
+
+	// Reading the filesystem is denied without allow_read_folders
+	_, err = r.Run(context.Background(), "", "console.log(Deno.readTextFileSync('/etc/hostname'))", nil, nil, false)
+	if err == nil || (!strings.Contains(err.Error(), "NotCapable") && !strings.Contains(err.Error(), "PermissionDenied")) {
+		t.Errorf("Expected permission error, got %v", err)
+	}
+}
@@ -0,0 +1,327 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DetectorAction is what is done when a detector rule triggers
+type DetectorAction string
+
+const (
+	// DetectorLog logs a warning
+	DetectorLog DetectorAction = "log"
+	// DetectorKill kills the command
+	DetectorKill DetectorAction = "kill"
+	// DetectorQuarantine pauses the command, so it can be inspected (and
+	// then resumed, checkpointed, tightened or killed)
+	DetectorQuarantine DetectorAction = "quarantine"
+)
+
+// maxDetections is the number of detections kept in an execution
+const maxDetections = 100
+
+// maxDetectorLine is the longest line scanned at once: longer lines are
+// scanned in pieces
+const maxDetectorLine = 64 * 1024
+
+// OutputDetector finds suspicious content in the output of commands
+type OutputDetector interface {
+	// Name identifies the detector in the detections
+	Name() string
+
+	// Match returns the suspicious text in a line of output of a stream
+	// ("stdout" or "stderr"), if any
+	Match(stream string, line []byte) (string, bool)
+}
+
+// DetectorRule triggers an action when a detector matches the output of a
+// command a number of times
+type DetectorRule struct {
+	// Detector scans the lines of output
+	Detector OutputDetector
+
+	// Action is done when the rule triggers (default: DetectorLog)
+	Action DetectorAction
+
+	// Threshold is the number of matches triggering the rule (default: 1)
+	Threshold int
+
+	// Window is the period where the matches are counted (default: the
+	// whole execution)
+	Window time.Duration
+
+	// Redact omits the matched text from the detections (e.g. for secrets)
+	Redact bool
+}
+
+// Detection is a trigger of a detector rule in the output of an execution
+type Detection struct {
+	// Detector is the name of the detector
+	Detector string `json:"detector"`
+	// Stream is "stdout" or "stderr"
+	Stream string `json:"stream"`
+	// Match is the text matched by the last match, unless redacted
+	Match string `json:"match,omitempty"`
+	// Count is the number of matches that triggered the rule
+	Count int `json:"count"`
+	// Action is the action done
+	Action DetectorAction `json:"action"`
+	// Err is the error of the action, if any
+	Err error `json:"-"`
+	// Time is when the rule triggered
+	Time time.Time `json:"time"`
+}
+
+// WithDetectorRules scans the output of the command (as read by the caller)
+// with detectors, doing the actions of their rules mid-execution. The
+// detections are returned by Execution.Detections, and emitted as
+// EventDetection events.
+func WithDetectorRules(rules ...DetectorRule) ExecOption {
+	return func(c *execConfig) {
+		c.detectorRules = append(c.detectorRules, rules...)
+	}
+}
+
+// Detections returns the triggers of the detector rules of the execution so
+// far (the first 100 of them)
+func (e *Execution) Detections() []Detection {
+	if e.detector == nil {
+		return nil
+	}
+	e.detector.mu.Lock()
+	defer e.detector.mu.Unlock()
+	return append([]Detection(nil), e.detector.detections...)
+}
+
+// PatternDetector matches a regular expression in the output of commands
+type PatternDetector struct {
+	name    string
+	pattern *regexp.Regexp
+	streams []string
+}
+
+// NewPatternDetector returns a detector matching the regular expression in
+// the streams ("stdout", "stderr" or both by default)
+func NewPatternDetector(name string, pattern string, streams ...string) (*PatternDetector, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern of detector %s: %w", name, err)
+	}
+	for _, stream := range streams {
+		if stream != "stdout" && stream != "stderr" {
+			return nil, fmt.Errorf("invalid stream %q of detector %s", stream, name)
+		}
+	}
+	return &PatternDetector{name: name, pattern: re, streams: streams}, nil
+}
+
+// mustPatternDetector returns the detector of a known valid pattern
+func mustPatternDetector(name string, pattern string, streams ...string) *PatternDetector {
+	d, err := NewPatternDetector(name, pattern, streams...)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// Name returns the name of the detector
+func (d *PatternDetector) Name() string {
+	return d.name
+}
+
+// Match returns the first match of the pattern in the line
+func (d *PatternDetector) Match(stream string, line []byte) (string, bool) {
+	if len(d.streams) > 0 && !contains(d.streams, stream) {
+		return "", false
+	}
+	match := d.pattern.Find(line)
+	if match == nil {
+		return "", false
+	}
+	return string(match), true
+}
+
+// DetectSecrets returns a rule detecting credentials in the output (cloud
+// access keys, tokens and private keys), with the matches redacted
+func DetectSecrets(action DetectorAction) DetectorRule {
+	return DetectorRule{
+		Detector: mustPatternDetector("secrets", `AKIA[0-9A-Z]{16}|gh[pousr]_[A-Za-z0-9]{36,}|xox[abprs]-[A-Za-z0-9-]{10,}|`+
+			`glpat-[A-Za-z0-9_-]{20,}|-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+		Action: action,
+		Redact: true,
+	}
+}
+
+// DetectPermissionDeniedStorms returns a rule detecting bursts of denied
+// operations (e.g. a command probing the restrictions of the sandbox):
+// threshold errors within the window
+func DetectPermissionDeniedStorms(threshold int, window time.Duration, action DetectorAction) DetectorRule {
+	return DetectorRule{
+		Detector:  mustPatternDetector("permission_denied", `(?i)permission denied|operation not permitted|\bEACCES\b|\bEPERM\b`),
+		Action:    action,
+		Threshold: threshold,
+		Window:    window,
+	}
+}
+
+// DetectCryptoMiners returns a rule detecting the output of cryptocurrency
+// miners (mining pool URLs and well-known miners and algorithms)
+func DetectCryptoMiners(action DetectorAction) DetectorRule {
+	return DetectorRule{
+		Detector: mustPatternDetector("crypto_miner", `(?i)stratum\+(tcp|ssl|tls)://|\bxmrig\b|\bcryptonight\b|\bminerd\b|`+
+			`\bnicehash\b|accepted \(\d+/\d+\) diff`),
+		Action: action,
+	}
+}
+
+// detectorState are the recent matches of a rule in an execution
+type detectorState struct {
+	matches []time.Time
+	count   int
+}
+
+// detectionEngine applies the detector rules to the output of an execution
+type detectionEngine struct {
+	e       *Execution
+	rules   []DetectorRule
+	emitter *eventEmitter
+
+	mu         sync.Mutex
+	states     []detectorState
+	detections []Detection
+}
+
+// attachDetectors scans the output pipes of the execution with the rules
+func attachDetectors(e *Execution, rules []DetectorRule, emitter *eventEmitter) {
+	engine := &detectionEngine{e: e, rules: rules, emitter: emitter, states: make([]detectorState, len(rules))}
+	e.detector = engine
+	e.Stdout = &detectorReader{ReadCloser: e.Stdout, stream: "stdout", engine: engine}
+	e.Stderr = &detectorReader{ReadCloser: e.Stderr, stream: "stderr", engine: engine}
+}
+
+// scan applies the rules to a line of output
+func (d *detectionEngine) scan(stream string, line []byte) {
+	for i, rule := range d.rules {
+		match, ok := rule.Detector.Match(stream, line)
+		if !ok {
+			continue
+		}
+		if count, triggered := d.record(i, time.Now()); triggered {
+			detection := Detection{Detector: rule.Detector.Name(), Stream: stream, Count: count,
+				Action: rule.Action, Time: time.Now()}
+			if detection.Action == "" {
+				detection.Action = DetectorLog
+			}
+			if !rule.Redact {
+				detection.Match = match
+			}
+			d.trigger(detection)
+		}
+	}
+}
+
+// record counts a match of a rule, returning the matches counted and whether
+// the rule triggers (which starts counting again)
+func (d *detectionEngine) record(i int, now time.Time) (int, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rule, state := d.rules[i], &d.states[i]
+	threshold := rule.Threshold
+	if threshold <= 0 {
+		threshold = 1
+	}
+	if rule.Window > 0 {
+		recent := state.matches[:0]
+		for _, t := range state.matches {
+			if now.Sub(t) <= rule.Window {
+				recent = append(recent, t)
+			}
+		}
+		state.matches = append(recent, now)
+		state.count = len(state.matches)
+	} else {
+		state.count++
+	}
+
+	if state.count < threshold {
+		return state.count, false
+	}
+	count := state.count
+	state.matches, state.count = nil, 0
+	return count, true
+}
+
+// trigger does the action of a detection and records it
+func (d *detectionEngine) trigger(detection Detection) {
+	e := d.e
+	switch detection.Action {
+	case DetectorKill:
+		detection.Err = e.kill()
+	case DetectorQuarantine:
+		detection.Err = e.Pause()
+	}
+	e.logger.Warn("Detector %s triggered in the %s of execution %s (%d matches): %s",
+		detection.Detector, detection.Stream, e.ID, detection.Count, detection.Action)
+	if detection.Err != nil {
+		e.logger.Warn("Failed to %s execution %s: %v", detection.Action, e.ID, detection.Err)
+	}
+
+	d.mu.Lock()
+	if len(d.detections) < maxDetections {
+		d.detections = append(d.detections, detection)
+	}
+	d.mu.Unlock()
+	d.emitter.emit(Event{Type: EventDetection, Detection: &detection})
+}
+
+// kill kills the command and all its children
+func (e *Execution) kill() error {
+	if e.backend == nil {
+		return ErrNotSupported
+	}
+	return e.backend.signal(syscall.SIGKILL)
+}
+
+// detectorReader splits the output read from a pipe in lines for the detectors
+type detectorReader struct {
+	io.ReadCloser
+	stream string
+	engine *detectionEngine
+	line   []byte
+}
+
+func (r *detectorReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	data := p[:n]
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			r.line = append(r.line, data...)
+			if len(r.line) >= maxDetectorLine {
+				r.engine.scan(r.stream, r.line)
+				r.line = r.line[:0]
+			}
+			break
+		}
+		if len(r.line) > 0 {
+			r.line = append(r.line, data[:i]...)
+			r.engine.scan(r.stream, r.line)
+			r.line = r.line[:0]
+		} else {
+			r.engine.scan(r.stream, data[:i])
+		}
+		data = data[i+1:]
+	}
+	if err != nil && len(r.line) > 0 {
+		r.engine.scan(r.stream, r.line)
+		r.line = r.line[:0]
+	}
+	return n, err
+}
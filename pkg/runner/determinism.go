@@ -0,0 +1,143 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// fakeTimeLibraries are the locations where libfaketime is looked for when
+// the faketime_library option is not set
+var fakeTimeLibraries = map[string][]string{
+	"linux": {
+		"/usr/lib/x86_64-linux-gnu/faketime/libfaketime.so.1",
+		"/usr/lib/aarch64-linux-gnu/faketime/libfaketime.so.1",
+		"/usr/lib64/faketime/libfaketime.so.1",
+		"/usr/lib/faketime/libfaketime.so.1",
+		"/usr/local/lib/faketime/libfaketime.so.1",
+	},
+	"darwin": {
+		"/opt/homebrew/lib/faketime/libfaketime.1.dylib",
+		"/usr/local/lib/faketime/libfaketime.1.dylib",
+	},
+}
+
+// DeterminismOptions pins the clock and locale settings of commands, so
+// their output does not depend on the host (for caching or golden tests).
+// It is embedded in the options of all the runners, so its fields are set
+// with the same keys as any other option.
+type DeterminismOptions struct {
+	// Timezone is the TZ of the command (e.g. "UTC")
+	Timezone string `json:"timezone"`
+
+	// Locale is the LANG and LC_ALL of the command (e.g. "C.UTF-8").
+	// LANGUAGE is cleared, as it takes precedence over them for translations.
+	Locale string `json:"locale"`
+
+	// SourceDateEpoch is the SOURCE_DATE_EPOCH of the command: the
+	// timestamp, in seconds since the Unix epoch, that reproducible build
+	// tools use instead of the current time
+	SourceDateEpoch *int64 `json:"source_date_epoch"`
+
+	// FakeTime runs the command with a virtual clock, using libfaketime.
+	// It is a FAKETIME specification: an absolute time ("@2020-01-01 00:00:00")
+	// or an offset from the current time ("-1d", "+2h").
+	FakeTime string `json:"fake_time"`
+
+	// FakeTimeLibrary is the libfaketime library preloaded in the command.
+	// For runners executing commands in the host it is looked for in the
+	// usual locations when empty. For other runners (e.g. Docker) it is a
+	// path in the guest, and nothing is preloaded when empty, so the guest
+	// must preload the library itself.
+	FakeTimeLibrary string `json:"faketime_library"`
+
+	// preloadVar is the variable used to preload FakeTimeLibrary
+	preloadVar string
+}
+
+// resolveFakeTime finds the libfaketime library in the host when the
+// command runs with a virtual clock. It is called by the runners that
+// execute commands in the host.
+func (o *DeterminismOptions) resolveFakeTime() error {
+	if o.FakeTime == "" {
+		return nil
+	}
+
+	if runtime.GOOS == "darwin" {
+		o.preloadVar = "DYLD_INSERT_LIBRARIES"
+	}
+	if o.FakeTimeLibrary != "" {
+		if _, err := os.Stat(o.FakeTimeLibrary); err != nil {
+			return fmt.Errorf("faketime library not found: %w", err)
+		}
+		return nil
+	}
+
+	for _, lib := range fakeTimeLibraries[runtime.GOOS] {
+		if _, err := os.Stat(lib); err == nil {
+			o.FakeTimeLibrary = lib
+			return nil
+		}
+	}
+	return fmt.Errorf("fake_time requires libfaketime, which was not found (set faketime_library)")
+}
+
+// pinnedEnv returns the environment variables set by the options
+func (o DeterminismOptions) pinnedEnv() []string {
+	var env []string
+	if o.Timezone != "" {
+		env = append(env, "TZ="+o.Timezone)
+	}
+	if o.Locale != "" {
+		env = append(env, "LANG="+o.Locale, "LC_ALL="+o.Locale, "LANGUAGE=")
+	}
+	if o.SourceDateEpoch != nil {
+		env = append(env, "SOURCE_DATE_EPOCH="+strconv.FormatInt(*o.SourceDateEpoch, 10))
+	}
+	if o.FakeTime != "" {
+		env = append(env, "FAKETIME="+o.FakeTime)
+		if o.FakeTimeLibrary != "" {
+			switch o.preloadVar {
+			case "DYLD_INSERT_LIBRARIES":
+				// libfaketime requires a flat namespace on macOS
+				env = append(env, "DYLD_INSERT_LIBRARIES="+o.FakeTimeLibrary, "DYLD_FORCE_FLAT_NAMESPACE=1")
+			default:
+				env = append(env, "LD_PRELOAD="+o.FakeTimeLibrary)
+			}
+		}
+	}
+	return env
+}
+
+// pinEnv returns env with the pinned variables, which replace the ones with
+// the same name. When the locale is pinned, the LC_* variables are removed
+// too (they are overridden by LC_ALL anyway).
+//
+// Runners that inherit the environment of the current process append env to
+// it, so the pinned variables also override the inherited ones.
+func (o DeterminismOptions) pinEnv(env []string) []string {
+	pinned := o.pinnedEnv()
+	if len(pinned) == 0 {
+		return env
+	}
+
+	overridden := map[string]bool{}
+	for _, e := range pinned {
+		overridden[strings.SplitN(e, "=", 2)[0]] = true
+	}
+
+	res := make([]string, 0, len(env)+len(pinned))
+	for _, e := range env {
+		name := strings.SplitN(e, "=", 2)[0]
+		if overridden[name] {
+			continue
+		}
+		if o.Locale != "" && strings.HasPrefix(name, "LC_") {
+			continue
+		}
+		res = append(res, e)
+	}
+	return append(res, pinned...)
+}
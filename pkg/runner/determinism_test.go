@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestDeterminismOptions_PinEnv(t *testing.T) {
+	epoch := int64(1700000000)
+	opts := DeterminismOptions{Timezone: "UTC", Locale: "C.UTF-8", SourceDateEpoch: &epoch}
+
+	got := opts.pinEnv([]string{"FOO=bar", "TZ=Europe/Madrid", "LC_TIME=es_ES.UTF-8", "LANG=es_ES.UTF-8"})
+	want := []string{
+		"FOO=bar",
+		"TZ=UTC",
+		"LANG=C.UTF-8", "LC_ALL=C.UTF-8", "LANGUAGE=",
+		"SOURCE_DATE_EPOCH=1700000000",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pinEnv() = %v, want %v", got, want)
+	}
+
+	env := []string{"TZ=Europe/Madrid"}
+	if got := (DeterminismOptions{}).pinEnv(env); !reflect.DeepEqual(got, env) {
+		t.Errorf("pinEnv() = %v, want the env unchanged without options", got)
+	}
+}
+
+func TestExec_Determinism(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping on Windows")
+	}
+
+	r, err := NewExec(Options{"timezone": "UTC", "source_date_epoch": 0}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	output, err := r.Run(context.Background(), "", `echo "$TZ $SOURCE_DATE_EPOCH"`,
+		[]string{"TZ=Europe/Madrid"}, nil, false)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if output != "UTC 0" {
+		t.Errorf("Run() = %q, want %q", output, "UTC 0")
+	}
+}
+
+func TestNewDockerOptions_Determinism(t *testing.T) {
+	opts, err := NewDockerOptions(Options{"image": "alpine", "timezone": "UTC", "source_date_epoch": float64(10)})
+	if err != nil {
+		t.Fatalf("NewDockerOptions failed: %v", err)
+	}
+	if opts.Timezone != "UTC" || opts.SourceDateEpoch == nil || *opts.SourceDateEpoch != 10 {
+		t.Errorf("NewDockerOptions() = %+v, want the clock options parsed", opts.DeterminismOptions)
+	}
+}
+
+func TestDeterminismOptions_FakeTime(t *testing.T) {
+	library := filepath.Join(t.TempDir(), "libfaketime.so.1")
+	if err := os.WriteFile(library, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DeterminismOptions{FakeTime: "@2020-01-01 00:00:00", FakeTimeLibrary: library}
+	if err := opts.resolveFakeTime(); err != nil {
+		t.Fatalf("resolveFakeTime failed: %v", err)
+	}
+	env := opts.pinEnv(nil)
+	if !contains(env, "FAKETIME=@2020-01-01 00:00:00") {
+		t.Errorf("pinEnv() = %v, want FAKETIME", env)
+	}
+	if runtime.GOOS == "linux" && !contains(env, "LD_PRELOAD="+library) {
+		t.Errorf("pinEnv() = %v, want the library preloaded", env)
+	}
+
+	missing := DeterminismOptions{FakeTime: "-1d", FakeTimeLibrary: filepath.Join(t.TempDir(), "missing.so")}
+	if err := missing.resolveFakeTime(); err == nil {
+		t.Errorf("resolveFakeTime should fail with a missing library")
+	}
+
+	// Docker does not resolve the library: it is a path in the container
+	dockerOpts, err := NewDockerOptions(Options{"image": "alpine", "fake_time": "+1h"})
+	if err != nil {
+		t.Fatalf("NewDockerOptions failed: %v", err)
+	}
+	if env := dockerOpts.pinEnv(nil); !reflect.DeepEqual(env, []string{"FAKETIME=+1h"}) {
+		t.Errorf("pinEnv() = %v, want only FAKETIME", env)
+	}
+}
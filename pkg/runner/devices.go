@@ -0,0 +1,135 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// DeviceOptions grants the command the desktop devices and services it needs,
+// for desktop automation tools. Everything is denied by default.
+type DeviceOptions struct {
+	// AllowAudio gives access to the sound devices (/dev/snd)
+	AllowAudio bool `json:"allow_audio"`
+
+	// AllowVideo gives access to the video capture devices (/dev/video*), e.g. cameras
+	AllowVideo bool `json:"allow_video"`
+
+	// AllowDBusPortals gives access to the XDG desktop portals
+	// (org.freedesktop.portal.*) of the session bus, and nothing else on the bus
+	AllowDBusPortals bool `json:"allow_dbus_portals"`
+}
+
+// portalBusNames are the bus names the command can talk to with AllowDBusPortals
+const portalBusNames = "org.freedesktop.portal.*"
+
+// dbusProxyGuestDir is where the session bus proxy is mounted in containers
+const dbusProxyGuestDir = "/run/runner-dbus"
+
+// devices returns the device nodes allowed to the command
+func (o DeviceOptions) devices() []string {
+	var devices []string
+	if o.AllowAudio {
+		if _, err := os.Stat("/dev/snd"); err == nil {
+			devices = append(devices, "/dev/snd")
+		}
+	}
+	if o.AllowVideo {
+		video, _ := filepath.Glob("/dev/video*")
+		devices = append(devices, video...)
+	}
+	return devices
+}
+
+// dbusProxy is a filtering proxy of the session bus that only lets clients
+// talk to the desktop portals, run with xdg-dbus-proxy
+type dbusProxy struct {
+	logger Logger
+	cmd    *exec.Cmd
+	// dir is the folder of the proxy socket
+	dir string
+}
+
+// sessionBusAddress returns the address of the session bus of the user
+func sessionBusAddress() string {
+	if address := os.Getenv("DBUS_SESSION_BUS_ADDRESS"); address != "" {
+		return address
+	}
+	if runtimeDir := userRuntimeDir(); runtimeDir != "" {
+		return "unix:path=" + filepath.Join(runtimeDir, "bus")
+	}
+	return ""
+}
+
+// startPortalProxy starts a proxy of the session bus that only allows the
+// desktop portals, and waits until its socket is ready. It must be stopped
+// once the command has completed.
+func startPortalProxy(ctx context.Context, logger Logger) (*dbusProxy, error) {
+	address := sessionBusAddress()
+	if address == "" {
+		return nil, fmt.Errorf("no session bus found for the desktop portals")
+	}
+
+	dir, err := os.MkdirTemp("", "runner-dbus-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the session bus proxy folder: %w", err)
+	}
+	// the command may run as another user in the container
+	if err := os.Chmod(dir, 0o755); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create the session bus proxy folder: %w", err)
+	}
+
+	socket := filepath.Join(dir, "bus")
+	cmd := exec.Command("xdg-dbus-proxy", address, socket, "--filter", "--talk="+portalBusNames)
+	if err := cmd.Start(); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to start xdg-dbus-proxy: %w", err)
+	}
+	p := &dbusProxy{logger: logger, cmd: cmd, dir: dir}
+
+	logger.Debug("Started session bus proxy for the desktop portals at %s", socket)
+
+	// the proxy creates its socket once it is ready
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(5 * time.Second)
+	for {
+		if _, err := os.Stat(socket); err == nil {
+			return p, nil
+		}
+		select {
+		case <-ctx.Done():
+			p.stop()
+			return nil, ctx.Err()
+		case <-timeout:
+			p.stop()
+			return nil, fmt.Errorf("timeout waiting for the session bus proxy")
+		case <-ticker.C:
+		}
+	}
+}
+
+// mountArg returns the bind mount of the proxy socket folder in containers
+func (p *dbusProxy) mountArg() string {
+	return p.dir + ":" + dbusProxyGuestDir
+}
+
+// guestEnv returns the variable pointing the command to the proxy in containers
+func (p *dbusProxy) guestEnv() string {
+	return "DBUS_SESSION_BUS_ADDRESS=unix:path=" + dbusProxyGuestDir + "/bus"
+}
+
+// stop stops the proxy and removes its socket
+func (p *dbusProxy) stop() {
+	if err := p.cmd.Process.Kill(); err != nil {
+		p.logger.Debug("Warning: failed to stop the session bus proxy: %v", err)
+	}
+	_ = p.cmd.Wait()
+	if err := os.RemoveAll(p.dir); err != nil {
+		p.logger.Debug("Warning: failed to remove the session bus proxy folder: %v", err)
+	}
+}
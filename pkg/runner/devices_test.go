@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDeviceOptions_devices(t *testing.T) {
+	if devices := (DeviceOptions{}).devices(); len(devices) != 0 {
+		t.Errorf("expected no devices by default, got %v", devices)
+	}
+
+	video, _ := filepath.Glob("/dev/video*")
+	if devices := (DeviceOptions{AllowVideo: true}).devices(); !reflect.DeepEqual(devices, video) {
+		t.Errorf("expected the video devices %v, got %v", video, devices)
+	}
+
+	_, err := os.Stat("/dev/snd")
+	devices := (DeviceOptions{AllowAudio: true}).devices()
+	if (err == nil) != contains(devices, "/dev/snd") {
+		t.Errorf("unexpected audio devices: %v", devices)
+	}
+}
+
+func TestFirejail_sessionArgs(t *testing.T) {
+	r, err := NewFirejail(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := r.sessionArgs()
+	for _, arg := range []string{"--x11=none", "--nosound", "--novideo", "--dbus-user=none"} {
+		if !contains(args, arg) {
+			t.Errorf("expected %s by default, got %v", arg, args)
+		}
+	}
+
+	r, err = NewFirejail(Options{
+		"allow_display":      true,
+		"allow_audio":        true,
+		"allow_video":        true,
+		"allow_dbus_portals": true,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args = r.sessionArgs()
+	for _, arg := range []string{"--x11=none", "--nosound", "--novideo", "--dbus-user=none"} {
+		if contains(args, arg) {
+			t.Errorf("expected %s to be dropped, got %v", arg, args)
+		}
+	}
+	if !contains(args, "--dbus-user.talk=org.freedesktop.portal.*") {
+		t.Errorf("expected the portals to be allowed, got %v", args)
+	}
+}
+
+func TestDockerOptions_devices(t *testing.T) {
+	opts, err := NewDockerOptions(Options{"image": "alpine", "allow_audio": true, "allow_video": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.AllowAudio || !opts.AllowVideo || opts.AllowDBusPortals {
+		t.Fatalf("unexpected device options: %+v", opts.DeviceOptions)
+	}
+
+	command := strings.Join(opts.GetBaseDockerCommand(nil), " ")
+	for _, device := range opts.devices() {
+		if !strings.Contains(command, "--device "+device) {
+			t.Errorf("expected %s in the command: %s", device, command)
+		}
+	}
+}
+
+func TestSessionBusAddress(t *testing.T) {
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "")
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	if got := sessionBusAddress(); got != "unix:path=/run/user/1000/bus" {
+		t.Errorf("unexpected session bus address: %s", got)
+	}
+
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path=/tmp/bus")
+	if got := sessionBusAddress(); got != "unix:path=/tmp/bus" {
+		t.Errorf("unexpected session bus address: %s", got)
+	}
+}
@@ -0,0 +1,298 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// commandConnStderrSize is the size of the end of the standard error kept by
+// a CommandConn
+const commandConnStderrSize = 4096
+
+// CommandAddr is the address of the ends of a CommandConn: the command line
+type CommandAddr string
+
+// Network implements net.Addr
+func (a CommandAddr) Network() string { return "command" }
+
+// String implements net.Addr
+func (a CommandAddr) String() string { return string(a) }
+
+// CommandConn is a net.Conn over the standard input and output of a command
+// (see DialCommand). Read and write deadlines are supported, regardless of
+// the pipes of the runner.
+type CommandConn struct {
+	// Execution is the command
+	Execution *Execution
+
+	addr   CommandAddr
+	cancel context.CancelFunc
+
+	// reads of the standard output, done by readLoop
+	readMu   sync.Mutex
+	chunks   chan []byte
+	pending  []byte
+	readDone chan struct{}
+	readErr  error
+
+	// writes to the standard input, done by a goroutine per write so they
+	// can time out
+	writeMu     sync.Mutex
+	writing     chan writeResult
+	writeBroken error
+
+	deadlineMu    sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	stderrMu   sync.Mutex
+	stderr     []byte
+	stderrDone chan struct{}
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// writeResult is the result of a write to the standard input
+type writeResult struct {
+	n   int
+	err error
+}
+
+// DialCommand starts a command and returns a net.Conn over its standard input
+// and output, for tunneling connections through sandboxed helpers (e.g. the
+// ProxyCommand of SSH, or the tunnel of a database client). Closing the
+// connection kills the command.
+//
+// The connection is a *CommandConn. When the command exits with an error, the
+// reads of the connection return it, along with the end of the standard
+// error.
+func DialCommand(ctx context.Context, r Runner, cmd string, args []string, env []string, params map[string]interface{},
+	opts ...ExecOption) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	e, err := Start(ctx, r, cmd, args, env, params, opts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	c := &CommandConn{
+		Execution:  e,
+		addr:       CommandAddr(strings.Join(append([]string{cmd}, args...), " ")),
+		cancel:     cancel,
+		chunks:     make(chan []byte),
+		readDone:   make(chan struct{}),
+		stderrDone: make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+	go c.readLoop()
+	go c.stderrLoop()
+	return c, nil
+}
+
+// Read implements net.Conn
+func (c *CommandConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if len(c.pending) == 0 {
+		timeout, stop := c.deadline(func() time.Time { return c.readDeadline })
+		defer stop()
+		select {
+		case chunk := <-c.chunks:
+			c.pending = chunk
+		case <-c.readDone:
+			// the chunks are sent before readDone is closed
+			return 0, c.readErr
+		case <-c.closed:
+			return 0, net.ErrClosed
+		case <-timeout:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write implements net.Conn
+func (c *CommandConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	timeout, stop := c.deadline(func() time.Time { return c.writeDeadline })
+	defer stop()
+
+	// a write that timed out may still be in progress
+	if c.writing != nil {
+		select {
+		case result := <-c.writing:
+			c.writing = nil
+			if result.err != nil {
+				c.writeBroken = result.err
+			}
+		case <-c.closed:
+			return 0, net.ErrClosed
+		case <-timeout:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+	if c.writeBroken != nil {
+		return 0, c.writeBroken
+	}
+
+	data := append([]byte{}, p...)
+	writing := make(chan writeResult, 1)
+	go func() {
+		n, err := c.Execution.Stdin.Write(data)
+		writing <- writeResult{n, err}
+	}()
+	select {
+	case result := <-writing:
+		if result.err != nil {
+			c.writeBroken = result.err
+		}
+		return result.n, result.err
+	case <-c.closed:
+		return 0, net.ErrClosed
+	case <-timeout:
+		c.writing = writing
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+// Close kills the command
+func (c *CommandConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		_ = c.Execution.Stdin.Close()
+		c.cancel()
+		_ = c.Execution.Wait()
+	})
+	return nil
+}
+
+// CloseWrite closes the standard input of the command, as with the
+// connections of net.TCPConn
+func (c *CommandConn) CloseWrite() error {
+	return c.Execution.Stdin.Close()
+}
+
+// LocalAddr implements net.Conn
+func (c *CommandConn) LocalAddr() net.Addr { return c.addr }
+
+// RemoteAddr implements net.Conn
+func (c *CommandConn) RemoteAddr() net.Addr { return c.addr }
+
+// SetDeadline implements net.Conn
+func (c *CommandConn) SetDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.readDeadline, c.writeDeadline = t, t
+	return nil
+}
+
+// SetReadDeadline implements net.Conn
+func (c *CommandConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn
+func (c *CommandConn) SetWriteDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.writeDeadline = t
+	return nil
+}
+
+// Stderr returns the end of the standard error of the command
+func (c *CommandConn) Stderr() string {
+	c.stderrMu.Lock()
+	defer c.stderrMu.Unlock()
+	return string(c.stderr)
+}
+
+// deadline returns a channel closed at the deadline returned by get (nil
+// when there is no deadline), and the function releasing its timer
+func (c *CommandConn) deadline(get func() time.Time) (<-chan time.Time, func()) {
+	c.deadlineMu.Lock()
+	t := get()
+	c.deadlineMu.Unlock()
+	if t.IsZero() {
+		return nil, func() {}
+	}
+	timer := time.NewTimer(time.Until(t))
+	return timer.C, func() { timer.Stop() }
+}
+
+// readLoop reads the standard output of the command until it is closed,
+// waiting then for the command to exit
+func (c *CommandConn) readLoop() {
+	defer close(c.readDone)
+	for {
+		buf := make([]byte, 32*1024)
+		n, err := c.Execution.Stdout.Read(buf)
+		if n > 0 {
+			select {
+			case c.chunks <- buf[:n]:
+			case <-c.closed:
+				c.readErr = net.ErrClosed
+				return
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	// Wait closes the pipes, so the standard error must be read first
+	select {
+	case <-c.stderrDone:
+	case <-c.closed:
+		c.readErr = net.ErrClosed
+		return
+	}
+	c.readErr = io.EOF
+	if err := c.Execution.Wait(); err != nil {
+		select {
+		case <-c.closed:
+			c.readErr = net.ErrClosed
+			return
+		default:
+		}
+		if stderr := strings.TrimSpace(c.Stderr()); stderr != "" {
+			c.readErr = fmt.Errorf("%s failed: %w: %s", c.addr, err, stderr)
+		} else {
+			c.readErr = fmt.Errorf("%s failed: %w", c.addr, err)
+		}
+	}
+}
+
+// stderrLoop keeps the end of the standard error of the command
+func (c *CommandConn) stderrLoop() {
+	defer close(c.stderrDone)
+	buf := make([]byte, 4096)
+	for {
+		n, err := c.Execution.Stderr.Read(buf)
+		if n > 0 {
+			c.stderrMu.Lock()
+			c.stderr = append(c.stderr, buf[:n]...)
+			if len(c.stderr) > commandConnStderrSize {
+				c.stderr = c.stderr[len(c.stderr)-commandConnStderrSize:]
+			}
+			c.stderrMu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialTestCommand dials a shell command with the exec runner
+func dialTestCommand(t *testing.T, script string) net.Conn {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	conn, err := DialCommand(context.Background(), r, "sh", []string{"-c", script}, nil, nil)
+	if err != nil {
+		t.Fatalf("DialCommand failed: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestDialCommand(t *testing.T) {
+	conn := dialTestCommand(t, "cat")
+
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("Read() = %q, %v", buf, err)
+	}
+	if conn.RemoteAddr().Network() != "command" || conn.RemoteAddr().String() != "sh -c cat" {
+		t.Errorf("RemoteAddr() = %v", conn.RemoteAddr())
+	}
+
+	// closing the input ends the command, and the reads
+	if err := conn.(*CommandConn).CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite failed: %v", err)
+	}
+	if _, err := conn.Read(buf); !errors.Is(err, io.EOF) {
+		t.Errorf("Read() after CloseWrite = %v, want EOF", err)
+	}
+
+	if err := conn.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if _, err := conn.Read(buf); !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) {
+		t.Errorf("Read() after Close = %v", err)
+	}
+}
+
+func TestDialCommand_deadlines(t *testing.T) {
+	conn := dialTestCommand(t, "sleep 60")
+
+	if err := conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatalf("SetReadDeadline failed: %v", err)
+	}
+	start := time.Now()
+	var netErr net.Error
+	if _, err := conn.Read(make([]byte, 1)); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Read() = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Read() timed out after %v", elapsed)
+	}
+
+	// the command does not read its input, so the pipe fills up
+	if err := conn.SetWriteDeadline(time.Now().Add(200 * time.Millisecond)); err != nil {
+		t.Fatalf("SetWriteDeadline failed: %v", err)
+	}
+	data := make([]byte, 1<<20)
+	if _, err := conn.Write(data); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Write() = %v, want a timeout", err)
+	}
+
+	// the connection is killed without waiting for the command
+	start = time.Now()
+	_ = conn.Close()
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Close() took %v", elapsed)
+	}
+}
+
+func TestDialCommand_failure(t *testing.T) {
+	conn := dialTestCommand(t, "echo 'connection refused' >&2; exit 255")
+
+	_, err := conn.Read(make([]byte, 1))
+	if err == nil || errors.Is(err, io.EOF) || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("Read() = %v, want the failure with the standard error", err)
+	}
+}
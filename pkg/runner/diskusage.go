@@ -0,0 +1,62 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// ErrNoSpace is matched (with errors.Is) by the errors of executions that
+// failed because the host ran out of disk space, e.g. when the Docker daemon
+// cannot create a container. They can be retried once space has been freed
+// (see ImageGC), and are classified as ErrorKindNoSpace.
+var ErrNoSpace = errors.New("no space left on the host")
+
+// IsNoSpace returns whether err shows that the host ran out of disk space:
+// it matches ErrNoSpace, or wraps ENOSPC (e.g. when the script of a command
+// cannot be written)
+func IsNoSpace(err error) bool {
+	return errors.Is(err, ErrNoSpace) || errors.Is(err, syscall.ENOSPC)
+}
+
+// noSpaceError returns an error wrapping ErrNoSpace when the output of a
+// failed container engine command says the host is out of disk space, or
+// err otherwise. Only the output of the engine itself must be checked, not
+// the output of the commands run in the containers.
+func noSpaceError(err error, output string) error {
+	if err == nil || !strings.Contains(strings.ToLower(output), "no space left on device") {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrNoSpace, err)
+}
+
+// DiskUsage is the usage of a filesystem
+type DiskUsage struct {
+	// Path is the path in the filesystem
+	Path string `json:"path"`
+
+	// Total is the size of the filesystem, in bytes
+	Total uint64 `json:"total"`
+
+	// Free is the space available to unprivileged users, in bytes
+	Free uint64 `json:"free"`
+}
+
+// Used returns the percentage of the filesystem that is not available
+func (u DiskUsage) Used() float64 {
+	if u.Total == 0 {
+		return 0
+	}
+	return 100 * float64(u.Total-min(u.Free, u.Total)) / float64(u.Total)
+}
+
+// GetDiskUsage returns the usage of the filesystem of a path
+func GetDiskUsage(path string) (DiskUsage, error) {
+	usage, err := diskUsage(path)
+	if err != nil {
+		return DiskUsage{}, fmt.Errorf("failed to get the disk usage of %s: %w", path, err)
+	}
+	usage.Path = path
+	return usage, nil
+}
@@ -0,0 +1,33 @@
+package runner
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGetDiskUsage(t *testing.T) {
+	usage, err := GetDiskUsage(t.TempDir())
+	if err != nil {
+		t.Fatalf("GetDiskUsage failed: %v", err)
+	}
+	if usage.Total == 0 || usage.Free > usage.Total || usage.Used() < 0 || usage.Used() > 100 {
+		t.Errorf("GetDiskUsage() = %+v, %.1f%% used", usage, usage.Used())
+	}
+	if _, err := GetDiskUsage("/no/such/folder/for/tests"); err == nil {
+		t.Errorf("GetDiskUsage() of a missing folder should fail")
+	}
+}
+
+func TestNoSpaceError(t *testing.T) {
+	base := errors.New("exit status 125")
+	err := noSpaceError(base, "docker: Error response from daemon: mkdir /var/lib/docker/overlay2/x: no space left on device.")
+	if !IsNoSpace(err) || !errors.Is(err, base) {
+		t.Errorf("noSpaceError() = %v, want ErrNoSpace wrapping the error", err)
+	}
+	if err := noSpaceError(base, "Unable to find image"); err != base || IsNoSpace(err) {
+		t.Errorf("noSpaceError() of another failure = %v", err)
+	}
+	if !ErrorKindNoSpace.Retryable() || ErrorKindFailed.Retryable() {
+		t.Errorf("only the executions out of space are retryable")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package runner
+
+import "golang.org/x/sys/unix"
+
+// diskUsage returns the usage of the filesystem of a path, with statfs
+func diskUsage(path string) (DiskUsage, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return DiskUsage{}, err
+	}
+	return DiskUsage{Total: uint64(st.Blocks) * uint64(st.Bsize), Free: uint64(st.Bavail) * uint64(st.Bsize)}, nil
+}
@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DisplayOptions controls whether the command can reach the display server
+// of the host (X11 or Wayland). Access is denied by default, as a client of
+// the display server can read the keyboard and the screen of the user
+// session.
+//
+// Denying removes the display variables from the environment of the command
+// and, in the runners that can deny paths, the display sockets.
+type DisplayOptions struct {
+	// AllowDisplay keeps DISPLAY, WAYLAND_DISPLAY and the display sockets
+	AllowDisplay bool `json:"allow_display"`
+}
+
+// displayEnvVars are the variables pointing to the display server
+var displayEnvVars = []string{"DISPLAY", "WAYLAND_DISPLAY", "WAYLAND_SOCKET", "XAUTHORITY"}
+
+// x11SocketDir is the folder of the X11 sockets
+const x11SocketDir = "/tmp/.X11-unix"
+
+// scrubDisplayEnv returns env without the display variables, unless the
+// display is allowed
+func (o DisplayOptions) scrubDisplayEnv(env []string) []string {
+	if o.AllowDisplay {
+		return env
+	}
+
+	var res []string
+	for _, e := range env {
+		name, _, _ := strings.Cut(e, "=")
+		if !contains(displayEnvVars, name) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// BlockedDisplayPaths returns the existing sockets (and authority files) of
+// the display server when the display is not allowed, denied by the runners
+// (and profile templates) that can deny access to paths
+func (o DisplayOptions) BlockedDisplayPaths() []string {
+	if o.AllowDisplay {
+		return nil
+	}
+
+	paths := []string{x11SocketDir}
+	// XQuartz sets DISPLAY to the path of its socket
+	if display := os.Getenv("DISPLAY"); filepath.IsAbs(display) {
+		paths = append(paths, display)
+	}
+	if xauthority := os.Getenv("XAUTHORITY"); filepath.IsAbs(xauthority) {
+		paths = append(paths, xauthority)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".Xauthority"))
+	}
+
+	wayland := os.Getenv("WAYLAND_DISPLAY")
+	if wayland == "" {
+		wayland = "wayland-0"
+	}
+	if filepath.IsAbs(wayland) {
+		paths = append(paths, wayland)
+	} else if runtimeDir := userRuntimeDir(); runtimeDir != "" {
+		paths = append(paths, filepath.Join(runtimeDir, wayland))
+	}
+
+	return existingPaths(paths)
+}
+
+// checkDisplayMount returns an error when mounting the source path would
+// expose the display server
+func (o DisplayOptions) checkDisplayMount(source string) error {
+	if path := exposedPath(source, o.BlockedDisplayPaths()); path != "" {
+		return fmt.Errorf("mount %s exposes the display socket %s (see allow_display)", source, path)
+	}
+	return nil
+}
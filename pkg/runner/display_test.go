@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDisplayOptions_scrubDisplayEnv(t *testing.T) {
+	env := []string{"PATH=/usr/bin", "DISPLAY=:0", "WAYLAND_DISPLAY=wayland-0", "XAUTHORITY=/home/user/.Xauthority"}
+
+	if got := (DisplayOptions{}).scrubDisplayEnv(env); !reflect.DeepEqual(got, []string{"PATH=/usr/bin"}) {
+		t.Errorf("expected the display variables to be removed, got %v", got)
+	}
+	if got := (DisplayOptions{AllowDisplay: true}).scrubDisplayEnv(env); !reflect.DeepEqual(got, env) {
+		t.Errorf("expected the environment to be unchanged, got %v", got)
+	}
+}
+
+func TestDisplayOptions_BlockedDisplayPaths(t *testing.T) {
+	dir := t.TempDir()
+	wayland := filepath.Join(dir, "wayland-1")
+	xauthority := filepath.Join(dir, "xauth")
+	for _, path := range []string{wayland, xauthority} {
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	t.Setenv("WAYLAND_DISPLAY", "wayland-1")
+	t.Setenv("XAUTHORITY", xauthority)
+	t.Setenv("DISPLAY", ":0")
+
+	paths := (DisplayOptions{}).BlockedDisplayPaths()
+	for _, path := range []string{wayland, xauthority} {
+		if !contains(paths, path) {
+			t.Errorf("expected %s to be blocked, got %v", path, paths)
+		}
+	}
+	if err := (DisplayOptions{}).checkDisplayMount(dir); err == nil {
+		t.Errorf("expected a mount exposing the Wayland socket to be rejected")
+	}
+
+	if paths := (DisplayOptions{AllowDisplay: true}).BlockedDisplayPaths(); len(paths) != 0 {
+		t.Errorf("expected nothing to be blocked, got %v", paths)
+	}
+	if err := (DisplayOptions{AllowDisplay: true}).checkDisplayMount(dir); err != nil {
+		t.Errorf("expected the mount to be accepted, got %v", err)
+	}
+}
@@ -2,29 +2,65 @@
 package runner
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/docker/docker/client"
+
 	"github.com/inercia/go-restricted-runner/pkg/common"
 )
 
-// Docker executes commands inside a Docker container.
+// Docker executes commands inside a Docker container. The same runner drives
+// Podman, whose CLI is compatible, with the engine option (see NewPodman).
 type Docker struct {
-	logger *common.Logger
+	logger Logger
 	opts   DockerOptions
+
+	// shellMu guards the result of checking whether the image has a shell,
+	// for inject_shell "auto" (see shellMode)
+	shellMu       sync.Mutex
+	shellProbed   bool
+	imageHasShell bool
+
+	// apiMu guards the client of the API of the engine (see FeatureDockerSDK)
+	apiMu sync.Mutex
+	api   *client.Client
 }
 
+// Container engines driven by the Docker runner
+const (
+	// EngineDocker is the docker CLI (the default)
+	EngineDocker = "docker"
+	// EnginePodman is the podman CLI, which can run without a daemon and
+	// without root privileges (rootless mode)
+	EnginePodman = "podman"
+)
+
 // DockerOptions represents configuration options for the Docker runner.
 type DockerOptions struct {
 	// The Docker image to use (required)
 	Image string `json:"image"`
 
+	// Engine is the container engine CLI: "docker" (default) or "podman"
+	Engine string `json:"engine"`
+
+	// Userns is the user namespace mode of the container (e.g. "host", or
+	// "keep-id" with Podman, so files written in the mounts by a non-root
+	// user of a rootless container are owned by the user of the host)
+	Userns string `json:"userns"`
+
 	// Additional Docker run options
 	DockerRunOpts string `json:"docker_run_opts"`
 
@@ -58,6 +94,16 @@ type DockerOptions struct {
 	// Tune container memory swappiness (0 to 100)
 	MemorySwappiness int `json:"memory_swappiness"`
 
+	// Relative weight of the block I/O of the container (10 to 1000), so
+	// disk-heavy jobs get a smaller share of the disks of the host
+	BlkioWeight int `json:"blkio_weight"`
+
+	// Read rate limits of devices, in bytes per second (e.g. "/dev/sda:10mb")
+	DeviceReadBps []string `json:"device_read_bps"`
+
+	// Write rate limits of devices, in bytes per second (e.g. "/dev/sda:10mb")
+	DeviceWriteBps []string `json:"device_write_bps"`
+
 	// Linux capabilities to add to the container
 	CapAdd []string `json:"cap_add"`
 
@@ -70,15 +116,111 @@ type DockerOptions struct {
 	// Custom DNS search domains for the container
 	DNSSearch []string `json:"dns_search"`
 
+	// Hostname of the container (containers always have their own UTS and IPC namespaces)
+	Hostname string `json:"hostname"`
+
 	// Set platform if server is multi-platform capable (e.g., "linux/amd64", "linux/arm64")
 	Platform string `json:"platform"`
+
+	// Umask of the command inside the container, in octal (e.g. "077")
+	Umask string `json:"umask"`
+
+	// InjectShell is how commands run in images without a shell (distroless
+	// or scratch): "busybox" mounts a static busybox of the host, "auto"
+	// does it when the image has no sh, and "direct" runs the commands
+	// without a shell. By default the shell of the image is used.
+	InjectShell string `json:"inject_shell"`
+
+	// BusyboxPath is the static busybox injected (defaults to busybox in PATH)
+	BusyboxPath string `json:"busybox_path"`
+
+	// Core dump policy, set with --ulimit (core dumps cannot be captured)
+	CoreDumpOptions
+
+	// Sandbox verification with canaries, run in a container
+	CanaryOptions
+
+	// Command run in a container before the commands
+	PreflightOptions
+
+	// PublishPorts are ports of the container published in random host ports
+	// ("8080" or "8080/udp"), available from Execution.Ports
+	PublishPorts []string `json:"publish_ports"`
+
+	// PublishAddress is the host address the ports are published on (defaults to "127.0.0.1")
+	PublishAddress string `json:"publish_address"`
+
+	// NetworkShaping adds latency, packet loss and bandwidth caps to the network of the container
+	NetworkShaping *NetworkShaping `json:"network_shaping"`
+
+	// Clock and locale settings
+	DeterminismOptions
+
+	// Variables of the commands read from .env files
+	EnvFileOptions
+
+	// Experimental features
+	ExperimentalOptions
+
+	// CA certificates trusted by the command
+	CABundleOptions
+
+	// Access to the credential agents of the host
+	AgentOptions
+
+	// Access to the display server of the host
+	DisplayOptions
+
+	// Desktop devices and portals, added with --device
+	DeviceOptions
+
+	// Hostname aliases, added with --add-host
+	HostsOptions
+
+	// System calls of the command, filtered with --security-opt seccomp=.
+	// The default profile is the one of the engine.
+	SeccompOptions
+
+	// Timeout of the commands, stopping the container with `docker stop -t`
+	TimeoutOptions
+
+	// Limits of the output of the commands
+	OutputLimitOptions
+
+	// containerName names the container of the command (see Run)
+	containerName string
+
+	// shell runs the script of the command (see Run), "sh" by default
+	shell string
+
+	// seccompFile is the profile file passed to the engine, if any (see dockerSeccompFile)
+	seccompFile string
+}
+
+// engine returns the container engine CLI
+func (o *DockerOptions) engine() string {
+	if o.Engine == "" {
+		return EngineDocker
+	}
+	return o.Engine
+}
+
+// runnerType returns the runner type of the engine
+func (o *DockerOptions) runnerType() Type {
+	if o.engine() == EnginePodman {
+		return TypePodman
+	}
+	return TypeDocker
 }
 
 // GetBaseDockerCommand creates the common parts of a docker run command with all configured options.
 // It returns a slice of command parts that can be further customized by the calling method.
 func (o *DockerOptions) GetBaseDockerCommand(env []string) []string {
 	// Start with basic docker run command
-	parts := []string{"docker run --rm"}
+	parts := []string{o.engine() + " run --rm --label " + managedLabel}
+	if o.containerName != "" {
+		parts = append(parts, "--name "+o.containerName)
+	}
 
 	// Add networking option
 	if !o.AllowNetworking {
@@ -87,10 +229,18 @@ func (o *DockerOptions) GetBaseDockerCommand(env []string) []string {
 		parts = append(parts, fmt.Sprintf("--network %s", o.Network))
 	}
 
-	// Add user if specified
+	// Add hostname aliases
+	for _, host := range o.sortedHosts() {
+		parts = append(parts, fmt.Sprintf("--add-host %s:%s", host, o.ExtraHosts[host]))
+	}
+
+	// Add user and user namespace if specified
 	if o.User != "" {
 		parts = append(parts, fmt.Sprintf("--user %s", o.User))
 	}
+	if o.Userns != "" {
+		parts = append(parts, fmt.Sprintf("--userns %s", o.Userns))
+	}
 
 	// Add working directory if specified
 	if o.WorkDir != "" {
@@ -114,6 +264,11 @@ func (o *DockerOptions) GetBaseDockerCommand(env []string) []string {
 		parts = append(parts, fmt.Sprintf("--memory-swappiness %d", o.MemorySwappiness))
 	}
 
+	// Add block I/O options if specified
+	for _, arg := range o.blkioArgs() {
+		parts = append(parts, shellQuote(arg))
+	}
+
 	// Add Linux capabilities options
 	for _, cap := range o.CapAdd {
 		parts = append(parts, fmt.Sprintf("--cap-add %s", cap))
@@ -133,11 +288,26 @@ func (o *DockerOptions) GetBaseDockerCommand(env []string) []string {
 		parts = append(parts, fmt.Sprintf("--dns-search %s", dnsSearch))
 	}
 
+	// Add hostname if specified
+	if o.Hostname != "" {
+		parts = append(parts, fmt.Sprintf("--hostname %s", o.Hostname))
+	}
+
 	// Add platform if specified
 	if o.Platform != "" {
 		parts = append(parts, fmt.Sprintf("--platform %s", o.Platform))
 	}
 
+	// Add the seccomp profile
+	if o.seccompFile != "" {
+		parts = append(parts, "--security-opt "+shellQuote("seccomp="+o.seccompFile))
+	}
+
+	// Add the core file size limit
+	if ulimit := o.dockerUlimit(); ulimit != "" {
+		parts = append(parts, fmt.Sprintf("--ulimit %s", ulimit))
+	}
+
 	// Add custom docker run options
 	if o.DockerRunOpts != "" {
 		parts = append(parts, o.DockerRunOpts)
@@ -147,6 +317,14 @@ func (o *DockerOptions) GetBaseDockerCommand(env []string) []string {
 	for _, mount := range o.Mounts {
 		parts = append(parts, fmt.Sprintf("-v %s", mount))
 	}
+	if o.CABundle != "" {
+		parts = append(parts, "-v", shellQuote(o.CABundle+":"+caBundleGuestPath+":ro"))
+	}
+
+	// Add the allowed desktop devices
+	for _, device := range o.devices() {
+		parts = append(parts, fmt.Sprintf("--device %s", device))
+	}
 
 	// Add environment variables (shell-quoted to handle values with spaces)
 	for _, e := range env {
@@ -168,7 +346,11 @@ func (o *DockerOptions) GetDockerCommand(scriptFile string, env []string) string
 
 	// Add image and the command to execute the script
 	parts = append(parts, o.Image)
-	parts = append(parts, fmt.Sprintf("sh %s", containerScriptPath))
+	shell := o.shell
+	if shell == "" {
+		shell = "sh"
+	}
+	parts = append(parts, fmt.Sprintf("%s %s", shell, containerScriptPath))
 
 	// Join all parts
 	return strings.Join(parts, " ")
@@ -197,6 +379,13 @@ func NewDockerOptions(genericOpts Options) (DockerOptions, error) {
 		MemorySwappiness: -1,   // Default to Docker's default swappiness
 	}
 
+	// Convert the values to their JSON types (e.g. []string to
+	// []interface{}), which would be ignored otherwise
+	genericOpts, err := normalizeOptions(genericOpts)
+	if err != nil {
+		return opts, err
+	}
+
 	// Parse image (required)
 	if image, ok := genericOpts["image"].(string); ok {
 		opts.Image = image
@@ -204,6 +393,16 @@ func NewDockerOptions(genericOpts Options) (DockerOptions, error) {
 		return opts, fmt.Errorf("docker runner requires 'image' option")
 	}
 
+	// Parse the container engine
+	if engine, ok := genericOpts["engine"].(string); ok {
+		opts.Engine = engine
+	}
+	switch opts.Engine {
+	case "", EngineDocker, EnginePodman:
+	default:
+		return opts, fmt.Errorf("invalid engine %q: must be %s or %s", opts.Engine, EngineDocker, EnginePodman)
+	}
+
 	// Parse optional docker run options
 	if dockerRunOpts, ok := genericOpts["docker_run_opts"].(string); ok {
 		opts.DockerRunOpts = dockerRunOpts
@@ -233,6 +432,11 @@ func NewDockerOptions(genericOpts Options) (DockerOptions, error) {
 		opts.User = user
 	}
 
+	// Parse user namespace option
+	if userns, ok := genericOpts["userns"].(string); ok {
+		opts.Userns = userns
+	}
+
 	// Parse working directory option
 	if workDir, ok := genericOpts["workdir"].(string); ok {
 		opts.WorkDir = workDir
@@ -263,6 +467,11 @@ func NewDockerOptions(genericOpts Options) (DockerOptions, error) {
 		opts.MemorySwappiness = int(swappiness)
 	}
 
+	// Parse block I/O options
+	if err := parseBlkioOptions(genericOpts, &opts); err != nil {
+		return opts, err
+	}
+
 	// Parse capabilities to add
 	if capAdd, ok := genericOpts["cap_add"].([]interface{}); ok {
 		for _, cap := range capAdd {
@@ -299,27 +508,269 @@ func NewDockerOptions(genericOpts Options) (DockerOptions, error) {
 		}
 	}
 
+	// Parse hostname option
+	if hostname, ok := genericOpts["hostname"].(string); ok && hostname != "" {
+		if err := validateHostname(hostname); err != nil {
+			return opts, err
+		}
+		opts.Hostname = hostname
+	}
+
 	// Parse platform option
 	if platform, ok := genericOpts["platform"].(string); ok {
 		opts.Platform = platform
 	}
 
+	// Parse umask option
+	if umask, ok := genericOpts["umask"].(string); ok {
+		if _, err := parseUmask(umask); err != nil {
+			return opts, err
+		}
+		opts.Umask = umask
+	}
+
+	// Parse the shell injected in images without one
+	if err := parseInjectShell(genericOpts, &opts); err != nil {
+		return opts, err
+	}
+
+	// Parse core dump options
+	if coreDumps, ok := genericOpts["core_dumps"].(string); ok {
+		opts.CoreDumps = CoreDumpPolicy(coreDumps)
+	}
+	if coreDumpMaxSize, ok := genericOpts["core_dump_max_size"].(string); ok {
+		opts.CoreDumpMaxSize = coreDumpMaxSize
+	}
+	if opts.CoreDumps == CoreDumpsCapture {
+		return opts, fmt.Errorf("core dumps cannot be captured in containers: %w", ErrNotSupported)
+	}
+	if err := opts.validateCoreDumps(); err != nil {
+		return opts, err
+	}
+
+	// Parse the sandbox verification
+	if verify, ok := genericOpts["verify_sandbox"].(bool); ok {
+		opts.VerifySandbox = verify
+	}
+	switch paths := genericOpts["canary_read_paths"].(type) {
+	case []string:
+		opts.CanaryReadPaths = paths
+	case []interface{}:
+		for _, p := range paths {
+			opts.CanaryReadPaths = append(opts.CanaryReadPaths, fmt.Sprint(p))
+		}
+	}
+	if err := opts.validateCanaries(); err != nil {
+		return opts, err
+	}
+
+	// Parse the preflight command
+	if preflight, ok := genericOpts["preflight"].(string); ok {
+		opts.Preflight = preflight
+	}
+	if err := opts.validatePreflight(); err != nil {
+		return opts, err
+	}
+
+	// Parse the seccomp profile
+	if profile, ok := genericOpts["seccomp_profile"].(string); ok {
+		opts.SeccompProfile = profile
+	}
+	if err := opts.loadSeccompProfile(); err != nil {
+		return opts, err
+	}
+	seccompFile, err := opts.dockerSeccompFile()
+	if err != nil {
+		return opts, err
+	}
+	opts.seccompFile = seccompFile
+
+	// Parse the env files
+	switch files := genericOpts["env_files"].(type) {
+	case []string:
+		opts.EnvFiles = files
+	case []interface{}:
+		for _, f := range files {
+			opts.EnvFiles = append(opts.EnvFiles, fmt.Sprint(f))
+		}
+	}
+	if err := opts.loadEnvFiles(); err != nil {
+		return opts, err
+	}
+
+	// Parse clock and locale options
+	if timezone, ok := genericOpts["timezone"].(string); ok {
+		opts.Timezone = timezone
+	}
+	if locale, ok := genericOpts["locale"].(string); ok {
+		opts.Locale = locale
+	}
+	var epoch *int64
+	switch seconds := genericOpts["source_date_epoch"].(type) {
+	case float64:
+		epoch = new(int64)
+		*epoch = int64(seconds)
+	case int:
+		epoch = new(int64)
+		*epoch = int64(seconds)
+	case int64:
+		epoch = &seconds
+	}
+	opts.SourceDateEpoch = epoch
+	// Parse published ports
+	var ports []string
+	switch value := genericOpts["publish_ports"].(type) {
+	case []string:
+		ports = value
+	case []interface{}:
+		for _, p := range value {
+			ports = append(ports, fmt.Sprint(p))
+		}
+	}
+	for _, port := range ports {
+		if _, _, err := parsePublishPort(port); err != nil {
+			return opts, err
+		}
+		opts.PublishPorts = append(opts.PublishPorts, port)
+	}
+	if len(opts.PublishPorts) > 0 && !opts.AllowNetworking {
+		return opts, fmt.Errorf("publish_ports requires allow_networking")
+	}
+	opts.PublishAddress = "127.0.0.1"
+	if address, ok := genericOpts["publish_address"].(string); ok && address != "" {
+		if net.ParseIP(address) == nil {
+			return opts, fmt.Errorf("invalid publish_address %q: must be an IP address", address)
+		}
+		opts.PublishAddress = address
+	}
+
+	// Parse network shaping
+	if shaping, ok := genericOpts["network_shaping"]; ok && shaping != nil {
+		if opts.Network == "host" {
+			return opts, fmt.Errorf("network_shaping cannot be used with the host network")
+		}
+		networkShaping, err := parseNetworkShaping(shaping)
+		if err != nil {
+			return opts, err
+		}
+		opts.NetworkShaping = networkShaping
+	}
+
+	if fakeTime, ok := genericOpts["fake_time"].(string); ok {
+		opts.FakeTime = fakeTime
+	}
+	if library, ok := genericOpts["faketime_library"].(string); ok {
+		opts.FakeTimeLibrary = library
+	}
+
+	// Parse hostname aliases
+	switch hosts := genericOpts["extra_hosts"].(type) {
+	case map[string]string:
+		opts.ExtraHosts = hosts
+	case map[string]interface{}:
+		opts.ExtraHosts = map[string]string{}
+		for host, ip := range hosts {
+			opts.ExtraHosts[host] = fmt.Sprint(ip)
+		}
+	}
+	if err := opts.validateHosts(); err != nil {
+		return opts, err
+	}
+
+	// Parse the CA bundle, mounted in the container
+	if bundle, ok := genericOpts["ca_bundle"].(string); ok {
+		opts.CABundle = bundle
+	}
+	if err := opts.validateCABundle(); err != nil {
+		return opts, err
+	}
+
+	// Parse the access to the credential agents and the display server,
+	// whose sockets cannot be mounted
+	if allow, ok := genericOpts["allow_ssh_agent"].(bool); ok {
+		opts.AllowSSHAgent = allow
+	}
+	if allow, ok := genericOpts["allow_gpg_agent"].(bool); ok {
+		opts.AllowGPGAgent = allow
+	}
+	if allow, ok := genericOpts["allow_keyring"].(bool); ok {
+		opts.AllowKeyring = allow
+	}
+	if allow, ok := genericOpts["allow_display"].(bool); ok {
+		opts.AllowDisplay = allow
+	}
+
+	// Parse the timeout of the commands
+	if timeout, ok := genericOpts["timeout"].(string); ok {
+		opts.Timeout = timeout
+	}
+	if grace, ok := genericOpts["kill_grace_period"].(string); ok {
+		opts.KillGracePeriod = grace
+	}
+	if err := opts.validateTimeouts(); err != nil {
+		return opts, err
+	}
+
+	// Parse the limits of the output of the commands
+	for key, limit := range map[string]*int{"max_output_bytes": &opts.MaxOutputBytes, "max_output_lines": &opts.MaxOutputLines} {
+		switch value := genericOpts[key].(type) {
+		case float64:
+			*limit = int(value)
+		case int:
+			*limit = value
+		}
+	}
+	if err := opts.validateOutputLimits(); err != nil {
+		return opts, err
+	}
+
+	// Parse the experimental features
+	features, err := parseFeatures(genericOpts["experimental"])
+	if err != nil {
+		return opts, err
+	}
+	opts.Experimental = features
+	if err := opts.validateSDK(); err != nil {
+		return opts, err
+	}
+
+	// Parse the desktop devices and portals
+	if allow, ok := genericOpts["allow_audio"].(bool); ok {
+		opts.AllowAudio = allow
+	}
+	if allow, ok := genericOpts["allow_video"].(bool); ok {
+		opts.AllowVideo = allow
+	}
+	if allow, ok := genericOpts["allow_dbus_portals"].(bool); ok {
+		opts.AllowDBusPortals = allow
+	}
+	for _, mount := range opts.Mounts {
+		source, _, _ := strings.Cut(mount, ":")
+		if err := opts.checkAgentMount(source); err != nil {
+			return opts, err
+		}
+		if err := opts.checkDisplayMount(source); err != nil {
+			return opts, err
+		}
+	}
+
 	return opts, nil
 }
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 // NewDocker creates a new Docker runner with the specified options.
-func NewDocker(options Options, logger *common.Logger) (*Docker, error) {
-	if logger == nil {
-		logger = common.GetLogger()
-	}
+func NewDocker(options Options, logger Logger) (*Docker, error) {
+	logger = defaultLogger(logger)
 
 	dockerOpts, err := NewDockerOptions(options)
 	if err != nil {
 		return nil, err
 	}
 
+	// The images of the runners can be removed by the ImageGC
+	touchImage(dockerOpts.Image)
+
 	// Docker executable and daemon checks are now handled by CheckImplicitRequirements()
 	return &Docker{
 		logger: logger,
@@ -327,9 +778,52 @@ func NewDocker(options Options, logger *common.Logger) (*Docker, error) {
 	}, nil
 }
 
+// pullImage pulls the image if it is not available locally, reporting the
+// progress printed by docker as EventImagePull events
+func (r *Docker) pullImage(ctx context.Context, emitter *eventEmitter) error {
+	if err := commandContext(ctx, r.opts.engine(), "image", "inspect", r.opts.Image).Run(); err == nil {
+		return nil
+	}
+
+	r.logger.Debug("Pulling image: %s", r.opts.Image)
+	pullCmd := commandContext(ctx, r.opts.engine(), "pull", r.opts.Image)
+	stdout, err := pullCmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	var stderr bytes.Buffer
+	pullCmd.Stderr = &stderr
+
+	if err := pullCmd.Start(); err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", r.opts.Image, err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		emitter.emit(Event{Type: EventImagePull, Image: r.opts.Image, Progress: scanner.Text()})
+	}
+
+	if err := pullCmd.Wait(); err != nil {
+		err = noSpaceError(err, stderr.String())
+		return fmt.Errorf("failed to pull image %s: %w: %s", r.opts.Image, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// canaryPolicy returns what the canaries verify (see CanaryOptions). The
+// connections to the host cannot be told apart from the connections to the
+// container, so the container must only have a loopback interface.
+func (r *Docker) canaryPolicy(ctx context.Context) canaryPolicy {
+	return canaryPolicy{loopbackOnly: !r.opts.AllowNetworking || loopbackNetworkFrom(ctx)}
+}
+
 // CheckImplicitRequirements checks if the runner meets its implicit requirements.
 // Docker runner requires the docker executable and a running daemon.
 func (r *Docker) CheckImplicitRequirements() error {
+	if r.opts.engine() == EnginePodman {
+		return r.checkPodman()
+	}
+
 	// Check if docker executable exists
 	if !common.CheckExecutableExists("docker") {
 		return fmt.Errorf("docker executable not found in PATH")
@@ -338,7 +832,7 @@ func (r *Docker) CheckImplicitRequirements() error {
 	// Check if Docker daemon is running
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, "docker", "stats", "--no-stream")
+	cmd := commandContext(ctx, "docker", "stats", "--no-stream")
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("docker daemon is not running: %w", err)
 	}
@@ -346,22 +840,149 @@ func (r *Docker) CheckImplicitRequirements() error {
 	return nil
 }
 
+// runsAsRoot returns whether the user of a container is root
+func runsAsRoot(user string) bool {
+	name, _, _ := strings.Cut(user, ":")
+	return name == "" || name == "root" || name == "0"
+}
+
+// policyWarnings returns the isolation features missing in the Docker
+// daemon: user namespaces (so root in the container is not root in the host)
+// and the seccomp filter of the system calls
+func (r *Docker) policyWarnings(ctx context.Context) []PolicyWarning {
+	if r.opts.engine() == EnginePodman {
+		return r.podmanPolicyWarnings(ctx)
+	}
+	output, err := exec.CommandContext(ctx, "docker", "info", "--format", "{{json .SecurityOptions}}").Output()
+	if err != nil {
+		return nil
+	}
+	var security []string
+	if err := json.Unmarshal(output, &security); err != nil {
+		return nil
+	}
+	userns, seccomp := false, false
+	for _, opt := range security {
+		userns = userns || strings.HasPrefix(opt, "name=userns") || strings.HasPrefix(opt, "name=rootless")
+		seccomp = seccomp || strings.HasPrefix(opt, "name=seccomp")
+	}
+
+	var warnings []PolicyWarning
+	if !userns && runsAsRoot(r.opts.User) {
+		warnings = append(warnings, PolicyWarning{
+			Runner:  TypeDocker,
+			Feature: "userns",
+			Option:  "user",
+			Message: "the Docker daemon does not remap user namespaces (userns-remap or rootless mode): " +
+				"root in the container is root in the host",
+		})
+	}
+	if !seccomp {
+		warnings = append(warnings, PolicyWarning{
+			Runner:  TypeDocker,
+			Feature: "seccomp",
+			Message: "the Docker daemon does not filter the system calls of the containers with seccomp",
+		})
+	}
+	return warnings
+}
+
 // Run executes the command using Docker.
 func (r *Docker) Run(ctx context.Context, shell string, cmd string, env []string, params map[string]interface{}, tmpfile bool) (string, error) {
-	// Create an exec runner that we'll use to execute the docker command
-	execRunner, err := NewExec(Options{}, r.logger)
+	return r.opts.runWithTimeout(ctx, func(ctx context.Context) (string, error) {
+		return r.run(ctx, shell, cmd, env, params, tmpfile)
+	})
+}
+
+// run is Run, with the context bounded by the timeout options
+func (r *Docker) run(ctx context.Context, shell string, cmd string, env []string, params map[string]interface{}, tmpfile bool) (string, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.opts.withEnvFiles(env)
+	env = r.opts.pinEnv(env)
+	env = r.opts.caBundleEnv(env, caBundleGuestPath)
+	env = r.opts.scrubAgentEnv(env)
+	env = r.opts.scrubDisplayEnv(env)
+
+	if err := r.opts.verifySandbox(ctx, logger, r.canaryPolicy(ctx), r.start, params); err != nil {
+		return "", err
+	}
+	if err := r.opts.runPreflight(ctx, logger, "/bin/sh", r.start, params); err != nil {
+		return "", err
+	}
+
+	// The network must be shaped before the command starts
+	if r.opts.NetworkShaping != nil {
+		return r.runShaped(ctx, shell, cmd, env, params)
+	}
+
+	touchImage(r.opts.Image)
+
+	// Only the desktop portals of the session bus are reachable, through a filtering proxy
+	opts := r.opts
+	if opts.AllowDBusPortals {
+		proxy, err := startPortalProxy(ctx, logger)
+		if err != nil {
+			return "", err
+		}
+		defer proxy.stop()
+		opts.Mounts = append(append([]string{}, opts.Mounts...), proxy.mountArg())
+		env = append(env, proxy.guestEnv())
+	}
+
+	// The experimental API client runs the container without the CLI
+	if opts.experimentEnabled(FeatureDockerSDK) {
+		return r.runSDK(ctx, logger, opts, shell, cmd, env)
+	}
+
+	// Create an exec runner that we'll use to execute the docker command,
+	// limiting the output of the container
+	execRunner, err := NewExec(r.opts.outputLimitOptions(), logger)
 	if err != nil {
 		return "", fmt.Errorf("failed to create exec runner: %w", err)
 	}
 
+	// Cancelling the context stops the container, killing it after the grace period
+	opts.containerName = fmt.Sprintf("go-restricted-runner-%d", time.Now().UnixNano())
+	stop := context.AfterFunc(ctx, func() {
+		if err := stopContainer(opts.engine(), opts.containerName, killGracePeriodFrom(ctx)); err != nil {
+			logger.Debug("Failed to stop container %s: %v", opts.containerName, err)
+		}
+	})
+	defer stop()
+
+	// Images without a shell run the commands with an injected busybox, or without a shell
+	mode := r.shellMode(ctx)
+	if mode == InjectShellBusybox {
+		mount, err := opts.busyboxMount()
+		if err != nil {
+			return "", err
+		}
+		opts.Mounts = append(append([]string{}, opts.Mounts...), mount)
+		opts.shell = injectedBusyboxPath + " sh"
+		if shell == "" {
+			shell = opts.shell
+		}
+	}
+
 	var dockerCmd string
 
-	// Determine if we should run directly or via script
-	if isSingleExecutableCommand(cmd) {
-		r.logger.Debug("Optimization: running single executable command directly in Docker: %s", cmd)
+	// Determine if we should run directly or via script (which sets the umask)
+	if mode == InjectShellDirect {
+		args, err := directArgs(cmd)
+		if err != nil {
+			return "", err
+		}
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = shellQuote(arg)
+		}
+		logger.Debug("Running the command without a shell in Docker: %v", args)
+		dockerCmd = opts.GetDirectExecutionCommand(strings.Join(quoted, " "), env)
+	} else if r.opts.Umask == "" && isSingleExecutableCommand(cmd) {
+		logger.Debug("Optimization: running single executable command directly in Docker: %s", cmd)
 
 		// Build docker command to directly execute the command without a temp script
-		dockerCmd = r.opts.GetDirectExecutionCommand(cmd, env)
+		dockerCmd = opts.GetDirectExecutionCommand(cmd, env)
 	} else {
 		// Create a temporary script file
 		scriptFile, err := r.createScriptFile(shell, cmd, env)
@@ -372,39 +993,40 @@ func (r *Docker) Run(ctx context.Context, shell string, cmd string, env []string
 		// Clean up the temporary script file when done
 		defer func() {
 			if err := os.Remove(scriptFile); err != nil {
-				r.logger.Debug("Warning: failed to remove temporary script file %s: %v", scriptFile, err)
+				logger.Debug("Warning: failed to remove temporary script file %s: %v", scriptFile, err)
 			}
 		}()
 
-		r.logger.Debug("Created temporary script file: %s", scriptFile)
+		logger.Debug("Created temporary script file: %s", scriptFile)
 
 		// Construct the docker run command with the script file
-		dockerCmd = r.opts.GetDockerCommand(scriptFile, env)
+		dockerCmd = opts.GetDockerCommand(scriptFile, env)
 	}
 
-	r.logger.Debug("Running command in Docker: %s", dockerCmd)
+	logger.Debug("Running command in Docker: %s", dockerCmd)
 
 	// Run the docker command - we set tmpfile to false because dockerCmd is already a full command
 	output, err := execRunner.Run(ctx, "sh", dockerCmd, nil, params, false)
+	if errors.Is(err, ErrOutputTruncated) {
+		return output, err
+	}
 	if err != nil {
-		return "", fmt.Errorf("docker command execution failed: %w", err)
+		if mode == "" {
+			err = opts.shellNotFoundError(err, err.Error(), "sh")
+		}
+		if dockerExitCodes.classify(err).Kind == ErrorKindBackend {
+			// the daemon failed, not the command
+			err = noSpaceError(err, err.Error())
+		}
+		return "", fmt.Errorf("%s command execution failed: %w", r.opts.engine(), err)
 	}
 
 	return output, nil
 }
 
-// createScriptFile writes the command to a temporary script file.
-func (r *Docker) createScriptFile(shell string, cmd string, env []string) (string, error) {
-	// Create a temporary file with a specific pattern
-	tmpFile, err := os.CreateTemp("", "mcpshell-docker-*.sh")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temporary script file: %w", err)
-	}
-
-	// Get the name for later usage
-	scriptPath := tmpFile.Name()
-
-	// Prepare script content
+// scriptContent returns the script running the command in the container,
+// with the environment, the umask and the preparation command
+func (r *Docker) scriptContent(shell string, cmd string, env []string) string {
 	var content strings.Builder
 	content.WriteString("#!/bin/sh\n\n")
 
@@ -416,6 +1038,11 @@ func (r *Docker) createScriptFile(shell string, cmd string, env []string) (strin
 		}
 	}
 
+	// Set the umask before any other command creates files
+	if r.opts.Umask != "" {
+		fmt.Fprintf(&content, "umask %s\n", r.opts.Umask)
+	}
+
 	// Add preparation command if specified
 	if r.opts.PrepareCommand != "" {
 		content.WriteString("\n# Preparation commands\n")
@@ -433,8 +1060,22 @@ func (r *Docker) createScriptFile(shell string, cmd string, env []string) (strin
 		fmt.Fprintf(&content, "exec sh -c %q\n", trimmedCmd)
 	}
 
+	return content.String()
+}
+
+// createScriptFile writes the command to a temporary script file.
+func (r *Docker) createScriptFile(shell string, cmd string, env []string) (string, error) {
+	// Create a temporary file with a specific pattern
+	tmpFile, err := os.CreateTemp("", "mcpshell-docker-*.sh")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary script file: %w", err)
+	}
+
+	// Get the name for later usage
+	scriptPath := tmpFile.Name()
+
 	// Write the content to the file
-	if _, err := tmpFile.WriteString(content.String()); err != nil {
+	if _, err := tmpFile.WriteString(r.scriptContent(shell, cmd, env)); err != nil {
 		// Close and remove the file in case of an error
 		_ = tmpFile.Close()       // Ignore close error, we already have a write error
 		_ = os.Remove(scriptPath) // Best effort cleanup
@@ -458,6 +1099,12 @@ func (r *Docker) createScriptFile(shell string, cmd string, env []string) (strin
 	return scriptPath, nil
 }
 
+// RunEx executes a command inside a Docker container like Run, returning its exit code and
+// both output streams. It implements the Runner interface.
+func (r *Docker) RunEx(ctx context.Context, req RunRequest) (*RunResult, error) {
+	return runEx(ctx, r, req)
+}
+
 // RunWithPipes executes a command with access to stdin/stdout/stderr pipes inside a Docker container.
 // It implements the Runner interface for interactive process communication with Docker isolation.
 //
@@ -473,22 +1120,113 @@ func (r *Docker) RunWithPipes(ctx context.Context, cmd string, args []string, en
 	wait func() error,
 	err error,
 ) {
+	e, err := r.start(ctx, cmd, args, env, params)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return e.Stdin, e.Stdout, e.Stderr, e.Wait, nil
+}
+
+// start executes a command in a Docker container and returns an execution handle for it.
+// It is used by RunWithPipes and Start.
+func (r *Docker) start(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	return r.opts.startWithTimeout(ctx, func(ctx context.Context) (*Execution, error) {
+		return r.startCommand(ctx, cmd, args, env, params)
+	})
+}
+
+// startCommand is start, with the context bounded by the timeout options
+func (r *Docker) startCommand(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.opts.withEnvFiles(env)
+	env = r.opts.pinEnv(env)
+	env = r.opts.caBundleEnv(env, caBundleGuestPath)
+	env = r.opts.scrubAgentEnv(env)
+	env = r.opts.scrubDisplayEnv(env)
+
 	// Check if context is already done
 	select {
 	case <-ctx.Done():
-		return nil, nil, nil, nil, ctx.Err()
+		return nil, ctx.Err()
 	default:
 		// Continue execution
 	}
 
-	r.logger.Debug("RunWithPipes: executing command in Docker: %s with args: %v", cmd, args)
+	if err := r.opts.verifySandbox(ctx, logger, r.canaryPolicy(ctx), r.start, params); err != nil {
+		return nil, err
+	}
+	if err := r.opts.runPreflight(ctx, logger, "/bin/sh", r.start, params); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("RunWithPipes: executing command in Docker: %s with args: %v", cmd, args)
+
+	if err := checkNoExtraFiles(ctx, r.opts.engine()); err != nil {
+		return nil, err
+	}
 
 	// First, create a long-running container that we can exec into
-	// We'll use a sleep command to keep the container alive
+	containerName, ports, removeContainer, err := r.createContainer(ctx, logger, env, params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the docker exec command with interactive mode
+	// docker exec -i <container> <cmd> <args...>
+	mode := r.shellMode(ctx)
+	containerCmd, containerArgs := r.opts.containerCommand(mode, cmd, args)
+	execArgs := []string{"exec", "-i", containerName, containerCmd}
+	execArgs = append(execArgs, containerArgs...)
+
+	logger.Debug("Executing in container: %s %v", r.opts.engine(), execArgs)
+
+	execCmd := commandContext(ctx, r.opts.engine(), execArgs...)
+
+	// Operations on the execution act on the whole container
+	backend := &containerBackend{engine: r.opts.engine(), container: containerName, mounts: r.opts.Mounts,
+		shell: containerShell(mode)}
+
+	// Cancelling the context terminates the command, and stops the container after the grace period
+	execCmd.Cancel = func() error {
+		go func() {
+			if err := backend.stop(killGracePeriodFrom(ctx)); err != nil {
+				logger.Debug("Failed to stop container %s: %v", containerName, err)
+			}
+		}()
+		return nil
+	}
+
+	// Containers stopped by a checkpoint are kept, for restoring them
+	e, err := startProcess(logger, execCmd, func() {
+		if !backend.checkpointed.Load() {
+			removeContainer()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.backend = backend
+	e.ports = ports
+	return e, nil
+}
+
+// createContainer creates a long-running container that commands can be
+// executed into, returning its name, its published ports and the function
+// removing it
+func (r *Docker) createContainer(ctx context.Context, logger Logger, env []string, params map[string]interface{}) (
+	string, []PublishedPort, func(), error) {
 	containerName := fmt.Sprintf("go-restricted-runner-%d", time.Now().UnixNano())
+	touchImage(r.opts.Image)
+
+	// The container is kept running with sleep, from busybox in images without a shell
+	mode := r.shellMode(ctx)
+	if mode == InjectShellDirect {
+		return "", nil, nil, fmt.Errorf("inject_shell %q cannot keep a container running for the commands "+
+			"of Start, RunWithPipes and sessions: %w", InjectShellDirect, ErrNotSupported)
+	}
 
 	// Build docker run command for the background container
-	dockerRunArgs := []string{"run", "--name", containerName, "-d"}
+	dockerRunArgs := []string{"run", "--name", containerName, "--label", managedLabel, "-d"}
 
 	// Add resource limits
 	if r.opts.Memory != "" {
@@ -500,18 +1238,33 @@ func (r *Docker) RunWithPipes(ctx context.Context, cmd string, args []string, en
 	if r.opts.MemorySwap != "" {
 		dockerRunArgs = append(dockerRunArgs, "--memory-swap", r.opts.MemorySwap)
 	}
+	dockerRunArgs = append(dockerRunArgs, r.opts.blkioArgs()...)
+	if ulimit := r.opts.dockerUlimit(); ulimit != "" {
+		dockerRunArgs = append(dockerRunArgs, "--ulimit", ulimit)
+	}
 
-	// Add network configuration
-	if !r.opts.AllowNetworking {
+	// Add network configuration. Containers without network keep their loopback interface.
+	loopback := loopbackNetworkFrom(ctx)
+	if loopback && len(r.opts.PublishPorts) > 0 {
+		return "", nil, nil, fmt.Errorf("publish_ports cannot be used with a loopback-only network")
+	}
+	if !r.opts.AllowNetworking || loopback {
 		dockerRunArgs = append(dockerRunArgs, "--network", "none")
 	} else if r.opts.Network != "" {
 		dockerRunArgs = append(dockerRunArgs, "--network", r.opts.Network)
 	}
+	dockerRunArgs = append(dockerRunArgs, r.opts.addHostArgs()...)
+	if r.opts.Hostname != "" {
+		dockerRunArgs = append(dockerRunArgs, "--hostname", r.opts.Hostname)
+	}
 
-	// Add user if specified
+	// Add user and user namespace if specified
 	if r.opts.User != "" {
 		dockerRunArgs = append(dockerRunArgs, "--user", r.opts.User)
 	}
+	if r.opts.Userns != "" {
+		dockerRunArgs = append(dockerRunArgs, "--userns", r.opts.Userns)
+	}
 
 	// Add working directory if specified
 	if r.opts.WorkDir != "" {
@@ -522,119 +1275,120 @@ func (r *Docker) RunWithPipes(ctx context.Context, cmd string, args []string, en
 	for _, mount := range r.opts.Mounts {
 		dockerRunArgs = append(dockerRunArgs, "-v", mount)
 	}
-
-	// Add environment variables
-	for _, envVar := range env {
-		dockerRunArgs = append(dockerRunArgs, "-e", envVar)
+	if r.opts.CABundle != "" {
+		dockerRunArgs = append(dockerRunArgs, "-v", r.opts.CABundle+":"+caBundleGuestPath+":ro")
 	}
 
-	// Add the image and a sleep command to keep container alive
-	dockerRunArgs = append(dockerRunArgs, r.opts.Image, "sleep", "infinity")
+	// Add the allowed desktop devices
+	for _, device := range r.opts.devices() {
+		dockerRunArgs = append(dockerRunArgs, "--device", device)
+	}
 
-	r.logger.Debug("Creating background container: docker %v", dockerRunArgs)
+	// Publish ports in random host ports, which are queried once the container runs
+	dockerRunArgs = append(dockerRunArgs, dockerPublishArgs(r.opts.PublishPorts, r.opts.PublishAddress)...)
 
-	// Create the container
-	createCmd := exec.CommandContext(ctx, "docker", dockerRunArgs...)
-	if output, err := createCmd.CombinedOutput(); err != nil {
-		r.logger.Debug("Failed to create container: %v, output: %s", err, string(output))
-		return nil, nil, nil, nil, fmt.Errorf("failed to create container: %w: %s", err, string(output))
+	// Ports allocated for the execution are published in the same host port (see WithFreePorts)
+	if ports := bindPorts(params); len(ports) > 0 {
+		if !r.opts.AllowNetworking || loopback {
+			return "", nil, nil, fmt.Errorf("cannot publish the allocated ports: networking is disabled")
+		}
+		for _, port := range ports {
+			dockerRunArgs = append(dockerRunArgs, "-p", fmt.Sprintf("%s:%d:%d/tcp", publishHost(r.opts.PublishAddress), port, port))
+		}
 	}
 
-	r.logger.Debug("Created container: %s", containerName)
+	// Staged input files are mounted read-only at the same path as in the host
+	if dir := inputsDir(params); dir != "" {
+		dockerRunArgs = append(dockerRunArgs, "-v", dir+":"+dir+":ro")
+	}
 
-	// Build the docker exec command with interactive mode
-	// docker exec -i <container> <cmd> <args...>
-	execArgs := []string{"exec", "-i", containerName, cmd}
-	execArgs = append(execArgs, args...)
+	// The workspace and scratch directories are mounted read-write at the same path as in the host
+	for _, dir := range writableDirs(params) {
+		dockerRunArgs = append(dockerRunArgs, "-v", dir+":"+dir)
+	}
 
-	r.logger.Debug("Executing in container: docker %v", execArgs)
+	// Only the desktop portals of the session bus are reachable, through a filtering proxy
+	var proxy *dbusProxy
+	if r.opts.AllowDBusPortals {
+		var err error
+		if proxy, err = startPortalProxy(ctx, logger); err != nil {
+			return "", nil, nil, err
+		}
+		dockerRunArgs = append(dockerRunArgs, "-v", proxy.mountArg())
+		env = append(env, proxy.guestEnv())
+	}
+	stopProxy := func() {
+		if proxy != nil {
+			proxy.stop()
+		}
+	}
 
-	execCmd := exec.CommandContext(ctx, "docker", execArgs...)
+	// Add environment variables
+	for _, envVar := range env {
+		dockerRunArgs = append(dockerRunArgs, "-e", envVar)
+	}
 
-	// Create pipes for stdin, stdout, and stderr
-	stdinPipe, err := execCmd.StdinPipe()
-	if err != nil {
-		// Clean up the container
-		cleanupCmd := exec.Command("docker", "rm", "-f", containerName)
-		if cleanupErr := cleanupCmd.Run(); cleanupErr != nil {
-			r.logger.Debug("Warning: failed to cleanup container during error handling: %v", cleanupErr)
+	// Add the image and a sleep command to keep container alive
+	if mode == InjectShellBusybox {
+		mount, err := r.opts.busyboxMount()
+		if err != nil {
+			stopProxy()
+			return "", nil, nil, err
 		}
-		r.logger.Debug("Failed to create stdin pipe: %v", err)
-		return nil, nil, nil, nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+		dockerRunArgs = append(dockerRunArgs, "-v", mount, "--entrypoint", injectedBusyboxPath,
+			r.opts.Image, "sleep", "2147483647")
+	} else {
+		dockerRunArgs = append(dockerRunArgs, r.opts.Image, "sleep", "infinity")
 	}
 
-	stdoutPipe, err := execCmd.StdoutPipe()
-	if err != nil {
-		if closeErr := stdinPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdin pipe: %v", closeErr)
-		}
-		cleanupCmd := exec.Command("docker", "rm", "-f", containerName)
-		if cleanupErr := cleanupCmd.Run(); cleanupErr != nil {
-			r.logger.Debug("Warning: failed to cleanup container during error handling: %v", cleanupErr)
+	// Pull the image explicitly when its progress is being reported
+	if emitter := eventEmitterFrom(ctx); emitter != nil {
+		if err := r.pullImage(ctx, emitter); err != nil {
+			stopProxy()
+			return "", nil, nil, err
 		}
-		r.logger.Debug("Failed to create stdout pipe: %v", err)
-		return nil, nil, nil, nil, fmt.Errorf("failed to create stdout pipe: %w", err)
 	}
 
-	stderrPipe, err := execCmd.StderrPipe()
-	if err != nil {
-		if closeErr := stdinPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdin pipe: %v", closeErr)
-		}
-		if closeErr := stdoutPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdout pipe: %v", closeErr)
-		}
-		cleanupCmd := exec.Command("docker", "rm", "-f", containerName)
-		if cleanupErr := cleanupCmd.Run(); cleanupErr != nil {
-			r.logger.Debug("Warning: failed to cleanup container during error handling: %v", cleanupErr)
+	logger.Debug("Creating background container: %s %v", r.opts.engine(), dockerRunArgs)
+
+	// Create the container
+	createCmd := commandContext(ctx, r.opts.engine(), dockerRunArgs...)
+	if output, err := createCmd.CombinedOutput(); err != nil {
+		logger.Debug("Failed to create container: %v, output: %s", err, string(output))
+		stopProxy()
+		if mode == "" {
+			err = r.opts.shellNotFoundError(err, string(output), "sleep")
 		}
-		r.logger.Debug("Failed to create stderr pipe: %v", err)
-		return nil, nil, nil, nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+		err = noSpaceError(err, string(output))
+		return "", nil, nil, fmt.Errorf("failed to create container: %w: %s", err, string(output))
 	}
 
-	// Start the exec command
-	r.logger.Debug("Starting docker exec command")
-	if err := execCmd.Start(); err != nil {
-		if closeErr := stdinPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdin pipe: %v", closeErr)
-		}
-		if closeErr := stdoutPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdout pipe: %v", closeErr)
-		}
-		if closeErr := stderrPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stderr pipe: %v", closeErr)
-		}
-		cleanupCmd := exec.Command("docker", "rm", "-f", containerName)
-		if cleanupErr := cleanupCmd.Run(); cleanupErr != nil {
-			r.logger.Debug("Warning: failed to cleanup container during error handling: %v", cleanupErr)
+	logger.Debug("Created container: %s", containerName)
+
+	if r.opts.NetworkShaping != nil && !loopback {
+		if err := r.shapeNetwork(ctx, logger, containerName); err != nil {
+			_ = exec.Command(r.opts.engine(), "rm", "-f", containerName).Run()
+			stopProxy()
+			return "", nil, nil, err
 		}
-		r.logger.Debug("Failed to start docker exec: %v", err)
-		return nil, nil, nil, nil, fmt.Errorf("failed to start docker exec: %w", err)
 	}
 
-	r.logger.Debug("Docker exec started successfully")
-
-	// Create wait function that waits for the command to complete and cleans up the container
-	waitFunc := func() error {
-		r.logger.Debug("Waiting for docker exec to complete")
-		execErr := execCmd.Wait()
+	ports, err := dockerPublishedPorts(ctx, r.opts.engine(), containerName, r.opts.PublishPorts)
+	if err != nil {
+		_ = exec.Command(r.opts.engine(), "rm", "-f", containerName).Run()
+		stopProxy()
+		return "", nil, nil, err
+	}
 
-		// Clean up the container
-		r.logger.Debug("Cleaning up container: %s", containerName)
-		cleanupCmd := exec.Command("docker", "rm", "-f", containerName)
+	removeContainer := func() {
+		logger.Debug("Cleaning up container: %s", containerName)
+		cleanupCmd := exec.Command(r.opts.engine(), "rm", "-f", containerName)
 		if cleanupOutput, cleanupErr := cleanupCmd.CombinedOutput(); cleanupErr != nil {
-			r.logger.Debug("Warning: failed to remove container %s: %v, output: %s", containerName, cleanupErr, string(cleanupOutput))
+			logger.Debug("Warning: failed to remove container %s: %v, output: %s", containerName, cleanupErr, string(cleanupOutput))
 		} else {
-			r.logger.Debug("Container %s removed successfully", containerName)
-		}
-
-		if execErr != nil {
-			r.logger.Debug("Docker exec completed with error: %v", execErr)
-			return execErr
+			logger.Debug("Container %s removed successfully", containerName)
 		}
-		r.logger.Debug("Docker exec completed successfully")
-		return nil
+		stopProxy()
 	}
-
-	return stdinPipe, stdoutPipe, stderrPipe, waitFunc, nil
+	return containerName, ports, removeContainer, nil
 }
@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// deviceRatePathRE matches the devices of the rate limits
+var deviceRatePathRE = regexp.MustCompile(`^/dev/[A-Za-z0-9/_.-]+$`)
+
+// Limits of the relative block I/O weight of a container
+const (
+	minBlkioWeight = 10
+	maxBlkioWeight = 1000
+)
+
+// parseBlkioOptions parses the block I/O options of the Docker runner
+func parseBlkioOptions(genericOpts Options, opts *DockerOptions) error {
+	switch weight := genericOpts["blkio_weight"].(type) {
+	case float64:
+		opts.BlkioWeight = int(weight)
+	case int:
+		opts.BlkioWeight = weight
+	}
+	if opts.BlkioWeight != 0 && (opts.BlkioWeight < minBlkioWeight || opts.BlkioWeight > maxBlkioWeight) {
+		return fmt.Errorf("invalid blkio_weight %d: must be between %d and %d",
+			opts.BlkioWeight, minBlkioWeight, maxBlkioWeight)
+	}
+
+	for _, option := range []struct {
+		key    string
+		limits *[]string
+	}{
+		{"device_read_bps", &opts.DeviceReadBps},
+		{"device_write_bps", &opts.DeviceWriteBps},
+	} {
+		switch value := genericOpts[option.key].(type) {
+		case []string:
+			*option.limits = value
+		case []interface{}:
+			for _, limit := range value {
+				*option.limits = append(*option.limits, fmt.Sprint(limit))
+			}
+		}
+		for _, limit := range *option.limits {
+			if err := validateDeviceRate(limit); err != nil {
+				return fmt.Errorf("invalid %s: %w", option.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateDeviceRate checks a device rate limit, as "device:rate" (e.g.
+// "/dev/sda:10mb")
+func validateDeviceRate(limit string) error {
+	device, rate, ok := strings.Cut(limit, ":")
+	if !ok || !deviceRatePathRE.MatchString(device) {
+		return fmt.Errorf("%q must be a device in /dev and a rate, like \"/dev/sda:10mb\"", limit)
+	}
+	// docker accepts units like "10mb" as well as "10m"
+	number := strings.ToLower(rate)
+	if len(number) > 2 && strings.HasSuffix(number, "b") && strings.IndexByte("kmgt", number[len(number)-2]) >= 0 {
+		number = strings.TrimSuffix(number, "b")
+	}
+	bytes, err := parseByteSize(number)
+	if err != nil || bytes == 0 {
+		return fmt.Errorf("invalid rate %q of %s: must be a positive number of bytes per second, "+
+			"optionally followed by kb, mb or gb", rate, device)
+	}
+	return nil
+}
+
+// blkioArgs returns the docker run arguments of the block I/O options
+func (o *DockerOptions) blkioArgs() []string {
+	var args []string
+	if o.BlkioWeight != 0 {
+		args = append(args, "--blkio-weight", strconv.Itoa(o.BlkioWeight))
+	}
+	for _, limit := range o.DeviceReadBps {
+		args = append(args, "--device-read-bps", limit)
+	}
+	for _, limit := range o.DeviceWriteBps {
+		args = append(args, "--device-write-bps", limit)
+	}
+	return args
+}
+
+// blkioLimited returns whether the block I/O of the container is limited
+func (o *DockerOptions) blkioLimited() bool {
+	return o.BlkioWeight != 0 || len(o.DeviceReadBps) > 0 || len(o.DeviceWriteBps) > 0
+}
@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDockerOptions_Blkio(t *testing.T) {
+	opts, err := NewDockerOptions(Options{
+		"image":            "alpine:latest",
+		"blkio_weight":     float64(100),
+		"device_read_bps":  []interface{}{"/dev/sda:50mb"},
+		"device_write_bps": []interface{}{"/dev/sda:1048576", "/dev/nvme0n1:20m"},
+	})
+	if err != nil {
+		t.Fatalf("NewDockerOptions failed: %v", err)
+	}
+	want := []string{
+		"--blkio-weight", "100",
+		"--device-read-bps", "/dev/sda:50mb",
+		"--device-write-bps", "/dev/sda:1048576",
+		"--device-write-bps", "/dev/nvme0n1:20m",
+	}
+	if args := opts.blkioArgs(); !reflect.DeepEqual(args, want) {
+		t.Errorf("blkioArgs() = %v, want %v", args, want)
+	}
+	cmd := strings.Join(opts.GetBaseDockerCommand(nil), " ")
+	if !strings.Contains(cmd, strings.Join(want, " ")) {
+		t.Errorf("expected the block I/O options in %q", cmd)
+	}
+
+	opts, err = NewDockerOptions(Options{"image": "alpine:latest"})
+	if err != nil {
+		t.Fatalf("NewDockerOptions failed: %v", err)
+	}
+	if args := opts.blkioArgs(); len(args) != 0 || opts.blkioLimited() {
+		t.Errorf("blkioArgs() without options = %v", args)
+	}
+}
+
+func TestDockerOptions_BlkioInvalid(t *testing.T) {
+	for _, options := range []Options{
+		{"blkio_weight": float64(5)},
+		{"blkio_weight": float64(1001)},
+		{"device_read_bps": []interface{}{"/dev/sda"}},
+		{"device_read_bps": []interface{}{"sda:10mb"}},
+		{"device_write_bps": []interface{}{"/dev/sda:fast"}},
+		{"device_write_bps": []interface{}{"/dev/sda:0"}},
+		{"device_read_bps": []interface{}{"/dev/sda;touch /tmp/pwn;x:10mb"}},
+		{"device_read_bps": []interface{}{"/etc/passwd:10mb"}},
+	} {
+		options["image"] = "alpine:latest"
+		if _, err := NewDockerOptions(options); err == nil {
+			t.Errorf("NewDockerOptions(%v) should fail", options)
+		}
+	}
+}
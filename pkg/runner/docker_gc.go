@@ -0,0 +1,359 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// managedLabel labels the containers created by the Docker runner, so they
+// can be garbage collected (see ImageGC)
+const managedLabel = "go-restricted-runner"
+
+// engineTimeLayout is the layout of the creation times listed by the
+// container engines
+const engineTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// usedImages are the images of the Docker runners of this process, with the
+// time they were last used
+var usedImages = struct {
+	sync.Mutex
+	lastUse map[string]time.Time
+}{lastUse: map[string]time.Time{}}
+
+// touchImage records that an image is used by a runner
+func touchImage(image string) {
+	usedImages.Lock()
+	defer usedImages.Unlock()
+	usedImages.lastUse[normalizeImageRef(image)] = time.Now()
+}
+
+// imageLastUse returns when an image was last used by a runner of this
+// process, if it was
+func imageLastUse(image string) (time.Time, bool) {
+	usedImages.Lock()
+	defer usedImages.Unlock()
+	lastUse, ok := usedImages.lastUse[normalizeImageRef(image)]
+	return lastUse, ok
+}
+
+// normalizeImageRef returns an image reference in the form listed by the
+// engines, with its tag and without the default registry
+func normalizeImageRef(ref string) string {
+	for _, prefix := range []string{"docker.io/library/", "docker.io/"} {
+		if rest, ok := strings.CutPrefix(ref, prefix); ok {
+			ref = rest
+			break
+		}
+	}
+	if !strings.Contains(ref, "@") && !strings.Contains(ref[strings.LastIndex(ref, "/")+1:], ":") {
+		ref += ":latest"
+	}
+	return ref
+}
+
+// matchImage returns whether an image matches one of the patterns, which
+// match the reference (e.g. "python:3.*") or the repository of the image
+// (e.g. "ghcr.io/acme/*")
+func matchImage(patterns []string, ref string) bool {
+	ref = normalizeImageRef(ref)
+	repo := ref
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		repo = ref[:i]
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, repo); ok {
+			return true
+		}
+		if ok, _ := path.Match(normalizeImageRef(pattern), ref); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ImageGCOptions is the options for an ImageGC
+type ImageGCOptions struct {
+	// Engine is the container engine, EngineDocker (by default) or EnginePodman
+	Engine string
+
+	// Path is a path in the filesystem whose usage is checked (the root
+	// folder of the engine by default, e.g. /var/lib/docker)
+	Path string
+
+	// HighWatermark is the percentage of the filesystem used above which
+	// Collect removes containers and images (85 by default)
+	HighWatermark float64
+
+	// LowWatermark is the percentage of the filesystem used Collect stops
+	// removing images at (70 by default)
+	LowWatermark float64
+
+	// Images are patterns of the images that can be removed (e.g.
+	// "python:3.*" or "ghcr.io/acme/*"), besides the images of the Docker
+	// runners created by this process
+	Images []string
+
+	// PinnedImages are patterns of the images that are never removed
+	PinnedImages []string
+
+	// StoppedContainerAge is the age after which the stopped containers of
+	// the runners are removed (1 hour by default). Checkpointed containers are
+	// stopped: use a longer age to keep them until they are restored.
+	StoppedContainerAge time.Duration
+
+	// RunningContainerAge is the age after which the running containers of
+	// the runners are removed, e.g. containers left running by a process
+	// that crashed (never by default)
+	RunningContainerAge time.Duration
+}
+
+// ImageGCReport is the result of a garbage collection
+type ImageGCReport struct {
+	// Before and After are the usage of the filesystem of the engine before
+	// and after the collection
+	Before DiskUsage `json:"before"`
+	After  DiskUsage `json:"after"`
+
+	// RemovedContainers are the IDs of the containers removed
+	RemovedContainers []string `json:"removed_containers,omitempty"`
+
+	// RemovedImages are the references of the images removed
+	RemovedImages []string `json:"removed_images,omitempty"`
+}
+
+// ImageGC removes the containers left behind by the Docker runner and the
+// images of the runners when the disk of the container engine fills up, so
+// long-running execution hosts do not run out of space (see ErrNoSpace).
+//
+// Only the containers labelled by the runners are removed, and only the
+// images used by the Docker runners of this process or matching the
+// configured patterns, never the pinned images nor the images used by
+// containers.
+type ImageGC struct {
+	logger  Logger
+	options ImageGCOptions
+
+	// mu serializes the collections
+	mu sync.Mutex
+}
+
+// NewImageGC creates a new ImageGC with the provided logger.
+// If logger is nil, a default logger is created.
+func NewImageGC(options ImageGCOptions, logger Logger) (*ImageGC, error) {
+	logger = defaultLogger(logger)
+	if options.Engine == "" {
+		options.Engine = EngineDocker
+	}
+	if options.Engine != EngineDocker && options.Engine != EnginePodman {
+		return nil, fmt.Errorf("invalid engine %q: must be %s or %s", options.Engine, EngineDocker, EnginePodman)
+	}
+	if options.HighWatermark == 0 {
+		options.HighWatermark = 85
+	}
+	if options.LowWatermark == 0 {
+		options.LowWatermark = min(70, options.HighWatermark)
+	}
+	if options.HighWatermark < 0 || options.HighWatermark > 100 {
+		return nil, fmt.Errorf("invalid high watermark %v: must be between 0 and 100", options.HighWatermark)
+	}
+	if options.LowWatermark < 0 || options.LowWatermark > options.HighWatermark {
+		return nil, fmt.Errorf("invalid low watermark %v: must be between 0 and the high watermark", options.LowWatermark)
+	}
+	for _, pattern := range append(append([]string{}, options.Images...), options.PinnedImages...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid image pattern %q: %w", pattern, err)
+		}
+	}
+	if options.StoppedContainerAge == 0 {
+		options.StoppedContainerAge = time.Hour
+	}
+	return &ImageGC{logger: logger, options: options}, nil
+}
+
+// DiskUsage returns the usage of the filesystem of the engine
+func (g *ImageGC) DiskUsage(ctx context.Context) (DiskUsage, error) {
+	dir := g.options.Path
+	if dir == "" {
+		format := "{{.DockerRootDir}}"
+		if g.options.Engine == EnginePodman {
+			format = "{{.Store.GraphRoot}}"
+		}
+		output, err := commandContext(ctx, g.options.Engine, "info", "--format", format).Output()
+		if err != nil {
+			return DiskUsage{}, fmt.Errorf("failed to get the root folder of %s: %w", g.options.Engine, err)
+		}
+		dir = strings.TrimSpace(string(output))
+	}
+	return GetDiskUsage(dir)
+}
+
+// Collect removes the old containers of the runners and, when the usage of
+// the filesystem of the engine is above the high watermark, the images that
+// can be removed, least recently used first, until it is below the low
+// watermark. The errors of the containers and images that could not be
+// removed are returned with the report.
+func (g *ImageGC) Collect(ctx context.Context) (*ImageGCReport, error) {
+	return g.collect(ctx, false)
+}
+
+// Prune removes the old containers of the runners and all the images that
+// can be removed, whatever the usage of the filesystem
+func (g *ImageGC) Prune(ctx context.Context) (*ImageGCReport, error) {
+	return g.collect(ctx, true)
+}
+
+// Run collects every interval until the context is cancelled
+func (g *ImageGC) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		report, err := g.Collect(ctx)
+		if err != nil {
+			g.logger.Info("Image garbage collection failed: %v", err)
+		}
+		if report != nil && (len(report.RemovedContainers) > 0 || len(report.RemovedImages) > 0) {
+			g.logger.Info("Image garbage collection removed %d containers and %d images: %.1f%% of %s used",
+				len(report.RemovedContainers), len(report.RemovedImages), report.After.Used(), report.After.Path)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collect removes the old containers and the images, until the low
+// watermark is reached unless all is set
+func (g *ImageGC) collect(ctx context.Context, all bool) (*ImageGCReport, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	usage, err := g.DiskUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &ImageGCReport{Before: usage, After: usage}
+
+	var errs []error
+	removed, err := g.removeContainers(ctx)
+	report.RemovedContainers = removed
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if all || usage.Used() >= g.options.HighWatermark {
+		images, err := g.removableImages(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, image := range images {
+			if !all {
+				if usage, err = g.DiskUsage(ctx); err != nil {
+					errs = append(errs, err)
+					break
+				}
+				if usage.Used() <= g.options.LowWatermark {
+					break
+				}
+			}
+			if output, err := commandContext(ctx, g.options.Engine, "image", "rm", image).CombinedOutput(); err != nil {
+				// e.g. used by a container
+				errs = append(errs, fmt.Errorf("failed to remove image %s: %w: %s", image, err, strings.TrimSpace(string(output))))
+				continue
+			}
+			g.logger.Debug("Removed image %s", image)
+			report.RemovedImages = append(report.RemovedImages, image)
+		}
+	}
+
+	if report.After, err = g.DiskUsage(ctx); err != nil {
+		errs = append(errs, err)
+		report.After = usage
+	}
+	return report, errors.Join(errs...)
+}
+
+// removeContainers removes the containers of the runners older than their
+// age, returning their IDs
+func (g *ImageGC) removeContainers(ctx context.Context) ([]string, error) {
+	output, err := commandContext(ctx, g.options.Engine, "ps", "-a", "--filter", "label="+managedLabel,
+		"--format", "{{.ID}}\t{{.State}}\t{{.CreatedAt}}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the containers: %w", err)
+	}
+
+	var removed []string
+	var errs []error
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		id, state := fields[0], fields[1]
+		created, err := time.Parse(engineTimeLayout, fields[2])
+		if err != nil {
+			g.logger.Debug("Keeping container %s with an unknown creation time %q", id, fields[2])
+			continue
+		}
+		age := time.Since(created)
+		running := state == "running" || state == "paused" || state == "restarting"
+		switch {
+		case running && (g.options.RunningContainerAge <= 0 || age < g.options.RunningContainerAge):
+			continue
+		case !running && age < g.options.StoppedContainerAge:
+			continue
+		}
+		if output, err := commandContext(ctx, g.options.Engine, "rm", "-f", id).CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove container %s: %w: %s", id, err, strings.TrimSpace(string(output))))
+			continue
+		}
+		g.logger.Debug("Removed %s container %s created %v ago", state, id, age.Round(time.Second))
+		removed = append(removed, id)
+	}
+	return removed, errors.Join(errs...)
+}
+
+// removableImages returns the images that can be removed, least recently
+// used first
+func (g *ImageGC) removableImages(ctx context.Context) ([]string, error) {
+	output, err := commandContext(ctx, g.options.Engine, "image", "ls",
+		"--format", "{{.Repository}}:{{.Tag}}\t{{.CreatedAt}}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the images: %w", err)
+	}
+
+	type candidate struct {
+		ref     string
+		lastUse time.Time
+	}
+	var candidates []candidate
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		ref, createdAt, _ := strings.Cut(line, "\t")
+		if ref == "" || strings.Contains(ref, "<none>") || matchImage(g.options.PinnedImages, ref) {
+			continue
+		}
+		lastUse, used := imageLastUse(ref)
+		if !used {
+			if !matchImage(g.options.Images, ref) {
+				continue
+			}
+			// never used by this process: ordered by creation
+			lastUse, _ = time.Parse(engineTimeLayout, createdAt)
+		}
+		candidates = append(candidates, candidate{ref: ref, lastUse: lastUse})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].lastUse.Before(candidates[j].lastUse) })
+
+	images := make([]string, len(candidates))
+	for i, c := range candidates {
+		images[i] = c.ref
+	}
+	return images, nil
+}
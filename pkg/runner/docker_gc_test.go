@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchImage(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		ref      string
+		want     bool
+	}{
+		{[]string{"alpine"}, "alpine:latest", true},
+		{[]string{"alpine"}, "docker.io/library/alpine", true},
+		{[]string{"alpine"}, "alpine:3.20", true},
+		{[]string{"alpine:3.*"}, "alpine:3.20", true},
+		{[]string{"alpine:3.*"}, "alpine:latest", false},
+		{[]string{"ghcr.io/acme/*"}, "ghcr.io/acme/tool:v1", true},
+		{[]string{"ghcr.io/acme/*"}, "ghcr.io/other/tool:v1", false},
+		{[]string{"localhost:5000/tool"}, "localhost:5000/tool:latest", true},
+		{nil, "alpine:latest", false},
+	}
+	for _, tt := range tests {
+		if got := matchImage(tt.patterns, tt.ref); got != tt.want {
+			t.Errorf("matchImage(%q, %q) = %v, want %v", tt.patterns, tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestNewImageGC(t *testing.T) {
+	for _, options := range []ImageGCOptions{
+		{Engine: "containerd"},
+		{HighWatermark: 120},
+		{HighWatermark: 50, LowWatermark: 60},
+		{PinnedImages: []string{"alpine["}},
+	} {
+		if _, err := NewImageGC(options, nil); err == nil {
+			t.Errorf("NewImageGC(%+v) should fail", options)
+		}
+	}
+}
+
+func TestImageGC_Prune(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "docker.log")
+	old := "2020-01-02 15:04:05 +0000 UTC"
+	recent := time.Now().UTC().Format(engineTimeLayout)
+	fakeTool(t, "docker", `echo "$@" >> `+log+`
+case "$1 $2" in
+ps*) printf 'c1\texited\t`+old+`\nc2\texited\t`+recent+`\nc3\trunning\t`+old+`\n' ;;
+"image ls") printf 'gc-test/alpine:latest\t`+old+`\nacme/tool:2\t`+old+`\nacme/pinned:1\t`+old+`\nunrelated:1\t`+old+`\n<none>:<none>\t`+old+`\n' ;;
+"image rm") [ "$3" = "acme/tool:2" ] && { echo "image is being used by running container" >&2; exit 1; } ;;
+esac
+exit 0
+`)
+	touchImage("gc-test/alpine")
+
+	gc, err := NewImageGC(ImageGCOptions{Path: t.TempDir(), Images: []string{"acme/*"}, PinnedImages: []string{"acme/pinned"}}, nil)
+	if err != nil {
+		t.Fatalf("NewImageGC failed: %v", err)
+	}
+	report, err := gc.Prune(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "acme/tool:2") {
+		t.Errorf("Prune() error = %v, want the failure of the image in use", err)
+	}
+	if report == nil {
+		t.Fatalf("Prune() returned no report")
+	}
+	if !reflect.DeepEqual(report.RemovedContainers, []string{"c1"}) {
+		t.Errorf("RemovedContainers = %q, want the old stopped container", report.RemovedContainers)
+	}
+	if !reflect.DeepEqual(report.RemovedImages, []string{"gc-test/alpine:latest"}) {
+		t.Errorf("RemovedImages = %q", report.RemovedImages)
+	}
+
+	calls, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(calls), "ps -a --filter label="+managedLabel) {
+		t.Errorf("the containers were not filtered by label:\n%s", calls)
+	}
+	for _, kept := range []string{"rm -f c2", "rm -f c3", "acme/pinned", "image rm unrelated"} {
+		if strings.Contains(string(calls), kept) {
+			t.Errorf("%q was removed:\n%s", kept, calls)
+		}
+	}
+}
+
+func TestImageGC_Collect(t *testing.T) {
+	fakeTool(t, "docker", `[ "$1 $2" = "image ls" ] && printf 'gc-test/collect:1\t2020-01-02 15:04:05 +0000 UTC\n'
+exit 0
+`)
+	touchImage("gc-test/collect:1")
+
+	// the disk is below the high watermark: only the containers are collected
+	gc, err := NewImageGC(ImageGCOptions{Path: t.TempDir(), HighWatermark: 100}, nil)
+	if err != nil {
+		t.Fatalf("NewImageGC failed: %v", err)
+	}
+	report, err := gc.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	if len(report.RemovedImages) > 0 || report.Before.Path == "" {
+		t.Errorf("Collect() = %+v", report)
+	}
+}
@@ -0,0 +1,172 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NetworkShaping degrades the network of a container with tc netem, to
+// simulate slow or unreliable networks in integration tests
+type NetworkShaping struct {
+	// Latency added to every packet (e.g. "100ms")
+	Latency string `json:"latency"`
+
+	// Jitter is the random variation of the latency (e.g. "20ms")
+	Jitter string `json:"jitter"`
+
+	// Loss is the percentage of packets dropped (0 to 100)
+	Loss float64 `json:"loss"`
+
+	// Rate is the bandwidth cap, in tc units (e.g. "1mbit", "512kbit")
+	Rate string `json:"rate"`
+
+	// Image is the image providing the tc command (defaults to the runner image).
+	// It is run in a separate container, with the NET_ADMIN capability, that
+	// shares the network namespace of the container of the command.
+	Image string `json:"image"`
+}
+
+// tcRateRegexp matches the rates accepted by tc
+var tcRateRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?([kmgt]?bit|[kmgt]?bps)$`)
+
+// parseNetworkShaping parses the network_shaping option
+func parseNetworkShaping(value interface{}) (*NetworkShaping, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var shaping NetworkShaping
+	if err := json.Unmarshal(data, &shaping); err != nil {
+		return nil, fmt.Errorf("invalid network_shaping: %w", err)
+	}
+	if _, err := shaping.netemArgs(); err != nil {
+		return nil, err
+	}
+	return &shaping, nil
+}
+
+// tcDuration converts a Go duration to a tc time
+func tcDuration(name string, value string) (string, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return "", fmt.Errorf("invalid network_shaping %s %q: must be a duration like \"100ms\"", name, value)
+	}
+	return fmt.Sprintf("%dus", d.Microseconds()), nil
+}
+
+// netemArgs returns the netem parameters of `tc qdisc add ... netem`
+func (s *NetworkShaping) netemArgs() ([]string, error) {
+	var args []string
+
+	if s.Latency != "" {
+		latency, err := tcDuration("latency", s.Latency)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "delay", latency)
+		if s.Jitter != "" {
+			jitter, err := tcDuration("jitter", s.Jitter)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, jitter)
+		}
+	} else if s.Jitter != "" {
+		return nil, fmt.Errorf("network_shaping jitter requires a latency")
+	}
+
+	if s.Loss < 0 || s.Loss > 100 {
+		return nil, fmt.Errorf("invalid network_shaping loss %v: must be a percentage", s.Loss)
+	}
+	if s.Loss > 0 {
+		args = append(args, "loss", fmt.Sprintf("%g%%", s.Loss))
+	}
+
+	if s.Rate != "" {
+		if !tcRateRegexp.MatchString(s.Rate) {
+			return nil, fmt.Errorf("invalid network_shaping rate %q: must be a tc rate like \"1mbit\"", s.Rate)
+		}
+		args = append(args, "rate", s.Rate)
+	}
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("network_shaping requires a latency, a loss or a rate")
+	}
+	return args, nil
+}
+
+// shapeNetwork applies the network shaping to a running container
+func (r *Docker) shapeNetwork(ctx context.Context, logger Logger, containerName string) error {
+	if !r.opts.AllowNetworking {
+		logger.Debug("Not shaping the network of container %s: networking is disabled", containerName)
+		return nil
+	}
+
+	netem, err := r.opts.NetworkShaping.netemArgs()
+	if err != nil {
+		return err
+	}
+	image := r.opts.NetworkShaping.Image
+	if image == "" {
+		image = r.opts.Image
+	}
+
+	args := []string{
+		"run", "--rm", "--label", managedLabel,
+		"--network", "container:" + containerName,
+		"--cap-add", "NET_ADMIN",
+		"--entrypoint", "tc",
+		image,
+		"qdisc", "add", "dev", "eth0", "root", "netem",
+	}
+	args = append(args, netem...)
+
+	logger.Debug("Shaping the network of container %s: %s %v", containerName, r.opts.engine(), args)
+	if output, err := commandContext(ctx, r.opts.engine(), args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to shape the network of the container: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// runShaped executes a command in a container whose network is shaped.
+// The network must be shaped before the command starts, so the command is
+// executed in a container created in the background, as with Start.
+func (r *Docker) runShaped(ctx context.Context, shell string, cmd string, env []string, params map[string]interface{}) (string, error) {
+	if shell == "" {
+		shell = "sh"
+	}
+	script := strings.TrimSpace(cmd)
+	if r.opts.PrepareCommand != "" {
+		script = r.opts.PrepareCommand + "\n" + script
+	}
+
+	e, err := r.startCommand(ctx, shell, []string{"-c", script}, env, params)
+	if err != nil {
+		return "", err
+	}
+	_ = e.Stdin.Close()
+
+	var stdout, stderr bytes.Buffer
+	stdoutWriter, stderrWriter := captureRunOutput(ctx, &stdout, &stderr)
+	stdoutWriter, stderrWriter, truncated := r.opts.limitOutput(ctx, stdoutWriter, stderrWriter)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); _, _ = io.Copy(stdoutWriter, e.Stdout) }()
+	go func() { defer wg.Done(); _, _ = io.Copy(stderrWriter, e.Stderr) }()
+	wg.Wait()
+
+	if err := e.Wait(); err != nil {
+		if stderr.Len() > 0 {
+			return "", newCommandError(strings.TrimSpace(stderr.String()), err)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), truncated()
+}
@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNetworkShaping_NetemArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		shaping NetworkShaping
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "latency and jitter",
+			shaping: NetworkShaping{Latency: "100ms", Jitter: "20ms"},
+			want:    []string{"delay", "100000us", "20000us"},
+		},
+		{
+			name:    "loss and rate",
+			shaping: NetworkShaping{Loss: 1.5, Rate: "1mbit"},
+			want:    []string{"loss", "1.5%", "rate", "1mbit"},
+		},
+		{
+			name:    "nothing to shape",
+			shaping: NetworkShaping{},
+			wantErr: true,
+		},
+		{
+			name:    "jitter without latency",
+			shaping: NetworkShaping{Jitter: "10ms"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid latency",
+			shaping: NetworkShaping{Latency: "fast"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid loss",
+			shaping: NetworkShaping{Loss: 120},
+			wantErr: true,
+		},
+		{
+			name:    "invalid rate",
+			shaping: NetworkShaping{Rate: "1mbit; reboot"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.shaping.netemArgs()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("netemArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("netemArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDockerOptions_NetworkShaping(t *testing.T) {
+	opts, err := NewDockerOptions(Options{
+		"image":           "alpine",
+		"network_shaping": map[string]interface{}{"latency": "50ms", "loss": 2.0},
+	})
+	if err != nil {
+		t.Fatalf("NewDockerOptions failed: %v", err)
+	}
+	if opts.NetworkShaping == nil || opts.NetworkShaping.Latency != "50ms" || opts.NetworkShaping.Loss != 2 {
+		t.Errorf("NetworkShaping = %+v", opts.NetworkShaping)
+	}
+
+	_, err = NewDockerOptions(Options{
+		"image":           "alpine",
+		"network":         "host",
+		"network_shaping": map[string]interface{}{"latency": "50ms"},
+	})
+	if err == nil {
+		t.Errorf("NewDockerOptions should reject shaping the host network")
+	}
+}
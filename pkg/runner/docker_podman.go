@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// NewPodman creates a Docker runner driving Podman, with the options of the
+// Docker runner. Podman runs the containers without a daemon, and usually
+// without root privileges (rootless mode).
+func NewPodman(options Options, logger Logger) (*Docker, error) {
+	podmanOptions := Options{}
+	for key, value := range options {
+		podmanOptions[key] = value
+	}
+	switch engine := options["engine"]; engine {
+	case nil, "", EnginePodman:
+		podmanOptions["engine"] = EnginePodman
+	default:
+		return nil, fmt.Errorf("the podman runner cannot use the %v engine", engine)
+	}
+	return NewDocker(podmanOptions, logger)
+}
+
+// podmanInfo are the fields of `podman info` about the isolation of the
+// containers
+type podmanInfo struct {
+	Host struct {
+		CgroupsVersion string `json:"cgroupVersion"`
+		Security       struct {
+			Rootless       bool `json:"rootless"`
+			SeccompEnabled bool `json:"seccompEnabled"`
+		} `json:"security"`
+	} `json:"host"`
+}
+
+// podmanInfoOf returns the information of the podman installation
+func podmanInfoOf(ctx context.Context) (podmanInfo, error) {
+	var info podmanInfo
+	output, err := exec.CommandContext(ctx, EnginePodman, "info", "--format", "json").Output()
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(output, &info); err != nil {
+		return info, fmt.Errorf("unexpected podman info output: %w", err)
+	}
+	return info, nil
+}
+
+// checkPodman checks the podman executable, and that the memory and block
+// I/O limits can be enforced: rootless containers can only be limited with
+// cgroups v2, when the controllers are delegated to the user
+func (r *Docker) checkPodman() error {
+	if !common.CheckExecutableExists(EnginePodman) {
+		return fmt.Errorf("podman executable not found in PATH")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	info, err := podmanInfoOf(ctx)
+	if err != nil {
+		return fmt.Errorf("podman is not working: %w", err)
+	}
+
+	limited := r.opts.Memory != "" || r.opts.MemoryReservation != "" || r.opts.MemorySwap != "" || r.opts.blkioLimited()
+	if limited && info.Host.Security.Rootless && info.Host.CgroupsVersion != "v2" {
+		return fmt.Errorf("memory and block I/O limits of rootless podman containers require cgroups v2 (found %s): %w",
+			info.Host.CgroupsVersion, ErrNotSupported)
+	}
+	return nil
+}
+
+// podmanPolicyWarnings returns the isolation features missing in podman:
+// rootful containers without a user namespace run as root in the host, and
+// the system calls may not be filtered with seccomp
+func (r *Docker) podmanPolicyWarnings(ctx context.Context) []PolicyWarning {
+	info, err := podmanInfoOf(ctx)
+	if err != nil {
+		return nil
+	}
+
+	var warnings []PolicyWarning
+	if !info.Host.Security.Rootless && r.opts.Userns == "" && runsAsRoot(r.opts.User) {
+		warnings = append(warnings, PolicyWarning{
+			Runner:  TypePodman,
+			Feature: "userns",
+			Option:  "userns",
+			Message: "podman runs as root without a user namespace (rootless mode or the userns option): " +
+				"root in the container is root in the host",
+		})
+	}
+	if !info.Host.Security.SeccompEnabled {
+		warnings = append(warnings, PolicyWarning{
+			Runner:  TypePodman,
+			Feature: "seccomp",
+			Message: "podman does not filter the system calls of the containers with seccomp",
+		})
+	}
+	return warnings
+}
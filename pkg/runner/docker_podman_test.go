@@ -0,0 +1,73 @@
+package runner
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// checkPodmanWorking verifies that podman is installed and working
+func checkPodmanWorking() bool {
+	if !common.CheckExecutableExists("podman") {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := podmanInfoOf(ctx)
+	return err == nil
+}
+
+func TestNewPodman(t *testing.T) {
+	r, err := NewPodman(Options{"image": "alpine:latest", "userns": "keep-id", "user": "1000"}, nil)
+	if err != nil {
+		t.Fatalf("NewPodman failed: %v", err)
+	}
+	if r.opts.Engine != EnginePodman {
+		t.Errorf("engine = %q, want %q", r.opts.Engine, EnginePodman)
+	}
+	if typ, _ := runnerPolicy(r); typ != TypePodman {
+		t.Errorf("runnerPolicy() = %q, want %q", typ, TypePodman)
+	}
+
+	command := r.opts.GetDirectExecutionCommand("id", []string{"FOO=bar"})
+	for _, want := range []string{"podman run --rm", "--user 1000", "--userns keep-id", "alpine:latest id"} {
+		if !strings.Contains(command, want) {
+			t.Errorf("command %q does not contain %q", command, want)
+		}
+	}
+
+	if _, err := NewPodman(Options{"image": "alpine:latest", "engine": "docker"}, nil); err == nil {
+		t.Errorf("NewPodman() with the docker engine should fail")
+	}
+	if _, err := NewDocker(Options{"image": "alpine:latest", "engine": "containerd"}, nil); err == nil {
+		t.Errorf("NewDocker() with an unknown engine should fail")
+	}
+
+	docker, err := NewDocker(Options{"image": "alpine:latest"}, nil)
+	if err != nil {
+		t.Fatalf("NewDocker failed: %v", err)
+	}
+	if command := docker.opts.GetDirectExecutionCommand("id", nil); !strings.HasPrefix(command, "docker run --rm") {
+		t.Errorf("command of the docker engine = %q", command)
+	}
+}
+
+func TestPodman_Run(t *testing.T) {
+	if !checkPodmanWorking() {
+		t.Skip("podman not available")
+	}
+	r, err := New(TypePodman, Options{"image": "alpine:latest", "allow_networking": false}, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	output, err := r.Run(context.Background(), "", "echo hello from $FOO", []string{"FOO=podman"}, nil, false)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if output != "hello from podman" {
+		t.Errorf("Run() = %q", output)
+	}
+}
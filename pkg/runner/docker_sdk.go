@@ -0,0 +1,332 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/blkiodev"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	units "github.com/docker/go-units"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// containerExitError is the error of a container run through the API of the
+// engine that exited with a non-zero status, or of the engine failing to run
+// it (with the status of `docker run`, 125), as the errors of the CLI
+type containerExitError struct {
+	code int
+	err  error
+}
+
+func (e *containerExitError) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func (e *containerExitError) Unwrap() error { return e.err }
+
+// engineError returns the error of a failed request to the engine, as a
+// failure of the backend
+func engineError(action string, err error) error {
+	err = &containerExitError{code: exitCodeBackend, err: fmt.Errorf("failed to %s: %w", action, err)}
+	return noSpaceError(err, err.Error())
+}
+
+// validateSDK checks the options can be used with the docker_sdk feature
+func (o *DockerOptions) validateSDK() error {
+	if !o.experimentEnabled(FeatureDockerSDK) {
+		return nil
+	}
+	if o.engine() != EngineDocker {
+		return fmt.Errorf("experimental feature %q is not available with %s", FeatureDockerSDK, o.engine())
+	}
+	if o.DockerRunOpts != "" {
+		return fmt.Errorf("docker_run_opts cannot be used with the experimental feature %q: "+
+			"they are arguments of the docker CLI", FeatureDockerSDK)
+	}
+	_, _, err := o.sdkContainerConfig(nil, nil)
+	return err
+}
+
+// apiClient returns the client of the API of the engine, created with the
+// environment (DOCKER_HOST, DOCKER_CERT_PATH...) as the docker CLI
+func (r *Docker) apiClient() (*client.Client, error) {
+	r.apiMu.Lock()
+	defer r.apiMu.Unlock()
+	if r.api == nil {
+		api, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the Docker API client: %w", err)
+		}
+		r.api = api
+	}
+	return r.api, nil
+}
+
+// sdkContainerConfig returns the configuration of the container of a
+// command, as the arguments of GetBaseDockerCommand. The mounts are passed as
+// they are, so their paths can contain spaces.
+func (o *DockerOptions) sdkContainerConfig(cmd []string, env []string) (
+	*container.Config, *container.HostConfig, error) {
+	config := &container.Config{
+		Image:      o.Image,
+		Cmd:        cmd,
+		Env:        env,
+		User:       o.User,
+		WorkingDir: o.WorkDir,
+		Hostname:   o.Hostname,
+		Labels:     map[string]string{managedLabel: ""},
+	}
+	hostConfig := &container.HostConfig{
+		Binds:      append([]string{}, o.Mounts...),
+		CapAdd:     o.CapAdd,
+		CapDrop:    o.CapDrop,
+		DNS:        o.DNS,
+		DNSSearch:  o.DNSSearch,
+		UsernsMode: container.UsernsMode(o.Userns),
+	}
+	if !o.AllowNetworking {
+		hostConfig.NetworkMode = "none"
+	} else if o.Network != "" {
+		hostConfig.NetworkMode = container.NetworkMode(o.Network)
+	}
+	for _, host := range o.sortedHosts() {
+		hostConfig.ExtraHosts = append(hostConfig.ExtraHosts, host+":"+o.ExtraHosts[host])
+	}
+	if o.CABundle != "" {
+		hostConfig.Binds = append(hostConfig.Binds, o.CABundle+":"+caBundleGuestPath+":ro")
+	}
+	for _, device := range o.devices() {
+		hostConfig.Devices = append(hostConfig.Devices,
+			container.DeviceMapping{PathOnHost: device, PathInContainer: device, CgroupPermissions: "rwm"})
+	}
+
+	// Resource limits
+	var err error
+	resources := &hostConfig.Resources
+	for _, limit := range []struct {
+		option string
+		value  string
+		bytes  *int64
+	}{
+		{"memory", o.Memory, &resources.Memory},
+		{"memory_reservation", o.MemoryReservation, &resources.MemoryReservation},
+		{"memory_swap", o.MemorySwap, &resources.MemorySwap},
+	} {
+		switch limit.value {
+		case "":
+		case "-1":
+			*limit.bytes = -1
+		default:
+			if *limit.bytes, err = units.RAMInBytes(limit.value); err != nil {
+				return nil, nil, fmt.Errorf("invalid %s %q: %w", limit.option, limit.value, err)
+			}
+		}
+	}
+	if o.MemorySwappiness != -1 {
+		swappiness := int64(o.MemorySwappiness)
+		resources.MemorySwappiness = &swappiness
+	}
+	resources.BlkioWeight = uint16(o.BlkioWeight)
+	for _, limits := range []struct {
+		rates   []string
+		devices *[]*blkiodev.ThrottleDevice
+	}{
+		{o.DeviceReadBps, &resources.BlkioDeviceReadBps},
+		{o.DeviceWriteBps, &resources.BlkioDeviceWriteBps},
+	} {
+		for _, limit := range limits.rates {
+			device, rate, _ := strings.Cut(limit, ":")
+			bytes, err := units.RAMInBytes(rate)
+			if err != nil || bytes <= 0 {
+				return nil, nil, fmt.Errorf("invalid rate %q of %s", rate, device)
+			}
+			*limits.devices = append(*limits.devices, &blkiodev.ThrottleDevice{Path: device, Rate: uint64(bytes)})
+		}
+	}
+	if ulimit := o.dockerUlimit(); ulimit != "" {
+		limit, err := units.ParseUlimit(ulimit)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid core dump limit %q: %w", ulimit, err)
+		}
+		resources.Ulimits = append(resources.Ulimits, limit)
+	}
+	if o.seccompFile != "" {
+		// the API takes the content of the profile, not its file
+		profile, err := o.seccompJSON()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read the seccomp profile: %w", err)
+		}
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "seccomp="+string(profile))
+	}
+	return config, hostConfig, nil
+}
+
+// sdkPlatform returns the platform of the platform option
+func (o *DockerOptions) sdkPlatform() *ocispec.Platform {
+	if o.Platform == "" {
+		return nil
+	}
+	parts := strings.SplitN(o.Platform, "/", 3)
+	platform := &ocispec.Platform{OS: parts[0]}
+	if len(parts) > 1 {
+		platform.Architecture = parts[1]
+	}
+	if len(parts) > 2 {
+		platform.Variant = parts[2]
+	}
+	return platform
+}
+
+// runSDK runs a command in a new container through the API of the engine
+// (see FeatureDockerSDK): the container is created, attached to, started,
+// waited for and removed with API requests, instead of with a docker run
+// command line
+func (r *Docker) runSDK(ctx context.Context, logger Logger, opts DockerOptions, shell string, cmd string,
+	env []string) (string, error) {
+	api, err := r.apiClient()
+	if err != nil {
+		return "", err
+	}
+
+	// Images without a shell run the commands with an injected busybox, or without a shell
+	var containerCmd []string
+	switch mode := r.shellMode(ctx); mode {
+	case InjectShellDirect:
+		if containerCmd, err = directArgs(cmd); err != nil {
+			return "", err
+		}
+		logger.Debug("Running the command without a shell in Docker: %v", containerCmd)
+	case InjectShellBusybox:
+		mount, err := opts.busyboxMount()
+		if err != nil {
+			return "", err
+		}
+		opts.Mounts = append(append([]string{}, opts.Mounts...), mount)
+		if shell == "" {
+			shell = injectedBusyboxPath + " sh"
+		}
+		containerCmd = []string{injectedBusyboxPath, "sh", "-c", r.scriptContent(shell, cmd, env)}
+	default:
+		containerCmd = []string{"sh", "-c", r.scriptContent(shell, cmd, env)}
+	}
+
+	name := fmt.Sprintf("go-restricted-runner-%d", time.Now().UnixNano())
+	config, hostConfig, err := opts.sdkContainerConfig(containerCmd, env)
+	if err != nil {
+		return "", err
+	}
+	config.AttachStdout, config.AttachStderr = true, true
+
+	// The container is created, pulling the image when it is missing, as docker run does
+	created, err := api.ContainerCreate(ctx, config, hostConfig, nil, opts.sdkPlatform(), name)
+	if cerrdefs.IsNotFound(err) {
+		if err = r.pullImageSDK(ctx, logger, api); err == nil {
+			created, err = api.ContainerCreate(ctx, config, hostConfig, nil, opts.sdkPlatform(), name)
+		}
+	}
+	if err != nil {
+		return "", engineError("create container", err)
+	}
+	logger.Debug("Created container %s (%s) with the Docker API", name, created.ID)
+	defer func() {
+		if err := api.ContainerRemove(context.WithoutCancel(ctx), created.ID, container.RemoveOptions{Force: true}); err != nil {
+			logger.Debug("Failed to remove container %s: %v", name, err)
+		}
+	}()
+
+	attached, err := api.ContainerAttach(ctx, created.ID, container.AttachOptions{Stream: true, Stdout: true, Stderr: true})
+	if err != nil {
+		return "", engineError("attach to container", err)
+	}
+	defer attached.Close()
+
+	// The container is waited for even once the context is cancelled, after stopping it
+	waitC, waitErrC := api.ContainerWait(context.WithoutCancel(ctx), created.ID, container.WaitConditionNextExit)
+	if err := api.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+		return "", engineError("start container", err)
+	}
+
+	// Cancelling the context stops the container, killing it after the grace period
+	stop := context.AfterFunc(ctx, func() {
+		seconds := int(math.Ceil(killGracePeriodFrom(ctx).Seconds()))
+		if err := api.ContainerStop(context.Background(), created.ID, container.StopOptions{Timeout: &seconds}); err != nil {
+			logger.Debug("Failed to stop container %s: %v", name, err)
+		}
+	})
+	defer stop()
+
+	var stdout, stderr bytes.Buffer
+	stdoutWriter, stderrWriter := captureRunOutput(ctx, &stdout, &stderr)
+	stdoutWriter, stderrWriter, truncated := opts.limitOutput(ctx, stdoutWriter, stderrWriter)
+	if _, err := stdcopy.StdCopy(stdoutWriter, stderrWriter, attached.Reader); err != nil && ctx.Err() == nil {
+		logger.Debug("Failed to read the output of container %s: %v", name, err)
+	}
+
+	var exitCode int64
+	select {
+	case result := <-waitC:
+		if result.Error != nil {
+			return "", engineError("wait for container", errors.New(result.Error.Message))
+		}
+		exitCode = result.StatusCode
+	case err := <-waitErrC:
+		return "", engineError("wait for container", err)
+	}
+
+	if exitCode != 0 {
+		err := error(&containerExitError{code: int(exitCode)})
+		if stderr.Len() > 0 {
+			return "", newCommandError(strings.TrimSpace(stderr.String()), err)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), truncated()
+}
+
+// pullImageSDK pulls the image of the runner through the API of the engine,
+// reporting the progress as EventImagePull events
+func (r *Docker) pullImageSDK(ctx context.Context, logger Logger, api *client.Client) error {
+	logger.Debug("Pulling image: %s", r.opts.Image)
+	progress, err := api.ImagePull(ctx, r.opts.Image, image.PullOptions{Platform: r.opts.Platform})
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", r.opts.Image, err)
+	}
+	defer progress.Close()
+
+	// The progress is a stream of JSON messages, ending with the error of the pull if it failed
+	emitter := eventEmitterFrom(ctx)
+	decoder := json.NewDecoder(progress)
+	for {
+		var message struct {
+			Status   string `json:"status"`
+			Progress string `json:"progress"`
+			Error    string `json:"error"`
+		}
+		if err := decoder.Decode(&message); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to pull image %s: %w", r.opts.Image, err)
+		}
+		if message.Error != "" {
+			return fmt.Errorf("failed to pull image %s: %s", r.opts.Image, message.Error)
+		}
+		if emitter != nil {
+			emitter.emit(Event{Type: EventImagePull, Image: r.opts.Image,
+				Progress: strings.TrimSpace(message.Status + " " + message.Progress)})
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDockerOptions_SDKContainerConfig(t *testing.T) {
+	opts, err := NewDockerOptions(Options{
+		"image":            "alpine:latest",
+		"experimental":     []string{"docker_sdk"},
+		"mounts":           []interface{}{"/home/me/My Projects:/work", "/data:/data:ro"},
+		"allow_networking": false,
+		"memory":           "512m",
+		"memory_swap":      "-1",
+		"blkio_weight":     500,
+		"device_read_bps":  []string{"/dev/sda:10mb"},
+		"cap_drop":         []interface{}{"ALL"},
+		"core_dumps":       "disabled",
+	})
+	if err != nil {
+		t.Fatalf("NewDockerOptions failed: %v", err)
+	}
+
+	config, hostConfig, err := opts.sdkContainerConfig([]string{"sh", "-c", "ls"}, []string{"A=b c"})
+	if err != nil {
+		t.Fatalf("sdkContainerConfig failed: %v", err)
+	}
+	if config.Image != "alpine:latest" || !reflect.DeepEqual([]string(config.Cmd), []string{"sh", "-c", "ls"}) {
+		t.Errorf("config = %+v", config)
+	}
+	if _, ok := config.Labels[managedLabel]; !ok {
+		t.Errorf("the container is not labelled for the image GC: %v", config.Labels)
+	}
+	// the mounts are passed as they are, with their spaces
+	if !reflect.DeepEqual(hostConfig.Binds, []string{"/home/me/My Projects:/work", "/data:/data:ro"}) {
+		t.Errorf("Binds = %q", hostConfig.Binds)
+	}
+	if hostConfig.NetworkMode != "none" {
+		t.Errorf("NetworkMode = %q", hostConfig.NetworkMode)
+	}
+	if hostConfig.Memory != 512*1024*1024 || hostConfig.MemorySwap != -1 || hostConfig.BlkioWeight != 500 {
+		t.Errorf("resources = %d, %d, %d", hostConfig.Memory, hostConfig.MemorySwap, hostConfig.BlkioWeight)
+	}
+	if len(hostConfig.BlkioDeviceReadBps) != 1 || hostConfig.BlkioDeviceReadBps[0].Rate != 10*1024*1024 {
+		t.Errorf("BlkioDeviceReadBps = %v", hostConfig.BlkioDeviceReadBps)
+	}
+	if len(hostConfig.Ulimits) != 1 || hostConfig.Ulimits[0].Name != "core" || hostConfig.Ulimits[0].Hard != 0 {
+		t.Errorf("Ulimits = %v", hostConfig.Ulimits)
+	}
+
+	if platform := (&DockerOptions{Platform: "linux/arm64/v8"}).sdkPlatform(); platform.Architecture != "arm64" ||
+		platform.Variant != "v8" {
+		t.Errorf("sdkPlatform() = %+v", platform)
+	}
+}
+
+func TestDockerOptions_ValidateSDK(t *testing.T) {
+	for _, options := range []Options{
+		{"engine": EnginePodman},
+		{"docker_run_opts": "--privileged"},
+		{"memory": "lots"},
+	} {
+		options["image"] = "alpine:latest"
+		options["experimental"] = []string{"docker_sdk"}
+		if _, err := NewDockerOptions(options); err == nil {
+			t.Errorf("NewDockerOptions(%v) should fail", options)
+		}
+	}
+}
+
+func TestContainerExitError(t *testing.T) {
+	d := &Docker{}
+	tests := []struct {
+		err  error
+		want ExitStatus
+	}{
+		{&containerExitError{code: 3}, ExitStatus{Raw: 3, Code: 3, Kind: ErrorKindFailed}},
+		{newCommandError("ls: denied", &containerExitError{code: 126}), ExitStatus{Raw: 126, Code: 126, Kind: ErrorKindPermissionDenied}},
+		{&containerExitError{code: 137}, ExitStatus{Raw: 137, Code: 137, Signal: 9, Kind: ErrorKindSignaled}},
+		{engineError("create container", errors.New("conflict")), ExitStatus{Raw: 125, Code: 125, Kind: ErrorKindBackend}},
+		{engineError("create container", errors.New("write /var/lib/docker/x: no space left on device")),
+			ExitStatus{Raw: 125, Code: 125, Kind: ErrorKindNoSpace}},
+	}
+	for _, tt := range tests {
+		if got := NormalizeExit(d, tt.err); got != tt.want {
+			t.Errorf("NormalizeExit(%v) = %+v, want %+v", tt.err, got, tt.want)
+		}
+	}
+	if !IsPermissionDenied(&containerExitError{code: 126}) {
+		t.Errorf("IsPermissionDenied() of a container exiting with 126 = false")
+	}
+}
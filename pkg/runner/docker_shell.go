@@ -0,0 +1,221 @@
+package runner
+
+import (
+	"context"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrShellNotFound is returned (wrapped) when the image of a container has no
+// shell (or no sleep for Start), as distroless and scratch images, and no
+// shell is injected (see the inject_shell option)
+var ErrShellNotFound = errors.New("shell not found in the image")
+
+// Ways of running commands in images without a shell (see the inject_shell option)
+const (
+	// InjectShellBusybox mounts a static busybox of the host read-only in
+	// the container, and runs the commands with its shell
+	InjectShellBusybox = "busybox"
+
+	// InjectShellAuto injects busybox only when the image has no sh, which
+	// is checked once per runner with a container running sh
+	InjectShellAuto = "auto"
+
+	// InjectShellDirect runs the commands as their arguments, without a
+	// shell, so commands with shell syntax cannot run
+	InjectShellDirect = "direct"
+)
+
+// injectedBusyboxPath is where busybox is mounted in the containers
+const injectedBusyboxPath = "/.restricted-runner/busybox"
+
+// parseInjectShell parses the inject_shell and busybox_path options
+func parseInjectShell(genericOpts Options, opts *DockerOptions) error {
+	if inject, ok := genericOpts["inject_shell"].(string); ok {
+		opts.InjectShell = inject
+	}
+	if path, ok := genericOpts["busybox_path"].(string); ok {
+		opts.BusyboxPath = path
+	}
+
+	switch opts.InjectShell {
+	case "", InjectShellBusybox, InjectShellAuto:
+	case InjectShellDirect:
+		if opts.PrepareCommand != "" {
+			return fmt.Errorf("prepare_command requires a shell: it cannot be used with inject_shell %q", InjectShellDirect)
+		}
+		if opts.Umask != "" {
+			return fmt.Errorf("umask requires a shell: it cannot be used with inject_shell %q", InjectShellDirect)
+		}
+	default:
+		return fmt.Errorf("invalid inject_shell %q: must be %s, %s or %s",
+			opts.InjectShell, InjectShellBusybox, InjectShellAuto, InjectShellDirect)
+	}
+	if opts.BusyboxPath != "" && !filepath.IsAbs(opts.BusyboxPath) {
+		return fmt.Errorf("invalid busybox_path %q: must be an absolute path", opts.BusyboxPath)
+	}
+	return nil
+}
+
+// shellMode returns how the commands are run: "" with the shell of the
+// image, InjectShellBusybox or InjectShellDirect
+func (r *Docker) shellMode(ctx context.Context) string {
+	if r.opts.InjectShell != InjectShellAuto {
+		return r.opts.InjectShell
+	}
+
+	r.shellMu.Lock()
+	defer r.shellMu.Unlock()
+	if !r.shellProbed {
+		args := []string{"run", "--rm", "--label", managedLabel, "--network", "none", "--entrypoint", "sh", r.opts.Image, "-c", ":"}
+		output, err := commandContext(ctx, r.opts.engine(), args...).CombinedOutput()
+		switch {
+		case err == nil:
+			r.shellProbed, r.imageHasShell = true, true
+		case missingExecutable(string(output), "sh"):
+			r.shellProbed, r.imageHasShell = true, false
+			r.logger.Debug("The image %s has no shell: injecting busybox", r.opts.Image)
+		default:
+			// the image could not be checked: use its shell, and check it again next time
+			r.logger.Debug("Failed to check the shell of the image %s: %v: %s", r.opts.Image, err, string(output))
+			return ""
+		}
+	}
+	if r.imageHasShell {
+		return ""
+	}
+	return InjectShellBusybox
+}
+
+// busyboxMount returns the bind mount of the busybox injected in the
+// containers, checking it is statically linked, as the image may have no C
+// library
+func (o *DockerOptions) busyboxMount() (string, error) {
+	path := o.BusyboxPath
+	if path == "" {
+		var err error
+		if path, err = exec.LookPath("busybox"); err != nil {
+			return "", fmt.Errorf("inject_shell requires busybox: %w (install a static busybox or set busybox_path)", err)
+		}
+	}
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to find busybox: %w", err)
+	}
+
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("busybox %s is not a Linux executable: %w", path, err)
+	}
+	defer f.Close()
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_INTERP {
+			return "", fmt.Errorf("busybox %s is dynamically linked: inject_shell requires a static busybox "+
+				"(e.g. from the busybox-static package)", path)
+		}
+	}
+	return path + ":" + injectedBusyboxPath + ":ro", nil
+}
+
+// containerCommand returns the command executed in the container of a
+// runner, setting the umask with the shell of the mode
+func (o *DockerOptions) containerCommand(mode string, cmd string, args []string) (string, []string) {
+	containerCmd, containerArgs := umaskArgs(o.Umask, cmd, args)
+	if o.Umask != "" && mode == InjectShellBusybox {
+		return injectedBusyboxPath, append([]string{"sh"}, containerArgs...)
+	}
+	return containerCmd, containerArgs
+}
+
+// containerShell returns the shell of the containers with the mode, for
+// the containerBackend
+func containerShell(mode string) []string {
+	if mode == InjectShellBusybox {
+		return []string{injectedBusyboxPath, "sh"}
+	}
+	return nil
+}
+
+// missingExecutable returns whether the output of a container engine says the
+// executable could not be found in the image
+func missingExecutable(output string, name string) bool {
+	if !strings.Contains(output, "not found") {
+		return false
+	}
+	return strings.Contains(output, `"`+name+`"`) || strings.Contains(output, "`"+name+"`")
+}
+
+// shellNotFoundError returns an error wrapping ErrShellNotFound when err is
+// the failure of a container engine not finding the executable in the image,
+// or err otherwise
+func (o *DockerOptions) shellNotFoundError(err error, output string, name string) error {
+	if err == nil || !missingExecutable(output, name) {
+		return err
+	}
+	return fmt.Errorf("%w: %s has no %s: set inject_shell to %q or %q to run commands in images "+
+		"without a shell: %v", ErrShellNotFound, o.Image, name, InjectShellBusybox, InjectShellDirect, err)
+}
+
+// directArgs splits a command run without a shell (see InjectShellDirect)
+// into its arguments, which can be quoted with single or double quotes. The
+// commands with shell syntax (pipes, redirections, variables...) cannot run.
+func directArgs(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, c := range strings.TrimSpace(command) {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case quote == '"':
+			switch c {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			case '$', '`':
+				return nil, fmt.Errorf("%q requires a shell: %w", command, ErrShellNotFound)
+			default:
+				arg.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == '\\':
+			escaped, inArg = true, true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		case strings.ContainsRune("|&;<>(){}$`\n", c):
+			return nil, fmt.Errorf("%q requires a shell: %w", command, ErrShellNotFound)
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote in %q", command)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return args, nil
+}
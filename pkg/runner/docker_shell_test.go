@@ -0,0 +1,108 @@
+package runner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDirectArgs(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"/app/server", []string{"/app/server"}},
+		{"  /app/server --port 8080 ", []string{"/app/server", "--port", "8080"}},
+		{`/app/tool 'a b' "c \"d\"" e\ f`, []string{"/app/tool", "a b", `c "d"`, "e f"}},
+		{`/app/tool '' *.txt`, []string{"/app/tool", "", "*.txt"}},
+	}
+	for _, tt := range tests {
+		got, err := directArgs(tt.command)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("directArgs(%q) = %q, %v, want %q", tt.command, got, err, tt.want)
+		}
+	}
+
+	for _, command := range []string{"ls | wc -l", "echo $HOME", `echo "$HOME"`, "a && b", "cat < in", "echo `id`"} {
+		if _, err := directArgs(command); !errors.Is(err, ErrShellNotFound) {
+			t.Errorf("directArgs(%q) = %v, want ErrShellNotFound", command, err)
+		}
+	}
+	for _, command := range []string{"", "echo 'open", `echo \`} {
+		if _, err := directArgs(command); err == nil {
+			t.Errorf("directArgs(%q) should fail", command)
+		}
+	}
+}
+
+func TestDockerOptions_InjectShell(t *testing.T) {
+	opts, err := NewDockerOptions(Options{"image": "gcr.io/distroless/static", "inject_shell": "busybox",
+		"busybox_path": "/opt/busybox"})
+	if err != nil {
+		t.Fatalf("NewDockerOptions failed: %v", err)
+	}
+	if opts.InjectShell != InjectShellBusybox || opts.BusyboxPath != "/opt/busybox" {
+		t.Errorf("options = %q, %q", opts.InjectShell, opts.BusyboxPath)
+	}
+
+	for _, options := range []Options{
+		{"inject_shell": "bash"},
+		{"inject_shell": "direct", "prepare_command": "apk add curl"},
+		{"inject_shell": "direct", "umask": "077"},
+		{"inject_shell": "busybox", "busybox_path": "busybox"},
+	} {
+		options["image"] = "alpine:latest"
+		if _, err := NewDockerOptions(options); err == nil {
+			t.Errorf("NewDockerOptions(%v) should fail", options)
+		}
+	}
+
+	// the umask is set with the injected shell
+	opts = DockerOptions{Umask: "077"}
+	cmd, args := opts.containerCommand(InjectShellBusybox, "/app/server", []string{"-v"})
+	if cmd != injectedBusyboxPath || args[0] != "sh" || args[len(args)-2] != "/app/server" {
+		t.Errorf("containerCommand() = %s %q", cmd, args)
+	}
+	if cmd, _ := opts.containerCommand("", "/app/server", nil); cmd != "/bin/sh" {
+		t.Errorf("containerCommand() without injection = %s", cmd)
+	}
+}
+
+func TestDockerOptions_BusyboxMount(t *testing.T) {
+	notELF := filepath.Join(t.TempDir(), "busybox")
+	if err := os.WriteFile(notELF, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	opts := DockerOptions{BusyboxPath: notELF}
+	if _, err := opts.busyboxMount(); err == nil {
+		t.Errorf("busyboxMount() of a script should fail")
+	}
+
+	if runtime.GOOS == "linux" {
+		// the shell of the host is dynamically linked in most distributions
+		opts.BusyboxPath = "/bin/sh"
+		if _, err := opts.busyboxMount(); err != nil && !strings.Contains(err.Error(), "dynamically linked") {
+			t.Errorf("busyboxMount() of /bin/sh = %v", err)
+		}
+	}
+}
+
+func TestShellNotFoundError(t *testing.T) {
+	opts := DockerOptions{Image: "gcr.io/distroless/static"}
+	base := errors.New("exit status 127")
+	for _, output := range []string{
+		`docker: Error response from daemon: failed to create task for container: exec: "sh": executable file not found in $PATH: unknown.`,
+		"Error: crun: executable file `sh` not found in $PATH: No such file or directory",
+	} {
+		if err := opts.shellNotFoundError(base, output, "sh"); !errors.Is(err, ErrShellNotFound) {
+			t.Errorf("shellNotFoundError(%q) = %v, want ErrShellNotFound", output, err)
+		}
+	}
+	if err := opts.shellNotFoundError(base, "sh: foo: not found", "sleep"); err != base {
+		t.Errorf("shellNotFoundError() of another failure = %v", err)
+	}
+}
@@ -332,6 +332,7 @@ func TestNewDockerOptions(t *testing.T) {
 				"cap_drop":           []interface{}{"NET_ADMIN"},
 				"dns":                []interface{}{"8.8.8.8"},
 				"dns_search":         []interface{}{"example.com"},
+				"hostname":           "sandbox",
 				"platform":           "linux/amd64",
 			},
 			expected: DockerOptions{
@@ -351,10 +352,41 @@ func TestNewDockerOptions(t *testing.T) {
 				CapDrop:           []string{"NET_ADMIN"},
 				DNS:               []string{"8.8.8.8"},
 				DNSSearch:         []string{"example.com"},
+				Hostname:          "sandbox",
 				Platform:          "linux/amd64",
 			},
 			expectError: false,
 		},
+		{
+			name: "invalid hostname",
+			input: Options{
+				"image":    "alpine:latest",
+				"hostname": "not a hostname",
+			},
+			expectError: true,
+		},
+		{
+			name: "core dump max size",
+			input: Options{
+				"image":              "alpine:latest",
+				"core_dump_max_size": "64m",
+			},
+			expected: DockerOptions{
+				Image:           "alpine:latest",
+				AllowNetworking: true,
+				CoreDumpOptions: CoreDumpOptions{CoreDumpMaxSize: "64m"},
+			},
+			expectError: false,
+		},
+		{
+			name: "core dump capture",
+			input: Options{
+				"image":         "alpine:latest",
+				"core_dumps":    "capture",
+				"core_dump_dir": "/var/crash",
+			},
+			expectError: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -398,6 +430,12 @@ func TestNewDockerOptions(t *testing.T) {
 			if result.PrepareCommand != tc.expected.PrepareCommand {
 				t.Errorf("PrepareCommand: expected %q, got %q", tc.expected.PrepareCommand, result.PrepareCommand)
 			}
+			if result.Hostname != tc.expected.Hostname {
+				t.Errorf("Hostname: expected %q, got %q", tc.expected.Hostname, result.Hostname)
+			}
+			if result.CoreDumpOptions != tc.expected.CoreDumpOptions {
+				t.Errorf("CoreDumpOptions: expected %+v, got %+v", tc.expected.CoreDumpOptions, result.CoreDumpOptions)
+			}
 
 			// Check slice fields
 			if !compareStringSlices(result.Mounts, tc.expected.Mounts) {
@@ -0,0 +1,175 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrLoosening is returned by Tighten when a restriction would relax the
+// current limits of the container
+var ErrLoosening = errors.New("the restriction would loosen the current limits")
+
+// Tightening are restrictions added to a running container, so operators can
+// react to suspicious behavior without killing the work in flight
+type Tightening struct {
+	// DisconnectNetwork disconnects the container from all its networks
+	DisconnectNetwork bool `json:"disconnect_network"`
+
+	// Memory is the new memory limit (e.g. "256m"). Swap is disabled.
+	Memory string `json:"memory"`
+
+	// CPUs is the new number of CPUs (e.g. 0.5)
+	CPUs float64 `json:"cpus"`
+
+	// PidsLimit is the new maximum number of processes
+	PidsLimit int64 `json:"pids_limit"`
+}
+
+// validate checks that the tightening restricts something
+func (t Tightening) validate() error {
+	if _, err := parseByteSize(t.Memory); err != nil {
+		return err
+	}
+	if t.CPUs < 0 || t.PidsLimit < 0 {
+		return fmt.Errorf("the cpus and pids_limit restrictions cannot be negative")
+	}
+	if !t.DisconnectNetwork && t.Memory == "" && t.CPUs == 0 && t.PidsLimit == 0 {
+		return fmt.Errorf("no restriction to apply")
+	}
+	return nil
+}
+
+// tightenBackend is implemented by the backends whose restrictions can be
+// changed while the command runs
+type tightenBackend interface {
+	tighten(ctx context.Context, logger Logger, t Tightening) error
+}
+
+// Tighten applies additional restrictions to the container of a running
+// execution: its network is disconnected, and its cgroup limits are lowered
+// with `docker update`. ErrLoosening is returned (and nothing is changed)
+// when a limit would be higher than the current one.
+//
+// ErrNotSupported is returned for executions that do not run in a container
+// of their own: the container of a Session is tightened with Session.Tighten.
+func (e *Execution) Tighten(ctx context.Context, t Tightening) error {
+	b, ok := e.backend.(tightenBackend)
+	if !ok {
+		return ErrNotSupported
+	}
+	e.logger.Debug("Tightening the restrictions of execution %s: %+v", e.ID, t)
+	if err := b.tighten(ctx, e.logger, t); err != nil {
+		return fmt.Errorf("failed to tighten execution %s: %w", e.ID, err)
+	}
+	return nil
+}
+
+// Tighten applies additional restrictions to the container of the session,
+// affecting all its commands (see Execution.Tighten). ErrNotSupported is
+// returned for sandboxes other than containers.
+func (s *Session) Tighten(ctx context.Context, t Tightening) error {
+	b, ok := s.backend.(tightenBackend)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := s.CheckImplicitRequirements(); err != nil {
+		return err
+	}
+	s.logger.Debug("Tightening the restrictions of the session: %+v", t)
+	return b.tighten(ctx, s.logger, t)
+}
+
+// tighten restricts the container of a session
+func (s *dockerSession) tighten(ctx context.Context, logger Logger, t Tightening) error {
+	b := &containerBackend{engine: s.r.opts.engine(), container: s.container}
+	return b.tighten(ctx, logger, t)
+}
+
+// tighten is not supported in the commands of a session, as it would affect
+// the other commands
+func (b *sharedContainerBackend) tighten(context.Context, Logger, Tightening) error {
+	return ErrNotSupported
+}
+
+// containerLimits are the fields of `docker inspect` with the current limits
+type containerLimits struct {
+	HostConfig struct {
+		Memory    int64  `json:"Memory"`
+		NanoCpus  int64  `json:"NanoCpus"`
+		PidsLimit *int64 `json:"PidsLimit"`
+	} `json:"HostConfig"`
+	NetworkSettings struct {
+		Networks map[string]json.RawMessage `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// tighten disconnects the networks and updates the limits of the container
+func (b *containerBackend) tighten(ctx context.Context, logger Logger, t Tightening) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	output, err := exec.CommandContext(ctx, b.engine, "inspect", "--type", "container", b.container).Output()
+	if err != nil {
+		return fmt.Errorf("failed to inspect container %s: %w", b.container, err)
+	}
+	var inspected []containerLimits
+	if err := json.Unmarshal(output, &inspected); err != nil || len(inspected) != 1 {
+		return fmt.Errorf("unexpected inspection of container %s: %s", b.container, strings.TrimSpace(string(output)))
+	}
+	current := inspected[0]
+
+	// check every limit before changing anything
+	memory, _ := parseByteSize(t.Memory)
+	nanoCPUs := int64(t.CPUs * 1e9)
+	switch {
+	case memory > 0 && current.HostConfig.Memory > 0 && memory > current.HostConfig.Memory:
+		return fmt.Errorf("%w: memory %s is above the current limit of %d bytes", ErrLoosening, t.Memory, current.HostConfig.Memory)
+	case nanoCPUs > 0 && current.HostConfig.NanoCpus > 0 && nanoCPUs > current.HostConfig.NanoCpus:
+		return fmt.Errorf("%w: %g CPUs are above the current limit of %g", ErrLoosening, t.CPUs, float64(current.HostConfig.NanoCpus)/1e9)
+	case t.PidsLimit > 0 && current.HostConfig.PidsLimit != nil && *current.HostConfig.PidsLimit > 0 &&
+		t.PidsLimit > *current.HostConfig.PidsLimit:
+		return fmt.Errorf("%w: %d processes are above the current limit of %d", ErrLoosening, t.PidsLimit, *current.HostConfig.PidsLimit)
+	}
+
+	if t.DisconnectNetwork {
+		networks := make([]string, 0, len(current.NetworkSettings.Networks))
+		for network := range current.NetworkSettings.Networks {
+			if network != "none" {
+				networks = append(networks, network)
+			}
+		}
+		sort.Strings(networks)
+		for _, network := range networks {
+			logger.Debug("Disconnecting container %s from network %s", b.container, network)
+			if output, err := exec.CommandContext(ctx, b.engine, "network", "disconnect", "--force", network, b.container).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to disconnect container %s from network %s: %w: %s",
+					b.container, network, err, strings.TrimSpace(string(output)))
+			}
+		}
+	}
+
+	var update []string
+	if memory > 0 {
+		update = append(update, "--memory", strconv.FormatInt(memory, 10), "--memory-swap", strconv.FormatInt(memory, 10))
+	}
+	if t.CPUs > 0 {
+		update = append(update, "--cpus", strconv.FormatFloat(t.CPUs, 'f', -1, 64))
+	}
+	if t.PidsLimit > 0 {
+		update = append(update, "--pids-limit", strconv.FormatInt(t.PidsLimit, 10))
+	}
+	if len(update) > 0 {
+		args := append(append([]string{"update"}, update...), b.container)
+		logger.Debug("Updating the limits of container %s: %s %v", b.container, b.engine, args)
+		if output, err := exec.CommandContext(ctx, b.engine, args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to update container %s: %w: %s", b.container, err, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// fakeTightenEngine returns a container engine whose containers have the
+// given inspection, logging the other calls to the returned file
+func fakeTightenEngine(t *testing.T, inspection string) (string, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	dir := t.TempDir()
+	engine := filepath.Join(dir, "engine")
+	log := filepath.Join(dir, "engine.log")
+	script := "#!/bin/sh\nif [ \"$1\" = inspect ]; then cat <<'EOF'\n" + inspection + "\nEOF\nexit 0\nfi\necho \"$*\" >> " + log + "\n"
+	if err := os.WriteFile(engine, []byte(script), 0o755); err != nil {
+		t.Fatalf("failed to write the fake engine: %v", err)
+	}
+	return engine, log
+}
+
+const tightenInspection = `[{"HostConfig":{"Memory":1073741824,"NanoCpus":2000000000,"PidsLimit":null},
+"NetworkSettings":{"Networks":{"bridge":{},"backend":{}}}}]`
+
+func TestExecution_Tighten(t *testing.T) {
+	engine, log := fakeTightenEngine(t, tightenInspection)
+	e := newExecution(common.GetLogger(), nil, nil, nil, func() error { return nil },
+		&containerBackend{engine: engine, container: "test"})
+
+	err := e.Tighten(context.Background(), Tightening{DisconnectNetwork: true, Memory: "256m", CPUs: 0.5, PidsLimit: 64})
+	if err != nil {
+		t.Fatalf("Tighten failed: %v", err)
+	}
+	data, _ := os.ReadFile(log)
+	calls := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []string{
+		"network disconnect --force backend test",
+		"network disconnect --force bridge test",
+		"update --memory 268435456 --memory-swap 268435456 --cpus 0.5 --pids-limit 64 test",
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("engine calls = %q, want %q", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("engine call %d = %q, want %q", i, calls[i], want[i])
+		}
+	}
+}
+
+func TestExecution_Tighten_loosening(t *testing.T) {
+	engine, log := fakeTightenEngine(t, tightenInspection)
+	e := newExecution(common.GetLogger(), nil, nil, nil, func() error { return nil },
+		&containerBackend{engine: engine, container: "test"})
+
+	for _, tightening := range []Tightening{
+		{DisconnectNetwork: true, Memory: "2g"},
+		{CPUs: 4},
+	} {
+		if err := e.Tighten(context.Background(), tightening); !errors.Is(err, ErrLoosening) {
+			t.Errorf("Tighten(%+v) = %v, want ErrLoosening", tightening, err)
+		}
+	}
+	if _, err := os.Stat(log); !os.IsNotExist(err) {
+		t.Errorf("the container was changed when loosening its limits")
+	}
+
+	if err := e.Tighten(context.Background(), Tightening{}); err == nil {
+		t.Errorf("Tighten() without restrictions should fail")
+	}
+	if err := e.Tighten(context.Background(), Tightening{Memory: "lots"}); err == nil {
+		t.Errorf("Tighten() with an invalid memory should fail")
+	}
+}
+
+func TestExecution_Tighten_notSupported(t *testing.T) {
+	e := newExecution(common.GetLogger(), nil, nil, nil, func() error { return nil },
+		&sharedContainerBackend{containerBackend{engine: "docker", container: "test"}})
+	if err := e.Tighten(context.Background(), Tightening{PidsLimit: 10}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Tighten() of a session command = %v, want ErrNotSupported", err)
+	}
+
+	s, _ := newFakeSession(t)
+	defer func() { _ = s.Close() }()
+	if err := s.Tighten(context.Background(), Tightening{PidsLimit: 10}); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Tighten() of a session without containers = %v, want ErrNotSupported", err)
+	}
+}
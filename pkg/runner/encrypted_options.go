@@ -0,0 +1,317 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// ErrNoDecrypter is returned when an option is encrypted with a scheme
+// without a Decrypter
+var ErrNoDecrypter = errors.New("no decrypter for the encryption scheme")
+
+// encryptedValuePattern matches the encrypted option values,
+// "ENC[<scheme>,<base64 ciphertext>]"
+var encryptedValuePattern = regexp.MustCompile(`^ENC\[([a-z0-9_-]+),([A-Za-z0-9+/=]+)\]$`)
+
+// Decrypter decrypts the option values encrypted with a scheme
+type Decrypter interface {
+	// Scheme is the name of the scheme in the encrypted values
+	Scheme() string
+
+	// Decrypt returns the plaintext of a ciphertext
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// EncryptedValue returns the option value of a ciphertext of a scheme, as
+// found in option files
+func EncryptedValue(scheme string, ciphertext []byte) string {
+	return "ENC[" + scheme + "," + base64.StdEncoding.EncodeToString(ciphertext) + "]"
+}
+
+// IsEncryptedValue returns whether an option value is encrypted
+func IsEncryptedValue(value interface{}) bool {
+	s, ok := value.(string)
+	return ok && encryptedValuePattern.MatchString(s)
+}
+
+// DecryptOptions returns a copy of the options with the encrypted values
+// (in any nested map or list) replaced by their plaintext, registered for
+// redaction (see common.RegisterSecret). The options are not modified, so the
+// encrypted ones can be kept and decrypted again when a runner is created.
+func DecryptOptions(ctx context.Context, options Options, decrypters ...Decrypter) (Options, error) {
+	if options == nil {
+		return nil, nil
+	}
+	byScheme := map[string]Decrypter{}
+	for _, d := range decrypters {
+		byScheme[d.Scheme()] = d
+	}
+	decrypted, err := decryptValue(ctx, "", map[string]interface{}(options), byScheme)
+	if err != nil {
+		return nil, err
+	}
+	return Options(decrypted.(map[string]interface{})), nil
+}
+
+// decryptValue decrypts an option value, returning a copy of maps and lists
+func decryptValue(ctx context.Context, path string, value interface{}, decrypters map[string]Decrypter) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		m := encryptedValuePattern.FindStringSubmatch(v)
+		if m == nil {
+			return v, nil
+		}
+		d, ok := decrypters[m[1]]
+		if !ok {
+			return nil, fmt.Errorf("%w: option %s is encrypted with %s", ErrNoDecrypter, path, m[1])
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(m[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid encrypted option %s: %w", path, err)
+		}
+		plaintext, err := d.Decrypt(ctx, ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt option %s: %w", path, err)
+		}
+		common.RegisterSecret(string(plaintext))
+		return string(plaintext), nil
+	case Options:
+		return decryptValue(ctx, path, map[string]interface{}(v), decrypters)
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			itemPath := key
+			if path != "" {
+				itemPath = path + "." + key
+			}
+			decrypted, err := decryptValue(ctx, itemPath, item, decrypters)
+			if err != nil {
+				return nil, err
+			}
+			res[key] = decrypted
+		}
+		return res, nil
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			decrypted, err := decryptValue(ctx, fmt.Sprintf("%s[%d]", path, i), item, decrypters)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = decrypted
+		}
+		return res, nil
+	case []string:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			decrypted, err := decryptValue(ctx, fmt.Sprintf("%s[%d]", path, i), item, decrypters)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = decrypted
+		}
+		return res, nil
+	default:
+		return v, nil
+	}
+}
+
+// LoadRunnerConfig reads a JSON runner configuration, keeping its encrypted
+// values: they are decrypted when the runner is created by a Registry with
+// decrypters (see Registry.SetDecrypters).
+func LoadRunnerConfig(path string) (RunnerConfig, error) {
+	var config RunnerConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid runner configuration %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// LoadOptionsFile reads a JSON file of options and decrypts its encrypted
+// values in memory
+func LoadOptionsFile(ctx context.Context, path string, decrypters ...Decrypter) (Options, error) {
+	var options Options
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return nil, fmt.Errorf("invalid options file %s: %w", path, err)
+	}
+	return DecryptOptions(ctx, options, decrypters...)
+}
+
+// AgeDecrypter decrypts the values encrypted with age (scheme "age"), with
+// the age command line tool
+type AgeDecrypter struct {
+	// IdentityFile is the file with the age identities (private keys)
+	IdentityFile string
+
+	// Binary is the age executable (default: "age")
+	Binary string
+}
+
+// Scheme returns "age"
+func (d AgeDecrypter) Scheme() string {
+	return "age"
+}
+
+// Decrypt decrypts a binary or armored age ciphertext
+func (d AgeDecrypter) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	binary := d.Binary
+	if binary == "" {
+		binary = "age"
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, "--decrypt", "--identity", d.IdentityFile)
+	cmd.Stdin = bytes.NewReader(ciphertext)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("age failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// KeyWrapper encrypts and decrypts data keys with a key encryption key,
+// usually kept in a KMS
+type KeyWrapper interface {
+	// WrapKey encrypts a data key with a key encryption key
+	WrapKey(ctx context.Context, keyID string, key []byte) ([]byte, error)
+
+	// UnwrapKey decrypts a data key encrypted with a key encryption key
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// envelope is the ciphertext of the "envelope" scheme: the value encrypted
+// with AES-256-GCM using a data key, itself wrapped with a key encryption key
+type envelope struct {
+	KeyID      string `json:"kid"`
+	WrappedKey []byte `json:"key"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// EnvelopeDecrypter decrypts the values encrypted with envelope encryption
+// (scheme "envelope"), unwrapping their data keys with a KeyWrapper
+type EnvelopeDecrypter struct {
+	Keys KeyWrapper
+}
+
+// Scheme returns "envelope"
+func (d EnvelopeDecrypter) Scheme() string {
+	return "envelope"
+}
+
+// Decrypt unwraps the data key of the envelope and decrypts its data
+func (d EnvelopeDecrypter) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	var env envelope
+	if err := json.Unmarshal(ciphertext, &env); err != nil {
+		return nil, fmt.Errorf("invalid envelope: %w", err)
+	}
+	key, err := d.Keys.UnwrapKey(ctx, env.KeyID, env.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap the data key with %s: %w", env.KeyID, err)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid envelope nonce")
+	}
+	return aead.Open(nil, env.Nonce, env.Data, []byte(env.KeyID))
+}
+
+// EncryptEnvelope encrypts an option value with a new data key wrapped with
+// the key encryption key keyID, returning the value to store in option files
+func EncryptEnvelope(ctx context.Context, keys KeyWrapper, keyID string, plaintext []byte) (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	env := envelope{KeyID: keyID, Nonce: make([]byte, aead.NonceSize())}
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return "", err
+	}
+	env.Data = aead.Seal(nil, env.Nonce, plaintext, []byte(keyID))
+	if env.WrappedKey, err = keys.WrapKey(ctx, keyID, key); err != nil {
+		return "", fmt.Errorf("failed to wrap the data key with %s: %w", keyID, err)
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+	return EncryptedValue("envelope", data), nil
+}
+
+// LocalKeys are key encryption keys (of 32 bytes) by ID, for services
+// without a KMS and for tests
+type LocalKeys map[string][]byte
+
+// WrapKey encrypts a data key with AES-256-GCM
+func (k LocalKeys) WrapKey(_ context.Context, keyID string, key []byte) ([]byte, error) {
+	aead, err := k.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, key, nil), nil
+}
+
+// UnwrapKey decrypts a data key encrypted by WrapKey
+func (k LocalKeys) UnwrapKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	aead, err := k.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid wrapped key")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], nil)
+}
+
+// aead returns the cipher of a key encryption key
+func (k LocalKeys) aead(keyID string) (cipher.AEAD, error) {
+	key, ok := k[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	return newGCM(key)
+}
+
+// newGCM returns an AES-256-GCM cipher
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid key length %d: 32 bytes required", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestEncryptEnvelope(t *testing.T) {
+	ctx := context.Background()
+	keys := LocalKeys{"main": make([]byte, 32)}
+
+	value, err := EncryptEnvelope(ctx, keys, "main", []byte("s3cr3t"))
+	if err != nil {
+		t.Fatalf("EncryptEnvelope failed: %v", err)
+	}
+	if !IsEncryptedValue(value) || strings.Contains(value, "s3cr3t") {
+		t.Fatalf("EncryptEnvelope() = %q, want an encrypted value", value)
+	}
+
+	options := Options{
+		"image": "alpine",
+		"registry_auth": map[string]interface{}{
+			"password": value,
+		},
+		"env": []string{"PLAIN=1", value},
+	}
+	decrypted, err := DecryptOptions(ctx, options, EnvelopeDecrypter{Keys: keys})
+	if err != nil {
+		t.Fatalf("DecryptOptions failed: %v", err)
+	}
+	if got := decrypted["registry_auth"].(map[string]interface{})["password"]; got != "s3cr3t" {
+		t.Errorf("decrypted password = %v", got)
+	}
+	if got := decrypted["env"].([]interface{}); got[0] != "PLAIN=1" || got[1] != "s3cr3t" {
+		t.Errorf("decrypted env = %v", got)
+	}
+	if options["registry_auth"].(map[string]interface{})["password"] != value {
+		t.Errorf("the options were modified")
+	}
+
+	if _, err := DecryptOptions(ctx, options); !errors.Is(err, ErrNoDecrypter) {
+		t.Errorf("DecryptOptions() without decrypters = %v, want ErrNoDecrypter", err)
+	}
+	wrongKeys := LocalKeys{"main": []byte(strings.Repeat("x", 32))}
+	if _, err := DecryptOptions(ctx, options, EnvelopeDecrypter{Keys: wrongKeys}); err == nil {
+		t.Errorf("DecryptOptions() with the wrong key should fail")
+	}
+}
+
+func TestLoadOptionsFile_age(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	dir := t.TempDir()
+	// a fake age reversing its input
+	age := filepath.Join(dir, "age")
+	if err := os.WriteFile(age, []byte("#!/bin/sh\nrev\n"), 0o755); err != nil {
+		t.Fatalf("failed to write the fake age: %v", err)
+	}
+	path := filepath.Join(dir, "options.json")
+	content := `{"allow_networking": false, "token": "` + EncryptedValue("age", []byte("eulav-terces-ega")) + `"}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write the options: %v", err)
+	}
+
+	options, err := LoadOptionsFile(context.Background(), path, AgeDecrypter{IdentityFile: "key.txt", Binary: age})
+	if err != nil {
+		t.Fatalf("LoadOptionsFile failed: %v", err)
+	}
+	if got := strings.TrimSpace(options["token"].(string)); got != "age-secret-value" || options["allow_networking"] != false {
+		t.Errorf("LoadOptionsFile() = %v", options)
+	}
+}
+
+func TestRegistry_decrypters(t *testing.T) {
+	ctx := context.Background()
+	keys := LocalKeys{"main": make([]byte, 32)}
+	value, err := EncryptEnvelope(ctx, keys, "main", []byte("registry-api-token"))
+	if err != nil {
+		t.Fatalf("EncryptEnvelope failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "runner.json")
+	content := `{"type": "exec", "options": {"api_token": "` + value + `"}}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write the configuration: %v", err)
+	}
+	config, err := LoadRunnerConfig(path)
+	if err != nil {
+		t.Fatalf("LoadRunnerConfig failed: %v", err)
+	}
+	if config.Options["api_token"] != value {
+		t.Errorf("LoadRunnerConfig() decrypted the options: %v", config.Options)
+	}
+
+	reg := NewRegistry(nil)
+	if err := reg.Register("echo", config); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if _, err := reg.Get("echo"); !errors.Is(err, ErrNoDecrypter) {
+		t.Errorf("Get() without decrypters = %v, want ErrNoDecrypter", err)
+	}
+	reg.SetDecrypters(EnvelopeDecrypter{Keys: keys})
+	if _, err := reg.Get("echo"); err != nil {
+		t.Errorf("Get failed: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envNamePattern matches the valid names of the variables of env files
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// EnvFileOptions sets environment variables of the commands from .env
+// files. It is embedded in the options of all the runners, so its fields
+// are set with the same keys as any other option.
+//
+// The variables of the files are overridden by the env of the commands,
+// and all of them by the variables pinned by DeterminismOptions. Runners
+// inheriting the environment of the current process append the variables
+// to it, so they override the inherited ones.
+type EnvFileOptions struct {
+	// EnvFiles are the .env files read when the runner is created, with
+	// the variables of the later files overriding those of the earlier ones
+	EnvFiles []string `json:"env_files"`
+
+	// envFileVars are the variables of the files, as NAME=VALUE
+	envFileVars []string
+}
+
+// loadEnvFiles reads and parses the env files
+func (o *EnvFileOptions) loadEnvFiles() error {
+	o.envFileVars = nil
+	for _, path := range o.EnvFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read env file: %w", err)
+		}
+		vars, err := parseEnvFile(string(data))
+		if err != nil {
+			return fmt.Errorf("invalid env file %s: %w", path, err)
+		}
+		o.envFileVars = mergeEnv(o.envFileVars, vars)
+	}
+	return nil
+}
+
+// withEnvFiles returns env with the variables of the env files it does not set
+func (o EnvFileOptions) withEnvFiles(env []string) []string {
+	if len(o.envFileVars) == 0 {
+		return env
+	}
+	return mergeEnv(o.envFileVars, env)
+}
+
+// envFilesFingerprint returns the variables of the env files, for the
+// fingerprint of the runner
+func (o EnvFileOptions) envFilesFingerprint() []string {
+	return o.envFileVars
+}
+
+// mergeEnv returns the variables of base not set by overrides, followed
+// by overrides
+func mergeEnv(base, overrides []string) []string {
+	overridden := map[string]bool{}
+	for _, e := range overrides {
+		overridden[strings.SplitN(e, "=", 2)[0]] = true
+	}
+	res := make([]string, 0, len(base)+len(overrides))
+	for _, e := range base {
+		if !overridden[strings.SplitN(e, "=", 2)[0]] {
+			res = append(res, e)
+		}
+	}
+	return append(res, overrides...)
+}
+
+// parseEnvFile parses the contents of an env file, returning its variables
+// as NAME=VALUE. The files contain a NAME=VALUE assignment per line (with
+// an optional `export` before the name), blank lines and comments starting
+// with `#`. Values can be:
+//
+//   - unquoted: the rest of the line, without the surrounding spaces nor
+//     the comments after a space
+//   - single quoted: the text between the quotes, verbatim
+//   - double quoted: the text between the quotes, with the escape sequences
+//     \n, \r, \t, \", \\ and \$ replaced
+//
+// Quoted values can span several lines. Variables are not expanded.
+func parseEnvFile(data string) ([]string, error) {
+	data = strings.TrimPrefix(strings.ReplaceAll(data, "\r\n", "\n"), "\ufeff")
+
+	var vars []string
+	line := 1
+	for len(data) > 0 {
+		start := line
+		var current string
+		current, data, _ = strings.Cut(data, "\n")
+		line++
+
+		current = strings.TrimSpace(current)
+		if current == "" || strings.HasPrefix(current, "#") {
+			continue
+		}
+		current = strings.TrimPrefix(current, "export ")
+		name, value, found := strings.Cut(current, "=")
+		name = strings.TrimSpace(name)
+		if !found {
+			return nil, fmt.Errorf("line %d: expected NAME=VALUE", start)
+		}
+		if !envNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("line %d: invalid variable name %q", start, name)
+		}
+
+		value = strings.TrimLeft(value, " \t")
+		if value == "" || (value[0] != '\'' && value[0] != '"') {
+			// unquoted values end at the comments
+			for i := 1; i < len(value); i++ {
+				if value[i] == '#' && (value[i-1] == ' ' || value[i-1] == '\t') {
+					value = value[:i]
+					break
+				}
+			}
+			vars = mergeEnv(vars, []string{name + "=" + strings.TrimSpace(value)})
+			continue
+		}
+
+		// quoted values continue in the next lines until the closing quote
+		quote := value[0]
+		value = value[1:]
+		for {
+			end := closingQuote(value, quote)
+			if end >= 0 {
+				rest := strings.TrimSpace(value[end+1:])
+				if rest != "" && !strings.HasPrefix(rest, "#") {
+					return nil, fmt.Errorf("line %d: unexpected text after the quoted value", line-1)
+				}
+				value = value[:end]
+				break
+			}
+			if len(data) == 0 {
+				return nil, fmt.Errorf("line %d: unterminated quoted value", start)
+			}
+			var next string
+			next, data, _ = strings.Cut(data, "\n")
+			line++
+			value += "\n" + next
+		}
+		if quote == '"' {
+			value = unescapeEnvValue(value)
+		}
+		vars = mergeEnv(vars, []string{name + "=" + value})
+	}
+	return vars, nil
+}
+
+// closingQuote returns the index of the quote closing value, or -1. Double
+// quotes escaped with a backslash do not close double-quoted values.
+func closingQuote(value string, quote byte) int {
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && quote == '"':
+			i++
+		case value[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// unescapeEnvValue replaces the escape sequences of a double-quoted value.
+// Other backslashes are kept.
+func unescapeEnvValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '"', '\\', '$':
+			b.WriteByte(value[i])
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,138 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "unquoted values and comments",
+			data: "# comment\n\nexport A=1\nB = two words  # comment\nC=x#y\nD=\n",
+			want: []string{"A=1", "B=two words", "C=x#y", "D="},
+		},
+		{
+			name: "no escapes in single quotes",
+			data: `A='it\'s $HOME "x"' # comment` + "\n",
+			want: nil,
+			// the backslash does not escape the quote in single quotes
+			wantErr: "line 1: unexpected text",
+		},
+		{
+			name: "single quoted",
+			data: `A='$HOME \n "x"'`,
+			want: []string{`A=$HOME \n "x"`},
+		},
+		{
+			name: "double quoted escapes",
+			data: `A="a\tb\n\"c\" \\ \$HOME \q"`,
+			want: []string{"A=a\tb\n\"c\" \\ $HOME \\q"},
+		},
+		{
+			name: "multiline values",
+			data: "KEY=\"-----BEGIN-----\nabc\n-----END-----\"\nB='x\r\ny'\nC=3\r\n",
+			want: []string{"KEY=-----BEGIN-----\nabc\n-----END-----", "B=x\ny", "C=3"},
+		},
+		{
+			name: "later assignments win",
+			data: "A=1\nB=2\nA=3\n",
+			want: []string{"B=2", "A=3"},
+		},
+		{
+			name:    "missing equals",
+			data:    "A=1\nB\n",
+			wantErr: "line 2: expected NAME=VALUE",
+		},
+		{
+			name:    "invalid name",
+			data:    "1A=1\n",
+			wantErr: `line 1: invalid variable name "1A"`,
+		},
+		{
+			name:    "unterminated quote",
+			data:    "A=1\nB=\"abc\nC=2\n",
+			wantErr: "line 2: unterminated quoted value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEnvFile(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseEnvFile() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseEnvFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvFileOptions_withEnvFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.env")
+	second := filepath.Join(dir, "second.env")
+	if err := os.WriteFile(first, []byte("A=1\nB=1\nC=1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(second, []byte("B=2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := EnvFileOptions{EnvFiles: []string{first, second}}
+	if err := opts.loadEnvFiles(); err != nil {
+		t.Fatal(err)
+	}
+	got := opts.withEnvFiles([]string{"C=explicit"})
+	want := []string{"A=1", "B=2", "C=explicit"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("withEnvFiles() = %q, want %q", got, want)
+	}
+
+	opts = EnvFileOptions{EnvFiles: []string{filepath.Join(dir, "missing.env")}}
+	if err := opts.loadEnvFiles(); err == nil {
+		t.Error("loadEnvFiles() should fail for a missing file")
+	}
+}
+
+func TestExec_envFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell")
+	}
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("GREETING=\"hello\nworld\"\nNAME=file\nTZ=Europe/Paris\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewExec(Options{"env_files": []string{path}, "timezone": "UTC"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := r.Run(context.Background(), "sh", `echo "$GREETING $NAME $TZ"`, []string{"NAME=explicit"}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "hello\nworld explicit UTC" {
+		t.Errorf("Run() = %q", output)
+	}
+
+	if _, err := NewExec(Options{"env_files": []string{filepath.Join(t.TempDir(), "missing")}}, nil); err == nil {
+		t.Error("NewExec() should fail for a missing env file")
+	}
+}
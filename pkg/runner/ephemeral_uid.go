@@ -0,0 +1,211 @@
+package runner
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// EphemeralUIDOptions runs every command as a new unprivileged user (Linux only)
+type EphemeralUIDOptions struct {
+	// EphemeralUID runs every command as a random UID and GID, mapped in a
+	// new user namespace from the subordinate IDs of the user (see
+	// subuid(5)). The files created by the command are owned by that UID,
+	// and it cannot access the files of the user unless they are accessible
+	// to everyone: the writable folders must be writable by other users.
+	EphemeralUID bool `json:"ephemeral_uid"`
+}
+
+// ephemeralUIDHelperEnv is set (to the JSON configuration of the helper) when
+// this executable is started as the helper that maps the ephemeral UID of
+// the command with newuidmap(1)
+const ephemeralUIDHelperEnv = "RUNNER_EPHEMERAL_UID_HELPER"
+
+// defaultEphemeralIDs are the IDs of the ephemeral users of root when it has
+// no subordinate IDs, above the ranges usually given to users and containers
+var defaultEphemeralIDs = idRange{Start: 0x70000000, Size: 65536}
+
+// idRange is a range of subordinate UIDs or GIDs
+type idRange struct {
+	Start uint32
+	Size  uint32
+}
+
+// ephemeralIDs are the UID and GID of an ephemeral user
+type ephemeralIDs struct {
+	UID uint32 `json:"uid"`
+	GID uint32 `json:"gid"`
+}
+
+// ephemeralUIDHelperConfig is the configuration passed to the ephemeral UID
+// helper
+type ephemeralUIDHelperConfig struct {
+	ephemeralIDs
+
+	// ExtraFiles is the number of files passed to the command after stderr
+	ExtraFiles int `json:"extra_files,omitempty"`
+
+	// Mapped is set in the process of the new user namespace, once started
+	Mapped bool `json:"mapped,omitempty"`
+}
+
+// validateEphemeralUID checks that commands can run as ephemeral users in
+// this OS, and with the namespaces given: the user namespace of the
+// ephemeral user cannot be nested in the one of the namespace helper
+func (o EphemeralUIDOptions) validateEphemeralUID(namespaces NamespaceOptions) error {
+	if !o.EphemeralUID {
+		return nil
+	}
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("ephemeral_uid requires Linux: %w", ErrNotSupported)
+	}
+	if namespaces.PrivatePIDs || namespaces.ReadOnlyRoot || namespaces.PrivateIPC || namespaces.hostname() != "" {
+		return fmt.Errorf("ephemeral_uid cannot be combined with private_pids, read_only_root, private_ipc or private_uts: %w",
+			ErrNotSupported)
+	}
+	return nil
+}
+
+// checkEphemeralUID checks that the ephemeral users can be mapped: root maps
+// them itself, other users need newuidmap(1), newgidmap(1) and subordinate
+// IDs
+func (o EphemeralUIDOptions) checkEphemeralUID() error {
+	if !o.EphemeralUID || os.Getuid() == 0 {
+		return nil
+	}
+	for _, tool := range []string{"newuidmap", "newgidmap"} {
+		if !common.CheckExecutableExists(tool) {
+			return fmt.Errorf("ephemeral_uid requires %s (usually in the uidmap package)", tool)
+		}
+	}
+	_, _, err := ephemeralIDRanges()
+	return err
+}
+
+// ephemeralUIDExecutables returns the executables run to start a command as
+// an ephemeral user, which must be allowed when the runner is restricted
+// with Landlock
+func (o EphemeralUIDOptions) ephemeralUIDExecutables() ([]string, error) {
+	if !o.EphemeralUID || os.Getuid() == 0 {
+		return nil, nil
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the ephemeral UID helper: %w", err)
+	}
+	executables := []string{self}
+	for _, tool := range []string{"newuidmap", "newgidmap"} {
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			return nil, fmt.Errorf("ephemeral_uid requires %s: %w", tool, err)
+		}
+		executables = append(executables, path)
+	}
+	return executables, nil
+}
+
+// applyEphemeralUID changes cmd so it runs as a new random user, when
+// enabled. It must be called after the Landlock helper (see
+// restrictInHelper). The ephemeral user cannot be combined with the loopback
+// network, as its user namespace cannot be nested in the one of the
+// loopback helper.
+func (o EphemeralUIDOptions) applyEphemeralUID(logger Logger, cmd *exec.Cmd, loopback bool) error {
+	if !o.EphemeralUID || cmd.Err != nil {
+		return nil
+	}
+	if loopback {
+		return fmt.Errorf("ephemeral_uid cannot be combined with the loopback network: %w", ErrNotSupported)
+	}
+	ids, err := newEphemeralIDs()
+	if err != nil {
+		return err
+	}
+	logger.Debug("Running the command as the ephemeral UID %d (GID %d)", ids.UID, ids.GID)
+	return runAsEphemeralUser(cmd, ids)
+}
+
+// newEphemeralIDs returns a random UID and GID from the subordinate IDs of
+// the user
+func newEphemeralIDs() (ephemeralIDs, error) {
+	uids, gids, err := ephemeralIDRanges()
+	if err != nil {
+		return ephemeralIDs{}, err
+	}
+	size := min(uids.Size, gids.Size)
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(size)))
+	if err != nil {
+		return ephemeralIDs{}, fmt.Errorf("failed to choose an ephemeral UID: %w", err)
+	}
+	offset := uint32(n.Int64())
+	return ephemeralIDs{UID: uids.Start + offset, GID: gids.Start + offset}, nil
+}
+
+// ephemeralIDRanges returns the subordinate UIDs and GIDs of the user, from
+// /etc/subuid and /etc/subgid. Root uses defaultEphemeralIDs when it has none.
+func ephemeralIDRanges() (uids idRange, gids idRange, err error) {
+	current, err := user.Current()
+	if err != nil {
+		return uids, gids, fmt.Errorf("failed to find the current user: %w", err)
+	}
+	uids, uidsFound, err := subordinateIDs("/etc/subuid", current.Username, current.Uid)
+	if err != nil {
+		return uids, gids, err
+	}
+	gids, gidsFound, err := subordinateIDs("/etc/subgid", current.Username, current.Uid)
+	if err != nil {
+		return uids, gids, err
+	}
+	if uidsFound && gidsFound {
+		return uids, gids, nil
+	}
+	if os.Getuid() == 0 {
+		return defaultEphemeralIDs, defaultEphemeralIDs, nil
+	}
+	return uids, gids, fmt.Errorf("ephemeral_uid requires subordinate UIDs and GIDs for %s in /etc/subuid and /etc/subgid",
+		current.Username)
+}
+
+// subordinateIDs returns the first range of subordinate IDs of the user (by
+// name or UID) in a subuid(5) or subgid(5) file
+func subordinateIDs(path string, name string, uid string) (idRange, bool, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return idRange{}, false, nil
+	} else if err != nil {
+		return idRange{}, false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer f.Close()
+	return parseSubordinateIDs(f, path, name, uid)
+}
+
+// parseSubordinateIDs returns the first range of subordinate IDs of the user
+// (by name or UID) in the lines of the subuid(5) or subgid(5) file at path
+func parseSubordinateIDs(r io.Reader, path string, name string, uid string) (idRange, bool, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(fields) != 3 || (fields[0] != name && fields[0] != uid) {
+			continue
+		}
+		start, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return idRange{}, false, fmt.Errorf("invalid subordinate IDs in %s: %q", path, scanner.Text())
+		}
+		size, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil || size == 0 || start+size > 1<<32-1 {
+			return idRange{}, false, fmt.Errorf("invalid subordinate IDs in %s: %q", path, scanner.Text())
+		}
+		return idRange{Start: uint32(start), Size: uint32(size)}, true, nil
+	}
+	return idRange{}, false, scanner.Err()
+}
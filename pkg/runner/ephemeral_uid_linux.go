@@ -0,0 +1,187 @@
+//go:build linux
+
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// prSetPdeathsig is the prctl argument for setting the signal sent when the
+// parent process dies
+const prSetPdeathsig = 1
+
+// ephemeralUIDMapTimeout is how long the command waits for its UID to be
+// mapped by the ephemeral UID helper
+const ephemeralUIDMapTimeout = 10 * time.Second
+
+// runAsEphemeralUser changes cmd so it runs as the ephemeral user ids, the
+// only user mapped in a new user namespace.
+//
+// Root maps the user itself. Other users can only map their subordinate IDs
+// with newuidmap(1) and newgidmap(1) once the namespace exists, so the
+// command is started through this executable: the helper mode (see
+// runEphemeralUIDHelper) starts a process in the new namespace, maps its IDs
+// and waits for it, while that process switches to the ephemeral user and
+// executes the command.
+func runAsEphemeralUser(cmd *exec.Cmd, ids ephemeralIDs) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if os.Getuid() == 0 {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: int(ids.UID), HostID: int(ids.UID), Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: int(ids.GID), HostID: int(ids.GID), Size: 1}}
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = true
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: ids.UID, Gid: ids.GID}
+		return nil
+	}
+
+	self, err := helperExecutable("ephemeral UID helper")
+	if err != nil {
+		return err
+	}
+	config, err := json.Marshal(ephemeralUIDHelperConfig{ephemeralIDs: ids, ExtraFiles: len(cmd.ExtraFiles)})
+	if err != nil {
+		return fmt.Errorf("failed to configure the ephemeral UID helper: %w", err)
+	}
+
+	cmd.Args = append([]string{"runner-ephemeral-uid-helper", cmd.Path}, cmd.Args...)
+	cmd.Path = self
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, ephemeralUIDHelperEnv+"="+string(config))
+
+	// signals must reach the command, not only the helper
+	setProcessGroup(cmd)
+	return nil
+}
+
+// ephemeralUIDHelperFail reports an error of the ephemeral UID helper and exits
+func ephemeralUIDHelperFail(code int, format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "runner: "+format+"\n", args...)
+	os.Exit(code)
+}
+
+// runEphemeralUIDHelper starts the command in os.Args[1:] (its path followed
+// by its arguments) in a new user namespace, as the ephemeral user of its
+// configuration, and exits with the exit code of the command (or 128 plus
+// the number of the signal that killed it). It never returns.
+func runEphemeralUIDHelper() {
+	var config ephemeralUIDHelperConfig
+	if err := json.Unmarshal([]byte(os.Getenv(ephemeralUIDHelperEnv)), &config); err != nil {
+		ephemeralUIDHelperFail(126, "invalid ephemeral UID helper configuration: %v", err)
+	}
+	if len(os.Args) < 3 {
+		ephemeralUIDHelperFail(126, "missing command for the ephemeral UID helper")
+	}
+	if config.Mapped {
+		execAsEphemeralUser(config.ephemeralIDs)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		ephemeralUIDHelperFail(126, "failed to find the ephemeral UID helper: %v", err)
+	}
+	config.Mapped = true
+	data, err := json.Marshal(config)
+	if err != nil {
+		ephemeralUIDHelperFail(126, "failed to configure the ephemeral UID helper: %v", err)
+	}
+	env := []string{ephemeralUIDHelperEnv + "=" + string(data)}
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, ephemeralUIDHelperEnv+"=") {
+			env = append(env, e)
+		}
+	}
+
+	// the helper survives (doing nothing) the signals sent to the process
+	// group, which also reach the command, until the command exits
+	signal.Notify(make(chan os.Signal, 1),
+		syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	files := make([]uintptr, 3+config.ExtraFiles)
+	for i := range files {
+		files[i] = uintptr(i)
+	}
+	pid, err := syscall.ForkExec(self, os.Args, &syscall.ProcAttr{
+		Env:   env,
+		Files: files,
+		Sys:   &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWUSER, Pdeathsig: syscall.SIGKILL},
+	})
+	if err != nil {
+		ephemeralUIDHelperFail(126, "failed to create the user namespace: %v", err)
+	}
+
+	for _, mapping := range []struct {
+		tool string
+		id   uint32
+	}{{"newuidmap", config.UID}, {"newgidmap", config.GID}} {
+		id := strconv.FormatUint(uint64(mapping.id), 10)
+		if output, err := exec.Command(mapping.tool, strconv.Itoa(pid), id, id, "1").CombinedOutput(); err != nil {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+			ephemeralUIDHelperFail(126, "failed to map the ephemeral user with %s: %v: %s",
+				mapping.tool, err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	for {
+		var status syscall.WaitStatus
+		_, err := syscall.Wait4(pid, &status, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			ephemeralUIDHelperFail(126, "failed to wait for %s: %v", os.Args[1], err)
+		}
+		if status.Signaled() {
+			os.Exit(128 + int(status.Signal()))
+		}
+		os.Exit(status.ExitStatus())
+	}
+}
+
+// execAsEphemeralUser waits until the ephemeral user is mapped in the user
+// namespace of this process, switches to it (without supplementary groups)
+// and executes the command in os.Args[1:]. It never returns.
+func execAsEphemeralUser(ids ephemeralIDs) {
+	_ = os.Unsetenv(ephemeralUIDHelperEnv)
+
+	deadline := time.Now().Add(ephemeralUIDMapTimeout)
+	for !idMapped("/proc/self/uid_map") || !idMapped("/proc/self/gid_map") {
+		if time.Now().After(deadline) {
+			ephemeralUIDHelperFail(126, "timeout waiting for the ephemeral user to be mapped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := syscall.Setgroups([]int{}); err != nil {
+		ephemeralUIDHelperFail(126, "failed to drop the supplementary groups: %v", err)
+	}
+	if err := syscall.Setresgid(int(ids.GID), int(ids.GID), int(ids.GID)); err != nil {
+		ephemeralUIDHelperFail(126, "failed to switch to the ephemeral GID %d: %v", ids.GID, err)
+	}
+	if err := syscall.Setresuid(int(ids.UID), int(ids.UID), int(ids.UID)); err != nil {
+		ephemeralUIDHelperFail(126, "failed to switch to the ephemeral UID %d: %v", ids.UID, err)
+	}
+
+	// the signal sent when the helper dies is reset when switching users
+	_, _, _ = syscall.RawSyscall(syscall.SYS_PRCTL, prSetPdeathsig, uintptr(syscall.SIGKILL), 0)
+
+	err := syscall.Exec(os.Args[1], os.Args[2:], os.Environ())
+	ephemeralUIDHelperFail(127, "failed to execute %s: %v", os.Args[1], err)
+}
+
+// idMapped returns whether some ID is mapped in the uid_map or gid_map file
+func idMapped(path string) bool {
+	data, err := os.ReadFile(path)
+	return err == nil && strings.TrimSpace(string(data)) != ""
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package runner
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// runAsEphemeralUser is only supported on Linux, where user namespaces exist
+func runAsEphemeralUser(cmd *exec.Cmd, ids ephemeralIDs) error {
+	return fmt.Errorf("ephemeral_uid requires Linux: %w", ErrNotSupported)
+}
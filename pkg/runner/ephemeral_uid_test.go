@@ -0,0 +1,137 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseSubordinateIDs(t *testing.T) {
+	content := "# comment\nalice:100000:65536\n1001:165536:65536\nbob:231072:1000\n"
+	tests := []struct {
+		name  string
+		user  string
+		uid   string
+		want  idRange
+		found bool
+	}{
+		{"by name", "alice", "1000", idRange{Start: 100000, Size: 65536}, true},
+		{"by uid", "carol", "1001", idRange{Start: 165536, Size: 65536}, true},
+		{"first range", "bob", "1002", idRange{Start: 231072, Size: 1000}, true},
+		{"missing", "dave", "1003", idRange{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found, err := parseSubordinateIDs(strings.NewReader(content), "/etc/subuid", tt.user, tt.uid)
+			if err != nil {
+				t.Fatalf("parseSubordinateIDs failed: %v", err)
+			}
+			if got != tt.want || found != tt.found {
+				t.Errorf("parseSubordinateIDs() = %v, %v, want %v, %v", got, found, tt.want, tt.found)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"alice:x:65536", "alice:100000:0", "alice:4294967000:65536"} {
+		if _, _, err := parseSubordinateIDs(strings.NewReader(invalid), "/etc/subuid", "alice", "1000"); err == nil {
+			t.Errorf("parseSubordinateIDs(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestValidateEphemeralUID(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		_, err := NewExec(Options{"ephemeral_uid": true}, nil)
+		if !errors.Is(err, ErrNotSupported) {
+			t.Errorf("NewExec() with ephemeral_uid = %v, want ErrNotSupported", err)
+		}
+		return
+	}
+
+	if _, err := NewExec(Options{"ephemeral_uid": true}, nil); err != nil {
+		t.Errorf("NewExec() with ephemeral_uid failed: %v", err)
+	}
+	for _, options := range []Options{
+		{"ephemeral_uid": true, "private_pids": true},
+		{"ephemeral_uid": true, "hostname": "sandbox"},
+		{"ephemeral_uid": true, "login_session": map[string]interface{}{"user": "nobody"}},
+	} {
+		if _, err := NewExec(options, nil); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("NewExec(%v) = %v, want ErrNotSupported", options, err)
+		}
+	}
+	if _, err := NewLandrun(Options{"ephemeral_uid": true, "read_only_root": true}, nil); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("NewLandrun() with ephemeral_uid and read_only_root = %v, want ErrNotSupported", err)
+	}
+
+	opts := EphemeralUIDOptions{EphemeralUID: true}
+	if err := opts.applyEphemeralUID(defaultLogger(nil), exec.Command("true"), true); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("applyEphemeralUID() with the loopback network = %v, want ErrNotSupported", err)
+	}
+}
+
+func TestExec_EphemeralUID(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("ephemeral users require Linux")
+	}
+	r, err := NewExec(Options{"ephemeral_uid": true}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	if err := r.CheckImplicitRequirements(); err != nil {
+		t.Skipf("ephemeral users not available: %v", err)
+	}
+
+	// the folder is writable by the ephemeral user, the secret only by this user
+	dir := t.TempDir()
+	if err := os.Chmod(filepath.Dir(dir), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0o1777); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	output, err := r.Run(ctx, "/bin/sh", "id -u && touch "+filepath.Join(dir, "created"), nil, nil, false)
+	if err != nil {
+		t.Skipf("the ephemeral user cannot run commands here: %v", err)
+	}
+	uid, err := strconv.Atoi(output)
+	if err != nil || uid == os.Getuid() {
+		t.Errorf("the command runs as %q, not as an ephemeral user", output)
+	}
+	owner, err := exec.Command("stat", "-c", "%u", filepath.Join(dir, "created")).Output()
+	if err != nil {
+		t.Fatalf("the command did not create the file: %v", err)
+	}
+	if strings.TrimSpace(string(owner)) != strconv.Itoa(uid) {
+		t.Errorf("the file is owned by %s, want the ephemeral UID %d", owner, uid)
+	}
+
+	if output, err := r.Run(ctx, "/bin/sh", "cat "+secret, nil, nil, false); err == nil {
+		t.Errorf("the ephemeral user read the files of the user: %q", output)
+	}
+
+	// every execution gets its own user
+	uids := map[string]bool{output: true}
+	for i := 0; i < 3; i++ {
+		next, err := r.Run(ctx, "/bin/sh", "id -u", nil, nil, false)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		uids[next] = true
+	}
+	if len(uids) == 1 {
+		t.Errorf("all the executions run as the ephemeral UID %s", output)
+	}
+}
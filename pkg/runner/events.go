@@ -0,0 +1,162 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"time"
+)
+
+// EventType is the type of an execution lifecycle event
+type EventType string
+
+const (
+	// EventPreparing is emitted before the runner starts preparing the execution
+	EventPreparing EventType = "preparing"
+	// EventImagePull is emitted while a container image is being pulled
+	EventImagePull EventType = "image_pull"
+	// EventStarted is emitted once the command is running
+	EventStarted EventType = "started"
+	// EventOutputChunk is emitted for every chunk of output read from the command
+	EventOutputChunk EventType = "output_chunk"
+	// EventExited is emitted when the command has completed
+	EventExited EventType = "exited"
+	// EventDetection is emitted when a detector rule triggers (see WithDetectorRules)
+	EventDetection EventType = "detection"
+	// EventPromptApproval is emitted when a prompt of the command has been
+	// approved or denied (see WithPromptApproval)
+	EventPromptApproval EventType = "prompt_approval"
+)
+
+// Event is a lifecycle event of an execution. Only the fields relevant to
+// the Type of the event are set.
+type Event struct {
+	// Type is the type of the event
+	Type EventType `json:"type"`
+	// ExecutionID is the ID of the execution
+	ExecutionID string `json:"execution_id"`
+	// Time is when the event happened
+	Time time.Time `json:"time"`
+
+	// Image is the image being pulled (EventImagePull)
+	Image string `json:"image,omitempty"`
+	// Progress is the progress reported by the container engine (EventImagePull)
+	Progress string `json:"progress,omitempty"`
+
+	// PID is the process ID of the command on the host (EventStarted)
+	PID int `json:"pid,omitempty"`
+	// Container is the container running the command (EventStarted)
+	Container string `json:"container,omitempty"`
+
+	// Stream is "stdout" or "stderr" (EventOutputChunk)
+	Stream string `json:"stream,omitempty"`
+	// Data is the chunk of output (EventOutputChunk)
+	Data []byte `json:"data,omitempty"`
+
+	// ExitCode is the exit code of the command, or -1 if unknown (EventExited)
+	ExitCode int `json:"exit_code"`
+	// Err is the error returned by Wait (EventExited)
+	Err error `json:"-"`
+
+	// Detection is the trigger of the detector rule (EventDetection)
+	Detection *Detection `json:"detection,omitempty"`
+
+	// Approval is the approval of the prompt of the command (EventPromptApproval)
+	Approval *ApprovalRecord `json:"approval,omitempty"`
+}
+
+// EventHandler receives the lifecycle events of an execution. It is called
+// synchronously, so it should not block.
+type EventHandler func(Event)
+
+// WithEventHandler registers a handler for the lifecycle events of the execution
+func WithEventHandler(handler EventHandler) ExecOption {
+	return func(c *execConfig) {
+		c.eventHandler = handler
+	}
+}
+
+// WithEventChannel sends the lifecycle events of the execution to a channel.
+// Events are dropped when the channel is full, so a buffered channel should
+// be used. The channel is not closed: EventExited is the last event.
+func WithEventChannel(ch chan<- Event) ExecOption {
+	return WithEventHandler(func(ev Event) {
+		select {
+		case ch <- ev:
+		default:
+		}
+	})
+}
+
+// eventHandlerKey is the context key of the event handler of an execution
+type eventHandlerKey struct{}
+
+// eventEmitter emits events for an execution
+type eventEmitter struct {
+	id      string
+	handler EventHandler
+}
+
+// emit sends an event, filling the execution ID and the time
+func (em *eventEmitter) emit(ev Event) {
+	if em == nil {
+		return
+	}
+	ev.ExecutionID = em.id
+	ev.Time = time.Now()
+	em.handler(ev)
+}
+
+// withEventEmitter returns a context carrying the emitter, so runners can
+// report the progress of their preparation steps (e.g. pulling images)
+func withEventEmitter(ctx context.Context, em *eventEmitter) context.Context {
+	return context.WithValue(ctx, eventHandlerKey{}, em)
+}
+
+// eventEmitterFrom returns the emitter of the context, or nil
+func eventEmitterFrom(ctx context.Context) *eventEmitter {
+	em, _ := ctx.Value(eventHandlerKey{}).(*eventEmitter)
+	return em
+}
+
+// emitStarted emits EventStarted with the details of the backend of the execution
+func (em *eventEmitter) emitStarted(e *Execution) {
+	ev := Event{Type: EventStarted}
+	switch b := e.backend.(type) {
+	case *processBackend:
+		ev.PID = b.pid
+	case *containerBackend:
+		ev.Container = b.container
+	}
+	em.emit(ev)
+}
+
+// exitCode returns the exit code for the error returned by a command
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// chunkReader emits an EventOutputChunk for every chunk read
+type chunkReader struct {
+	io.ReadCloser
+	stream  string
+	emitter *eventEmitter
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		data := make([]byte, n)
+		copy(data, p[:n])
+		r.emitter.emit(Event{Type: EventOutputChunk, Stream: r.stream, Data: data})
+	}
+	return n, err
+}
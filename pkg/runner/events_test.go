@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// TestStart_Events tests the lifecycle events emitted for an execution
+func TestStart_Events(t *testing.T) {
+	logger, _ := common.NewLogger("", "", common.LogLevelDebug, false)
+	runner, err := NewExec(Options{}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+
+	var mu sync.Mutex
+	var events []Event
+	handler := func(ev Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev)
+	}
+
+	e, err := Start(context.Background(), runner, "sh", []string{"-c", "echo out; echo err >&2; exit 3"}, nil, nil,
+		WithEventHandler(handler))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	_ = e.Stdin.Close()
+	_, _ = io.ReadAll(e.Stdout)
+	_, _ = io.ReadAll(e.Stderr)
+	if err := e.Wait(); err == nil {
+		t.Fatalf("Expected Wait to fail")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var types []string
+	output := map[string]string{}
+	for _, ev := range events {
+		if ev.ExecutionID != e.ID {
+			t.Errorf("Expected execution ID %s, got %s", e.ID, ev.ExecutionID)
+		}
+		switch ev.Type {
+		case EventOutputChunk:
+			output[ev.Stream] += string(ev.Data)
+			continue
+		case EventStarted:
+			if ev.PID == 0 {
+				t.Errorf("Expected a PID in the started event")
+			}
+		case EventExited:
+			if ev.ExitCode != 3 {
+				t.Errorf("Expected exit code 3, got %d", ev.ExitCode)
+			}
+		}
+		types = append(types, string(ev.Type))
+	}
+
+	if got := strings.Join(types, ","); got != "preparing,started,exited" {
+		t.Errorf("Unexpected events: %s", got)
+	}
+	if output["stdout"] != "out\n" || output["stderr"] != "err\n" {
+		t.Errorf("Unexpected output chunks: %v", output)
+	}
+}
+
+// TestWithEventChannel tests that events are not blocked by a full channel
+func TestWithEventChannel(t *testing.T) {
+	ch := make(chan Event, 1)
+	cfg := &execConfig{}
+	WithEventChannel(ch)(cfg)
+
+	em := &eventEmitter{id: "test", handler: cfg.eventHandler}
+	em.emit(Event{Type: EventPreparing})
+	em.emit(Event{Type: EventStarted})
+
+	ev := <-ch
+	if ev.Type != EventPreparing || ev.ExecutionID != "test" {
+		t.Errorf("Unexpected event: %+v", ev)
+	}
+}
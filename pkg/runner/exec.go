@@ -4,7 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
@@ -17,13 +17,70 @@ import (
 
 // Exec implements the Runner interface for direct command execution
 type Exec struct {
-	logger  *common.Logger
+	logger  Logger
 	options ExecOptions
 }
 
 // ExecOptions is the options for the Exec runner
 type ExecOptions struct {
 	Shell string `json:"shell"`
+
+	// AllowWriteFolders are the folders kept writable with read_only_root
+	AllowWriteFolders []string `json:"allow_write_folders"`
+
+	// Umask and file mode policy
+	FileModeOptions
+
+	// Core dump policy
+	CoreDumpOptions
+
+	// Folders searched for executables
+	PathOptions
+
+	// Clock and locale settings
+	DeterminismOptions
+
+	// Variables of the commands read from .env files
+	EnvFileOptions
+
+	// Experimental features
+	ExperimentalOptions
+
+	// CA certificates trusted by the command
+	CABundleOptions
+
+	// Access to the credential agents of the host
+	AgentOptions
+
+	// Access to the display server of the host
+	DisplayOptions
+
+	// Namespaces of the command (Linux only)
+	NamespaceOptions
+
+	// Ephemeral user of the commands (Linux only)
+	EphemeralUIDOptions
+
+	// System calls of the commands (Linux only)
+	SeccompOptions
+
+	// Access token of the command (Windows only)
+	RestrictedTokenOptions
+
+	// Architecture of the command (macOS only)
+	ArchitectureOptions
+
+	// LoginSession runs the commands in a new login session of another user (Linux only)
+	LoginSession *LoginSessionOptions `json:"login_session"`
+
+	// Timeout of the commands
+	TimeoutOptions
+
+	// Limits of the output of the commands
+	OutputLimitOptions
+
+	// Command run before the commands
+	PreflightOptions
 }
 
 // NewExecOptions creates a new ExecOptions from Options
@@ -39,15 +96,73 @@ func NewExecOptions(options Options) (ExecOptions, error) {
 
 // NewExec creates a new Exec runner with the provided logger.
 // If logger is nil, a default logger is created.
-func NewExec(options Options, logger *common.Logger) (*Exec, error) {
-	if logger == nil {
-		logger = common.GetLogger()
-	}
+func NewExec(options Options, logger Logger) (*Exec, error) {
+	logger = defaultLogger(logger)
 
 	execOptions, err := NewExecOptions(options)
 	if err != nil {
 		return nil, err
 	}
+	if err := execOptions.validate(); err != nil {
+		return nil, err
+	}
+	if err := execOptions.resolveFakeTime(); err != nil {
+		return nil, err
+	}
+	if err := execOptions.validateCABundle(); err != nil {
+		return nil, err
+	}
+	if err := execOptions.validateTimeouts(); err != nil {
+		return nil, err
+	}
+	if err := execOptions.loadEnvFiles(); err != nil {
+		return nil, err
+	}
+	if err := execOptions.validateOutputLimits(); err != nil {
+		return nil, err
+	}
+	if err := execOptions.validatePreflight(); err != nil {
+		return nil, err
+	}
+	if err := execOptions.validateNamespaces(); err != nil {
+		return nil, err
+	}
+	if err := execOptions.validateEphemeralUID(execOptions.NamespaceOptions); err != nil {
+		return nil, err
+	}
+	if execOptions.EphemeralUID && execOptions.LoginSession != nil {
+		return nil, fmt.Errorf("ephemeral_uid cannot be combined with login_session: %w", ErrNotSupported)
+	}
+	if err := execOptions.loadSeccompProfile(); err != nil {
+		return nil, err
+	}
+	if err := execOptions.validateSeccompFilter(); err != nil {
+		return nil, err
+	}
+	if execOptions.SeccompProfile != "" && execOptions.LoginSession != nil {
+		return nil, fmt.Errorf("seccomp_profile cannot be combined with login_session: %w", ErrNotSupported)
+	}
+	if err := execOptions.validateCoreDumps(); err != nil {
+		return nil, err
+	}
+	if err := execOptions.validateExtraPath(); err != nil {
+		return nil, err
+	}
+	if err := execOptions.validatePinnedExecutables(); err != nil {
+		return nil, err
+	}
+	if err := execOptions.validateRestrictedToken(); err != nil {
+		return nil, err
+	}
+	if err := execOptions.validateArchitecture(); err != nil {
+		return nil, err
+	}
+	if err := execOptions.LoginSession.validate(); err != nil {
+		return nil, err
+	}
+	if execOptions.LoginSession != nil && execOptions.hostname() != "" {
+		return nil, fmt.Errorf("private_uts cannot be used with login sessions: %w", ErrNotSupported)
+	}
 
 	return &Exec{
 		logger:  logger,
@@ -65,6 +180,23 @@ func (r *Exec) Run(ctx context.Context, shell string,
 	env []string, params map[string]interface{},
 	tmpfile bool,
 ) (string, error) {
+	return r.options.runWithTimeout(ctx, func(ctx context.Context) (string, error) {
+		return r.run(ctx, shell, command, env, params, tmpfile)
+	})
+}
+
+// run is Run, with the context bounded by the timeout options
+func (r *Exec) run(ctx context.Context, shell string,
+	command string,
+	env []string, params map[string]interface{},
+	tmpfile bool,
+) (string, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+	env = r.options.pathEnv(env)
+	env = r.options.caBundleEnv(env, r.options.CABundle)
+
 	// Check if context is done
 	select {
 	case <-ctx.Done():
@@ -73,6 +205,20 @@ func (r *Exec) Run(ctx context.Context, shell string,
 		// Continue execution
 	}
 
+	if err := r.options.runPreflight(ctx, logger, getShell(r.options.Shell), r.start, params); err != nil {
+		return "", err
+	}
+
+	toolsDir, removeTools, err := r.options.linkHermeticTools(logger)
+	if err != nil {
+		return "", err
+	}
+	defer removeTools()
+	env = hermeticEnv(env, toolsDir)
+	if err := r.options.verifyPinnedExecutables(logger, command); err != nil {
+		return "", err
+	}
+
 	var execCmd *exec.Cmd
 	var tmpDir string
 
@@ -85,30 +231,30 @@ func (r *Exec) Run(ctx context.Context, shell string,
 	if runtime.GOOS == "windows" && isWindowsShell(shellLower) {
 		// Use direct execution for Windows shells to avoid temp file issues
 		shellPath, args := getShellCommandArgs(configShell, command)
-		execCmd = exec.CommandContext(ctx, shellPath, args...)
-		r.logger.Debug("Created direct command for Windows: %s with args %v", shellPath, args)
-	} else if isSingleExecutableCommand(command) {
-		r.logger.Debug("Optimization: running single executable command directly: %s", command)
-		execCmd = exec.CommandContext(ctx, command)
+		execCmd = commandContext(ctx, shellPath, args...)
+		logger.Debug("Created direct command for Windows: %s with args %v", shellPath, args)
+	} else if r.options.directCommand(command) {
+		logger.Debug("Optimization: running single executable command directly: %s", command)
+		execCmd = commandContext(ctx, r.options.lookPath(command))
 		if len(env) > 0 {
-			r.logger.Debug("Adding %d environment variables to command", len(env))
+			logger.Debug("Adding %d environment variables to command", len(env))
 			for _, e := range env {
-				r.logger.Debug("... adding environment variable: %s", e)
+				categoryLogger(logger, LogCategoryEnvironment).Debug("... adding environment variable: %s", e)
 			}
 			execCmd.Env = append(os.Environ(), env...)
 		}
-		r.logger.Debug("Created command: %s", command)
+		logger.Debug("Created command: %s", command)
 	} else if tmpfile {
 		// Create a temporary file for the command
 		var err error
 		tmpDir, err = os.MkdirTemp("", "mcpshell")
 		if err != nil {
-			r.logger.Debug("Failed to create temp directory: %v", err)
+			logger.Debug("Failed to create temp directory: %v", err)
 			return "", err
 		}
 		defer func() {
 			if err := os.RemoveAll(tmpDir); err != nil {
-				r.logger.Debug("Failed to remove temporary directory: %v", err)
+				logger.Debug("Failed to remove temporary directory: %v", err)
 			}
 		}()
 
@@ -123,54 +269,91 @@ func (r *Exec) Run(ctx context.Context, shell string,
 		tmpFile := filepath.Join(tmpDir, scriptFileName)
 		err = os.WriteFile(tmpFile, []byte(scriptContent.String()), 0o700)
 		if err != nil {
-			r.logger.Debug("Failed to write temporary file: %v", err)
+			logger.Debug("Failed to write temporary file: %v", err)
 			return "", err
 		}
+		if r.options.LoginSession != nil {
+			if err := r.options.LoginSession.grant(tmpDir, tmpFile); err != nil {
+				return "", err
+			}
+		}
 
-		r.logger.Debug("Created temporary script file at: %s", tmpFile)
+		logger.Debug("Created temporary script file at: %s", tmpFile)
 
 		// Set up the command
-		r.logger.Debug("Using shell: %s", configShell)
+		logger.Debug("Using shell: %s", configShell)
 
 		// Create the command to execute the script file
-		execCmd = exec.CommandContext(ctx, configShell, tmpFile)
-		r.logger.Debug("Created command: %s %s", configShell, tmpFile)
+		execCmd = commandContext(ctx, configShell, tmpFile)
+		logger.Debug("Created command: %s %s", configShell, tmpFile)
 	} else {
 		// Execute the command directly without a temporary file (Unix-style)
-		r.logger.Debug("Using shell: %s", configShell)
+		logger.Debug("Using shell: %s", configShell)
 
 		// Get the appropriate command arguments for this shell
 		shellPath, args := getShellCommandArgs(configShell, command)
-		execCmd = exec.CommandContext(ctx, shellPath, args...)
-		r.logger.Debug("Created command: %s with args %v", shellPath, args)
+		execCmd = commandContext(ctx, shellPath, args...)
+		logger.Debug("Created command: %s with args %v", shellPath, args)
 	}
 
 	// Set environment variables if provided
 	if len(env) > 0 {
-		r.logger.Debug("Adding %d environment variables to command", len(env))
+		logger.Debug("Adding %d environment variables to command", len(env))
 		for _, e := range env {
-			r.logger.Debug("... adding environment variable: %s", e)
+			categoryLogger(logger, LogCategoryEnvironment).Debug("... adding environment variable: %s", e)
 		}
 		execCmd.Env = append(os.Environ(), env...)
 	}
+	execCmd.Env = r.options.agentEnv(execCmd.Env)
+	execCmd.Env = r.options.scrubDisplayEnv(execCmd.Env)
+
+	r.options.applyArchitecture(logger, execCmd)
+	applyUmask(logger, execCmd, r.options.Umask)
+	collectCores := r.options.applyCoreDumps(logger, execCmd)
+	defer collectCores()
+
+	if r.options.LoginSession != nil {
+		unit, err := r.options.LoginSession.wrap(ctx, logger, execCmd, r.options.scrubDisplayEnv(r.options.scrubAgentEnv(env)),
+			r.namespaceSetup(params))
+		if err != nil {
+			return "", err
+		}
+		defer stopLoginSession(logger, unit)
+	} else {
+		if err := r.options.applySeccomp(logger, execCmd); err != nil {
+			return "", err
+		}
+		if err := r.options.applyEphemeralUID(logger, execCmd, false); err != nil {
+			return "", err
+		}
+		if err := isolateNamespaces(execCmd, r.namespaceSetup(params)); err != nil {
+			return "", err
+		}
+	}
+	releaseToken, err := r.options.applyRestrictedToken(logger, execCmd)
+	if err != nil {
+		return "", err
+	}
+	defer releaseToken()
 
 	// Capture output
 	var stdout, stderr bytes.Buffer
-	execCmd.Stdout = &stdout
-	execCmd.Stderr = &stderr
+	execCmd.Stdout, execCmd.Stderr = captureRunOutput(ctx, &stdout, &stderr)
+	var truncated func() error
+	execCmd.Stdout, execCmd.Stderr, truncated = r.options.limitOutput(ctx, execCmd.Stdout, execCmd.Stderr)
 
 	// Run the command
-	r.logger.Debug("Executing command")
+	logger.Debug("Executing command")
 
-	err := execCmd.Run()
+	err = execCmd.Run()
 	if err != nil {
 		// If there's error output, include it in the error
 		if stderr.Len() > 0 {
 			errMsg := strings.TrimSpace(stderr.String())
-			r.logger.Debug("Command failed with stderr: %s", errMsg)
-			return "", errors.New(errMsg)
+			logger.Debug("Command failed with stderr: %s", errMsg)
+			return "", newCommandError(errMsg, err)
 		}
-		r.logger.Debug("Command failed with error: %v", err)
+		logger.Debug("Command failed with error: %v", err)
 		return "", err
 	}
 
@@ -187,21 +370,27 @@ func (r *Exec) Run(ctx context.Context, shell string,
 	} else if runtime.GOOS == "windows" && strings.Contains(output, "Microsoft Windows [版本") {
 		// If the output contains Windows version info, the command might not have executed properly
 		// This indicates the batch file might not have been set up properly to capture command output
-		r.logger.Debug("Detected Windows command prompt output, checking for real command output")
+		logger.Debug("Detected Windows command prompt output, checking for real command output")
 		// We'll still return what we captured, but this suggests the command didn't execute as expected
 	}
 
 	// Trim the output but preserve meaningful content
 	output = strings.TrimSpace(output)
 
-	r.logger.Debug("Command executed successfully, output length: %d bytes", len(output))
+	logger.Debug("Command executed successfully, output length: %d bytes", len(output))
 	if stderr.Len() > 0 {
-		r.logger.Debug("Command generated stderr (but no error): '%s'", strings.TrimSpace(stderrStr))
+		logger.Debug("Command generated stderr (but no error): '%s'", strings.TrimSpace(stderrStr))
 	}
-	r.logger.Debug("Full output captured: '%s'", output)
+	logger.Debug("Full output captured: '%s'", output)
 
 	// Return the output
-	return output, nil
+	return output, truncated()
+}
+
+// RunEx executes a command like Run, returning its exit code and both
+// output streams. It implements the Runner interface.
+func (r *Exec) RunEx(ctx context.Context, req RunRequest) (*RunResult, error) {
+	return runEx(ctx, r, req)
 }
 
 // RunWithPipes executes a command with access to stdin/stdout/stderr pipes.
@@ -220,92 +409,144 @@ func (r *Exec) RunWithPipes(ctx context.Context, cmd string, args []string, env
 	wait func() error,
 	err error,
 ) {
+	e, err := r.start(ctx, cmd, args, env, params)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return e.Stdin, e.Stdout, e.Stderr, e.Wait, nil
+}
+
+// start executes a command and returns an execution handle for it.
+// It is used by RunWithPipes and Start.
+func (r *Exec) start(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	return r.options.startWithTimeout(ctx, func(ctx context.Context) (*Execution, error) {
+		return r.startCommand(ctx, cmd, args, env, params)
+	})
+}
+
+// startCommand is start, with the context bounded by the timeout options
+func (r *Exec) startCommand(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+	env = r.options.pathEnv(env)
+	env = r.options.caBundleEnv(env, r.options.CABundle)
+
 	// Check if context is already done
 	select {
 	case <-ctx.Done():
-		return nil, nil, nil, nil, ctx.Err()
+		return nil, ctx.Err()
 	default:
 		// Continue execution
 	}
 
-	r.logger.Debug("RunWithPipes: executing command: %s with args: %v", cmd, args)
+	if err := r.options.runPreflight(ctx, logger, getShell(r.options.Shell), r.start, params); err != nil {
+		return nil, err
+	}
+
+	// the hermetic tools are removed once the command completes (see startProcess)
+	toolsDir, removeTools, err := r.options.linkHermeticTools(logger)
+	if err != nil {
+		return nil, err
+	}
+	started := false
+	defer func() {
+		if !started {
+			removeTools()
+		}
+	}()
+	env = hermeticEnv(env, toolsDir)
+	if cmd, err = r.options.hermeticCommand(ctx, toolsDir, cmd); err != nil {
+		return nil, err
+	}
+	if err := r.options.verifyPinnedExecutables(logger, append([]string{cmd}, args...)...); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("RunWithPipes: executing command: %s with args: %v", cmd, args)
 
 	// Create the command
-	execCmd := exec.CommandContext(ctx, cmd, args...)
+	execCmd := commandContext(ctx, cmd, args...)
 
 	// Set environment variables if provided
 	if len(env) > 0 {
-		r.logger.Debug("Adding %d environment variables to command", len(env))
+		logger.Debug("Adding %d environment variables to command", len(env))
 		for _, e := range env {
-			r.logger.Debug("... adding environment variable: %s", e)
+			categoryLogger(logger, LogCategoryEnvironment).Debug("... adding environment variable: %s", e)
 		}
 		execCmd.Env = append(os.Environ(), env...)
 	}
-
-	// Create pipes for stdin, stdout, and stderr
-	stdinPipe, err := execCmd.StdinPipe()
-	if err != nil {
-		r.logger.Debug("Failed to create stdin pipe: %v", err)
-		return nil, nil, nil, nil, errors.New("failed to create stdin pipe: " + err.Error())
-	}
-
-	stdoutPipe, err := execCmd.StdoutPipe()
-	if err != nil {
-		if closeErr := stdinPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdin pipe: %v", closeErr)
-		}
-		r.logger.Debug("Failed to create stdout pipe: %v", err)
-		return nil, nil, nil, nil, errors.New("failed to create stdout pipe: " + err.Error())
-	}
-
-	stderrPipe, err := execCmd.StderrPipe()
-	if err != nil {
-		if closeErr := stdinPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdin pipe: %v", closeErr)
+	execCmd.Env = r.options.agentEnv(execCmd.Env)
+	execCmd.Env = r.options.scrubDisplayEnv(execCmd.Env)
+	execCmd.ExtraFiles = extraFilesFrom(ctx)
+
+	r.options.applyArchitecture(logger, execCmd)
+	applyUmask(logger, execCmd, r.options.Umask)
+	collectCores := r.options.applyCoreDumps(logger, execCmd)
+
+	if r.options.LoginSession != nil {
+		// the network and namespaces of the session are isolated by systemd
+		unit, err := r.options.LoginSession.wrap(ctx, logger, execCmd, r.options.scrubDisplayEnv(r.options.scrubAgentEnv(env)),
+			r.namespaceSetup(params))
+		if err != nil {
+			return nil, err
 		}
-		if closeErr := stdoutPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdout pipe: %v", closeErr)
+		started = true
+		e, err := startProcess(logger, execCmd, func() {
+			stopLoginSession(logger, unit)
+			collectCores()
+			removeTools()
+		})
+		if err != nil {
+			return nil, err
 		}
-		r.logger.Debug("Failed to create stderr pipe: %v", err)
-		return nil, nil, nil, nil, errors.New("failed to create stderr pipe: " + err.Error())
+		e.backend = &loginSessionBackend{unit: unit}
+		return e, nil
 	}
 
-	// Start the command
-	r.logger.Debug("Starting command with pipes")
-	if err := execCmd.Start(); err != nil {
-		if closeErr := stdinPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdin pipe: %v", closeErr)
-		}
-		if closeErr := stdoutPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdout pipe: %v", closeErr)
-		}
-		if closeErr := stderrPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stderr pipe: %v", closeErr)
-		}
-		r.logger.Debug("Failed to start command: %v", err)
-		return nil, nil, nil, nil, errors.New("failed to start command: " + err.Error())
+	if err := r.options.applySeccomp(logger, execCmd); err != nil {
+		return nil, err
 	}
-
-	r.logger.Debug("Command started successfully with PID: %d", execCmd.Process.Pid)
-
-	// Create wait function that waits for the command to complete
-	waitFunc := func() error {
-		r.logger.Debug("Waiting for command to complete")
-		err := execCmd.Wait()
-		if err != nil {
-			r.logger.Debug("Command completed with error: %v", err)
-			return err
+	if err := r.options.applyEphemeralUID(logger, execCmd, loopbackNetworkFrom(ctx)); err != nil {
+		return nil, err
+	}
+	if loopbackNetworkFrom(ctx) {
+		if err := isolateLoopback(execCmd); err != nil {
+			return nil, err
 		}
-		r.logger.Debug("Command completed successfully")
-		return nil
+	}
+	if err := isolateNamespaces(execCmd, r.namespaceSetup(params)); err != nil {
+		return nil, err
+	}
+	releaseToken, err := r.options.applyRestrictedToken(logger, execCmd)
+	if err != nil {
+		return nil, err
 	}
 
-	return stdinPipe, stdoutPipe, stderrPipe, waitFunc, nil
+	started = true
+	return startProcess(logger, execCmd, func() {
+		releaseToken()
+		collectCores()
+		removeTools()
+	})
+}
+
+// namespaceSetup returns the namespaces of the command, with template
+// variables in the writable folders replaced with params
+func (r *Exec) namespaceSetup(params map[string]interface{}) namespaceSetup {
+	writable := common.ProcessTemplateListFlexible(r.options.AllowWriteFolders, params)
+	return r.options.namespaceSetup(true, writable)
 }
 
 // CheckImplicitRequirements checks if the runner meets its implicit requirements.
-// Exec runner has no special requirements.
+// Exec runner has no special requirements, but login sessions need systemd,
+// and ephemeral users need their IDs to be mapped.
 func (r *Exec) CheckImplicitRequirements() error {
-	// No special requirements for the basic exec runner
-	return nil
+	if r.options.LoginSession != nil {
+		return checkLoginSessionRequirements()
+	}
+	if err := r.options.checkEphemeralUID(); err != nil {
+		return err
+	}
+	return r.options.checkArchitecture()
 }
@@ -0,0 +1,667 @@
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// ErrNotSupported is returned by execution handle operations the backend
+// of the execution cannot perform.
+var ErrNotSupported = errors.New("operation not supported by this runner")
+
+// Execution is a handle to a command started with Start.
+//
+// It exposes the same pipes as RunWithPipes, plus operations on the running
+// command. Wait must always be called to release the resources associated
+// with the execution, exactly as the wait function returned by RunWithPipes.
+type Execution struct {
+	// ID uniquely identifies the execution
+	ID string
+
+	// Stdin is connected to the standard input of the command. It must be
+	// closed when done writing.
+	Stdin io.WriteCloser
+
+	// Stdout is connected to the standard output of the command
+	Stdout io.ReadCloser
+
+	// Stderr is connected to the standard error of the command
+	Stderr io.ReadCloser
+
+	logger  Logger
+	backend executionBackend
+	wait    func() error
+
+	// exitHooks are run once the command has completed, before release
+	exitHooks []func()
+	// release frees the resources of the execution (e.g. removes its container)
+	release func()
+
+	mu       sync.Mutex
+	paused   bool
+	waitOnce sync.Once
+	waitErr  error
+
+	fileChanges    []FileChange
+	fileChangesErr error
+
+	artifacts    []Artifact
+	artifactsErr error
+
+	ports []PublishedPort
+
+	readiness *readiness
+
+	transcript    []byte
+	transcriptErr error
+
+	published    []PublishedObject
+	publishedErr error
+
+	detector *detectionEngine
+
+	prompts *promptWatcher
+
+	// exitCodes are the exit codes reserved by the backend (see ExitStatus)
+	exitCodes exitCodeTable
+}
+
+// executionBackend implements the operations on a running command that
+// depend on how the runner started it (a local process, a container...)
+type executionBackend interface {
+	// pause suspends the command and all its children
+	pause() error
+
+	// resume continues a command previously suspended with pause
+	resume() error
+
+	// signal sends a signal to the command and all its children
+	signal(sig syscall.Signal) error
+}
+
+// ExecOption configures an execution started with Start
+type ExecOption func(*execConfig)
+
+// execConfig is the per-execution configuration built from the ExecOptions
+type execConfig struct {
+	artifacts    []ArtifactSpec
+	artifactsDir string
+
+	inputFiles map[string][]byte
+	inputPaths map[string]string
+
+	workspace *Workspace
+
+	tempDirs *TempDirProvider
+
+	detectorRules []DetectorRule
+
+	prompts *PromptApprovalPolicy
+
+	eventHandler EventHandler
+
+	freePorts []string
+
+	readiness *ReadinessProbe
+
+	shutdown *ShutdownAction
+
+	transcript *TranscriptOptions
+
+	publisher ArtifactPublisher
+
+	notifier *WebhookNotifier
+
+	extraFiles []*os.File
+
+	loopbackNetwork bool
+
+	logLevel common.LogLevel
+
+	reproBundle string
+}
+
+// WithLogLevel raises the logging level of the runner for this execution
+// only, tagging the lines with the execution ID. It is the per-call
+// equivalent of common.WithLogLevel.
+func WithLogLevel(level common.LogLevel) ExecOption {
+	return func(c *execConfig) {
+		c.logLevel = level
+	}
+}
+
+// starter is implemented by runners that can return an execution handle
+// directly, with support for the operations of their backend
+type starter interface {
+	start(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error)
+}
+
+// Start executes a command with the given runner and returns a handle to it.
+//
+// Start accepts the same parameters as RunWithPipes and applies the same
+// restrictions. Runners that cannot provide a richer handle are wrapped:
+// their executions support pipes and Wait, while backend operations such as
+// Pause return ErrNotSupported.
+//
+// When the runner is configured with report_file_changes, the files changed
+// in its writable folders are available from FileChanges once the execution
+// has completed.
+func Start(ctx context.Context, r Runner, cmd string, args []string, env []string, params map[string]interface{},
+	opts ...ExecOption,
+) (*Execution, error) {
+	cfg := &execConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.prompts != nil {
+		if err := cfg.prompts.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	id := newExecutionID()
+	if cfg.logLevel != common.LogLevelNone {
+		ctx = common.WithLogLevel(ctx, cfg.logLevel)
+	}
+	if common.LogIDFromContext(ctx) == "" {
+		// nested executions (e.g. the sandbox of the Python runner) keep the outer ID
+		ctx = common.WithLogID(ctx, id)
+	}
+
+	var repro *reproRequest
+	if cfg.reproBundle != "" {
+		repro = &reproRequest{path: cfg.reproBundle, runner: r, id: id, cmd: cmd, args: args, env: env, params: params,
+			rec: &reproRecorder{}}
+		ctx = withReproRecorder(ctx, repro.rec)
+	}
+
+	var emitter *eventEmitter
+	if cfg.eventHandler != nil {
+		emitter = &eventEmitter{id: id, handler: cfg.eventHandler}
+		ctx = withEventEmitter(ctx, emitter)
+	}
+	emitter.emit(Event{Type: EventPreparing})
+
+	if len(cfg.extraFiles) > 0 {
+		ctx = withExtraFiles(ctx, cfg.extraFiles)
+		env = append(append([]string{}, env...), extraFilesEnv(len(cfg.extraFiles)))
+	}
+	if cfg.loopbackNetwork {
+		ctx = withLoopbackNetwork(ctx)
+	}
+
+	if cfg.workspace != nil {
+		if err := cfg.workspace.check(); err != nil {
+			return nil, err
+		}
+		// The runners allow writing the directory in params (or mount it)
+		params = withParam(params, WorkspaceDirParam, cfg.workspace.Dir())
+		env = append(append([]string{}, env...), WorkspaceDirEnv+"="+cfg.workspace.Dir())
+	}
+
+	var stagingDir string
+	if cfg.hasInputs() {
+		dir, err := stageInputs(cfg)
+		if err != nil {
+			return nil, err
+		}
+		stagingDir = dir
+
+		// The runners allow reading the directory in params (or mount it)
+		params = withParam(params, InputsDirParam, dir)
+		env = append(append([]string{}, env...), InputsDirEnv+"="+dir)
+	}
+	var scratch *TempDir
+	removeDirs := func() {
+		if stagingDir != "" {
+			if err := os.RemoveAll(stagingDir); err != nil {
+				common.GetLogger().Debug("Warning: failed to remove staging directory %s: %v", stagingDir, err)
+			}
+		}
+		if scratch != nil {
+			if err := scratch.Remove(); err != nil {
+				common.GetLogger().Debug("Warning: %v", err)
+			}
+		}
+	}
+
+	if cfg.tempDirs != nil {
+		var err error
+		if scratch, err = cfg.tempDirs.Create(ctx); err != nil {
+			removeDirs()
+			return nil, err
+		}
+		// The runners allow writing the directory in params (or mount it), and
+		// TMPDIR keeps the temporary files of the command in the capped volume
+		params = withParam(params, ScratchDirParam, scratch.Path())
+		env = append(append([]string{}, env...), ScratchDirEnv+"="+scratch.Path(), "TMPDIR="+scratch.Path())
+	}
+
+	var freePorts []PublishedPort
+	if len(cfg.freePorts) > 0 {
+		var err error
+		params, freePorts, err = allocateFreePorts(cfg, params)
+		if err != nil {
+			removeDirs()
+			return nil, err
+		}
+		// the ports are only known now, so the arguments refer to them with templates
+		args = common.ProcessTemplateListFlexible(args, params)
+	}
+
+	var prober *readinessProber
+	if cfg.readiness != nil {
+		var err error
+		if prober, err = newReadinessProber(cfg.readiness, params); err != nil {
+			removeDirs()
+			return nil, err
+		}
+	}
+
+	// the context of the caller, as ctx is detached from it with WithShutdown
+	callerCtx := ctx
+	var watchShutdown func(e *Execution)
+	killCommand := func() {}
+	if cfg.shutdown != nil {
+		if err := cfg.shutdown.validate(); err != nil {
+			removeDirs()
+			return nil, err
+		}
+		// the command is killed by the shutdown watcher instead of by ctx
+		ctx, watchShutdown, killCommand = gracefulContext(ctx, cfg.shutdown, params)
+	}
+
+	var recorder *transcriptRecorder
+	if cfg.transcript != nil {
+		var err error
+		if recorder, err = newTranscriptRecorder(cfg.transcript); err != nil {
+			killCommand()
+			removeDirs()
+			return nil, err
+		}
+	}
+
+	watcher, err := watchFileChanges(r, params, common.GetLogger())
+	if err != nil {
+		if recorder != nil {
+			_, _ = recorder.close()
+		}
+		killCommand()
+		removeDirs()
+		return nil, fmt.Errorf("failed to watch file changes: %w", err)
+	}
+
+	e, err := startExecution(ctx, r, cmd, args, env, params)
+	if err != nil {
+		if repro != nil {
+			repro.writeLogged(err, false)
+		}
+		if watcher != nil {
+			_, _ = watcher.Stop()
+		}
+		if recorder != nil {
+			_, _ = recorder.close()
+		}
+		killCommand()
+		removeDirs()
+		return nil, err
+	}
+	e.ID = id
+	e.ports = append(e.ports, freePorts...)
+	e.exitCodes = exitCodesOf(r)
+
+	if emitter != nil {
+		emitter.emitStarted(e)
+		e.Stdout = &chunkReader{ReadCloser: e.Stdout, stream: "stdout", emitter: emitter}
+		e.Stderr = &chunkReader{ReadCloser: e.Stderr, stream: "stderr", emitter: emitter}
+
+		wait := e.wait
+		e.wait = func() error {
+			err := wait()
+			emitter.emit(Event{Type: EventExited, ExitCode: exitCode(err), Err: err})
+			return err
+		}
+	}
+
+	if stagingDir != "" || scratch != nil {
+		release := e.release
+		e.release = func() {
+			if release != nil {
+				release()
+			}
+			removeDirs()
+		}
+	}
+
+	if prober != nil {
+		prober.start(ctx, e)
+	}
+	if watchShutdown != nil {
+		watchShutdown(e)
+	}
+	if recorder != nil {
+		recorder.attach(e)
+	}
+	if len(cfg.detectorRules) > 0 {
+		attachDetectors(e, cfg.detectorRules, emitter)
+	}
+	if cfg.prompts != nil {
+		runnerType, _ := runnerPolicy(r)
+		attachPromptApproval(callerCtx, e, cfg.prompts, runnerType, commandLine(cmd, args), emitter)
+	}
+
+	if watcher != nil {
+		e.exitHooks = append(e.exitHooks, func() {
+			e.fileChanges, e.fileChangesErr = watcher.Stop()
+		})
+	}
+	if len(cfg.artifacts) > 0 {
+		e.exitHooks = append(e.exitHooks, func() {
+			e.artifacts, e.artifactsErr = collectArtifacts(e, cfg)
+		})
+	}
+	if cfg.notifier != nil {
+		started := time.Now()
+		fingerprint := Fingerprint(r)
+		wait := e.wait
+		e.wait = func() error {
+			err := wait()
+			cfg.notifier.notifyAsync(summarize(callerCtx, e, err, fingerprint, hostInfoOf(r), started, time.Now()))
+			return err
+		}
+	}
+	if repro != nil {
+		e.exitHooks = append(e.exitHooks, func() {
+			repro.writeLogged(e.waitErr, true)
+		})
+	}
+	if cfg.publisher != nil {
+		spool, err := newOutputSpool(e)
+		if err != nil {
+			e.publishedErr = err
+		} else {
+			e.exitHooks = append(e.exitHooks, func() {
+				defer spool.remove()
+				e.published, e.publishedErr = publishExecution(context.WithoutCancel(ctx), e, cfg.publisher, spool)
+			})
+		}
+	}
+	return e, nil
+}
+
+// startExecution starts a command with the runner, wrapping the pipes
+// of runners that cannot provide an execution handle
+func startExecution(ctx context.Context, r Runner, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	if s, ok := r.(starter); ok {
+		return s.start(ctx, cmd, args, env, params)
+	}
+	if err := checkNoExtraFiles(ctx, "this runner's"); err != nil {
+		return nil, err
+	}
+	if loopbackNetworkFrom(ctx) {
+		return nil, fmt.Errorf("this runner cannot isolate the network: %w", ErrNotSupported)
+	}
+
+	stdin, stdout, stderr, wait, err := r.RunWithPipes(ctx, cmd, args, env, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return newExecution(common.GetLogger(), stdin, stdout, stderr, wait, nil), nil
+}
+
+// newExecution creates an execution handle with a new ID
+func newExecution(logger Logger, stdin io.WriteCloser, stdout, stderr io.ReadCloser,
+	wait func() error, backend executionBackend,
+) *Execution {
+	return &Execution{
+		ID:      newExecutionID(),
+		Stdin:   stdin,
+		Stdout:  stdout,
+		Stderr:  stderr,
+		logger:  logger,
+		backend: backend,
+		wait:    wait,
+	}
+}
+
+// newExecutionID returns a random identifier for an execution
+func newExecutionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand never fails on supported platforms
+		panic(fmt.Sprintf("failed to generate execution ID: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// Wait waits for the command to complete and releases its resources.
+// It can be called several times: later calls return the same result.
+func (e *Execution) Wait() error {
+	e.waitOnce.Do(func() {
+		e.waitErr = e.wait()
+		for _, hook := range e.exitHooks {
+			hook()
+		}
+		if e.release != nil {
+			e.release()
+		}
+	})
+	return e.waitErr
+}
+
+// FileChanges returns the files created, modified and deleted in the writable
+// folders of the runner during the execution, when the runner is configured
+// with report_file_changes. It must be called after Wait.
+//
+// ErrFileChangesOverflow is returned (along with the changes recorded) when
+// the list is incomplete.
+func (e *Execution) FileChanges() ([]FileChange, error) {
+	return e.fileChanges, e.fileChangesErr
+}
+
+// Pause suspends the command and all its children.
+//
+// Local processes are stopped with SIGSTOP (sent to the whole process group),
+// and containers are frozen with the cgroup freezer (`docker pause`). Pausing
+// an already paused execution is a no-op.
+func (e *Execution) Pause() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.backend == nil {
+		return ErrNotSupported
+	}
+	if e.paused {
+		return nil
+	}
+
+	e.logger.Debug("Pausing execution %s", e.ID)
+	if err := e.backend.pause(); err != nil {
+		return fmt.Errorf("failed to pause execution %s: %w", e.ID, err)
+	}
+	e.paused = true
+	return nil
+}
+
+// Resume continues an execution previously suspended with Pause.
+// Resuming an execution that is not paused is a no-op.
+func (e *Execution) Resume() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.backend == nil {
+		return ErrNotSupported
+	}
+	if !e.paused {
+		return nil
+	}
+
+	e.logger.Debug("Resuming execution %s", e.ID)
+	if err := e.backend.resume(); err != nil {
+		return fmt.Errorf("failed to resume execution %s: %w", e.ID, err)
+	}
+	e.paused = false
+	return nil
+}
+
+// Paused returns whether the execution is currently paused
+func (e *Execution) Paused() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.paused
+}
+
+// startProcess creates the pipes of a local command, starts it in its own
+// process group and returns an execution handle for it.
+//
+// cleanup (which can be nil) is called once the command has completed and
+// the execution has been released, or immediately if the command cannot be
+// started.
+func startProcess(logger Logger, execCmd *exec.Cmd, cleanup func()) (*Execution, error) {
+	runCleanup := func() {
+		if cleanup != nil {
+			cleanup()
+		}
+	}
+
+	// Run the command in its own process group so it can be paused (or signalled) with all its children
+	setProcessGroup(execCmd)
+
+	// Create pipes for stdin, stdout, and stderr
+	stdinPipe, err := execCmd.StdinPipe()
+	if err != nil {
+		runCleanup()
+		logger.Debug("Failed to create stdin pipe: %v", err)
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+
+	stdoutPipe, err := execCmd.StdoutPipe()
+	if err != nil {
+		if closeErr := stdinPipe.Close(); closeErr != nil {
+			logger.Debug("Warning: failed to close stdin pipe: %v", closeErr)
+		}
+		runCleanup()
+		logger.Debug("Failed to create stdout pipe: %v", err)
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+
+	stderrPipe, err := execCmd.StderrPipe()
+	if err != nil {
+		if closeErr := stdinPipe.Close(); closeErr != nil {
+			logger.Debug("Warning: failed to close stdin pipe: %v", closeErr)
+		}
+		if closeErr := stdoutPipe.Close(); closeErr != nil {
+			logger.Debug("Warning: failed to close stdout pipe: %v", closeErr)
+		}
+		runCleanup()
+		logger.Debug("Failed to create stderr pipe: %v", err)
+		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+
+	// Start the command
+	logger.Debug("Starting command with pipes: %s", execCmd.String())
+	if err := execCmd.Start(); err != nil {
+		if closeErr := stdinPipe.Close(); closeErr != nil {
+			logger.Debug("Warning: failed to close stdin pipe: %v", closeErr)
+		}
+		if closeErr := stdoutPipe.Close(); closeErr != nil {
+			logger.Debug("Warning: failed to close stdout pipe: %v", closeErr)
+		}
+		if closeErr := stderrPipe.Close(); closeErr != nil {
+			logger.Debug("Warning: failed to close stderr pipe: %v", closeErr)
+		}
+		runCleanup()
+		logger.Debug("Failed to start command: %v", err)
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	logger.Debug("Command started successfully with PID: %d", execCmd.Process.Pid)
+
+	// Create wait function that waits for the command to complete
+	waitFunc := func() error {
+		logger.Debug("Waiting for command to complete")
+		err := execCmd.Wait()
+		if err != nil {
+			logger.Debug("Command completed with error: %v", err)
+			return err
+		}
+		logger.Debug("Command completed successfully")
+		return nil
+	}
+
+	e := newExecution(logger, stdinPipe, stdoutPipe, stderrPipe, waitFunc,
+		&processBackend{pid: execCmd.Process.Pid})
+	e.release = cleanup
+	return e, nil
+}
+
+// containerBackend implements execution operations for commands running in a container
+type containerBackend struct {
+	// engine is the container engine CLI (e.g. "docker")
+	engine string
+	// container is the name of the container
+	container string
+	// mounts are the bind mounts of the container, as "host:container[:options]"
+	mounts []string
+	// shell is the shell of the container and its arguments ("sh" by default)
+	shell []string
+	// checkpointed is set once the container has been stopped by a checkpoint
+	checkpointed atomic.Bool
+}
+
+func (b *containerBackend) pause() error {
+	return b.run("pause")
+}
+
+func (b *containerBackend) resume() error {
+	return b.run("unpause")
+}
+
+// signal sends the signal to all the processes of the container but its
+// init process, which only keeps the container alive
+func (b *containerBackend) signal(sig syscall.Signal) error {
+	script := fmt.Sprintf("kill -s %d -1", int(sig))
+	shell := b.shell
+	if len(shell) == 0 {
+		shell = []string{"sh"}
+	}
+	args := append(append([]string{"exec", b.container}, shell...), "-c", script)
+	if output, err := exec.Command(b.engine, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to send %v to container %s: %w: %s", sig, b.container, err, string(output))
+	}
+	return nil
+}
+
+// stop asks all the processes of the container to terminate, and stops it
+// after the grace period
+func (b *containerBackend) stop(grace time.Duration) error {
+	// containers without a shell are still stopped
+	signalErr := b.signal(syscall.SIGTERM)
+	if err := stopContainer(b.engine, b.container, grace); err != nil {
+		return err
+	}
+	return signalErr
+}
+
+// run runs a container engine subcommand on the container
+func (b *containerBackend) run(subcommand string, args ...string) error {
+	cmdArgs := append([]string{subcommand}, args...)
+	cmdArgs = append(cmdArgs, b.container)
+	if output, err := exec.Command(b.engine, cmdArgs...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s failed: %w: %s", b.engine, subcommand, err, string(output))
+	}
+	return nil
+}
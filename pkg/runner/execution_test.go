@@ -0,0 +1,157 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// TestStart_Exec tests that Start returns a working execution handle
+func TestStart_Exec(t *testing.T) {
+	logger, _ := common.NewLogger("", "", common.LogLevelDebug, false)
+	runner, err := NewExec(Options{}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+
+	e, err := Start(context.Background(), runner, "cat", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if e.ID == "" {
+		t.Errorf("Expected a non-empty execution ID")
+	}
+
+	if _, err := e.Stdin.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("Failed to write to stdin: %v", err)
+	}
+	_ = e.Stdin.Close()
+
+	output, err := io.ReadAll(e.Stdout)
+	if err != nil {
+		t.Fatalf("Failed to read from stdout: %v", err)
+	}
+	_, _ = io.ReadAll(e.Stderr)
+
+	if err := e.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	// Wait can be called several times
+	if err := e.Wait(); err != nil {
+		t.Fatalf("Second Wait failed: %v", err)
+	}
+
+	if string(output) != "hello\n" {
+		t.Errorf("Expected output %q, got %q", "hello\n", string(output))
+	}
+}
+
+// TestExecution_PauseResume tests that a paused command stops producing output
+func TestExecution_PauseResume(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Pause is not supported on Windows")
+	}
+
+	logger, _ := common.NewLogger("", "", common.LogLevelDebug, false)
+	runner, err := NewExec(Options{}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	e, err := Start(ctx, runner, "sh", []string{"-c", "while true; do echo tick; sleep 0.05; done"}, nil, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	lines := make(chan string, 1000)
+	go func() {
+		scanner := bufio.NewScanner(e.Stdout)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	// Wait for the command to produce some output
+	select {
+	case <-lines:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for output")
+	}
+
+	if err := e.Pause(); err != nil {
+		t.Fatalf("Pause failed: %v", err)
+	}
+	if !e.Paused() {
+		t.Errorf("Expected execution to be paused")
+	}
+
+	// Drain whatever was written before the pause took effect
+	time.Sleep(200 * time.Millisecond)
+	for len(lines) > 0 {
+		<-lines
+	}
+
+	select {
+	case line := <-lines:
+		t.Errorf("Unexpected output while paused: %q", line)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if err := e.Resume(); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+
+	select {
+	case <-lines:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for output after resume")
+	}
+
+	_ = e.Stdin.Close()
+	cancel()
+	_ = e.Wait()
+}
+
+// mockPipesRunner is a runner without support for execution handles
+type mockPipesRunner struct {
+	Runner
+}
+
+// TestStart_FallbackNotSupported tests that runners without a backend
+// return ErrNotSupported for backend operations
+func TestStart_FallbackNotSupported(t *testing.T) {
+	logger, _ := common.NewLogger("", "", common.LogLevelDebug, false)
+	runner, err := NewExec(Options{}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+
+	e, err := Start(context.Background(), mockPipesRunner{runner}, "cat", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	_ = e.Stdin.Close()
+	_, _ = io.ReadAll(e.Stdout)
+	_, _ = io.ReadAll(e.Stderr)
+
+	if err := e.Pause(); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported from Pause, got %v", err)
+	}
+	if err := e.Resume(); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported from Resume, got %v", err)
+	}
+
+	if err := e.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+//go:build !windows
+
+package runner
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes the command the leader of a new process group
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// processBackend implements execution operations for local processes
+type processBackend struct {
+	// pid is the process ID of the command, which is also its process group ID
+	pid int
+}
+
+func (b *processBackend) pause() error {
+	return syscall.Kill(-b.pid, syscall.SIGSTOP)
+}
+
+func (b *processBackend) resume() error {
+	return syscall.Kill(-b.pid, syscall.SIGCONT)
+}
+
+func (b *processBackend) signal(sig syscall.Signal) error {
+	return syscall.Kill(-b.pid, sig)
+}
+
+// killedBySeccomp returns whether the process was killed by SIGSYS, the
+// signal sent by seccomp filters for denied system calls
+func killedBySeccomp(exitErr *exec.ExitError) bool {
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGSYS
+}
+
+// exitSignal returns the number of the signal that killed the process, or 0
+func exitSignal(exitErr *exec.ExitError) int {
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return 0
+	}
+	return int(status.Signal())
+}
+
+// terminateProcess sends SIGTERM to a started command, or to its process
+// group when it is the leader of one
+func terminateProcess(cmd *exec.Cmd) error {
+	var err error
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Setpgid {
+		err = syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	} else {
+		err = cmd.Process.Signal(syscall.SIGTERM)
+	}
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
@@ -0,0 +1,45 @@
+//go:build windows
+
+package runner
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup is a no-op on Windows
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// processBackend implements execution operations for local processes
+type processBackend struct {
+	// pid is the process ID of the command
+	pid int
+}
+
+func (b *processBackend) pause() error {
+	return ErrNotSupported
+}
+
+func (b *processBackend) resume() error {
+	return ErrNotSupported
+}
+
+func (b *processBackend) signal(sig syscall.Signal) error {
+	return ErrNotSupported
+}
+
+// killedBySeccomp always returns false, as there are no seccomp filters on Windows
+func killedBySeccomp(exitErr *exec.ExitError) bool {
+	return false
+}
+
+// exitSignal always returns 0, as processes are not killed by signals on Windows
+func exitSignal(exitErr *exec.ExitError) int {
+	return 0
+}
+
+// terminateProcess kills a started command, as there are no termination
+// signals on Windows
+func terminateProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
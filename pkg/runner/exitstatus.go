@@ -0,0 +1,184 @@
+package runner
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// ErrorKind is the normalized cause of the failure of an execution
+type ErrorKind string
+
+const (
+	// ErrorKindNone is the kind of executions that succeeded
+	ErrorKindNone ErrorKind = ""
+	// ErrorKindFailed is the kind of commands that exited with a non-zero status of their own
+	ErrorKindFailed ErrorKind = "failed"
+	// ErrorKindPermissionDenied is the kind of commands denied an operation by the sandbox
+	ErrorKindPermissionDenied ErrorKind = "permission_denied"
+	// ErrorKindNotFound is the kind of commands that were not found
+	ErrorKindNotFound ErrorKind = "not_found"
+	// ErrorKindSignaled is the kind of commands killed by a signal
+	ErrorKindSignaled ErrorKind = "signaled"
+	// ErrorKindBackend is the kind of failures of the backend itself (e.g. the Docker daemon)
+	ErrorKindBackend ErrorKind = "backend"
+	// ErrorKindUnknown is the kind of errors without an exit status (e.g. the command could not be started)
+	ErrorKindUnknown ErrorKind = "unknown"
+	// ErrorKindNoSpace is the kind of executions that failed because the host ran out of disk space (see ErrNoSpace)
+	ErrorKindNoSpace ErrorKind = "no_space"
+)
+
+// Retryable returns whether the executions failing with this kind can be
+// retried once the cause has been addressed, without changing the command
+// (e.g. after freeing disk space with ImageGC)
+func (k ErrorKind) Retryable() bool {
+	return k == ErrorKindNoSpace
+}
+
+// Normalized exit codes, following the conventions of shells and `docker run`
+const (
+	exitCodeBackend  = 125
+	exitCodeNotFound = 127
+	exitCodeSignaled = 128
+)
+
+// ExitStatus is the exit status of an execution, as returned by the backend
+// of the runner and normalized across runners
+type ExitStatus struct {
+	// Raw is the exit code of the process started by the runner (e.g. the
+	// `docker` client), or -1 when it was killed by a signal or did not run
+	Raw int `json:"raw"`
+
+	// Code is the normalized exit code: the exit code of the command, 125 for
+	// failures of the backend, 126 when the command cannot be executed, 127
+	// when it is not found, and 128 plus the signal number when it was killed
+	// by a signal. It is -1 when the command did not run.
+	Code int `json:"code"`
+
+	// Signal is the number of the signal that killed the command, if any
+	Signal int `json:"signal,omitempty"`
+
+	// Kind is the normalized cause of the failure
+	Kind ErrorKind `json:"kind,omitempty"`
+}
+
+// exitCodeTable maps the exit codes reserved by a backend to error kinds.
+// Other non-zero codes are the exit status of the command.
+type exitCodeTable map[int]ErrorKind
+
+var (
+	// shellExitCodes are the codes of shells, used by the runners that
+	// execute commands through a shell or exec them directly
+	shellExitCodes = exitCodeTable{
+		exitCodeCannotExecute: ErrorKindPermissionDenied,
+		exitCodeNotFound:      ErrorKindNotFound,
+	}
+
+	// dockerExitCodes are the codes of `docker run` and `docker exec`
+	dockerExitCodes = exitCodeTable{
+		exitCodeBackend:       ErrorKindBackend,
+		exitCodeCannotExecute: ErrorKindPermissionDenied,
+		exitCodeNotFound:      ErrorKindNotFound,
+	}
+)
+
+// exitCodesOf returns the exit code table of the backend of a runner
+func exitCodesOf(r Runner) exitCodeTable {
+	switch r := r.(type) {
+	case *Docker:
+		return dockerExitCodes
+	case *Nsjail:
+		return nsjailExitCodes
+	case *Firejail:
+		return firejailExitCodes
+	case *Session:
+		return exitCodesOf(r.runner)
+	case *ApprovalGate:
+		return exitCodesOf(r.runner)
+	case *PolicyGate:
+		return exitCodesOf(r.runner)
+	default:
+		return shellExitCodes
+	}
+}
+
+// NormalizeExit returns the exit status of a command run with the given
+// runner, from the error returned by Run or Execution.Wait
+func NormalizeExit(r Runner, err error) ExitStatus {
+	return exitCodesOf(r).normalize(err)
+}
+
+// normalize returns the exit status for the error of an execution
+func (t exitCodeTable) normalize(err error) ExitStatus {
+	status := t.classify(err)
+	if (status.Kind == ErrorKindBackend || status.Kind == ErrorKindUnknown) && IsNoSpace(err) {
+		// the command did not fail, the host did
+		status.Kind = ErrorKindNoSpace
+	}
+	return status
+}
+
+// classify returns the exit status for the error of an execution, from its
+// exit code
+func (t exitCodeTable) classify(err error) ExitStatus {
+	if err == nil {
+		return ExitStatus{}
+	}
+
+	var status ExitStatus
+	var exitErr *exec.ExitError
+	var containerErr *containerExitError
+	switch {
+	case errors.As(err, &exitErr):
+		status.Raw = exitErr.ExitCode()
+		if sig := exitSignal(exitErr); sig != 0 {
+			// the process started by the runner was killed
+			status.Code = exitCodeSignaled + sig
+			status.Signal = sig
+			status.Kind = ErrorKindSignaled
+			if killedBySeccomp(exitErr) {
+				status.Kind = ErrorKindPermissionDenied
+			}
+			return status
+		}
+	case errors.As(err, &containerErr):
+		// a container run through the API of the engine
+		status.Raw = containerErr.code
+	default:
+		kind := ErrorKindUnknown
+		if IsPermissionDenied(err) {
+			kind = ErrorKindPermissionDenied
+		}
+		return ExitStatus{Raw: -1, Code: -1, Kind: kind}
+	}
+
+	status.Code = status.Raw
+	if kind, ok := t[status.Raw]; ok {
+		status.Kind = kind
+		switch {
+		case kind == ErrorKindBackend:
+			status.Code = exitCodeBackend
+		case status.Raw > exitCodeSignaled:
+			// a command killed by a signal, e.g. by the seccomp filter of firejail
+			status.Signal = status.Raw - exitCodeSignaled
+		}
+		return status
+	}
+	if status.Raw > exitCodeSignaled && status.Raw <= exitCodeSignaled+64 {
+		// shells (and containers) report commands killed by a signal as 128+n
+		status.Signal = status.Raw - exitCodeSignaled
+		status.Kind = ErrorKindSignaled
+		return status
+	}
+	status.Kind = ErrorKindFailed
+	return status
+}
+
+// ExitStatus returns the normalized exit status of the command. It must be
+// called after Wait.
+func (e *Execution) ExitStatus() ExitStatus {
+	exitCodes := e.exitCodes
+	if exitCodes == nil {
+		exitCodes = shellExitCodes
+	}
+	return exitCodes.normalize(e.waitErr)
+}
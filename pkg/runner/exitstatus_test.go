@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+func TestNormalizeExit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(command string) error {
+		_, err := r.Run(context.Background(), "sh", command, nil, nil, false)
+		return err
+	}
+
+	tests := []struct {
+		name   string
+		runner Runner
+		err    error
+		want   ExitStatus
+	}{
+		{name: "success", runner: r, err: nil, want: ExitStatus{}},
+		{name: "failed", runner: r, err: run("exit 3"), want: ExitStatus{Raw: 3, Code: 3, Kind: ErrorKindFailed}},
+		{name: "cannot execute", runner: r, err: run("exit 126"), want: ExitStatus{Raw: 126, Code: 126, Kind: ErrorKindPermissionDenied}},
+		{name: "not found", runner: r, err: run("no-such-command-for-tests"), want: ExitStatus{Raw: 127, Code: 127, Kind: ErrorKindNotFound}},
+		{name: "signal from shell", runner: r, err: run("exit 137"), want: ExitStatus{Raw: 137, Code: 137, Signal: 9, Kind: ErrorKindSignaled}},
+		{name: "killed", runner: r, err: run("kill -TERM $$"), want: ExitStatus{Raw: -1, Code: 143, Signal: 15, Kind: ErrorKindSignaled}},
+		{name: "seccomp", runner: r, err: run("kill -SYS $$"), want: ExitStatus{Raw: -1, Code: 128 + 31, Signal: 31, Kind: ErrorKindPermissionDenied}},
+		{name: "docker daemon", runner: &Docker{}, err: run("exit 125"), want: ExitStatus{Raw: 125, Code: 125, Kind: ErrorKindBackend}},
+		{name: "shell 125", runner: r, err: run("exit 125"), want: ExitStatus{Raw: 125, Code: 125, Kind: ErrorKindFailed}},
+		{name: "firejail seccomp", runner: &Firejail{}, err: run("exit 159"), want: ExitStatus{Raw: 159, Code: 159, Signal: 31, Kind: ErrorKindPermissionDenied}},
+		{name: "firejail signal", runner: &Firejail{}, err: run("exit 137"), want: ExitStatus{Raw: 137, Code: 137, Signal: 9, Kind: ErrorKindSignaled}},
+		{name: "firejail not found", runner: &Firejail{}, err: run("exit 127"), want: ExitStatus{Raw: 127, Code: 127, Kind: ErrorKindNotFound}},
+		{name: "firejail error", runner: &Firejail{}, err: run("exit 1"), want: ExitStatus{Raw: 1, Code: 1, Kind: ErrorKindFailed}},
+		{name: "shell 159", runner: r, err: run("exit 159"), want: ExitStatus{Raw: 159, Code: 159, Signal: 31, Kind: ErrorKindSignaled}},
+		{name: "no exit status", runner: r, err: errors.New("failed to start"), want: ExitStatus{Raw: -1, Code: -1, Kind: ErrorKindUnknown}},
+		{name: "docker out of space", runner: &Docker{}, err: noSpaceError(run("exit 125"), "no space left on device"),
+			want: ExitStatus{Raw: 125, Code: 125, Kind: ErrorKindNoSpace}},
+		{name: "command out of space", runner: &Docker{}, err: noSpaceError(run("exit 1"), "no space left on device"),
+			want: ExitStatus{Raw: 1, Code: 1, Kind: ErrorKindFailed}},
+		{name: "script out of space", runner: r, err: fmt.Errorf("failed to write script: %w", syscall.ENOSPC),
+			want: ExitStatus{Raw: -1, Code: -1, Kind: ErrorKindNoSpace}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NormalizeExit(tt.runner, tt.err)
+			if tt.name == "seccomp" && runtime.GOOS != "linux" {
+				// the number of SIGSYS depends on the platform
+				got.Code, got.Signal = tt.want.Code, tt.want.Signal
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeExit(%v) = %+v, want %+v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecution_ExitStatus(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := Start(context.Background(), r, "sh", []string{"-c", "exit 4"}, nil, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	go func() { _, _ = io.Copy(io.Discard, e.Stderr) }()
+	_, _ = io.Copy(io.Discard, e.Stdout)
+	_ = e.Wait()
+
+	want := ExitStatus{Raw: 4, Code: 4, Kind: ErrorKindFailed}
+	if got := e.ExitStatus(); got != want {
+		t.Errorf("ExitStatus() = %+v, want %+v", got, want)
+	}
+}
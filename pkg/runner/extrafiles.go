@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// WithExtraFiles passes pre-opened files (e.g. a connected socket) to the
+// command, so a broker can hand it a single connection without granting it
+// general network access.
+//
+// The files are inherited by the command as file descriptors 3, 4, ... in
+// the order given, and the ExtraFilesEnv variable holds their numbers. The
+// files remain owned by the caller, who can close them once Start returns.
+//
+// Only runners that start local processes support extra files: Start fails
+// with ErrNotSupported for the others (e.g. Docker and ADB).
+func WithExtraFiles(files ...*os.File) ExecOption {
+	return func(c *execConfig) {
+		c.extraFiles = append(c.extraFiles, files...)
+	}
+}
+
+// ExtraFilesEnv is the environment variable set to the (space separated)
+// descriptor numbers of the files passed with WithExtraFiles
+const ExtraFilesEnv = "RUNNER_EXTRA_FDS"
+
+// extraFilesKey is the context key of the files passed with WithExtraFiles
+type extraFilesKey struct{}
+
+// withExtraFiles returns a context carrying the extra files for the runners
+func withExtraFiles(ctx context.Context, files []*os.File) context.Context {
+	return context.WithValue(ctx, extraFilesKey{}, files)
+}
+
+// extraFilesFrom returns the extra files of the context, or nil
+func extraFilesFrom(ctx context.Context) []*os.File {
+	files, _ := ctx.Value(extraFilesKey{}).([]*os.File)
+	return files
+}
+
+// extraFDs returns the descriptor numbers of n extra files in the child
+func extraFDs(n int) []string {
+	fds := make([]string, n)
+	for i := range fds {
+		fds[i] = strconv.Itoa(3 + i)
+	}
+	return fds
+}
+
+// extraFilesEnv returns the ExtraFilesEnv variable for n extra files
+func extraFilesEnv(n int) string {
+	return ExtraFilesEnv + "=" + strings.Join(extraFDs(n), " ")
+}
+
+// checkNoExtraFiles returns ErrNotSupported when the context carries extra
+// files, for runners whose commands do not run as local processes
+func checkNoExtraFiles(ctx context.Context, runner string) error {
+	if len(extraFilesFrom(ctx)) > 0 {
+		return fmt.Errorf("extra files cannot be passed to %s commands: %w", runner, ErrNotSupported)
+	}
+	return nil
+}
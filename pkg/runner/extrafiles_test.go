@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWithExtraFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("extra files are not supported on Windows")
+	}
+
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+
+	e, err := Start(context.Background(), r, "sh", []string{"-c", `echo "fds=$RUNNER_EXTRA_FDS" >&3`}, nil, nil,
+		WithExtraFiles(pw))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	// the child has its own copy of the descriptor
+	_ = pw.Close()
+
+	out, err := io.ReadAll(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "fds=3" {
+		t.Errorf("expected %q on the extra file, got %q", "fds=3", got)
+	}
+}
+
+func TestWithExtraFiles_NotSupported(t *testing.T) {
+	r, err := NewADB(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Start(context.Background(), r, "true", nil, nil, nil, WithExtraFiles(os.Stdin))
+	if !errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+func TestSendReceiveFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("passing files is not supported on Windows")
+	}
+
+	addr := &net.UnixAddr{Name: filepath.Join(t.TempDir(), "broker.sock"), Net: "unix"}
+	l, err := net.ListenUnix("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := net.DialUnix("unix", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := l.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+
+	if err := SendFiles(client, pw); err != nil {
+		t.Fatalf("SendFiles failed: %v", err)
+	}
+	_ = pw.Close()
+
+	files, err := ReceiveFiles(server, 1)
+	if err != nil {
+		t.Fatalf("ReceiveFiles failed: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(files))
+	}
+
+	if _, err := files[0].WriteString("hello"); err != nil {
+		t.Fatal(err)
+	}
+	_ = files[0].Close()
+
+	out, err := io.ReadAll(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "hello" {
+		t.Errorf("expected %q, got %q", "hello", out)
+	}
+}
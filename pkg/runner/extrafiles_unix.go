@@ -0,0 +1,61 @@
+//go:build !windows
+
+package runner
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// SendFiles sends open files over a Unix socket (with SCM_RIGHTS), so a
+// broker can hand connected sockets to a helper process that is already
+// running. The receiver gets its own descriptors for the same files, and the
+// caller can close its copies once SendFiles returns.
+func SendFiles(conn *net.UnixConn, files ...*os.File) error {
+	if len(files) == 0 {
+		return nil
+	}
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	// at least one byte of data must go with the descriptors
+	_, _, err := conn.WriteMsgUnix([]byte{0}, syscall.UnixRights(fds...), nil)
+	if err != nil {
+		return fmt.Errorf("failed to send files: %w", err)
+	}
+	return nil
+}
+
+// ReceiveFiles receives up to max files sent with SendFiles on a Unix socket
+func ReceiveFiles(conn *net.UnixConn, max int) ([]*os.File, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(max*4))
+	_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive files: %w", err)
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse control message: %w", err)
+	}
+
+	var files []*os.File
+	for _, msg := range msgs {
+		fds, err := syscall.ParseUnixRights(&msg)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			syscall.CloseOnExec(fd)
+			files = append(files, os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd)))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files received")
+	}
+	return files, nil
+}
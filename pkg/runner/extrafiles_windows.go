@@ -0,0 +1,18 @@
+//go:build windows
+
+package runner
+
+import (
+	"net"
+	"os"
+)
+
+// SendFiles is not supported on Windows
+func SendFiles(conn *net.UnixConn, files ...*os.File) error {
+	return ErrNotSupported
+}
+
+// ReceiveFiles is not supported on Windows
+func ReceiveFiles(conn *net.UnixConn, max int) ([]*os.File, error) {
+	return nil, ErrNotSupported
+}
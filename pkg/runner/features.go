@@ -0,0 +1,176 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Feature is an experimental behavior of the runners, enabled with the
+// "experimental" option. Experimental features let new (and riskier)
+// implementations ship incrementally: they are disabled unless explicitly
+// requested, and may change or be removed between releases.
+type Feature string
+
+const (
+	// FeatureLandlockHelper applies the Landlock rules of the Landrun runner
+	// in a helper process, instead of in the runner process: the commands
+	// are started through this executable, which applies the rules right
+	// before executing them, so Run calls with different rules work in the
+	// same process
+	FeatureLandlockHelper Feature = "landlock_helper"
+
+	// FeatureDockerSDK drives the Docker engine through its API, instead of
+	// running the docker CLI
+	FeatureDockerSDK Feature = "docker_sdk"
+)
+
+// experimentalFeatures are the known features, with the runners they apply to
+var experimentalFeatures = map[Feature][]Type{
+	FeatureLandlockHelper: {TypeLandrun},
+	FeatureDockerSDK:      {TypeDocker},
+}
+
+// ExperimentalOptions enables experimental features in a runner
+type ExperimentalOptions struct {
+	// Experimental are the experimental features enabled
+	Experimental []Feature `json:"experimental"`
+}
+
+// experimentEnabled returns whether the experimental feature is enabled
+func (o ExperimentalOptions) experimentEnabled(feature Feature) bool {
+	for _, f := range o.Experimental {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Features returns the known experimental features
+func Features() []Feature {
+	features := make([]Feature, 0, len(experimentalFeatures))
+	for f := range experimentalFeatures {
+		features = append(features, f)
+	}
+	sort.Slice(features, func(i, j int) bool { return features[i] < features[j] })
+	return features
+}
+
+// Deprecation describes a deprecated option
+type Deprecation struct {
+	// Runner is the runner type of the option, or "" for all of them
+	Runner Type `json:"runner,omitempty"`
+
+	// Option is the deprecated option key
+	Option string `json:"option"`
+
+	// Replacement is the option replacing it, if any (see Migrate)
+	Replacement string `json:"replacement,omitempty"`
+
+	// Since is the options schema version where the option was deprecated
+	Since int `json:"since"`
+
+	// Note explains how to stop using the option
+	Note string `json:"note,omitempty"`
+}
+
+// String returns the warning logged for the deprecation
+func (d Deprecation) String() string {
+	msg := fmt.Sprintf("option %q is deprecated since options version %d", d.Option, d.Since)
+	if d.Runner != "" {
+		msg = fmt.Sprintf("option %q of the %s runner is deprecated since options version %d", d.Option, d.Runner, d.Since)
+	}
+	if d.Replacement != "" {
+		msg += fmt.Sprintf(": use %q instead", d.Replacement)
+	}
+	if d.Note != "" {
+		msg += ": " + d.Note
+	}
+	return msg
+}
+
+// appliesTo returns whether the deprecation applies to the runner type
+func (d Deprecation) appliesTo(runnerType Type) bool {
+	return d.Runner == "" || d.Runner == runnerType
+}
+
+// deprecations are the deprecated options, in order. Options can only be
+// deprecated together with an increase of CurrentOptionsVersion.
+var deprecations []Deprecation
+
+// Deprecations returns the deprecated options used in the options of a runner
+func Deprecations(runnerType Type, opts Options) []Deprecation {
+	var used []Deprecation
+	for _, d := range deprecations {
+		if _, ok := opts[d.Option]; ok && d.appliesTo(runnerType) {
+			used = append(used, d)
+		}
+	}
+	return used
+}
+
+// checkOptions logs the deprecated options and the experimental features in
+// the options of a runner, and returns the options with the deprecated keys
+// replaced (see Migrate)
+func checkOptions(runnerType Type, opts Options, logger Logger) (Options, error) {
+	for _, d := range Deprecations(runnerType, opts) {
+		logger.Warn("Deprecated: %s", d)
+	}
+
+	migrated, _, err := Migrate(runnerType, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	features, err := parseFeatures(migrated["experimental"])
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range features {
+		runners, known := experimentalFeatures[f]
+		switch {
+		case !known:
+			logger.Warn("Unknown experimental feature %q: ignored", f)
+		case !containsType(runners, runnerType):
+			logger.Warn("Experimental feature %q does not apply to the %s runner: ignored", f, runnerType)
+		default:
+			logger.Info("Experimental feature %q enabled in the %s runner", f, runnerType)
+		}
+	}
+	return migrated, nil
+}
+
+// parseFeatures returns the features of the "experimental" option
+func parseFeatures(value interface{}) ([]Feature, error) {
+	var features []Feature
+	switch v := value.(type) {
+	case nil:
+	case []Feature:
+		features = v
+	case []string:
+		for _, f := range v {
+			features = append(features, Feature(f))
+		}
+	case []interface{}:
+		for _, f := range v {
+			s, ok := f.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid experimental feature: %v", f)
+			}
+			features = append(features, Feature(s))
+		}
+	default:
+		return nil, fmt.Errorf("experimental must be a list of features, got %T", value)
+	}
+	return features, nil
+}
+
+// containsType returns whether the list contains the runner type
+func containsType(types []Type, t Type) bool {
+	for _, other := range types {
+		if other == t {
+			return true
+		}
+	}
+	return false
+}
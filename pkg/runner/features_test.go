@@ -0,0 +1,80 @@
+package runner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFeatures(t *testing.T) {
+	expected := []Feature{FeatureDockerSDK, FeatureLandlockHelper}
+	if got := Features(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected features: %v", got)
+	}
+
+	opts, err := NewLandrunOptions(Options{"experimental": []string{"landlock_helper"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.experimentEnabled(FeatureLandlockHelper) || opts.experimentEnabled(FeatureDockerSDK) {
+		t.Errorf("unexpected experimental features: %v", opts.Experimental)
+	}
+
+	dockerOpts, err := NewDockerOptions(Options{"image": "alpine", "experimental": []interface{}{"docker_sdk"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dockerOpts.experimentEnabled(FeatureDockerSDK) {
+		t.Errorf("expected docker_sdk to be enabled, got %v", dockerOpts.Experimental)
+	}
+}
+
+func TestDeprecation_String(t *testing.T) {
+	d := Deprecation{Runner: TypeDocker, Option: "volumes", Replacement: "mounts", Since: 2}
+	expected := `option "volumes" of the docker runner is deprecated since options version 2: use "mounts" instead`
+	if got := d.String(); got != expected {
+		t.Errorf("unexpected message: %s", got)
+	}
+
+	d = Deprecation{Option: "docker_run_opts", Since: 3, Note: "it will be removed"}
+	expected = `option "docker_run_opts" is deprecated since options version 3: it will be removed`
+	if got := d.String(); got != expected {
+		t.Errorf("unexpected message: %s", got)
+	}
+}
+
+func TestNew_deprecationsAndFeatures(t *testing.T) {
+	defer func(d []Deprecation) { deprecations = d }(deprecations)
+	deprecations = []Deprecation{{Since: CurrentOptionsVersion + 1, Option: "sh", Replacement: "shell"}}
+
+	if got := Deprecations(TypeExec, Options{"sh": "/bin/sh"}); len(got) != 1 {
+		t.Errorf("expected the deprecated option to be reported, got %v", got)
+	}
+
+	rec := &recordingLogger{}
+	r, err := New(TypeExec, Options{
+		"sh":           "/bin/sh",
+		"experimental": []string{"docker_sdk", "time_travel"},
+	}, NewPrintfLogger(rec))
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if shell := r.(*Exec).options.Shell; shell != "/bin/sh" {
+		t.Errorf("expected the deprecated option to be migrated, got shell %q", shell)
+	}
+
+	all := strings.Join(rec.lines, "\n")
+	for _, line := range []string{
+		`warn: Deprecated: option "sh" is deprecated`,
+		`warn: Experimental feature "docker_sdk" does not apply to the exec runner`,
+		`warn: Unknown experimental feature "time_travel"`,
+	} {
+		if !strings.Contains(all, line) {
+			t.Errorf("expected %q in the logs:\n%s", line, all)
+		}
+	}
+
+	if _, err := New(TypeExec, Options{"experimental": "docker_sdk"}, nil); err == nil {
+		t.Error("expected an error for an invalid experimental option")
+	}
+}
@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// FileModeOptions is the umask and file mode policy of the runners that
+// execute commands on Unix hosts. It is embedded in their options, so its
+// fields are set with the same keys as any other option.
+type FileModeOptions struct {
+	// Umask is the umask of the command, in octal (e.g. "077").
+	// When empty the command inherits the umask of the current process.
+	Umask string `json:"umask"`
+
+	// StripSetuid clears the setuid and setgid bits of the files in the
+	// writable folders after every execution
+	StripSetuid bool `json:"strip_setuid"`
+
+	// EnforceUmask clears the bits of Umask from the files in the writable
+	// folders after every execution, including the files the command made
+	// more permissive with chmod
+	EnforceUmask bool `json:"enforce_umask"`
+}
+
+// validate checks the umask and the options that depend on it
+func (o FileModeOptions) validate() error {
+	if _, err := parseUmask(o.Umask); err != nil {
+		return err
+	}
+	if o.EnforceUmask && o.Umask == "" {
+		return fmt.Errorf("enforce_umask requires a umask")
+	}
+	return nil
+}
+
+// parseUmask parses an octal umask, returning 0 for an empty one
+func parseUmask(umask string) (os.FileMode, error) {
+	if umask == "" {
+		return 0, nil
+	}
+	mask, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil || mask > 0o777 {
+		return 0, fmt.Errorf("invalid umask %q: must be an octal number between 000 and 777", umask)
+	}
+	return os.FileMode(mask), nil
+}
+
+// umaskScript is the shell script that sets the umask and executes its
+// arguments, with the command as $0 so "$@" does not include it
+const umaskScript = `umask %s && exec "$0" "$@"`
+
+// umaskArgs returns the command and arguments that execute cmd with the
+// given umask, or cmd and args unchanged when umask is empty
+func umaskArgs(umask string, cmd string, args []string) (string, []string) {
+	if umask == "" {
+		return cmd, args
+	}
+	return "/bin/sh", append([]string{"-c", fmt.Sprintf(umaskScript, umask), cmd}, args...)
+}
+
+// applyUmask makes a command, not started yet, run with the given umask.
+// The umask is set by a shell that then replaces itself with the command,
+// so the process tree and the exit status are not affected.
+func applyUmask(logger Logger, execCmd *exec.Cmd, umask string) {
+	if umask == "" || runtime.GOOS == "windows" || execCmd.Err != nil {
+		return
+	}
+	shell, args := umaskArgs(umask, execCmd.Path, execCmd.Args[1:])
+	logger.Debug("Running command with umask %s", umask)
+	execCmd.Path = shell
+	execCmd.Args = append([]string{shell}, args...)
+}
+
+// normalizeFileModes applies the post-execution file mode policy to the
+// files in the given folders. Files that cannot be changed (e.g. those owned
+// by other users) are skipped.
+func (o FileModeOptions) normalizeFileModes(logger Logger, folders []string) {
+	if !o.StripSetuid && !o.EnforceUmask {
+		return
+	}
+
+	var clear fs.FileMode
+	if o.StripSetuid {
+		clear |= fs.ModeSetuid | fs.ModeSetgid
+	}
+	if o.EnforceUmask {
+		mask, _ := parseUmask(o.Umask)
+		clear |= mask
+	}
+
+	for _, folder := range folders {
+		_ = filepath.WalkDir(folder, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || path == folder || d.Type()&fs.ModeSymlink != 0 {
+				// the tree can change while it is walked, links are not followed and
+				// the folders themselves belong to the configuration, not to the command
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || info.Mode()&clear == 0 {
+				return nil
+			}
+			mode := info.Mode() &^ clear
+			if err := os.Chmod(path, mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+				logger.Debug("Failed to change the mode of %s: %v", path, err)
+				return nil
+			}
+			logger.Debug("Changed the mode of %s from %v to %v", path, info.Mode(), mode)
+			return nil
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestParseUmask(t *testing.T) {
+	tests := []struct {
+		umask   string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{umask: "", want: 0},
+		{umask: "077", want: 0o077},
+		{umask: "0022", want: 0o022},
+		{umask: "999", wantErr: true},
+		{umask: "1777", wantErr: true},
+		{umask: "077; rm -rf /", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.umask, func(t *testing.T) {
+			got, err := parseUmask(tt.umask)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseUmask() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseUmask() = %o, want %o", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileModeOptions_Validate(t *testing.T) {
+	if _, err := NewExec(Options{"umask": "08"}, nil); err == nil {
+		t.Errorf("NewExec should fail with an invalid umask")
+	}
+	if _, err := NewExec(Options{"enforce_umask": true}, nil); err == nil {
+		t.Errorf("NewExec should fail with enforce_umask and no umask")
+	}
+}
+
+func TestExec_Umask(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("umask is not supported on Windows")
+	}
+
+	dir := t.TempDir()
+	r, err := NewExec(Options{"umask": "077"}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+
+	t.Run("Run", func(t *testing.T) {
+		file := filepath.Join(dir, "run.txt")
+		if _, err := r.Run(context.Background(), "", "touch "+file, nil, nil, false); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		assertFileMode(t, file, 0o600)
+	})
+
+	t.Run("Start", func(t *testing.T) {
+		file := filepath.Join(dir, "start.txt")
+		e, err := Start(context.Background(), r, "touch", []string{file}, nil, nil)
+		if err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		_ = e.Stdin.Close()
+		_, _ = io.ReadAll(e.Stdout)
+		_, _ = io.ReadAll(e.Stderr)
+		if err := e.Wait(); err != nil {
+			t.Fatalf("Wait failed: %v", err)
+		}
+		assertFileMode(t, file, 0o600)
+	})
+}
+
+func TestNormalizeFileModes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not supported on Windows")
+	}
+
+	dir := t.TempDir()
+	setuid := filepath.Join(dir, "setuid")
+	open := filepath.Join(dir, "sub", "open")
+	if err := os.MkdirAll(filepath.Dir(open), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{setuid, open} {
+		if err := os.WriteFile(file, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(setuid, 0o755|os.ModeSetuid|os.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(open, 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	FileModeOptions{Umask: "022", StripSetuid: true, EnforceUmask: true}.normalizeFileModes(defaultLogger(nil), []string{dir})
+
+	assertFileMode(t, setuid, 0o755)
+	assertFileMode(t, open, 0o644)
+	assertFileMode(t, filepath.Dir(open), 0o755|os.ModeDir)
+}
+
+// assertFileMode checks the mode of a file, ignoring the file type bits other than directories
+func assertFileMode(t *testing.T, path string, want os.FileMode) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if got := info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeDir); got != want {
+		t.Errorf("mode of %s = %v, want %v", path, got, want)
+	}
+}
@@ -0,0 +1,191 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+)
+
+// ErrNotRegularFile is returned by GetFile when the path is not a regular file
+var ErrNotRegularFile = errors.New("not a regular file")
+
+// fileTransferBackend is implemented by the backends that can copy files in
+// and out of the environment of the command (e.g. a container)
+type fileTransferBackend interface {
+	// putFile writes a file with the contents of r, of the given size
+	putFile(ctx context.Context, path string, r io.Reader, size int64, mode os.FileMode) error
+
+	// getFile writes the contents of a file to w
+	getFile(ctx context.Context, path string, w io.Writer) error
+}
+
+// PutFile writes a file with the contents of r in the container of the
+// execution, creating its parent folders. The file is streamed as a tar
+// archive to a `tar` helper run in the container, so bind mounts are not
+// needed, and it is owned by the user of the container.
+//
+// ErrNotSupported is returned for executions that do not run in a container.
+func (e *Execution) PutFile(ctx context.Context, path string, r io.Reader, mode os.FileMode) error {
+	b, ok := e.backend.(fileTransferBackend)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	// the size of the file goes in the tar header before its contents, so
+	// readers of an unknown size are spooled to a temporary file first
+	size, err := readerSize(r)
+	if err != nil {
+		spool, err := os.CreateTemp("", "go-restricted-runner-putfile-*")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = spool.Close()
+			_ = os.Remove(spool.Name())
+		}()
+		if size, err = io.Copy(spool, r); err != nil {
+			return fmt.Errorf("failed to read the contents of %s: %w", path, err)
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r = spool
+	}
+
+	e.logger.Debug("Copying %d bytes to %s in execution %s", size, path, e.ID)
+	return b.putFile(ctx, path, r, size, mode)
+}
+
+// GetFile writes the contents of a file in the container of the execution to
+// w, streamed as a tar archive from a `tar` helper run in the container.
+//
+// ErrNotSupported is returned for executions that do not run in a container,
+// and ErrNotRegularFile when the path is not a regular file.
+func (e *Execution) GetFile(ctx context.Context, path string, w io.Writer) error {
+	b, ok := e.backend.(fileTransferBackend)
+	if !ok {
+		return ErrNotSupported
+	}
+	e.logger.Debug("Copying %s from execution %s", path, e.ID)
+	return b.getFile(ctx, path, w)
+}
+
+// readerSize returns the number of bytes left in readers that know it
+func readerSize(r io.Reader) (int64, error) {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		// bytes.Buffer, bytes.Reader and strings.Reader
+		return int64(r.Len()), nil
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return 0, errors.New("unknown size")
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, err
+		}
+		return info.Size() - offset, nil
+	}
+	return 0, errors.New("unknown size")
+}
+
+// putFile extracts a tar archive with the file in the container
+func (b *containerBackend) putFile(ctx context.Context, file string, r io.Reader, size int64, mode os.FileMode) error {
+	if !path.IsAbs(file) {
+		return fmt.Errorf("path %q in the container must be absolute", file)
+	}
+	dir, name := path.Split(path.Clean(file))
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, b.engine, "exec", "-i", b.container,
+		"sh", "-c", `mkdir -p "$1" && exec tar -x -o -f - -C "$1"`, "sh", dir)
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start the tar helper: %w", err)
+	}
+
+	tw := tar.NewWriter(stdin)
+	writeErr := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     int64(mode.Perm()),
+		ModTime:  time.Now(),
+		Format:   tar.FormatPAX,
+	})
+	if writeErr == nil {
+		var n int64
+		if n, writeErr = io.Copy(tw, io.LimitReader(r, size)); writeErr == nil && n != size {
+			writeErr = fmt.Errorf("the contents are %d bytes, expected %d", n, size)
+		}
+	}
+	if writeErr == nil {
+		writeErr = tw.Close()
+	}
+	_ = stdin.Close()
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("failed to copy %s to container %s: %w: %s", file, b.container, err, stderr.String())
+	}
+	if writeErr != nil {
+		return fmt.Errorf("failed to copy %s to container %s: %w", file, b.container, writeErr)
+	}
+	return nil
+}
+
+// getFile reads the file from a tar archive created in the container
+func (b *containerBackend) getFile(ctx context.Context, file string, w io.Writer) error {
+	if !path.IsAbs(file) {
+		return fmt.Errorf("path %q in the container must be absolute", file)
+	}
+	dir, name := path.Split(path.Clean(file))
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, b.engine, "exec", "-i", b.container, "tar", "-c", "-f", "-", "-C", dir, name)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start the tar helper: %w", err)
+	}
+
+	readErr := func() error {
+		tr := tar.NewReader(stdout)
+		header, err := tr.Next()
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("%w: %s", ErrNotRegularFile, file)
+		}
+		_, err = io.Copy(w, tr)
+		return err
+	}()
+	// the rest of the archive (e.g. the files of a folder) is discarded
+	_, _ = io.Copy(io.Discard, stdout)
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("failed to copy %s from container %s: %w: %s", file, b.container, err, stderr.String())
+	}
+	if readErr != nil {
+		if errors.Is(readErr, ErrNotRegularFile) {
+			return readErr
+		}
+		return fmt.Errorf("failed to copy %s from container %s: %w", file, b.container, readErr)
+	}
+	return nil
+}
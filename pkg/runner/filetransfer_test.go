@@ -0,0 +1,118 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// fakeContainerExecution returns an execution whose container engine runs
+// the commands of `exec` in the host
+func fakeContainerExecution(t *testing.T) *Execution {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not installed")
+	}
+	engine := filepath.Join(t.TempDir(), "engine")
+	// engine exec -i <container> <command...>
+	if err := os.WriteFile(engine, []byte("#!/bin/sh\nshift 3\nexec \"$@\"\n"), 0o755); err != nil {
+		t.Fatalf("failed to write the fake engine: %v", err)
+	}
+	e := newExecution(common.GetLogger(), nil, nil, nil, func() error { return nil },
+		&containerBackend{engine: engine, container: "test"})
+	return e
+}
+
+func TestExecution_PutFile(t *testing.T) {
+	e := fakeContainerExecution(t)
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	target := filepath.Join(dir, "sub", "dir", "script.sh")
+	if err := e.PutFile(ctx, target, strings.NewReader("echo hello\n"), 0o750); err != nil {
+		t.Fatalf("PutFile failed: %v", err)
+	}
+	data, err := os.ReadFile(target)
+	if err != nil || string(data) != "echo hello\n" {
+		t.Errorf("file contents = %q, %v", data, err)
+	}
+	if info, err := os.Stat(target); err != nil || info.Mode().Perm() != 0o750 {
+		t.Errorf("file mode = %v, %v, want 0750", info.Mode(), err)
+	}
+
+	// readers of an unknown size are spooled
+	large := bytes.Repeat([]byte("0123456789"), 100000)
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = pw.Write(large)
+		_ = pw.Close()
+	}()
+	target = filepath.Join(dir, "large.bin")
+	if err := e.PutFile(ctx, target, pr, 0o644); err != nil {
+		t.Fatalf("PutFile from a pipe failed: %v", err)
+	}
+	if data, err := os.ReadFile(target); err != nil || !bytes.Equal(data, large) {
+		t.Errorf("file contents differ (%d bytes, %v)", len(data), err)
+	}
+
+	if err := e.PutFile(ctx, "relative/path", strings.NewReader(""), 0o644); err == nil {
+		t.Errorf("PutFile with a relative path should fail")
+	}
+}
+
+func TestExecution_GetFile(t *testing.T) {
+	e := fakeContainerExecution(t)
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	source := filepath.Join(dir, "result.json")
+	if err := os.WriteFile(source, []byte(`{"ok":true}`), 0o600); err != nil {
+		t.Fatalf("failed to write the file: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := e.GetFile(ctx, source, &buf); err != nil || buf.String() != `{"ok":true}` {
+		t.Errorf("GetFile() = %q, %v", buf.String(), err)
+	}
+
+	if err := e.GetFile(ctx, dir, io.Discard); !errors.Is(err, ErrNotRegularFile) {
+		t.Errorf("GetFile() of a folder = %v, want ErrNotRegularFile", err)
+	}
+	if err := e.GetFile(ctx, filepath.Join(dir, "missing"), io.Discard); err == nil {
+		t.Errorf("GetFile() of a missing file should fail")
+	}
+}
+
+func TestExecution_PutFile_notSupported(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	e, err := Start(context.Background(), r, "true", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer func() { _ = e.Wait() }()
+	_ = e.Stdin.Close()
+
+	if err := e.PutFile(context.Background(), "/tmp/x", strings.NewReader("x"), 0o644); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("PutFile() = %v, want ErrNotSupported", err)
+	}
+	if err := e.GetFile(context.Background(), "/tmp/x", io.Discard); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("GetFile() = %v, want ErrNotSupported", err)
+	}
+}
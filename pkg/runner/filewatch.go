@@ -0,0 +1,143 @@
+package runner
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ErrFileChangesOverflow is returned by FileWatcher.Stop when some changes
+// could not be recorded (e.g. the inotify queue overflowed). The returned
+// list of changes is incomplete in that case.
+var ErrFileChangesOverflow = errors.New("too many file changes: the list of changes is incomplete")
+
+// FileOp is the kind of change made to a file
+type FileOp string
+
+const (
+	// FileCreated is reported for files that did not exist before the execution
+	FileCreated FileOp = "created"
+	// FileModified is reported for existing files whose content or metadata changed
+	FileModified FileOp = "modified"
+	// FileDeleted is reported for existing files that were removed
+	FileDeleted FileOp = "deleted"
+)
+
+// FileChange is a change made to a file in a watched folder
+type FileChange struct {
+	// Path is the absolute path of the file
+	Path string `json:"path"`
+	// Op is the net change made to the file during the execution
+	Op FileOp `json:"op"`
+}
+
+// fileChangeReporter is implemented by runners that can report the files
+// changed in their writable folders (see the report_file_changes option)
+type fileChangeReporter interface {
+	// fileChangeFolders returns the folders to watch for the given params,
+	// or nil when reporting is disabled
+	fileChangeFolders(params map[string]interface{}) []string
+}
+
+// FileWatcher records the files created, modified and deleted in a set of
+// folders (and their subfolders) until it is stopped.
+//
+// On Linux it is implemented with inotify, so the cost does not depend on the
+// size of the watched trees. On other platforms the folders are scanned when
+// the watcher is created and when it is stopped.
+type FileWatcher struct {
+	logger  Logger
+	folders []string
+
+	mu       sync.Mutex
+	changes  map[string]FileOp
+	overflow bool
+
+	impl fileWatcherImpl
+}
+
+// fileWatcherImpl is the platform specific part of a FileWatcher
+type fileWatcherImpl interface {
+	// stop stops watching, recording the pending changes in the watcher
+	stop(w *FileWatcher)
+}
+
+// WatchFolders starts recording the changes made in the given folders.
+// Folders that do not exist are ignored.
+func WatchFolders(folders []string, logger Logger) (*FileWatcher, error) {
+	logger = defaultLogger(logger)
+
+	w := &FileWatcher{
+		logger:  logger,
+		changes: map[string]FileOp{},
+	}
+	for _, folder := range folders {
+		abs, err := filepath.Abs(folder)
+		if err != nil {
+			return nil, err
+		}
+		w.folders = append(w.folders, abs)
+	}
+
+	impl, err := startFileWatcher(w)
+	if err != nil {
+		return nil, err
+	}
+	w.impl = impl
+	return w, nil
+}
+
+// Stop stops watching and returns the changes, sorted by path.
+func (w *FileWatcher) Stop() ([]FileChange, error) {
+	w.impl.stop(w)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	changes := make([]FileChange, 0, len(w.changes))
+	for path, op := range w.changes {
+		changes = append(changes, FileChange{Path: path, Op: op})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+	if w.overflow {
+		return changes, ErrFileChangesOverflow
+	}
+	return changes, nil
+}
+
+// record merges a change into the net change of the file
+func (w *FileWatcher) record(path string, op FileOp) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	prev, seen := w.changes[path]
+	switch {
+	case !seen:
+		w.changes[path] = op
+	case prev == FileCreated && op == FileDeleted:
+		// temporary file: no net change
+		delete(w.changes, path)
+	case prev == FileCreated:
+		// still a new file
+	case prev == FileDeleted && op == FileCreated:
+		w.changes[path] = FileModified
+	default:
+		w.changes[path] = op
+	}
+}
+
+// watchFileChanges starts a watcher for the runner, if it reports file changes
+func watchFileChanges(r Runner, params map[string]interface{}, logger Logger) (*FileWatcher, error) {
+	fr, ok := r.(fileChangeReporter)
+	if !ok {
+		return nil, nil
+	}
+	folders := fr.fileChangeFolders(params)
+	if len(folders) == 0 {
+		return nil, nil
+	}
+	logger.Debug("Watching folders for file changes: %v", folders)
+	return WatchFolders(folders, logger)
+}
@@ -0,0 +1,163 @@
+//go:build linux
+
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// inotifyMask is the set of inotify events recorded by the watcher
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+// inotifyWatcher watches folders with inotify
+type inotifyWatcher struct {
+	file *os.File
+	fd   int
+
+	// dirs maps watch descriptors to the directory they watch
+	dirs map[int32]string
+
+	done chan struct{}
+}
+
+func startFileWatcher(w *FileWatcher) (fileWatcherImpl, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize inotify: %w", err)
+	}
+
+	iw := &inotifyWatcher{
+		// the descriptor is non-blocking, so reads go through the runtime poller
+		file: os.NewFile(uintptr(fd), "inotify"),
+		fd:   fd,
+		dirs: map[int32]string{},
+		done: make(chan struct{}),
+	}
+
+	for _, folder := range w.folders {
+		if _, err := os.Stat(folder); err != nil {
+			w.logger.Debug("Not watching missing folder %s: %v", folder, err)
+			continue
+		}
+		if err := iw.addTree(w, folder, false); err != nil {
+			_ = iw.file.Close()
+			return nil, err
+		}
+	}
+
+	go iw.loop(w)
+	return iw, nil
+}
+
+// addTree watches a directory and all its subdirectories. When report is
+// true, the files found are recorded as created (used for directories
+// created during the execution, whose content may predate the watch).
+func (iw *inotifyWatcher) addTree(w *FileWatcher, root string, report bool) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// the tree can change while it is walked
+			return nil
+		}
+		if !d.IsDir() {
+			if report {
+				w.record(path, FileCreated)
+			}
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(iw.fd, path, inotifyMask)
+		if err != nil {
+			if errors.Is(err, syscall.ENOSPC) {
+				return fmt.Errorf("failed to watch %s (inotify watch limit reached): %w", path, err)
+			}
+			w.logger.Debug("Failed to watch %s: %v", path, err)
+			return nil
+		}
+		iw.dirs[int32(wd)] = path
+		return nil
+	})
+}
+
+// loop reads events until the watcher is stopped
+func (iw *inotifyWatcher) loop(w *FileWatcher) {
+	defer close(iw.done)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := iw.file.Read(buf)
+		if err != nil {
+			return
+		}
+		iw.handle(w, buf[:n])
+	}
+}
+
+// drain processes the events queued in the kernel without waiting for more
+func (iw *inotifyWatcher) drain(w *FileWatcher) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(iw.fd, buf)
+		if err != nil || n <= 0 {
+			return
+		}
+		iw.handle(w, buf[:n])
+	}
+}
+
+// handle records the events in buf
+func (iw *inotifyWatcher) handle(w *FileWatcher, buf []byte) {
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+		offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+		if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+			w.mu.Lock()
+			w.overflow = true
+			w.mu.Unlock()
+			continue
+		}
+
+		dir, ok := iw.dirs[event.Wd]
+		if !ok || len(nameBytes) == 0 {
+			continue
+		}
+		// the name is padded with NUL bytes
+		path := filepath.Join(dir, string(bytes.TrimRight(nameBytes, "\x00")))
+
+		if event.Mask&syscall.IN_ISDIR != 0 {
+			// only files are reported, but new directories must be watched
+			if event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				if err := iw.addTree(w, path, true); err != nil {
+					w.logger.Debug("Failed to watch new directory %s: %v", path, err)
+				}
+			}
+			continue
+		}
+
+		switch {
+		case event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+			w.record(path, FileCreated)
+		case event.Mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+			w.record(path, FileDeleted)
+		default:
+			w.record(path, FileModified)
+		}
+	}
+}
+
+func (iw *inotifyWatcher) stop(w *FileWatcher) {
+	// wake up the reader, and process whatever is still queued once it is gone
+	_ = iw.file.SetReadDeadline(time.Now())
+	<-iw.done
+	iw.drain(w)
+	_ = iw.file.Close()
+}
@@ -0,0 +1,60 @@
+//go:build !linux
+
+package runner
+
+import (
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+// fileState is the state of a file recorded in a snapshot
+type fileState struct {
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode
+}
+
+// snapshotWatcher detects changes by comparing snapshots of the folders
+type snapshotWatcher struct {
+	before map[string]fileState
+}
+
+func startFileWatcher(w *FileWatcher) (fileWatcherImpl, error) {
+	return &snapshotWatcher{before: snapshotFolders(w.folders)}, nil
+}
+
+func (sw *snapshotWatcher) stop(w *FileWatcher) {
+	after := snapshotFolders(w.folders)
+	for path, state := range after {
+		prev, ok := sw.before[path]
+		switch {
+		case !ok:
+			w.record(path, FileCreated)
+		case prev != state:
+			w.record(path, FileModified)
+		}
+	}
+	for path := range sw.before {
+		if _, ok := after[path]; !ok {
+			w.record(path, FileDeleted)
+		}
+	}
+}
+
+// snapshotFolders returns the state of all the files in the folders
+func snapshotFolders(folders []string) map[string]fileState {
+	snapshot := map[string]fileState{}
+	for _, folder := range folders {
+		_ = filepath.WalkDir(folder, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				snapshot[path] = fileState{size: info.Size(), modTime: info.ModTime(), mode: info.Mode()}
+			}
+			return nil
+		})
+	}
+	return snapshot
+}
@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// TestWatchFolders tests the net changes reported by a FileWatcher
+func TestWatchFolders(t *testing.T) {
+	dir := t.TempDir()
+
+	modified := filepath.Join(dir, "modified.txt")
+	deleted := filepath.Join(dir, "deleted.txt")
+	for _, f := range []string{modified, deleted} {
+		if err := os.WriteFile(f, []byte("before"), 0o644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	w, err := WatchFolders([]string{dir}, nil)
+	if err != nil {
+		t.Fatalf("WatchFolders failed: %v", err)
+	}
+
+	created := filepath.Join(dir, "sub", "created.txt")
+	temporary := filepath.Join(dir, "temporary.txt")
+
+	if err := os.WriteFile(modified, []byte("after, with a different size"), 0o644); err != nil {
+		t.Fatalf("Failed to modify file: %v", err)
+	}
+	if err := os.Remove(deleted); err != nil {
+		t.Fatalf("Failed to delete file: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(created), 0o755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(created, []byte("new"), 0o644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.WriteFile(temporary, []byte("tmp"), 0o644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := os.Remove(temporary); err != nil {
+		t.Fatalf("Failed to delete file: %v", err)
+	}
+
+	changes, err := w.Stop()
+	if err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	expected := []FileChange{
+		{Path: deleted, Op: FileDeleted},
+		{Path: modified, Op: FileModified},
+		{Path: created, Op: FileCreated},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected changes %v, got %v", expected, changes)
+	}
+}
+
+// reportingExec is an Exec runner reporting the changes in a folder
+type reportingExec struct {
+	*Exec
+	folder string
+}
+
+func (r *reportingExec) fileChangeFolders(params map[string]interface{}) []string {
+	return []string{r.folder}
+}
+
+// TestStart_FileChanges tests that file changes are available after Wait
+func TestStart_FileChanges(t *testing.T) {
+	dir := t.TempDir()
+
+	logger, _ := common.NewLogger("", "", common.LogLevelDebug, false)
+	exec, err := NewExec(Options{}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+
+	output := filepath.Join(dir, "output.txt")
+	e, err := Start(context.Background(), &reportingExec{Exec: exec, folder: dir},
+		"sh", []string{"-c", "echo hello > " + output}, nil, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	_ = e.Stdin.Close()
+	_, _ = io.ReadAll(e.Stdout)
+	_, _ = io.ReadAll(e.Stderr)
+	if err := e.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	changes, err := e.FileChanges()
+	if err != nil {
+		t.Fatalf("FileChanges failed: %v", err)
+	}
+	expected := []FileChange{{Path: output, Op: FileCreated}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected changes %v, got %v", expected, changes)
+	}
+}
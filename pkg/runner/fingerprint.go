@@ -0,0 +1,162 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+)
+
+// fingerprintVersion is included in every fingerprint, and must be increased
+// whenever the way options are hashed changes
+const fingerprintVersion = "v4"
+
+// Fingerprinter is implemented by runners that can identify their effective
+// policy. All the runners in this package implement it.
+type Fingerprinter interface {
+	// Fingerprint returns a stable hash of the runner type and its parsed
+	// options (including defaults). Two runners with the same fingerprint
+	// apply the same restrictions, and the fingerprint changes whenever
+	// an option that affects the policy changes.
+	Fingerprint() string
+}
+
+// Fingerprint returns the fingerprint of a runner, or an empty string if
+// the runner does not implement Fingerprinter
+func Fingerprint(r Runner) string {
+	if f, ok := r.(Fingerprinter); ok {
+		return f.Fingerprint()
+	}
+	return ""
+}
+
+// envFilesFingerprinter is implemented by the options embedding
+// EnvFileOptions
+type envFilesFingerprinter interface {
+	envFilesFingerprint() []string
+}
+
+// seccompFingerprinter is implemented by the options embedding
+// SeccompOptions
+type seccompFingerprinter interface {
+	seccompFingerprint() *seccompProfile
+}
+
+// caBundleFingerprinter is implemented by the options embedding
+// CABundleOptions
+type caBundleFingerprinter interface {
+	caBundleFiles() []string
+}
+
+// hermeticToolsFingerprinter is implemented by the options embedding
+// PathOptions
+type hermeticToolsFingerprinter interface {
+	hermeticToolFiles() []string
+}
+
+// policyFilesFingerprinter is implemented by the options of the runners
+// reading other files in the host (e.g. custom_profile)
+type policyFilesFingerprinter interface {
+	policyFiles() []string
+}
+
+// fingerprint hashes the type and the options of a runner. The options are
+// encoded as JSON, whose object keys are always sorted, so the result does
+// not depend on the order of the options map the runner was created from.
+//
+// The contents of the files read when the runner is created (the variables
+// of the env files and the seccomp profile) and of the files read when the
+// commands run (the CA bundle, the hermetic tools, the custom profiles and
+// the requirements files) are hashed too, as they are not in the encoding of
+// the options but change the policy as well.
+func fingerprint(t Type, options interface{}) string {
+	resolved := struct {
+		EnvFiles []string          `json:"env_files,omitempty"`
+		Seccomp  *seccompProfile   `json:"seccomp,omitempty"`
+		Files    map[string]string `json:"files,omitempty"`
+	}{}
+	if o, ok := options.(envFilesFingerprinter); ok {
+		resolved.EnvFiles = o.envFilesFingerprint()
+	}
+	if o, ok := options.(seccompFingerprinter); ok {
+		resolved.Seccomp = o.seccompFingerprint()
+	}
+	var files []string
+	if o, ok := options.(caBundleFingerprinter); ok {
+		files = append(files, o.caBundleFiles()...)
+	}
+	if o, ok := options.(hermeticToolsFingerprinter); ok {
+		files = append(files, o.hermeticToolFiles()...)
+	}
+	if o, ok := options.(policyFilesFingerprinter); ok {
+		files = append(files, o.policyFiles()...)
+	}
+	resolved.Files = hashFiles(files)
+	data, err := json.Marshal(struct {
+		Version  string      `json:"version"`
+		Type     Type        `json:"type"`
+		Options  interface{} `json:"options"`
+		Resolved interface{} `json:"resolved"`
+	}{fingerprintVersion, t, options, resolved})
+	if err != nil {
+		// options are always plain structs, so this cannot happen
+		panic("runner: cannot encode options for fingerprint: " + err.Error())
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashFiles returns the SHA-256 of the contents of the files, or an empty
+// string for the files that cannot be read
+func hashFiles(paths []string) map[string]string {
+	if len(paths) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(paths))
+	for _, path := range paths {
+		res[path] = ""
+		if data, err := os.ReadFile(path); err == nil {
+			sum := sha256.Sum256(data)
+			res[path] = hex.EncodeToString(sum[:])
+		}
+	}
+	return res
+}
+
+// Fingerprint implements the Fingerprinter interface
+func (r *Exec) Fingerprint() string { return fingerprint(TypeExec, r.options) }
+
+// Fingerprint implements the Fingerprinter interface
+func (r *SandboxExec) Fingerprint() string { return fingerprint(TypeSandboxExec, r.options) }
+
+// Fingerprint implements the Fingerprinter interface
+func (r *Firejail) Fingerprint() string { return fingerprint(TypeFirejail, r.options) }
+
+// Fingerprint implements the Fingerprinter interface
+func (r *Landrun) Fingerprint() string { return fingerprint(TypeLandrun, r.options) }
+
+// Fingerprint implements the Fingerprinter interface
+func (r *Nsjail) Fingerprint() string { return fingerprint(TypeNsjail, r.options) }
+
+// Fingerprint implements the Fingerprinter interface
+func (r *Docker) Fingerprint() string { return fingerprint(r.opts.runnerType(), r.opts) }
+
+// Fingerprint implements the Fingerprinter interface
+func (r *ADB) Fingerprint() string { return fingerprint(TypeADB, r.options) }
+
+// Fingerprint implements the Fingerprinter interface
+func (r *Proot) Fingerprint() string { return fingerprint(TypeProot, r.options) }
+
+// Fingerprint implements the Fingerprinter interface
+func (r *Deno) Fingerprint() string { return fingerprint(TypeDeno, r.options) }
+
+// Fingerprint implements the Fingerprinter interface
+func (r *Python) Fingerprint() string { return fingerprint(TypePython, r.options) }
+
+// Fingerprint implements the Fingerprinter interface
+func (r *WindowsRestricted) Fingerprint() string {
+	return fingerprint(TypeWindowsRestricted, r.options)
+}
+
+// Fingerprint implements the Fingerprinter interface
+func (r *Unshare) Fingerprint() string { return fingerprint(TypeUnshare, r.options) }
@@ -0,0 +1,192 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+func TestFingerprint(t *testing.T) {
+	logger, _ := common.NewLogger("test-fingerprint: ", "", common.LogLevelInfo, false)
+
+	newFirejail := func(options Options) *Firejail {
+		r, err := NewFirejail(options, logger)
+		if err != nil {
+			t.Fatalf("NewFirejail() error = %v", err)
+		}
+		return r
+	}
+
+	base := Options{
+		"allow_networking":    false,
+		"allow_read_folders":  []string{"/usr", "{{.home}}/data"},
+		"allow_write_folders": []string{"/tmp"},
+	}
+
+	t.Run("stable", func(t *testing.T) {
+		fp := newFirejail(base).Fingerprint()
+		if len(fp) != 64 {
+			t.Errorf("Fingerprint() = %q, want a sha256 hex digest", fp)
+		}
+		if again := newFirejail(base).Fingerprint(); again != fp {
+			t.Errorf("Fingerprint() = %q, want %q for the same options", again, fp)
+		}
+	})
+
+	t.Run("options changes", func(t *testing.T) {
+		fp := newFirejail(base).Fingerprint()
+		changed := Options{
+			"allow_networking":    true,
+			"allow_read_folders":  []string{"/usr", "{{.home}}/data"},
+			"allow_write_folders": []string{"/tmp"},
+		}
+		if other := newFirejail(changed).Fingerprint(); other == fp {
+			t.Errorf("Fingerprint() did not change with allow_networking")
+		}
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		// options set to their default values do not change the policy
+		explicit := Options{
+			"allow_networking":    false,
+			"allow_user_folders":  false,
+			"allow_read_folders":  []string{"/usr", "{{.home}}/data"},
+			"allow_write_folders": []string{"/tmp"},
+		}
+		if newFirejail(explicit).Fingerprint() != newFirejail(base).Fingerprint() {
+			t.Errorf("Fingerprint() changed with options set to their defaults")
+		}
+	})
+
+	t.Run("not modified by templates", func(t *testing.T) {
+		r := newFirejail(base)
+		fp := r.Fingerprint()
+
+		opts := r.profileOptions(map[string]interface{}{"home": "/home/user"})
+		if !contains(opts.AllowReadFolders, "/home/user/data") {
+			t.Errorf("profileOptions() read folders = %v, want the template processed", opts.AllowReadFolders)
+		}
+		if r.Fingerprint() != fp {
+			t.Errorf("Fingerprint() changed after processing templates")
+		}
+	})
+
+	t.Run("contents of the files", func(t *testing.T) {
+		dir := t.TempDir()
+		envFile := filepath.Join(dir, "vars.env")
+		profile := filepath.Join(dir, "seccomp.json")
+		write := func(path, content string) {
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatalf("failed to write %s: %v", path, err)
+			}
+		}
+		newExec := func() string {
+			r, err := NewExec(Options{"env_files": []string{envFile}, "seccomp_profile": profile}, logger)
+			if err != nil {
+				t.Fatalf("NewExec() error = %v", err)
+			}
+			return r.Fingerprint()
+		}
+
+		write(envFile, "TOKEN=one\n")
+		write(profile, `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["ptrace"], "action": "SCMP_ACT_ERRNO"}]}`)
+		fp := newExec()
+
+		write(envFile, "TOKEN=two\n")
+		if other := newExec(); other == fp {
+			t.Errorf("Fingerprint() did not change with the variables of the env file")
+		}
+		write(envFile, "TOKEN=one\n")
+		write(profile, `{"defaultAction": "SCMP_ACT_ALLOW"}`)
+		if other := newExec(); other == fp {
+			t.Errorf("Fingerprint() did not change with the seccomp profile")
+		}
+	})
+
+	t.Run("contents of the files read by the commands", func(t *testing.T) {
+		dir := t.TempDir()
+		read := func(path string) string {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", path, err)
+			}
+			return string(data)
+		}
+		write := func(path, content string) {
+			if err := os.WriteFile(path, []byte(content), 0o755); err != nil {
+				t.Fatalf("failed to write %s: %v", path, err)
+			}
+		}
+		caBundle := filepath.Join(dir, "ca.pem")
+		otherCA := read(writeTestCABundle(t))
+		write(caBundle, read(writeTestCABundle(t)))
+		tool := filepath.Join(dir, "tool")
+		write(tool, "#!/bin/sh\necho one\n")
+		include := filepath.Join(dir, "extra.inc")
+		write(include, "net none\n")
+		imported := filepath.Join(dir, "extra.sb")
+		write(imported, "(deny network*)\n")
+		requirements := filepath.Join(dir, "requirements.txt")
+		write(requirements, "requests==2.31.0\n")
+
+		newRunners := func() []Runner {
+			exec, err := NewExec(Options{"ca_bundle": caBundle, "hermetic_tools": []string{tool}}, logger)
+			if err != nil {
+				t.Fatalf("NewExec() error = %v", err)
+			}
+			sandbox, err := NewSandboxExec(Options{"custom_profile": "(version 1)\n(import \"" + imported + "\")\n"}, logger)
+			if err != nil {
+				t.Fatalf("NewSandboxExec() error = %v", err)
+			}
+			python, err := NewPython(Options{"requirements_file": requirements}, logger)
+			if err != nil {
+				t.Fatalf("NewPython() error = %v", err)
+			}
+			return []Runner{exec, newFirejail(Options{"custom_profile": "include " + include + "\n"}), sandbox, python}
+		}
+		fingerprints := func() []string {
+			var res []string
+			for _, r := range newRunners() {
+				res = append(res, Fingerprint(r))
+			}
+			return res
+		}
+
+		for _, tt := range []struct {
+			name    string
+			path    string
+			content string
+			runner  int
+		}{
+			{"ca_bundle", caBundle, otherCA, 0},
+			{"hermetic_tools", tool, "#!/bin/sh\necho two\n", 0},
+			{"custom_profile include", include, "net none\nnoroot\n", 1},
+			{"custom_profile import", imported, "(deny network* file-write*)\n", 2},
+			{"requirements_file", requirements, "requests==2.32.0\n", 3},
+		} {
+			before := fingerprints()
+			original := read(tt.path)
+			write(tt.path, tt.content)
+			if after := fingerprints(); after[tt.runner] == before[tt.runner] {
+				t.Errorf("Fingerprint() did not change with the contents of the %s file", tt.name)
+			}
+			write(tt.path, original)
+		}
+	})
+
+	t.Run("runner types", func(t *testing.T) {
+		exec, err := NewExec(Options{}, logger)
+		if err != nil {
+			t.Fatalf("NewExec() error = %v", err)
+		}
+		landrun, err := NewLandrun(Options{}, logger)
+		if err != nil {
+			t.Fatalf("NewLandrun() error = %v", err)
+		}
+		if Fingerprint(exec) == Fingerprint(landrun) {
+			t.Errorf("Fingerprint() is the same for different runner types")
+		}
+	})
+}
@@ -5,11 +5,11 @@ import (
 	"context"
 	_ "embed"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"text/template"
@@ -20,9 +20,23 @@ import (
 //go:embed firejail_profile.tpl
 var firejailProfileTemplate string
 
+// exitCodeFirejailSeccomp is the exit code of firejail when the command is
+// killed by its seccomp filter (with SIGSYS, 31 on Linux), as firejail exits
+// with 128 plus the signal of the commands killed by a signal
+const exitCodeFirejailSeccomp = exitCodeSignaled + 31
+
+// firejailExitCodes are the codes of firejail, which exits with the status of
+// the command run in its shell. Its own errors exit with 1, and cannot be told
+// apart from the commands failing.
+var firejailExitCodes = exitCodeTable{
+	exitCodeCannotExecute:   ErrorKindPermissionDenied,
+	exitCodeNotFound:        ErrorKindNotFound,
+	exitCodeFirejailSeccomp: ErrorKindPermissionDenied,
+}
+
 // Firejail implements the Runner interface using firejail on Linux
 type Firejail struct {
-	logger     *common.Logger
+	logger     Logger
 	profileTpl *template.Template
 	options    FirejailOptions
 }
@@ -37,6 +51,62 @@ type FirejailOptions struct {
 	AllowReadFiles    []string `json:"allow_read_files"`
 	AllowWriteFiles   []string `json:"allow_write_files"`
 	CustomProfile     string   `json:"custom_profile"`
+
+	// ReportFileChanges records the files changed in AllowWriteFolders (see Execution.FileChanges)
+	ReportFileChanges bool `json:"report_file_changes"`
+
+	// Umask and file mode policy
+	FileModeOptions
+
+	// Core dump policy
+	CoreDumpOptions
+
+	// Sandbox verification with canaries
+	CanaryOptions
+
+	// Command run in the sandbox before the commands
+	PreflightOptions
+
+	// Folders searched for executables
+	PathOptions
+
+	// Clock and locale settings
+	DeterminismOptions
+
+	// Variables of the commands read from .env files
+	EnvFileOptions
+
+	// Experimental features
+	ExperimentalOptions
+
+	// CA certificates trusted by the command
+	CABundleOptions
+
+	// Access to the credential agents of the host
+	AgentOptions
+
+	// Access to the display server of the host
+	DisplayOptions
+
+	// Desktop devices and portals
+	DeviceOptions
+
+	// Hostname aliases, passed with --hosts-file
+	HostsOptions
+
+	// Namespaces of the command. Firejail always runs commands in a new
+	// PID namespace, so private_pids is implied, and the other options are
+	// passed with --read-only, --ipc-namespace and --hostname.
+	NamespaceOptions
+
+	// System calls of the commands, passed with the seccomp lines of the profile
+	SeccompOptions
+
+	// Timeout of the commands
+	TimeoutOptions
+
+	// Limits of the output of the commands
+	OutputLimitOptions
 }
 
 // NewFirejailOptions creates a new FirejailOptions from Options
@@ -52,10 +122,8 @@ func NewFirejailOptions(options Options) (FirejailOptions, error) {
 
 // NewFirejail creates a new Firejail runner with the provided logger.
 // If logger is nil, a default logger is created.
-func NewFirejail(options Options, logger *common.Logger) (*Firejail, error) {
-	if logger == nil {
-		logger = common.GetLogger()
-	}
+func NewFirejail(options Options, logger Logger) (*Firejail, error) {
+	logger = defaultLogger(logger)
 
 	// Parse the firejail profile template
 	profileTpl, err := template.New("firejail-profile").Parse(firejailProfileTemplate)
@@ -70,6 +138,52 @@ func NewFirejail(options Options, logger *common.Logger) (*Firejail, error) {
 		logger.Debug("Failed to parse firejail options: %v", err)
 		return nil, fmt.Errorf("failed to parse firejail options: %w", err)
 	}
+	firejailOpts.normalizeRules()
+	if err := firejailOpts.validate(); err != nil {
+		return nil, fmt.Errorf("invalid firejail options: %w", err)
+	}
+	if err := firejailOpts.validateHosts(); err != nil {
+		return nil, fmt.Errorf("invalid firejail options: %w", err)
+	}
+	if err := firejailOpts.loadSeccompProfile(); err != nil {
+		return nil, fmt.Errorf("invalid firejail options: %w", err)
+	}
+	if _, err := firejailOpts.firejailSeccomp(firejailOpts.AllowKeyring); err != nil {
+		return nil, fmt.Errorf("invalid firejail options: %w", err)
+	}
+	if err := firejailOpts.resolveFakeTime(); err != nil {
+		return nil, fmt.Errorf("invalid firejail options: %w", err)
+	}
+	if err := firejailOpts.validateCABundle(); err != nil {
+		return nil, fmt.Errorf("invalid firejail options: %w", err)
+	}
+	if err := firejailOpts.validateTimeouts(); err != nil {
+		return nil, fmt.Errorf("invalid firejail options: %w", err)
+	}
+	if err := firejailOpts.loadEnvFiles(); err != nil {
+		return nil, fmt.Errorf("invalid firejail options: %w", err)
+	}
+	if err := firejailOpts.validateOutputLimits(); err != nil {
+		return nil, fmt.Errorf("invalid firejail options: %w", err)
+	}
+	if err := firejailOpts.validateNamespaces(); err != nil {
+		return nil, fmt.Errorf("invalid firejail options: %w", err)
+	}
+	if err := firejailOpts.validateCoreDumps(); err != nil {
+		return nil, fmt.Errorf("invalid firejail options: %w", err)
+	}
+	if err := firejailOpts.validateCanaries(); err != nil {
+		return nil, fmt.Errorf("invalid firejail options: %w", err)
+	}
+	if err := firejailOpts.validatePreflight(); err != nil {
+		return nil, fmt.Errorf("invalid firejail options: %w", err)
+	}
+	if err := firejailOpts.validateExtraPath(); err != nil {
+		return nil, fmt.Errorf("invalid firejail options: %w", err)
+	}
+	if err := firejailOpts.validatePinnedExecutables(); err != nil {
+		return nil, fmt.Errorf("invalid firejail options: %w", err)
+	}
 
 	return &Firejail{
 		logger:     logger,
@@ -86,6 +200,22 @@ func (r *Firejail) Run(ctx context.Context,
 	shell string, command string,
 	env []string, params map[string]interface{}, tmpfile bool,
 ) (string, error) {
+	return r.options.runWithTimeout(ctx, func(ctx context.Context) (string, error) {
+		return r.run(ctx, shell, command, env, params, tmpfile)
+	})
+}
+
+// run is Run, with the context bounded by the timeout options
+func (r *Firejail) run(ctx context.Context,
+	shell string, command string,
+	env []string, params map[string]interface{}, tmpfile bool,
+) (string, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+	env = r.options.pathEnv(env)
+	env = r.options.caBundleEnv(env, r.options.CABundle)
+
 	fullCmd := command
 
 	// Check if context is done
@@ -96,74 +226,97 @@ func (r *Firejail) Run(ctx context.Context,
 		// Continue execution
 	}
 
-	// replace template variables in allow read and write folders and files
-	if len(r.options.AllowReadFolders) > 0 {
-		r.options.AllowReadFolders = common.ProcessTemplateListFlexible(r.options.AllowReadFolders, params)
+	if err := r.options.verifySandbox(ctx, logger, r.canaryPolicy(ctx, params), r.start, params); err != nil {
+		return "", err
 	}
-	if len(r.options.AllowWriteFolders) > 0 {
-		r.options.AllowWriteFolders = common.ProcessTemplateListFlexible(r.options.AllowWriteFolders, params)
+	if err := r.options.runPreflight(ctx, logger, "/bin/sh", r.start, params); err != nil {
+		return "", err
 	}
-	if len(r.options.AllowReadFiles) > 0 {
-		r.options.AllowReadFiles = common.ProcessTemplateListFlexible(r.options.AllowReadFiles, params)
+
+	toolsDir, removeTools, err := r.options.linkHermeticTools(logger)
+	if err != nil {
+		return "", err
 	}
-	if len(r.options.AllowWriteFiles) > 0 {
-		r.options.AllowWriteFiles = common.ProcessTemplateListFlexible(r.options.AllowWriteFiles, params)
+	defer removeTools()
+	env = hermeticEnv(env, toolsDir)
+	if err := r.options.verifyPinnedExecutables(logger, command); err != nil {
+		return "", err
 	}
 
+	// replace template variables in allow read and write folders and files
+	profileOpts := r.profileOptions(params)
+
 	// Generate the profile by rendering the template
 	var profileBuf bytes.Buffer
-	if err := r.profileTpl.Execute(&profileBuf, r.options); err != nil {
-		r.logger.Debug("Failed to render firejail profile template: %v", err)
+	if err := r.profileTpl.Execute(&profileBuf, profileOpts); err != nil {
+		logger.Debug("Failed to render firejail profile template: %v", err)
 		return "", fmt.Errorf("failed to render firejail profile: %w", err)
 	}
 
 	profile := profileBuf.String()
-	r.logger.Debug("Firejail options: %+v", r.options)
-	r.logger.Debug("Generated firejail profile: %s", profile)
+	logger.Debug("Firejail options: %+v", profileOpts)
+	logger.Debug("Generated firejail profile: %s", profile)
 
 	// Create a temporary file for the firejail profile
 	profileFile, err := os.CreateTemp("", "firejail-profile-*.profile")
 	if err != nil {
-		r.logger.Debug("Failed to create temporary profile file: %v", err)
+		logger.Debug("Failed to create temporary profile file: %v", err)
 		return "", fmt.Errorf("failed to create temporary profile file: %w", err)
 	}
 	profileFilePath := profileFile.Name()
 	defer func() {
 		if err := os.Remove(profileFilePath); err != nil {
-			r.logger.Debug("Warning: failed to remove temporary profile file: %v", err)
+			logger.Debug("Warning: failed to remove temporary profile file: %v", err)
 		}
 	}()
 
 	// Write the profile to the temporary file
 	if _, err := profileFile.WriteString(profile); err != nil {
-		r.logger.Debug("Failed to write profile to temporary file: %v", err)
+		logger.Debug("Failed to write profile to temporary file: %v", err)
 		return "", fmt.Errorf("failed to write profile to temporary file: %w", err)
 	}
 
 	// Flush data to ensure it's written to disk
 	if err := profileFile.Sync(); err != nil {
-		r.logger.Debug("Failed to sync profile file: %v", err)
+		logger.Debug("Failed to sync profile file: %v", err)
 		return "", fmt.Errorf("failed to sync profile file: %w", err)
 	}
 
 	// Close the profile file before executing firejail
 	// This is critical to avoid "Text file busy" errors
 	if err := profileFile.Close(); err != nil {
-		r.logger.Debug("Failed to close profile file: %v", err)
+		logger.Debug("Failed to close profile file: %v", err)
 		return "", fmt.Errorf("failed to close profile file: %w", err)
 	}
 
+	jailArgs := []string{"--profile=" + profileFilePath}
+
+	hostsFile, err := r.options.writeHostsFile("/etc/hosts")
+	if err != nil {
+		return "", err
+	}
+	if hostsFile != "" {
+		defer func() {
+			if err := os.Remove(hostsFile); err != nil {
+				logger.Debug("Warning: failed to remove hosts file: %v", err)
+			}
+		}()
+		jailArgs = append(jailArgs, "--hosts-file="+hostsFile)
+	}
+	jailArgs = append(jailArgs, r.sessionArgs()...)
+	jailArgs = append(jailArgs, profileOpts.readOnlyRootArgs()...)
+
 	var execCmd *exec.Cmd
 
 	// Check if we can optimize by running a single executable directly
-	if isSingleExecutableCommand(fullCmd) {
-		r.logger.Debug("Optimization: running single executable command directly: %s", fullCmd)
-		execCmd = exec.CommandContext(ctx, "firejail", "--profile="+profileFilePath, fullCmd)
+	if r.options.directCommand(fullCmd) {
+		logger.Debug("Optimization: running single executable command directly: %s", fullCmd)
+		execCmd = commandContext(ctx, r.options.lookPath("firejail"), append(jailArgs, fullCmd)...)
 	} else {
 		// Create a temporary file for the command
 		tmpScript, err := os.CreateTemp("", "firejail-command-*.sh")
 		if err != nil {
-			r.logger.Debug("Failed to create temporary command file: %v", err)
+			logger.Debug("Failed to create temporary command file: %v", err)
 			return "", fmt.Errorf("failed to create temporary command file: %w", err)
 		}
 		tmpScriptPath := tmpScript.Name()
@@ -171,38 +324,38 @@ func (r *Firejail) Run(ctx context.Context,
 		// Ensure temporary file is deleted when this function exits
 		defer func() {
 			if err := os.Remove(tmpScriptPath); err != nil {
-				r.logger.Debug("Warning: failed to remove temporary script file: %v", err)
+				logger.Debug("Warning: failed to remove temporary script file: %v", err)
 			}
 		}()
 
 		// Write the command to the temporary file
 		if _, err := tmpScript.WriteString(fullCmd); err != nil {
 			_ = tmpScript.Close()
-			r.logger.Debug("Failed to write command to temporary file: %v", err)
+			logger.Debug("Failed to write command to temporary file: %v", err)
 			return "", fmt.Errorf("failed to write command to temporary file: %w", err)
 		}
 
 		// Flush data to ensure it's written to disk
 		if err := tmpScript.Sync(); err != nil {
 			_ = tmpScript.Close()
-			r.logger.Debug("Failed to sync script file: %v", err)
+			logger.Debug("Failed to sync script file: %v", err)
 			return "", fmt.Errorf("failed to sync script file: %w", err)
 		}
 
 		// Close the file before making it executable and running it
 		// This is critical to avoid "Text file busy" errors with firejail
 		if err := tmpScript.Close(); err != nil {
-			r.logger.Debug("Failed to close script file: %v", err)
+			logger.Debug("Failed to close script file: %v", err)
 			return "", fmt.Errorf("failed to close script file: %w", err)
 		}
 
 		// Make the temporary file executable
 		if err := os.Chmod(tmpScriptPath, 0o700); err != nil {
-			r.logger.Debug("Failed to make temporary file executable: %v", err)
+			logger.Debug("Failed to make temporary file executable: %v", err)
 			return "", fmt.Errorf("failed to make temporary file executable: %w", err)
 		}
 
-		execCmd = exec.CommandContext(ctx, "firejail", "--profile="+profileFilePath, tmpScriptPath)
+		execCmd = commandContext(ctx, r.options.lookPath("firejail"), append(jailArgs, tmpScriptPath)...)
 	}
 
 	// Check if context is done
@@ -213,46 +366,59 @@ func (r *Firejail) Run(ctx context.Context,
 		// Continue execution
 	}
 
-	r.logger.Debug("Created command: %s", execCmd.String())
+	logger.Debug("Created command: %s", execCmd.String())
 
 	// Set environment variables if provided
 	if len(env) > 0 {
-		r.logger.Debug("Adding %d environment variables to command", len(env))
+		logger.Debug("Adding %d environment variables to command", len(env))
 		for _, e := range env {
-			r.logger.Debug("... adding environment variable: %s", e)
+			categoryLogger(logger, LogCategoryEnvironment).Debug("... adding environment variable: %s", e)
 		}
 		execCmd.Env = append(os.Environ(), env...)
 	}
+	execCmd.Env = r.options.agentEnv(execCmd.Env)
+	execCmd.Env = r.options.scrubDisplayEnv(execCmd.Env)
+
+	applyUmask(logger, execCmd, r.options.Umask)
+	defer r.options.applyCoreDumps(logger, execCmd)()
 
 	// Capture output
 	var stdout, stderr bytes.Buffer
-	execCmd.Stdout = &stdout
-	execCmd.Stderr = &stderr
+	execCmd.Stdout, execCmd.Stderr = captureRunOutput(ctx, &stdout, &stderr)
+	var truncated func() error
+	execCmd.Stdout, execCmd.Stderr, truncated = r.options.limitOutput(ctx, execCmd.Stdout, execCmd.Stderr)
 
 	// Run the command
-	r.logger.Debug("Executing command")
+	logger.Debug("Executing command")
+	defer r.options.normalizeFileModes(logger, profileOpts.AllowWriteFolders)
 
 	if err := execCmd.Run(); err != nil {
 		// If there's error output, include it in the error
 		if stderr.Len() > 0 {
 			errMsg := strings.TrimSpace(stderr.String())
-			r.logger.Debug("Command failed with stderr: %s", errMsg)
-			return "", errors.New(errMsg)
+			logger.Debug("Command failed with stderr: %s", errMsg)
+			return "", newCommandError(errMsg, err)
 		}
-		r.logger.Debug("Command failed with error: %v", err)
+		logger.Debug("Command failed with error: %v", err)
 		return "", err
 	}
 
 	// Get the output
 	outputStr := strings.TrimSpace(stdout.String())
 
-	r.logger.Debug("Command executed successfully, output length: %d bytes", len(outputStr))
+	logger.Debug("Command executed successfully, output length: %d bytes", len(outputStr))
 	if stderr.Len() > 0 {
-		r.logger.Debug("Command generated stderr (but no error): %s", strings.TrimSpace(stderr.String()))
+		logger.Debug("Command generated stderr (but no error): %s", strings.TrimSpace(stderr.String()))
 	}
 
 	// Return the stdout output
-	return outputStr, nil
+	return outputStr, truncated()
+}
+
+// RunEx executes a command within the firejail sandbox like Run, returning its exit code and
+// both output streams. It implements the Runner interface.
+func (r *Firejail) RunEx(ctx context.Context, req RunRequest) (*RunResult, error) {
+	return runEx(ctx, r, req)
 }
 
 // RunWithPipes executes a command with access to stdin/stdout/stderr pipes within the firejail sandbox.
@@ -267,158 +433,291 @@ func (r *Firejail) RunWithPipes(ctx context.Context, cmd string, args []string,
 	wait func() error,
 	err error,
 ) {
+	e, err := r.start(ctx, cmd, args, env, params)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return e.Stdin, e.Stdout, e.Stderr, e.Wait, nil
+}
+
+// start executes a command in the firejail sandbox and returns an execution handle for it.
+// It is used by RunWithPipes and Start.
+func (r *Firejail) start(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	return r.options.startWithTimeout(ctx, func(ctx context.Context) (*Execution, error) {
+		return r.startCommand(ctx, cmd, args, env, params)
+	})
+}
+
+// startCommand is start, with the context bounded by the timeout options
+func (r *Firejail) startCommand(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+	env = r.options.pathEnv(env)
+	env = r.options.caBundleEnv(env, r.options.CABundle)
+
 	// Check if context is already done
 	select {
 	case <-ctx.Done():
-		return nil, nil, nil, nil, ctx.Err()
+		return nil, ctx.Err()
 	default:
 		// Continue execution
 	}
 
-	r.logger.Debug("RunWithPipes: executing command in firejail: %s with args: %v", cmd, args)
+	if err := r.options.verifySandbox(ctx, logger, r.canaryPolicy(ctx, params), r.start, params); err != nil {
+		return nil, err
+	}
+	if err := r.options.runPreflight(ctx, logger, "/bin/sh", r.start, params); err != nil {
+		return nil, err
+	}
 
-	// Process template variables in allow read and write folders and files
-	if len(r.options.AllowReadFolders) > 0 {
-		r.options.AllowReadFolders = common.ProcessTemplateListFlexible(r.options.AllowReadFolders, params)
+	// the hermetic tools are removed once the command completes (see startProcess)
+	toolsDir, removeTools, err := r.options.linkHermeticTools(logger)
+	if err != nil {
+		return nil, err
+	}
+	started := false
+	defer func() {
+		if !started {
+			removeTools()
+		}
+	}()
+	env = hermeticEnv(env, toolsDir)
+	if cmd, err = r.options.hermeticCommand(ctx, toolsDir, cmd); err != nil {
+		return nil, err
+	}
+	if err := r.options.verifyPinnedExecutables(logger, append([]string{cmd}, args...)...); err != nil {
+		return nil, err
 	}
-	if len(r.options.AllowWriteFolders) > 0 {
-		r.options.AllowWriteFolders = common.ProcessTemplateListFlexible(r.options.AllowWriteFolders, params)
+
+	logger.Debug("RunWithPipes: executing command in firejail: %s with args: %v", cmd, args)
+
+	firejailArgs, profileOpts, removeSandboxFiles, err := r.sandboxArgs(ctx, logger, params)
+	if err != nil {
+		return nil, err
 	}
-	if len(r.options.AllowReadFiles) > 0 {
-		r.options.AllowReadFiles = common.ProcessTemplateListFlexible(r.options.AllowReadFiles, params)
+	extraFiles := extraFilesFrom(ctx)
+	if len(extraFiles) > 0 {
+		// firejail closes inherited descriptors unless told to keep them
+		firejailArgs = append(firejailArgs, "--keep-fd="+strings.Join(extraFDs(len(extraFiles)), ","))
 	}
-	if len(r.options.AllowWriteFiles) > 0 {
-		r.options.AllowWriteFiles = common.ProcessTemplateListFlexible(r.options.AllowWriteFiles, params)
+	firejailArgs = append(firejailArgs, cmd)
+	firejailArgs = append(firejailArgs, args...)
+
+	execCmd := commandContext(ctx, r.options.lookPath("firejail"), firejailArgs...)
+
+	// Set environment variables if provided
+	if len(env) > 0 {
+		logger.Debug("Adding %d environment variables to command", len(env))
+		execCmd.Env = append(os.Environ(), env...)
 	}
+	execCmd.Env = r.options.agentEnv(execCmd.Env)
+	execCmd.Env = r.options.scrubDisplayEnv(execCmd.Env)
+	execCmd.ExtraFiles = extraFiles
+
+	applyUmask(logger, execCmd, r.options.Umask)
+	collectCores := r.options.applyCoreDumps(logger, execCmd)
+
+	started = true
+	return startProcess(logger, execCmd, func() {
+		r.options.normalizeFileModes(logger, profileOpts.AllowWriteFolders)
+		collectCores()
+		removeTools()
+		removeSandboxFiles()
+	})
+}
+
+// sandboxArgs returns the firejail arguments applying the restrictions of
+// the runner, with the profile rendered for params, and the function removing
+// the files they refer to
+func (r *Firejail) sandboxArgs(ctx context.Context, logger Logger, params map[string]interface{}) (
+	[]string, FirejailOptions, func(), error) {
+	// Process template variables in allow read and write folders and files
+	profileOpts := r.profileOptions(params)
 
 	// Generate the firejail profile
 	var profileBuf bytes.Buffer
-	if err := r.profileTpl.Execute(&profileBuf, r.options); err != nil {
-		r.logger.Debug("Failed to render firejail profile template: %v", err)
-		return nil, nil, nil, nil, fmt.Errorf("failed to render firejail profile: %w", err)
+	if err := r.profileTpl.Execute(&profileBuf, profileOpts); err != nil {
+		logger.Debug("Failed to render firejail profile template: %v", err)
+		return nil, FirejailOptions{}, nil, fmt.Errorf("failed to render firejail profile: %w", err)
 	}
 
+	recordReproFile(ctx, "firejail.profile", profileBuf.Bytes())
+
 	// Create a temporary file for the firejail profile
 	profileFile, err := os.CreateTemp("", "firejail-profile-*.profile")
 	if err != nil {
-		r.logger.Debug("Failed to create temporary profile file: %v", err)
-		return nil, nil, nil, nil, fmt.Errorf("failed to create temporary profile file: %w", err)
+		logger.Debug("Failed to create temporary profile file: %v", err)
+		return nil, FirejailOptions{}, nil, fmt.Errorf("failed to create temporary profile file: %w", err)
 	}
 	profileFilePath := profileFile.Name()
 
 	// Write the profile to the file
 	if _, err := profileFile.Write(profileBuf.Bytes()); err != nil {
 		if closeErr := profileFile.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close profile file: %v", closeErr)
+			logger.Debug("Warning: failed to close profile file: %v", closeErr)
 		}
 		if removeErr := os.Remove(profileFilePath); removeErr != nil {
-			r.logger.Debug("Warning: failed to remove profile file: %v", removeErr)
+			logger.Debug("Warning: failed to remove profile file: %v", removeErr)
 		}
-		r.logger.Debug("Failed to write firejail profile: %v", err)
-		return nil, nil, nil, nil, fmt.Errorf("failed to write firejail profile: %w", err)
+		logger.Debug("Failed to write firejail profile: %v", err)
+		return nil, FirejailOptions{}, nil, fmt.Errorf("failed to write firejail profile: %w", err)
 	}
 
 	// Close the file so firejail can read it
 	if err := profileFile.Close(); err != nil {
 		if removeErr := os.Remove(profileFilePath); removeErr != nil {
-			r.logger.Debug("Warning: failed to remove profile file: %v", removeErr)
+			logger.Debug("Warning: failed to remove profile file: %v", removeErr)
 		}
-		r.logger.Debug("Failed to close profile file: %v", err)
-		return nil, nil, nil, nil, fmt.Errorf("failed to close profile file: %w", err)
+		logger.Debug("Failed to close profile file: %v", err)
+		return nil, FirejailOptions{}, nil, fmt.Errorf("failed to close profile file: %w", err)
 	}
 
-	r.logger.Debug("Created firejail profile at: %s", profileFilePath)
+	logger.Debug("Created firejail profile at: %s", profileFilePath)
 
 	// Build the command with firejail
 	// firejail --profile=<profile> <cmd> <args...>
-	firejailArgs := []string{"--profile=" + profileFilePath, cmd}
-	firejailArgs = append(firejailArgs, args...)
-
-	execCmd := exec.CommandContext(ctx, "firejail", firejailArgs...)
-
-	// Set environment variables if provided
-	if len(env) > 0 {
-		r.logger.Debug("Adding %d environment variables to command", len(env))
-		execCmd.Env = append(os.Environ(), env...)
-	}
+	firejailArgs := []string{"--profile=" + profileFilePath}
 
-	// Create pipes for stdin, stdout, and stderr
-	stdinPipe, err := execCmd.StdinPipe()
+	hostsFile, err := r.options.writeHostsFile("/etc/hosts")
 	if err != nil {
 		if removeErr := os.Remove(profileFilePath); removeErr != nil {
-			r.logger.Debug("Warning: failed to remove profile file: %v", removeErr)
+			logger.Debug("Warning: failed to remove profile file: %v", removeErr)
 		}
-		r.logger.Debug("Failed to create stdin pipe: %v", err)
-		return nil, nil, nil, nil, errors.New("failed to create stdin pipe: " + err.Error())
+		return nil, FirejailOptions{}, nil, err
 	}
+	if hostsFile != "" {
+		firejailArgs = append(firejailArgs, "--hosts-file="+hostsFile)
+	}
+	firejailArgs = append(firejailArgs, r.sessionArgs()...)
+	firejailArgs = append(firejailArgs, profileOpts.readOnlyRootArgs()...)
 
-	stdoutPipe, err := execCmd.StdoutPipe()
-	if err != nil {
-		if closeErr := stdinPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdin pipe: %v", closeErr)
-		}
-		if removeErr := os.Remove(profileFilePath); removeErr != nil {
-			r.logger.Debug("Warning: failed to remove profile file: %v", removeErr)
-		}
-		r.logger.Debug("Failed to create stdout pipe: %v", err)
-		return nil, nil, nil, nil, errors.New("failed to create stdout pipe: " + err.Error())
+	if loopbackNetworkFrom(ctx) {
+		// a new network namespace with only the loopback interface
+		firejailArgs = append(firejailArgs, "--net=none")
 	}
 
-	stderrPipe, err := execCmd.StderrPipe()
-	if err != nil {
-		if closeErr := stdinPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdin pipe: %v", closeErr)
-		}
-		if closeErr := stdoutPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdout pipe: %v", closeErr)
-		}
+	removeSandboxFiles := func() {
 		if removeErr := os.Remove(profileFilePath); removeErr != nil {
-			r.logger.Debug("Warning: failed to remove profile file: %v", removeErr)
+			logger.Debug("Warning: failed to remove firejail profile file %s: %v", profileFilePath, removeErr)
+		}
+		if hostsFile != "" {
+			if removeErr := os.Remove(hostsFile); removeErr != nil {
+				logger.Debug("Warning: failed to remove hosts file %s: %v", hostsFile, removeErr)
+			}
 		}
-		r.logger.Debug("Failed to create stderr pipe: %v", err)
-		return nil, nil, nil, nil, errors.New("failed to create stderr pipe: " + err.Error())
 	}
+	return firejailArgs, profileOpts, removeSandboxFiles, nil
+}
 
-	// Start the command
-	r.logger.Debug("Starting firejail command with pipes")
-	if err := execCmd.Start(); err != nil {
-		if closeErr := stdinPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdin pipe: %v", closeErr)
-		}
-		if closeErr := stdoutPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdout pipe: %v", closeErr)
-		}
-		if closeErr := stderrPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stderr pipe: %v", closeErr)
-		}
-		if removeErr := os.Remove(profileFilePath); removeErr != nil {
-			r.logger.Debug("Warning: failed to remove profile file: %v", removeErr)
-		}
-		r.logger.Debug("Failed to start command: %v", err)
-		return nil, nil, nil, nil, errors.New("failed to start command: " + err.Error())
+// sessionArgs returns the firejail arguments denying the agent sockets, the
+// display server and the desktop devices of the user session (see
+// AgentOptions, DisplayOptions and DeviceOptions). They are passed in the
+// command line so they also apply to custom profiles.
+func (r *Firejail) sessionArgs() []string {
+	var args []string
+	if !r.options.AllowDisplay {
+		args = append(args, "--x11=none")
+	}
+	if !r.options.AllowAudio {
+		args = append(args, "--nosound")
+	}
+	if !r.options.AllowVideo {
+		args = append(args, "--novideo")
+	}
+	if r.options.AllowDBusPortals {
+		// firejail filters the session bus with xdg-dbus-proxy
+		args = append(args, "--dbus-user=filter", "--dbus-user.talk="+portalBusNames)
+	} else {
+		args = append(args, "--dbus-user=none")
+	}
+	if r.options.PrivateIPC {
+		args = append(args, "--ipc-namespace")
+	}
+	if hostname := r.options.hostname(); hostname != "" {
+		args = append(args, "--hostname="+hostname)
 	}
+	paths := append(r.options.BlockedAgentPaths(), r.options.BlockedDisplayPaths()...)
+	for _, path := range paths {
+		args = append(args, "--blacklist="+path)
+	}
+	return args
+}
 
-	r.logger.Debug("Firejail command started successfully with PID: %d", execCmd.Process.Pid)
+// readOnlyRootArgs returns the arguments making the whole filesystem
+// read-only, except the writable folders and files, with read_only_root
+func (o FirejailOptions) readOnlyRootArgs() []string {
+	if !o.ReadOnlyRoot {
+		return nil
+	}
+	args := []string{"--read-only=/"}
+	for _, path := range append(append([]string{}, o.AllowWriteFolders...), o.AllowWriteFiles...) {
+		args = append(args, "--read-write="+path)
+	}
+	return args
+}
 
-	// Create wait function that waits for the command to complete and cleans up
-	waitFunc := func() error {
-		r.logger.Debug("Waiting for firejail command to complete")
-		err := execCmd.Wait()
+// profileOptions returns the options used to render the profile of a call:
+// the runner options with template variables replaced with params, plus the
+// staged inputs, workspace and scratch directories. The runner options are
+// not modified, so templates are evaluated on every call.
+func (r *Firejail) profileOptions(params map[string]interface{}) FirejailOptions {
+	opts := r.options
+	opts.AllowReadFolders = withInputsDir(common.ProcessTemplateListFlexible(r.options.AllowReadFolders, params), params)
+	opts.AllowWriteFolders = withWritableDirs(common.ProcessTemplateListFlexible(r.options.AllowWriteFolders, params), params)
+	opts.AllowReadFiles = common.ProcessTemplateListFlexible(r.options.AllowReadFiles, params)
+	opts.AllowWriteFiles = common.ProcessTemplateListFlexible(r.options.AllowWriteFiles, params)
+	if r.options.CABundle != "" {
+		opts.AllowReadFiles = append(opts.AllowReadFiles, r.options.CABundle)
+	}
+	opts.normalizeRules()
+	return opts
+}
 
-		// Clean up the profile file
-		if removeErr := os.Remove(profileFilePath); removeErr != nil {
-			r.logger.Debug("Warning: failed to remove firejail profile file %s: %v", profileFilePath, removeErr)
-		}
+// SeccompFilter returns the seccomp line of the profile (see SeccompOptions)
+func (o FirejailOptions) SeccompFilter() string {
+	// the profile was checked by NewFirejail
+	line, _ := o.firejailSeccomp(o.AllowKeyring)
+	return line
+}
 
-		if err != nil {
-			r.logger.Debug("Firejail command completed with error: %v", err)
-			return err
+// policyFiles returns the files included by the custom profile (with an
+// absolute path), for the fingerprint of the runner
+func (o FirejailOptions) policyFiles() []string {
+	var files []string
+	for _, line := range strings.Split(o.CustomProfile, "\n") {
+		if path, ok := strings.CutPrefix(strings.TrimSpace(line), "include "); ok {
+			if path = strings.TrimSpace(path); filepath.IsAbs(path) {
+				files = append(files, path)
+			}
 		}
-		r.logger.Debug("Firejail command completed successfully")
-		return nil
 	}
+	return files
+}
+
+// normalizeRules sorts and deduplicates the paths of the profile
+func (o *FirejailOptions) normalizeRules() {
+	o.AllowReadFolders = normalizePaths(o.AllowReadFolders)
+	o.AllowWriteFolders = normalizePaths(o.AllowWriteFolders)
+	o.AllowReadFiles = normalizePaths(o.AllowReadFiles)
+	o.AllowWriteFiles = normalizePaths(o.AllowWriteFiles)
+}
 
-	return stdinPipe, stdoutPipe, stderrPipe, waitFunc, nil
+// canaryPolicy returns what the canaries verify (see CanaryOptions). Reads
+// are only denied in the folders of the profile, so only the canary paths
+// are checked. Custom profiles may allow networking.
+func (r *Firejail) canaryPolicy(ctx context.Context, params map[string]interface{}) canaryPolicy {
+	return canaryPolicy{
+		networkDenied: (!r.options.AllowNetworking && r.options.CustomProfile == "") || loopbackNetworkFrom(ctx),
+	}
+}
+
+// fileChangeFolders returns the writable folders when file changes must be reported
+func (r *Firejail) fileChangeFolders(params map[string]interface{}) []string {
+	if !r.options.ReportFileChanges {
+		return nil
+	}
+	return common.ProcessTemplateListFlexible(r.options.AllowWriteFolders, params)
 }
 
 // CheckImplicitRequirements checks if the runner meets its implicit requirements.
@@ -430,9 +729,38 @@ func (r *Firejail) CheckImplicitRequirements() error {
 	}
 
 	// Check if firejail is available
-	if !common.CheckExecutableExists("firejail") {
+	if !common.CheckExecutableExists("firejail", r.options.ExtraPath...) {
 		return fmt.Errorf("firejail executable not found in PATH")
 	}
 
 	return nil
 }
+
+// policyConflicts returns the paths both read-only and writable in the
+// profile, where read-only wins
+func (r *Firejail) policyConflicts() []PolicyWarning {
+	return pathConflictWarnings(TypeFirejail,
+		map[string][]string{"allow_read_folders": r.options.AllowReadFolders, "allow_read_files": r.options.AllowReadFiles},
+		map[string][]string{"allow_write_folders": r.options.AllowWriteFolders, "allow_write_files": r.options.AllowWriteFiles},
+		true)
+}
+
+// policyWarnings returns the conflicting paths of the profile, and the
+// restrictions of the profile not supported by the firejail build
+func (r *Firejail) policyWarnings(ctx context.Context) []PolicyWarning {
+	warnings := r.policyConflicts()
+
+	output, err := exec.CommandContext(ctx, r.options.lookPath("firejail"), "--version").Output()
+	if err != nil {
+		return warnings
+	}
+	if strings.Contains(string(output), "seccomp-bpf support is disabled") {
+		warnings = append(warnings, PolicyWarning{
+			Runner:  TypeFirejail,
+			Feature: "seccomp",
+			Message: "firejail was built without seccomp support: the system calls of the command " +
+				"are not filtered (e.g. the kernel keyrings are reachable)",
+		})
+	}
+	return warnings
+}
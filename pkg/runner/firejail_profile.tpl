@@ -48,7 +48,8 @@ whitelist {{ . }}
 {{ end }}
 
 # Always apply basic security features
-seccomp
+# The default seccomp filter blocks the kernel keyrings, unless allow_keyring is set
+{{ .SeccompFilter }}
 caps.drop all
 noroot
 {{ end }} 
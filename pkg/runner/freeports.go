@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"fmt"
+	"net"
+)
+
+// BindPortsParam is the parameter holding the TCP ports the command is
+// allowed to listen on, allocated with WithFreePorts. The runners that
+// restrict binding (Landrun) allow them, and the ones with their own network
+// namespace (Docker) publish them in the same host ports.
+const BindPortsParam = "bind_ports"
+
+// AllocatePorts returns n TCP ports that are free in the loopback interface.
+//
+// The ports are only guaranteed to be free when AllocatePorts returns: they
+// should be used right away. All the ports are held at the same time while
+// they are allocated, so they are all different.
+func AllocatePorts(n int) ([]int, error) {
+	listeners := make([]net.Listener, 0, n)
+	defer func() {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+	}()
+
+	ports := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate a free port: %w", err)
+		}
+		listeners = append(listeners, l)
+		ports = append(ports, l.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
+
+// WithFreePorts allocates a free TCP port for each name, and passes it to
+// the command as a template parameter with that name (e.g. {{.port}}).
+// As the ports are not known before, the arguments of the command are
+// processed as templates too, so they can refer to the ports.
+//
+// The command is allowed to listen on the ports, which are reachable from
+// the host at the same port number and are listed by Execution.Ports.
+func WithFreePorts(names ...string) ExecOption {
+	return func(c *execConfig) {
+		c.freePorts = append(c.freePorts, names...)
+	}
+}
+
+// allocateFreePorts allocates the ports requested with WithFreePorts,
+// returning the params with the ports and the ports to report
+func allocateFreePorts(cfg *execConfig, params map[string]interface{}) (map[string]interface{}, []PublishedPort, error) {
+	ports, err := AllocatePorts(len(cfg.freePorts))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bind := append([]int{}, bindPorts(params)...)
+	var published []PublishedPort
+	for i, name := range cfg.freePorts {
+		params = withParam(params, name, ports[i])
+		bind = append(bind, ports[i])
+		published = append(published, PublishedPort{
+			Name:     name,
+			Port:     ports[i],
+			Protocol: "tcp",
+			HostIP:   "127.0.0.1",
+			HostPort: ports[i],
+		})
+	}
+	return withParam(params, BindPortsParam, bind), published, nil
+}
+
+// bindPorts returns the ports in the BindPortsParam parameter
+func bindPorts(params map[string]interface{}) []int {
+	ports, _ := params[BindPortsParam].([]int)
+	return ports
+}
+
+// withParam returns a copy of params with a parameter set
+func withParam(params map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(params)+1)
+	for k, v := range params {
+		res[k] = v
+	}
+	res[key] = value
+	return res
+}
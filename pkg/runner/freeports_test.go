@@ -0,0 +1,83 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAllocatePorts(t *testing.T) {
+	ports, err := AllocatePorts(3)
+	if err != nil {
+		t.Fatalf("AllocatePorts failed: %v", err)
+	}
+	if len(ports) != 3 {
+		t.Fatalf("AllocatePorts() = %v, want 3 ports", ports)
+	}
+
+	seen := map[int]bool{}
+	for _, port := range ports {
+		if seen[port] {
+			t.Errorf("AllocatePorts() = %v, want different ports", ports)
+		}
+		seen[port] = true
+
+		// the port is free again once allocated
+		l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			t.Errorf("port %d is not free: %v", port, err)
+			continue
+		}
+		_ = l.Close()
+	}
+}
+
+func TestStart_WithFreePorts(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+
+	e, err := Start(context.Background(), r, "echo", []string{"{{.port}}"}, nil, nil, WithFreePorts("port", "admin_port"))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	_ = e.Stdin.Close()
+	output, _ := io.ReadAll(e.Stdout)
+	_, _ = io.ReadAll(e.Stderr)
+	if err := e.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	ports := e.Ports()
+	if len(ports) != 2 || ports[0].Name != "port" || ports[1].Name != "admin_port" {
+		t.Fatalf("Ports() = %+v, want the allocated ports", ports)
+	}
+	if got := strings.TrimSpace(string(output)); got != strconv.Itoa(ports[0].Port) {
+		t.Errorf("output = %q, want the port in the arguments", got)
+	}
+	for _, p := range ports {
+		if p.Port == 0 || p.HostPort != p.Port || p.Protocol != "tcp" {
+			t.Errorf("Ports() = %+v, want the same port in the host", p)
+		}
+	}
+}
+
+func TestAllocateFreePorts_Params(t *testing.T) {
+	cfg := &execConfig{freePorts: []string{"port"}}
+	params, published, err := allocateFreePorts(cfg, map[string]interface{}{"name": "server"})
+	if err != nil {
+		t.Fatalf("allocateFreePorts failed: %v", err)
+	}
+
+	port := published[0].Port
+	if params["port"] != port || params["name"] != "server" {
+		t.Errorf("params = %v, want the port and the original params", params)
+	}
+	if got := bindPorts(params); len(got) != 1 || got[0] != port {
+		t.Errorf("bindPorts() = %v, want [%d]", got, port)
+	}
+}
@@ -0,0 +1,48 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// helpersEnabled is whether MaybeRunHelper was called, so this executable
+// can be started as the helper of a runner
+var helpersEnabled atomic.Bool
+
+// MaybeRunHelper runs the helper of a runner when this executable was
+// started as one, and never returns in that case. Otherwise it returns
+// immediately.
+//
+// Some features start the command through the executable of the runner
+// process, which applies the restrictions that cannot be set from the
+// parent before it executes the command: WithLoopbackNetwork, the private
+// namespaces and the ephemeral UIDs of Exec and Landrun, the seccomp
+// profiles, the Landlock helper of Landrun, SelfTest and the sandbox of the
+// WindowsRestricted runner. Programs using them must call MaybeRunHelper at
+// the start of main, before doing anything else (including parsing flags),
+// and tests with a TestMain:
+//
+//	func main() {
+//		runner.MaybeRunHelper()
+//		...
+//	}
+//
+// These features fail with an error when MaybeRunHelper has not been called.
+func MaybeRunHelper() {
+	helpersEnabled.Store(true)
+	runHelper()
+}
+
+// helperExecutable returns the path of this executable, for starting the
+// helper of a runner (see MaybeRunHelper)
+func helperExecutable(helper string) (string, error) {
+	if !helpersEnabled.Load() {
+		return "", fmt.Errorf("the %s requires calling runner.MaybeRunHelper at the start of main", helper)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the %s: %w", helper, err)
+	}
+	return self, nil
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package runner
+
+import "os"
+
+// runHelper runs the helper this executable was started as, if any
+func runHelper() {
+	// the namespace helper runs the other helpers (see isolateNamespaces)
+	switch {
+	case os.Getenv(namespaceHelperEnv) != "":
+		runNamespaceHelper()
+	case os.Getenv(loopbackHelperEnv) == "1":
+		runLoopbackHelper()
+	case os.Getenv(ephemeralUIDHelperEnv) != "":
+		runEphemeralUIDHelper()
+	case os.Getenv(landlockHelperEnv) != "":
+		runLandlockHelper()
+	case os.Getenv(seccompHelperEnv) != "":
+		runSeccompHelper()
+	case os.Getenv(selfTestHelperEnv) != "":
+		runSelfTestHelper()
+	}
+}
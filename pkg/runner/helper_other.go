@@ -0,0 +1,6 @@
+//go:build !linux && !windows
+
+package runner
+
+// runHelper runs the helper this executable was started as, if any
+func runHelper() {}
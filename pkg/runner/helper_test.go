@@ -0,0 +1,20 @@
+package runner
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	MaybeRunHelper()
+	os.Exit(m.Run())
+}
+
+func TestHelperExecutable(t *testing.T) {
+	helpersEnabled.Store(false)
+	t.Cleanup(func() { helpersEnabled.Store(true) })
+	if _, err := helperExecutable("test helper"); err == nil || !strings.Contains(err.Error(), "MaybeRunHelper") {
+		t.Errorf("helperExecutable() = %v, want an error asking for MaybeRunHelper", err)
+	}
+}
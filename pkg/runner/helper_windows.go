@@ -0,0 +1,12 @@
+//go:build windows
+
+package runner
+
+import "os"
+
+// runHelper runs the helper this executable was started as, if any
+func runHelper() {
+	if os.Getenv(windowsSandboxHelperEnv) != "" {
+		runWindowsSandboxHelper()
+	}
+}
@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// HostInfo describes the host a command ran on and the backend of its
+// runner, so the commands behaving differently on some hosts can be
+// investigated from their results (see RunResult and ExecutionSummary)
+type HostInfo struct {
+	// OS and Arch are the operating system and the CPU architecture of the host
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+
+	// Kernel is the kernel release of the host (Linux only)
+	Kernel string `json:"kernel,omitempty"`
+
+	// LandlockABI is the Landlock ABI version of the kernel, 0 when Landlock
+	// is not available (Linux only)
+	LandlockABI int `json:"landlock_abi,omitempty"`
+
+	// Runner is the runner type, empty for the types registered by other modules
+	Runner Type `json:"runner,omitempty"`
+
+	// BackendVersion is the first line printed by the version command of the
+	// tool of the runner (e.g. the firejail version, or the client and server
+	// versions of Docker), empty when the runner has no tool or it failed
+	BackendVersion string `json:"backend_version,omitempty"`
+}
+
+// hostInfoEntry is the HostInfo of a runner type, collected once
+type hostInfoEntry struct {
+	once sync.Once
+	info HostInfo
+}
+
+// hostInfos are the HostInfo collected, by runner type
+var hostInfos sync.Map
+
+// hostInfoOf returns the HostInfo of a runner. It is collected the first
+// time it is needed for the runner type, as the version commands of the
+// tools (e.g. `docker version`) are too slow to run for every command.
+func hostInfoOf(r Runner) HostInfo {
+	if s, ok := r.(*Session); ok {
+		r = s.runner
+	}
+	runnerType, _ := runnerPolicy(r)
+	value, _ := hostInfos.LoadOrStore(runnerType, &hostInfoEntry{})
+	entry := value.(*hostInfoEntry)
+	entry.once.Do(func() {
+		entry.info = collectHostInfo(context.Background(), runnerType)
+	})
+	return entry.info
+}
+
+// collectHostInfo returns the HostInfo of a runner type
+func collectHostInfo(ctx context.Context, runnerType Type) HostInfo {
+	info := HostInfo{
+		OS:     runtime.GOOS,
+		Arch:   runtime.GOARCH,
+		Runner: runnerType,
+	}
+	if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		info.Kernel = strings.TrimSpace(string(release))
+	}
+	if abi, err := landlockABI(); err == nil {
+		info.LandlockABI = abi
+	}
+	if version, err := toolVersion(ctx, runnerType); err == nil {
+		info.BackendVersion = version
+	}
+	return info
+}
@@ -0,0 +1,44 @@
+package runner
+
+import (
+	"context"
+	"runtime"
+	"testing"
+)
+
+func TestCollectHostInfo(t *testing.T) {
+	fakeTool(t, "firejail", `echo "firejail version 0.9.72"; echo; echo "Compile time support:"`)
+
+	info := collectHostInfo(context.Background(), TypeFirejail)
+	if info.OS != runtime.GOOS || info.Arch != runtime.GOARCH || info.Runner != TypeFirejail {
+		t.Errorf("collectHostInfo() = %+v", info)
+	}
+	if info.BackendVersion != "firejail version 0.9.72" {
+		t.Errorf("BackendVersion = %q, want the first line of firejail --version", info.BackendVersion)
+	}
+	if runtime.GOOS == "linux" && info.Kernel == "" {
+		t.Errorf("Kernel is not set on Linux")
+	}
+
+	// the types without a tool have no version
+	if info := collectHostInfo(context.Background(), TypeExec); info.BackendVersion != "" {
+		t.Errorf("BackendVersion = %q for the exec runner", info.BackendVersion)
+	}
+}
+
+func TestRunEx_host(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := r.RunEx(context.Background(), RunRequest{Shell: "/bin/sh", Command: "exit 0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Host.Runner != TypeExec || result.Host.OS != runtime.GOOS || result.Host != hostInfoOf(r) {
+		t.Errorf("Host = %+v", result.Host)
+	}
+}
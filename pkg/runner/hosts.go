@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// HostsOptions adds hostname aliases to the commands, so they can resolve
+// internal service names without DNS access. It is embedded in the options
+// of the runners that can change the /etc/hosts of their commands (Docker,
+// Firejail and Proot), so its fields are set with the same keys as any other
+// option.
+type HostsOptions struct {
+	// ExtraHosts maps hostnames to the IP address they resolve to
+	ExtraHosts map[string]string `json:"extra_hosts"`
+}
+
+// validateHosts checks the hostnames and the addresses of ExtraHosts
+func (o HostsOptions) validateHosts() error {
+	for host, ip := range o.ExtraHosts {
+		if host == "" || strings.ContainsAny(host, " \t\n#:") {
+			return fmt.Errorf("invalid hostname in extra_hosts: %q", host)
+		}
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid IP address for %s in extra_hosts: %q", host, ip)
+		}
+	}
+	return nil
+}
+
+// sortedHosts returns the hostnames of ExtraHosts, sorted
+func (o HostsOptions) sortedHosts() []string {
+	hosts := make([]string, 0, len(o.ExtraHosts))
+	for host := range o.ExtraHosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// addHostArgs returns the docker run arguments that add ExtraHosts
+func (o HostsOptions) addHostArgs() []string {
+	var args []string
+	for _, host := range o.sortedHosts() {
+		args = append(args, "--add-host", host+":"+o.ExtraHosts[host])
+	}
+	return args
+}
+
+// writeHostsFile writes a copy of the base hosts file followed by ExtraHosts,
+// and returns its path. It returns an empty path when there are no extra
+// hosts. The caller must remove the file.
+func (o HostsOptions) writeHostsFile(base string) (string, error) {
+	if len(o.ExtraHosts) == 0 {
+		return "", nil
+	}
+
+	var content strings.Builder
+	if data, err := os.ReadFile(base); err == nil {
+		content.Write(data)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			content.WriteByte('\n')
+		}
+	} else {
+		content.WriteString("127.0.0.1\tlocalhost\n::1\tlocalhost\n")
+	}
+	content.WriteString("# extra_hosts\n")
+	for _, host := range o.sortedHosts() {
+		fmt.Fprintf(&content, "%s\t%s\n", o.ExtraHosts[host], host)
+	}
+
+	f, err := os.CreateTemp("", "runner-hosts-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create hosts file: %w", err)
+	}
+	if _, err := f.WriteString(content.String()); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write hosts file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write hosts file: %w", err)
+	}
+	// commands running as other users (e.g. in the guest) must be able to read it
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write hosts file: %w", err)
+	}
+	return f.Name(), nil
+}
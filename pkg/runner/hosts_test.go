@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHostsOptions_validateHosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		hosts   map[string]string
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "ipv4 and ipv6", hosts: map[string]string{"db.internal": "10.0.0.5", "cache": "fd00::1"}},
+		{name: "invalid address", hosts: map[string]string{"db.internal": "not-an-ip"}, wantErr: true},
+		{name: "empty hostname", hosts: map[string]string{"": "10.0.0.5"}, wantErr: true},
+		{name: "hostname with spaces", hosts: map[string]string{"db internal": "10.0.0.5"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := HostsOptions{ExtraHosts: tt.hosts}.validateHosts()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateHosts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHostsOptions_addHostArgs(t *testing.T) {
+	o := HostsOptions{ExtraHosts: map[string]string{"web": "10.0.0.2", "db": "10.0.0.1"}}
+	want := []string{"--add-host", "db:10.0.0.1", "--add-host", "web:10.0.0.2"}
+	if got := o.addHostArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("addHostArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestHostsOptions_writeHostsFile(t *testing.T) {
+	if path, err := (HostsOptions{}).writeHostsFile("/etc/hosts"); err != nil || path != "" {
+		t.Fatalf("expected no hosts file without extra hosts, got %q, %v", path, err)
+	}
+
+	base := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(base, []byte("127.0.0.1 localhost"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	o := HostsOptions{ExtraHosts: map[string]string{"db.internal": "10.0.0.5"}}
+	path, err := o.writeHostsFile(base)
+	if err != nil {
+		t.Fatalf("writeHostsFile failed: %v", err)
+	}
+	defer os.Remove(path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if lines[0] != "127.0.0.1 localhost" {
+		t.Errorf("expected the base hosts file to be kept, got %q", lines[0])
+	}
+	if last := lines[len(lines)-1]; last != "10.0.0.5\tdb.internal" {
+		t.Errorf("expected the extra host at the end, got %q", last)
+	}
+}
+
+func TestDocker_extraHosts(t *testing.T) {
+	opts, err := NewDockerOptions(Options{
+		"image":       "alpine",
+		"extra_hosts": map[string]interface{}{"db.internal": "10.0.0.5"},
+	})
+	if err != nil {
+		t.Fatalf("NewDockerOptions failed: %v", err)
+	}
+	cmd := strings.Join(opts.GetBaseDockerCommand(nil), " ")
+	if !strings.Contains(cmd, "--add-host db.internal:10.0.0.5") {
+		t.Errorf("expected --add-host in %q", cmd)
+	}
+
+	if _, err := NewDockerOptions(Options{
+		"image":       "alpine",
+		"extra_hosts": map[string]interface{}{"db.internal": "nope"},
+	}); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
+}
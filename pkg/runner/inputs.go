@@ -0,0 +1,143 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// InputsDirParam is the template parameter holding the directory where the
+	// input files of an execution have been staged (see WithInputFiles)
+	InputsDirParam = "inputs_dir"
+
+	// InputsDirEnv is the environment variable holding the directory where the
+	// input files of an execution have been staged (see WithInputFiles)
+	InputsDirEnv = "INPUTS_DIR"
+)
+
+// WithInputFiles provides files to the command. The files (keyed by their
+// path relative to the staging directory) are written to a per-execution
+// staging directory that is readable (but not writable) by the command in
+// every backend, and removed once the execution has completed.
+func WithInputFiles(files map[string][]byte) ExecOption {
+	return func(c *execConfig) {
+		if c.inputFiles == nil {
+			c.inputFiles = map[string][]byte{}
+		}
+		for name, content := range files {
+			c.inputFiles[name] = content
+		}
+	}
+}
+
+// WithInputPaths is like WithInputFiles, but copies the content of host
+// files (the values of the map) into the staging directory.
+func WithInputPaths(paths map[string]string) ExecOption {
+	return func(c *execConfig) {
+		if c.inputPaths == nil {
+			c.inputPaths = map[string]string{}
+		}
+		for name, path := range paths {
+			c.inputPaths[name] = path
+		}
+	}
+}
+
+// hasInputs returns whether input files must be staged for the execution
+func (c *execConfig) hasInputs() bool {
+	return len(c.inputFiles) > 0 || len(c.inputPaths) > 0
+}
+
+// stageInputs materializes the input files in a new staging directory and
+// returns it. The caller must remove the directory.
+func stageInputs(cfg *execConfig) (string, error) {
+	dir, err := os.MkdirTemp("", "go-restricted-runner-inputs-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	// The command can run as another user (e.g. in a container)
+	if err := os.Chmod(dir, 0o755); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to set staging directory permissions: %w", err)
+	}
+
+	stage := func() error {
+		for name, content := range cfg.inputFiles {
+			dest, err := stagedPath(dir, name)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(dest, content, 0o444); err != nil {
+				return fmt.Errorf("failed to stage input file %s: %w", name, err)
+			}
+		}
+		for name, src := range cfg.inputPaths {
+			dest, err := stagedPath(dir, name)
+			if err != nil {
+				return err
+			}
+			if err := copyInputFile(src, dest); err != nil {
+				return fmt.Errorf("failed to stage input file %s from %s: %w", name, src, err)
+			}
+		}
+		return nil
+	}
+
+	if err := stage(); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// stagedPath returns the path of an input file in the staging directory,
+// creating its parent directories. Names escaping the directory are rejected.
+func stagedPath(dir string, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if clean == "." || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid input file name %q", name)
+	}
+
+	dest := filepath.Join(dir, clean)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create directory for input file %s: %w", name, err)
+	}
+	return dest, nil
+}
+
+// copyInputFile copies a host file to the staging directory
+func copyInputFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o444)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// inputsDir returns the staged inputs directory in params, if any
+func inputsDir(params map[string]interface{}) string {
+	dir, _ := params[InputsDirParam].(string)
+	return dir
+}
+
+// withInputsDir returns the folders plus the staged inputs directory in params, if any
+func withInputsDir(folders []string, params map[string]interface{}) []string {
+	dir := inputsDir(params)
+	if dir == "" || contains(folders, dir) {
+		return folders
+	}
+	return append(append([]string{}, folders...), dir)
+}
@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// TestStart_InputFiles tests that input files are staged and cleaned up
+func TestStart_InputFiles(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src.txt")
+	if err := os.WriteFile(src, []byte("from host\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	logger, _ := common.NewLogger("", "", common.LogLevelDebug, false)
+	runner, err := NewExec(Options{}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+
+	e, err := Start(context.Background(), runner, "sh",
+		[]string{"-c", `echo "$INPUTS_DIR"; cat "$INPUTS_DIR/data/in.txt" "$INPUTS_DIR/copy.txt"`}, nil, nil,
+		WithInputFiles(map[string][]byte{"data/in.txt": []byte("inline\n")}),
+		WithInputPaths(map[string]string{"copy.txt": src}))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	_ = e.Stdin.Close()
+	output, _ := io.ReadAll(e.Stdout)
+	_, _ = io.ReadAll(e.Stderr)
+	if err := e.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	lines := strings.SplitN(string(output), "\n", 2)
+	if len(lines) != 2 || lines[1] != "inline\nfrom host\n" {
+		t.Fatalf("Unexpected output: %q", string(output))
+	}
+	if _, err := os.Stat(lines[0]); !os.IsNotExist(err) {
+		t.Errorf("Expected staging directory %s to be removed, got %v", lines[0], err)
+	}
+}
+
+// TestStagedPath tests that input file names cannot escape the staging directory
+func TestStagedPath(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"../escape", "/etc/passwd", ".", "a/../../b"} {
+		if _, err := stagedPath(dir, name); err == nil {
+			t.Errorf("Expected an error for input file name %q", name)
+		}
+	}
+
+	path, err := stagedPath(dir, "a/b/c.txt")
+	if err != nil {
+		t.Fatalf("stagedPath failed: %v", err)
+	}
+	if path != filepath.Join(dir, "a", "b", "c.txt") {
+		t.Errorf("Unexpected path %q", path)
+	}
+}
+
+// TestWithInputsDir tests that the staging directory is added to the allowed folders
+func TestWithInputsDir(t *testing.T) {
+	folders := []string{"/data"}
+
+	if got := withInputsDir(folders, nil); len(got) != 1 {
+		t.Errorf("Expected folders unchanged without inputs, got %v", got)
+	}
+
+	got := withInputsDir(folders, map[string]interface{}{InputsDirParam: "/tmp/inputs"})
+	if len(got) != 2 || got[1] != "/tmp/inputs" {
+		t.Errorf("Expected the inputs directory to be added, got %v", got)
+	}
+	if len(folders) != 1 {
+		t.Errorf("Expected the original folders to be unchanged, got %v", folders)
+	}
+}
@@ -0,0 +1,115 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// ErrExecutableTampered is returned when an executable referenced by a
+// command does not match its pinned SHA-256 (see PathOptions)
+var ErrExecutableTampered = errors.New("executable does not match its pinned SHA-256")
+
+// validatePinnedExecutables checks the pinned executables are names or
+// absolute paths, with hex SHA-256 sums
+func (o PathOptions) validatePinnedExecutables() error {
+	for executable, sum := range o.PinnedExecutables {
+		if !filepath.IsAbs(executable) && strings.ContainsAny(executable, `/\`) {
+			return fmt.Errorf("pinned executable %q must be a name or an absolute path", executable)
+		}
+		if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("pinned executable %q: invalid SHA-256 %q", executable, sum)
+		}
+	}
+	return nil
+}
+
+// verifyPinnedExecutables checks the pinned executables referenced by the
+// words of a command (or of the executable and arguments of a command
+// started directly) immediately before it is executed. A word referencing a
+// pinned executable by name must resolve to it, and its contents must match
+// the SHA-256.
+func (o PathOptions) verifyPinnedExecutables(logger Logger, words ...string) error {
+	if len(o.PinnedExecutables) == 0 {
+		return nil
+	}
+
+	pinned := map[string]string{}
+	for executable := range o.PinnedExecutables {
+		pinned[filepath.Base(executable)] = executable
+	}
+	for _, word := range commandWords(words) {
+		executable, ok := pinned[filepath.Base(word)]
+		if !ok {
+			continue
+		}
+		path, err := common.ResolveExecutable(word, o.ExtraPath...)
+		if err != nil {
+			// not an executable (e.g. an argument with the same name)
+			continue
+		}
+		path = realPath(path)
+		expected := executable
+		if !filepath.IsAbs(expected) {
+			if expected, err = common.ResolveExecutable(executable, o.ExtraPath...); err != nil {
+				return fmt.Errorf("%w: %s not found", ErrExecutableTampered, executable)
+			}
+		}
+		if expected = realPath(expected); path != expected {
+			return fmt.Errorf("%w: %s resolves to %s instead of %s", ErrExecutableTampered, word, path, expected)
+		}
+
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return fmt.Errorf("failed to verify %s: %w", path, err)
+		}
+		if !strings.EqualFold(sum, o.PinnedExecutables[executable]) {
+			logger.Error("Executable %s has SHA-256 %s, pinned to %s", path, sum, o.PinnedExecutables[executable])
+			return fmt.Errorf("%w: %s", ErrExecutableTampered, path)
+		}
+		logger.Debug("Executable %s matches its pinned SHA-256", path)
+	}
+	return nil
+}
+
+// commandWords splits commands in the words that can be executables,
+// separated by blanks and shell operators
+func commandWords(commands []string) []string {
+	var words []string
+	for _, command := range commands {
+		words = append(words, strings.FieldsFunc(command, func(r rune) bool {
+			return strings.ContainsRune(" \t\n;|&()<>'\"`$=", r)
+		})...)
+	}
+	return words
+}
+
+// realPath returns path with the symbolic links evaluated, or path when they
+// cannot be
+func realPath(path string) string {
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		return real
+	}
+	return path
+}
+
+// fileSHA256 returns the hex SHA-256 of the contents of a file
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
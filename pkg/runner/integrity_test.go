@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPathOptions_validatePinnedExecutables(t *testing.T) {
+	sum := strings.Repeat("ab", sha256.Size)
+	tests := []struct {
+		name    string
+		pinned  map[string]string
+		wantErr bool
+	}{
+		{name: "name", pinned: map[string]string{"git": sum}},
+		{name: "absolute path", pinned: map[string]string{"/usr/bin/git": strings.ToUpper(sum)}},
+		{name: "relative path", pinned: map[string]string{"bin/git": sum}, wantErr: true},
+		{name: "short sum", pinned: map[string]string{"git": "abcd"}, wantErr: true},
+		{name: "not hex", pinned: map[string]string{"git": strings.Repeat("zz", sha256.Size)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := PathOptions{PinnedExecutables: tt.pinned}.validatePinnedExecutables()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validatePinnedExecutables() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCommandWords(t *testing.T) {
+	got := commandWords([]string{"git status && FOO=bar make -j4 | tee $(which log)", "/bin/ls"})
+	want := "git,status,FOO,bar,make,-j4,tee,which,log,/bin/ls"
+	if strings.Join(got, ",") != want {
+		t.Errorf("commandWords() = %v, want %s", got, want)
+	}
+}
+
+func TestExec_pinnedExecutables(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+
+	dir := t.TempDir()
+	tool := filepath.Join(dir, "pinned-tool")
+	content := []byte("#!/bin/sh\necho pinned\n")
+	if err := os.WriteFile(tool, content, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+
+	r, err := NewExec(Options{
+		"extra_path":         []string{dir},
+		"pinned_executables": map[string]string{"pinned-tool": hex.EncodeToString(sum[:])},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	if output, err := r.Run(context.Background(), "", "pinned-tool", nil, nil, false); err != nil || output != "pinned" {
+		t.Fatalf("Run() = %q, %v, want the output of the pinned tool", output, err)
+	}
+
+	// a tampered binary is refused, in shell commands and commands started directly
+	if err := os.WriteFile(tool, []byte("#!/bin/sh\necho tampered\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := r.Run(context.Background(), "", "echo start; pinned-tool", nil, nil, false); !errors.Is(err, ErrExecutableTampered) {
+		t.Errorf("Run() = %q, %v, want ErrExecutableTampered", output, err)
+	}
+	if _, err := r.start(context.Background(), "pinned-tool", nil, nil, nil); !errors.Is(err, ErrExecutableTampered) {
+		t.Errorf("start() error = %v, want ErrExecutableTampered", err)
+	}
+
+	// a binary with the name of a pinned executable, somewhere else
+	other := filepath.Join(t.TempDir(), "pinned-tool")
+	if err := os.WriteFile(other, content, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r, err = NewExec(Options{"pinned_executables": map[string]string{tool: hex.EncodeToString(sum[:])}}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	if _, err := r.Run(context.Background(), "", other, nil, nil, false); !errors.Is(err, ErrExecutableTampered) ||
+		!strings.Contains(err.Error(), "instead of") {
+		t.Errorf("Run() error = %v, want the executable to resolve elsewhere", err)
+	}
+}
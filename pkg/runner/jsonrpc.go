@@ -0,0 +1,372 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrJSONRPCClosed is returned by the calls of a JSON-RPC connection once it
+// is closed, or when the command exits
+var ErrJSONRPCClosed = errors.New("JSON-RPC connection closed")
+
+// JSON-RPC error codes
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+)
+
+// JSONRPCError is the error of a JSON-RPC response
+type JSONRPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Error implements the error interface
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("JSON-RPC error %d: %s", e.Code, e.Message)
+}
+
+// JSONRPCHandler handles the requests and notifications sent by the command.
+// The result is sent back for requests (and ignored for notifications);
+// returning a *JSONRPCError sends it as is, and other errors are sent as
+// internal errors.
+type JSONRPCHandler func(ctx context.Context, method string, params json.RawMessage) (interface{}, error)
+
+// JSONRPCOptions are the options of StartJSONRPC
+type JSONRPCOptions struct {
+	// Handler handles the requests and notifications of the command. Without
+	// a handler, requests are answered with a "method not found" error.
+	Handler JSONRPCHandler
+
+	// Stderr receives the standard error of the command (discarded when nil)
+	Stderr io.Writer
+}
+
+// jsonrpcMessage is any JSON-RPC message read
+type jsonrpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+}
+
+// jsonrpcRequest is a request or a notification written
+type jsonrpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      *int64      `json:"id,omitempty"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// jsonrpcResponse is a response written
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+// jsonrpcErrorResponse is an error response written (without a result)
+type jsonrpcErrorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   *JSONRPCError   `json:"error"`
+}
+
+// JSONRPCConn is a JSON-RPC 2.0 connection with messages framed with a
+// Content-Length header, as in the Language Server Protocol, usually over the
+// standard input and output of a command (see StartJSONRPC).
+//
+// Calls can be made concurrently, and their responses are correlated by ID.
+type JSONRPCConn struct {
+	// Execution is the command, when started with StartJSONRPC
+	Execution *Execution
+
+	reader  *bufio.Reader
+	writer  io.WriteCloser
+	handler JSONRPCHandler
+	cancel  context.CancelFunc
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[string]chan *jsonrpcMessage
+	err     error
+
+	done chan struct{}
+}
+
+// NewJSONRPCConn creates a JSON-RPC connection reading messages from r and
+// writing them to w, e.g. the pipes returned by RunWithPipes. The handler
+// can be nil.
+func NewJSONRPCConn(r io.Reader, w io.WriteCloser, handler JSONRPCHandler) *JSONRPCConn {
+	c := &JSONRPCConn{
+		reader:  bufio.NewReader(r),
+		writer:  w,
+		handler: handler,
+		pending: map[string]chan *jsonrpcMessage{},
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+// StartJSONRPC starts a command speaking JSON-RPC over its standard input and
+// output (e.g. a language server), and returns the connection to it. Use
+// Shutdown to stop the command.
+func StartJSONRPC(ctx context.Context, r Runner, cmd string, args []string, env []string, params map[string]interface{},
+	opts JSONRPCOptions, execOpts ...ExecOption) (*JSONRPCConn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	e, err := Start(ctx, r, cmd, args, env, params, execOpts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	stderr := opts.Stderr
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	go func() { _, _ = io.Copy(stderr, e.Stderr) }()
+
+	c := NewJSONRPCConn(e.Stdout, e.Stdin, opts.Handler)
+	c.Execution = e
+	c.cancel = cancel
+	return c, nil
+}
+
+// Call sends a request and waits for its response, decoding its result into
+// result (unless it is nil). Error responses are returned as *JSONRPCError.
+func (c *JSONRPCConn) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := c.nextID
+	key := strconv.FormatInt(id, 10)
+	response := make(chan *jsonrpcMessage, 1)
+	c.pending[key] = response
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, key)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(jsonrpcRequest{JSONRPC: "2.0", ID: &id, Method: method, Params: params}); err != nil {
+		return err
+	}
+
+	select {
+	case msg := <-response:
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result == nil || len(msg.Result) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			return fmt.Errorf("invalid result of %s: %w", method, err)
+		}
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Notify sends a notification, which has no response
+func (c *JSONRPCConn) Notify(method string, params interface{}) error {
+	return c.write(jsonrpcRequest{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// Shutdown stops the command with the shutdown handshake of the Language
+// Server Protocol: a "shutdown" request, followed by an "exit" notification.
+// It then closes the standard input of the command and waits for it to
+// exit. When ctx expires first, the command is killed.
+func (c *JSONRPCConn) Shutdown(ctx context.Context) error {
+	err := c.Call(ctx, "shutdown", nil, nil)
+	if err == nil {
+		err = c.Notify("exit", nil)
+	}
+	closeErr := c.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if c.Execution == nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- c.Execution.Wait() }()
+	select {
+	case waitErr := <-exited:
+		if err == nil {
+			err = waitErr
+		}
+	case <-ctx.Done():
+		c.cancel()
+		<-exited
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	c.cancel()
+	return err
+}
+
+// Close closes the writer of the connection (the standard input of the
+// command). The pending calls fail once the command closes its output.
+func (c *JSONRPCConn) Close() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writer.Close()
+}
+
+// Done is closed when the connection is closed by the other side
+func (c *JSONRPCConn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection was closed, or nil while it is open
+func (c *JSONRPCConn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// write sends a message
+func (c *JSONRPCConn) write(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode the JSON-RPC message: %w", err)
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := WriteFramedMessage(c.writer, data); err != nil {
+		if closeErr := c.Err(); closeErr != nil {
+			return closeErr
+		}
+		return fmt.Errorf("failed to send the JSON-RPC message: %w", err)
+	}
+	return nil
+}
+
+// readLoop reads the messages, dispatching the responses to the pending
+// calls, and the requests and notifications to the handler
+func (c *JSONRPCConn) readLoop() {
+	var err error
+	for {
+		var data []byte
+		if data, err = ReadFramedMessage(c.reader); err != nil {
+			break
+		}
+		var msg jsonrpcMessage
+		if json.Unmarshal(data, &msg) != nil {
+			// the ID is unknown, so the error has a null ID
+			_ = c.write(jsonrpcErrorResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: &JSONRPCError{Code: JSONRPCParseError, Message: "invalid JSON"}})
+			continue
+		}
+
+		switch {
+		case msg.Method != "":
+			go c.handle(&msg)
+		case len(msg.ID) > 0:
+			c.mu.Lock()
+			response, ok := c.pending[strings.TrimSpace(string(msg.ID))]
+			c.mu.Unlock()
+			if ok {
+				select {
+				case response <- &msg:
+				default:
+					// a duplicate response
+				}
+			}
+		}
+	}
+
+	if errors.Is(err, io.EOF) {
+		err = ErrJSONRPCClosed
+	} else {
+		err = fmt.Errorf("%w: %v", ErrJSONRPCClosed, err)
+	}
+	c.mu.Lock()
+	c.err = err
+	c.mu.Unlock()
+	close(c.done)
+}
+
+// handle runs the handler for a request or notification, and answers the
+// requests
+func (c *JSONRPCConn) handle(msg *jsonrpcMessage) {
+	var result interface{}
+	var err error
+	if c.handler != nil {
+		result, err = c.handler(context.Background(), msg.Method, msg.Params)
+	} else {
+		err = &JSONRPCError{Code: JSONRPCMethodNotFound, Message: "method not found: " + msg.Method}
+	}
+	if len(msg.ID) == 0 {
+		// notifications have no response
+		return
+	}
+
+	if err != nil {
+		var rpcErr *JSONRPCError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &JSONRPCError{Code: JSONRPCInternalError, Message: err.Error()}
+		}
+		_ = c.write(jsonrpcErrorResponse{JSONRPC: "2.0", ID: msg.ID, Error: rpcErr})
+		return
+	}
+	_ = c.write(jsonrpcResponse{JSONRPC: "2.0", ID: msg.ID, Result: result})
+}
+
+// ReadFramedMessage reads a message framed with a Content-Length header, as
+// in the Language Server Protocol and the Debug Adapter Protocol. Other
+// headers (e.g. Content-Type) are ignored.
+func ReadFramedMessage(r *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		if errors.Is(err, io.EOF) && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("invalid message header: %w", err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("truncated message: %w", err)
+	}
+	return data, nil
+}
+
+// WriteFramedMessage writes a message framed with a Content-Length header
+func WriteFramedMessage(w io.Writer, data []byte) error {
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
@@ -0,0 +1,208 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// jsonrpcPair returns two connections talking to each other
+func jsonrpcPair(client, server JSONRPCHandler) (*JSONRPCConn, *JSONRPCConn) {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	return NewJSONRPCConn(clientReader, clientWriter, client), NewJSONRPCConn(serverReader, serverWriter, server)
+}
+
+func TestFramedMessage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFramedMessage(&buf, []byte(`{"a":1}`)); err != nil {
+		t.Fatalf("WriteFramedMessage failed: %v", err)
+	}
+	if buf.String() != "Content-Length: 7\r\n\r\n{\"a\":1}" {
+		t.Errorf("framed message = %q", buf.String())
+	}
+
+	r := bufio.NewReader(strings.NewReader(
+		"Content-Length: 2\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\n\r\n{}" +
+			"Content-Length: 4\r\n\r\nnull"))
+	for _, want := range []string{"{}", "null"} {
+		data, err := ReadFramedMessage(r)
+		if err != nil || string(data) != want {
+			t.Errorf("ReadFramedMessage() = %q, %v, want %q", data, err, want)
+		}
+	}
+	if _, err := ReadFramedMessage(r); !errors.Is(err, io.EOF) {
+		t.Errorf("ReadFramedMessage() at the end = %v, want EOF", err)
+	}
+
+	for _, invalid := range []string{"Content-Type: x\r\n\r\n{}", "Content-Length: 10\r\n\r\n{}"} {
+		if _, err := ReadFramedMessage(bufio.NewReader(strings.NewReader(invalid))); err == nil || errors.Is(err, io.EOF) {
+			t.Errorf("ReadFramedMessage(%q) = %v, want an error", invalid, err)
+		}
+	}
+}
+
+func TestJSONRPCConn_Call(t *testing.T) {
+	client, server := jsonrpcPair(nil, func(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+		switch method {
+		case "add":
+			var numbers []int
+			if err := json.Unmarshal(params, &numbers); err != nil {
+				return nil, &JSONRPCError{Code: JSONRPCInvalidParams, Message: err.Error()}
+			}
+			// answer out of order, so the responses must be correlated
+			time.Sleep(time.Duration(numbers[0]) * time.Millisecond)
+			return numbers[0] + numbers[1], nil
+		case "fail":
+			return nil, errors.New("boom")
+		}
+		return nil, &JSONRPCError{Code: JSONRPCMethodNotFound, Message: method}
+	})
+	defer func() { _ = server.Close() }()
+	defer func() { _ = client.Close() }()
+
+	ctx := context.Background()
+	results := make(chan error, 3)
+	for _, delay := range []int{30, 10, 0} {
+		go func(delay int) {
+			var sum int
+			err := client.Call(ctx, "add", []int{delay, 1}, &sum)
+			if err == nil && sum != delay+1 {
+				err = errors.New("unexpected sum")
+			}
+			results <- err
+		}(delay)
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("Call(add) failed: %v", err)
+		}
+	}
+
+	var rpcErr *JSONRPCError
+	if err := client.Call(ctx, "fail", nil, nil); !errors.As(err, &rpcErr) || rpcErr.Code != JSONRPCInternalError || rpcErr.Message != "boom" {
+		t.Errorf("Call(fail) = %v, want an internal error", err)
+	}
+	if err := client.Call(ctx, "missing", nil, nil); !errors.As(err, &rpcErr) || rpcErr.Code != JSONRPCMethodNotFound {
+		t.Errorf("Call(missing) = %v, want method not found", err)
+	}
+}
+
+func TestJSONRPCConn_serverRequests(t *testing.T) {
+	notified := make(chan string, 1)
+	client, server := jsonrpcPair(func(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+		if method == "window/logMessage" {
+			notified <- string(params)
+			return nil, nil
+		}
+		return "configured", nil
+	}, nil)
+	defer func() { _ = server.Close() }()
+	defer func() { _ = client.Close() }()
+
+	var answer string
+	if err := server.Call(context.Background(), "workspace/configuration", nil, &answer); err != nil || answer != "configured" {
+		t.Errorf("Call() = %q, %v", answer, err)
+	}
+	if err := server.Notify("window/logMessage", map[string]string{"message": "hello"}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	select {
+	case params := <-notified:
+		if params != `{"message":"hello"}` {
+			t.Errorf("notification params = %s", params)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the notification was not handled")
+	}
+
+	// the client has no handler for the server
+	var rpcErr *JSONRPCError
+	if err := client.Call(context.Background(), "anything", nil, nil); !errors.As(err, &rpcErr) || rpcErr.Code != JSONRPCMethodNotFound {
+		t.Errorf("Call() without a handler = %v, want method not found", err)
+	}
+}
+
+func TestJSONRPCConn_closed(t *testing.T) {
+	client, server := jsonrpcPair(nil, func(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+		select {} // never answers
+	})
+
+	errs := make(chan error, 1)
+	go func() { errs <- client.Call(context.Background(), "hang", nil, nil) }()
+	time.Sleep(50 * time.Millisecond)
+	_ = server.Close()
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrJSONRPCClosed) {
+			t.Errorf("pending Call() = %v, want ErrJSONRPCClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the pending call did not fail")
+	}
+	<-client.Done()
+	if err := client.Call(context.Background(), "again", nil, nil); !errors.Is(err, ErrJSONRPCClosed) {
+		t.Errorf("Call() after closing = %v, want ErrJSONRPCClosed", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	other, _ := jsonrpcPair(nil, func(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+		select {}
+	})
+	if err := other.Call(ctx, "hang", nil, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Call() past the deadline = %v", err)
+	}
+}
+
+func TestStartJSONRPC(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+
+	// cat echoes the messages, so the requests are answered by our own
+	// handler through the command
+	var methods []string
+	handled := make(chan string, 10)
+	conn, err := StartJSONRPC(context.Background(), r, "cat", nil, nil, nil, JSONRPCOptions{
+		Handler: func(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+			handled <- method
+			return method + "-result", nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("StartJSONRPC failed: %v", err)
+	}
+
+	var result string
+	if err := conn.Call(context.Background(), "initialize", nil, &result); err != nil || result != "initialize-result" {
+		t.Errorf("Call(initialize) = %q, %v", result, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := conn.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+	if status := conn.Execution.ExitStatus(); status.Code != 0 {
+		t.Errorf("exit status = %+v, want 0", status)
+	}
+	for len(methods) < 2 {
+		methods = append(methods, <-handled)
+	}
+	if !strings.HasPrefix(strings.Join(methods, ","), "initialize,shutdown") {
+		t.Errorf("handled methods = %v, want the initialize and shutdown requests", methods)
+	}
+}
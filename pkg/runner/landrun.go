@@ -4,16 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"runtime"
+	"slices"
 	"strings"
 
 	"github.com/inercia/go-restricted-runner/pkg/common"
 	"github.com/landlock-lsm/go-landlock/landlock"
+	llsyscall "github.com/landlock-lsm/go-landlock/landlock/syscall"
 )
 
 // Landrun implements the Runner interface using Linux Landlock LSM.
@@ -29,11 +29,14 @@ import (
 //
 // This is a fundamental limitation of how Landlock works in the Linux kernel.
 // For complete isolation between commands with different restrictions, consider:
+//   - Enabling the experimental "landlock_helper" feature, which applies the
+//     restrictions in a helper process re-executing this executable, right
+//     before it executes the command (see FeatureLandlockHelper)
 //   - Running each command in a separate process
 //   - Using the Docker runner which provides process-level isolation
 //   - Using Firejail which spawns separate sandboxed processes
 type Landrun struct {
-	logger  *common.Logger
+	logger  Logger
 	options LandrunOptions
 }
 
@@ -55,6 +58,57 @@ type LandrunOptions struct {
 
 	// Best effort mode - gracefully degrade on older kernels
 	BestEffort bool `json:"best_effort"`
+
+	// Report the files changed in the writable folders (see Execution.FileChanges)
+	ReportFileChanges bool `json:"report_file_changes"`
+
+	// Umask and file mode policy
+	FileModeOptions
+
+	// Core dump policy
+	CoreDumpOptions
+
+	// Sandbox verification with canaries
+	CanaryOptions
+
+	// Command run in the sandbox before the commands
+	PreflightOptions
+
+	// Folders searched for executables
+	PathOptions
+
+	// Clock and locale settings
+	DeterminismOptions
+
+	// Variables of the commands read from .env files
+	EnvFileOptions
+
+	// Experimental features
+	ExperimentalOptions
+
+	// CA certificates trusted by the command
+	CABundleOptions
+
+	// Access to the credential agents of the host
+	AgentOptions
+
+	// Access to the display server of the host
+	DisplayOptions
+
+	// Namespaces of the command
+	NamespaceOptions
+
+	// Ephemeral user of the commands
+	EphemeralUIDOptions
+
+	// System calls of the commands
+	SeccompOptions
+
+	// Timeout of the commands
+	TimeoutOptions
+
+	// Limits of the output of the commands
+	OutputLimitOptions
 }
 
 // NewLandrunOptions creates a new LandrunOptions from Options
@@ -70,10 +124,8 @@ func NewLandrunOptions(options Options) (LandrunOptions, error) {
 
 // NewLandrun creates a new Landrun runner with the provided logger.
 // If logger is nil, a default logger is created.
-func NewLandrun(options Options, logger *common.Logger) (*Landrun, error) {
-	if logger == nil {
-		logger = common.GetLogger()
-	}
+func NewLandrun(options Options, logger Logger) (*Landrun, error) {
+	logger = defaultLogger(logger)
 
 	// Parse landrun-specific options
 	landrunOpts, err := NewLandrunOptions(options)
@@ -81,6 +133,55 @@ func NewLandrun(options Options, logger *common.Logger) (*Landrun, error) {
 		logger.Debug("Failed to parse landrun options: %v", err)
 		return nil, fmt.Errorf("failed to parse landrun options: %w", err)
 	}
+	landrunOpts.normalizeRules()
+	if err := landrunOpts.validate(); err != nil {
+		return nil, fmt.Errorf("invalid landrun options: %w", err)
+	}
+	if err := landrunOpts.resolveFakeTime(); err != nil {
+		return nil, fmt.Errorf("invalid landrun options: %w", err)
+	}
+	if err := landrunOpts.validateCABundle(); err != nil {
+		return nil, fmt.Errorf("invalid landrun options: %w", err)
+	}
+	if err := landrunOpts.validateTimeouts(); err != nil {
+		return nil, fmt.Errorf("invalid landrun options: %w", err)
+	}
+	if err := landrunOpts.loadEnvFiles(); err != nil {
+		return nil, fmt.Errorf("invalid landrun options: %w", err)
+	}
+	if err := landrunOpts.validateOutputLimits(); err != nil {
+		return nil, fmt.Errorf("invalid landrun options: %w", err)
+	}
+	if err := landrunOpts.validateNamespaces(); err != nil {
+		return nil, fmt.Errorf("invalid landrun options: %w", err)
+	}
+	if err := landrunOpts.validateEphemeralUID(landrunOpts.NamespaceOptions); err != nil {
+		return nil, fmt.Errorf("invalid landrun options: %w", err)
+	}
+	if err := landrunOpts.loadSeccompProfile(); err != nil {
+		return nil, fmt.Errorf("invalid landrun options: %w", err)
+	}
+	if err := landrunOpts.validateSeccompFilter(); err != nil {
+		return nil, fmt.Errorf("invalid landrun options: %w", err)
+	}
+	if err := landrunOpts.validateCoreDumps(); err != nil {
+		return nil, fmt.Errorf("invalid landrun options: %w", err)
+	}
+	if err := landrunOpts.validateCanaries(); err != nil {
+		return nil, fmt.Errorf("invalid landrun options: %w", err)
+	}
+	if err := landrunOpts.validatePreflight(); err != nil {
+		return nil, fmt.Errorf("invalid landrun options: %w", err)
+	}
+	if err := landrunOpts.validateExtraPath(); err != nil {
+		return nil, fmt.Errorf("invalid landrun options: %w", err)
+	}
+	if err := landrunOpts.validatePinnedExecutables(); err != nil {
+		return nil, fmt.Errorf("invalid landrun options: %w", err)
+	}
+	if err := landrunOpts.validateProcAccess(); err != nil {
+		return nil, fmt.Errorf("invalid landrun options: %w", err)
+	}
 
 	return &Landrun{
 		logger:  logger,
@@ -88,33 +189,183 @@ func NewLandrun(options Options, logger *common.Logger) (*Landrun, error) {
 	}, nil
 }
 
+// validateProcAccess checks that the command cannot read the /proc of the
+// host with private_pids, as a new /proc cannot be mounted once Landlock
+// restrictions are applied
+func (o LandrunOptions) validateProcAccess() error {
+	if !o.PrivatePIDs || o.UnrestrictedFilesystem {
+		return nil
+	}
+	folders := append([]string{}, o.AllowReadFolders...)
+	folders = append(folders, o.AllowReadExecFolders...)
+	folders = append(folders, o.AllowWriteFolders...)
+	folders = append(folders, o.AllowWriteExecFolders...)
+	for _, folder := range folders {
+		if isSubPath(folder, "/proc") {
+			return fmt.Errorf("private_pids cannot hide the processes of the host when %s is allowed", folder)
+		}
+	}
+	return nil
+}
+
+// fileChangeFolders returns the writable folders when file changes must be reported
+func (r *Landrun) fileChangeFolders(params map[string]interface{}) []string {
+	if !r.options.ReportFileChanges {
+		return nil
+	}
+	return r.writeFolders(params)
+}
+
+// writeFolders returns the writable folders, with template variables replaced
+// with params, plus the workspace and scratch directories
+func (r *Landrun) writeFolders(params map[string]interface{}) []string {
+	folders := append([]string{}, r.options.AllowWriteFolders...)
+	folders = append(folders, r.options.AllowWriteExecFolders...)
+	return withWritableDirs(common.ProcessTemplateListFlexible(folders, params), params)
+}
+
+// canaryPolicy returns what the canaries verify (see CanaryOptions): reading
+// outside the allowed folders is denied, and the network is only restricted
+// with rules for some ports (or a loopback network)
+func (r *Landrun) canaryPolicy(ctx context.Context, params map[string]interface{}) canaryPolicy {
+	allowed := []string{"/dev", "/tmp", r.options.CABundle}
+	for _, folders := range [][]string{r.options.AllowReadFolders, r.options.AllowReadExecFolders} {
+		allowed = append(allowed, common.ProcessTemplateListFlexible(folders, params)...)
+	}
+	allowed = append(allowed, r.writeFolders(params)...)
+	return canaryPolicy{
+		plant:   !r.options.UnrestrictedFilesystem,
+		allowed: withInputsDir(allowed, params),
+		networkDenied: (!r.options.AllowNetworking &&
+			(len(r.options.AllowBindTCP) > 0 || len(r.options.AllowConnectTCP) > 0)) || loopbackNetworkFrom(ctx),
+	}
+}
+
 // CheckImplicitRequirements verifies that Landlock is available on the system.
 // This check is side-effect-free and does not apply any restrictions to the current process.
 func (r *Landrun) CheckImplicitRequirements() error {
 	if runtime.GOOS != "linux" {
 		return fmt.Errorf("landrun runner requires Linux")
 	}
+	if err := r.options.checkEphemeralUID(); err != nil {
+		return err
+	}
 
-	// Side-effect-free check: verify that the Landlock securityfs interface exists.
-	// This avoids applying any Landlock rules to the current process while still
-	// providing an early indication of availability.
-	if _, err := os.Stat("/sys/kernel/security/landlock"); err != nil {
-		return fmt.Errorf("landlock not available on this kernel: %w", err)
+	if err := landlockAvailable(); err != nil {
+		if r.options.ReadOnlyRoot {
+			r.logger.Debug("Landlock is not available, the read-only root is used instead: %v", err)
+			return nil
+		}
+		return err
 	}
 
 	r.logger.Debug("Landlock is available on this system")
 	return nil
 }
 
+// landlockAvailable returns an error when Landlock is not available on this system.
+//
+// Side-effect-free check: verify that the Landlock securityfs interface exists.
+// This avoids applying any Landlock rules to the current process while still
+// providing an early indication of availability.
+func landlockAvailable() error {
+	if _, err := os.Stat("/sys/kernel/security/landlock"); err != nil {
+		return fmt.Errorf("landlock not available on this kernel: %w", err)
+	}
+	return nil
+}
+
+// landlockABI returns the Landlock ABI version of the kernel
+var landlockABI = func() (int, error) {
+	if err := landlockAvailable(); err != nil {
+		return 0, err
+	}
+	return llsyscall.LandlockGetABIVersion()
+}
+
+// policyConflicts returns the paths both read-only and writable in the
+// options. Landlock rules add up, so they are writable.
+func (r *Landrun) policyConflicts() []PolicyWarning {
+	return pathConflictWarnings(TypeLandrun,
+		map[string][]string{"allow_read_folders": r.options.AllowReadFolders, "allow_read_exec_folders": r.options.AllowReadExecFolders},
+		map[string][]string{"allow_write_folders": r.options.AllowWriteFolders, "allow_write_exec_folders": r.options.AllowWriteExecFolders},
+		false)
+}
+
+// policyWarnings returns the conflicting paths of the options, and the
+// restrictions of the options that are not enforced with the Landlock ABI
+// of the kernel
+func (r *Landrun) policyWarnings(context.Context) []PolicyWarning {
+	warnings := r.policyConflicts()
+	warn := func(feature string, option string, format string, args ...interface{}) {
+		warnings = append(warnings, PolicyWarning{Runner: TypeLandrun, Feature: feature, Option: option,
+			Message: fmt.Sprintf(format, args...)})
+	}
+
+	networkRules := len(r.options.AllowBindTCP) > 0 || len(r.options.AllowConnectTCP) > 0
+	if !r.options.AllowNetworking && !networkRules {
+		warn("network", "allow_networking", "Landlock only restricts the network when allow_bind_tcp or "+
+			"allow_connect_tcp list ports: the network is not restricted")
+	}
+
+	abi, err := landlockABI()
+	switch {
+	case err != nil || abi < 1:
+		if r.options.ReadOnlyRoot {
+			warn("landlock", "read_only_root", "Landlock is not available: only the read-only root restricts "+
+				"the command, and the network is not restricted")
+		} else if r.options.BestEffort {
+			warn("landlock", "best_effort", "Landlock is not available: the command is not restricted")
+		}
+	case r.options.BestEffort && !r.options.AllowNetworking && networkRules && abi < 4:
+		warn("landlock_network", "best_effort", "the Landlock ABI v%d of the kernel cannot restrict the network "+
+			"(v4, in kernel 6.7+, is required): the TCP ports are not restricted", abi)
+	}
+	return warnings
+}
+
+// useLandlock returns whether the Landlock rules must be applied. With
+// read_only_root, the read-only view of the filesystem replaces Landlock when
+// it is not available.
+func (r *Landrun) useLandlock(logger Logger, rules []landlock.Rule) bool {
+	if len(rules) == 0 {
+		return false
+	}
+	if r.options.ReadOnlyRoot && landlockAvailable() != nil {
+		logger.Warn("Landlock is not available: only the read-only root restricts the command, and the network is not restricted")
+		return false
+	}
+	return true
+}
+
+// namespaceSetup returns the namespaces of the command. Processes restricted
+// by Landlock cannot mount filesystems, so /proc is then hidden by the rules
+// instead, and the read-only root is not needed.
+func (r *Landrun) namespaceSetup(params map[string]interface{}, landlocked bool) namespaceSetup {
+	writable := append([]string{"/tmp"}, r.writeFolders(params)...)
+	return r.options.namespaceSetup(!landlocked, writable)
+}
+
 // buildLandlockRules constructs Landlock rules from the options and params
 func (r *Landrun) buildLandlockRules(params map[string]interface{}) ([]landlock.Rule, error) {
-	var rules []landlock.Rule
+	specs, err := r.landlockRuleSpecs(params)
+	if err != nil {
+		return nil, err
+	}
+	return landlockRules(specs), nil
+}
+
+// landlockRuleSpecs constructs the descriptions of the Landlock rules from
+// the options and params, which can be passed to the Landlock helper
+func (r *Landrun) landlockRuleSpecs(params map[string]interface{}) ([]landlockRuleSpec, error) {
+	var rules []landlockRuleSpec
 
 	// Process template variables in paths
 	allowReadFolders := r.options.AllowReadFolders
 	if len(allowReadFolders) > 0 {
 		allowReadFolders = common.ProcessTemplateListFlexible(allowReadFolders, params)
 	}
+	allowReadFolders = withInputsDir(allowReadFolders, params)
 
 	allowReadExecFolders := r.options.AllowReadExecFolders
 	if len(allowReadExecFolders) > 0 {
@@ -125,52 +376,73 @@ func (r *Landrun) buildLandlockRules(params map[string]interface{}) ([]landlock.
 	if len(allowWriteFolders) > 0 {
 		allowWriteFolders = common.ProcessTemplateListFlexible(allowWriteFolders, params)
 	}
+	allowWriteFolders = withWritableDirs(allowWriteFolders, params)
 
 	allowWriteExecFolders := r.options.AllowWriteExecFolders
 	if len(allowWriteExecFolders) > 0 {
 		allowWriteExecFolders = common.ProcessTemplateListFlexible(allowWriteExecFolders, params)
 	}
 
+	// The templates may expand to the same paths, or to unsorted ones
+	allowReadFolders = normalizePaths(allowReadFolders)
+	allowReadExecFolders = normalizePaths(allowReadExecFolders)
+	allowWriteFolders = normalizePaths(allowWriteFolders)
+	allowWriteExecFolders = normalizePaths(allowWriteExecFolders)
+
 	// Add filesystem rules
 	if !r.options.UnrestrictedFilesystem {
 		// Always allow access to /dev and /tmp for basic system operations
 		// /dev is required for process execution and I/O operations
 		// /tmp is required for temporary files used by tests and commands
 		r.logger.Debug("Adding read-write access to /dev and /tmp for system operations")
-		rules = append(rules, landlock.RWDirs("/dev", "/tmp"))
+		rules = append(rules, landlockRuleSpec{Kind: landlockRWDirs, Paths: []string{"/dev", "/tmp"}})
+
+		if r.options.CABundle != "" {
+			r.logger.Debug("Adding read-only access to the CA bundle: %s", r.options.CABundle)
+			rules = append(rules, landlockRuleSpec{Kind: landlockROFiles, Paths: []string{r.options.CABundle}})
+		}
 
 		if len(allowReadFolders) > 0 {
 			r.logger.Debug("Adding read-only access to: %v", allowReadFolders)
-			rules = append(rules, landlock.RODirs(allowReadFolders...))
+			rules = append(rules, landlockRuleSpec{Kind: landlockRODirs, Paths: allowReadFolders})
 		}
 
 		if len(allowReadExecFolders) > 0 {
 			r.logger.Debug("Adding read-execute access to: %v", allowReadExecFolders)
 			// RODirs already includes execute permissions for files
-			rules = append(rules, landlock.RODirs(allowReadExecFolders...))
+			rules = append(rules, landlockRuleSpec{Kind: landlockRODirs, Paths: allowReadExecFolders})
 		}
 
 		if len(allowWriteFolders) > 0 {
 			r.logger.Debug("Adding read-write access to: %v", allowWriteFolders)
-			rules = append(rules, landlock.RWDirs(allowWriteFolders...))
+			rules = append(rules, landlockRuleSpec{Kind: landlockRWDirs, Paths: allowWriteFolders})
 		}
 
 		if len(allowWriteExecFolders) > 0 {
 			r.logger.Debug("Adding read-write-execute access to: %v", allowWriteExecFolders)
-			rules = append(rules, landlock.RWDirs(allowWriteExecFolders...))
+			rules = append(rules, landlockRuleSpec{Kind: landlockRWDirs, Paths: allowWriteExecFolders})
 		}
 	}
 
 	// Add network rules (only if not allowing unrestricted networking)
 	if !r.options.AllowNetworking {
-		for _, port := range r.options.AllowBindTCP {
+		// Ports allocated for the execution (see WithFreePorts). Without
+		// other network rules the network is not restricted at all.
+		allowBindTCP := slices.Clone(r.options.AllowBindTCP)
+		if len(r.options.AllowBindTCP) > 0 || len(r.options.AllowConnectTCP) > 0 {
+			for _, port := range bindPorts(params) {
+				r.logger.Debug("Adding TCP bind permission for allocated port: %d", port)
+				allowBindTCP = append(allowBindTCP, uint16(port))
+			}
+		}
+		for _, port := range normalizePorts(allowBindTCP) {
 			r.logger.Debug("Adding TCP bind permission for port: %d", port)
-			rules = append(rules, landlock.BindTCP(port))
+			rules = append(rules, landlockRuleSpec{Kind: landlockBindTCP, Port: port})
 		}
 
 		for _, port := range r.options.AllowConnectTCP {
 			r.logger.Debug("Adding TCP connect permission for port: %d", port)
-			rules = append(rules, landlock.ConnectTCP(port))
+			rules = append(rules, landlockRuleSpec{Kind: landlockConnectTCP, Port: port})
 		}
 	}
 
@@ -188,13 +460,9 @@ func (r *Landrun) buildLandlockRules(params map[string]interface{}) ([]landlock.
 // - V5 (kernel 6.7+): Additional network features
 // - V6 (kernel 6.10+): Latest features
 func (r *Landrun) selectLandlockABI() landlock.Config {
-	// Check if network restrictions are requested
-	needsNetwork := !r.options.AllowNetworking &&
-		(len(r.options.AllowBindTCP) > 0 || len(r.options.AllowConnectTCP) > 0)
-
 	// Select ABI based on features needed
 	var config landlock.Config
-	if needsNetwork {
+	if r.landlockABIVersion() >= 4 {
 		// Network restrictions require V4+ (kernel 6.7+)
 		r.logger.Debug("Network restrictions requested, using Landlock V4+")
 		config = landlock.V4
@@ -213,6 +481,35 @@ func (r *Landrun) selectLandlockABI() landlock.Config {
 	return config
 }
 
+// normalizeRules sorts and deduplicates the paths and ports of the rules
+func (o *LandrunOptions) normalizeRules() {
+	o.AllowReadFolders = normalizePaths(o.AllowReadFolders)
+	o.AllowReadExecFolders = normalizePaths(o.AllowReadExecFolders)
+	o.AllowWriteFolders = normalizePaths(o.AllowWriteFolders)
+	o.AllowWriteExecFolders = normalizePaths(o.AllowWriteExecFolders)
+	o.AllowBindTCP = normalizePorts(o.AllowBindTCP)
+	o.AllowConnectTCP = normalizePorts(o.AllowConnectTCP)
+}
+
+// landlockABIVersion returns the Landlock ABI version required by the
+// options: 4 for restricting the network, 1 otherwise
+func (r *Landrun) landlockABIVersion() int {
+	if !r.options.AllowNetworking && (len(r.options.AllowBindTCP) > 0 || len(r.options.AllowConnectTCP) > 0) {
+		return 4
+	}
+	return 1
+}
+
+// landlockRulesText describes the Landlock configuration and rules, one per line
+func landlockRulesText(config landlock.Config, rules []landlock.Rule) []byte {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%v\n", config)
+	for _, rule := range rules {
+		fmt.Fprintf(&text, "%v\n", rule)
+	}
+	return []byte(text.String())
+}
+
 // Run executes a command with Landlock restrictions and returns the output.
 // It implements the Runner interface.
 //
@@ -224,6 +521,19 @@ func (r *Landrun) selectLandlockABI() landlock.Config {
 // at the process level before command execution.
 func (r *Landrun) Run(ctx context.Context, shell string, command string,
 	env []string, params map[string]interface{}, tmpfile bool) (string, error) {
+	return r.options.runWithTimeout(ctx, func(ctx context.Context) (string, error) {
+		return r.run(ctx, shell, command, env, params, tmpfile)
+	})
+}
+
+// run is Run, with the context bounded by the timeout options
+func (r *Landrun) run(ctx context.Context, shell string, command string,
+	env []string, params map[string]interface{}, tmpfile bool) (string, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+	env = r.options.pathEnv(env)
+	env = r.options.caBundleEnv(env, r.options.CABundle)
 
 	// Check if context is done
 	select {
@@ -233,77 +543,146 @@ func (r *Landrun) Run(ctx context.Context, shell string, command string,
 		// Continue execution
 	}
 
-	r.logger.Debug("Landrun: executing command with Landlock restrictions")
+	if err := r.options.verifySandbox(ctx, logger, r.canaryPolicy(ctx, params), r.start, params); err != nil {
+		return "", err
+	}
+	if err := r.options.runPreflight(ctx, logger, "/bin/sh", r.start, params); err != nil {
+		return "", err
+	}
+
+	toolsDir, removeTools, err := r.options.linkHermeticTools(logger)
+	if err != nil {
+		return "", err
+	}
+	defer removeTools()
+	env = hermeticEnv(env, toolsDir)
+	if err := r.options.verifyPinnedExecutables(logger, command); err != nil {
+		return "", err
+	}
+
+	logger.Debug("Landrun: executing command with Landlock restrictions")
 
 	// Build Landlock rules
-	rules, err := r.buildLandlockRules(params)
+	specs, err := r.landlockRuleSpecs(params)
 	if err != nil {
 		return "", fmt.Errorf("failed to build landlock rules: %w", err)
 	}
+	rules := landlockRules(specs)
 
-	// Apply Landlock restrictions to this process
+	landlocked := r.useLandlock(logger, rules)
+	inHelper := landlocked && r.useLandlockHelper()
+	if (r.options.PrivatePIDs || r.options.SeccompProfile != "") && landlocked && !inHelper {
+		// the command is started through this executable (see isolateNamespaces and applySeccomp)
+		self, err := os.Executable()
+		if err != nil {
+			return "", fmt.Errorf("failed to find the namespace helper: %w", err)
+		}
+		rules = append(rules, landlock.ROFiles(self))
+	}
+	if landlocked && !inHelper {
+		// the ephemeral user is mapped by the helpers (see applyEphemeralUID)
+		executables, err := r.options.ephemeralUIDExecutables()
+		if err != nil {
+			return "", err
+		}
+		if len(executables) > 0 {
+			rules = append(rules, landlock.ROFiles(executables...))
+		}
+	}
+
+	// Apply Landlock restrictions to this process, unless applied by the helper process
 	// Note: This affects the current process and all its children
 	// Only apply restrictions if we actually have rules to enforce
-	if len(rules) > 0 {
+	if inHelper {
+		logger.Debug("Landlock restrictions are applied by the helper process")
+	} else if landlocked {
 		// Select appropriate ABI based on requested features
 		config := r.selectLandlockABI()
 
-		r.logger.Debug("Applying Landlock restrictions with %d rules", len(rules))
+		logger.Debug("Applying Landlock restrictions with %d rules", len(rules))
 		if err := config.Restrict(rules...); err != nil {
 			return "", fmt.Errorf("failed to apply landlock restrictions: %w", err)
 		}
-		r.logger.Debug("Landlock restrictions applied successfully")
+		logger.Debug("Landlock restrictions applied successfully")
 	} else {
-		r.logger.Debug("No Landlock restrictions to apply (unrestricted mode)")
+		logger.Debug("No Landlock restrictions to apply")
 	}
 
 	// Now execute the command - it will inherit the Landlock restrictions
 	configShell := getShell(shell)
-	r.logger.Debug("Using shell: %s", configShell)
+	logger.Debug("Using shell: %s", configShell)
 
 	// Get the appropriate command arguments for this shell
 	shellPath, args := getShellCommandArgs(configShell, command)
-	execCmd := exec.CommandContext(ctx, shellPath, args...)
-	r.logger.Debug("Created command: %s with args %v", shellPath, args)
+	execCmd := commandContext(ctx, shellPath, args...)
+	logger.Debug("Created command: %s with args %v", shellPath, args)
 
 	// Set environment variables if provided
 	if len(env) > 0 {
-		r.logger.Debug("Adding %d environment variables to command", len(env))
+		logger.Debug("Adding %d environment variables to command", len(env))
 		for _, e := range env {
-			r.logger.Debug("... adding environment variable: %s", e)
+			categoryLogger(logger, LogCategoryEnvironment).Debug("... adding environment variable: %s", e)
 		}
 		execCmd.Env = append(os.Environ(), env...)
 	}
+	execCmd.Env = r.options.agentEnv(execCmd.Env)
+	execCmd.Env = r.options.scrubDisplayEnv(execCmd.Env)
+
+	applyUmask(logger, execCmd, r.options.Umask)
+	defer r.options.applyCoreDumps(logger, execCmd)()
+
+	if inHelper {
+		// the seccomp filter is installed by the Landlock helper
+		if err := r.restrictInHelper(logger, execCmd, specs); err != nil {
+			return "", err
+		}
+	} else if err := r.options.applySeccomp(logger, execCmd); err != nil {
+		return "", err
+	}
+	if err := r.options.applyEphemeralUID(logger, execCmd, false); err != nil {
+		return "", err
+	}
+	if err := isolateNamespaces(execCmd, r.namespaceSetup(params, landlocked)); err != nil {
+		return "", err
+	}
 
 	// Capture output
 	var stdout, stderr bytes.Buffer
-	execCmd.Stdout = &stdout
-	execCmd.Stderr = &stderr
+	execCmd.Stdout, execCmd.Stderr = captureRunOutput(ctx, &stdout, &stderr)
+	var truncated func() error
+	execCmd.Stdout, execCmd.Stderr, truncated = r.options.limitOutput(ctx, execCmd.Stdout, execCmd.Stderr)
 
 	// Run the command
-	r.logger.Debug("Executing command")
+	logger.Debug("Executing command")
+	defer r.options.normalizeFileModes(logger, r.writeFolders(params))
 
 	if err := execCmd.Run(); err != nil {
 		// If there's error output, include it in the error
 		if stderr.Len() > 0 {
 			errMsg := strings.TrimSpace(stderr.String())
-			r.logger.Debug("Command failed with stderr: %s", errMsg)
-			return "", errors.New(errMsg)
+			logger.Debug("Command failed with stderr: %s", errMsg)
+			return "", newCommandError(errMsg, err)
 		}
-		r.logger.Debug("Command failed with error: %v", err)
+		logger.Debug("Command failed with error: %v", err)
 		return "", err
 	}
 
 	// Get the output
 	outputStr := strings.TrimSpace(stdout.String())
 
-	r.logger.Debug("Command executed successfully, output length: %d bytes", len(outputStr))
+	logger.Debug("Command executed successfully, output length: %d bytes", len(outputStr))
 	if stderr.Len() > 0 {
-		r.logger.Debug("Command generated stderr (but no error): %s", strings.TrimSpace(stderr.String()))
+		logger.Debug("Command generated stderr (but no error): %s", strings.TrimSpace(stderr.String()))
 	}
 
 	// Return the stdout output
-	return outputStr, nil
+	return outputStr, truncated()
+}
+
+// RunEx executes a command with Landlock restrictions like Run, returning its exit code and
+// both output streams. It implements the Runner interface.
+func (r *Landrun) RunEx(ctx context.Context, req RunRequest) (*RunResult, error) {
+	return runEx(ctx, r, req)
 }
 
 // RunWithPipes executes a command with access to stdin/stdout/stderr pipes with Landlock restrictions.
@@ -322,85 +701,151 @@ func (r *Landrun) RunWithPipes(ctx context.Context, cmd string, args []string, e
 	wait func() error,
 	err error,
 ) {
+	e, err := r.start(ctx, cmd, args, env, params)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return e.Stdin, e.Stdout, e.Stderr, e.Wait, nil
+}
+
+// start executes a command with Landlock restrictions and returns an execution handle for it.
+// It is used by RunWithPipes and Start.
+func (r *Landrun) start(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	return r.options.startWithTimeout(ctx, func(ctx context.Context) (*Execution, error) {
+		return r.startCommand(ctx, cmd, args, env, params)
+	})
+}
+
+// startCommand is start, with the context bounded by the timeout options
+func (r *Landrun) startCommand(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+	env = r.options.pathEnv(env)
+	env = r.options.caBundleEnv(env, r.options.CABundle)
+
 	// Check if context is already done
 	select {
 	case <-ctx.Done():
-		return nil, nil, nil, nil, ctx.Err()
+		return nil, ctx.Err()
 	default:
 		// Continue execution
 	}
 
-	r.logger.Debug("RunWithPipes: executing command with Landlock: %s with args: %v", cmd, args)
+	if err := r.options.verifySandbox(ctx, logger, r.canaryPolicy(ctx, params), r.start, params); err != nil {
+		return nil, err
+	}
+	if err := r.options.runPreflight(ctx, logger, "/bin/sh", r.start, params); err != nil {
+		return nil, err
+	}
+
+	// the hermetic tools are removed once the command completes (see startProcess)
+	toolsDir, removeTools, err := r.options.linkHermeticTools(logger)
+	if err != nil {
+		return nil, err
+	}
+	started := false
+	defer func() {
+		if !started {
+			removeTools()
+		}
+	}()
+	env = hermeticEnv(env, toolsDir)
+	if cmd, err = r.options.hermeticCommand(ctx, toolsDir, cmd); err != nil {
+		return nil, err
+	}
+	if err := r.options.verifyPinnedExecutables(logger, append([]string{cmd}, args...)...); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("RunWithPipes: executing command with Landlock: %s with args: %v", cmd, args)
 
 	// Build Landlock rules
-	rules, err := r.buildLandlockRules(params)
+	specs, err := r.landlockRuleSpecs(params)
 	if err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("failed to build landlock rules: %w", err)
+		return nil, fmt.Errorf("failed to build landlock rules: %w", err)
+	}
+	rules := landlockRules(specs)
+	landlocked := r.useLandlock(logger, rules)
+	inHelper := landlocked && r.useLandlockHelper()
+	if (loopbackNetworkFrom(ctx) || r.options.PrivatePIDs || r.options.SeccompProfile != "") && landlocked && !inHelper {
+		// the command is started through this executable (see WithLoopbackNetwork, isolateNamespaces
+		// and applySeccomp)
+		self, err := os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the namespace helpers: %w", err)
+		}
+		rules = append(rules, landlock.ROFiles(self))
+	}
+	if landlocked && !inHelper {
+		// the ephemeral user is mapped by the helpers (see applyEphemeralUID)
+		executables, err := r.options.ephemeralUIDExecutables()
+		if err != nil {
+			return nil, err
+		}
+		if len(executables) > 0 {
+			rules = append(rules, landlock.ROFiles(executables...))
+		}
 	}
 
-	// Apply Landlock restrictions to this process
+	// Apply Landlock restrictions to this process, or to the helper process
 	// Only apply restrictions if we actually have rules to enforce
-	if len(rules) > 0 {
+	if inHelper {
+		recordReproFile(ctx, "landlock.rules", landlockRulesText(r.selectLandlockABI(), rules))
+		logger.Debug("Landlock restrictions are applied by the helper process")
+	} else if landlocked {
 		// Select appropriate ABI based on requested features
 		config := r.selectLandlockABI()
+		recordReproFile(ctx, "landlock.rules", landlockRulesText(config, rules))
 
-		r.logger.Debug("Applying Landlock restrictions with %d rules", len(rules))
+		logger.Debug("Applying Landlock restrictions with %d rules", len(rules))
 		if err := config.Restrict(rules...); err != nil {
-			return nil, nil, nil, nil, fmt.Errorf("failed to apply landlock restrictions: %w", err)
+			return nil, fmt.Errorf("failed to apply landlock restrictions: %w", err)
 		}
-		r.logger.Debug("Landlock restrictions applied successfully")
+		logger.Debug("Landlock restrictions applied successfully")
 	} else {
-		r.logger.Debug("No Landlock restrictions to apply (unrestricted mode)")
+		logger.Debug("No Landlock restrictions to apply")
 	}
 
 	// Create the command
-	execCmd := exec.CommandContext(ctx, cmd, args...)
+	execCmd := commandContext(ctx, cmd, args...)
 
 	// Set environment variables if provided
 	if len(env) > 0 {
-		r.logger.Debug("Adding %d environment variables to command", len(env))
+		logger.Debug("Adding %d environment variables to command", len(env))
 		execCmd.Env = append(os.Environ(), env...)
 	}
+	execCmd.Env = r.options.agentEnv(execCmd.Env)
+	execCmd.Env = r.options.scrubDisplayEnv(execCmd.Env)
+	execCmd.ExtraFiles = extraFilesFrom(ctx)
 
-	// Create pipes
-	stdinPipe, err := execCmd.StdinPipe()
-	if err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("failed to create stdin pipe: %w", err)
-	}
-
-	stdoutPipe, err := execCmd.StdoutPipe()
-	if err != nil {
-		_ = stdinPipe.Close()
-		return nil, nil, nil, nil, fmt.Errorf("failed to create stdout pipe: %w", err)
-	}
+	applyUmask(logger, execCmd, r.options.Umask)
+	collectCores := r.options.applyCoreDumps(logger, execCmd)
 
-	stderrPipe, err := execCmd.StderrPipe()
-	if err != nil {
-		_ = stdinPipe.Close()
-		_ = stdoutPipe.Close()
-		return nil, nil, nil, nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+	if inHelper {
+		// the seccomp filter is installed by the Landlock helper
+		if err := r.restrictInHelper(logger, execCmd, specs); err != nil {
+			return nil, err
+		}
+	} else if err := r.options.applySeccomp(logger, execCmd); err != nil {
+		return nil, err
 	}
-
-	// Start the command
-	if err := execCmd.Start(); err != nil {
-		_ = stdinPipe.Close()
-		_ = stdoutPipe.Close()
-		_ = stderrPipe.Close()
-		return nil, nil, nil, nil, fmt.Errorf("failed to start command: %w", err)
+	if err := r.options.applyEphemeralUID(logger, execCmd, loopbackNetworkFrom(ctx)); err != nil {
+		return nil, err
 	}
-
-	r.logger.Debug("Command started successfully with PID: %d", execCmd.Process.Pid)
-
-	// Create wait function
-	waitFunc := func() error {
-		err := execCmd.Wait()
-		if err != nil {
-			r.logger.Debug("Command exited with error: %v", err)
-		} else {
-			r.logger.Debug("Command exited successfully")
+	if loopbackNetworkFrom(ctx) {
+		if err := isolateLoopback(execCmd); err != nil {
+			return nil, err
 		}
-		return err
+	}
+	if err := isolateNamespaces(execCmd, r.namespaceSetup(params, landlocked)); err != nil {
+		return nil, err
 	}
 
-	return stdinPipe, stdoutPipe, stderrPipe, waitFunc, nil
+	started = true
+	return startProcess(logger, execCmd, func() {
+		r.options.normalizeFileModes(logger, r.writeFolders(params))
+		collectCores()
+		removeTools()
+	})
 }
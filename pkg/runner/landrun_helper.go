@@ -0,0 +1,97 @@
+package runner
+
+import (
+	"os/exec"
+
+	"github.com/landlock-lsm/go-landlock/landlock"
+)
+
+// landlockHelperEnv is set (to the JSON configuration of the helper) when
+// this executable is started as the helper that applies the Landlock rules
+// before running the command
+const landlockHelperEnv = "RUNNER_LANDLOCK_HELPER"
+
+// Kinds of the Landlock rules passed to the helper
+const (
+	landlockRODirs     = "ro_dirs"
+	landlockRWDirs     = "rw_dirs"
+	landlockROFiles    = "ro_files"
+	landlockBindTCP    = "bind_tcp"
+	landlockConnectTCP = "connect_tcp"
+)
+
+// landlockRuleSpec describes a Landlock rule, so it can be passed to the
+// Landlock helper
+type landlockRuleSpec struct {
+	Kind  string   `json:"kind"`
+	Paths []string `json:"paths,omitempty"`
+	Port  uint16   `json:"port,omitempty"`
+}
+
+// rule returns the Landlock rule described
+func (s landlockRuleSpec) rule() landlock.Rule {
+	switch s.Kind {
+	case landlockRWDirs:
+		return landlock.RWDirs(s.Paths...)
+	case landlockROFiles:
+		return landlock.ROFiles(s.Paths...)
+	case landlockBindTCP:
+		return landlock.BindTCP(s.Port)
+	case landlockConnectTCP:
+		return landlock.ConnectTCP(s.Port)
+	}
+	return landlock.RODirs(s.Paths...)
+}
+
+// landlockRules returns the Landlock rules described
+func landlockRules(specs []landlockRuleSpec) []landlock.Rule {
+	rules := make([]landlock.Rule, 0, len(specs))
+	for _, spec := range specs {
+		rules = append(rules, spec.rule())
+	}
+	return rules
+}
+
+// landlockHelperConfig is the configuration passed to the Landlock helper
+type landlockHelperConfig struct {
+	// ABI is the Landlock ABI version of the rules (1 or 4)
+	ABI int `json:"abi"`
+	// BestEffort degrades gracefully on older kernels
+	BestEffort bool `json:"best_effort,omitempty"`
+	// Rules are the rules applied
+	Rules []landlockRuleSpec `json:"rules"`
+	// Seccomp is the seccomp profile installed after the rules, if any
+	Seccomp *seccompProfile `json:"seccomp,omitempty"`
+}
+
+// restrict applies the Landlock rules to the current process
+func (c landlockHelperConfig) restrict() error {
+	config := landlock.V1
+	if c.ABI >= 4 {
+		config = landlock.V4
+	}
+	if c.BestEffort {
+		config = config.BestEffort()
+	}
+	return config.Restrict(landlockRules(c.Rules)...)
+}
+
+// useLandlockHelper returns whether the Landlock rules are applied in a
+// helper process (see FeatureLandlockHelper)
+func (r *Landrun) useLandlockHelper() bool {
+	return r.options.experimentEnabled(FeatureLandlockHelper)
+}
+
+// restrictInHelper changes cmd so the Landlock rules are applied in a child
+// process, right before it executes the command, instead of in this process.
+// It must be called before any other isolation of the command (e.g.
+// isolateLoopback), as the Landlock helper must be the innermost process.
+func (r *Landrun) restrictInHelper(logger Logger, cmd *exec.Cmd, specs []landlockRuleSpec) error {
+	logger.Debug("Applying Landlock restrictions with %d rules in the helper process", len(specs))
+	return landlockInHelper(cmd, landlockHelperConfig{
+		ABI:        r.landlockABIVersion(),
+		BestEffort: r.options.BestEffort,
+		Rules:      specs,
+		Seccomp:    r.options.seccomp,
+	})
+}
@@ -0,0 +1,72 @@
+//go:build linux
+
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// landlockInHelper changes cmd so it is started through this executable: the
+// helper mode (see runLandlockHelper) applies the Landlock rules to itself
+// and then executes the command, so the rules of every command only restrict
+// it, and not the process running the runner.
+func landlockInHelper(cmd *exec.Cmd, config landlockHelperConfig) error {
+	if cmd.Err != nil {
+		return nil
+	}
+
+	self, err := helperExecutable("Landlock helper")
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to configure the Landlock helper: %w", err)
+	}
+
+	cmd.Args = append([]string{"runner-landlock-helper", cmd.Path}, cmd.Args...)
+	cmd.Path = self
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, landlockHelperEnv+"="+string(data))
+	return nil
+}
+
+// runLandlockHelper applies the Landlock rules of its configuration (and
+// its seccomp filter, if any) and executes the command in os.Args[1:] (its path followed by its arguments).
+// It never returns.
+func runLandlockHelper() {
+	runtime.LockOSThread()
+
+	var config landlockHelperConfig
+	if err := json.Unmarshal([]byte(os.Getenv(landlockHelperEnv)), &config); err != nil {
+		fmt.Fprintf(os.Stderr, "runner: invalid Landlock helper configuration: %v\n", err)
+		os.Exit(126)
+	}
+	_ = os.Unsetenv(landlockHelperEnv)
+
+	if len(os.Args) < 3 {
+		fmt.Fprintln(os.Stderr, "runner: missing command for the Landlock helper")
+		os.Exit(126)
+	}
+	if err := config.restrict(); err != nil {
+		fmt.Fprintf(os.Stderr, "runner: failed to apply landlock restrictions: %v\n", err)
+		os.Exit(126)
+	}
+	if config.Seccomp != nil {
+		if err := installSeccompFilter(config.Seccomp); err != nil {
+			fmt.Fprintf(os.Stderr, "runner: %v\n", err)
+			os.Exit(126)
+		}
+	}
+
+	err := syscall.Exec(os.Args[1], os.Args[2:], os.Environ())
+	fmt.Fprintf(os.Stderr, "runner: failed to execute %s: %v\n", os.Args[1], err)
+	os.Exit(127)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package runner
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// landlockInHelper is only supported on Linux, where Landlock exists
+func landlockInHelper(cmd *exec.Cmd, config landlockHelperConfig) error {
+	return fmt.Errorf("the Landlock helper requires Linux: %w", ErrNotSupported)
+}
@@ -0,0 +1,140 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	llsyscall "github.com/landlock-lsm/go-landlock/landlock/syscall"
+)
+
+func TestLandlockRuleSpecs(t *testing.T) {
+	r, err := NewLandrun(Options{
+		"allow_read_folders":  []string{"{{.workdir}}"},
+		"allow_write_folders": []string{"/var/tmp"},
+		"allow_connect_tcp":   []uint16{443},
+		"ca_bundle":           "/etc/ssl/certs/ca-certificates.crt",
+		"experimental":        []string{"landlock_helper"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewLandrun failed: %v", err)
+	}
+	if !r.useLandlockHelper() {
+		t.Errorf("the landlock_helper feature should be enabled")
+	}
+
+	specs, err := r.landlockRuleSpecs(map[string]interface{}{"workdir": "/src"})
+	if err != nil {
+		t.Fatalf("landlockRuleSpecs failed: %v", err)
+	}
+	want := []landlockRuleSpec{
+		{Kind: landlockRWDirs, Paths: []string{"/dev", "/tmp"}},
+		{Kind: landlockROFiles, Paths: []string{"/etc/ssl/certs/ca-certificates.crt"}},
+		{Kind: landlockRODirs, Paths: []string{"/src"}},
+		{Kind: landlockRWDirs, Paths: []string{"/var/tmp"}},
+		{Kind: landlockConnectTCP, Port: 443},
+	}
+	got, _ := json.Marshal(specs)
+	expected, _ := json.Marshal(want)
+	if string(got) != string(expected) {
+		t.Errorf("landlockRuleSpecs() = %s, want %s", got, expected)
+	}
+	if rules := landlockRules(specs); len(rules) != len(specs) {
+		t.Errorf("landlockRules() returned %d rules, want %d", len(rules), len(specs))
+	}
+	if r.landlockABIVersion() != 4 {
+		t.Errorf("landlockABIVersion() = %d, want 4 for network rules", r.landlockABIVersion())
+	}
+}
+
+func TestLandlockInHelper(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the Landlock helper requires Linux")
+	}
+	cmd := exec.Command("/bin/echo", "hello")
+	config := landlockHelperConfig{ABI: 1, Rules: []landlockRuleSpec{{Kind: landlockRODirs, Paths: []string{"/usr"}}}}
+	if err := landlockInHelper(cmd, config); err != nil {
+		t.Fatalf("landlockInHelper failed: %v", err)
+	}
+	self, _ := os.Executable()
+	if cmd.Path != self || len(cmd.Args) != 4 || cmd.Args[1] != "/bin/echo" || cmd.Args[3] != "hello" {
+		t.Errorf("unexpected helper command: %s %v", cmd.Path, cmd.Args)
+	}
+	var found bool
+	for _, e := range cmd.Env {
+		if value, ok := strings.CutPrefix(e, landlockHelperEnv+"="); ok {
+			var decoded landlockHelperConfig
+			found = json.Unmarshal([]byte(value), &decoded) == nil && len(decoded.Rules) == 1
+		}
+	}
+	if !found {
+		t.Errorf("the helper configuration is not in the environment: %v", cmd.Env)
+	}
+
+	// the command is restricted, but not this process
+	if abi, err := llsyscall.LandlockGetABIVersion(); err != nil || abi < 1 {
+		t.Skip("Landlock not supported by the kernel")
+	}
+	allowed, denied := t.TempDir(), t.TempDir()
+	config = landlockHelperConfig{ABI: 1, Rules: []landlockRuleSpec{
+		{Kind: landlockRODirs, Paths: []string{"/usr", "/bin", "/lib", "/etc", allowed}},
+	}}
+	for _, dir := range []string{allowed, denied} {
+		cmd = exec.Command("/bin/ls", dir)
+		if err := landlockInHelper(cmd, config); err != nil {
+			t.Fatalf("landlockInHelper failed: %v", err)
+		}
+		output, err := cmd.CombinedOutput()
+		if (err == nil) != (dir == allowed) {
+			t.Errorf("listing %s through the helper = %q, %v", dir, output, err)
+		}
+	}
+	if _, err := os.ReadDir(denied); err != nil {
+		t.Errorf("the Landlock rules should not restrict this process: %v", err)
+	}
+}
+
+func TestLandrun_LandlockHelper_DifferentRules(t *testing.T) {
+	if !isLandlockAvailable() {
+		t.Skip("Landlock not available on this system")
+	}
+
+	first, second := t.TempDir(), t.TempDir()
+	for _, dir := range []string{first, second} {
+		if err := os.WriteFile(filepath.Join(dir, "file"), []byte(filepath.Base(dir)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	system := []string{"/usr", "/bin", "/lib", "/lib64", "/etc"}
+	newRunner := func(dir string) *Landrun {
+		r, err := NewLandrun(Options{
+			"allow_read_exec_folders": system,
+			"allow_read_folders":      []string{dir},
+			"experimental":            []string{"landlock_helper"},
+		}, nil)
+		if err != nil {
+			t.Fatalf("NewLandrun failed: %v", err)
+		}
+		return r
+	}
+
+	// each runner only reads its own folder, and this process is not restricted
+	ctx := context.Background()
+	for _, dirs := range [][2]string{{first, second}, {second, first}} {
+		r := newRunner(dirs[0])
+		if output, err := r.Run(ctx, "/bin/sh", "cat "+filepath.Join(dirs[0], "file"), nil, nil, false); err != nil || output != filepath.Base(dirs[0]) {
+			t.Errorf("Run() of an allowed folder = %q, %v", output, err)
+		}
+		if _, err := r.Run(ctx, "/bin/sh", "cat "+filepath.Join(dirs[1], "file"), nil, nil, false); err == nil {
+			t.Errorf("Run() of a denied folder should fail")
+		}
+	}
+	if _, err := os.ReadFile(filepath.Join(first, "file")); err != nil {
+		t.Errorf("the Landlock rules should not restrict this process: %v", err)
+	}
+}
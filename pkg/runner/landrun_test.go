@@ -546,6 +546,17 @@ func TestLandrun_buildLandlockRules(t *testing.T) {
 			wantRules: 3,
 			wantErr:   false,
 		},
+		{
+			name: "allocated ports",
+			options: Options{
+				"allow_connect_tcp": []uint16{443},
+			},
+			params: map[string]interface{}{
+				BindPortsParam: []int{40000},
+			},
+			wantRules: 3,
+			wantErr:   false,
+		},
 		{
 			name: "unrestricted filesystem",
 			options: Options{
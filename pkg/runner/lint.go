@@ -0,0 +1,353 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// LintSeverity is the severity of a LintIssue
+type LintSeverity string
+
+const (
+	// LintError is an issue making the configuration invalid, or ignored
+	// by the runner
+	LintError LintSeverity = "error"
+
+	// LintWarning is an issue to review, such as an overly broad grant
+	LintWarning LintSeverity = "warning"
+)
+
+// LintIssue is a problem found in a runner configuration by Lint
+type LintIssue struct {
+	// Severity is the severity of the issue
+	Severity LintSeverity `json:"severity"`
+
+	// Check identifies the check finding the issue (e.g. "broad_grant")
+	Check string `json:"check"`
+
+	// Option is the option with the issue, if any
+	Option string `json:"option,omitempty"`
+
+	// Message describes the issue
+	Message string `json:"message"`
+}
+
+// String returns a description of the issue
+func (i LintIssue) String() string {
+	if i.Option != "" {
+		return fmt.Sprintf("%s: %s (%s): %s", i.Severity, i.Check, i.Option, i.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", i.Severity, i.Check, i.Message)
+}
+
+// LintReport is the result of Lint
+type LintReport struct {
+	// Path is the file of the configuration, if any
+	Path string `json:"path,omitempty"`
+
+	// Runner is the runner type of the configuration
+	Runner Type `json:"runner"`
+
+	// Issues are the problems found
+	Issues []LintIssue `json:"issues"`
+
+	// Policy is the translation of the options for the backend (the firejail
+	// or sandbox-exec profile, the Landlock rules, the nsjail configuration
+	// or the docker command), rendered without params
+	Policy string `json:"policy,omitempty"`
+}
+
+// Passed returns whether no error was found
+func (r *LintReport) Passed() bool {
+	for _, issue := range r.Issues {
+		if issue.Severity == LintError {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteText writes the report as a table
+func (r *LintReport) WriteText(w io.Writer) error {
+	name := string(r.Runner)
+	if r.Path != "" {
+		name = r.Path
+	}
+	if len(r.Issues) == 0 {
+		_, err := fmt.Fprintf(w, "%s: no issues found\n", name)
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "CONFIG\tSEVERITY\tCHECK\tOPTION\tMESSAGE\n")
+	for _, issue := range r.Issues {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", name, strings.ToUpper(string(issue.Severity)),
+			issue.Check, issue.Option, issue.Message)
+	}
+	return tw.Flush()
+}
+
+// add adds an issue to the report
+func (r *LintReport) add(severity LintSeverity, check string, option string, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, LintIssue{
+		Severity: severity,
+		Check:    check,
+		Option:   option,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// LintFile lints the runner configuration of a file (see LoadRunnerConfig)
+func LintFile(path string, logger Logger) (*LintReport, error) {
+	config, err := LoadRunnerConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	report := Lint(config, logger)
+	report.Path = path
+	return report, nil
+}
+
+// Lint checks a runner configuration before it is deployed, without running
+// anything: the options are checked against the schema of the runner and
+// parsed as the runner does, the paths granted must exist on this host, the
+// overly broad grants (e.g. the home folder writable) and the contradictory
+// options are reported, and the options are translated for the backend (see
+// LintReport.Policy).
+//
+// The implicit requirements of the runner are not checked, so configurations
+// can be linted on hosts without the sandbox tools, and the encrypted values
+// and secret references are not resolved.
+func Lint(config RunnerConfig, logger Logger) *LintReport {
+	logger = defaultLogger(logger)
+	report := &LintReport{Runner: config.Type, Issues: []LintIssue{}}
+
+	if _, err := SchemaFor(config.Type); err != nil {
+		report.add(LintError, "runner", "", "%v", err)
+		return report
+	}
+
+	options, err := applyProfile(config.Type, config.Options, logger)
+	if err != nil {
+		report.add(LintError, "profile", ProfileKey, "%v", err)
+		return report
+	}
+
+	for _, d := range Deprecations(config.Type, options) {
+		report.add(LintWarning, "deprecated", d.Option, "%s", d)
+	}
+	options, _, err = Migrate(config.Type, options)
+	if err != nil {
+		report.add(LintError, "invalid_options", OptionsVersionKey, "%v", err)
+		return report
+	}
+	optionErrs, err := validateOptions(config.Type, options)
+	if err != nil {
+		report.add(LintError, "runner", "", "%v", err)
+		return report
+	}
+	for _, e := range optionErrs {
+		switch {
+		case e.Unknown && e.Suggestion != "":
+			report.add(LintError, "unknown_option", e.Option, "the option is ignored by the %s runner: did you mean %q?",
+				config.Type, e.Suggestion)
+		case e.Unknown:
+			report.add(LintError, "unknown_option", e.Option, "the option is ignored by the %s runner", config.Type)
+		default:
+			report.add(LintError, "invalid_type", e.Option, "the option %s", e.Message)
+		}
+	}
+	lintFeatures(report, options)
+	lintPaths(report, options)
+	lintGrants(report, options)
+
+	r, err := newRunner(config.Type, options, logger)
+	if err != nil {
+		report.add(LintError, "invalid_options", "", "%v", err)
+		return report
+	}
+	if c, ok := r.(policyConflicter); ok {
+		for _, w := range c.policyConflicts() {
+			report.add(LintWarning, w.Feature, w.Option, "%s", w.Message)
+		}
+	}
+	policy, err := renderPolicy(r)
+	if err != nil {
+		report.add(LintError, "translation", "", "%v", err)
+		return report
+	}
+	report.Policy = string(policy)
+	return report
+}
+
+// lintFeatures reports the experimental features that are not known, or do
+// not apply to the runner
+func lintFeatures(report *LintReport, options Options) {
+	features, err := parseFeatures(options["experimental"])
+	if err != nil {
+		report.add(LintError, "invalid_options", "experimental", "%v", err)
+		return
+	}
+	for _, f := range features {
+		runners, known := experimentalFeatures[f]
+		switch {
+		case !known:
+			report.add(LintWarning, "experimental", "experimental", "unknown experimental feature %q: it is ignored", f)
+		case !containsType(runners, report.Runner):
+			report.add(LintWarning, "experimental", "experimental",
+				"experimental feature %q does not apply to the %s runner: it is ignored", f, report.Runner)
+		}
+	}
+}
+
+// isPathOption returns whether an option lists the paths granted to the
+// commands (e.g. "allow_write_folders")
+func isPathOption(key string) bool {
+	return strings.HasPrefix(key, "allow_") && (strings.HasSuffix(key, "_folders") || strings.HasSuffix(key, "_files"))
+}
+
+// lintPaths reports the paths granted that do not exist on this host. The
+// paths with templates, relative, encrypted or referencing secrets are not
+// checked, as they are only known when the commands run.
+func lintPaths(report *LintReport, options Options) {
+	for _, key := range slices.Sorted(maps.Keys(options)) {
+		if !isPathOption(key) {
+			continue
+		}
+		for _, path := range lintStrings(options[key]) {
+			if strings.Contains(path, "{{") || !filepath.IsAbs(path) || IsEncryptedValue(path) {
+				continue
+			}
+			if _, err := os.Stat(path); err != nil {
+				report.add(LintWarning, "missing_path", key, "%s does not exist on this host", path)
+			}
+		}
+	}
+}
+
+// broadFolders are the folders too broad to be granted to the commands, as
+// they hold the system or the files of the users
+var broadFolders = []string{"/", "/etc", "/home", "/root", "/Users", "/usr", "/var"}
+
+// lintGrants reports the grants of the options that are overly broad: the
+// whole filesystem or the home folders readable, the system or the home
+// folders writable, and the network or the filesystem unrestricted
+func lintGrants(report *LintReport, options Options) {
+	home, _ := os.UserHomeDir()
+	isHome := func(path string) bool {
+		return path == "~" || (home != "" && path == filepath.Clean(home))
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(options)) {
+		switch {
+		case isPathOption(key):
+			writable := strings.HasPrefix(key, "allow_write_")
+			for _, path := range lintStrings(options[key]) {
+				clean := path
+				if !strings.Contains(path, "{{") {
+					clean = filepath.Clean(path)
+				}
+				switch {
+				case clean == "/" && !writable:
+					report.add(LintWarning, "broad_grant", key, "the whole filesystem is readable")
+				case isHome(clean) && !writable:
+					report.add(LintWarning, "broad_grant", key,
+						"the home folder %s is readable, with its credentials (e.g. ~/.ssh)", path)
+				case writable && (isHome(clean) || slices.Contains(broadFolders, clean)):
+					report.add(LintWarning, "broad_grant", key, "%s is writable", path)
+				}
+			}
+		case key == "mounts":
+			for _, mount := range lintStrings(options[key]) {
+				source, _, _ := strings.Cut(mount, ":")
+				switch {
+				case source == "/" || isHome(filepath.Clean(source)):
+					report.add(LintWarning, "broad_grant", key, "%s is mounted in the container", source)
+				case strings.HasSuffix(source, "/docker.sock") || strings.HasSuffix(source, "/podman.sock"):
+					report.add(LintWarning, "broad_grant", key,
+						"the socket of the engine %s gives the container control of the host", source)
+				}
+			}
+		case key == "docker_run_opts":
+			if opts, _ := options[key].(string); strings.Contains(opts, "--privileged") {
+				report.add(LintWarning, "broad_grant", key, "the container is privileged")
+			}
+		case key == "cap_add":
+			for _, capability := range lintStrings(options[key]) {
+				name := strings.TrimPrefix(strings.ToUpper(capability), "CAP_")
+				if name == "ALL" || name == "SYS_ADMIN" {
+					report.add(LintWarning, "broad_grant", key, "the container has the %s capability", name)
+				}
+			}
+		case key == "network":
+			if network, _ := options[key].(string); network == "host" {
+				report.add(LintWarning, "broad_grant", key, "the container shares the network of the host")
+			}
+		case key == "allow_networking" || key == "allow_user_folders" || key == "unrestricted_filesystem":
+			if enabled, _ := options[key].(bool); enabled {
+				report.add(LintWarning, "broad_grant", key, "the restriction is disabled")
+			}
+		}
+	}
+	if report.Runner == TypeExec {
+		report.add(LintWarning, "broad_grant", "", "the exec runner does not isolate the commands")
+	}
+}
+
+// lintStrings returns the strings of an option value, a string or a list
+func lintStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		var res []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+// renderPolicy translates the options of a runner for its backend, as done
+// when the commands are run (without params), or returns nil for the
+// runners without a translation
+func renderPolicy(r Runner) ([]byte, error) {
+	var policy bytes.Buffer
+	switch r := r.(type) {
+	case *Firejail:
+		if err := r.profileTpl.Execute(&policy, r.profileOptions(nil)); err != nil {
+			return nil, fmt.Errorf("failed to render firejail profile: %w", err)
+		}
+	case *SandboxExec:
+		if err := r.profileTpl.Execute(&policy, r.profileOptions(nil)); err != nil {
+			return nil, fmt.Errorf("failed to render sandbox profile: %w", err)
+		}
+	case *Nsjail:
+		if err := r.configTpl.Execute(&policy, r.config(nil, "")); err != nil {
+			return nil, fmt.Errorf("failed to render nsjail configuration: %w", err)
+		}
+	case *Landrun:
+		rules, err := r.buildLandlockRules(nil)
+		if err != nil {
+			return nil, err
+		}
+		policy.Write(landlockRulesText(r.selectLandlockABI(), rules))
+	case *Docker:
+		policy.WriteString(strings.Join(r.opts.GetBaseDockerCommand(nil), " "))
+		policy.WriteString("\n")
+	default:
+		return nil, nil
+	}
+	return policy.Bytes(), nil
+}
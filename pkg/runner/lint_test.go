@@ -0,0 +1,129 @@
+package runner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// lintIssue returns the issue of a check and option found by Lint, if any
+func lintIssue(report *LintReport, check string, option string) (LintIssue, bool) {
+	for _, issue := range report.Issues {
+		if issue.Check == check && issue.Option == option {
+			return issue, true
+		}
+	}
+	return LintIssue{}, false
+}
+
+func TestLint(t *testing.T) {
+	dir := t.TempDir()
+	report := Lint(RunnerConfig{
+		Type: TypeFirejail,
+		Options: Options{
+			"allow_read_folders":  []interface{}{dir, "/", filepath.Join(dir, "missing"), "{{ .workdir }}"},
+			"allow_write_folders": []interface{}{dir, "/etc"},
+			"allow_networking":    true,
+			"allow_write_folder":  []interface{}{dir},
+		},
+	}, nil)
+
+	if report.Passed() {
+		t.Errorf("Passed() = true with an unknown option: %v", report.Issues)
+	}
+	tests := []struct {
+		check    string
+		option   string
+		severity LintSeverity
+		message  string
+	}{
+		{"unknown_option", "allow_write_folder", LintError, `did you mean "allow_write_folders"?`},
+		{"missing_path", "allow_read_folders", LintWarning, "missing does not exist"},
+		{"broad_grant", "allow_read_folders", LintWarning, "the whole filesystem"},
+		{"broad_grant", "allow_write_folders", LintWarning, "/etc is writable"},
+		{"broad_grant", "allow_networking", LintWarning, "disabled"},
+		{"policy_conflict", "allow_write_folders", LintWarning, dir},
+	}
+	for _, tt := range tests {
+		issue, found := lintIssue(report, tt.check, tt.option)
+		if !found {
+			t.Errorf("no %s issue for %s: %v", tt.check, tt.option, report.Issues)
+			continue
+		}
+		if issue.Severity != tt.severity || !strings.Contains(issue.Message, tt.message) {
+			t.Errorf("issue = %v, want a %s with %q", issue, tt.severity, tt.message)
+		}
+	}
+
+	// the options are translated to the firejail profile
+	if !strings.Contains(report.Policy, "whitelist "+dir) {
+		t.Errorf("Policy = %q, want the folders whitelisted", report.Policy)
+	}
+
+	var text bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	if !strings.Contains(text.String(), "unknown_option") {
+		t.Errorf("WriteText() = %q", text.String())
+	}
+}
+
+func TestLint_invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config RunnerConfig
+		check  string
+	}{
+		{"unknown runner", RunnerConfig{Type: "chroot"}, "runner"},
+		{"invalid options", RunnerConfig{Type: TypeNsjail, Options: Options{"allow_networking": "yes"}}, "invalid_options"},
+		{"missing image", RunnerConfig{Type: TypeDocker, Options: Options{}}, "invalid_options"},
+		{"invalid type", RunnerConfig{Type: TypeDocker, Options: Options{"image": "alpine", "mounts": "/data:/data"}}, "invalid_type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := Lint(tt.config, nil)
+			if report.Passed() {
+				t.Fatalf("Passed() = true")
+			}
+			found := false
+			for _, issue := range report.Issues {
+				found = found || issue.Check == tt.check
+			}
+			if !found {
+				t.Errorf("no %s issue: %v", tt.check, report.Issues)
+			}
+		})
+	}
+}
+
+func TestLintFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runner.json")
+	config := `{"type": "docker", "options": {"image": "alpine:latest", "network": "host",
+		"mounts": ["/var/run/docker.sock:/var/run/docker.sock"]}}`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := LintFile(path, nil)
+	if err != nil {
+		t.Fatalf("LintFile failed: %v", err)
+	}
+	if report.Path != path || !report.Passed() {
+		t.Errorf("report = %+v", report)
+	}
+	for _, option := range []string{"network", "mounts"} {
+		if _, found := lintIssue(report, "broad_grant", option); !found {
+			t.Errorf("no broad_grant issue for %s: %v", option, report.Issues)
+		}
+	}
+	if !strings.Contains(report.Policy, "--network") {
+		t.Errorf("Policy = %q, want the docker command", report.Policy)
+	}
+
+	if _, err := LintFile(filepath.Join(t.TempDir(), "missing.json"), nil); err == nil {
+		t.Errorf("LintFile() of a missing file should fail")
+	}
+}
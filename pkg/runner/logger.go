@@ -0,0 +1,125 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// Logger is the logging interface used by the runners.
+//
+// *common.Logger implements it, and the adapters in this file wrap the
+// loggers of other libraries, so embedding projects can inject their own
+// logger in the runner constructors. Both redact the secrets registered with
+// common.RegisterSecret.
+type Logger interface {
+	Debug(format string, v ...interface{})
+	Info(format string, v ...interface{})
+	Warn(format string, v ...interface{})
+	Error(format string, v ...interface{})
+}
+
+// defaultLogger returns logger, or the global logger if it is nil
+// (including a nil *common.Logger)
+func defaultLogger(logger Logger) Logger {
+	if cl, ok := logger.(*common.Logger); logger == nil || (ok && cl == nil) {
+		return common.GetLogger()
+	}
+	return logger
+}
+
+// contextLogger returns the logger for a call made with ctx (see
+// common.WithLogLevel). When the context raises the logging level without
+// an ID, a new one is generated so the lines of the call can be told apart.
+//
+// Only *common.Logger supports raising the level per call: other loggers are
+// returned unchanged.
+func contextLogger(ctx context.Context, logger Logger) Logger {
+	cl, ok := logger.(*common.Logger)
+	if !ok {
+		return logger
+	}
+	if common.HasLogLevel(ctx) && common.LogIDFromContext(ctx) == "" {
+		ctx = common.WithLogID(ctx, newExecutionID())
+	}
+	return common.ContextLogger(ctx, cl)
+}
+
+// categoryLogger returns the logger for the messages of a category (see
+// common.Logger.SetSampling). Only *common.Logger supports sampling.
+func categoryLogger(logger Logger, category string) Logger {
+	if cl, ok := logger.(*common.Logger); ok {
+		return cl.Category(category)
+	}
+	return logger
+}
+
+//////////////////////////////////////////////////////////////////////
+
+// slogLogger adapts a *slog.Logger
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a Logger writing to a *slog.Logger
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return &slogLogger{logger: logger}
+}
+
+func (l *slogLogger) Debug(format string, v ...interface{}) {
+	l.logger.Debug(common.RedactSecrets(fmt.Sprintf(format, v...)))
+}
+
+func (l *slogLogger) Info(format string, v ...interface{}) {
+	l.logger.Info(common.RedactSecrets(fmt.Sprintf(format, v...)))
+}
+
+func (l *slogLogger) Warn(format string, v ...interface{}) {
+	l.logger.Warn(common.RedactSecrets(fmt.Sprintf(format, v...)))
+}
+
+func (l *slogLogger) Error(format string, v ...interface{}) {
+	l.logger.Error(common.RedactSecrets(fmt.Sprintf(format, v...)))
+}
+
+// LeveledPrintfLogger is implemented by loggers with printf-style leveled
+// methods, such as *zap.SugaredLogger and *logrus.Logger (or *logrus.Entry)
+type LeveledPrintfLogger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// printfLogger adapts a LeveledPrintfLogger
+type printfLogger struct {
+	logger LeveledPrintfLogger
+}
+
+// NewPrintfLogger returns a Logger writing to a logger with printf-style
+// leveled methods. It can be used with zap (through logger.Sugar()) and
+// logrus, without this module depending on them:
+//
+//	runner.NewPrintfLogger(zapLogger.Sugar())
+//	runner.NewPrintfLogger(logrus.StandardLogger())
+func NewPrintfLogger(logger LeveledPrintfLogger) Logger {
+	return &printfLogger{logger: logger}
+}
+
+func (l *printfLogger) Debug(format string, v ...interface{}) {
+	l.logger.Debugf("%s", common.RedactSecrets(fmt.Sprintf(format, v...)))
+}
+
+func (l *printfLogger) Info(format string, v ...interface{}) {
+	l.logger.Infof("%s", common.RedactSecrets(fmt.Sprintf(format, v...)))
+}
+
+func (l *printfLogger) Warn(format string, v ...interface{}) {
+	l.logger.Warnf("%s", common.RedactSecrets(fmt.Sprintf(format, v...)))
+}
+
+func (l *printfLogger) Error(format string, v ...interface{}) {
+	l.logger.Errorf("%s", common.RedactSecrets(fmt.Sprintf(format, v...)))
+}
@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// recordingLogger implements LeveledPrintfLogger, like zap's SugaredLogger or logrus
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.lines = append(l.lines, "debug: "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.lines = append(l.lines, "info: "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.lines = append(l.lines, "warn: "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.lines = append(l.lines, "error: "+fmt.Sprintf(format, args...))
+}
+
+// TestNewPrintfLogger tests that runners log through a printf-style logger
+func TestNewPrintfLogger(t *testing.T) {
+	rec := &recordingLogger{}
+	runner, err := New(TypeExec, Options{}, NewPrintfLogger(rec))
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+
+	if _, err := runner.Run(context.Background(), "", "echo hello", []string{"A=1"}, nil, false); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	all := strings.Join(rec.lines, "\n")
+	if !strings.Contains(all, "debug: Executing command") {
+		t.Errorf("Expected debug lines to be recorded, got:\n%s", all)
+	}
+	if !strings.Contains(all, "debug: ... adding environment variable: A=1") {
+		t.Errorf("Expected categorized lines to be recorded, got:\n%s", all)
+	}
+}
+
+// TestNewSlogLogger tests that runners log through a *slog.Logger
+func TestNewSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	runner, err := New(TypeExec, Options{}, NewSlogLogger(logger))
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+
+	if _, err := runner.Run(context.Background(), "", "echo hello", nil, nil, false); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if !strings.Contains(buf.String(), `level=DEBUG msg="Executing command"`) {
+		t.Errorf("Expected slog debug records, got:\n%s", buf.String())
+	}
+}
+
+// TestDefaultLogger tests that nil loggers (including typed nils) use the global logger
+func TestDefaultLogger(t *testing.T) {
+	var typedNil *common.Logger
+	for _, logger := range []Logger{nil, typedNil} {
+		if l := defaultLogger(logger); l == nil || l == Logger(typedNil) {
+			t.Errorf("Expected the global logger for %#v", logger)
+		}
+	}
+
+	if _, err := NewExec(Options{}, typedNil); err != nil {
+		t.Fatalf("Failed to create runner with a nil logger: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// TestStart_WithLogLevel tests that the logging level can be raised for a single execution
+func TestStart_WithLogLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := common.NewLogger("", "", common.LogLevelInfo, false)
+	logger.SetOutput(&buf)
+
+	runner, err := NewExec(Options{}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+
+	run := func(opts ...ExecOption) *Execution {
+		e, err := Start(context.Background(), runner, "true", nil, nil, nil, opts...)
+		if err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		_ = e.Stdin.Close()
+		_, _ = io.ReadAll(e.Stdout)
+		_, _ = io.ReadAll(e.Stderr)
+		if err := e.Wait(); err != nil {
+			t.Fatalf("Wait failed: %v", err)
+		}
+		return e
+	}
+
+	run()
+	if buf.Len() > 0 {
+		t.Fatalf("Expected no debug output, got %q", buf.String())
+	}
+
+	e := run(WithLogLevel(common.LogLevelDebug))
+	if !strings.Contains(buf.String(), "[DEBUG] ["+e.ID+"]") {
+		t.Errorf("Expected debug lines tagged with the execution ID %s, got %q", e.ID, buf.String())
+	}
+
+	if logger.Level() != common.LogLevelInfo {
+		t.Errorf("Expected the logger level to be unchanged, got %v", logger.Level())
+	}
+}
+
+// TestRun_ContextLogLevel tests that the logging level can be raised through the context
+func TestRun_ContextLogLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := common.NewLogger("", "", common.LogLevelInfo, false)
+	logger.SetOutput(&buf)
+
+	runner, err := NewExec(Options{}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+
+	ctx := common.WithLogID(common.WithLogLevel(context.Background(), common.LogLevelDebug), "req-42")
+	if _, err := runner.Run(ctx, "", "echo hello", nil, nil, false); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "[DEBUG] [req-42]") {
+		t.Errorf("Expected debug lines tagged with the request ID, got %q", buf.String())
+	}
+}
@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// TestLogSampling_Environment tests that the environment lines can be sampled
+func TestLogSampling_Environment(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := common.NewLogger("", "", common.LogLevelDebug, false)
+	logger.SetOutput(&buf)
+	logger.SetSampling(LogCategoryEnvironment, common.SamplingRule{Interval: time.Hour, First: 2})
+
+	runner, err := NewExec(Options{}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+
+	env := []string{"A=1", "B=2", "C=3", "D=4"}
+	if _, err := runner.Run(context.Background(), "", "true", env, nil, false); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if n := strings.Count(buf.String(), "adding environment variable"); n != 2 {
+		t.Errorf("Expected 2 environment lines, got %d:\n%s", n, buf.String())
+	}
+	if !strings.Contains(buf.String(), "Adding 4 environment variables") {
+		t.Errorf("Expected lines of other categories to be logged, got:\n%s", buf.String())
+	}
+}
+
+// TestLogSampling_Thereafter tests that one of every N messages is logged
+// after the first ones, and that dropped messages are reported
+func TestLogSampling_Thereafter(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := common.NewLogger("", "", common.LogLevelDebug, false)
+	logger.SetOutput(&buf)
+	logger.SetSampling("test", common.SamplingRule{Interval: 50 * time.Millisecond, First: 1, Thereafter: 3})
+
+	cat := logger.Category("test")
+	for i := 0; i < 7; i++ {
+		cat.Debug("message %d", i)
+	}
+
+	// messages 0 (first), 3 and 6 (one of every 3 after the first)
+	for _, expected := range []string{"message 0", "message 3", "message 6"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected %q to be logged, got:\n%s", expected, buf.String())
+		}
+	}
+	if n := strings.Count(buf.String(), "message"); n != 3 {
+		t.Errorf("Expected 3 messages, got %d:\n%s", n, buf.String())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	cat.Debug("message after interval")
+	if !strings.Contains(buf.String(), `(4 similar "test" messages dropped)`) {
+		t.Errorf("Expected the dropped messages to be reported, got:\n%s", buf.String())
+	}
+}
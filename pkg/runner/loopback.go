@@ -0,0 +1,39 @@
+package runner
+
+import "context"
+
+// WithLoopbackNetwork runs the command without external network access but
+// with a working loopback interface, so commands that talk to helpers they
+// start themselves over localhost keep working. It overrides the networking
+// options of the runner for this execution.
+//
+// The isolation depends on the runner:
+//
+//   - Exec, Landrun, Proot and Unshare run the command in a new network
+//     namespace where only the loopback interface is up (Linux only)
+//   - Firejail runs the command with --net=none
+//   - Docker runs the container with --network none (ports cannot be published)
+//   - SandboxExec only allows connections to and from localhost
+//   - Deno only allows connections to localhost
+//
+// Start fails with ErrNotSupported for the runners that cannot isolate the
+// network (e.g. ADB, or Exec outside Linux).
+func WithLoopbackNetwork() ExecOption {
+	return func(c *execConfig) {
+		c.loopbackNetwork = true
+	}
+}
+
+// loopbackNetworkKey is the context key of the loopback-only network mode
+type loopbackNetworkKey struct{}
+
+// withLoopbackNetwork returns a context requesting a loopback-only network
+func withLoopbackNetwork(ctx context.Context) context.Context {
+	return context.WithValue(ctx, loopbackNetworkKey{}, true)
+}
+
+// loopbackNetworkFrom returns whether the context requests a loopback-only network
+func loopbackNetworkFrom(ctx context.Context) bool {
+	loopback, _ := ctx.Value(loopbackNetworkKey{}).(bool)
+	return loopback
+}
@@ -0,0 +1,107 @@
+//go:build linux
+
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// loopbackHelperEnv is set when this executable is started as the helper
+// that brings the loopback interface up before running the command
+const loopbackHelperEnv = "RUNNER_LOOPBACK_HELPER"
+
+// prctl arguments for clearing the ambient capabilities
+const (
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+)
+
+// capNetAdmin is the capability required to bring the loopback interface up
+const capNetAdmin = 12
+
+// isolateLoopback changes cmd so it runs in a new network namespace with
+// only the loopback interface up.
+//
+// The interfaces of a new namespace are down, so the command is started
+// through this executable: the helper mode (see runLoopbackHelper) brings
+// the loopback interface up and then executes the command. Unprivileged
+// users get the namespace through a user namespace mapping their own user,
+// with the capability to configure the interface until the command runs.
+func isolateLoopback(cmd *exec.Cmd) error {
+	if cmd.Err != nil {
+		return nil
+	}
+
+	self, err := helperExecutable("loopback network helper")
+	if err != nil {
+		return err
+	}
+
+	cmd.Args = append([]string{"runner-loopback-helper", cmd.Path}, cmd.Args...)
+	cmd.Path = self
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, loopbackHelperEnv+"=1")
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	inUserNamespace(cmd, capNetAdmin)
+	return nil
+}
+
+// runLoopbackHelper brings the loopback interface up and executes the
+// command in os.Args[1:] (its path followed by its arguments). It never returns.
+func runLoopbackHelper() {
+	runtime.LockOSThread()
+	_ = os.Unsetenv(loopbackHelperEnv)
+
+	if len(os.Args) < 3 {
+		fmt.Fprintln(os.Stderr, "runner: missing command for the loopback network helper")
+		os.Exit(126)
+	}
+	if err := setLinkUp("lo"); err != nil {
+		fmt.Fprintf(os.Stderr, "runner: failed to bring the loopback interface up: %v\n", err)
+		os.Exit(126)
+	}
+
+	// the command must not keep the capability granted to configure the interface
+	_, _, _ = syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0)
+
+	err := syscall.Exec(os.Args[1], os.Args[2:], os.Environ())
+	fmt.Fprintf(os.Stderr, "runner: failed to execute %s: %v\n", os.Args[1], err)
+	os.Exit(127)
+}
+
+// setLinkUp brings a network interface up
+func setLinkUp(name string) error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	// struct ifreq with the ifr_flags member of the union
+	var req struct {
+		name  [syscall.IFNAMSIZ]byte
+		flags uint16
+		_     [22]byte
+	}
+	copy(req.name[:], name)
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return errno
+	}
+	req.flags |= syscall.IFF_UP
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package runner
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// isolateLoopback is only supported on Linux, where network namespaces exist
+func isolateLoopback(cmd *exec.Cmd) error {
+	return fmt.Errorf("loopback-only network requires Linux: %w", ErrNotSupported)
+}
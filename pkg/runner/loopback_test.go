@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWithLoopbackNetwork(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("loopback-only network namespaces require Linux")
+	}
+
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := Start(context.Background(), r, "sh", []string{"-c", "cat /proc/net/dev; echo ---; cat /proc/net/fib_trie"}, nil, nil,
+		WithLoopbackNetwork())
+	if errors.Is(err, os.ErrPermission) {
+		t.Skipf("network namespaces are not available: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	go func() { _, _ = io.Copy(io.Discard, e.Stderr) }()
+	out, _ := io.ReadAll(e.Stdout)
+	if err := e.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	devices, routes, _ := strings.Cut(string(out), "---")
+
+	// the interfaces are listed as "name: counters" after two header lines
+	for _, line := range strings.Split(strings.TrimSpace(devices), "\n")[2:] {
+		if name, _, _ := strings.Cut(line, ":"); strings.TrimSpace(name) != "lo" {
+			t.Errorf("unexpected interface %q in the network namespace", strings.TrimSpace(name))
+		}
+	}
+	if !strings.Contains(routes, "127.0.0.1") {
+		t.Errorf("expected the loopback interface to be up, got:\n%s", out)
+	}
+}
+
+func TestWithLoopbackNetwork_NotSupported(t *testing.T) {
+	r, err := NewADB(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Start(context.Background(), r, "true", nil, nil, nil, WithLoopbackNetwork())
+	if !errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
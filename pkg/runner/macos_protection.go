@@ -0,0 +1,215 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// ErrQuarantined is matched (with errors.Is) by the errors of commands whose
+// executable has the quarantine attribute of macOS, so Gatekeeper may refuse
+// to run it
+var ErrQuarantined = errors.New("executable quarantined by Gatekeeper")
+
+// ErrTCCDenied is matched (with errors.Is) by the errors of commands denied
+// access to a folder protected by the privacy controls of macOS (TCC), such
+// as ~/Documents, which this process has not been granted
+var ErrTCCDenied = errors.New("access to a folder protected by TCC denied")
+
+// quarantineAttr is the extended attribute set on downloaded files
+const quarantineAttr = "com.apple.quarantine"
+
+// Values of the quarantine option
+const (
+	// QuarantineCheck fails with ErrQuarantined
+	QuarantineCheck = "check"
+	// QuarantineRemove removes the quarantine attribute
+	QuarantineRemove = "remove"
+)
+
+// ProtectedPathError is the error of a command failing because of a
+// protection of macOS, and not because of its sandbox: IsPermissionDenied
+// returns false for it
+type ProtectedPathError struct {
+	// Path is the protected executable or folder
+	Path string
+	// Kind is ErrQuarantined or ErrTCCDenied
+	Kind error
+	// Err is the error of the command or of the access, if any
+	Err error
+}
+
+func (e *ProtectedPathError) Error() string {
+	var msg string
+	if e.Kind == ErrQuarantined {
+		msg = fmt.Sprintf("%s is quarantined by Gatekeeper: remove the %s attribute (e.g. with xattr -d) or use the quarantine option", e.Path, quarantineAttr)
+	} else {
+		msg = fmt.Sprintf("%s is protected by TCC: grant the app running this process access to it "+
+			"(System Settings > Privacy & Security > Files and Folders, or Full Disk Access)", e.Path)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ProtectedPathError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// MacOSProtectionOptions handles the protections of macOS that are not part
+// of the sandbox profile: the quarantine of Gatekeeper, and the privacy
+// controls (TCC) of folders such as ~/Desktop and ~/Documents. Without them,
+// the commands failing because of these protections cannot be told apart
+// from the ones denied by the sandbox.
+type MacOSProtectionOptions struct {
+	// Quarantine is what is done, before running a command, when its
+	// executables have the quarantine attribute: "" (nothing), "check"
+	// (fail with ErrQuarantined) or "remove" (remove the attribute)
+	Quarantine string `json:"quarantine"`
+
+	// VerifyProtectedFolders checks that this process can access the
+	// folders protected by TCC among the allowed folders before running a
+	// command, failing with ErrTCCDenied otherwise. Accessing them asks the
+	// user for permission the first time, when the app has a UI.
+	VerifyProtectedFolders bool `json:"verify_protected_folders"`
+}
+
+// validateMacOSProtection checks the quarantine option
+func (o MacOSProtectionOptions) validateMacOSProtection() error {
+	switch o.Quarantine {
+	case "", QuarantineCheck, QuarantineRemove:
+		return nil
+	}
+	return fmt.Errorf("invalid quarantine %q: must be %s or %s", o.Quarantine, QuarantineCheck, QuarantineRemove)
+}
+
+// checkMacOSProtections handles the quarantine of the executables in the
+// words of a command, and verifies the access to the protected folders
+func (o MacOSProtectionOptions) checkMacOSProtections(logger Logger, folders []string, extraPath []string, words ...string) error {
+	if o.Quarantine != "" {
+		for _, path := range commandExecutables(extraPath, words) {
+			attr, err := QuarantineAttribute(path)
+			if err != nil || attr == "" {
+				continue
+			}
+			if o.Quarantine == QuarantineCheck {
+				return &ProtectedPathError{Path: path, Kind: ErrQuarantined}
+			}
+			logger.Info("Removing the quarantine attribute of %s (%s)", path, attr)
+			if err := removeQuarantine(path); err != nil {
+				return &ProtectedPathError{Path: path, Kind: ErrQuarantined, Err: err}
+			}
+		}
+	}
+	if o.VerifyProtectedFolders {
+		for _, folder := range folders {
+			if IsTCCProtected(folder) {
+				if err := VerifyProtectedAccess(folder); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// classifyMacOSDenial returns the error of a command denied an operation
+// because of the quarantine of its executables or the TCC protection of the
+// allowed folders, or err when the denial comes from the sandbox
+func classifyMacOSDenial(err error, folders []string, extraPath []string, words ...string) error {
+	if runtime.GOOS != "darwin" || !IsPermissionDenied(err) {
+		return err
+	}
+	for _, path := range commandExecutables(extraPath, words) {
+		if attr, qErr := QuarantineAttribute(path); qErr == nil && attr != "" {
+			return &ProtectedPathError{Path: path, Kind: ErrQuarantined, Err: err}
+		}
+	}
+	for _, folder := range folders {
+		if IsTCCProtected(folder) && VerifyProtectedAccess(folder) != nil {
+			return &ProtectedPathError{Path: folder, Kind: ErrTCCDenied, Err: err}
+		}
+	}
+	return err
+}
+
+// allowedFolders returns the folders that can be read or written
+func (o SandboxExecOptions) allowedFolders() []string {
+	folders := append([]string{}, o.AllowReadFolders...)
+	return append(folders, o.AllowWriteFolders...)
+}
+
+// commandExecutables returns the executables found in the words of a command
+func commandExecutables(extraPath []string, words []string) []string {
+	var paths []string
+	for _, word := range commandWords(words) {
+		if path, err := common.ResolveExecutable(word, extraPath...); err == nil && !contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// tccProtectedFolders are the folders protected by TCC, relative to the home
+// directory, and the volumes
+func tccProtectedFolders(home string) []string {
+	return []string{
+		filepath.Join(home, "Desktop"),
+		filepath.Join(home, "Documents"),
+		filepath.Join(home, "Downloads"),
+		filepath.Join(home, "Library", "Mobile Documents"),
+		"/Volumes",
+	}
+}
+
+// IsTCCProtected returns whether a path is in a folder protected by the
+// privacy controls of macOS (TCC): the Desktop, Documents and Downloads
+// folders, iCloud Drive, and the removable and network volumes. It always
+// returns false in other operating systems.
+func IsTCCProtected(path string) bool {
+	if runtime.GOOS != "darwin" {
+		return false
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return false
+	}
+	return isUnderAny(path, tccProtectedFolders(home))
+}
+
+// isUnderAny returns whether path is one of the folders or in them
+func isUnderAny(path string, folders []string) bool {
+	path = filepath.Clean(path)
+	for _, folder := range folders {
+		if path == folder || strings.HasPrefix(path, folder+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyProtectedAccess checks that this process can list a folder protected
+// by TCC, returning a *ProtectedPathError matching ErrTCCDenied when it
+// cannot. The first access asks the user for permission, when the app
+// running this process has a UI.
+func VerifyProtectedAccess(folder string) error {
+	f, err := os.Open(folder)
+	if err == nil {
+		_, err = f.Readdirnames(1)
+		_ = f.Close()
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return &ProtectedPathError{Path: folder, Kind: ErrTCCDenied, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+//go:build darwin
+
+package runner
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// QuarantineAttribute returns the quarantine attribute of a file, set by
+// macOS on the files downloaded by most apps, or "" when it has none
+func QuarantineAttribute(path string) (string, error) {
+	buf := make([]byte, 256)
+	n, err := unix.Getxattr(path, quarantineAttr, buf)
+	if errors.Is(err, unix.ENOATTR) {
+		return "", nil
+	}
+	if errors.Is(err, unix.ERANGE) {
+		if n, err = unix.Getxattr(path, quarantineAttr, nil); err == nil {
+			buf = make([]byte, n)
+			n, err = unix.Getxattr(path, quarantineAttr, buf)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+// removeQuarantine removes the quarantine attribute of a file
+func removeQuarantine(path string) error {
+	if err := unix.Removexattr(path, quarantineAttr); err != nil && !errors.Is(err, unix.ENOATTR) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !darwin
+
+package runner
+
+// QuarantineAttribute returns the quarantine attribute of a file, set by
+// macOS on the files downloaded by most apps, or "" when it has none. Files
+// never have it in other operating systems.
+func QuarantineAttribute(path string) (string, error) {
+	return "", nil
+}
+
+// removeQuarantine removes the quarantine attribute of a file
+func removeQuarantine(path string) error {
+	return nil
+}
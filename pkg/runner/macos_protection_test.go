@@ -0,0 +1,73 @@
+package runner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestProtectedPathError(t *testing.T) {
+	denial := newCommandError("ls: Documents: Operation not permitted", os.ErrPermission)
+	err := &ProtectedPathError{Path: "/Users/me/Documents", Kind: ErrTCCDenied, Err: denial}
+
+	if !errors.Is(err, ErrTCCDenied) || errors.Is(err, ErrQuarantined) {
+		t.Errorf("errors.Is() does not match the kind of %v", err)
+	}
+	if IsPermissionDenied(err) {
+		t.Errorf("IsPermissionDenied() = true for a TCC denial")
+	}
+	if !IsPermissionDenied(denial) {
+		t.Errorf("IsPermissionDenied() = false for the denial of the command")
+	}
+	if !strings.Contains(err.Error(), "protected by TCC") || !strings.Contains(err.Error(), "Operation not permitted") {
+		t.Errorf("Error() = %q", err.Error())
+	}
+
+	err = &ProtectedPathError{Path: "/tmp/tool", Kind: ErrQuarantined}
+	if !errors.Is(err, ErrQuarantined) || IsPermissionDenied(err) {
+		t.Errorf("quarantine error not classified: %v", err)
+	}
+}
+
+func TestTCCProtectedFolders(t *testing.T) {
+	folders := tccProtectedFolders("/Users/me")
+	tests := map[string]bool{
+		"/Users/me/Documents":                  true,
+		"/Users/me/Documents/project/":         true,
+		"/Users/me/Library/Mobile Documents/x": true,
+		"/Volumes/USB":                         true,
+		"/Users/me/DocumentsOld":               false,
+		"/Users/me/src":                        false,
+		"/tmp":                                 false,
+	}
+	for path, want := range tests {
+		if got := isUnderAny(path, folders); got != want {
+			t.Errorf("isUnderAny(%q) = %v, want %v", path, got, want)
+		}
+	}
+	if runtime.GOOS != "darwin" && IsTCCProtected(filepath.Join(os.Getenv("HOME"), "Documents")) {
+		t.Errorf("IsTCCProtected() = true outside macOS")
+	}
+}
+
+func TestMacOSProtectionOptions(t *testing.T) {
+	for _, q := range []string{"", QuarantineCheck, QuarantineRemove} {
+		if err := (MacOSProtectionOptions{Quarantine: q}).validateMacOSProtection(); err != nil {
+			t.Errorf("validateMacOSProtection(%q) failed: %v", q, err)
+		}
+	}
+	if _, err := NewSandboxExec(Options{"quarantine": "ignore"}, nil); err == nil {
+		t.Errorf("NewSandboxExec() with an invalid quarantine should fail")
+	}
+
+	opts := MacOSProtectionOptions{Quarantine: QuarantineCheck, VerifyProtectedFolders: true}
+	if err := opts.checkMacOSProtections(defaultLogger(nil), []string{t.TempDir()}, nil, "ls -l"); err != nil {
+		t.Errorf("checkMacOSProtections() failed: %v", err)
+	}
+	if err := VerifyProtectedAccess(t.TempDir()); err != nil {
+		t.Errorf("VerifyProtectedAccess() of an accessible folder = %v", err)
+	}
+}
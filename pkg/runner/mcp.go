@@ -0,0 +1,491 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrMCPServerExited is returned by the reads of an MCPBridge when the MCP
+// server exited and cannot be restarted
+var ErrMCPServerExited = errors.New("MCP server exited")
+
+// defaultMCPMaxMessageSize is the default maximum size of the messages of an
+// MCPBridge
+const defaultMCPMaxMessageSize = 4 << 20
+
+// mcpReplayID is the ID of the initialize request replayed to restarted
+// servers, whose response is not forwarded to the host
+const mcpReplayID = `"go-restricted-runner-initialize"`
+
+// MCPBridgeOptions is the options for an MCPBridge
+type MCPBridgeOptions struct {
+	// AllowedMethods are the methods the host can call on the server (all
+	// when empty). "initialize", "ping" and the notifications are always
+	// allowed.
+	AllowedMethods []string
+
+	// AllowedTools are the tools the host can call with "tools/call" (all when
+	// empty). The other tools are also removed from the "tools/list" results.
+	AllowedTools []string
+
+	// MaxMessageSize is the maximum size of the messages, in bytes (4 MiB by
+	// default). Larger messages of the host are rejected, and a server
+	// sending one is killed.
+	MaxMessageSize int
+
+	// MaxRestarts is the maximum number of times the server is restarted when
+	// it exits, replaying the initialization of the host (no restarts by
+	// default)
+	MaxRestarts int
+
+	// RestartDelay is the delay before restarting the server
+	RestartDelay time.Duration
+
+	// Stderr receives the standard error of the server (discarded when nil)
+	Stderr io.Writer
+}
+
+// MCPBridge runs an MCP server using the stdio transport (newline delimited
+// JSON-RPC messages) with a runner, and exposes it to the host as an
+// io.ReadWriteCloser: the messages written are sent to the server, after
+// enforcing the policy of the bridge, and the messages of the server are
+// read.
+//
+// When the server exits, it is restarted up to MaxRestarts times: the calls
+// pending at that moment fail, and the initialization of the host is replayed
+// to the new server, so the host can carry on with the same session.
+type MCPBridge struct {
+	runner   Runner
+	cmd      string
+	args     []string
+	env      []string
+	params   map[string]interface{}
+	execOpts []ExecOption
+	options  MCPBridgeOptions
+	logger   Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu          sync.Mutex
+	execution   *Execution
+	stdin       io.WriteCloser
+	kill        context.CancelFunc
+	pending     map[string]string
+	initRequest []byte
+	initialized []byte
+	restarts    int
+	closed      bool
+	input       []byte
+
+	output *mcpOutput
+}
+
+// NewMCPBridge starts an MCP server with a runner, returning the bridge to it
+func NewMCPBridge(ctx context.Context, r Runner, cmd string, args []string, env []string, params map[string]interface{},
+	options MCPBridgeOptions, logger Logger, execOpts ...ExecOption) (*MCPBridge, error) {
+	if options.MaxMessageSize <= 0 {
+		options.MaxMessageSize = defaultMCPMaxMessageSize
+	}
+	b := &MCPBridge{
+		runner:   r,
+		cmd:      cmd,
+		args:     args,
+		env:      env,
+		params:   params,
+		execOpts: execOpts,
+		options:  options,
+		logger:   defaultLogger(logger),
+		pending:  map[string]string{},
+		output:   newMCPOutput(),
+	}
+	b.ctx, b.cancel = context.WithCancel(ctx)
+
+	stdout, err := b.start()
+	if err != nil {
+		b.cancel()
+		return nil, err
+	}
+	go b.serve(stdout)
+	return b, nil
+}
+
+// Read reads the messages of the server, one JSON-RPC message per line
+func (b *MCPBridge) Read(p []byte) (int, error) {
+	return b.output.Read(p)
+}
+
+// Write sends messages to the server, one JSON-RPC message per line. The
+// messages denied by the policy of the bridge are answered with an error
+// instead.
+func (b *MCPBridge) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	b.input = append(b.input, p...)
+	var lines [][]byte
+	for {
+		i := bytes.IndexByte(b.input, '\n')
+		if i < 0 {
+			break
+		}
+		lines = append(lines, b.input[:i])
+		b.input = b.input[i+1:]
+	}
+	tooLarge := len(b.input) > b.options.MaxMessageSize
+	if tooLarge {
+		b.input = nil
+	}
+	b.mu.Unlock()
+
+	for _, line := range lines {
+		b.handleHost(line)
+	}
+	if tooLarge {
+		b.reply(json.RawMessage("null"), &JSONRPCError{Code: JSONRPCInvalidRequest, Message: "message too large"})
+	}
+	return len(p), nil
+}
+
+// Close stops the server
+func (b *MCPBridge) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	stdin := b.stdin
+	b.mu.Unlock()
+
+	if stdin != nil {
+		_ = stdin.Close()
+	}
+	b.cancel()
+	b.output.closeWithError(nil)
+	return nil
+}
+
+// Execution returns the current execution of the server
+func (b *MCPBridge) Execution() *Execution {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.execution
+}
+
+// Restarts returns how many times the server has been restarted
+func (b *MCPBridge) Restarts() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.restarts
+}
+
+// start starts the server, returning its output
+func (b *MCPBridge) start() (io.Reader, error) {
+	ctx, kill := context.WithCancel(b.ctx)
+	e, err := Start(ctx, b.runner, b.cmd, b.args, b.env, b.params, b.execOpts...)
+	if err != nil {
+		kill()
+		return nil, err
+	}
+	stderr := b.options.Stderr
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	go func() { _, _ = io.Copy(stderr, e.Stderr) }()
+
+	b.mu.Lock()
+	b.execution, b.stdin, b.kill = e, e.Stdin, kill
+	if b.initRequest != nil {
+		// the server of a restart is initialized like the first one
+		var request map[string]json.RawMessage
+		_ = json.Unmarshal(b.initRequest, &request)
+		request["id"] = json.RawMessage(mcpReplayID)
+		replay, _ := json.Marshal(request)
+		_, _ = b.stdin.Write(append(replay, '\n'))
+	}
+	b.mu.Unlock()
+	return e.Stdout, nil
+}
+
+// serve forwards the messages of the server to the host, restarting it when
+// it exits
+func (b *MCPBridge) serve(stdout io.Reader) {
+	for {
+		b.forwardServer(stdout)
+
+		b.mu.Lock()
+		e, kill := b.execution, b.kill
+		b.mu.Unlock()
+		_ = e.Wait()
+		kill()
+
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return
+		}
+		pending := b.pending
+		b.pending = map[string]string{}
+		b.stdin = nil
+		restart := b.restarts < b.options.MaxRestarts
+		if restart {
+			b.restarts++
+		}
+		b.mu.Unlock()
+
+		status := e.ExitStatus()
+		for id := range pending {
+			b.reply(json.RawMessage(id), &JSONRPCError{Code: JSONRPCInternalError,
+				Message: fmt.Sprintf("MCP server exited with code %d", status.Code)})
+		}
+		if !restart {
+			b.logger.Error("MCP server %s exited with code %d", b.cmd, status.Code)
+			b.output.closeWithError(fmt.Errorf("%w with code %d", ErrMCPServerExited, status.Code))
+			return
+		}
+		b.logger.Info("MCP server %s exited with code %d, restarting it", b.cmd, status.Code)
+
+		select {
+		case <-time.After(b.options.RestartDelay):
+		case <-b.ctx.Done():
+			b.output.closeWithError(b.ctx.Err())
+			return
+		}
+		var err error
+		if stdout, err = b.start(); err != nil {
+			b.logger.Error("Failed to restart the MCP server %s: %v", b.cmd, err)
+			b.output.closeWithError(fmt.Errorf("%w: failed to restart it: %v", ErrMCPServerExited, err))
+			return
+		}
+	}
+}
+
+// forwardServer forwards the messages of the server to the host until it
+// closes its output
+func (b *MCPBridge) forwardServer(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), b.options.MaxMessageSize+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var msg jsonrpcMessage
+		if err := json.Unmarshal(line, &msg); err == nil && msg.Method == "" && len(msg.ID) > 0 {
+			id := strings.TrimSpace(string(msg.ID))
+			b.mu.Lock()
+			method := b.pending[id]
+			delete(b.pending, id)
+			initialized := b.initialized
+			b.mu.Unlock()
+
+			if id == mcpReplayID {
+				b.logger.Debug("MCP server %s initialized again", b.cmd)
+				if initialized != nil {
+					b.send(initialized)
+				}
+				continue
+			}
+			if method == "tools/list" && msg.Error == nil {
+				line = b.filterTools(line, msg.Result)
+			}
+		}
+		if !b.write(append(append([]byte{}, line...), '\n')) {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		b.logger.Error("Killing the MCP server %s: %v", b.cmd, err)
+		b.mu.Lock()
+		b.kill()
+		b.mu.Unlock()
+	}
+}
+
+// handleHost enforces the policy on a message of the host and sends it to
+// the server
+func (b *MCPBridge) handleHost(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+	if len(line) > b.options.MaxMessageSize {
+		b.reply(json.RawMessage("null"), &JSONRPCError{Code: JSONRPCInvalidRequest, Message: "message too large"})
+		return
+	}
+	var msg jsonrpcMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		b.reply(json.RawMessage("null"), &JSONRPCError{Code: JSONRPCParseError, Message: "invalid JSON-RPC message"})
+		return
+	}
+	id := strings.TrimSpace(string(msg.ID))
+
+	if msg.Method != "" {
+		if err := b.checkPolicy(&msg); err != nil {
+			b.logger.Info("MCP bridge denied %s: %s", msg.Method, err.Message)
+			if id != "" {
+				b.reply(msg.ID, err)
+			}
+			return
+		}
+		b.mu.Lock()
+		switch {
+		case msg.Method == "initialize" && id != "":
+			b.initRequest = append([]byte{}, line...)
+		case msg.Method == "notifications/initialized":
+			b.initialized = append([]byte{}, line...)
+		}
+		if id != "" {
+			b.pending[id] = msg.Method
+		}
+		b.mu.Unlock()
+	}
+
+	if !b.send(line) && msg.Method != "" && id != "" {
+		b.mu.Lock()
+		delete(b.pending, id)
+		b.mu.Unlock()
+		b.reply(msg.ID, &JSONRPCError{Code: JSONRPCInternalError, Message: "MCP server not running"})
+	}
+}
+
+// checkPolicy returns the error for a request or notification of the host
+// denied by the policy, or nil when it is allowed
+func (b *MCPBridge) checkPolicy(msg *jsonrpcMessage) *JSONRPCError {
+	if len(b.options.AllowedMethods) > 0 && msg.Method != "initialize" && msg.Method != "ping" &&
+		!strings.HasPrefix(msg.Method, "notifications/") && !slices.Contains(b.options.AllowedMethods, msg.Method) {
+		return &JSONRPCError{Code: JSONRPCMethodNotFound, Message: "method not allowed: " + msg.Method}
+	}
+	if msg.Method == "tools/call" && len(b.options.AllowedTools) > 0 {
+		var call struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(msg.Params, &call); err != nil || !slices.Contains(b.options.AllowedTools, call.Name) {
+			return &JSONRPCError{Code: JSONRPCInvalidParams, Message: "tool not allowed: " + call.Name}
+		}
+	}
+	return nil
+}
+
+// filterTools removes the tools not allowed from a "tools/list" response
+func (b *MCPBridge) filterTools(line []byte, result json.RawMessage) []byte {
+	if len(b.options.AllowedTools) == 0 {
+		return line
+	}
+	var fields map[string]json.RawMessage
+	var tools []map[string]json.RawMessage
+	if json.Unmarshal(result, &fields) != nil || json.Unmarshal(fields["tools"], &tools) != nil {
+		return line
+	}
+	allowed := []map[string]json.RawMessage{}
+	for _, tool := range tools {
+		var name string
+		if json.Unmarshal(tool["name"], &name) == nil && slices.Contains(b.options.AllowedTools, name) {
+			allowed = append(allowed, tool)
+		}
+	}
+	fields["tools"], _ = json.Marshal(allowed)
+
+	var response map[string]json.RawMessage
+	if json.Unmarshal(line, &response) != nil {
+		return line
+	}
+	response["result"], _ = json.Marshal(fields)
+	filtered, err := json.Marshal(response)
+	if err != nil {
+		return line
+	}
+	return filtered
+}
+
+// send sends a message to the server, returning false when it is not
+// running
+func (b *MCPBridge) send(line []byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stdin == nil {
+		return false
+	}
+	_, err := b.stdin.Write(append(append([]byte{}, line...), '\n'))
+	return err == nil
+}
+
+// reply sends an error response to the host
+func (b *MCPBridge) reply(id json.RawMessage, rpcErr *JSONRPCError) {
+	data, _ := json.Marshal(jsonrpcErrorResponse{JSONRPC: "2.0", ID: id, Error: rpcErr})
+	b.write(append(data, '\n'))
+}
+
+// write writes a message for the host, returning false when the bridge is
+// closed
+func (b *MCPBridge) write(data []byte) bool {
+	return b.output.write(data)
+}
+
+// mcpOutput is the buffer of the messages for the host, so the bridge never
+// blocks on a host that is not reading (e.g. while it writes)
+type mcpOutput struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+	err  error
+}
+
+// newMCPOutput creates an empty output
+func newMCPOutput() *mcpOutput {
+	o := &mcpOutput{}
+	o.cond = sync.NewCond(&o.mu)
+	return o
+}
+
+// Read reads the buffered messages, waiting for them when there are none
+func (o *mcpOutput) Read(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for o.buf.Len() == 0 && o.err == nil {
+		o.cond.Wait()
+	}
+	if o.buf.Len() > 0 {
+		return o.buf.Read(p)
+	}
+	return 0, o.err
+}
+
+// write buffers a message, returning false once the output is closed
+func (o *mcpOutput) write(data []byte) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err != nil {
+		return false
+	}
+	o.buf.Write(data)
+	o.cond.Broadcast()
+	return true
+}
+
+// closeWithError closes the output: the reads return err (io.EOF when nil)
+// once the buffered messages are read
+func (o *mcpOutput) closeWithError(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err != nil {
+		return
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	o.err = err
+	o.cond.Broadcast()
+}
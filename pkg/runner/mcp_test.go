@@ -0,0 +1,172 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// mcpTestServer is a minimal MCP server answering one message per line
+const mcpTestServer = `ready=0
+while IFS= read -r line; do
+  id=$(echo "$line" | sed -n 's/.*"id":\([^,}]*\).*/\1/p')
+  method=$(echo "$line" | sed -n 's/.*"method":"\([^"]*\)".*/\1/p')
+  case "$method" in
+  initialize) echo '{"jsonrpc":"2.0","id":'"$id"',"result":{"protocolVersion":"2025-06-18"}}' ;;
+  notifications/initialized) ready=1 ;;
+  ready) echo '{"jsonrpc":"2.0","id":'"$id"',"result":'"$ready"'}' ;;
+  tools/list) echo '{"jsonrpc":"2.0","id":'"$id"',"result":{"tools":[{"name":"read"},{"name":"write"}]}}' ;;
+  tools/call) echo '{"jsonrpc":"2.0","id":'"$id"',"result":{"content":[]}}' ;;
+  crash) exit 3 ;;
+  esac
+done
+`
+
+// mcpTestClient sends messages to a bridge and reads its responses
+type mcpTestClient struct {
+	t      *testing.T
+	bridge *MCPBridge
+	lines  chan []byte
+}
+
+// newMCPTestClient starts a bridge to the test server
+func newMCPTestClient(t *testing.T, options MCPBridgeOptions) *mcpTestClient {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	bridge, err := NewMCPBridge(context.Background(), r, "sh", []string{"-c", mcpTestServer}, nil, nil, options, nil)
+	if err != nil {
+		t.Fatalf("NewMCPBridge failed: %v", err)
+	}
+	t.Cleanup(func() { _ = bridge.Close() })
+
+	c := &mcpTestClient{t: t, bridge: bridge, lines: make(chan []byte, 10)}
+	go func() {
+		reader := bufio.NewReader(bridge)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				close(c.lines)
+				return
+			}
+			c.lines <- line
+		}
+	}()
+	return c
+}
+
+// call sends a message and returns the response
+func (c *mcpTestClient) call(message string) jsonrpcMessage {
+	c.t.Helper()
+	if _, err := io.WriteString(c.bridge, message+"\n"); err != nil {
+		c.t.Fatalf("Write failed: %v", err)
+	}
+	select {
+	case line, ok := <-c.lines:
+		if !ok {
+			c.t.Fatalf("the bridge was closed")
+		}
+		var msg jsonrpcMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			c.t.Fatalf("invalid response %q: %v", line, err)
+		}
+		return msg
+	case <-time.After(10 * time.Second):
+		c.t.Fatalf("no response to %s", message)
+	}
+	return jsonrpcMessage{}
+}
+
+func TestMCPBridge_restart(t *testing.T) {
+	c := newMCPTestClient(t, MCPBridgeOptions{MaxRestarts: 1})
+
+	if msg := c.call(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`); msg.Error != nil || string(msg.ID) != "1" {
+		t.Fatalf("initialize response = %+v", msg)
+	}
+	if _, err := io.WriteString(c.bridge, `{"jsonrpc":"2.0","method":"notifications/initialized"}`+"\n"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if msg := c.call(`{"jsonrpc":"2.0","id":2,"method":"ready"}`); string(msg.Result) != "1" {
+		t.Fatalf("ready response = %+v", msg)
+	}
+
+	// the pending call fails, and the new server is initialized again
+	if msg := c.call(`{"jsonrpc":"2.0","id":3,"method":"crash"}`); msg.Error == nil || string(msg.ID) != "3" {
+		t.Errorf("crash response = %+v, want an error", msg)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for c.bridge.Restarts() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the server was not restarted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var msg jsonrpcMessage
+	for deadline = time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		// the replay may still be in flight
+		if msg = c.call(`{"jsonrpc":"2.0","id":4,"method":"ready"}`); string(msg.Result) == "1" {
+			break
+		}
+	}
+	if string(msg.Result) != "1" {
+		t.Errorf("ready response after the restart = %+v, want the server initialized", msg)
+	}
+
+	// no more restarts
+	c.call(`{"jsonrpc":"2.0","id":5,"method":"crash"}`)
+	select {
+	case _, ok := <-c.lines:
+		if ok {
+			t.Errorf("unexpected message after the last crash")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the bridge was not closed")
+	}
+	if _, err := c.bridge.Read(make([]byte, 1)); !errors.Is(err, ErrMCPServerExited) {
+		t.Errorf("Read() = %v, want ErrMCPServerExited", err)
+	}
+}
+
+func TestMCPBridge_policy(t *testing.T) {
+	c := newMCPTestClient(t, MCPBridgeOptions{
+		AllowedMethods: []string{"tools/list", "tools/call"},
+		AllowedTools:   []string{"read"},
+		MaxMessageSize: 1024,
+	})
+
+	var list struct {
+		Tools []struct {
+			Name string `json:"name"`
+		} `json:"tools"`
+	}
+	msg := c.call(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+	if err := json.Unmarshal(msg.Result, &list); err != nil || len(list.Tools) != 1 || list.Tools[0].Name != "read" {
+		t.Errorf("tools/list response = %s, want only the read tool", msg.Result)
+	}
+	if msg := c.call(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"read"}}`); msg.Error != nil {
+		t.Errorf("allowed tools/call response = %+v", msg)
+	}
+	if msg := c.call(`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"write"}}`); msg.Error == nil || msg.Error.Code != JSONRPCInvalidParams {
+		t.Errorf("denied tools/call response = %+v, want an error", msg)
+	}
+	if msg := c.call(`{"jsonrpc":"2.0","id":4,"method":"resources/read"}`); msg.Error == nil || msg.Error.Code != JSONRPCMethodNotFound {
+		t.Errorf("denied method response = %+v, want an error", msg)
+	}
+	if msg := c.call(`not json`); msg.Error == nil || msg.Error.Code != JSONRPCParseError {
+		t.Errorf("invalid message response = %+v, want a parse error", msg)
+	}
+	large := `{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"read","arguments":"` + string(make([]byte, 2000)) + `"}}`
+	if msg := c.call(large); msg.Error == nil || msg.Error.Code != JSONRPCInvalidRequest {
+		t.Errorf("large message response = %+v, want an error", msg)
+	}
+}
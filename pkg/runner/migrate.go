@@ -0,0 +1,189 @@
+package runner
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// OptionsVersionKey is the option holding the version of the options schema
+// a configuration was written for. Configurations without it are assumed to
+// be of version 1.
+const OptionsVersionKey = "options_version"
+
+// CurrentOptionsVersion is the version of the options schema of this release.
+// It is increased whenever options are deprecated (see Deprecation).
+const CurrentOptionsVersion = 1
+
+// OptionsSchema describes the options accepted by a runner type
+type OptionsSchema struct {
+	// Runner is the runner type
+	Runner Type `json:"runner"`
+
+	// Version is the version of the schema
+	Version int `json:"version"`
+
+	// Keys are the (sorted) top level option keys, empty for the types
+	// registered without the struct of their options
+	Keys []string `json:"keys"`
+}
+
+// Has returns whether the schema accepts the key
+func (s OptionsSchema) Has(key string) bool {
+	i := sort.SearchStrings(s.Keys, key)
+	return i < len(s.Keys) && s.Keys[i] == key
+}
+
+// optionStructs are the option types of the runners, whose JSON keys make
+// the schemas
+var optionStructs = map[Type]interface{}{
+	TypeExec:              ExecOptions{},
+	TypeSandboxExec:       SandboxExecOptions{},
+	TypeFirejail:          FirejailOptions{},
+	TypeLandrun:           LandrunOptions{},
+	TypeNsjail:            NsjailOptions{},
+	TypeDocker:            DockerOptions{},
+	TypePodman:            DockerOptions{},
+	TypeADB:               ADBOptions{},
+	TypeProot:             ProotOptions{},
+	TypeDeno:              DenoOptions{},
+	TypePython:            PythonOptions{},
+	TypeWindowsRestricted: WindowsRestrictedOptions{},
+	TypeUnshare:           UnshareOptions{},
+}
+
+// SchemaFor returns the current options schema of a runner type
+func SchemaFor(runnerType Type) (OptionsSchema, error) {
+	opts, ok := optionStructs[runnerType]
+	if !ok {
+		backend, registered := registeredBackend(runnerType)
+		if !registered {
+			return OptionsSchema{}, fmt.Errorf("unknown runner type: %s", runnerType)
+		}
+		if backend.Options == nil {
+			return OptionsSchema{Runner: runnerType, Version: CurrentOptionsVersion}, nil
+		}
+		opts = backend.Options
+	}
+
+	keys := []string{OptionsVersionKey, ProfileKey}
+	t := reflect.TypeOf(opts)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	keys = appendJSONKeys(keys, t)
+	sort.Strings(keys)
+
+	return OptionsSchema{
+		Runner:  runnerType,
+		Version: CurrentOptionsVersion,
+		Keys:    keys,
+	}, nil
+}
+
+// appendJSONKeys appends the JSON keys of the fields of a struct, including
+// those of its embedded structs
+func appendJSONKeys(keys []string, t reflect.Type) []string {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			keys = appendJSONKeys(keys, field.Type)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		keys = append(keys, name)
+	}
+	return keys
+}
+
+// Migrate upgrades the options of a runner written for an older version of
+// the schema: renamed keys are replaced with their current names, and the
+// version is set to CurrentOptionsVersion (unless the options are for a newer
+// version). The original options are not modified.
+//
+// The returned warnings describe the changes made, and report the keys that
+// are not known by the runner (which are kept, and ignored by the runner),
+// so configurations can be fixed before they silently stop applying.
+func Migrate(runnerType Type, old Options) (Options, []string, error) {
+	schema, err := SchemaFor(runnerType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	version, err := optionsVersion(old)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var warnings []string
+	if version > CurrentOptionsVersion {
+		warnings = append(warnings, fmt.Sprintf("options are for version %d of the schema, newer than the supported version %d",
+			version, CurrentOptionsVersion))
+	}
+
+	migrated := Options{}
+	for key, value := range old {
+		migrated[key] = value
+	}
+
+	for _, d := range deprecations {
+		if d.Replacement == "" || d.Since <= version || !d.appliesTo(runnerType) {
+			continue
+		}
+		value, ok := migrated[d.Option]
+		if !ok {
+			continue
+		}
+		delete(migrated, d.Option)
+		if _, exists := migrated[d.Replacement]; exists {
+			warnings = append(warnings, fmt.Sprintf("option %q was renamed to %q, which is also set: %q is ignored",
+				d.Option, d.Replacement, d.Option))
+			continue
+		}
+		migrated[d.Replacement] = value
+		warnings = append(warnings, fmt.Sprintf("option %q was renamed to %q", d.Option, d.Replacement))
+	}
+
+	var unknown []string
+	for key := range migrated {
+		if len(schema.Keys) > 0 && !schema.Has(key) {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		warnings = append(warnings, fmt.Sprintf("unknown option %q for the %s runner", key, runnerType))
+	}
+
+	if version <= CurrentOptionsVersion {
+		migrated[OptionsVersionKey] = CurrentOptionsVersion
+	}
+	return migrated, warnings, nil
+}
+
+// optionsVersion returns the schema version of the options
+func optionsVersion(opts Options) (int, error) {
+	switch v := opts[OptionsVersionKey].(type) {
+	case nil:
+		return 1, nil
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		// numbers decoded from JSON
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	case uint64:
+		return int(v), nil
+	}
+	return 0, fmt.Errorf("invalid %s: %v", OptionsVersionKey, opts[OptionsVersionKey])
+}
@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSchemaFor(t *testing.T) {
+	schema, err := SchemaFor(TypeDocker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"image", "mounts", "ca_bundle", "allow_ssh_agent", OptionsVersionKey} {
+		if !schema.Has(key) {
+			t.Errorf("expected %q in the docker schema", key)
+		}
+	}
+	if schema.Has("allow_read_exec_folders") {
+		t.Errorf("expected landrun options not to be in the docker schema")
+	}
+	if schema.Version != CurrentOptionsVersion {
+		t.Errorf("unexpected schema version %d", schema.Version)
+	}
+
+	if _, err := SchemaFor("unknown"); err == nil {
+		t.Error("expected an error for an unknown runner type")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	defer func(d []Deprecation) { deprecations = d }(deprecations)
+	deprecations = []Deprecation{
+		{Since: CurrentOptionsVersion + 1, Runner: TypeDocker, Option: "volumes", Replacement: "mounts"},
+		{Since: CurrentOptionsVersion + 1, Option: "network_enabled", Replacement: "allow_networking"},
+	}
+
+	old := Options{
+		"image":           "alpine",
+		"volumes":         []string{"/data:/data"},
+		"network_enabled": true,
+		"allow_networkin": false,
+	}
+	migrated, warnings, err := Migrate(TypeDocker, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Options{
+		"image":            "alpine",
+		"mounts":           []string{"/data:/data"},
+		"allow_networking": true,
+		"allow_networkin":  false,
+		OptionsVersionKey:  CurrentOptionsVersion,
+	}
+	if !reflect.DeepEqual(migrated, expected) {
+		t.Errorf("unexpected migrated options:\n got %v\nwant %v", migrated, expected)
+	}
+	expectedWarnings := []string{
+		`option "volumes" was renamed to "mounts"`,
+		`option "network_enabled" was renamed to "allow_networking"`,
+		`unknown option "allow_networkin" for the docker runner`,
+	}
+	if !reflect.DeepEqual(warnings, expectedWarnings) {
+		t.Errorf("unexpected warnings:\n got %q\nwant %q", warnings, expectedWarnings)
+	}
+	if _, ok := old["mounts"]; ok {
+		t.Error("expected the original options not to be modified")
+	}
+
+	// renames of other runners and of older versions are not applied
+	migrated, _, err = Migrate(TypeExec, Options{"volumes": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := migrated["volumes"]; !ok {
+		t.Error("expected the docker rename not to apply to exec")
+	}
+	migrated, _, err = Migrate(TypeDocker, Options{"volumes": "x", OptionsVersionKey: float64(CurrentOptionsVersion + 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := migrated["volumes"]; !ok {
+		t.Error("expected up to date options not to be renamed")
+	}
+}
+
+func TestMigrate_conflicts(t *testing.T) {
+	defer func(d []Deprecation) { deprecations = d }(deprecations)
+	deprecations = []Deprecation{{Since: CurrentOptionsVersion + 1, Option: "net", Replacement: "allow_networking"}}
+
+	migrated, warnings, err := Migrate(TypeFirejail, Options{"net": true, "allow_networking": false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated["allow_networking"] != false || len(warnings) != 1 {
+		t.Errorf("expected the current key to win with a warning, got %v %q", migrated, warnings)
+	}
+
+	_, warnings, err = Migrate(TypeFirejail, Options{OptionsVersionKey: CurrentOptionsVersion + 5})
+	if err != nil || len(warnings) != 1 {
+		t.Errorf("expected a warning for a newer version, got %q (%v)", warnings, err)
+	}
+	if _, _, err := Migrate(TypeFirejail, Options{OptionsVersionKey: "two"}); err == nil {
+		t.Error("expected an error for an invalid version")
+	}
+}
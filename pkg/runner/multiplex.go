@@ -0,0 +1,383 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrSessionClosed is returned when starting commands in a closed Session
+var ErrSessionClosed = errors.New("session closed")
+
+// sessionBackend is a long-lived sandbox where commands are started
+type sessionBackend interface {
+	// start starts a command in the sandbox
+	start(ctx context.Context, cmd string, args []string, env []string) (*Execution, error)
+
+	// close destroys the sandbox
+	close()
+}
+
+// sessionOpener is implemented by the runners supporting sessions
+type sessionOpener interface {
+	openSession(ctx context.Context, params map[string]interface{}) (sessionBackend, error)
+}
+
+// Session is a long-lived sandbox of a runner (a Docker container, or a
+// firejail sandbox) where several commands run concurrently, each with its
+// own pipes and Wait. The commands share the filesystem, processes and
+// network of the sandbox.
+//
+// A Session is a Runner, so Start, Connect, pools... can start commands in
+// it. The sandbox is reference counted: it is destroyed once the session is
+// closed and all the commands started in it have been waited for.
+type Session struct {
+	runner  Runner
+	backend sessionBackend
+	logger  Logger
+
+	mu   sync.Mutex
+	refs int
+	open bool
+	done chan struct{}
+}
+
+// OpenSession creates a sandbox with the restrictions of a runner, with the
+// template variables of params applied, and returns the session for starting
+// commands in it. ErrNotSupported is returned for runners without sessions.
+func OpenSession(ctx context.Context, r Runner, params map[string]interface{}) (*Session, error) {
+	opener, ok := r.(sessionOpener)
+	if !ok {
+		return nil, fmt.Errorf("sessions: %w", ErrNotSupported)
+	}
+	backend, err := opener.openSession(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return newSession(r, backend, defaultLogger(nil)), nil
+}
+
+// newSession returns a session holding the reference of its opener
+func newSession(r Runner, backend sessionBackend, logger Logger) *Session {
+	return &Session{runner: r, backend: backend, logger: logger, refs: 1, open: true, done: make(chan struct{})}
+}
+
+// Run executes a command in the session and returns its output. The params
+// of the session are used, and those of the call are ignored.
+func (s *Session) Run(ctx context.Context, shell string, command string, env []string, params map[string]interface{}, tmpfile bool) (string, error) {
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	e, err := s.start(ctx, shell, []string{"-c", command}, env, params)
+	if err != nil {
+		return "", err
+	}
+	_ = e.Stdin.Close()
+	var stdout, stderr bytes.Buffer
+	stdoutWriter, stderrWriter := captureRunOutput(ctx, &stdout, &stderr)
+	stderrDone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(stderrWriter, e.Stderr)
+		close(stderrDone)
+	}()
+	_, readErr := io.Copy(stdoutWriter, e.Stdout)
+	<-stderrDone
+	if err := e.Wait(); err != nil {
+		return "", fmt.Errorf("command failed: %w, stderr: %s", err, stderr.String())
+	}
+	if readErr != nil {
+		return "", readErr
+	}
+	return stdout.String(), nil
+}
+
+// RunEx executes a command in the session like Run, returning its exit code and
+// both output streams. It implements the Runner interface.
+func (s *Session) RunEx(ctx context.Context, req RunRequest) (*RunResult, error) {
+	return runEx(ctx, s, req)
+}
+
+// RunWithPipes starts a command in the session. The params of the session
+// are used, and those of the call are ignored.
+func (s *Session) RunWithPipes(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (
+	stdin io.WriteCloser,
+	stdout io.ReadCloser,
+	stderr io.ReadCloser,
+	wait func() error,
+	err error,
+) {
+	e, err := s.start(ctx, cmd, args, env, params)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return e.Stdin, e.Stdout, e.Stderr, e.Wait, nil
+}
+
+// CheckImplicitRequirements returns ErrSessionClosed once the session is
+// closed
+func (s *Session) CheckImplicitRequirements() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.open {
+		return ErrSessionClosed
+	}
+	return nil
+}
+
+// start starts a command in the sandbox, holding a reference to it until
+// the command has been waited for
+func (s *Session) start(ctx context.Context, cmd string, args []string, env []string, _ map[string]interface{}) (*Execution, error) {
+	s.mu.Lock()
+	if !s.open {
+		s.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+	s.refs++
+	s.mu.Unlock()
+
+	e, err := s.backend.start(ctx, cmd, args, env)
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+	e.exitHooks = append(e.exitHooks, s.release)
+	return e, nil
+}
+
+// Close releases the reference of the opener of the session: no more
+// commands can be started, and the sandbox is destroyed once the running
+// commands have been waited for.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	if !s.open {
+		s.mu.Unlock()
+		return nil
+	}
+	s.open = false
+	s.mu.Unlock()
+	s.release()
+	return nil
+}
+
+// Refs returns the number of references to the sandbox: the running commands,
+// plus the opener until the session is closed
+func (s *Session) Refs() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refs
+}
+
+// Done is closed once the sandbox has been destroyed
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// release drops a reference, destroying the sandbox with the last one
+func (s *Session) release() {
+	s.mu.Lock()
+	s.refs--
+	last := s.refs == 0
+	s.mu.Unlock()
+	if last {
+		s.logger.Debug("Destroying the session sandbox")
+		s.backend.close()
+		close(s.done)
+	}
+}
+
+// dockerSession is a container where the commands are executed
+type dockerSession struct {
+	r         *Docker
+	logger    Logger
+	container string
+	ports     []PublishedPort
+	remove    func()
+}
+
+// openSession creates the container of a session
+func (r *Docker) openSession(ctx context.Context, params map[string]interface{}) (sessionBackend, error) {
+	logger := contextLogger(ctx, r.logger)
+	env := r.opts.pinEnv(nil)
+	env = r.opts.caBundleEnv(env, caBundleGuestPath)
+
+	container, ports, remove, err := r.createContainer(ctx, logger, env, params)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Opened session in container %s", container)
+	return &dockerSession{r: r, logger: logger, container: container, ports: ports, remove: remove}, nil
+}
+
+// start executes a command in the container
+func (s *dockerSession) start(ctx context.Context, cmd string, args []string, env []string) (*Execution, error) {
+	logger := contextLogger(ctx, s.logger)
+	env = s.r.opts.withEnvFiles(env)
+	env = s.r.opts.pinEnv(env)
+	env = s.r.opts.scrubAgentEnv(env)
+	env = s.r.opts.scrubDisplayEnv(env)
+	if err := checkNoExtraFiles(ctx, s.r.opts.engine()); err != nil {
+		return nil, err
+	}
+
+	execArgs := []string{"exec", "-i"}
+	for _, envVar := range env {
+		execArgs = append(execArgs, "-e", envVar)
+	}
+	mode := s.r.shellMode(ctx)
+	containerCmd, containerArgs := s.r.opts.containerCommand(mode, cmd, args)
+	execArgs = append(execArgs, s.container, containerCmd)
+	execArgs = append(execArgs, containerArgs...)
+
+	logger.Debug("Executing in session container: %s %v", s.r.opts.engine(), execArgs)
+	e, err := startProcess(logger, commandContext(ctx, s.r.opts.engine(), execArgs...), nil)
+	if err != nil {
+		return nil, err
+	}
+	e.backend = &sharedContainerBackend{containerBackend{engine: s.r.opts.engine(), container: s.container,
+		mounts: s.r.opts.Mounts, shell: containerShell(mode)}}
+	e.ports = s.ports
+	return e, nil
+}
+
+// close removes the container
+func (s *dockerSession) close() {
+	s.remove()
+}
+
+// sharedContainerBackend is the backend of the commands of a container
+// shared by a session, where pausing or signalling the container would affect
+// the other commands
+type sharedContainerBackend struct {
+	containerBackend
+}
+
+func (b *sharedContainerBackend) pause() error {
+	return ErrNotSupported
+}
+
+func (b *sharedContainerBackend) resume() error {
+	return ErrNotSupported
+}
+
+func (b *sharedContainerBackend) signal(sig syscall.Signal) error {
+	return ErrNotSupported
+}
+
+// firejailSession is a named firejail sandbox, kept alive by a sleeping
+// process, that commands join
+type firejailSession struct {
+	r           *Firejail
+	logger      Logger
+	name        string
+	keepAlive   *exec.Cmd
+	writable    []string
+	removeFiles func()
+}
+
+// firejailSessionTimeout is how long to wait for the sandbox of a session to
+// be ready
+const firejailSessionTimeout = 10 * time.Second
+
+// openSession starts the sandbox of a session
+func (r *Firejail) openSession(ctx context.Context, params map[string]interface{}) (sessionBackend, error) {
+	logger := contextLogger(ctx, r.logger)
+	firejailArgs, profileOpts, removeFiles, err := r.sandboxArgs(ctx, logger, params)
+	if err != nil {
+		return nil, err
+	}
+
+	name := "go-restricted-runner-" + newExecutionID()
+	firejailArgs = append(firejailArgs, "--name="+name, "sleep", "infinity")
+	keepAlive := exec.Command(r.options.lookPath("firejail"), firejailArgs...)
+	keepAlive.Env = r.options.scrubDisplayEnv(r.options.agentEnv(os.Environ()))
+	setProcessGroup(keepAlive)
+	logger.Debug("Starting session sandbox: %s", keepAlive.String())
+	if err := keepAlive.Start(); err != nil {
+		removeFiles()
+		return nil, fmt.Errorf("failed to start the session sandbox: %w", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = keepAlive.Wait()
+		close(exited)
+	}()
+
+	s := &firejailSession{r: r, logger: logger, name: name, keepAlive: keepAlive,
+		writable: profileOpts.AllowWriteFolders, removeFiles: removeFiles}
+	if err := s.waitReady(ctx, exited); err != nil {
+		s.close()
+		return nil, err
+	}
+	logger.Debug("Opened session in firejail sandbox %s", name)
+	return s, nil
+}
+
+// waitReady waits until the sandbox can be joined
+func (s *firejailSession) waitReady(ctx context.Context, exited <-chan struct{}) error {
+	deadline := time.Now().Add(firejailSessionTimeout)
+	for time.Now().Before(deadline) {
+		output, _ := exec.Command(s.r.options.lookPath("firejail"), "--list").Output()
+		for _, line := range strings.Split(string(output), "\n") {
+			if strings.Contains(line, ":"+s.name+":") {
+				return nil
+			}
+		}
+		select {
+		case <-exited:
+			return fmt.Errorf("the session sandbox exited")
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("timed out waiting for the session sandbox")
+}
+
+// start runs a command joining the sandbox
+func (s *firejailSession) start(ctx context.Context, cmd string, args []string, env []string) (*Execution, error) {
+	logger := contextLogger(ctx, s.logger)
+	opts := s.r.options
+	env = opts.withEnvFiles(env)
+	env = opts.pinEnv(env)
+	env = opts.pathEnv(env)
+	env = opts.caBundleEnv(env, opts.CABundle)
+	if err := checkNoExtraFiles(ctx, "firejail sessions"); err != nil {
+		return nil, err
+	}
+	if err := opts.verifyPinnedExecutables(logger, append([]string{cmd}, args...)...); err != nil {
+		return nil, err
+	}
+
+	// the environment of the joining process is not passed to the command
+	joinArgs := []string{"--join=" + s.name}
+	if len(env) > 0 {
+		joinArgs = append(joinArgs, "env")
+		joinArgs = append(joinArgs, env...)
+	}
+	joinArgs = append(joinArgs, cmd)
+	joinArgs = append(joinArgs, args...)
+
+	execCmd := commandContext(ctx, opts.lookPath("firejail"), joinArgs...)
+	execCmd.Env = opts.scrubDisplayEnv(opts.agentEnv(os.Environ()))
+	applyUmask(logger, execCmd, opts.Umask)
+	logger.Debug("Executing in session sandbox: %s", execCmd.String())
+	return startProcess(logger, execCmd, nil)
+}
+
+// close stops the sandbox
+func (s *firejailSession) close() {
+	if output, err := exec.Command(s.r.options.lookPath("firejail"), "--shutdown="+s.name).CombinedOutput(); err != nil {
+		s.logger.Debug("Warning: failed to shut down the firejail sandbox %s: %v: %s", s.name, err, string(output))
+		_ = s.keepAlive.Process.Kill()
+	}
+	s.r.options.normalizeFileModes(s.logger, s.writable)
+	s.removeFiles()
+}
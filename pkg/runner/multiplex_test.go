@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeSessionBackend starts the commands with the exec runner
+type fakeSessionBackend struct {
+	r      *Exec
+	closed atomic.Int32
+}
+
+func (b *fakeSessionBackend) start(ctx context.Context, cmd string, args []string, env []string) (*Execution, error) {
+	return b.r.start(ctx, cmd, args, env, nil)
+}
+
+func (b *fakeSessionBackend) close() {
+	b.closed.Add(1)
+}
+
+// newFakeSession returns a session of the fake backend
+func newFakeSession(t *testing.T) (*Session, *fakeSessionBackend) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	backend := &fakeSessionBackend{r: r}
+	return newSession(r, backend, defaultLogger(nil)), backend
+}
+
+func TestSession_referenceCounting(t *testing.T) {
+	s, backend := newFakeSession(t)
+	ctx := context.Background()
+
+	// concurrent commands, each with its own pipes
+	first, err := Start(ctx, s, "cat", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	second, err := Start(ctx, s, "cat", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if refs := s.Refs(); refs != 3 {
+		t.Errorf("Refs() = %d, want 3", refs)
+	}
+
+	_, _ = io.WriteString(second.Stdin, "second")
+	_ = second.Stdin.Close()
+	if output, _ := io.ReadAll(second.Stdout); string(output) != "second" {
+		t.Errorf("second output = %q", output)
+	}
+	_, _ = io.ReadAll(second.Stderr)
+	if err := second.Wait(); err != nil {
+		t.Errorf("second Wait failed: %v", err)
+	}
+
+	// the sandbox is kept while a command runs after closing the session
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := Start(ctx, s, "true", nil, nil, nil); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Start() after Close = %v, want ErrSessionClosed", err)
+	}
+	if backend.closed.Load() != 0 || s.Refs() != 1 {
+		t.Errorf("the sandbox was destroyed with a running command (refs = %d)", s.Refs())
+	}
+
+	_ = first.Stdin.Close()
+	_, _ = io.ReadAll(first.Stdout)
+	_, _ = io.ReadAll(first.Stderr)
+	_ = first.Wait()
+	<-s.Done()
+	if backend.closed.Load() != 1 {
+		t.Errorf("the sandbox was closed %d times, want 1", backend.closed.Load())
+	}
+
+	// waiting again does not release more references
+	_ = first.Wait()
+	_ = s.Close()
+	if backend.closed.Load() != 1 || s.Refs() != 0 {
+		t.Errorf("closed %d times, refs = %d", backend.closed.Load(), s.Refs())
+	}
+}
+
+func TestSession_Run(t *testing.T) {
+	s, backend := newFakeSession(t)
+	ctx := context.Background()
+
+	output, err := s.Run(ctx, "", "echo $GREETING", []string{"GREETING=hello"}, nil, false)
+	if err != nil || strings.TrimSpace(output) != "hello" {
+		t.Errorf("Run() = %q, %v", output, err)
+	}
+	if _, err := s.Run(ctx, "", "echo oops >&2; exit 2", nil, nil, false); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("Run() of a failing command = %v", err)
+	}
+	if NormalizeExit(s, errors.New("x")) != NormalizeExit(backend.r, errors.New("x")) {
+		t.Errorf("the exit codes of the session differ from those of its runner")
+	}
+
+	_ = s.Close()
+	if err := s.CheckImplicitRequirements(); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("CheckImplicitRequirements() = %v, want ErrSessionClosed", err)
+	}
+	if backend.closed.Load() != 1 {
+		t.Errorf("the sandbox was not destroyed")
+	}
+}
+
+func TestOpenSession_notSupported(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	if _, err := OpenSession(context.Background(), r, nil); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("OpenSession() = %v, want ErrNotSupported", err)
+	}
+}
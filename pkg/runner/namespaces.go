@@ -0,0 +1,124 @@
+package runner
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+)
+
+// NamespaceOptions runs the commands in new Linux namespaces, hiding (and
+// protecting) the host from them in ways filesystem restrictions cannot.
+type NamespaceOptions struct {
+	// PrivatePIDs runs the command in a new PID namespace, with its own
+	// /proc, so it cannot see or signal the processes of the host
+	PrivatePIDs bool `json:"private_pids"`
+
+	// ReadOnlyRoot runs the command in a new mount namespace where the whole
+	// filesystem is read-only, except the writable folders of the runner
+	ReadOnlyRoot bool `json:"read_only_root"`
+
+	// PrivateIPC runs the command in a new IPC namespace, so it cannot reach
+	// the System V shared memory, semaphores and message queues (nor the
+	// POSIX message queues) of the host
+	PrivateIPC bool `json:"private_ipc"`
+
+	// PrivateUTS runs the command in a new UTS namespace, where the hostname
+	// is Hostname, so the hostname of the host does not leak
+	PrivateUTS bool `json:"private_uts"`
+
+	// Hostname is the hostname of the command (defaults to "localhost").
+	// Setting it implies PrivateUTS.
+	Hostname string `json:"hostname"`
+}
+
+// defaultHostname is the hostname in private UTS namespaces
+const defaultHostname = "localhost"
+
+// hostnameRegexp matches valid hostnames (RFC 1123)
+var hostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+
+// validateHostname checks that a hostname is valid
+func validateHostname(hostname string) error {
+	if len(hostname) > 64 || !hostnameRegexp.MatchString(hostname) {
+		return fmt.Errorf("invalid hostname: %q", hostname)
+	}
+	return nil
+}
+
+// hostname returns the hostname of the command in a private UTS namespace,
+// or "" when the UTS namespace is not private
+func (o NamespaceOptions) hostname() string {
+	switch {
+	case o.Hostname != "":
+		return o.Hostname
+	case o.PrivateUTS:
+		return defaultHostname
+	}
+	return ""
+}
+
+// validateNamespaces checks that the namespaces can be created in this OS
+func (o NamespaceOptions) validateNamespaces() error {
+	if o.Hostname != "" {
+		if err := validateHostname(o.Hostname); err != nil {
+			return err
+		}
+	}
+	if runtime.GOOS == "linux" {
+		return nil
+	}
+	switch {
+	case o.PrivatePIDs:
+		return fmt.Errorf("private_pids requires Linux: %w", ErrNotSupported)
+	case o.ReadOnlyRoot:
+		return fmt.Errorf("read_only_root requires Linux: %w", ErrNotSupported)
+	case o.PrivateIPC:
+		return fmt.Errorf("private_ipc requires Linux: %w", ErrNotSupported)
+	case o.hostname() != "":
+		return fmt.Errorf("private_uts requires Linux: %w", ErrNotSupported)
+	}
+	return nil
+}
+
+// namespaceSetup is how a command is isolated in new namespaces
+type namespaceSetup struct {
+	// PrivatePIDs runs the command in a new PID namespace
+	PrivatePIDs bool `json:"private_pids,omitempty"`
+
+	// MountProc mounts a new /proc for the PID namespace
+	MountProc bool `json:"mount_proc,omitempty"`
+
+	// ReadOnlyRoot makes the filesystem read-only, except Writable
+	ReadOnlyRoot bool `json:"read_only_root,omitempty"`
+
+	// Writable are the folders kept writable with ReadOnlyRoot
+	Writable []string `json:"writable,omitempty"`
+
+	// PrivateIPC runs the command in a new IPC namespace
+	PrivateIPC bool `json:"private_ipc,omitempty"`
+
+	// Hostname runs the command in a new UTS namespace with this hostname
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// enabled returns whether the command must run in new namespaces
+func (s namespaceSetup) enabled() bool {
+	return s.PrivatePIDs || s.ReadOnlyRoot || s.PrivateIPC || s.Hostname != ""
+}
+
+// namespaceSetup returns the isolation of the commands, with the given
+// writable folders. A new /proc is mounted unless mounting is not possible
+// (e.g. under Landlock).
+func (o NamespaceOptions) namespaceSetup(canMount bool, writable []string) namespaceSetup {
+	setup := namespaceSetup{
+		PrivatePIDs:  o.PrivatePIDs,
+		MountProc:    o.PrivatePIDs && canMount,
+		ReadOnlyRoot: o.ReadOnlyRoot && canMount,
+		PrivateIPC:   o.PrivateIPC,
+		Hostname:     o.hostname(),
+	}
+	if setup.ReadOnlyRoot {
+		setup.Writable = writable
+	}
+	return setup
+}
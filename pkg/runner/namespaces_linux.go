@@ -0,0 +1,244 @@
+//go:build linux
+
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// namespaceHelperEnv is set (to the JSON configuration of the helper) when
+// this executable is started as the helper that sets up the namespaces of
+// the command
+const namespaceHelperEnv = "RUNNER_NAMESPACE_HELPER"
+
+// prCapAmbientLower is the prctl argument for dropping an ambient capability
+const prCapAmbientLower = 3
+
+// capSysAdmin is the capability required to mount filesystems and set the hostname
+const capSysAdmin = 21
+
+// mount_setattr(2) constants, not in the syscall package
+const (
+	sysMountSetattr = 442
+	atFDCWD         = -100
+	atRecursive     = 0x8000
+	mountAttrRdonly = 0x1
+)
+
+// namespaceHelperConfig is the configuration passed to the namespace helper
+type namespaceHelperConfig struct {
+	namespaceSetup
+
+	// ExtraFiles is the number of files passed to the command after stderr
+	ExtraFiles int `json:"extra_files,omitempty"`
+}
+
+// isolateNamespaces changes cmd so it runs in new namespaces, in its own
+// process group.
+//
+// The command is started through this executable: the helper mode (see
+// runNamespaceHelper) sets up the mounts of a new mount namespace (a new
+// /proc, the read-only view of the filesystem) and the hostname, and runs the
+// command. With a
+// new PID namespace the helper is its first process (its init): it reaps
+// the processes orphaned in the namespace, and all of them are killed once
+// the command exits.
+//
+// It must be called after any other isolation of the command (e.g.
+// isolateLoopback), as the helper must be the outermost process.
+func isolateNamespaces(cmd *exec.Cmd, setup namespaceSetup) error {
+	if cmd.Err != nil || !setup.enabled() {
+		return nil
+	}
+
+	self, err := helperExecutable("namespace helper")
+	if err != nil {
+		return err
+	}
+	config, err := json.Marshal(namespaceHelperConfig{namespaceSetup: setup, ExtraFiles: len(cmd.ExtraFiles)})
+	if err != nil {
+		return fmt.Errorf("failed to configure the namespace helper: %w", err)
+	}
+
+	cmd.Args = append([]string{"runner-namespace-helper", cmd.Path}, cmd.Args...)
+	cmd.Path = self
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, namespaceHelperEnv+"="+string(config))
+
+	// signals must reach the command, not only the helper
+	setProcessGroup(cmd)
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNS
+	if setup.PrivatePIDs {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWPID
+	}
+	if setup.PrivateIPC {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWIPC
+	}
+	if setup.Hostname != "" {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUTS
+	}
+	inUserNamespace(cmd, capSysAdmin)
+	return nil
+}
+
+// inUserNamespace runs cmd in a new user namespace mapping the current user
+// when it is not root, so unprivileged users can create the other
+// namespaces. The command is granted the capabilities given until it drops
+// them.
+func inUserNamespace(cmd *exec.Cmd, caps ...uintptr) {
+	uid := os.Getuid()
+	if uid == 0 {
+		return
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if cmd.SysProcAttr.Cloneflags&syscall.CLONE_NEWUSER == 0 {
+		gid := os.Getgid()
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}}
+	}
+	cmd.SysProcAttr.AmbientCaps = append(cmd.SysProcAttr.AmbientCaps, caps...)
+}
+
+// namespaceHelperFail reports an error of the namespace helper and exits
+func namespaceHelperFail(code int, format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "runner: "+format+"\n", args...)
+	os.Exit(code)
+}
+
+// runNamespaceHelper sets up the namespaces of the command in os.Args[1:]
+// (its path followed by its arguments) and runs it. With a new PID
+// namespace it exits with the exit code of the command, or 128 plus the
+// number of the signal that killed it. It never returns.
+func runNamespaceHelper() {
+	var config namespaceHelperConfig
+	if err := json.Unmarshal([]byte(os.Getenv(namespaceHelperEnv)), &config); err != nil {
+		namespaceHelperFail(126, "invalid namespace helper configuration: %v", err)
+	}
+	_ = os.Unsetenv(namespaceHelperEnv)
+
+	if len(os.Args) < 3 {
+		namespaceHelperFail(126, "missing command for the namespace helper")
+	}
+
+	// mounts must not propagate to the host (and are not possible under Landlock)
+	if config.MountProc || config.ReadOnlyRoot {
+		if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+			namespaceHelperFail(126, "failed to make the mounts private: %v", err)
+		}
+	}
+	if config.MountProc {
+		if err := syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+			namespaceHelperFail(126, "failed to mount /proc: %v", err)
+		}
+	}
+	if config.ReadOnlyRoot {
+		if err := mountReadOnlyRoot(config.Writable); err != nil {
+			namespaceHelperFail(126, "failed to make the filesystem read-only: %v", err)
+		}
+	}
+
+	if config.Hostname != "" {
+		if err := syscall.Sethostname([]byte(config.Hostname)); err != nil {
+			namespaceHelperFail(126, "failed to set the hostname: %v", err)
+		}
+	}
+
+	// the command must not keep the capability granted to set up the namespaces
+	_, _, _ = syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientLower, capSysAdmin, 0, 0, 0)
+
+	if !config.PrivatePIDs {
+		err := syscall.Exec(os.Args[1], os.Args[2:], os.Environ())
+		namespaceHelperFail(127, "failed to execute %s: %v", os.Args[1], err)
+	}
+
+	// The init of a namespace ignores the signals it does not handle, and
+	// the signals sent to the process group also reach the command: handle
+	// them (doing nothing) so the helper survives until the command exits.
+	signal.Notify(make(chan os.Signal, 1),
+		syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	files := make([]uintptr, 3+config.ExtraFiles)
+	for i := range files {
+		files[i] = uintptr(i)
+	}
+	pid, err := syscall.ForkExec(os.Args[1], os.Args[2:], &syscall.ProcAttr{Env: os.Environ(), Files: files})
+	if err != nil {
+		namespaceHelperFail(127, "failed to execute %s: %v", os.Args[1], err)
+	}
+
+	for {
+		var status syscall.WaitStatus
+		wpid, err := syscall.Wait4(-1, &status, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			namespaceHelperFail(126, "failed to wait for %s: %v", os.Args[1], err)
+		}
+		if wpid != pid {
+			// an orphaned process reaped
+			continue
+		}
+		if status.Signaled() {
+			os.Exit(128 + int(status.Signal()))
+		}
+		os.Exit(status.ExitStatus())
+	}
+}
+
+// mountReadOnlyRoot makes all the mounts read-only, except the writable
+// folders (and /dev, for terminals and shared memory). The writable folders
+// are bind mounted over themselves first, so they are separate mounts that
+// can be made writable again.
+func mountReadOnlyRoot(writable []string) error {
+	writable = append([]string{"/dev"}, writable...)
+	for _, folder := range writable {
+		if err := syscall.Mount(folder, folder, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("failed to bind mount %s: %w", folder, err)
+		}
+	}
+	if err := mountSetattr("/", mountAttrRdonly, 0); err != nil {
+		return fmt.Errorf("failed to remount / read-only: %w", err)
+	}
+	for _, folder := range writable {
+		if err := mountSetattr(folder, 0, mountAttrRdonly); err != nil {
+			return fmt.Errorf("failed to remount %s writable: %w", folder, err)
+		}
+	}
+	return nil
+}
+
+// mountSetattr sets and clears the attributes of the mount at path and all
+// the mounts below it (requires Linux 5.12)
+func mountSetattr(path string, set uint64, clear uint64) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	attr := struct {
+		attrSet     uint64
+		attrClr     uint64
+		propagation uint64
+		usernsFd    uint64
+	}{attrSet: set, attrClr: clear}
+
+	dirfd := atFDCWD
+	_, _, errno := syscall.Syscall6(sysMountSetattr, uintptr(dirfd), uintptr(unsafe.Pointer(p)),
+		atRecursive, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package runner
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// isolateNamespaces is only supported on Linux, where namespaces exist
+func isolateNamespaces(cmd *exec.Cmd, setup namespaceSetup) error {
+	if !setup.enabled() {
+		return nil
+	}
+	return fmt.Errorf("namespaces require Linux: %w", ErrNotSupported)
+}
@@ -0,0 +1,301 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNamespaceOptions_validateNamespaces(t *testing.T) {
+	if err := (NamespaceOptions{}).validateNamespaces(); err != nil {
+		t.Errorf("expected no error without namespaces, got %v", err)
+	}
+	err := NamespaceOptions{PrivatePIDs: true}.validateNamespaces()
+	if runtime.GOOS == "linux" && err != nil {
+		t.Errorf("expected private PIDs to be supported, got %v", err)
+	}
+	if runtime.GOOS != "linux" && !errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+func TestLandrunOptions_validateProcAccess(t *testing.T) {
+	o := LandrunOptions{AllowReadFolders: []string{"/usr", "/"}}
+	if err := o.validateProcAccess(); err != nil {
+		t.Errorf("expected no error without private PIDs, got %v", err)
+	}
+	o.PrivatePIDs = true
+	if err := o.validateProcAccess(); err == nil {
+		t.Errorf("expected an error when / is allowed")
+	}
+	o.AllowReadFolders = []string{"/usr", "/proc/self"}
+	if err := o.validateProcAccess(); err != nil {
+		t.Errorf("expected a folder inside /proc to be allowed, got %v", err)
+	}
+}
+
+// runPrivatePIDs runs the script in a new PID namespace, skipping the test
+// when namespaces cannot be created
+func runPrivatePIDs(t *testing.T, script string) string {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("PID namespaces require Linux")
+	}
+
+	r, err := NewExec(Options{"private_pids": true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := r.Run(context.Background(), "sh", script, nil, nil, false)
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("cannot create namespaces: %v", err)
+		}
+		t.Fatal(err)
+	}
+	return output
+}
+
+func TestExec_privatePIDs(t *testing.T) {
+	// the helper is the first process of the namespace
+	output := runPrivatePIDs(t, `tr '\0' ' ' < /proc/1/cmdline; echo; ls -d /proc/[0-9]* | wc -l`)
+	lines := strings.Split(output, "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "runner-namespace-helper") {
+		t.Fatalf("expected the helper to be the first process of the namespace, got %q", output)
+	}
+	// the helper, the shell, ls and wc
+	if n, err := strconv.Atoi(strings.TrimSpace(lines[1])); err != nil || n > 5 {
+		t.Errorf("expected /proc to only show the processes of the namespace, got %s processes", lines[1])
+	}
+}
+
+func TestExec_privatePIDsExitCode(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("PID namespaces require Linux")
+	}
+
+	r, err := NewExec(Options{"private_pids": true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := Start(context.Background(), r, "sh", []string{"-c", "exit 3"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = e.Stdin.Close()
+	var exitErr *exec.ExitError
+	if err := e.Wait(); !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("expected exit code 3, got %v", err)
+	}
+}
+
+func TestExec_privatePIDsLoopback(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("namespaces require Linux")
+	}
+
+	r, err := NewExec(Options{"private_pids": true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := Start(context.Background(), r, "sh", []string{"-c", "tr '\\0' ' ' < /proc/1/cmdline; echo; cat /proc/net/fib_trie"}, nil, nil,
+		WithLoopbackNetwork())
+	if errors.Is(err, os.ErrPermission) {
+		t.Skipf("namespaces are not available: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	go func() { _, _ = io.Copy(io.Discard, e.Stderr) }()
+	out, _ := io.ReadAll(e.Stdout)
+	if err := e.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	if !strings.HasPrefix(string(out), "runner-namespace-helper") || !strings.Contains(string(out), "127.0.0.1") {
+		t.Errorf("expected a PID namespace with the loopback interface up, got:\n%s", out)
+	}
+}
+
+func TestNamespaceOptions_namespaceSetup(t *testing.T) {
+	o := NamespaceOptions{PrivatePIDs: true, ReadOnlyRoot: true}
+
+	setup := o.namespaceSetup(true, []string{"/srv"})
+	expected := namespaceSetup{PrivatePIDs: true, MountProc: true, ReadOnlyRoot: true, Writable: []string{"/srv"}}
+	if !reflect.DeepEqual(setup, expected) {
+		t.Errorf("unexpected setup: %+v", setup)
+	}
+
+	// nothing can be mounted under Landlock
+	setup = o.namespaceSetup(false, []string{"/srv"})
+	if !reflect.DeepEqual(setup, namespaceSetup{PrivatePIDs: true}) {
+		t.Errorf("unexpected setup without mounts: %+v", setup)
+	}
+
+	if (NamespaceOptions{}).namespaceSetup(true, []string{"/srv"}).enabled() {
+		t.Errorf("expected no namespaces by default")
+	}
+}
+
+func TestFirejailOptions_readOnlyRootArgs(t *testing.T) {
+	o := FirejailOptions{AllowWriteFolders: []string{"/srv"}, AllowWriteFiles: []string{"/etc/app.conf"}}
+	if args := o.readOnlyRootArgs(); args != nil {
+		t.Errorf("expected no arguments without read_only_root, got %v", args)
+	}
+
+	o.ReadOnlyRoot = true
+	expected := []string{"--read-only=/", "--read-write=/srv", "--read-write=/etc/app.conf"}
+	if args := o.readOnlyRootArgs(); !reflect.DeepEqual(args, expected) {
+		t.Errorf("unexpected arguments: %v", args)
+	}
+}
+
+func TestExec_readOnlyRoot(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("mount namespaces require Linux")
+	}
+
+	writable := t.TempDir()
+	readOnly := t.TempDir()
+	r, err := NewExec(Options{
+		"read_only_root":      true,
+		"allow_write_folders": []string{"{{.dir}}"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := fmt.Sprintf("touch %s/a && ! touch %s/b 2>/dev/null && echo ok", writable, readOnly)
+	output, err := r.Run(context.Background(), "sh", script, nil, map[string]interface{}{"dir": writable}, false)
+	if errors.Is(err, os.ErrPermission) {
+		t.Skipf("namespaces are not available: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "ok" {
+		t.Errorf("expected only the writable folder to be writable, got %q", output)
+	}
+
+	// the host is not affected
+	if err := os.WriteFile(filepath.Join(readOnly, "c"), nil, 0o600); err != nil {
+		t.Errorf("expected the folder to be writable outside the namespace: %v", err)
+	}
+}
+
+func TestValidateHostname(t *testing.T) {
+	for _, hostname := range []string{"localhost", "sandbox-1", "build.example.com"} {
+		if err := validateHostname(hostname); err != nil {
+			t.Errorf("expected %q to be valid, got %v", hostname, err)
+		}
+	}
+	for _, hostname := range []string{"", "-sandbox", "not a hostname", "a..b", strings.Repeat("a", 65)} {
+		if err := validateHostname(hostname); err == nil {
+			t.Errorf("expected %q to be invalid", hostname)
+		}
+	}
+}
+
+func TestNamespaceOptions_hostname(t *testing.T) {
+	if h := (NamespaceOptions{}).hostname(); h != "" {
+		t.Errorf("expected no hostname by default, got %q", h)
+	}
+	if h := (NamespaceOptions{PrivateUTS: true}).hostname(); h != defaultHostname {
+		t.Errorf("expected the default hostname, got %q", h)
+	}
+	if h := (NamespaceOptions{Hostname: "sandbox"}).hostname(); h != "sandbox" {
+		t.Errorf("expected the hostname to imply a private UTS namespace, got %q", h)
+	}
+}
+
+func TestExec_privateIPCAndUTS(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("namespaces require Linux")
+	}
+	hostIPC, err := os.Readlink("/proc/self/ns/ipc")
+	if err != nil {
+		t.Skipf("cannot read the IPC namespace: %v", err)
+	}
+
+	r, err := NewExec(Options{"private_ipc": true, "hostname": "sandbox"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := r.Run(context.Background(), "sh", "cat /proc/sys/kernel/hostname; readlink /proc/self/ns/ipc", nil, nil, false)
+	if errors.Is(err, os.ErrPermission) {
+		t.Skipf("namespaces are not available: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(output, "\n")
+	if len(lines) != 2 || lines[0] != "sandbox" {
+		t.Fatalf("expected the hostname to be set, got %q", output)
+	}
+	if lines[1] == hostIPC {
+		t.Errorf("expected a new IPC namespace, got the one of the host (%s)", hostIPC)
+	}
+}
+
+func TestFirejail_namespaceArgs(t *testing.T) {
+	r := &Firejail{options: FirejailOptions{
+		NamespaceOptions: NamespaceOptions{PrivateIPC: true, PrivateUTS: true},
+		DisplayOptions:   DisplayOptions{AllowDisplay: true},
+	}}
+	args := r.sessionArgs()
+	if !contains(args, "--ipc-namespace") || !contains(args, "--hostname=localhost") {
+		t.Errorf("expected the IPC and UTS namespaces in the arguments, got %v", args)
+	}
+}
+
+func TestLandrun_readOnlyRootFallback(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("mount namespaces require Linux")
+	}
+	if landlockAvailable() == nil {
+		t.Skip("Landlock is available, so the read-only root is not used (and would restrict the test process)")
+	}
+
+	// /tmp is always writable in Landrun
+	writable := t.TempDir()
+	readOnly, err := os.MkdirTemp(".", "read-only-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(readOnly)
+	readOnly, _ = filepath.Abs(readOnly)
+
+	r, err := NewLandrun(Options{
+		"read_only_root":      true,
+		"allow_read_folders":  []string{"/"},
+		"allow_write_folders": []string{writable},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CheckImplicitRequirements(); err != nil {
+		t.Errorf("expected the read-only root to replace Landlock, got %v", err)
+	}
+
+	script := fmt.Sprintf("touch %s/a && ! touch %s/b 2>/dev/null && echo ok", writable, readOnly)
+	output, err := r.Run(context.Background(), "sh", script, nil, nil, false)
+	if errors.Is(err, os.ErrPermission) {
+		t.Skipf("namespaces are not available: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "ok" {
+		t.Errorf("expected only the writable folder to be writable, got %q", output)
+	}
+}
@@ -0,0 +1,538 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+//go:embed nsjail_config.tpl
+var nsjailConfigTemplate string
+
+// defaultNsjailSystemFolders are the folders of the host mounted read-only
+// in the jail by default, so the shell and the usual tools can run. The
+// missing ones are skipped.
+var defaultNsjailSystemFolders = []string{"/bin", "/etc", "/lib", "/lib32", "/lib64", "/sbin", "/usr"}
+
+// nsjailDevices are the devices of the host available in the jail
+var nsjailDevices = []string{"/dev/full", "/dev/null", "/dev/random", "/dev/tty", "/dev/urandom", "/dev/zero"}
+
+// defaultNsjailSeccompPolicy is the Kafel policy of the jail by default:
+// the system calls reaching the kernel keyrings, other processes, kernel
+// modules and mounts fail with EPERM, and the others are allowed
+const defaultNsjailSeccompPolicy = "ERRNO(1) { add_key, keyctl, request_key, ptrace, process_vm_readv, " +
+	"process_vm_writev, bpf, perf_event_open, userfaultfd, kexec_load, init_module, finit_module, " +
+	"delete_module, mount, umount2, pivot_root, swapon, swapoff, reboot, acct } DEFAULT ALLOW"
+
+// exitCodeNsjail is the exit code of nsjail when it fails to set up the jail
+const exitCodeNsjail = 255
+
+// nsjailExitCodes are the codes of nsjail, which exits with the status of
+// the command
+var nsjailExitCodes = exitCodeTable{
+	exitCodeNsjail:        ErrorKindBackend,
+	exitCodeCannotExecute: ErrorKindPermissionDenied,
+	exitCodeNotFound:      ErrorKindNotFound,
+}
+
+// Nsjail implements the Runner interface using nsjail on Linux.
+//
+// nsjail runs every command in new namespaces (user, mount, PID, IPC, UTS,
+// cgroup and network), with only the folders of the options mounted, resource
+// limits and a seccomp policy. Its configuration is generated from the
+// options for every command.
+type Nsjail struct {
+	logger    Logger
+	configTpl *template.Template
+	options   NsjailOptions
+}
+
+// NsjailOptions is the options for the Nsjail runner
+type NsjailOptions struct {
+	// NsjailPath is the nsjail executable to use (defaults to "nsjail" in PATH)
+	NsjailPath string `json:"nsjail_path"`
+
+	// Shell is the shell running the commands in the jail (defaults to "/bin/sh")
+	Shell string `json:"shell"`
+
+	AllowNetworking   bool     `json:"allow_networking"`
+	AllowReadFolders  []string `json:"allow_read_folders"`
+	AllowWriteFolders []string `json:"allow_write_folders"`
+	AllowReadFiles    []string `json:"allow_read_files"`
+	AllowWriteFiles   []string `json:"allow_write_files"`
+
+	// SystemFolders are the folders of the host mounted read-only, when they
+	// exist (defaults to defaultNsjailSystemFolders)
+	SystemFolders []string `json:"system_folders"`
+
+	// WorkDir is the working directory in the jail
+	WorkDir string `json:"workdir"`
+
+	// Hostname is the hostname in the jail (defaults to "localhost")
+	Hostname string `json:"hostname"`
+
+	// Resource limits of the command. The soft limits of the host are kept
+	// for the limits not set.
+	RlimitAS     uint64 `json:"rlimit_as"`     // Address space, in MiB
+	RlimitCPU    uint64 `json:"rlimit_cpu"`    // CPU time, in seconds
+	RlimitFsize  uint64 `json:"rlimit_fsize"`  // Size of the files written, in MiB
+	RlimitNofile uint64 `json:"rlimit_nofile"` // Open files
+	RlimitNproc  uint64 `json:"rlimit_nproc"`  // Processes of the user
+
+	// SeccompPolicy is the Kafel seccomp policy of the command (defaults to
+	// defaultNsjailSeccompPolicy)
+	SeccompPolicy string `json:"seccomp_policy"`
+
+	// System calls of the command, translated to a Kafel policy (the
+	// alternative to SeccompPolicy)
+	SeccompOptions
+
+	// Umask and file mode policy
+	FileModeOptions
+
+	// Command run in the jail before the commands
+	PreflightOptions
+
+	// Clock and locale settings
+	DeterminismOptions
+
+	// Variables of the commands read from .env files
+	EnvFileOptions
+
+	// CA certificates trusted by the command
+	CABundleOptions
+
+	// Hostname aliases, mounted at /etc/hosts in the jail
+	HostsOptions
+
+	// Timeout of the commands
+	TimeoutOptions
+
+	// Limits of the output of the commands
+	OutputLimitOptions
+}
+
+// NewNsjailOptions creates a new NsjailOptions from Options
+func NewNsjailOptions(options Options) (NsjailOptions, error) {
+	var opts NsjailOptions
+	jsonStr, err := options.ToJSON()
+	if err != nil {
+		return NsjailOptions{}, err
+	}
+	err = json.Unmarshal([]byte(jsonStr), &opts)
+	return opts, err
+}
+
+// NewNsjail creates a new Nsjail runner with the provided logger.
+// If logger is nil, a default logger is created.
+func NewNsjail(options Options, logger Logger) (*Nsjail, error) {
+	logger = defaultLogger(logger)
+
+	// Parse the nsjail configuration template
+	configTpl, err := template.New("nsjail-config").Funcs(template.FuncMap{"quote": strconv.Quote}).
+		Parse(nsjailConfigTemplate)
+	if err != nil {
+		logger.Debug("Failed to parse nsjail configuration template: %v", err)
+		return nil, err
+	}
+
+	nsjailOpts, err := NewNsjailOptions(options)
+	if err != nil {
+		logger.Debug("Failed to parse nsjail options: %v", err)
+		return nil, fmt.Errorf("failed to parse nsjail options: %w", err)
+	}
+	if nsjailOpts.SystemFolders == nil {
+		nsjailOpts.SystemFolders = defaultNsjailSystemFolders
+	}
+	if nsjailOpts.Hostname == "" {
+		nsjailOpts.Hostname = "localhost"
+	}
+	if err := nsjailOpts.loadSeccompProfile(); err != nil {
+		return nil, fmt.Errorf("invalid nsjail options: %w", err)
+	}
+	if nsjailOpts.seccomp != nil {
+		if nsjailOpts.SeccompPolicy != "" {
+			return nil, fmt.Errorf("invalid nsjail options: seccomp_policy and seccomp_profile cannot be combined")
+		}
+		if nsjailOpts.SeccompPolicy, err = nsjailOpts.seccomp.kafel(); err != nil {
+			return nil, fmt.Errorf("invalid nsjail options: %w", err)
+		}
+	}
+	if nsjailOpts.SeccompPolicy == "" {
+		nsjailOpts.SeccompPolicy = defaultNsjailSeccompPolicy
+	}
+	nsjailOpts.normalizeRules()
+	if err := nsjailOpts.validate(); err != nil {
+		return nil, fmt.Errorf("invalid nsjail options: %w", err)
+	}
+	if err := nsjailOpts.resolveFakeTime(); err != nil {
+		return nil, fmt.Errorf("invalid nsjail options: %w", err)
+	}
+	if err := nsjailOpts.validateHosts(); err != nil {
+		return nil, fmt.Errorf("invalid nsjail options: %w", err)
+	}
+	if err := nsjailOpts.validateCABundle(); err != nil {
+		return nil, fmt.Errorf("invalid nsjail options: %w", err)
+	}
+	if err := nsjailOpts.validateTimeouts(); err != nil {
+		return nil, fmt.Errorf("invalid nsjail options: %w", err)
+	}
+	if err := nsjailOpts.loadEnvFiles(); err != nil {
+		return nil, fmt.Errorf("invalid nsjail options: %w", err)
+	}
+	if err := nsjailOpts.validateOutputLimits(); err != nil {
+		return nil, fmt.Errorf("invalid nsjail options: %w", err)
+	}
+	if err := nsjailOpts.validatePreflight(); err != nil {
+		return nil, fmt.Errorf("invalid nsjail options: %w", err)
+	}
+
+	return &Nsjail{
+		logger:    logger,
+		configTpl: configTpl,
+		options:   nsjailOpts,
+	}, nil
+}
+
+// normalizeRules sorts and deduplicates the paths mounted in the jail
+func (o *NsjailOptions) normalizeRules() {
+	o.AllowReadFolders = normalizePaths(o.AllowReadFolders)
+	o.AllowWriteFolders = normalizePaths(o.AllowWriteFolders)
+	o.AllowReadFiles = normalizePaths(o.AllowReadFiles)
+	o.AllowWriteFiles = normalizePaths(o.AllowWriteFiles)
+	o.SystemFolders = normalizePaths(o.SystemFolders)
+}
+
+// nsjailPath returns the nsjail executable to use
+func (r *Nsjail) nsjailPath() string {
+	if r.options.NsjailPath != "" {
+		return r.options.NsjailPath
+	}
+	return "nsjail"
+}
+
+// nsjailRlimit is a resource limit of the configuration of nsjail
+type nsjailRlimit struct {
+	Name  string
+	Value uint64
+}
+
+// nsjailConfig is what the configuration template is rendered with
+type nsjailConfig struct {
+	Hostname        string
+	WorkDir         string
+	AllowNetworking bool
+	Rlimits         []nsjailRlimit
+	Devices         []string
+	SystemFolders   []string
+	ReadOnly        []string
+	Writable        []string
+	HostsFile       string
+	SeccompPolicy   string
+}
+
+// config returns the configuration of the jail of a call: the folders and
+// files of the options with template variables replaced with params, the
+// CA bundle, and the staged inputs, workspace and scratch directories. The
+// mounts are sorted, so the parent folders are mounted before their
+// children. The hosts file, when not empty, is mounted at /etc/hosts.
+func (r *Nsjail) config(params map[string]interface{}, hostsFile string) nsjailConfig {
+	readOnly := common.ProcessTemplateListFlexible(r.options.AllowReadFolders, params)
+	readOnly = append(readOnly, common.ProcessTemplateListFlexible(r.options.AllowReadFiles, params)...)
+	readOnly = withInputsDir(readOnly, params)
+	if r.options.CABundle != "" {
+		readOnly = append(readOnly, r.options.CABundle)
+	}
+	writable := common.ProcessTemplateListFlexible(r.options.AllowWriteFolders, params)
+	writable = append(writable, common.ProcessTemplateListFlexible(r.options.AllowWriteFiles, params)...)
+	writable = withWritableDirs(writable, params)
+
+	config := nsjailConfig{
+		Hostname:        r.options.Hostname,
+		AllowNetworking: r.options.AllowNetworking,
+		Rlimits: []nsjailRlimit{
+			{"as", r.options.RlimitAS},
+			{"cpu", r.options.RlimitCPU},
+			{"fsize", r.options.RlimitFsize},
+			{"nofile", r.options.RlimitNofile},
+			{"nproc", r.options.RlimitNproc},
+		},
+		Devices:       nsjailDevices,
+		SystemFolders: r.options.SystemFolders,
+		ReadOnly:      normalizePaths(readOnly),
+		Writable:      normalizePaths(writable),
+		HostsFile:     hostsFile,
+		SeccompPolicy: r.options.SeccompPolicy,
+	}
+	if r.options.WorkDir != "" {
+		config.WorkDir = common.ProcessTemplateListFlexible([]string{r.options.WorkDir}, params)[0]
+	}
+	return config
+}
+
+// writeConfig writes the configuration of the jail of a call to a temporary
+// file. The caller must remove it.
+func (r *Nsjail) writeConfig(logger Logger, params map[string]interface{}, hostsFile string) (string, error) {
+	var config bytes.Buffer
+	if err := r.configTpl.Execute(&config, r.config(params, hostsFile)); err != nil {
+		return "", fmt.Errorf("failed to render nsjail configuration: %w", err)
+	}
+	logger.Debug("Generated nsjail configuration: %s", config.String())
+
+	configFile, err := os.CreateTemp("", "nsjail-*.cfg")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary nsjail configuration file: %w", err)
+	}
+	if _, err := configFile.Write(config.Bytes()); err != nil {
+		_ = configFile.Close()
+		_ = os.Remove(configFile.Name())
+		return "", fmt.Errorf("failed to write nsjail configuration: %w", err)
+	}
+	if err := configFile.Close(); err != nil {
+		_ = os.Remove(configFile.Name())
+		return "", fmt.Errorf("failed to write nsjail configuration: %w", err)
+	}
+	return configFile.Name(), nil
+}
+
+// prepare writes the hosts file and the configuration of a call, returning
+// the nsjail arguments running argv in the jail and a function removing
+// the files
+func (r *Nsjail) prepare(logger Logger, params map[string]interface{}, argv ...string) ([]string, func(), error) {
+	hostsFile, err := r.options.writeHostsFile("/etc/hosts")
+	if err != nil {
+		return nil, nil, err
+	}
+	remove := func(path string) {
+		if path == "" {
+			return
+		}
+		if err := os.Remove(path); err != nil {
+			logger.Debug("Warning: failed to remove %s: %v", path, err)
+		}
+	}
+
+	configPath, err := r.writeConfig(logger, params, hostsFile)
+	if err != nil {
+		remove(hostsFile)
+		return nil, nil, err
+	}
+	args := append([]string{"--config", configPath, "--"}, argv...)
+	return args, func() {
+		remove(configPath)
+		remove(hostsFile)
+	}, nil
+}
+
+// jailShell returns the shell running the commands in the jail
+func (r *Nsjail) jailShell(shell string) string {
+	if shell != "" {
+		return shell
+	}
+	if r.options.Shell != "" {
+		return r.options.Shell
+	}
+	// the host $SHELL may not be mounted in the jail
+	return "/bin/sh"
+}
+
+// Run executes a command in the jail and returns the output.
+// It implements the Runner interface.
+//
+// Note: tmpfile is ignored, as the temporary directory of the host is not
+// visible in the jail
+func (r *Nsjail) Run(ctx context.Context, shell string, command string,
+	env []string, params map[string]interface{}, tmpfile bool,
+) (string, error) {
+	return r.options.runWithTimeout(ctx, func(ctx context.Context) (string, error) {
+		return r.run(ctx, shell, command, env, params, tmpfile)
+	})
+}
+
+// run is Run, with the context bounded by the timeout options
+func (r *Nsjail) run(ctx context.Context, shell string, command string,
+	env []string, params map[string]interface{}, tmpfile bool,
+) (string, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+	env = r.options.caBundleEnv(env, r.options.CABundle)
+
+	// Check if context is done
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	default:
+		// Continue execution
+	}
+
+	if err := r.options.runPreflight(ctx, logger, "/bin/sh", r.start, params); err != nil {
+		return "", err
+	}
+
+	args, cleanup, err := r.prepare(logger, params, r.jailShell(shell), "-c", command)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	execCmd := commandContext(ctx, r.nsjailPath(), args...)
+	logger.Debug("Created command: %s", execCmd.String())
+
+	// Set environment variables if provided
+	if len(env) > 0 {
+		logger.Debug("Adding %d environment variables to command", len(env))
+		for _, e := range env {
+			categoryLogger(logger, LogCategoryEnvironment).Debug("... adding environment variable: %s", e)
+		}
+		execCmd.Env = append(os.Environ(), env...)
+	}
+
+	applyUmask(logger, execCmd, r.options.Umask)
+
+	// Capture output
+	var stdout, stderr bytes.Buffer
+	execCmd.Stdout, execCmd.Stderr = captureRunOutput(ctx, &stdout, &stderr)
+	var truncated func() error
+	execCmd.Stdout, execCmd.Stderr, truncated = r.options.limitOutput(ctx, execCmd.Stdout, execCmd.Stderr)
+
+	// Run the command
+	logger.Debug("Executing command")
+	defer r.options.normalizeFileModes(logger, common.ProcessTemplateListFlexible(r.options.AllowWriteFolders, params))
+
+	if err := execCmd.Run(); err != nil {
+		// If there's error output, include it in the error
+		if stderr.Len() > 0 {
+			errMsg := strings.TrimSpace(stderr.String())
+			logger.Debug("Command failed with stderr: %s", errMsg)
+			return "", newCommandError(errMsg, err)
+		}
+		logger.Debug("Command failed with error: %v", err)
+		return "", err
+	}
+
+	// Get the output
+	outputStr := strings.TrimSpace(stdout.String())
+
+	logger.Debug("Command executed successfully, output length: %d bytes", len(outputStr))
+	if stderr.Len() > 0 {
+		logger.Debug("Command generated stderr (but no error): %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return outputStr, truncated()
+}
+
+// RunEx executes a command in the jail like Run, returning its exit code and
+// both output streams. It implements the Runner interface.
+func (r *Nsjail) RunEx(ctx context.Context, req RunRequest) (*RunResult, error) {
+	return runEx(ctx, r, req)
+}
+
+// RunWithPipes executes a command in the jail with access to stdin/stdout/stderr pipes.
+// It implements the Runner interface for interactive process communication.
+//
+// The command is resolved in the jail, so cmd must be a path (or a name in
+// the PATH) in the folders mounted in it.
+func (r *Nsjail) RunWithPipes(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (
+	stdin io.WriteCloser,
+	stdout io.ReadCloser,
+	stderr io.ReadCloser,
+	wait func() error,
+	err error,
+) {
+	e, err := r.start(ctx, cmd, args, env, params)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return e.Stdin, e.Stdout, e.Stderr, e.Wait, nil
+}
+
+// start executes a command in the jail and returns an execution handle for it.
+// It is used by RunWithPipes and Start.
+func (r *Nsjail) start(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	return r.options.startWithTimeout(ctx, func(ctx context.Context) (*Execution, error) {
+		return r.startCommand(ctx, cmd, args, env, params)
+	})
+}
+
+// startCommand is start, with the context bounded by the timeout options
+func (r *Nsjail) startCommand(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+	env = r.options.caBundleEnv(env, r.options.CABundle)
+
+	// Check if context is already done
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+		// Continue execution
+	}
+
+	if err := r.options.runPreflight(ctx, logger, "/bin/sh", r.start, params); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("RunWithPipes: executing command in nsjail: %s with args: %v", cmd, args)
+
+	jailArgs, cleanup, err := r.prepare(logger, params, append([]string{cmd}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	execCmd := commandContext(ctx, r.nsjailPath(), jailArgs...)
+
+	// Set environment variables if provided
+	if len(env) > 0 {
+		logger.Debug("Adding %d environment variables to command", len(env))
+		execCmd.Env = append(os.Environ(), env...)
+	}
+	execCmd.ExtraFiles = extraFilesFrom(ctx)
+
+	applyUmask(logger, execCmd, r.options.Umask)
+
+	writeFolders := common.ProcessTemplateListFlexible(r.options.AllowWriteFolders, params)
+	return startProcess(logger, execCmd, func() {
+		cleanup()
+		r.options.normalizeFileModes(logger, writeFolders)
+	})
+}
+
+// CheckImplicitRequirements checks if the runner meets its implicit requirements.
+// Nsjail runner requires Linux and the nsjail executable.
+func (r *Nsjail) CheckImplicitRequirements() error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("nsjail runner requires Linux")
+	}
+
+	if !common.CheckExecutableExists(r.nsjailPath()) {
+		return fmt.Errorf("nsjail executable not found in PATH")
+	}
+
+	return nil
+}
+
+// policyWarnings returns the conflicting paths of the options
+func (r *Nsjail) policyWarnings(context.Context) []PolicyWarning {
+	return r.policyConflicts()
+}
+
+// policyConflicts returns the paths both read-only and writable in the
+// options, where they are writable, as they are mounted last
+func (r *Nsjail) policyConflicts() []PolicyWarning {
+	return pathConflictWarnings(TypeNsjail,
+		map[string][]string{"allow_read_folders": r.options.AllowReadFolders, "allow_read_files": r.options.AllowReadFiles},
+		map[string][]string{"allow_write_folders": r.options.AllowWriteFolders, "allow_write_files": r.options.AllowWriteFiles},
+		false)
+}
@@ -0,0 +1,52 @@
+# nsjail configuration generated by go-restricted-runner
+name: "go-restricted-runner"
+mode: ONCE
+hostname: {{ quote .Hostname }}
+{{- if .WorkDir }}
+cwd: {{ quote .WorkDir }}
+{{- end }}
+
+# the timeout of the commands is enforced by the runner
+time_limit: 0
+keep_env: true
+log_level: ERROR
+
+# Namespaces
+clone_newnet: {{ not .AllowNetworking }}
+clone_newuser: true
+clone_newns: true
+clone_newpid: true
+clone_newipc: true
+clone_newuts: true
+clone_newcgroup: true
+
+# Resource limits (the soft limits of the host when not set)
+{{- range .Rlimits }}
+{{- if .Value }}
+rlimit_{{ .Name }}: {{ .Value }}
+{{- else }}
+rlimit_{{ .Name }}_type: SOFT
+{{- end }}
+{{- end }}
+
+# Filesystem
+mount { dst: "/proc" fstype: "proc" rw: false }
+mount { dst: "/tmp" fstype: "tmpfs" rw: true }
+{{- range .Devices }}
+mount { src: {{ quote . }} dst: {{ quote . }} is_bind: true rw: true mandatory: false }
+{{- end }}
+{{- range .SystemFolders }}
+mount { src: {{ quote . }} dst: {{ quote . }} is_bind: true rw: false mandatory: false }
+{{- end }}
+{{- range .ReadOnly }}
+mount { src: {{ quote . }} dst: {{ quote . }} is_bind: true rw: false }
+{{- end }}
+{{- range .Writable }}
+mount { src: {{ quote . }} dst: {{ quote . }} is_bind: true rw: true }
+{{- end }}
+{{- if .HostsFile }}
+mount { src: {{ quote .HostsFile }} dst: "/etc/hosts" is_bind: true rw: false }
+{{- end }}
+
+# System calls
+seccomp_string: {{ quote .SeccompPolicy }}
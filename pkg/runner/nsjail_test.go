@@ -0,0 +1,132 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewNsjail(t *testing.T) {
+	for _, options := range []Options{
+		{"allow_read_folders": "/usr"},
+		{"extra_hosts": map[string]string{"db": "not an address"}},
+		{"max_output_bytes": -1},
+	} {
+		if _, err := NewNsjail(options, nil); err == nil {
+			t.Errorf("NewNsjail(%v) should fail", options)
+		}
+	}
+
+	r, err := NewNsjail(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewNsjail failed: %v", err)
+	}
+	if r.options.Hostname != "localhost" || r.options.SeccompPolicy != defaultNsjailSeccompPolicy ||
+		len(r.options.SystemFolders) != len(defaultNsjailSystemFolders) {
+		t.Errorf("defaults = %+v", r.options)
+	}
+	r, err = NewNsjail(Options{"system_folders": []string{}}, nil)
+	if err != nil || len(r.options.SystemFolders) != 0 {
+		t.Errorf("NewNsjail() without system folders = %v, %v", r.options.SystemFolders, err)
+	}
+}
+
+func TestNsjail_config(t *testing.T) {
+	r, err := NewNsjail(Options{
+		"allow_read_folders":  []string{"/srv/data", "{{.project}}"},
+		"allow_write_folders": []string{"{{.project}}/build"},
+		"allow_write_files":   []string{"/var/log/tool.log"},
+		"system_folders":      []string{"/usr", "/bin"},
+		"workdir":             "{{.project}}",
+		"rlimit_as":           2048,
+		"rlimit_nofile":       64,
+		"seccomp_policy":      `KILL { ptrace } DEFAULT ALLOW`,
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewNsjail failed: %v", err)
+	}
+
+	var config strings.Builder
+	if err := r.configTpl.Execute(&config, r.config(map[string]interface{}{"project": "/home/me/app"}, "/tmp/hosts")); err != nil {
+		t.Fatalf("failed to render the configuration: %v", err)
+	}
+	for _, want := range []string{
+		"mode: ONCE",
+		`cwd: "/home/me/app"`,
+		"time_limit: 0",
+		"clone_newnet: true",
+		"rlimit_as: 2048",
+		"rlimit_cpu_type: SOFT",
+		"rlimit_nofile: 64",
+		`mount { src: "/bin" dst: "/bin" is_bind: true rw: false mandatory: false }`,
+		`mount { src: "/home/me/app" dst: "/home/me/app" is_bind: true rw: false }`,
+		`mount { src: "/home/me/app/build" dst: "/home/me/app/build" is_bind: true rw: true }`,
+		`mount { src: "/var/log/tool.log" dst: "/var/log/tool.log" is_bind: true rw: true }`,
+		`mount { src: "/tmp/hosts" dst: "/etc/hosts" is_bind: true rw: false }`,
+		`seccomp_string: "KILL { ptrace } DEFAULT ALLOW"`,
+	} {
+		if !strings.Contains(config.String(), want) {
+			t.Errorf("the configuration does not contain %q:\n%s", want, config.String())
+		}
+	}
+	// the read-only mounts are sorted, before the writable ones nested in them
+	if strings.Index(config.String(), `"/home/me/app"`) > strings.Index(config.String(), `"/srv/data"`) ||
+		strings.Index(config.String(), `"/srv/data"`) > strings.Index(config.String(), `"/home/me/app/build"`) {
+		t.Errorf("the mounts are not in order:\n%s", config.String())
+	}
+}
+
+func TestNsjail_Run(t *testing.T) {
+	dir := t.TempDir()
+	// the fake nsjail keeps its configuration, and runs the command after "--"
+	fakeTool(t, "nsjail", `[ "$1" = --config ] || exit 255
+cp "$2" `+filepath.Join(dir, "nsjail.cfg")+`
+shift 3
+exec "$@"
+`)
+	r, err := NewNsjail(Options{"allow_networking": true}, nil)
+	if err != nil {
+		t.Fatalf("NewNsjail failed: %v", err)
+	}
+	if err := r.CheckImplicitRequirements(); err != nil {
+		t.Skipf("nsjail runner not available: %v", err)
+	}
+	ctx := context.Background()
+
+	output, err := r.Run(ctx, "", "echo $GREETING", []string{"GREETING=hello"}, nil, false)
+	if err != nil || output != "hello" {
+		t.Errorf("Run() = %q, %v", output, err)
+	}
+	config, err := os.ReadFile(filepath.Join(dir, "nsjail.cfg"))
+	if err != nil {
+		t.Fatalf("the configuration was not passed to nsjail: %v", err)
+	}
+	if !strings.Contains(string(config), "clone_newnet: false") {
+		t.Errorf("the configuration does not allow networking:\n%s", config)
+	}
+
+	_, err = r.Run(ctx, "", "exit 3", nil, nil, false)
+	if status := NormalizeExit(r, err); status.Code != 3 || status.Kind != ErrorKindFailed {
+		t.Errorf("NormalizeExit() = %+v, want the status of the command", status)
+	}
+	_, err = r.Run(ctx, "", "echo 'cannot set up the jail' >&2; exit 255", nil, nil, false)
+	if status := NormalizeExit(r, err); status.Kind != ErrorKindBackend {
+		t.Errorf("NormalizeExit() = %+v, want a backend failure", status)
+	}
+
+	stdin, stdout, _, wait, err := r.RunWithPipes(ctx, "cat", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("RunWithPipes failed: %v", err)
+	}
+	_, _ = stdin.Write([]byte("piped"))
+	_ = stdin.Close()
+	piped := make([]byte, 5)
+	if _, err := stdout.Read(piped); err != nil || string(piped) != "piped" {
+		t.Errorf("RunWithPipes() output = %q, %v", piped, err)
+	}
+	if err := wait(); err != nil {
+		t.Errorf("wait() = %v", err)
+	}
+}
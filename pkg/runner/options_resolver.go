@@ -0,0 +1,235 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// ErrFloorViolation is returned by the OptionsResolver when a layer sets an
+// option enforced by a floor to a different value
+var ErrFloorViolation = errors.New("option enforced by a floor")
+
+// ListMerge is how a list option of a layer is merged with the same option
+// of the layers below it
+type ListMerge string
+
+const (
+	// ListAppend appends the items of the layer to the items of the layers
+	// below, dropping duplicates (the default)
+	ListAppend ListMerge = "append"
+	// ListReplace replaces the items of the layers below
+	ListReplace ListMerge = "replace"
+)
+
+// OptionsPolicy is the configuration of an OptionsResolver
+type OptionsPolicy struct {
+	// Defaults are the options of all the runners
+	Defaults Options `json:"defaults"`
+
+	// Tenants are the default options of each tenant, overriding the
+	// global defaults
+	Tenants map[string]Options `json:"tenants"`
+
+	// Floors are the options enforced on all the runners: tenants and calls
+	// cannot set them to a different value. The items of list floors are
+	// always in the resolved lists, but other items can be added.
+	Floors Options `json:"floors"`
+
+	// ListMerge is the merge of each list option (default: ListAppend)
+	ListMerge map[string]ListMerge `json:"list_merge"`
+}
+
+// OptionsResolver resolves the options of runners from layers: the global
+// defaults, the defaults of the tenant and the per-call overrides, in
+// increasing order of precedence, with the floors of the platform on top.
+//
+// Scalars and maps of a layer replace the ones of the layers below, lists are
+// merged with their ListMerge, and a nil value removes the option (so the
+// runner default is used). Resolved values are normalized to their JSON
+// types (e.g. []interface{} instead of []string).
+type OptionsResolver struct {
+	mu        sync.RWMutex
+	defaults  Options
+	tenants   map[string]Options
+	floors    Options
+	listMerge map[string]ListMerge
+}
+
+// NewOptionsResolver creates a resolver from a policy, checking that its
+// defaults do not violate its floors
+func NewOptionsResolver(policy OptionsPolicy) (*OptionsResolver, error) {
+	for key, merge := range policy.ListMerge {
+		if merge != ListAppend && merge != ListReplace {
+			return nil, fmt.Errorf("invalid list merge %q of option %s", merge, key)
+		}
+	}
+	floors, err := normalizeOptions(policy.Floors)
+	if err != nil {
+		return nil, fmt.Errorf("invalid floors: %w", err)
+	}
+	r := &OptionsResolver{
+		floors:    floors,
+		tenants:   map[string]Options{},
+		listMerge: policy.ListMerge,
+	}
+	if r.defaults, err = r.checkLayer("defaults", policy.Defaults); err != nil {
+		return nil, err
+	}
+	for tenant, defaults := range policy.Tenants {
+		if err := r.SetTenant(tenant, defaults); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// SetTenant sets the default options of a tenant, replacing the previous
+// ones. An error is returned if they violate the floors.
+func (r *OptionsResolver) SetTenant(tenant string, defaults Options) error {
+	if tenant == "" {
+		return fmt.Errorf("tenant name cannot be empty")
+	}
+	normalized, err := r.checkLayer("tenant "+tenant, defaults)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tenants[tenant] = normalized
+	return nil
+}
+
+// Tenants returns the names of the tenants with defaults, sorted
+func (r *OptionsResolver) Tenants() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tenants := make([]string, 0, len(r.tenants))
+	for tenant := range r.tenants {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// Resolve returns the options of a call of a tenant ("" for none) with the
+// overrides. An error wrapping ErrFloorViolation is returned if the overrides
+// violate the floors, and an error for unknown tenants.
+func (r *OptionsResolver) Resolve(tenant string, overrides Options) (Options, error) {
+	normalized, err := r.checkLayer("overrides", overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	resolved := r.merge(nil, r.defaults)
+	if tenant != "" {
+		defaults, ok := r.tenants[tenant]
+		if !ok {
+			return nil, fmt.Errorf("unknown tenant %q", tenant)
+		}
+		resolved = r.merge(resolved, defaults)
+	}
+	resolved = r.merge(resolved, normalized)
+
+	// apply the floors, with their list items always present
+	for key, floor := range r.floors {
+		items, isList := floor.([]interface{})
+		current, hasList := resolved[key].([]interface{})
+		if isList && hasList {
+			resolved[key] = appendNew(current, items)
+		} else {
+			resolved[key] = floor
+		}
+	}
+	return resolved, nil
+}
+
+// checkLayer normalizes the options of a layer and checks them against the
+// floors
+func (r *OptionsResolver) checkLayer(layer string, options Options) (Options, error) {
+	normalized, err := normalizeOptions(options)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options of %s: %w", layer, err)
+	}
+	for key, value := range normalized {
+		floor, ok := r.floors[key]
+		if !ok {
+			continue
+		}
+		if _, isList := floor.([]interface{}); isList {
+			// lists can only add items (and nil removes them), and the
+			// floor items are added back
+			if _, ok := value.([]interface{}); ok || value == nil {
+				continue
+			}
+		}
+		if !reflect.DeepEqual(value, floor) {
+			return nil, fmt.Errorf("%w: %s cannot set %s to %v (enforced: %v)", ErrFloorViolation, layer, key, value, floor)
+		}
+	}
+	return normalized, nil
+}
+
+// merge merges a layer into the options resolved so far
+func (r *OptionsResolver) merge(resolved Options, layer Options) Options {
+	res := Options{}
+	for key, value := range resolved {
+		res[key] = value
+	}
+	for key, value := range layer {
+		items, isList := value.([]interface{})
+		current, hasList := res[key].([]interface{})
+		switch {
+		case value == nil:
+			delete(res, key)
+		case isList && hasList && r.listMerge[key] != ListReplace:
+			res[key] = appendNew(current, items)
+		default:
+			res[key] = value
+		}
+	}
+	return res
+}
+
+// appendNew returns the items of a list with the items of another one not
+// in it
+func appendNew(list []interface{}, items []interface{}) []interface{} {
+	res := append([]interface{}(nil), list...)
+	for _, item := range items {
+		found := false
+		for _, existing := range res {
+			if reflect.DeepEqual(existing, item) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+// normalizeOptions converts the values of the options to their JSON types, so
+// they can be compared and merged
+func normalizeOptions(options Options) (Options, error) {
+	res := Options{}
+	if len(options) == 0 {
+		return res, nil
+	}
+	data, err := json.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
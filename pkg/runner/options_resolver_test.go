@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func newTestResolver(t *testing.T) *OptionsResolver {
+	t.Helper()
+	r, err := NewOptionsResolver(OptionsPolicy{
+		Defaults: Options{
+			"allow_read_folders": []string{"/usr"},
+			"umask":              "077",
+		},
+		Tenants: map[string]Options{
+			"acme": {
+				"allow_read_folders":  []string{"/srv/acme"},
+				"allow_write_folders": []string{"/srv/acme/out"},
+			},
+		},
+		Floors: Options{
+			"allow_networking":    false,
+			"blocked_credentials": []string{"ssh-agent"},
+		},
+		ListMerge: map[string]ListMerge{"allow_write_folders": ListReplace},
+	})
+	if err != nil {
+		t.Fatalf("NewOptionsResolver failed: %v", err)
+	}
+	return r
+}
+
+func TestOptionsResolver_Resolve(t *testing.T) {
+	r := newTestResolver(t)
+
+	got, err := r.Resolve("acme", Options{
+		"allow_read_folders":  []string{"/tmp", "/usr"},
+		"allow_write_folders": []string{"/tmp/out"},
+		"blocked_credentials": []string{"gpg-agent"},
+		"umask":               nil,
+	})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	want := Options{
+		"allow_read_folders":  []interface{}{"/usr", "/srv/acme", "/tmp"},
+		"allow_write_folders": []interface{}{"/tmp/out"},
+		"allow_networking":    false,
+		"blocked_credentials": []interface{}{"gpg-agent", "ssh-agent"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolve() = %v, want %v", got, want)
+	}
+
+	got, err = r.Resolve("", nil)
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	want = Options{
+		"allow_read_folders":  []interface{}{"/usr"},
+		"umask":               "077",
+		"allow_networking":    false,
+		"blocked_credentials": []interface{}{"ssh-agent"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolve() without a tenant = %v, want %v", got, want)
+	}
+
+	if _, err := r.Resolve("unknown", nil); err == nil {
+		t.Errorf("Resolve() of an unknown tenant should fail")
+	}
+}
+
+func TestOptionsResolver_floors(t *testing.T) {
+	r := newTestResolver(t)
+
+	if _, err := r.Resolve("acme", Options{"allow_networking": true}); !errors.Is(err, ErrFloorViolation) {
+		t.Errorf("Resolve() enabling the network = %v, want ErrFloorViolation", err)
+	}
+	if _, err := r.Resolve("acme", Options{"allow_networking": false, "blocked_credentials": nil}); err != nil {
+		t.Errorf("Resolve() keeping the floors failed: %v", err)
+	}
+	if err := r.SetTenant("evil", Options{"allow_networking": true}); !errors.Is(err, ErrFloorViolation) {
+		t.Errorf("SetTenant() enabling the network = %v, want ErrFloorViolation", err)
+	}
+	if got := r.Tenants(); len(got) != 1 || got[0] != "acme" {
+		t.Errorf("Tenants() = %v", got)
+	}
+
+	_, err := NewOptionsResolver(OptionsPolicy{
+		Defaults: Options{"allow_networking": true},
+		Floors:   Options{"allow_networking": false},
+	})
+	if !errors.Is(err, ErrFloorViolation) {
+		t.Errorf("NewOptionsResolver() with defaults violating the floors = %v", err)
+	}
+	if _, err := NewOptionsResolver(OptionsPolicy{ListMerge: map[string]ListMerge{"x": "prepend"}}); err == nil {
+		t.Errorf("NewOptionsResolver() with an invalid list merge should fail")
+	}
+}
+
+func TestRegistry_resolver(t *testing.T) {
+	reg := NewRegistry(nil)
+	reg.SetResolver(newTestResolver(t))
+
+	err := reg.Register("acme/curl", RunnerConfig{Type: TypeExec, Tenant: "acme", Options: Options{"allow_networking": true}})
+	if !errors.Is(err, ErrFloorViolation) {
+		t.Errorf("Register() violating the floors = %v, want ErrFloorViolation", err)
+	}
+	if err := reg.Register("acme/echo", RunnerConfig{Type: TypeExec, Tenant: "acme"}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if _, err := reg.Get("acme/echo"); err != nil {
+		t.Errorf("Get failed: %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// OptionError is an invalid option found by Options.Validate
+type OptionError struct {
+	// Option is the key of the option
+	Option string
+
+	// Unknown is whether the runner does not know the option, instead of
+	// the value having the wrong type
+	Unknown bool
+
+	// Suggestion is the known option closest to an unknown one, if any is
+	// close enough to be a typo
+	Suggestion string
+
+	// Message describes the problem
+	Message string
+}
+
+// Error implements the error interface
+func (e *OptionError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("option %q: %s: did you mean %q?", e.Option, e.Message, e.Suggestion)
+	}
+	return fmt.Sprintf("option %q: %s", e.Option, e.Message)
+}
+
+// Validate checks the options of a runner type against the struct of its
+// options (e.g. DockerOptions): the keys the runner does not know, which
+// would be ignored, and the values that cannot be converted to the type of
+// their option are rejected. The returned error joins an *OptionError for
+// every invalid option, sorted by key.
+//
+// Only the keys and the types are checked: the values are checked when the
+// runner is created. The options of the types registered without the struct
+// of their options are not checked.
+func (o Options) Validate(runnerType Type) error {
+	optionErrs, err := validateOptions(runnerType, o)
+	if err != nil {
+		return err
+	}
+	errs := make([]error, 0, len(optionErrs))
+	for _, e := range optionErrs {
+		errs = append(errs, e)
+	}
+	return errors.Join(errs...)
+}
+
+// validateOptions returns the invalid options of a runner type
+func validateOptions(runnerType Type, options Options) ([]*OptionError, error) {
+	schema, err := SchemaFor(runnerType)
+	if err != nil {
+		return nil, err
+	}
+	if len(schema.Keys) == 0 {
+		return nil, nil
+	}
+	fields := optionFields(runnerType)
+
+	var errs []*OptionError
+	for _, key := range slices.Sorted(maps.Keys(options)) {
+		if !schema.Has(key) {
+			errs = append(errs, &OptionError{
+				Option:     key,
+				Unknown:    true,
+				Suggestion: closestKey(key, schema.Keys),
+				Message:    fmt.Sprintf("unknown option of the %s runner", runnerType),
+			})
+			continue
+		}
+		t, ok := fields[key]
+		if !ok {
+			continue
+		}
+		if err := checkOptionType(options[key], t); err != nil {
+			errs = append(errs, &OptionError{Option: key, Message: err.Error()})
+		}
+	}
+	return errs, nil
+}
+
+// optionFields returns the types of the options of a runner type, by key,
+// including the options version and the profile
+func optionFields(runnerType Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{
+		OptionsVersionKey: reflect.TypeOf(0),
+		ProfileKey:        reflect.TypeOf(""),
+	}
+	opts, ok := optionStructs[runnerType]
+	if !ok {
+		backend, _ := registeredBackend(runnerType)
+		opts = backend.Options
+	}
+	t := reflect.TypeOf(opts)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	addOptionFields(fields, t)
+	return fields
+}
+
+// addOptionFields adds the types of the fields of a struct by JSON key,
+// including those of its embedded structs, like appendJSONKeys
+func addOptionFields(fields map[string]reflect.Type, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			addOptionFields(fields, field.Type)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = field.Type
+	}
+}
+
+// checkOptionType checks that a value can be converted to the type of its
+// option, the way the runners convert the options (through JSON)
+func checkOptionType(value interface{}, t reflect.Type) error {
+	if value == nil {
+		return nil
+	}
+	// report the invalid items of the lists
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Slice && t.Kind() == reflect.Slice {
+		for i := 0; i < v.Len(); i++ {
+			if err := checkOptionType(v.Index(i).Interface(), t.Elem()); err != nil {
+				return fmt.Errorf("must be %s: item %d %w", describeType(t), i+1, err)
+			}
+		}
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("invalid value: %w", err)
+	}
+	if err := json.Unmarshal(data, reflect.New(t).Interface()); err != nil {
+		return fmt.Errorf("must be %s, not %s", describeType(t), describeValue(value))
+	}
+	return nil
+}
+
+// describeType returns the description of the type of an option, as written
+// in a configuration
+func describeType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return describeType(t.Elem())
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "a list of " + strings.TrimPrefix(strings.TrimPrefix(describeType(t.Elem())+"s", "a "), "an ")
+	case reflect.Map:
+		return "a map of " + strings.TrimPrefix(strings.TrimPrefix(describeType(t.Elem())+"s", "a "), "an ")
+	case reflect.Struct:
+		return "an object"
+	}
+	return "a " + t.String()
+}
+
+// describeValue returns the description of the type of a value
+func describeValue(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return fmt.Sprintf("the string %q", value)
+	case bool:
+		return fmt.Sprintf("the boolean %v", value)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return fmt.Sprintf("the number %v", value)
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// closestKey returns the key most similar to an unknown one, if any is close
+// enough to be a typo
+func closestKey(key string, keys []string) string {
+	best, bestDistance := "", len(key)/3+1
+	for _, k := range keys {
+		if d := editDistance(key, k); d <= bestDistance && (best == "" || d < bestDistance) {
+			best, bestDistance = k, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name       string
+		runner     Type
+		options    Options
+		wantErrors []string
+	}{
+		{
+			name:   "valid",
+			runner: TypeDocker,
+			options: Options{
+				"image":            "alpine",
+				"mounts":           []string{"/data:/data:ro"},
+				"allow_networking": false,
+				"blkio_weight":     100,
+				"network_shaping":  map[string]interface{}{"delay": "100ms"},
+				"experimental":     []Feature{"x"},
+				"options_version":  1,
+			},
+		},
+		{
+			name:       "unknown keys",
+			runner:     TypeFirejail,
+			options:    Options{"allow_write_folder": []string{"/tmp"}, "frobnicate": true},
+			wantErrors: []string{`option "allow_write_folder": unknown option of the firejail runner: did you mean "allow_write_folders"?`, `option "frobnicate": unknown option of the firejail runner`},
+		},
+		{
+			name:    "wrong types",
+			runner:  TypeDocker,
+			options: Options{"image": "alpine", "mounts": "/data:/data", "cap_add": []interface{}{"NET_ADMIN", 1}, "allow_networking": "no"},
+			wantErrors: []string{
+				`option "allow_networking": must be a boolean, not the string "no"`,
+				`option "cap_add": must be a list of strings: item 2 must be a string, not the number 1`,
+				`option "mounts": must be a list of strings, not the string "/data:/data"`,
+			},
+		},
+		{
+			name:       "wrong integer",
+			runner:     TypeExec,
+			options:    Options{"options_version": "1"},
+			wantErrors: []string{`option "options_version": must be an integer, not the string "1"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.options.Validate(tt.runner)
+			if len(tt.wantErrors) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Validate() should fail")
+			}
+			if got := strings.Split(err.Error(), "\n"); strings.Join(got, "|") != strings.Join(tt.wantErrors, "|") {
+				t.Errorf("Validate() errors = %q, want %q", got, tt.wantErrors)
+			}
+			var optionErr *OptionError
+			if !errors.As(err, &optionErr) {
+				t.Errorf("Validate() error = %v, want an *OptionError", err)
+			}
+		})
+	}
+
+	if err := (Options{}).Validate("nonexistent"); err == nil {
+		t.Error("Validate() should fail for an unknown runner type")
+	}
+}
+
+func TestNewDockerOptions_typedValues(t *testing.T) {
+	// typed lists were ignored when only []interface{} was accepted
+	opts, err := NewDockerOptions(Options{
+		"image":   "alpine",
+		"mounts":  []string{"/data:/data:ro"},
+		"cap_add": []string{"NET_ADMIN"},
+		"dns":     []string{"1.1.1.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.Mounts) != 1 || len(opts.CapAdd) != 1 || len(opts.DNS) != 1 {
+		t.Errorf("options = %+v, want the typed lists", opts)
+	}
+}
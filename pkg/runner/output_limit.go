@@ -0,0 +1,148 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrOutputTruncated is returned (wrapped) by Run, with the output kept, when
+// the output of a successful command exceeded the output limits of the
+// runner
+var ErrOutputTruncated = errors.New("command output truncated")
+
+// OutputLimitOptions cap the output of the commands kept in memory, so
+// runaway commands cannot exhaust it. The output beyond the limits is
+// discarded (and not streamed, see RunRequest), while the command keeps
+// running.
+type OutputLimitOptions struct {
+	// MaxOutputBytes is the maximum size of the stdout and of the stderr of
+	// a command, each (0 for no limit)
+	MaxOutputBytes int `json:"max_output_bytes"`
+
+	// MaxOutputLines is the maximum number of lines of the stdout and of the
+	// stderr of a command, each (0 for no limit)
+	MaxOutputLines int `json:"max_output_lines"`
+}
+
+// validateOutputLimits checks the output limits
+func (o OutputLimitOptions) validateOutputLimits() error {
+	if o.MaxOutputBytes < 0 {
+		return fmt.Errorf("invalid max_output_bytes %d: must not be negative", o.MaxOutputBytes)
+	}
+	if o.MaxOutputLines < 0 {
+		return fmt.Errorf("invalid max_output_lines %d: must not be negative", o.MaxOutputLines)
+	}
+	return nil
+}
+
+// limited returns whether the output is limited
+func (o OutputLimitOptions) limited() bool {
+	return o.MaxOutputBytes > 0 || o.MaxOutputLines > 0
+}
+
+// outputLimitOptions returns the options of the limits, for the runners
+// running their commands with the Exec runner
+func (o OutputLimitOptions) outputLimitOptions() Options {
+	options := Options{}
+	if o.MaxOutputBytes > 0 {
+		options["max_output_bytes"] = o.MaxOutputBytes
+	}
+	if o.MaxOutputLines > 0 {
+		options["max_output_lines"] = o.MaxOutputLines
+	}
+	return options
+}
+
+// limitOutput returns the writers the output of a command must be written
+// to, discarding the output beyond the limits, and the function returning
+// ErrOutputTruncated (wrapped) once some output was discarded
+func (o OutputLimitOptions) limitOutput(ctx context.Context, stdout io.Writer, stderr io.Writer) (io.Writer, io.Writer, func() error) {
+	if !o.limited() {
+		return stdout, stderr, func() error { return nil }
+	}
+	stdoutLimit := &limitedWriter{w: stdout, ctx: ctx, maxBytes: o.MaxOutputBytes, maxLines: o.MaxOutputLines}
+	stderrLimit := &limitedWriter{w: stderr, ctx: ctx, maxBytes: o.MaxOutputBytes, maxLines: o.MaxOutputLines}
+	truncated := func() error {
+		if !stdoutLimit.isTruncated() && !stderrLimit.isTruncated() {
+			return nil
+		}
+		return fmt.Errorf("%w: the output exceeded %s", ErrOutputTruncated, o.describe())
+	}
+	return stdoutLimit, stderrLimit, truncated
+}
+
+// describe returns the limits, for errors
+func (o OutputLimitOptions) describe() string {
+	switch {
+	case o.MaxOutputBytes > 0 && o.MaxOutputLines > 0:
+		return fmt.Sprintf("%d bytes or %d lines", o.MaxOutputBytes, o.MaxOutputLines)
+	case o.MaxOutputBytes > 0:
+		return fmt.Sprintf("%d bytes", o.MaxOutputBytes)
+	}
+	return fmt.Sprintf("%d lines", o.MaxOutputLines)
+}
+
+// limitedWriter writes to w until the limits are reached, discarding the
+// rest, so the command writing to it is not interrupted
+type limitedWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	ctx      context.Context
+	maxBytes int
+	maxLines int
+
+	written   int
+	lines     int
+	truncated bool
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := len(p)
+	if l.truncated {
+		return n, nil
+	}
+
+	keep := p
+	if l.maxBytes > 0 && l.written+len(keep) > l.maxBytes {
+		keep = keep[:l.maxBytes-l.written]
+	}
+	if l.maxLines > 0 {
+		if l.lines >= l.maxLines {
+			keep = nil
+		}
+		for i, remaining := 0, l.maxLines-l.lines; i < len(keep); i++ {
+			if keep[i] == '\n' {
+				if remaining--; remaining == 0 {
+					keep = keep[:i+1]
+					break
+				}
+			}
+		}
+		l.lines += bytes.Count(keep, []byte("\n"))
+	}
+	l.written += len(keep)
+
+	if len(keep) > 0 {
+		if _, err := l.w.Write(keep); err != nil {
+			return 0, err
+		}
+	}
+	if len(keep) < len(p) {
+		l.truncated = true
+		markRunOutputTruncated(l.ctx)
+	}
+	return n, nil
+}
+
+// isTruncated returns whether some output was discarded
+func (l *limitedWriter) isTruncated() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.truncated
+}
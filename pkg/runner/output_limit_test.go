@@ -0,0 +1,98 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestLimitedWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits OutputLimitOptions
+		chunks []string
+		want   string
+	}{
+		{"bytes", OutputLimitOptions{MaxOutputBytes: 5}, []string{"abc", "defg", "h"}, "abcde"},
+		{"lines", OutputLimitOptions{MaxOutputLines: 2}, []string{"one\ntw", "o\nthree\n", "four"}, "one\ntwo\n"},
+		{"both", OutputLimitOptions{MaxOutputBytes: 6, MaxOutputLines: 2}, []string{"one\ntwo\n"}, "one\ntw"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			w, _, truncated := tt.limits.limitOutput(context.Background(), &stdout, &stderr)
+			for _, chunk := range tt.chunks {
+				// the command keeps running after the limits
+				if n, err := w.Write([]byte(chunk)); n != len(chunk) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+				}
+			}
+			if stdout.String() != tt.want {
+				t.Errorf("output = %q, want %q", stdout.String(), tt.want)
+			}
+			if err := truncated(); !errors.Is(err, ErrOutputTruncated) {
+				t.Errorf("truncated() = %v, want ErrOutputTruncated", err)
+			}
+		})
+	}
+
+	var stdout bytes.Buffer
+	w, _, truncated := OutputLimitOptions{MaxOutputLines: 1}.limitOutput(context.Background(), &stdout, &stdout)
+	_, _ = w.Write([]byte("exactly one line\n"))
+	if err := truncated(); err != nil {
+		t.Errorf("truncated() of output within the limits = %v", err)
+	}
+}
+
+func TestNewExec_OutputLimits(t *testing.T) {
+	if _, err := NewExec(Options{"max_output_bytes": -1}, nil); err == nil {
+		t.Errorf("NewExec() with a negative max_output_bytes should fail")
+	}
+
+	opts, err := NewDockerOptions(Options{"image": "alpine:latest", "max_output_bytes": float64(1024), "max_output_lines": 10})
+	if err != nil {
+		t.Fatalf("NewDockerOptions failed: %v", err)
+	}
+	if opts.MaxOutputBytes != 1024 || opts.MaxOutputLines != 10 {
+		t.Errorf("limits = %d bytes, %d lines", opts.MaxOutputBytes, opts.MaxOutputLines)
+	}
+	if _, err := NewDockerOptions(Options{"image": "alpine:latest", "max_output_lines": -5}); err == nil {
+		t.Errorf("NewDockerOptions() with a negative max_output_lines should fail")
+	}
+}
+
+func TestExec_OutputLimits(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test uses a POSIX shell")
+	}
+	r, err := NewExec(Options{"max_output_lines": 3}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	ctx := context.Background()
+
+	// the command runs to completion, and the output is cut
+	output, err := r.Run(ctx, "sh", "seq 1 100000; echo done >&2", nil, nil, false)
+	if !errors.Is(err, ErrOutputTruncated) {
+		t.Fatalf("Run() = %v, want ErrOutputTruncated", err)
+	}
+	if output != "1\n2\n3" {
+		t.Errorf("output = %q", output)
+	}
+
+	result, err := r.RunEx(ctx, RunRequest{Shell: "sh", Command: "seq 1 10; exit 3"})
+	if err != nil {
+		t.Fatalf("RunEx failed: %v", err)
+	}
+	if !result.Truncated || result.ExitCode != 3 || strings.Count(string(result.Stdout), "\n") != 3 {
+		t.Errorf("RunEx() = exit code %d, truncated %v, stdout %q", result.ExitCode, result.Truncated, result.Stdout)
+	}
+
+	result, err = r.RunEx(ctx, RunRequest{Shell: "sh", Command: "echo short"})
+	if err != nil || result.Truncated {
+		t.Errorf("RunEx() within the limits = %v, %v", result, err)
+	}
+}
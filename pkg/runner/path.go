@@ -0,0 +1,180 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// PathOptions control the executables found and run by the commands
+type PathOptions struct {
+	// ExtraPath are folders searched for the commands, and the tools of the
+	// runner (e.g. firejail), after the PATH. They are appended to the PATH
+	// of the commands.
+	ExtraPath []string `json:"extra_path"`
+
+	// HermeticTools replaces the PATH of the commands with a folder of links
+	// to these executables (names searched in the PATH and ExtraPath, or
+	// absolute paths), created for every command and removed once it
+	// completes. Commands then only find the tools enumerated.
+	HermeticTools []string `json:"hermetic_tools"`
+
+	// PinnedExecutables are the SHA-256 of executables (names or absolute
+	// paths), verified before running the commands referencing them
+	PinnedExecutables map[string]string `json:"pinned_executables"`
+}
+
+// validateExtraPath checks the extra folders are absolute, and the hermetic
+// tools are names or absolute paths with different names
+func (o PathOptions) validateExtraPath() error {
+	for _, folder := range o.ExtraPath {
+		if !filepath.IsAbs(folder) {
+			return fmt.Errorf("extra_path folder %q must be absolute", folder)
+		}
+	}
+	names := map[string]bool{}
+	for _, tool := range o.HermeticTools {
+		if !filepath.IsAbs(tool) && strings.ContainsAny(tool, `/\`) {
+			return fmt.Errorf("hermetic tool %q must be a name or an absolute path", tool)
+		}
+		name := filepath.Base(tool)
+		if tool == "" || names[name] {
+			return fmt.Errorf("hermetic tool %q is empty or repeated", tool)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// hermetic returns whether the PATH of the commands is replaced with the
+// hermetic tools
+func (o PathOptions) hermetic() bool {
+	return len(o.HermeticTools) > 0
+}
+
+// hermeticToolFiles returns the executables of the hermetic tools (or their
+// names, when they are not found), for the fingerprint of the runner
+func (o PathOptions) hermeticToolFiles() []string {
+	files := make([]string, 0, len(o.HermeticTools))
+	for _, tool := range o.HermeticTools {
+		if path, err := common.ResolveExecutable(tool, o.ExtraPath...); err == nil {
+			tool = path
+		}
+		files = append(files, tool)
+	}
+	return files
+}
+
+// directCommand returns whether a command can be run directly, without a
+// shell. Hermetic commands always run in a shell, so they are searched in
+// the hermetic tools.
+func (o PathOptions) directCommand(command string) bool {
+	return !o.hermetic() && isSingleExecutableCommand(command, o.ExtraPath...)
+}
+
+// linkHermeticTools creates a folder with links to the hermetic tools,
+// returning it (or "" without hermetic tools) and a function removing it
+func (o PathOptions) linkHermeticTools(logger Logger) (string, func(), error) {
+	if !o.hermetic() {
+		return "", func() {}, nil
+	}
+	dir, err := os.MkdirTemp("", "runner-tools-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create the hermetic tools folder: %w", err)
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Debug("Warning: failed to remove the hermetic tools folder %s: %v", dir, err)
+		}
+	}
+
+	for _, tool := range o.HermeticTools {
+		path, err := common.ResolveExecutable(tool, o.ExtraPath...)
+		if err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("hermetic tool %q: %w", tool, err)
+		}
+		link := filepath.Join(dir, filepath.Base(path))
+		if err := os.Symlink(path, link); err != nil {
+			// creating symbolic links may require privileges on Windows
+			if err := os.Link(path, link); err != nil {
+				cleanup()
+				return "", nil, fmt.Errorf("failed to link the hermetic tool %s: %w", path, err)
+			}
+		}
+		logger.Debug("Hermetic tool %s linked to %s", path, link)
+	}
+	return dir, cleanup, nil
+}
+
+// hermeticCommand returns the link to a command started directly, which must
+// be one of the hermetic tools (except for the sandbox canaries, see
+// CanaryOptions)
+func (o PathOptions) hermeticCommand(ctx context.Context, dir string, cmd string) (string, error) {
+	if dir == "" || ctx.Value(canaryRunKey{}) != nil {
+		return o.lookPath(cmd), nil
+	}
+	if filepath.Base(cmd) == cmd {
+		if path, err := common.ResolveExecutable(filepath.Join(dir, cmd)); err == nil {
+			return path, nil
+		}
+	} else if cmdInfo, err := os.Stat(cmd); err == nil && filepath.IsAbs(cmd) {
+		links, _ := os.ReadDir(dir)
+		for _, link := range links {
+			if info, err := os.Stat(filepath.Join(dir, link.Name())); err == nil && os.SameFile(cmdInfo, info) {
+				return filepath.Join(dir, link.Name()), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("command %q is not one of the hermetic_tools: %w", cmd, ErrPermissionDenied)
+}
+
+// lookPath returns the absolute path of an executable found in the PATH or
+// the extra folders, or the name unchanged when it is not found
+func (o PathOptions) lookPath(name string) string {
+	if len(o.ExtraPath) == 0 {
+		return name
+	}
+	if path, err := common.ResolveExecutable(name, o.ExtraPath...); err == nil {
+		return path
+	}
+	return name
+}
+
+// pathEnv appends the extra folders to the PATH of env, or of the current
+// process when env does not set it
+func (o PathOptions) pathEnv(env []string) []string {
+	if len(o.ExtraPath) == 0 {
+		return env
+	}
+	path := os.Getenv("PATH")
+	for _, e := range env {
+		if name, value, ok := strings.Cut(e, "="); ok && envNameEqual(name, "PATH") {
+			path = value
+		}
+	}
+	folders := append(filepath.SplitList(path), o.ExtraPath...)
+	return append(env, "PATH="+strings.Join(folders, string(filepath.ListSeparator)))
+}
+
+// hermeticEnv replaces the PATH of env with the hermetic tools folder
+func hermeticEnv(env []string, dir string) []string {
+	if dir == "" {
+		return env
+	}
+	return append(env, "PATH="+dir)
+}
+
+// envNameEqual returns whether two environment variable names are the same,
+// ignoring the case on Windows
+func envNameEqual(a string, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
@@ -0,0 +1,141 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPathOptions_pathEnv(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin")
+	sep := string(filepath.ListSeparator)
+
+	o := PathOptions{ExtraPath: []string{"/opt/tools/bin"}}
+	if got := o.pathEnv([]string{"A=1"}); strings.Join(got, " ") != "A=1 PATH=/usr/bin"+sep+"/opt/tools/bin" {
+		t.Errorf("pathEnv() = %v, want the PATH of the process extended", got)
+	}
+	if got := o.pathEnv([]string{"PATH=/bin"}); got[len(got)-1] != "PATH=/bin"+sep+"/opt/tools/bin" {
+		t.Errorf("pathEnv() = %v, want the PATH of env extended", got)
+	}
+	if got := (PathOptions{}).pathEnv(nil); got != nil {
+		t.Errorf("pathEnv() = %v, want nil without extra folders", got)
+	}
+}
+
+func TestExec_extraPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+
+	extra := t.TempDir()
+	if err := os.WriteFile(filepath.Join(extra, "extra-tool"), []byte("#!/bin/sh\necho extra $1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewExec(Options{"extra_path": []string{"bin"}}, nil); err == nil {
+		t.Errorf("NewExec should fail with a relative extra_path")
+	}
+	r, err := NewExec(Options{"extra_path": []string{extra}}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+
+	// single executables are run directly
+	for _, command := range []string{"extra-tool", "extra-tool run"} {
+		output, err := r.Run(context.Background(), "", command, nil, nil, false)
+		if err != nil {
+			t.Fatalf("Run(%q) failed: %v", command, err)
+		}
+		if !strings.HasPrefix(output, "extra") {
+			t.Errorf("Run(%q) = %q, want the output of the extra tool", command, output)
+		}
+	}
+
+	stdin, stdout, _, wait, err := r.RunWithPipes(context.Background(), "extra-tool", []string{"piped"}, nil, nil)
+	if err != nil {
+		t.Fatalf("RunWithPipes failed: %v", err)
+	}
+	_ = stdin.Close()
+	output, _ := io.ReadAll(stdout)
+	if err := wait(); err != nil {
+		t.Fatalf("wait failed: %v", err)
+	}
+	if strings.TrimSpace(string(output)) != "extra piped" {
+		t.Errorf("RunWithPipes() output = %q, want the output of the extra tool", output)
+	}
+}
+
+func TestPathOptions_validateExtraPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    PathOptions
+		wantErr bool
+	}{
+		{name: "empty", opts: PathOptions{}},
+		{name: "tools", opts: PathOptions{HermeticTools: []string{"git", "/usr/bin/make"}}},
+		{name: "relative path", opts: PathOptions{HermeticTools: []string{"bin/git"}}, wantErr: true},
+		{name: "repeated name", opts: PathOptions{HermeticTools: []string{"git", "/opt/bin/git"}}, wantErr: true},
+		{name: "relative extra path", opts: PathOptions{ExtraPath: []string{"bin"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.validateExtraPath()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateExtraPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExec_hermeticTools(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+
+	r, err := NewExec(Options{"hermetic_tools": []string{"echo", "cat"}}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	output, err := r.Run(context.Background(), "", "ls /", nil, nil, false)
+	if err == nil {
+		t.Errorf("Run() = %q, want ls not found", output)
+	}
+
+	output, err = r.Run(context.Background(), "", "echo $PATH", nil, nil, false)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	dir := output
+	if !strings.Contains(filepath.Base(dir), "runner-tools-") {
+		t.Fatalf("PATH = %q, want the hermetic tools folder", dir)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("the hermetic tools folder %s was not removed", dir)
+	}
+
+	e, err := r.start(context.Background(), "cat", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("start(cat) failed: %v", err)
+	}
+	_ = e.Stdin.Close()
+	_, _ = io.ReadAll(e.Stdout)
+	_ = e.Wait()
+
+	if _, err := r.start(context.Background(), "ls", nil, nil, nil); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("start(ls) error = %v, want ErrPermissionDenied", err)
+	}
+
+	// the tools are resolved when the commands run
+	missing, err := NewExec(Options{"hermetic_tools": []string{"no-such-tool"}}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	if _, err := missing.Run(context.Background(), "", "true", nil, nil, false); err == nil {
+		t.Errorf("Run() should fail with a missing hermetic tool")
+	}
+}
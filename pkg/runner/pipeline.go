@@ -0,0 +1,219 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrStepFailed is returned by RunPipeline when a step exits with a code
+// that does not allow the pipeline to continue
+var ErrStepFailed = errors.New("pipeline step failed")
+
+// PipelineStep is a command of a pipeline, run with its own runner
+type PipelineStep struct {
+	// Name identifies the step, so later steps can use its output
+	Name string
+
+	// Runner is the runner used to execute the command
+	Runner Runner
+
+	// Cmd, Args, Env and Params are passed to Start
+	Cmd    string
+	Args   []string
+	Env    []string
+	Params map[string]interface{}
+
+	// Stdin is the name of a previous step whose standard output is written
+	// to the standard input of the command
+	Stdin string
+
+	// Inputs are files staged for the command (see WithInputFiles), keyed by
+	// their name in the staging directory. The values are the name of a
+	// previous step, for its standard output, or "step:name" for the
+	// artifact of a previous step with that base name.
+	Inputs map[string]string
+
+	// Artifacts are collected after the command, for the later steps
+	Artifacts []ArtifactSpec
+
+	// ContinueOn are the exit codes that let the pipeline continue
+	// (only 0 by default)
+	ContinueOn []int
+
+	// Options are additional options for the execution
+	Options []ExecOption
+}
+
+// StepResult is the result of a step of a pipeline
+type StepResult struct {
+	// Name is the name of the step
+	Name string
+
+	// ExecutionID is the ID of the execution of the step
+	ExecutionID string
+
+	// ExitCode is the exit code of the command, or -1 if unknown
+	ExitCode int
+
+	// Err is the error of the execution of the step, if any
+	Err error
+
+	// Stdout and Stderr are the output of the command
+	Stdout []byte
+	Stderr []byte
+
+	// Artifacts are the artifacts collected after the command
+	Artifacts []Artifact
+
+	// Duration is how long the step took
+	Duration time.Duration
+}
+
+// RunPipeline runs the steps in order, passing data between them through
+// their standard input and output, or staged files. Every step runs with
+// the restrictions of its own runner.
+//
+// The pipeline stops at the first step exiting with a code not in its
+// ContinueOn list, returning an error wrapping ErrStepFailed along with the
+// results of the steps run so far.
+func RunPipeline(ctx context.Context, steps []PipelineStep) ([]StepResult, error) {
+	if err := validatePipeline(steps); err != nil {
+		return nil, err
+	}
+
+	var results []StepResult
+	byName := map[string]*StepResult{}
+	for _, step := range steps {
+		result := runStep(ctx, step, byName)
+		results = append(results, result)
+		byName[step.Name] = &result
+
+		continueOn := step.ContinueOn
+		if len(continueOn) == 0 {
+			continueOn = []int{0}
+		}
+		if result.ExitCode < 0 || !slices.Contains(continueOn, result.ExitCode) {
+			return results, fmt.Errorf("%w: %s: %v", ErrStepFailed, step.Name, result.Err)
+		}
+	}
+	return results, nil
+}
+
+// validatePipeline checks that the steps only refer to previous steps
+func validatePipeline(steps []PipelineStep) error {
+	seen := map[string]bool{}
+	for i, step := range steps {
+		if step.Name == "" {
+			return fmt.Errorf("pipeline step %d requires a name", i)
+		}
+		if seen[step.Name] {
+			return fmt.Errorf("duplicate pipeline step %q", step.Name)
+		}
+		if step.Runner == nil {
+			return fmt.Errorf("pipeline step %q requires a runner", step.Name)
+		}
+		if step.Stdin != "" && !seen[step.Stdin] {
+			return fmt.Errorf("pipeline step %q reads the output of %q, which is not a previous step", step.Name, step.Stdin)
+		}
+		for name, ref := range step.Inputs {
+			from, _, _ := strings.Cut(ref, ":")
+			if !seen[from] {
+				return fmt.Errorf("input %q of pipeline step %q refers to %q, which is not a previous step", name, step.Name, from)
+			}
+		}
+		seen[step.Name] = true
+	}
+	return nil
+}
+
+// runStep runs a step with the outputs of the previous ones
+func runStep(ctx context.Context, step PipelineStep, previous map[string]*StepResult) StepResult {
+	start := time.Now()
+	result := StepResult{Name: step.Name, ExitCode: -1}
+	fail := func(err error) StepResult {
+		result.Err = err
+		result.Duration = time.Since(start)
+		return result
+	}
+
+	opts := append([]ExecOption{}, step.Options...)
+	if len(step.Inputs) > 0 {
+		files := map[string][]byte{}
+		paths := map[string]string{}
+		for name, ref := range step.Inputs {
+			from, artifact, isArtifact := strings.Cut(ref, ":")
+			if !isArtifact {
+				files[name] = previous[from].Stdout
+				continue
+			}
+			path, err := artifactPath(previous[from], artifact)
+			if err != nil {
+				return fail(err)
+			}
+			paths[name] = path
+		}
+		opts = append(opts, WithInputFiles(files), WithInputPaths(paths))
+	}
+	if len(step.Artifacts) > 0 {
+		opts = append(opts, WithArtifacts(step.Artifacts...))
+	}
+
+	e, err := Start(ctx, step.Runner, step.Cmd, step.Args, step.Env, step.Params, opts...)
+	if err != nil {
+		return fail(err)
+	}
+	result.ExecutionID = e.ID
+
+	var stdout, stderr bytes.Buffer
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		if step.Stdin != "" {
+			_, _ = e.Stdin.Write(previous[step.Stdin].Stdout)
+		}
+		_ = e.Stdin.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(&stdout, e.Stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(&stderr, e.Stderr)
+	}()
+	wg.Wait()
+
+	result.Err = e.Wait()
+	result.ExitCode = exitCode(result.Err)
+	result.Stdout = stdout.Bytes()
+	result.Stderr = stderr.Bytes()
+	result.Duration = time.Since(start)
+
+	artifacts, err := e.Artifacts()
+	result.Artifacts = artifacts
+	if err != nil && result.Err == nil {
+		// missing artifacts stop the pipeline, as later steps may need them
+		result.Err = err
+		result.ExitCode = -1
+	}
+	return result
+}
+
+// artifactPath returns the host path of the artifact of a step with the given base name
+func artifactPath(result *StepResult, name string) (string, error) {
+	for _, a := range result.Artifacts {
+		if filepath.Base(a.Path) == name {
+			return a.LocalPath, nil
+		}
+	}
+	return "", fmt.Errorf("%w: step %s has no artifact %q", ErrArtifactNotFound, result.Name, name)
+}
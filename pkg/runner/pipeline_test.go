@@ -0,0 +1,97 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunPipeline(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	results, err := RunPipeline(context.Background(), []PipelineStep{
+		{
+			Name:   "fetch",
+			Runner: r,
+			Cmd:    "sh",
+			Args:   []string{"-c", "printf 'b\\na\\nc\\n'"},
+		},
+		{
+			Name:   "transform",
+			Runner: r,
+			Cmd:    "sort",
+			Stdin:  "fetch",
+		},
+		{
+			Name:   "validate",
+			Runner: r,
+			Cmd:    "sh",
+			Args: []string{"-c", `test "$(cat "$INPUTS_DIR/sorted.txt")" = "$(printf 'a\nb\nc')" && ` +
+				"echo valid > " + filepath.Join(dir, "report.txt")},
+			Inputs:    map[string]string{"sorted.txt": "transform"},
+			Artifacts: []ArtifactSpec{{Pattern: filepath.Join(dir, "*.txt")}},
+		},
+		{
+			Name:   "publish",
+			Runner: r,
+			Cmd:    "sh",
+			Args:   []string{"-c", `cat "$INPUTS_DIR/report"; exit 3`},
+			Inputs: map[string]string{"report": "validate:report.txt"},
+			// 3 is "nothing to publish", which is fine
+			ContinueOn: []int{0, 3},
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunPipeline failed: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+	if got := string(results[1].Stdout); got != "a\nb\nc\n" {
+		t.Errorf("unexpected output of transform: %q", got)
+	}
+	if results[3].ExitCode != 3 || strings.TrimSpace(string(results[3].Stdout)) != "valid" {
+		t.Errorf("unexpected result of publish: %+v", results[3])
+	}
+}
+
+func TestRunPipeline_StopsOnFailure(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := RunPipeline(context.Background(), []PipelineStep{
+		{Name: "first", Runner: r, Cmd: "sh", Args: []string{"-c", "exit 1"}},
+		{Name: "second", Runner: r, Cmd: "true"},
+	})
+	if !errors.Is(err, ErrStepFailed) {
+		t.Fatalf("expected ErrStepFailed, got %v", err)
+	}
+	if len(results) != 1 || results[0].ExitCode != 1 {
+		t.Errorf("unexpected results: %+v", results)
+	}
+}
+
+func TestRunPipeline_Invalid(t *testing.T) {
+	r, _ := NewExec(Options{}, nil)
+
+	tests := map[string][]PipelineStep{
+		"no name":       {{Runner: r, Cmd: "true"}},
+		"no runner":     {{Name: "a", Cmd: "true"}},
+		"duplicate":     {{Name: "a", Runner: r, Cmd: "true"}, {Name: "a", Runner: r, Cmd: "true"}},
+		"future stdin":  {{Name: "a", Runner: r, Cmd: "cat", Stdin: "b"}, {Name: "b", Runner: r, Cmd: "true"}},
+		"unknown input": {{Name: "a", Runner: r, Cmd: "true", Inputs: map[string]string{"f": "missing:x"}}},
+	}
+	for name, steps := range tests {
+		if _, err := RunPipeline(context.Background(), steps); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
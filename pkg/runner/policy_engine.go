@@ -0,0 +1,377 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrPolicyDenied is returned for the runners and executions denied by the
+// policy engine
+var ErrPolicyDenied = errors.New("denied by policy")
+
+// Phases of the policy evaluation
+const (
+	// PolicyPhaseCreate is the evaluation of the options of a runner
+	// created with New
+	PolicyPhaseCreate = "create"
+	// PolicyPhaseExecute is the evaluation of each execution
+	PolicyPhaseExecute = "execute"
+)
+
+// PolicyInput is the request evaluated by a PolicyEngine, the input of the
+// Rego policies
+type PolicyInput struct {
+	// Phase is PolicyPhaseCreate or PolicyPhaseExecute
+	Phase string `json:"phase"`
+
+	// Runner is the type of the runner
+	Runner Type `json:"runner"`
+
+	// Options are the options of the runner
+	Options Options `json:"options"`
+
+	// Command is the command line of the execution (empty when creating
+	// the runner)
+	Command string `json:"command,omitempty"`
+
+	// EnvNames are the names of the environment variables of the execution
+	EnvNames []string `json:"env_names,omitempty"`
+
+	// Labels are the labels of the caller (see WithCallerLabels)
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// PolicyDecision is the decision of a PolicyEngine, which can allow the
+// request with some changes
+type PolicyDecision struct {
+	// Allow is whether the request is allowed
+	Allow bool `json:"allow"`
+
+	// Reasons explain the decision, e.g. the rules denying the request
+	Reasons []string `json:"reasons,omitempty"`
+
+	// Options are merged into the options of the runner when creating it: the
+	// options set to null are removed
+	Options Options `json:"options,omitempty"`
+
+	// Env are the environment variables set for the execution
+	Env map[string]string `json:"env,omitempty"`
+
+	// UnsetEnv are the names of the environment variables removed from the
+	// execution
+	UnsetEnv []string `json:"unset_env,omitempty"`
+}
+
+// PolicyEngine evaluates the runners and their executions against central
+// policies, such as the Rego policies of OPA
+type PolicyEngine interface {
+	Evaluate(ctx context.Context, input PolicyInput) (PolicyDecision, error)
+}
+
+// PolicyEngineFunc adapts a function to the PolicyEngine interface, e.g. for
+// evaluating Rego policies embedded with the rego package of OPA
+type PolicyEngineFunc func(ctx context.Context, input PolicyInput) (PolicyDecision, error)
+
+// Evaluate implements PolicyEngine
+func (f PolicyEngineFunc) Evaluate(ctx context.Context, input PolicyInput) (PolicyDecision, error) {
+	return f(ctx, input)
+}
+
+// OPAEngine evaluates the policies with the Data API of an OPA server: the
+// PolicyInput is POSTed as the input of a rule (or package), whose result is
+// a PolicyDecision object, or a boolean for rules that only allow or deny.
+type OPAEngine struct {
+	// URL of the OPA server, e.g. "http://localhost:8181"
+	URL string
+
+	// Path is the path of the rule, e.g. "runner/decision" for the decision
+	// rule of the runner package
+	Path string
+
+	// Headers are added to the requests (e.g. Authorization)
+	Headers map[string]string
+
+	// Client is the HTTP client (default: http.DefaultClient)
+	Client *http.Client
+}
+
+// Evaluate implements PolicyEngine
+func (e OPAEngine) Evaluate(ctx context.Context, input PolicyInput) (PolicyDecision, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return PolicyDecision{}, err
+	}
+	url := strings.TrimSuffix(e.URL, "/") + "/v1/data/" + strings.Trim(e.Path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return PolicyDecision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.Headers {
+		req.Header.Set(key, value)
+	}
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return PolicyDecision{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return PolicyDecision{}, fmt.Errorf("OPA server %s returned %s", e.URL, resp.Status)
+	}
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return PolicyDecision{}, fmt.Errorf("invalid response of OPA server %s: %w", e.URL, err)
+	}
+	if len(response.Result) == 0 {
+		return PolicyDecision{}, fmt.Errorf("policy %s is not defined in OPA server %s", e.Path, e.URL)
+	}
+
+	var decision PolicyDecision
+	var allow bool
+	if err := json.Unmarshal(response.Result, &allow); err == nil {
+		decision.Allow = allow
+	} else if err := json.Unmarshal(response.Result, &decision); err != nil {
+		return PolicyDecision{}, fmt.Errorf("invalid result of policy %s: %w", e.Path, err)
+	}
+	return decision, nil
+}
+
+// callerLabelsKey is the context key of the labels of the caller
+type callerLabelsKey struct{}
+
+// WithCallerLabels returns a context with the labels of the caller (e.g. the
+// team, the tool or the tenant), passed to the policy engine with the
+// executions run with it
+func WithCallerLabels(ctx context.Context, labels map[string]string) context.Context {
+	return context.WithValue(ctx, callerLabelsKey{}, labels)
+}
+
+// callerLabelsFrom returns the labels of the caller in the context
+func callerLabelsFrom(ctx context.Context) map[string]string {
+	labels, _ := ctx.Value(callerLabelsKey{}).(map[string]string)
+	return labels
+}
+
+var (
+	policyEngineMu sync.RWMutex
+	policyEngine   PolicyEngine
+)
+
+// UsePolicyEngine sets the policy engine evaluating the runners created with
+// New (nil for none): their options are evaluated when they are created, and
+// the runners returned are PolicyGates evaluating each execution
+func UsePolicyEngine(engine PolicyEngine) {
+	policyEngineMu.Lock()
+	defer policyEngineMu.Unlock()
+	policyEngine = engine
+}
+
+// currentPolicyEngine returns the engine set with UsePolicyEngine
+func currentPolicyEngine() PolicyEngine {
+	policyEngineMu.RLock()
+	defer policyEngineMu.RUnlock()
+	return policyEngine
+}
+
+// applyPolicyEngine evaluates the options of a runner created with New,
+// returning them with the changes of the decision
+func applyPolicyEngine(engine PolicyEngine, runnerType Type, options Options, logger Logger) (Options, error) {
+	decision, err := engine.Evaluate(context.Background(), PolicyInput{
+		Phase:   PolicyPhaseCreate,
+		Runner:  runnerType,
+		Options: options,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: policy evaluation failed: %w", ErrPolicyDenied, err)
+	}
+	if !decision.Allow {
+		return nil, policyDenial(fmt.Sprintf("the %s runner", runnerType), decision)
+	}
+	if len(decision.Options) == 0 {
+		return options, nil
+	}
+
+	merged := Options{}
+	for key, value := range options {
+		merged[key] = value
+	}
+	for key, value := range decision.Options {
+		if value == nil {
+			delete(merged, key)
+		} else {
+			merged[key] = value
+		}
+	}
+	logger.Info("Options of the %s runner changed by the policy: %v", runnerType, decision.Options)
+	return merged, nil
+}
+
+// policyDenial returns the error of a request denied by the policy
+func policyDenial(what string, decision PolicyDecision) error {
+	if len(decision.Reasons) == 0 {
+		return fmt.Errorf("%w: %s", ErrPolicyDenied, what)
+	}
+	return fmt.Errorf("%w: %s: %s", ErrPolicyDenied, what, strings.Join(decision.Reasons, "; "))
+}
+
+// PolicyGate is a Runner evaluating each execution with a PolicyEngine,
+// which can deny it or change its environment
+type PolicyGate struct {
+	runner     Runner
+	runnerType Type
+	options    Options
+	engine     PolicyEngine
+	logger     Logger
+}
+
+// NewPolicyGate wraps a runner with a policy engine. If logger is nil, a
+// default logger is created.
+func NewPolicyGate(r Runner, engine PolicyEngine, logger Logger) (*PolicyGate, error) {
+	if engine == nil {
+		return nil, fmt.Errorf("policy gate requires a policy engine")
+	}
+	runnerType, options, err := runnerOptions(r)
+	if err != nil {
+		return nil, err
+	}
+	return &PolicyGate{
+		runner:     r,
+		runnerType: runnerType,
+		options:    options,
+		engine:     engine,
+		logger:     defaultLogger(logger),
+	}, nil
+}
+
+// Run executes a command if allowed by the policy. It implements the Runner
+// interface.
+func (g *PolicyGate) Run(ctx context.Context, shell string, command string, env []string, params map[string]interface{}, tmpfile bool) (string, error) {
+	env, err := g.evaluate(ctx, command, env)
+	if err != nil {
+		return "", err
+	}
+	return g.runner.Run(ctx, shell, command, env, params, tmpfile)
+}
+
+// RunEx executes a command if allowed by the policy, like Run, returning its
+// exit code and both output streams. It implements the Runner interface.
+func (g *PolicyGate) RunEx(ctx context.Context, req RunRequest) (*RunResult, error) {
+	env, err := g.evaluate(ctx, req.Command, req.Env)
+	if err != nil {
+		return nil, err
+	}
+	req.Env = env
+	return RunEx(ctx, g.runner, req)
+}
+
+// RunWithPipes starts a command if allowed by the policy. It implements the
+// Runner interface.
+func (g *PolicyGate) RunWithPipes(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (
+	stdin io.WriteCloser,
+	stdout io.ReadCloser,
+	stderr io.ReadCloser,
+	wait func() error,
+	err error,
+) {
+	env, err = g.evaluate(ctx, commandLine(cmd, args), env)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return g.runner.RunWithPipes(ctx, cmd, args, env, params)
+}
+
+// CheckImplicitRequirements checks the requirements of the runner
+func (g *PolicyGate) CheckImplicitRequirements() error {
+	return g.runner.CheckImplicitRequirements()
+}
+
+// Fingerprint returns the fingerprint of the runner. It implements the
+// Fingerprinter interface.
+func (g *PolicyGate) Fingerprint() string {
+	return Fingerprint(g.runner)
+}
+
+// start starts a command if allowed by the policy, with the execution
+// handle of the runner (see Start)
+func (g *PolicyGate) start(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	env, err := g.evaluate(ctx, commandLine(cmd, args), env)
+	if err != nil {
+		return nil, err
+	}
+	return startExecution(ctx, g.runner, cmd, args, env, params)
+}
+
+// fileChangeFolders returns the folders of the runner reporting file changes
+func (g *PolicyGate) fileChangeFolders(params map[string]interface{}) []string {
+	if fr, ok := g.runner.(fileChangeReporter); ok {
+		return fr.fileChangeFolders(params)
+	}
+	return nil
+}
+
+// policyWarnings returns the warnings of the runner
+func (g *PolicyGate) policyWarnings(ctx context.Context) []PolicyWarning {
+	if w, ok := g.runner.(policyWarner); ok {
+		return w.policyWarnings(ctx)
+	}
+	return nil
+}
+
+// evaluate evaluates an execution, returning its environment with the
+// changes of the decision, or an error wrapping ErrPolicyDenied
+func (g *PolicyGate) evaluate(ctx context.Context, command string, env []string) ([]string, error) {
+	decision, err := g.engine.Evaluate(ctx, PolicyInput{
+		Phase:    PolicyPhaseExecute,
+		Runner:   g.runnerType,
+		Options:  g.options,
+		Command:  command,
+		EnvNames: envNames(env),
+		Labels:   callerLabelsFrom(ctx),
+	})
+	if err != nil {
+		g.logger.Warn("Policy evaluation of %q failed: %v", command, err)
+		return nil, fmt.Errorf("%w: policy evaluation failed: %w", ErrPolicyDenied, err)
+	}
+	if !decision.Allow {
+		err := policyDenial(fmt.Sprintf("command %q", command), decision)
+		g.logger.Warn("%v", err)
+		return nil, err
+	}
+	if len(decision.Env) == 0 && len(decision.UnsetEnv) == 0 {
+		return env, nil
+	}
+
+	var result []string
+	for _, e := range env {
+		name, _, _ := strings.Cut(e, "=")
+		if _, set := decision.Env[name]; !set && !contains(decision.UnsetEnv, name) {
+			result = append(result, e)
+		}
+	}
+	names := make([]string, 0, len(decision.Env))
+	for name := range decision.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result = append(result, name+"="+decision.Env[name])
+	}
+	g.logger.Debug("Environment of %q changed by the policy: set %v, unset %v", command, names, decision.UnsetEnv)
+	return result, nil
+}
@@ -0,0 +1,122 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// testPolicy denies the rm commands of the callers of the "untrusted" team,
+// removes the secrets of the environment and forbids networking
+var testPolicy = PolicyEngineFunc(func(ctx context.Context, input PolicyInput) (PolicyDecision, error) {
+	switch input.Phase {
+	case PolicyPhaseCreate:
+		if input.Options["allow_networking"] == true {
+			return PolicyDecision{Allow: true, Options: Options{"allow_networking": nil}}, nil
+		}
+	case PolicyPhaseExecute:
+		if input.Labels["team"] == "untrusted" && strings.HasPrefix(input.Command, "rm ") {
+			return PolicyDecision{Reasons: []string{"untrusted callers cannot delete files"}}, nil
+		}
+		return PolicyDecision{Allow: true, UnsetEnv: []string{"SECRET"}, Env: map[string]string{"POLICY": "applied"}}, nil
+	}
+	return PolicyDecision{Allow: true}, nil
+})
+
+func TestUsePolicyEngine(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	UsePolicyEngine(testPolicy)
+	t.Cleanup(func() { UsePolicyEngine(nil) })
+
+	r, err := New(TypeExec, Options{"allow_networking": true}, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	gate, ok := r.(*PolicyGate)
+	if !ok {
+		t.Fatalf("New() = %T, want *PolicyGate", r)
+	}
+	if _, ok := gate.options["allow_networking"]; ok && gate.options["allow_networking"] != false {
+		t.Errorf("the policy should remove allow_networking: %v", gate.options)
+	}
+	if fp := Fingerprint(r); fp == "" || fp != Fingerprint(gate.runner) {
+		t.Errorf("Fingerprint() = %q, want the fingerprint of the runner", fp)
+	}
+
+	ctx := context.Background()
+	output, err := r.Run(ctx, "/bin/sh", "echo $SECRET-$POLICY", []string{"SECRET=s3cr3t"}, nil, false)
+	if err != nil || output != "-applied" {
+		t.Errorf("Run() = %q, %v, want the environment changed by the policy", output, err)
+	}
+
+	untrusted := WithCallerLabels(ctx, map[string]string{"team": "untrusted"})
+	_, err = r.Run(untrusted, "/bin/sh", "rm -rf /tmp/nothing", nil, nil, false)
+	if !errors.Is(err, ErrPolicyDenied) || !strings.Contains(err.Error(), "cannot delete files") {
+		t.Errorf("Run() = %v, want ErrPolicyDenied", err)
+	}
+	if _, err := r.Run(ctx, "/bin/sh", "rm -rf /tmp/nothing", nil, nil, false); err != nil {
+		t.Errorf("Run() of a trusted caller failed: %v", err)
+	}
+}
+
+func TestUsePolicyEngineDeniesCreation(t *testing.T) {
+	UsePolicyEngine(PolicyEngineFunc(func(ctx context.Context, input PolicyInput) (PolicyDecision, error) {
+		return PolicyDecision{Allow: input.Runner != TypeExec, Reasons: []string{"use a sandbox"}}, nil
+	}))
+	t.Cleanup(func() { UsePolicyEngine(nil) })
+
+	_, err := New(TypeExec, Options{}, nil)
+	if !errors.Is(err, ErrPolicyDenied) || !strings.Contains(err.Error(), "use a sandbox") {
+		t.Errorf("New() = %v, want ErrPolicyDenied", err)
+	}
+}
+
+func TestOPAEngine(t *testing.T) {
+	var input PolicyInput
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input PolicyInput `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		input = body.Input
+		switch r.URL.Path {
+		case "/v1/data/runner/decision":
+			_, _ = w.Write([]byte(`{"result": {"allow": false, "reasons": ["no networking"]}}`))
+		case "/v1/data/runner/allow":
+			_, _ = w.Write([]byte(`{"result": true}`))
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	request := PolicyInput{Phase: PolicyPhaseExecute, Runner: TypeDocker, Command: "curl example.com", Labels: map[string]string{"tool": "fetch"}}
+
+	decision, err := OPAEngine{URL: server.URL, Path: "runner/decision"}.Evaluate(ctx, request)
+	if err != nil || decision.Allow || len(decision.Reasons) != 1 {
+		t.Errorf("Evaluate() = %+v, %v", decision, err)
+	}
+	if input.Command != request.Command || input.Labels["tool"] != "fetch" {
+		t.Errorf("unexpected input: %+v", input)
+	}
+
+	decision, err = OPAEngine{URL: server.URL + "/", Path: "/runner/allow"}.Evaluate(ctx, request)
+	if err != nil || !decision.Allow {
+		t.Errorf("Evaluate() of a boolean rule = %+v, %v", decision, err)
+	}
+
+	if _, err := (OPAEngine{URL: server.URL, Path: "runner/undefined"}).Evaluate(ctx, request); err == nil {
+		t.Errorf("Evaluate() of an undefined rule should fail")
+	}
+}
@@ -0,0 +1,78 @@
+package runner
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// normalizePaths cleans, deduplicates and sorts the paths of a policy, so
+// the rules and profiles generated from them (and the fingerprints of the
+// runners) do not depend on the order or the spelling of the options. Empty
+// paths are dropped, and paths with templates are kept as they are, as they
+// are cleaned once the templates are processed.
+func normalizePaths(paths []string) []string {
+	if len(paths) == 0 {
+		return paths
+	}
+	normalized := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if !strings.Contains(path, "{{") {
+			path = filepath.Clean(path)
+		}
+		normalized = append(normalized, path)
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
+
+// normalizePorts deduplicates and sorts the ports of a policy
+func normalizePorts(ports []uint16) []uint16 {
+	if len(ports) == 0 {
+		return ports
+	}
+	normalized := slices.Clone(ports)
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
+
+// pathConflictWarnings returns a warning for every path in both a read-only
+// list and a writable list of options (by option name), which contradict
+// each other. The profiles of some sandboxes make the path read-only
+// (readOnlyWins), the others writable.
+func pathConflictWarnings(t Type, readOnly map[string][]string, writable map[string][]string, readOnlyWins bool) []PolicyWarning {
+	effect := "it is writable"
+	if readOnlyWins {
+		effect = "it is read-only"
+	}
+
+	var warnings []PolicyWarning
+	for _, readOption := range slices.Sorted(maps.Keys(readOnly)) {
+		read := normalizePaths(readOnly[readOption])
+		for _, writeOption := range slices.Sorted(maps.Keys(writable)) {
+			for _, path := range normalizePaths(writable[writeOption]) {
+				if _, found := slices.BinarySearch(read, path); !found {
+					continue
+				}
+				warnings = append(warnings, PolicyWarning{
+					Runner:  t,
+					Feature: "policy_conflict",
+					Option:  writeOption,
+					Message: fmt.Sprintf("%s is in both %s and %s: %s", path, readOption, writeOption, effect),
+				})
+			}
+		}
+	}
+	return warnings
+}
+
+// policyConflicter is implemented by the runners whose options can
+// contradict each other, such as paths both read-only and writable
+type policyConflicter interface {
+	policyConflicts() []PolicyWarning
+}
@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizePaths(t *testing.T) {
+	got := normalizePaths([]string{"/usr/", "/tmp", "", "/usr", "/opt/../usr", "{{.home}}/src", "/etc"})
+	want := []string{"/etc", "/tmp", "/usr", "{{.home}}/src"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizePaths() = %q, want %q", got, want)
+	}
+	if got := normalizePorts([]uint16{443, 80, 443, 22}); !reflect.DeepEqual(got, []uint16{22, 80, 443}) {
+		t.Errorf("normalizePorts() = %v", got)
+	}
+}
+
+func TestNormalizeRules_fingerprint(t *testing.T) {
+	a, err := NewLandrun(Options{
+		"allow_read_folders": []string{"/usr", "/etc", "/usr/"},
+		"allow_connect_tcp":  []uint16{443, 80},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewLandrun failed: %v", err)
+	}
+	b, err := NewLandrun(Options{
+		"allow_read_folders": []string{"/etc", "/usr"},
+		"allow_connect_tcp":  []uint16{80, 443, 80},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewLandrun failed: %v", err)
+	}
+	if a.Fingerprint() != b.Fingerprint() {
+		t.Errorf("the fingerprints of the same rules in another order differ")
+	}
+
+	f, err := NewFirejail(Options{"allow_write_folders": []string{"/var/tmp", "{{.workdir}}", "/data"}}, nil)
+	if err != nil {
+		t.Fatalf("NewFirejail failed: %v", err)
+	}
+	// the templates may expand to paths already listed
+	opts := f.profileOptions(map[string]interface{}{"workdir": "/data/"})
+	if !reflect.DeepEqual(opts.AllowWriteFolders, []string{"/data", "/var/tmp"}) {
+		t.Errorf("AllowWriteFolders = %q", opts.AllowWriteFolders)
+	}
+}
+
+func TestLandlockRuleSpecs_sorted(t *testing.T) {
+	r, err := NewLandrun(Options{
+		"allow_read_folders":  []string{"{{.src}}", "/usr", "{{.lib}}"},
+		"allow_bind_tcp":      []uint16{9000, 8080},
+		"allow_connect_tcp":   []uint16{443, 80, 443},
+		"allow_write_folders": []string{"/var/tmp"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewLandrun failed: %v", err)
+	}
+	specs, err := r.landlockRuleSpecs(map[string]interface{}{"src": "/src", "lib": "/usr"})
+	if err != nil {
+		t.Fatalf("landlockRuleSpecs failed: %v", err)
+	}
+	want := []landlockRuleSpec{
+		{Kind: landlockRWDirs, Paths: []string{"/dev", "/tmp"}},
+		{Kind: landlockRODirs, Paths: []string{"/src", "/usr"}},
+		{Kind: landlockRWDirs, Paths: []string{"/var/tmp"}},
+		{Kind: landlockBindTCP, Port: 8080},
+		{Kind: landlockBindTCP, Port: 9000},
+		{Kind: landlockConnectTCP, Port: 80},
+		{Kind: landlockConnectTCP, Port: 443},
+	}
+	got, _ := json.Marshal(specs)
+	expected, _ := json.Marshal(want)
+	if string(got) != string(expected) {
+		t.Errorf("landlockRuleSpecs() = %s, want %s", got, expected)
+	}
+}
+
+func TestPathConflictWarnings(t *testing.T) {
+	r, err := NewLandrun(Options{
+		"allow_networking":         true,
+		"allow_read_folders":       []string{"/usr", "/data/"},
+		"allow_write_exec_folders": []string{"/data", "/var/tmp"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewLandrun failed: %v", err)
+	}
+	warnings := pathConflictWarnings(TypeLandrun,
+		map[string][]string{"allow_read_folders": r.options.AllowReadFolders},
+		map[string][]string{"allow_write_exec_folders": r.options.AllowWriteExecFolders}, false)
+	if len(warnings) != 1 || warnings[0].Feature != "policy_conflict" || warnings[0].Option != "allow_write_exec_folders" ||
+		!strings.Contains(warnings[0].Message, "/data is in both allow_read_folders and allow_write_exec_folders: it is writable") {
+		t.Errorf("warnings = %v", warnings)
+	}
+
+	fakeTool(t, "firejail", `echo "firejail version 0.9.72"`)
+	f, err := NewFirejail(Options{"allow_read_files": []string{"/etc/hosts"}, "allow_write_files": []string{"/etc/hosts"}}, nil)
+	if err != nil {
+		t.Fatalf("NewFirejail failed: %v", err)
+	}
+	if got := Warnings(f); len(got) != 1 || !strings.HasSuffix(got[0].Message, "it is read-only") {
+		t.Errorf("warnings = %v", got)
+	}
+}
@@ -0,0 +1,446 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// ErrJobPreempted is returned by Job.Wait when the job was killed to make
+// room for a job with a higher priority.
+var ErrJobPreempted = errors.New("job preempted by a higher priority job")
+
+// PreemptionPolicy defines what a Pool does with running jobs when a job
+// with a higher priority is waiting for a slot.
+type PreemptionPolicy string
+
+const (
+	// PreemptNone never interrupts running jobs: higher priority jobs only
+	// take precedence over other queued jobs
+	PreemptNone PreemptionPolicy = ""
+
+	// PreemptPause pauses the lowest priority running job and puts it back in
+	// the queue, where it is resumed once a slot is available again. Jobs that
+	// cannot be paused are killed instead.
+	PreemptPause PreemptionPolicy = "pause"
+
+	// PreemptKill kills the lowest priority running job, whose Wait then
+	// returns ErrJobPreempted
+	PreemptKill PreemptionPolicy = "kill"
+)
+
+// PoolOptions is the options for a Pool
+type PoolOptions struct {
+	// MaxConcurrent is the maximum number of jobs running at the same time
+	// (defaults to the number of CPUs)
+	MaxConcurrent int
+
+	// Preemption is the policy applied to running jobs when a higher priority
+	// job is waiting
+	Preemption PreemptionPolicy
+}
+
+// JobRequest describes a command to execute in a Pool
+type JobRequest struct {
+	// Runner is the runner used to execute the command
+	Runner Runner
+
+	// Cmd, Args, Env and Params are passed to Start
+	Cmd    string
+	Args   []string
+	Env    []string
+	Params map[string]interface{}
+
+	// Priority of the job: jobs with higher values are started first
+	Priority int
+
+	// Tenant is the fairness key of the job. Among queued jobs with the same
+	// priority, jobs of the tenant with fewer running jobs are started first.
+	Tenant string
+}
+
+// JobState is the scheduling state of a Job
+type JobState string
+
+const (
+	// JobQueued jobs are waiting for a slot
+	JobQueued JobState = "queued"
+	// JobRunning jobs hold a slot
+	JobRunning JobState = "running"
+	// JobPaused jobs have been preempted and are waiting to be resumed
+	JobPaused JobState = "paused"
+	// JobDone jobs have completed, failed to start or have been killed
+	JobDone JobState = "done"
+)
+
+// Pool executes jobs with a bounded concurrency, starting queued jobs by
+// priority and, optionally, preempting running jobs for higher priority ones.
+//
+// Jobs run with the restrictions of the runner of their request: the pool
+// only decides when they start. Callers must call Job.Wait for every job
+// that started, exactly as they would call Execution.Wait, as that is what
+// releases the slot of the job.
+type Pool struct {
+	logger  Logger
+	options PoolOptions
+
+	mu      sync.Mutex
+	seq     uint64
+	queued  []*Job
+	running []*Job
+}
+
+// Job is a command submitted to a Pool
+type Job struct {
+	// ID uniquely identifies the job
+	ID string
+
+	// Priority and Tenant are copied from the request
+	Priority int
+	Tenant   string
+
+	pool   *Pool
+	req    JobRequest
+	seq    uint64
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// the following fields are protected by the pool mutex
+	state     JobState
+	execution *Execution
+	starting  bool
+	pausing   bool
+	preempted bool
+
+	started  chan struct{}
+	startErr error
+
+	waitOnce sync.Once
+	waitErr  error
+}
+
+// NewPool creates a new Pool with the provided logger.
+// If logger is nil, a default logger is created.
+func NewPool(options PoolOptions, logger Logger) *Pool {
+	logger = defaultLogger(logger)
+	if options.MaxConcurrent <= 0 {
+		options.MaxConcurrent = runtime.NumCPU()
+	}
+	return &Pool{
+		logger:  logger,
+		options: options,
+	}
+}
+
+// Submit queues a job and returns it immediately. The job is started as soon
+// as a slot is available for it.
+//
+// Cancelling ctx removes a queued job from the queue and kills a running one.
+func (p *Pool) Submit(ctx context.Context, req JobRequest) (*Job, error) {
+	if req.Runner == nil {
+		return nil, fmt.Errorf("job request requires a runner")
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	j := &Job{
+		ID:       newExecutionID(),
+		Priority: req.Priority,
+		Tenant:   req.Tenant,
+		pool:     p,
+		req:      req,
+		ctx:      jobCtx,
+		cancel:   cancel,
+		state:    JobQueued,
+		started:  make(chan struct{}),
+	}
+
+	p.mu.Lock()
+	p.seq++
+	j.seq = p.seq
+	p.queued = append(p.queued, j)
+	p.logger.Debug("Pool: queued job %s (priority %d, tenant %q)", j.ID, j.Priority, j.Tenant)
+	p.scheduleLocked()
+	p.mu.Unlock()
+
+	// Drop the job from the queue if it is cancelled before starting
+	context.AfterFunc(jobCtx, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if j.state == JobQueued {
+			p.queued = removeJob(p.queued, j)
+			p.finishStartLocked(j, nil, jobCtx.Err())
+		}
+	})
+
+	return j, nil
+}
+
+// Len returns the number of queued (including paused) and running jobs
+func (p *Pool) Len() (queued int, running int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queued), len(p.running)
+}
+
+// scheduleLocked starts (or resumes) queued jobs while there are free slots,
+// and preempts running jobs for queued jobs with a higher priority.
+// It must be called with the pool mutex held.
+func (p *Pool) scheduleLocked() {
+	for len(p.queued) > 0 {
+		next := p.nextLocked()
+
+		if len(p.running) >= p.options.MaxConcurrent {
+			if !p.preemptLocked(next) {
+				return
+			}
+			continue
+		}
+
+		p.queued = removeJob(p.queued, next)
+		p.running = append(p.running, next)
+
+		if next.state == JobPaused {
+			p.logger.Debug("Pool: resuming job %s", next.ID)
+			next.state = JobRunning
+			next.starting = true
+			go p.resume(next)
+			continue
+		}
+
+		p.logger.Debug("Pool: starting job %s", next.ID)
+		next.state = JobRunning
+		next.starting = true
+		go p.start(next)
+	}
+}
+
+// nextLocked returns the queued job that should run next: the one with the
+// highest priority, then the one whose tenant has fewer running jobs, then
+// the oldest one.
+func (p *Pool) nextLocked() *Job {
+	runningPerTenant := map[string]int{}
+	for _, j := range p.running {
+		runningPerTenant[j.Tenant]++
+	}
+
+	var best *Job
+	for _, j := range p.queued {
+		switch {
+		case best == nil:
+			best = j
+		case j.Priority != best.Priority:
+			if j.Priority > best.Priority {
+				best = j
+			}
+		case runningPerTenant[j.Tenant] != runningPerTenant[best.Tenant]:
+			if runningPerTenant[j.Tenant] < runningPerTenant[best.Tenant] {
+				best = j
+			}
+		case j.seq < best.seq:
+			best = j
+		}
+	}
+	return best
+}
+
+// preemptLocked frees a slot for the given job by pausing or killing the
+// running job with the lowest priority, if that priority is lower than the
+// one of the job. It returns whether a slot was freed: the slot of a job
+// being paused is freed once it has been paused (see pause).
+func (p *Pool) preemptLocked(j *Job) bool {
+	if p.options.Preemption == PreemptNone {
+		return false
+	}
+
+	var victim *Job
+	for _, r := range p.running {
+		if r.pausing {
+			// wait for the slot of the job being paused
+			return false
+		}
+		// jobs still being started or resumed cannot be paused yet
+		if r.starting || r.Priority >= j.Priority {
+			continue
+		}
+		if victim == nil || r.Priority < victim.Priority ||
+			(r.Priority == victim.Priority && r.seq > victim.seq) {
+			victim = r
+		}
+	}
+	if victim == nil {
+		return false
+	}
+
+	if p.options.Preemption == PreemptPause {
+		victim.pausing = true
+		go p.pause(victim, j)
+		return false
+	}
+
+	p.killLocked(victim, j)
+	return true
+}
+
+// killLocked kills a running job preempted by the given job, freeing its slot
+func (p *Pool) killLocked(victim *Job, j *Job) {
+	p.logger.Debug("Pool: killing job %s for job %s", victim.ID, j.ID)
+	p.running = removeJob(p.running, victim)
+	victim.state = JobDone
+	victim.preempted = true
+	victim.cancel()
+}
+
+// pause pauses a job preempted by the given job and puts it back in the
+// queue, freeing its slot. The job is killed when it cannot be paused.
+func (p *Pool) pause(victim *Job, j *Job) {
+	err := victim.execution.Pause()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	victim.pausing = false
+	switch {
+	case victim.state == JobDone:
+		// the job completed meanwhile, freeing its slot
+	case err != nil:
+		p.logger.Debug("Pool: failed to pause job %s, killing it: %v", victim.ID, err)
+		p.killLocked(victim, j)
+	default:
+		p.logger.Debug("Pool: paused job %s for job %s", victim.ID, j.ID)
+		p.running = removeJob(p.running, victim)
+		victim.state = JobPaused
+		p.queued = append(p.queued, victim)
+	}
+	p.scheduleLocked()
+}
+
+// resume resumes a paused job that has been given a slot again. The job is
+// killed when it cannot be resumed.
+func (p *Pool) resume(j *Job) {
+	err := j.execution.Resume()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	j.starting = false
+	if err != nil {
+		p.logger.Debug("Pool: failed to resume job %s, killing it: %v", j.ID, err)
+		j.cancel()
+	}
+	// a queued job may have been waiting for this one to become preemptible
+	p.scheduleLocked()
+}
+
+// start starts the command of a job that has been given a slot
+func (p *Pool) start(j *Job) {
+	e, err := Start(j.ctx, j.req.Runner, j.req.Cmd, j.req.Args, j.req.Env, j.req.Params)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	j.starting = false
+	if err != nil {
+		p.logger.Debug("Pool: failed to start job %s: %v", j.ID, err)
+		p.running = removeJob(p.running, j)
+		p.finishStartLocked(j, nil, err)
+		p.scheduleLocked()
+		return
+	}
+
+	p.finishStartLocked(j, e, nil)
+	// a queued job may have been waiting for this one to become preemptible
+	p.scheduleLocked()
+}
+
+// finishStartLocked records the result of starting a job and wakes up its waiters
+func (p *Pool) finishStartLocked(j *Job, e *Execution, err error) {
+	j.execution = e
+	j.startErr = err
+	if err != nil {
+		j.state = JobDone
+		j.cancel()
+	}
+	close(j.started)
+}
+
+// release frees the slot of a completed job
+func (p *Pool) release(j *Job) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.running = removeJob(p.running, j)
+	p.queued = removeJob(p.queued, j)
+	j.state = JobDone
+	p.scheduleLocked()
+}
+
+// removeJob removes a job from a list, if present
+func removeJob(jobs []*Job, j *Job) []*Job {
+	for i, other := range jobs {
+		if other == j {
+			return append(jobs[:i], jobs[i+1:]...)
+		}
+	}
+	return jobs
+}
+
+// Started returns a channel that is closed once the job has been started,
+// or has failed to start
+func (j *Job) Started() <-chan struct{} {
+	return j.started
+}
+
+// Execution waits for the job to start and returns its execution handle.
+//
+// The handle gives access to the pipes of the command. Pause and Resume
+// should not be called directly on jobs of a pool using PreemptPause.
+func (j *Job) Execution(ctx context.Context) (*Execution, error) {
+	select {
+	case <-j.started:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return j.execution, j.startErr
+}
+
+// State returns the scheduling state of the job
+func (j *Job) State() JobState {
+	j.pool.mu.Lock()
+	defer j.pool.mu.Unlock()
+	return j.state
+}
+
+// Cancel removes the job from the queue, or kills it if it is running
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// Wait waits for the job to start and complete, and releases its slot.
+// It returns the error of the command, ErrJobPreempted if the job was
+// killed by preemption, or the error that prevented it from starting.
+func (j *Job) Wait() error {
+	j.waitOnce.Do(func() {
+		<-j.started
+		if j.startErr != nil {
+			j.waitErr = j.startErr
+			return
+		}
+
+		err := j.execution.Wait()
+		j.pool.release(j)
+		j.cancel()
+
+		j.pool.mu.Lock()
+		preempted := j.preempted
+		j.pool.mu.Unlock()
+
+		if preempted {
+			j.waitErr = fmt.Errorf("%w: %v", ErrJobPreempted, err)
+			return
+		}
+		j.waitErr = err
+	})
+	return j.waitErr
+}
@@ -0,0 +1,246 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// submitCat submits a job running `cat`, which runs until its stdin is closed
+func submitCat(t *testing.T, p *Pool, priority int, tenant string) *Job {
+	t.Helper()
+
+	runner, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+
+	j, err := p.Submit(context.Background(), JobRequest{
+		Runner:   runner,
+		Cmd:      "cat",
+		Priority: priority,
+		Tenant:   tenant,
+	})
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	return j
+}
+
+// finishCat makes a job submitted with submitCat complete and returns its Wait result
+func finishCat(t *testing.T, j *Job) error {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	e, err := j.Execution(ctx)
+	if err != nil {
+		t.Fatalf("Job %s did not start: %v", j.ID, err)
+	}
+	_ = e.Stdin.Close()
+	_, _ = io.ReadAll(e.Stdout)
+	_, _ = io.ReadAll(e.Stderr)
+	return j.Wait()
+}
+
+// waitStarted waits for a job to be started
+func waitStarted(t *testing.T, j *Job) {
+	t.Helper()
+	select {
+	case <-j.Started():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for job %s to start", j.ID)
+	}
+}
+
+// waitState waits for a job to reach a state
+func waitState(t *testing.T, j *Job, state JobState) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for j.State() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for job %s to be %s (is %s)", j.ID, state, j.State())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestPool_Priority tests that queued jobs are started by priority
+func TestPool_Priority(t *testing.T) {
+	logger, _ := common.NewLogger("", "", common.LogLevelDebug, false)
+	p := NewPool(PoolOptions{MaxConcurrent: 1}, logger)
+
+	blocker := submitCat(t, p, 0, "")
+	waitStarted(t, blocker)
+
+	low := submitCat(t, p, 1, "")
+	high := submitCat(t, p, 10, "")
+
+	if err := finishCat(t, blocker); err != nil {
+		t.Fatalf("Blocker failed: %v", err)
+	}
+
+	waitStarted(t, high)
+	if state := low.State(); state != JobQueued {
+		t.Errorf("Expected low priority job to be queued, got %s", state)
+	}
+
+	if err := finishCat(t, high); err != nil {
+		t.Fatalf("High priority job failed: %v", err)
+	}
+	if err := finishCat(t, low); err != nil {
+		t.Fatalf("Low priority job failed: %v", err)
+	}
+}
+
+// TestPool_TenantFairness tests that tenants with fewer running jobs go first
+func TestPool_TenantFairness(t *testing.T) {
+	logger, _ := common.NewLogger("", "", common.LogLevelDebug, false)
+	p := NewPool(PoolOptions{MaxConcurrent: 2}, logger)
+
+	a1 := submitCat(t, p, 0, "a")
+	a2 := submitCat(t, p, 0, "a")
+	waitStarted(t, a1)
+	waitStarted(t, a2)
+
+	a3 := submitCat(t, p, 0, "a")
+	b1 := submitCat(t, p, 0, "b")
+
+	if err := finishCat(t, a1); err != nil {
+		t.Fatalf("Job failed: %v", err)
+	}
+
+	waitStarted(t, b1)
+	if state := a3.State(); state != JobQueued {
+		t.Errorf("Expected job of the busier tenant to be queued, got %s", state)
+	}
+
+	for _, j := range []*Job{a2, b1, a3} {
+		if err := finishCat(t, j); err != nil {
+			t.Fatalf("Job failed: %v", err)
+		}
+	}
+}
+
+// TestPool_PreemptPause tests that lower priority jobs are paused and resumed
+func TestPool_PreemptPause(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Pause is not supported on Windows")
+	}
+
+	logger, _ := common.NewLogger("", "", common.LogLevelDebug, false)
+	p := NewPool(PoolOptions{MaxConcurrent: 1, Preemption: PreemptPause}, logger)
+
+	low := submitCat(t, p, 0, "")
+	waitStarted(t, low)
+
+	high := submitCat(t, p, 10, "")
+	waitStarted(t, high)
+	if state := low.State(); state != JobPaused {
+		t.Errorf("Expected low priority job to be paused, got %s", state)
+	}
+
+	if err := finishCat(t, high); err != nil {
+		t.Fatalf("High priority job failed: %v", err)
+	}
+
+	waitState(t, low, JobRunning)
+	if err := finishCat(t, low); err != nil {
+		t.Fatalf("Low priority job failed: %v", err)
+	}
+}
+
+// TestPool_PreemptKill tests that lower priority jobs are killed
+func TestPool_PreemptKill(t *testing.T) {
+	logger, _ := common.NewLogger("", "", common.LogLevelDebug, false)
+	p := NewPool(PoolOptions{MaxConcurrent: 1, Preemption: PreemptKill}, logger)
+
+	low := submitCat(t, p, 0, "")
+	waitStarted(t, low)
+
+	high := submitCat(t, p, 10, "")
+	waitStarted(t, high)
+
+	if err := finishCat(t, low); !errors.Is(err, ErrJobPreempted) {
+		t.Errorf("Expected ErrJobPreempted, got %v", err)
+	}
+	if err := finishCat(t, high); err != nil {
+		t.Fatalf("High priority job failed: %v", err)
+	}
+}
+
+// TestPool_CancelQueued tests that cancelled jobs leave the queue
+func TestPool_CancelQueued(t *testing.T) {
+	logger, _ := common.NewLogger("", "", common.LogLevelDebug, false)
+	p := NewPool(PoolOptions{MaxConcurrent: 1}, logger)
+
+	blocker := submitCat(t, p, 0, "")
+	waitStarted(t, blocker)
+
+	queued := submitCat(t, p, 0, "")
+	queued.Cancel()
+
+	if err := queued.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if n, _ := p.Len(); n != 0 {
+		t.Errorf("Expected empty queue, got %d jobs", n)
+	}
+
+	if err := finishCat(t, blocker); err != nil {
+		t.Fatalf("Blocker failed: %v", err)
+	}
+}
+
+// poolBackend is an execution backend using its pool while it pauses and
+// resumes the command, as slow backends (e.g. Docker) would take their time
+type poolBackend struct {
+	pool *Pool
+}
+
+func (b poolBackend) pause() error                    { b.pool.Len(); return nil }
+func (b poolBackend) resume() error                   { b.pool.Len(); return nil }
+func (b poolBackend) signal(sig syscall.Signal) error { return nil }
+
+// TestPool_PreemptPauseUnlocked tests that jobs are paused and resumed
+// without holding the mutex of the pool
+func TestPool_PreemptPauseUnlocked(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	p := NewPool(PoolOptions{MaxConcurrent: 1, Preemption: PreemptPause}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	low := &Job{ID: "low", pool: p, ctx: ctx, cancel: cancel, state: JobRunning, started: make(chan struct{}),
+		execution: &Execution{ID: "low", backend: poolBackend{pool: p}, logger: defaultLogger(nil)}}
+	close(low.started)
+	p.mu.Lock()
+	p.running = append(p.running, low)
+	p.mu.Unlock()
+
+	high := submitCat(t, p, 10, "")
+	waitStarted(t, high)
+	if state := low.State(); state != JobPaused || !low.execution.Paused() {
+		t.Errorf("Expected low priority job to be paused, got %s", state)
+	}
+
+	if err := finishCat(t, high); err != nil {
+		t.Fatalf("High priority job failed: %v", err)
+	}
+	waitState(t, low, JobRunning)
+	deadline := time.Now().Add(5 * time.Second)
+	for low.execution.Paused() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if low.execution.Paused() {
+		t.Errorf("Expected low priority job to be resumed")
+	}
+}
@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PublishedPort is a port of a sandboxed command made reachable from the host
+type PublishedPort struct {
+	// Name is the parameter name of the ports allocated with WithFreePorts
+	Name string `json:"name,omitempty"`
+
+	// Port is the port the command listens on
+	Port int `json:"port"`
+
+	// Protocol is "tcp" or "udp"
+	Protocol string `json:"protocol"`
+
+	// HostIP and HostPort are the address to connect to from the host
+	HostIP   string `json:"host_ip"`
+	HostPort int    `json:"host_port"`
+}
+
+// Address returns the host address of the port, as accepted by net.Dial
+func (p PublishedPort) Address() string {
+	return net.JoinHostPort(p.HostIP, strconv.Itoa(p.HostPort))
+}
+
+// Ports returns the ports of the command published in the host, with the
+// host ports allocated for them. It is empty for runners that do not
+// publish ports.
+func (e *Execution) Ports() []PublishedPort {
+	return e.ports
+}
+
+// publishPortRegexp matches the ports of the publish_ports option
+var publishPortRegexp = regexp.MustCompile(`^([0-9]{1,5})(/(tcp|udp))?$`)
+
+// parsePublishPort parses a port of the publish_ports option, as "8080" or "8080/udp"
+func parsePublishPort(spec string) (int, string, error) {
+	m := publishPortRegexp.FindStringSubmatch(spec)
+	if m == nil {
+		return 0, "", fmt.Errorf("invalid port %q: must be a port number, optionally followed by /tcp or /udp", spec)
+	}
+	port, _ := strconv.Atoi(m[1])
+	if port < 1 || port > 65535 {
+		return 0, "", fmt.Errorf("invalid port %q: out of range", spec)
+	}
+	protocol := m[3]
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	return port, protocol, nil
+}
+
+// dockerPublishArgs returns the `docker run` arguments publishing the ports
+// in random host ports of the given address
+func dockerPublishArgs(ports []string, address string) []string {
+	var args []string
+	for _, spec := range ports {
+		port, protocol, _ := parsePublishPort(spec)
+		args = append(args, "-p", fmt.Sprintf("%s::%d/%s", publishHost(address), port, protocol))
+	}
+	return args
+}
+
+// publishHost returns the host address of the -p argument of `docker run`,
+// where IPv6 addresses are enclosed in brackets
+func publishHost(address string) string {
+	if strings.Contains(address, ":") {
+		return "[" + address + "]"
+	}
+	return address
+}
+
+// dockerPublishedPorts returns the host ports the container engine allocated for the ports of a container
+func dockerPublishedPorts(ctx context.Context, engine string, containerName string, ports []string) ([]PublishedPort, error) {
+	var published []PublishedPort
+	for _, spec := range ports {
+		port, protocol, _ := parsePublishPort(spec)
+
+		output, err := commandContext(ctx, engine, "port", containerName, fmt.Sprintf("%d/%s", port, protocol)).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the host port of %s: %w: %s", spec, err, strings.TrimSpace(string(output)))
+		}
+
+		// docker prints a line per address, e.g. "127.0.0.1:49153" or "[::]:49153"
+		line := strings.TrimSpace(strings.SplitN(strings.TrimSpace(string(output)), "\n", 2)[0])
+		host, hostPort, err := net.SplitHostPort(line)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected docker port output %q: %w", line, err)
+		}
+		p, err := strconv.Atoi(hostPort)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected docker port output %q: %w", line, err)
+		}
+		published = append(published, PublishedPort{Port: port, Protocol: protocol, HostIP: host, HostPort: p})
+	}
+	return published, nil
+}
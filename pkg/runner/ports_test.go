@@ -0,0 +1,89 @@
+package runner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePublishPort(t *testing.T) {
+	tests := []struct {
+		spec         string
+		wantPort     int
+		wantProtocol string
+		wantErr      bool
+	}{
+		{spec: "8080", wantPort: 8080, wantProtocol: "tcp"},
+		{spec: "53/udp", wantPort: 53, wantProtocol: "udp"},
+		{spec: "0", wantErr: true},
+		{spec: "70000", wantErr: true},
+		{spec: "8080/sctp", wantErr: true},
+		{spec: "127.0.0.1:8080:80", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			port, protocol, err := parsePublishPort(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePublishPort() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if port != tt.wantPort || protocol != tt.wantProtocol {
+				t.Errorf("parsePublishPort() = %d, %q, want %d, %q", port, protocol, tt.wantPort, tt.wantProtocol)
+			}
+		})
+	}
+}
+
+func TestNewDockerOptions_PublishPorts(t *testing.T) {
+	opts, err := NewDockerOptions(Options{
+		"image":         "alpine",
+		"publish_ports": []interface{}{8080, "53/udp"},
+	})
+	if err != nil {
+		t.Fatalf("NewDockerOptions failed: %v", err)
+	}
+
+	want := []string{"-p", "127.0.0.1::8080/tcp", "-p", "127.0.0.1::53/udp"}
+	if got := dockerPublishArgs(opts.PublishPorts, opts.PublishAddress); !reflect.DeepEqual(got, want) {
+		t.Errorf("dockerPublishArgs() = %v, want %v", got, want)
+	}
+
+	_, err = NewDockerOptions(Options{
+		"image":            "alpine",
+		"allow_networking": false,
+		"publish_ports":    []string{"8080"},
+	})
+	if err == nil {
+		t.Errorf("NewDockerOptions should fail publishing ports without networking")
+	}
+
+	opts, err = NewDockerOptions(Options{
+		"image":           "alpine",
+		"publish_ports":   []string{"8080"},
+		"publish_address": "::1",
+	})
+	if err != nil {
+		t.Fatalf("NewDockerOptions failed: %v", err)
+	}
+	want = []string{"-p", "[::1]::8080/tcp"}
+	if got := dockerPublishArgs(opts.PublishPorts, opts.PublishAddress); !reflect.DeepEqual(got, want) {
+		t.Errorf("dockerPublishArgs() = %v, want %v", got, want)
+	}
+
+	for _, address := range []string{"localhost", "127.0.0.1:80", "[::1]", "0.0.0.0 -v /:/host"} {
+		_, err = NewDockerOptions(Options{
+			"image":           "alpine",
+			"publish_ports":   []string{"8080"},
+			"publish_address": address,
+		})
+		if err == nil {
+			t.Errorf("NewDockerOptions should fail with the publish address %q", address)
+		}
+	}
+}
+
+func TestPublishedPort_Address(t *testing.T) {
+	p := PublishedPort{Port: 80, Protocol: "tcp", HostIP: "::1", HostPort: 49153}
+	if got := p.Address(); got != "[::1]:49153" {
+		t.Errorf("Address() = %q", got)
+	}
+}
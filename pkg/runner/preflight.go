@@ -0,0 +1,101 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ErrPreflightFailed is returned when the preflight command of a runner (see
+// PreflightOptions) fails, before the command is run
+var ErrPreflightFailed = errors.New("preflight command failed")
+
+// maxPreflightOutput is the most output of a failed preflight command kept in
+// its error
+const maxPreflightOutput = 1024
+
+// PreflightOptions run a command in the sandbox before the commands
+type PreflightOptions struct {
+	// Preflight is a shell command run with the same restrictions (and
+	// parameters) before every command (e.g. "git --version && node
+	// --version"). The command is not run when the preflight command fails.
+	Preflight string `json:"preflight"`
+}
+
+// validatePreflight checks the preflight command
+func (o PreflightOptions) validatePreflight() error {
+	if o.Preflight != "" && strings.TrimSpace(o.Preflight) == "" {
+		return fmt.Errorf("the preflight command is empty")
+	}
+	return nil
+}
+
+// preflightRunKey is the context key marking the preflight commands, so they
+// are not preceded by the preflight command themselves
+type preflightRunKey struct{}
+
+// runPreflight runs the preflight command with the shell when it is set,
+// returning ErrPreflightFailed with its output when it fails
+func (o PreflightOptions) runPreflight(ctx context.Context, logger Logger, shell string,
+	start canaryStarter, params map[string]interface{}) error {
+	if o.Preflight == "" || ctx.Value(preflightRunKey{}) != nil {
+		return nil
+	}
+	ctx = context.WithValue(ctx, preflightRunKey{}, true)
+	// the canaries verify the sandbox of the command, not of the preflight command
+	ctx = context.WithValue(ctx, canaryRunKey{}, true)
+
+	logger.Debug("Running the preflight command: %s", o.Preflight)
+	cmd, args := getShellCommandArgs(shell, o.Preflight)
+	e, err := start(ctx, cmd, args, nil, params)
+	if err != nil {
+		return fmt.Errorf("%w: %q could not be started: %w", ErrPreflightFailed, o.Preflight, err)
+	}
+	_ = e.Stdin.Close()
+
+	// stdout and stderr are interleaved, as in a terminal
+	var output preflightOutput
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(&output, e.Stderr)
+	}()
+	_, _ = io.Copy(&output, e.Stdout)
+	wg.Wait()
+	if err := e.Wait(); err != nil {
+		logger.Error("The preflight command %q failed: %v", o.Preflight, err)
+		if detail := output.String(); detail != "" {
+			return fmt.Errorf("%w: %q: %s: %w", ErrPreflightFailed, o.Preflight, detail, err)
+		}
+		return fmt.Errorf("%w: %q: %w", ErrPreflightFailed, o.Preflight, err)
+	}
+	logger.Debug("The preflight command succeeded")
+	return nil
+}
+
+// preflightOutput is the output of a preflight command, keeping its last
+// maxPreflightOutput bytes, where the errors usually are
+type preflightOutput struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (o *preflightOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.buf = append(o.buf, p...)
+	if len(o.buf) > maxPreflightOutput {
+		o.buf = o.buf[len(o.buf)-maxPreflightOutput:]
+	}
+	return len(p), nil
+}
+
+func (o *preflightOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return strings.TrimSpace(string(o.buf))
+}
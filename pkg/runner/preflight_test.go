@@ -0,0 +1,63 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPreflightOptions_Validate(t *testing.T) {
+	if _, err := NewExec(Options{"preflight": "  "}, nil); err == nil {
+		t.Errorf("NewExec() with a blank preflight command should fail")
+	}
+
+	opts, err := NewDockerOptions(Options{"image": "alpine:latest", "preflight": "git --version"})
+	if err != nil {
+		t.Fatalf("NewDockerOptions failed: %v", err)
+	}
+	if opts.Preflight != "git --version" {
+		t.Errorf("Preflight = %q", opts.Preflight)
+	}
+}
+
+func TestExec_Preflight(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test uses a POSIX shell")
+	}
+	ctx := context.Background()
+	marker := filepath.Join(t.TempDir(), "ran")
+
+	r, err := NewExec(Options{"shell": "/bin/sh", "preflight": "echo checking; echo 'tool: not found' >&2; exit 127"}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	_, err = r.Run(ctx, "sh", "touch "+marker, nil, nil, false)
+	if !errors.Is(err, ErrPreflightFailed) {
+		t.Fatalf("Run() = %v, want ErrPreflightFailed", err)
+	}
+	if !strings.Contains(err.Error(), "tool: not found") || !strings.Contains(err.Error(), "checking") {
+		t.Errorf("the error does not include the output of the preflight command: %v", err)
+	}
+	if status := NormalizeExit(r, err); status.Kind != ErrorKindNotFound {
+		t.Errorf("NormalizeExit() = %+v, want not_found", status)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("the command ran after the preflight command failed")
+	}
+	if _, _, _, _, err := r.RunWithPipes(ctx, "touch", []string{marker}, nil, nil); !errors.Is(err, ErrPreflightFailed) {
+		t.Errorf("RunWithPipes() = %v, want ErrPreflightFailed", err)
+	}
+
+	r, err = NewExec(Options{"shell": "/bin/sh", "preflight": "sh --help >/dev/null 2>&1 || true"}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	output, err := r.Run(ctx, "sh", "echo ok", nil, nil, false)
+	if err != nil || output != "ok" {
+		t.Errorf("Run() = %q, %v", output, err)
+	}
+}
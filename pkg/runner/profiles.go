@@ -0,0 +1,315 @@
+package runner
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ProfileKey is the option referencing the profile the options of a runner
+// are based on, by name and version (e.g. "ci-build@v3") or by name for its
+// latest version
+const ProfileKey = "profile"
+
+// ErrProfileNotFound is returned for references to unknown profiles
+var ErrProfileNotFound = errors.New("profile not found")
+
+// ErrInvalidSignature is returned when loading profiles whose signature
+// cannot be verified
+var ErrInvalidSignature = errors.New("invalid profile signature")
+
+// profileSignatureExt is the extension of the detached signatures
+const profileSignatureExt = ".sig"
+
+// Profile is a named and versioned sandbox policy, managed centrally and
+// referenced by the options of runners with ProfileKey
+type Profile struct {
+	// Name of the profile
+	Name string `json:"name"`
+
+	// Version of the profile, starting at 1
+	Version int `json:"version"`
+
+	// Runner is the type of the runners the profile applies to, if limited
+	// to one
+	Runner Type `json:"runner,omitempty"`
+
+	// Description of the profile
+	Description string `json:"description,omitempty"`
+
+	// Options are the default options of the runners using the profile,
+	// overridden by their own options
+	Options Options `json:"options"`
+
+	// Floors are the options enforced by the profile: the runners using it
+	// cannot set them to a different value (see OptionsPolicy)
+	Floors Options `json:"floors,omitempty"`
+
+	// ListMerge is the merge of each list option (default: ListAppend)
+	ListMerge map[string]ListMerge `json:"list_merge,omitempty"`
+
+	// Source is the file the profile was loaded from
+	Source string `json:"-"`
+
+	// Digest is the SHA-256 of the file, for auditing
+	Digest string `json:"-"`
+
+	// SignedBy is the ID of the key that signed the profile (see ProfileKeyID), or
+	// "" when it is not signed
+	SignedBy string `json:"-"`
+
+	resolver *OptionsResolver
+}
+
+// Ref returns the reference of the profile, e.g. "ci-build@v3"
+func (p *Profile) Ref() string {
+	return fmt.Sprintf("%s@v%d", p.Name, p.Version)
+}
+
+// Apply returns the options of a runner of a type using the profile: the
+// options of the profile overridden by the given ones, with the floors of
+// the profile on top. An error wrapping ErrFloorViolation is returned when
+// the options violate the floors.
+func (p *Profile) Apply(runnerType Type, options Options) (Options, error) {
+	if p.Runner != "" && p.Runner != runnerType {
+		return nil, fmt.Errorf("profile %s is for the %s runner, not %s", p.Ref(), p.Runner, runnerType)
+	}
+	overrides := Options{}
+	for key, value := range options {
+		if key != ProfileKey {
+			overrides[key] = value
+		}
+	}
+	resolved, err := p.resolver.Resolve("", overrides)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %w", p.Ref(), err)
+	}
+	return resolved, nil
+}
+
+// ProfileStoreOptions are the options of LoadProfiles
+type ProfileStoreOptions struct {
+	// PublicKeys are the keys verifying the signatures of the profiles
+	PublicKeys []ed25519.PublicKey
+
+	// RequireSignatures rejects the profiles without a valid signature
+	RequireSignatures bool
+}
+
+// ProfileStore holds the profiles loaded with LoadProfiles
+type ProfileStore struct {
+	// profiles are the versions of every profile, sorted by version
+	profiles map[string][]*Profile
+}
+
+// LoadProfiles loads the profiles in the JSON files (*.json) of a folder of
+// a filesystem, such as an embed.FS or os.DirFS. A profile is signed with a
+// detached Ed25519 signature of its file, base64 encoded in a file with the
+// same name and the .sig suffix (e.g. ci-build-v3.json.sig, see
+// SignProfile). Signatures are verified with the public keys of the
+// options: an invalid signature is always an error, and unsigned profiles
+// are rejected with RequireSignatures.
+func LoadProfiles(fsys fs.FS, dir string, options ProfileStoreOptions) (*ProfileStore, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the profiles: %w", err)
+	}
+	store := &ProfileStore{profiles: map[string][]*Profile{}}
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		profile, err := loadProfile(fsys, path.Join(dir, entry.Name()), options)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.add(profile); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// loadProfile loads and verifies the profile of a file
+func loadProfile(fsys fs.FS, file string, options ProfileStoreOptions) (*Profile, error) {
+	data, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile %s: %w", file, err)
+	}
+
+	var profile Profile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("invalid profile %s: %w", file, err)
+	}
+	if profile.Name == "" || strings.ContainsAny(profile.Name, "@ ") {
+		return nil, fmt.Errorf("invalid profile %s: invalid name %q", file, profile.Name)
+	}
+	if profile.Version < 1 {
+		return nil, fmt.Errorf("invalid profile %s: the version must be 1 or higher", file)
+	}
+	profile.resolver, err = NewOptionsResolver(OptionsPolicy{
+		Defaults:  profile.Options,
+		Floors:    profile.Floors,
+		ListMerge: profile.ListMerge,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile %s: %w", file, err)
+	}
+
+	digest := sha256.Sum256(data)
+	profile.Source = file
+	profile.Digest = "sha256:" + hex.EncodeToString(digest[:])
+
+	signature, err := fs.ReadFile(fsys, file+profileSignatureExt)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if options.RequireSignatures {
+			return nil, fmt.Errorf("profile %s is not signed: %w", file, ErrInvalidSignature)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to read the signature of profile %s: %w", file, err)
+	default:
+		if profile.SignedBy, err = verifyProfile(data, signature, options.PublicKeys); err != nil {
+			return nil, fmt.Errorf("profile %s: %w", file, err)
+		}
+	}
+	return &profile, nil
+}
+
+// verifyProfile verifies the signature of a profile, returning the ID of the
+// key that signed it
+func verifyProfile(data []byte, signature []byte, keys []ed25519.PublicKey) (string, error) {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, data, sig) {
+			return ProfileKeyID(key), nil
+		}
+	}
+	return "", fmt.Errorf("%w: not signed by any of the %d public keys", ErrInvalidSignature, len(keys))
+}
+
+// SignProfile returns the detached signature of the file of a profile, to
+// be written next to it with the .sig suffix
+func SignProfile(data []byte, key ed25519.PrivateKey) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)) + "\n")
+}
+
+// ProfileKeyID returns the ID of a public key signing profiles: the first 8
+// bytes of its SHA-256, hex encoded
+func ProfileKeyID(key ed25519.PublicKey) string {
+	digest := sha256.Sum256(key)
+	return hex.EncodeToString(digest[:8])
+}
+
+// add adds a profile, rejecting duplicated versions
+func (s *ProfileStore) add(profile *Profile) error {
+	versions := s.profiles[profile.Name]
+	for _, p := range versions {
+		if p.Version == profile.Version {
+			return fmt.Errorf("profile %s is defined in %s and %s", profile.Ref(), p.Source, profile.Source)
+		}
+	}
+	versions = append(versions, profile)
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	s.profiles[profile.Name] = versions
+	return nil
+}
+
+// Get returns the profile of a reference: a name and a version (e.g.
+// "ci-build@v3" or "ci-build@3"), or a name for its latest version. An error
+// wrapping ErrProfileNotFound is returned for unknown profiles.
+func (s *ProfileStore) Get(ref string) (*Profile, error) {
+	name, version, hasVersion := strings.Cut(ref, "@")
+	versions := s.profiles[name]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrProfileNotFound, ref)
+	}
+	if !hasVersion {
+		return versions[len(versions)-1], nil
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(version, "v"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile reference %q: %w", ref, err)
+	}
+	for _, p := range versions {
+		if p.Version == n {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrProfileNotFound, ref)
+}
+
+// Profiles returns the references of all the profiles, sorted by name and
+// version
+func (s *ProfileStore) Profiles() []string {
+	names := make([]string, 0, len(s.profiles))
+	for name := range s.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var refs []string
+	for _, name := range names {
+		for _, p := range s.profiles[name] {
+			refs = append(refs, p.Ref())
+		}
+	}
+	return refs
+}
+
+var (
+	profilesMu sync.RWMutex
+	profiles   *ProfileStore
+)
+
+// UseProfiles sets the profiles referenced by the options of the runners
+// created with New (nil for none)
+func UseProfiles(store *ProfileStore) {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+	profiles = store
+}
+
+// applyProfile returns the options of a runner with the profile they
+// reference applied, if any
+func applyProfile(runnerType Type, options Options, logger Logger) (Options, error) {
+	ref, ok := options[ProfileKey]
+	if !ok {
+		return options, nil
+	}
+	name, ok := ref.(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("invalid %s option: %v", ProfileKey, ref)
+	}
+
+	profilesMu.RLock()
+	store := profiles
+	profilesMu.RUnlock()
+	if store == nil {
+		return nil, fmt.Errorf("cannot use profile %s: %w (no profiles loaded, see UseProfiles)", name, ErrProfileNotFound)
+	}
+
+	profile, err := store.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	signedBy := profile.SignedBy
+	if signedBy == "" {
+		signedBy = "unsigned"
+	}
+	logger.Info("Using profile %s for the %s runner (%s, %s, key %s)", profile.Ref(), runnerType, profile.Source, profile.Digest, signedBy)
+	return profile.Apply(runnerType, options)
+}
@@ -0,0 +1,124 @@
+package runner
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const ciBuildV2 = `{"name": "ci-build", "version": 2, "runner": "exec", "options": {"shell": "/bin/sh"}}`
+
+const ciBuildV3 = `{
+	"name": "ci-build",
+	"version": 3,
+	"runner": "exec",
+	"options": {"shell": "/bin/bash", "extra_path": ["/opt/ci/bin"]},
+	"floors": {"hermetic_tools": ["sh", "make"]}
+}`
+
+func TestLoadProfiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"profiles/ci-build-v2.json": {Data: []byte(ciBuildV2)},
+		"profiles/ci-build-v3.json": {Data: []byte(ciBuildV3)},
+		"profiles/README.md":        {Data: []byte("not a profile")},
+	}
+	store, err := LoadProfiles(fsys, "profiles", ProfileStoreOptions{})
+	if err != nil {
+		t.Fatalf("LoadProfiles failed: %v", err)
+	}
+	if got := strings.Join(store.Profiles(), ","); got != "ci-build@v2,ci-build@v3" {
+		t.Errorf("Profiles() = %s", got)
+	}
+
+	latest, err := store.Get("ci-build")
+	if err != nil || latest.Version != 3 || latest.SignedBy != "" || !strings.HasPrefix(latest.Digest, "sha256:") {
+		t.Errorf("Get(ci-build) = %+v, %v", latest, err)
+	}
+	if p, err := store.Get("ci-build@2"); err != nil || p.Version != 2 {
+		t.Errorf("Get(ci-build@2) = %+v, %v", p, err)
+	}
+	if _, err := store.Get("ci-build@v4"); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("Get() of an unknown version = %v", err)
+	}
+
+	options, err := latest.Apply(TypeExec, Options{ProfileKey: "ci-build", "extra_path": []string{"/usr/local/bin"}})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if options["shell"] != "/bin/bash" || len(options["extra_path"].([]interface{})) != 2 || options[ProfileKey] != nil {
+		t.Errorf("Apply() = %v", options)
+	}
+	if _, err := latest.Apply(TypeExec, Options{"hermetic_tools": []string{"curl"}}); err != nil {
+		t.Errorf("Apply() adding to a list floor failed: %v", err)
+	}
+	if _, err := latest.Apply(TypeDocker, Options{}); err == nil {
+		t.Errorf("Apply() to another runner type should fail")
+	}
+
+	fsys["profiles/dup.json"] = &fstest.MapFile{Data: []byte(ciBuildV3)}
+	if _, err := LoadProfiles(fsys, "profiles", ProfileStoreOptions{}); err == nil {
+		t.Errorf("LoadProfiles() with a duplicated version should fail")
+	}
+}
+
+func TestLoadProfiles_signatures(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, _, _ := ed25519.GenerateKey(nil)
+
+	fsys := fstest.MapFS{
+		"ci-build-v3.json":     {Data: []byte(ciBuildV3)},
+		"ci-build-v3.json.sig": {Data: SignProfile([]byte(ciBuildV3), private)},
+	}
+	store, err := LoadProfiles(fsys, ".", ProfileStoreOptions{PublicKeys: []ed25519.PublicKey{otherPublic, public}, RequireSignatures: true})
+	if err != nil {
+		t.Fatalf("LoadProfiles failed: %v", err)
+	}
+	if p, _ := store.Get("ci-build@v3"); p.SignedBy != ProfileKeyID(public) {
+		t.Errorf("SignedBy = %q, want %q", p.SignedBy, ProfileKeyID(public))
+	}
+
+	if _, err := LoadProfiles(fsys, ".", ProfileStoreOptions{PublicKeys: []ed25519.PublicKey{otherPublic}}); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("LoadProfiles() signed by an unknown key = %v", err)
+	}
+	fsys["ci-build-v3.json"] = &fstest.MapFile{Data: []byte(strings.Replace(ciBuildV3, "/bin/bash", "/bin/zsh", 1))}
+	if _, err := LoadProfiles(fsys, ".", ProfileStoreOptions{PublicKeys: []ed25519.PublicKey{public}}); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("LoadProfiles() of a modified profile = %v", err)
+	}
+
+	unsigned := fstest.MapFS{"ci-build-v2.json": {Data: []byte(ciBuildV2)}}
+	if _, err := LoadProfiles(unsigned, ".", ProfileStoreOptions{RequireSignatures: true}); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("LoadProfiles() of an unsigned profile with RequireSignatures = %v", err)
+	}
+}
+
+func TestNew_profile(t *testing.T) {
+	store, err := LoadProfiles(fstest.MapFS{"ci.json": {Data: []byte(ciBuildV3)}}, ".", ProfileStoreOptions{})
+	if err != nil {
+		t.Fatalf("LoadProfiles failed: %v", err)
+	}
+	UseProfiles(nil)
+	if _, err := New(TypeExec, Options{ProfileKey: "ci-build@v3"}, nil); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("New() without profiles = %v", err)
+	}
+
+	UseProfiles(store)
+	defer UseProfiles(nil)
+	r, err := New(TypeExec, Options{ProfileKey: "ci-build@v3"}, nil)
+	if err != nil {
+		t.Fatalf("New() with a profile failed: %v", err)
+	}
+	if shell := r.(*Exec).options.Shell; shell != "/bin/bash" {
+		t.Errorf("shell = %q, want the one of the profile", shell)
+	}
+	if _, err := New(TypeExec, Options{ProfileKey: "ci-build@v3", "hermetic_tools": []string{}}, nil); err != nil {
+		t.Errorf("New() with an empty list floor failed: %v", err)
+	}
+	if _, err := New(TypeExec, Options{ProfileKey: "ci-build@v3", "hermetic_tools": "sh"}, nil); !errors.Is(err, ErrFloorViolation) {
+		t.Errorf("New() violating the floors of the profile = %v", err)
+	}
+}
@@ -0,0 +1,342 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// defaultPromptContextLines is the number of lines of output sent with the
+// prompts when the policy does not set it
+const defaultPromptContextLines = 20
+
+// PromptRule selects the prompts of a command requiring an approval, such
+// as the password prompt of sudo or the confirmation of a package manager
+type PromptRule struct {
+	// Name identifies the rule in the requests and the audit
+	Name string `json:"name"`
+
+	// Pattern is a regular expression matching the prompt. It is matched
+	// against every line of output, including the last one before the
+	// command waits for its input (which prompts usually do not end).
+	Pattern string `json:"pattern"`
+
+	// Input is written to the command when the prompt is approved, unless
+	// the decision has its own input (e.g. "y\n")
+	Input string `json:"input,omitempty"`
+
+	// DenyInput is written to the command when the prompt is denied (e.g.
+	// "n\n"). The command is killed when it is empty.
+	DenyInput string `json:"deny_input,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// PromptApprovalPolicy is the configuration of WithPromptApproval
+type PromptApprovalPolicy struct {
+	// Rules select the prompts requiring an approval
+	Rules []PromptRule
+
+	// Approver decides on the prompts matching the rules, seeing the
+	// prompt and the output before it
+	Approver Approver
+
+	// Timeout is the time to wait for a decision, after which the prompt
+	// is denied (default: DefaultApprovalTimeout)
+	Timeout time.Duration
+
+	// ContextLines is the number of lines of output before the prompt
+	// sent with it (default: 20)
+	ContextLines int
+
+	// Audit receives every decision, or failure to obtain one
+	Audit func(ApprovalRecord)
+}
+
+// WithPromptApproval mirrors the prompts of the command matching the rules
+// of the policy to its approver (e.g. a human auditor), which answers them
+// on behalf of the caller, while the rest of the input and output flows
+// as usual. This allows the supervised execution of maintenance commands
+// asking for confirmations or passwords.
+//
+// The prompts are found in the output of the command as read by the
+// caller, so the output must be read. While a prompt waits for its
+// decision, the writes of the caller to Stdin (and its closing) are held.
+// The decisions are returned by Execution.PromptApprovals, and emitted as
+// EventPromptApproval events.
+func WithPromptApproval(policy PromptApprovalPolicy) ExecOption {
+	return func(c *execConfig) {
+		c.prompts = &policy
+	}
+}
+
+// PromptApprovals returns the approvals of the prompts of the execution so
+// far (see WithPromptApproval)
+func (e *Execution) PromptApprovals() []ApprovalRecord {
+	if e.prompts == nil {
+		return nil
+	}
+	e.prompts.mu.Lock()
+	defer e.prompts.mu.Unlock()
+	return append([]ApprovalRecord(nil), e.prompts.records...)
+}
+
+// validate compiles the patterns of the rules and sets the defaults
+func (p *PromptApprovalPolicy) validate() error {
+	if p.Approver == nil {
+		return fmt.Errorf("prompt approval requires an approver")
+	}
+	if len(p.Rules) == 0 {
+		return fmt.Errorf("prompt approval requires at least a rule")
+	}
+	rules := make([]PromptRule, len(p.Rules))
+	for i, rule := range p.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("prompt-%d", i+1)
+		}
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern of prompt rule %s: %w", rule.Name, err)
+		}
+		rule.pattern = pattern
+		rules[i] = rule
+	}
+	p.Rules = rules
+	if p.Timeout <= 0 {
+		p.Timeout = DefaultApprovalTimeout
+	}
+	if p.ContextLines <= 0 {
+		p.ContextLines = defaultPromptContextLines
+	}
+	return nil
+}
+
+// promptWatcher finds the prompts in the output of an execution and
+// answers them with the decisions of the approver
+type promptWatcher struct {
+	ctx        context.Context
+	e          *Execution
+	policy     *PromptApprovalPolicy
+	runnerType Type
+	command    string
+	emitter    *eventEmitter
+	stdin      io.WriteCloser
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	lines   [][]byte
+	pending bool
+	writing int
+	records []ApprovalRecord
+}
+
+// attachPromptApproval watches the output pipes of the execution for the
+// prompts of the policy, holding the writes to its input while they wait
+// for a decision
+func attachPromptApproval(ctx context.Context, e *Execution, policy *PromptApprovalPolicy, runnerType Type,
+	command string, emitter *eventEmitter,
+) {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &promptWatcher{ctx: ctx, e: e, policy: policy, runnerType: runnerType, command: command,
+		emitter: emitter, stdin: e.Stdin}
+	w.cond = sync.NewCond(&w.mu)
+	e.prompts = w
+	e.exitHooks = append(e.exitHooks, cancel)
+	e.Stdin = &promptWriter{w: w}
+	e.Stdout = &promptReader{ReadCloser: e.Stdout, w: w}
+	e.Stderr = &promptReader{ReadCloser: e.Stderr, w: w}
+}
+
+// scan looks for a prompt in a line of output, complete or not. It
+// returns whether the line matched a rule.
+func (w *promptWatcher) scan(line []byte, complete bool) bool {
+	for i := range w.policy.Rules {
+		rule := &w.policy.Rules[i]
+		if !rule.pattern.Match(line) {
+			continue
+		}
+
+		w.mu.Lock()
+		if w.pending {
+			w.mu.Unlock()
+			w.e.logger.Warn("Prompt of rule %s in execution %s ignored: another prompt is waiting for approval",
+				rule.Name, w.e.ID)
+			return true
+		}
+		w.pending = true
+		output := bytes.Join(w.lines, []byte("\n"))
+		w.mu.Unlock()
+
+		go w.approve(rule, string(line), string(output))
+		return true
+	}
+	if complete {
+		w.mu.Lock()
+		w.lines = append(w.lines, append([]byte(nil), line...))
+		if len(w.lines) > w.policy.ContextLines {
+			w.lines = w.lines[len(w.lines)-w.policy.ContextLines:]
+		}
+		w.mu.Unlock()
+	}
+	return false
+}
+
+// approve requests the approval of a prompt, and answers it with the decision
+func (w *promptWatcher) approve(rule *PromptRule, prompt string, output string) {
+	req := ApprovalRequest{
+		ID:        newExecutionID(),
+		Runner:    w.runnerType,
+		Command:   w.command,
+		Rules:     []string{rule.Name},
+		Prompt:    prompt,
+		Output:    output,
+		Requested: time.Now(),
+	}
+	w.e.logger.Info("Prompt of execution %s requires approval (rule %s): %s", w.e.ID, rule.Name, prompt)
+
+	approveCtx, cancel := context.WithTimeout(w.ctx, w.policy.Timeout)
+	defer cancel()
+	decision, err := w.policy.Approver.Approve(approveCtx, req)
+	if err == nil && approveCtx.Err() != nil {
+		err = approveCtx.Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) && w.ctx.Err() == nil {
+		err = ErrApprovalTimeout
+	}
+
+	input := decision.Input
+	record := ApprovalRecord{Request: req, Decision: decision, Waited: time.Since(req.Requested)}
+	// the input (e.g. a password) is not audited
+	record.Decision.Input = ""
+	switch {
+	case err != nil:
+		record.Decision = ApprovalDecision{}
+		record.Error = err.Error()
+		w.e.logger.Warn("Prompt of execution %s not approved: %v", w.e.ID, err)
+	case !decision.Approved:
+		w.e.logger.Warn("Prompt of execution %s denied by %s: %s", w.e.ID, decision.Approver, decision.Reason)
+	default:
+		w.e.logger.Info("Prompt of execution %s approved by %s: %s", w.e.ID, decision.Approver, decision.Reason)
+		if input == "" {
+			input = rule.Input
+		}
+	}
+	if err != nil || !decision.Approved {
+		input = rule.DenyInput
+		if input == "" && w.ctx.Err() == nil {
+			if err := w.e.kill(); err != nil {
+				w.e.logger.Warn("Failed to kill execution %s: %v", w.e.ID, err)
+				// without its input, the command cannot wait for an answer forever
+				_ = w.stdin.Close()
+			}
+		}
+	}
+
+	w.answer(input)
+
+	if w.policy.Audit != nil {
+		w.policy.Audit(record)
+	}
+	w.mu.Lock()
+	w.records = append(w.records, record)
+	w.mu.Unlock()
+	w.emitter.emit(Event{Type: EventPromptApproval, Approval: &record})
+}
+
+// answer writes the answer of a prompt, once the writes of the caller in
+// progress have completed, and releases the writes held
+func (w *promptWatcher) answer(input string) {
+	w.mu.Lock()
+	for w.writing > 0 {
+		w.cond.Wait()
+	}
+	w.mu.Unlock()
+
+	if input != "" && w.ctx.Err() == nil {
+		if _, err := io.WriteString(w.stdin, input); err != nil {
+			w.e.logger.Warn("Failed to answer the prompt of execution %s: %v", w.e.ID, err)
+		}
+	}
+
+	w.mu.Lock()
+	w.pending = false
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+// promptWriter holds the writes to the input of the command while a prompt
+// waits for its decision
+type promptWriter struct {
+	w *promptWatcher
+}
+
+func (p *promptWriter) Write(data []byte) (int, error) {
+	w := p.w
+	w.mu.Lock()
+	for w.pending {
+		w.cond.Wait()
+	}
+	w.writing++
+	w.mu.Unlock()
+
+	n, err := w.stdin.Write(data)
+
+	w.mu.Lock()
+	w.writing--
+	w.cond.Broadcast()
+	w.mu.Unlock()
+	return n, err
+}
+
+// Close closes the input of the command once the prompt waiting for its
+// decision, if any, has been answered
+func (p *promptWriter) Close() error {
+	w := p.w
+	w.mu.Lock()
+	for w.pending {
+		w.cond.Wait()
+	}
+	w.mu.Unlock()
+	return w.stdin.Close()
+}
+
+// promptReader splits the output read from a pipe in lines for the prompt
+// watcher, which also sees the last line before it is complete
+type promptReader struct {
+	io.ReadCloser
+	w *promptWatcher
+
+	line []byte
+	// matched is whether the current line already matched a rule
+	matched bool
+}
+
+func (r *promptReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	data := p[:n]
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			r.line = append(r.line, data...)
+			if len(r.line) > maxDetectorLine {
+				r.line = r.line[len(r.line)-maxDetectorLine:]
+			}
+			if !r.matched {
+				r.matched = r.w.scan(r.line, false)
+			}
+			break
+		}
+		r.line = append(r.line, data[:i]...)
+		if !r.matched {
+			r.w.scan(bytes.TrimSuffix(r.line, []byte("\r")), true)
+		}
+		r.line, r.matched = r.line[:0], false
+		data = data[i+1:]
+	}
+	return n, err
+}
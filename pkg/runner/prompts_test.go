@@ -0,0 +1,135 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startPrompted starts a shell script with the exec runner and the prompt
+// approval policy, returning its output once it has completed
+func startPrompted(t *testing.T, script string, policy PromptApprovalPolicy) (*Execution, string, []Event) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	events := make(chan Event, 100)
+	e, err := Start(context.Background(), r, "sh", []string{"-c", script}, nil, nil,
+		WithPromptApproval(policy), WithEventChannel(events))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	go func() { _, _ = io.ReadAll(e.Stderr) }()
+	output, _ := io.ReadAll(e.Stdout)
+	_ = e.Stdin.Close()
+	_ = e.Wait()
+
+	var approvals []Event
+	for len(events) > 0 {
+		if ev := <-events; ev.Type == EventPromptApproval {
+			approvals = append(approvals, ev)
+		}
+	}
+	return e, string(output), approvals
+}
+
+func TestPromptApproval_approved(t *testing.T) {
+	var got ApprovalRequest
+	approver := ApproverFunc(func(ctx context.Context, req ApprovalRequest) (ApprovalDecision, error) {
+		got = req
+		return ApprovalDecision{Approved: true, Approver: "alice", Input: "secret\n"}, nil
+	})
+	e, output, events := startPrompted(t,
+		`echo "updating packages"; printf "[sudo] password for bob: " >&2; read p; echo "got $p"; printf "Continue? [y/N] "; read c; echo "answer $c"`,
+		PromptApprovalPolicy{
+			Rules: []PromptRule{
+				{Name: "sudo", Pattern: `password for \w+:`},
+				{Name: "confirm", Pattern: `Continue\? \[y/N\]`, Input: "y\n"},
+			},
+			Approver: approver,
+		})
+
+	if !strings.Contains(output, "got secret") || !strings.Contains(output, "answer secret") {
+		t.Errorf("output = %q, want the answers of the approver", output)
+	}
+	if got.Prompt != "Continue? [y/N] " || !strings.Contains(got.Output, "got secret") || got.Rules[0] != "confirm" {
+		t.Errorf("request = %+v, want the prompt and the output before it", got)
+	}
+	approvals := e.PromptApprovals()
+	if len(approvals) != 2 || len(events) != 2 {
+		t.Fatalf("approvals = %+v (%d events), want 2", approvals, len(events))
+	}
+	if approvals[0].Request.Rules[0] != "sudo" || approvals[0].Decision.Approver != "alice" {
+		t.Errorf("approval = %+v", approvals[0])
+	}
+	if approvals[0].Decision.Input != "" {
+		t.Error("the input of the decision was audited")
+	}
+}
+
+func TestPromptApproval_denied(t *testing.T) {
+	deny := ApproverFunc(func(ctx context.Context, req ApprovalRequest) (ApprovalDecision, error) {
+		return ApprovalDecision{Reason: "not now"}, nil
+	})
+
+	_, output, _ := startPrompted(t, `printf "Continue? [y/N] "; read c; echo "answer $c"`,
+		PromptApprovalPolicy{Rules: []PromptRule{{Pattern: `\[y/N\]`, Input: "y\n", DenyInput: "n\n"}}, Approver: deny})
+	if !strings.Contains(output, "answer n") {
+		t.Errorf("output = %q, want the deny input", output)
+	}
+
+	start := time.Now()
+	e, output, _ := startPrompted(t, `printf "Password: "; sleep 30; echo done`,
+		PromptApprovalPolicy{Rules: []PromptRule{{Pattern: `^Password:`}}, Approver: deny})
+	if time.Since(start) > 20*time.Second || strings.Contains(output, "done") {
+		t.Errorf("the command was not killed: %q", output)
+	}
+	if approvals := e.PromptApprovals(); len(approvals) != 1 || approvals[0].Decision.Approved {
+		t.Errorf("approvals = %+v, want a denial", approvals)
+	}
+}
+
+func TestPromptApproval_timeout(t *testing.T) {
+	slow := ApproverFunc(func(ctx context.Context, req ApprovalRequest) (ApprovalDecision, error) {
+		<-ctx.Done()
+		return ApprovalDecision{}, ctx.Err()
+	})
+	e, output, _ := startPrompted(t, `printf "Continue? [y/N] "; read c; echo "answer $c"`,
+		PromptApprovalPolicy{
+			Rules:    []PromptRule{{Pattern: `\[y/N\]`, DenyInput: "n\n"}},
+			Approver: slow,
+			Timeout:  100 * time.Millisecond,
+		})
+	if !strings.Contains(output, "answer n") {
+		t.Errorf("output = %q, want the deny input", output)
+	}
+	if approvals := e.PromptApprovals(); len(approvals) != 1 || approvals[0].Error != ErrApprovalTimeout.Error() {
+		t.Errorf("approvals = %+v, want a timeout", approvals)
+	}
+}
+
+func TestPromptApprovalPolicy_validate(t *testing.T) {
+	approver := ApproverFunc(func(ctx context.Context, req ApprovalRequest) (ApprovalDecision, error) {
+		return ApprovalDecision{}, nil
+	})
+	if err := (&PromptApprovalPolicy{Rules: []PromptRule{{Pattern: "x"}}}).validate(); err == nil {
+		t.Error("validate() should fail without an approver")
+	}
+	if err := (&PromptApprovalPolicy{Rules: []PromptRule{{Pattern: "("}}, Approver: approver}).validate(); err == nil {
+		t.Error("validate() should fail for an invalid pattern")
+	}
+	policy := &PromptApprovalPolicy{Rules: []PromptRule{{Pattern: "x"}}, Approver: approver}
+	if err := policy.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Rules[0].Name != "prompt-1" || policy.Timeout != DefaultApprovalTimeout || policy.ContextLines != 20 {
+		t.Errorf("policy = %+v, want the defaults", policy)
+	}
+}
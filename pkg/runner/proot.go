@@ -0,0 +1,450 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// Proot implements the Runner interface using proot on Linux.
+//
+// proot emulates chroot, mount --bind and binfmt_misc in user space (through
+// ptrace), so commands can run inside an alternative root filesystem without
+// root privileges, namespaces or a container engine. This makes it usable on
+// locked-down shared hosts (HPC login nodes, CI runners) where neither Docker
+// nor user namespaces are permitted.
+//
+// Note that proot is not a security boundary in the same sense as Landlock or
+// Docker: it only changes the filesystem view of the command, and networking
+// is not restricted at all.
+type Proot struct {
+	logger  Logger
+	options ProotOptions
+}
+
+// ProotOptions is the options for the Proot runner
+type ProotOptions struct {
+	// ProotPath is the proot executable to use (defaults to "proot" in PATH)
+	ProotPath string `json:"proot_path"`
+
+	// RootFS is the directory used as the guest root filesystem (required)
+	RootFS string `json:"rootfs"`
+
+	// Binds are host paths made visible in the guest, as "host" or "host:guest"
+	Binds []string `json:"binds"`
+
+	// WorkDir is the working directory inside the guest
+	WorkDir string `json:"workdir"`
+
+	// Shell is the shell used inside the guest (defaults to "/bin/sh")
+	Shell string `json:"shell"`
+
+	// RootID makes the command believe it runs as root (proot -0)
+	RootID bool `json:"root_id"`
+
+	// KernelRelease is the kernel release reported to the command (proot -k)
+	KernelRelease string `json:"kernel_release"`
+
+	// Umask and file mode policy
+	FileModeOptions
+
+	// Core dump policy
+	CoreDumpOptions
+
+	// Clock and locale settings
+	DeterminismOptions
+
+	// Variables of the commands read from .env files
+	EnvFileOptions
+
+	// Experimental features
+	ExperimentalOptions
+
+	// CA certificates trusted by the command
+	CABundleOptions
+
+	// Access to the credential agents of the host
+	AgentOptions
+
+	// Access to the display server of the host
+	DisplayOptions
+
+	// Hostname aliases, bound at /etc/hosts in the guest
+	HostsOptions
+
+	// Timeout of the commands
+	TimeoutOptions
+}
+
+// NewProotOptions creates a new ProotOptions from Options
+func NewProotOptions(options Options) (ProotOptions, error) {
+	var opts ProotOptions
+	jsonStr, err := options.ToJSON()
+	if err != nil {
+		return ProotOptions{}, err
+	}
+	err = json.Unmarshal([]byte(jsonStr), &opts)
+	return opts, err
+}
+
+// NewProot creates a new Proot runner with the provided logger.
+// If logger is nil, a default logger is created.
+func NewProot(options Options, logger Logger) (*Proot, error) {
+	logger = defaultLogger(logger)
+
+	prootOpts, err := NewProotOptions(options)
+	if err != nil {
+		logger.Debug("Failed to parse proot options: %v", err)
+		return nil, fmt.Errorf("failed to parse proot options: %w", err)
+	}
+	if err := prootOpts.validate(); err != nil {
+		return nil, fmt.Errorf("invalid proot options: %w", err)
+	}
+	if err := prootOpts.validateHosts(); err != nil {
+		return nil, fmt.Errorf("invalid proot options: %w", err)
+	}
+	if err := prootOpts.validateCABundle(); err != nil {
+		return nil, fmt.Errorf("invalid proot options: %w", err)
+	}
+	if err := prootOpts.validateTimeouts(); err != nil {
+		return nil, fmt.Errorf("invalid proot options: %w", err)
+	}
+	if err := prootOpts.loadEnvFiles(); err != nil {
+		return nil, fmt.Errorf("invalid proot options: %w", err)
+	}
+	if err := prootOpts.validateCoreDumps(); err != nil {
+		return nil, fmt.Errorf("invalid proot options: %w", err)
+	}
+
+	if prootOpts.RootFS == "" {
+		return nil, fmt.Errorf("proot runner requires 'rootfs' option")
+	}
+
+	return &Proot{
+		logger:  logger,
+		options: prootOpts,
+	}, nil
+}
+
+// prootPath returns the proot executable to use
+func (r *Proot) prootPath() string {
+	if r.options.ProotPath != "" {
+		return r.options.ProotPath
+	}
+	return "proot"
+}
+
+// rootFS returns the guest root filesystem, with template variables replaced with params
+func (r *Proot) rootFS(params map[string]interface{}) string {
+	return common.ProcessTemplateListFlexible([]string{r.options.RootFS}, params)[0]
+}
+
+// hostsFile writes the hosts file of the guest when there are extra hosts
+// (see HostsOptions). The caller must remove it.
+func (r *Proot) hostsFile(params map[string]interface{}) (string, error) {
+	return r.options.writeHostsFile(filepath.Join(r.rootFS(params), "etc", "hosts"))
+}
+
+// prootArgs builds the proot arguments that set up the guest filesystem view.
+// Template variables in the root filesystem, binds and working directory are
+// replaced with the given params. The hosts file, when not empty, is bound
+// at /etc/hosts in the guest.
+func (r *Proot) prootArgs(params map[string]interface{}, hostsFile string) []string {
+	rootFS := r.rootFS(params)
+	args := []string{"-r", rootFS}
+
+	binds := common.ProcessTemplateListFlexible(r.options.Binds, params)
+	for _, bind := range binds {
+		args = append(args, "-b", bind)
+	}
+
+	// Hide the agent and display sockets visible in the guest
+	hidden := append(r.options.BlockedAgentPaths(), r.options.BlockedDisplayPaths()...)
+	for _, path := range guestPaths(hidden, rootFS, binds) {
+		args = append(args, "-b", "/dev/null:"+path)
+	}
+
+	if hostsFile != "" {
+		args = append(args, "-b", hostsFile+":/etc/hosts")
+	}
+
+	if r.options.CABundle != "" {
+		args = append(args, "-b", r.options.CABundle+":"+caBundleGuestPath)
+	}
+
+	// Staged input files are visible at the same path in the guest
+	if dir := inputsDir(params); dir != "" {
+		args = append(args, "-b", dir)
+	}
+
+	// The workspace and scratch directories are writable at the same path in the guest
+	for _, dir := range writableDirs(params) {
+		args = append(args, "-b", dir)
+	}
+
+	if r.options.WorkDir != "" {
+		workDir := common.ProcessTemplateListFlexible([]string{r.options.WorkDir}, params)[0]
+		args = append(args, "-w", workDir)
+	}
+
+	if r.options.RootID {
+		args = append(args, "-0")
+	}
+
+	if r.options.KernelRelease != "" {
+		args = append(args, "-k", r.options.KernelRelease)
+	}
+
+	return args
+}
+
+// guestPaths returns the guest paths of the given host paths, when the
+// guest sees them through the root filesystem (the host root) or a bind
+func guestPaths(paths []string, rootFS string, binds []string) []string {
+	var guestPaths []string
+	for _, path := range paths {
+		if filepath.Clean(rootFS) == "/" {
+			guestPaths = append(guestPaths, path)
+			continue
+		}
+		for _, bind := range binds {
+			host, guest, found := strings.Cut(bind, ":")
+			if !found {
+				guest = host
+			}
+			if rel, err := filepath.Rel(host, path); err == nil && isSubPath(host, path) {
+				guestPaths = append(guestPaths, filepath.Join(guest, rel))
+			}
+		}
+	}
+	return guestPaths
+}
+
+// guestShell returns the shell to use inside the guest
+func (r *Proot) guestShell(shell string) string {
+	if shell != "" {
+		return shell
+	}
+	if r.options.Shell != "" {
+		return r.options.Shell
+	}
+	// the host $SHELL is meaningless inside another root filesystem
+	return "/bin/sh"
+}
+
+// Run executes a command inside the proot guest and returns the output.
+// It implements the Runner interface.
+//
+// note: tmpfile is ignored for proot because the host temporary directory is
+// not visible inside the guest
+func (r *Proot) Run(ctx context.Context, shell string, command string,
+	env []string, params map[string]interface{}, tmpfile bool,
+) (string, error) {
+	return r.options.runWithTimeout(ctx, func(ctx context.Context) (string, error) {
+		return r.run(ctx, shell, command, env, params, tmpfile)
+	})
+}
+
+// run is Run, with the context bounded by the timeout options
+func (r *Proot) run(ctx context.Context, shell string, command string,
+	env []string, params map[string]interface{}, tmpfile bool,
+) (string, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+	env = r.options.caBundleEnv(env, caBundleGuestPath)
+
+	// Check if context is done
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	default:
+		// Continue execution
+	}
+
+	hostsFile, err := r.hostsFile(params)
+	if err != nil {
+		return "", err
+	}
+	if hostsFile != "" {
+		defer func() {
+			if err := os.Remove(hostsFile); err != nil {
+				logger.Debug("Warning: failed to remove hosts file: %v", err)
+			}
+		}()
+	}
+
+	args := r.prootArgs(params, hostsFile)
+	args = append(args, r.guestShell(shell), "-c", command)
+
+	execCmd := commandContext(ctx, r.prootPath(), args...)
+	logger.Debug("Created command: %s", execCmd.String())
+
+	// Set environment variables if provided
+	if len(env) > 0 {
+		logger.Debug("Adding %d environment variables to command", len(env))
+		for _, e := range env {
+			categoryLogger(logger, LogCategoryEnvironment).Debug("... adding environment variable: %s", e)
+		}
+		execCmd.Env = append(os.Environ(), env...)
+	}
+	execCmd.Env = r.options.agentEnv(execCmd.Env)
+	execCmd.Env = r.options.scrubDisplayEnv(execCmd.Env)
+
+	applyUmask(logger, execCmd, r.options.Umask)
+	defer r.options.applyCoreDumps(logger, execCmd)()
+
+	// Capture output
+	var stdout, stderr bytes.Buffer
+	execCmd.Stdout, execCmd.Stderr = captureRunOutput(ctx, &stdout, &stderr)
+
+	// Run the command
+	logger.Debug("Executing command")
+
+	if err := execCmd.Run(); err != nil {
+		// If there's error output, include it in the error
+		if stderr.Len() > 0 {
+			errMsg := strings.TrimSpace(stderr.String())
+			logger.Debug("Command failed with stderr: %s", errMsg)
+			return "", newCommandError(errMsg, err)
+		}
+		logger.Debug("Command failed with error: %v", err)
+		return "", err
+	}
+
+	// Get the output
+	outputStr := strings.TrimSpace(stdout.String())
+
+	logger.Debug("Command executed successfully, output length: %d bytes", len(outputStr))
+	if stderr.Len() > 0 {
+		logger.Debug("Command generated stderr (but no error): %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return outputStr, nil
+}
+
+// RunEx executes a command inside the proot guest like Run, returning its exit code and
+// both output streams. It implements the Runner interface.
+func (r *Proot) RunEx(ctx context.Context, req RunRequest) (*RunResult, error) {
+	return runEx(ctx, r, req)
+}
+
+// RunWithPipes executes a command inside the proot guest with access to stdin/stdout/stderr pipes.
+// It implements the Runner interface for interactive process communication.
+//
+// The command is resolved inside the guest root filesystem, so cmd must be a
+// path (or a name in the guest PATH) that exists there.
+func (r *Proot) RunWithPipes(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (
+	stdin io.WriteCloser,
+	stdout io.ReadCloser,
+	stderr io.ReadCloser,
+	wait func() error,
+	err error,
+) {
+	e, err := r.start(ctx, cmd, args, env, params)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return e.Stdin, e.Stdout, e.Stderr, e.Wait, nil
+}
+
+// start executes a command inside the proot guest and returns an execution handle for it.
+// It is used by RunWithPipes and Start.
+func (r *Proot) start(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	return r.options.startWithTimeout(ctx, func(ctx context.Context) (*Execution, error) {
+		return r.startCommand(ctx, cmd, args, env, params)
+	})
+}
+
+// startCommand is start, with the context bounded by the timeout options
+func (r *Proot) startCommand(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+	env = r.options.caBundleEnv(env, caBundleGuestPath)
+
+	// Check if context is already done
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+		// Continue execution
+	}
+
+	logger.Debug("RunWithPipes: executing command in proot: %s with args: %v", cmd, args)
+
+	hostsFile, err := r.hostsFile(params)
+	if err != nil {
+		return nil, err
+	}
+	removeHostsFile := func() {
+		if hostsFile != "" {
+			if err := os.Remove(hostsFile); err != nil {
+				logger.Debug("Warning: failed to remove hosts file %s: %v", hostsFile, err)
+			}
+		}
+	}
+
+	prootArgs := r.prootArgs(params, hostsFile)
+	prootArgs = append(prootArgs, cmd)
+	prootArgs = append(prootArgs, args...)
+
+	execCmd := commandContext(ctx, r.prootPath(), prootArgs...)
+
+	// Set environment variables if provided
+	if len(env) > 0 {
+		logger.Debug("Adding %d environment variables to command", len(env))
+		execCmd.Env = append(os.Environ(), env...)
+	}
+	execCmd.Env = r.options.agentEnv(execCmd.Env)
+	execCmd.Env = r.options.scrubDisplayEnv(execCmd.Env)
+	execCmd.ExtraFiles = extraFilesFrom(ctx)
+
+	applyUmask(logger, execCmd, r.options.Umask)
+	collectCores := r.options.applyCoreDumps(logger, execCmd)
+
+	if loopbackNetworkFrom(ctx) {
+		if err := isolateLoopback(execCmd); err != nil {
+			removeHostsFile()
+			return nil, err
+		}
+	}
+
+	return startProcess(logger, execCmd, func() {
+		removeHostsFile()
+		collectCores()
+	})
+}
+
+// CheckImplicitRequirements checks if the runner meets its implicit requirements.
+// Proot runner requires Linux, the proot executable and an existing root filesystem.
+func (r *Proot) CheckImplicitRequirements() error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("proot runner requires Linux")
+	}
+
+	if !common.CheckExecutableExists(r.prootPath()) {
+		return fmt.Errorf("proot executable not found in PATH")
+	}
+
+	// The root filesystem can only be checked when it does not depend on template params
+	if !strings.Contains(r.options.RootFS, "{{") {
+		info, err := os.Stat(r.options.RootFS)
+		if err != nil {
+			return fmt.Errorf("proot root filesystem not available: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("proot root filesystem %s is not a directory", r.options.RootFS)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+func TestNewProot(t *testing.T) {
+	logger, _ := common.NewLogger("test-proot: ", "", common.LogLevelInfo, false)
+
+	tests := []struct {
+		name    string
+		options Options
+		wantErr bool
+	}{
+		{
+			name:    "missing rootfs",
+			options: Options{},
+			wantErr: true,
+		},
+		{
+			name:    "with rootfs",
+			options: Options{"rootfs": "/srv/rootfs/alpine"},
+		},
+		{
+			name:    "wrong binds type",
+			options: Options{"rootfs": "/srv/rootfs/alpine", "binds": "/tmp"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewProot(tt.options, logger)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewProot() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProot_prootArgs(t *testing.T) {
+	logger, _ := common.NewLogger("test-proot: ", "", common.LogLevelInfo, false)
+
+	r, err := NewProot(Options{
+		"rootfs":         "/srv/rootfs/{{.distro}}",
+		"binds":          []interface{}{"/proc", "{{.project}}:/work"},
+		"workdir":        "/work",
+		"root_id":        true,
+		"kernel_release": "5.15.0",
+	}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create proot runner: %v", err)
+	}
+
+	params := map[string]interface{}{
+		"distro":  "alpine",
+		"project": "/home/user/project",
+	}
+
+	want := []string{
+		"-r", "/srv/rootfs/alpine",
+		"-b", "/proc",
+		"-b", "/home/user/project:/work",
+		"-w", "/work",
+		"-0",
+		"-k", "5.15.0",
+	}
+	if got := r.prootArgs(params, ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("prootArgs() = %v, want %v", got, want)
+	}
+
+	if got := r.guestShell(""); got != "/bin/sh" {
+		t.Errorf("guestShell() = %q, want %q", got, "/bin/sh")
+	}
+}
+
+func TestProot_Run(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping proot test on non-Linux platform")
+	}
+	if !common.CheckExecutableExists("proot") {
+		t.Skip("Skipping proot test if proot is not available")
+	}
+
+	logger, _ := common.NewLogger("test-proot: ", "", common.LogLevelInfo, false)
+
+	// Use the host root as guest root: this only exercises the proot plumbing
+	r, err := NewProot(Options{"rootfs": "/"}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create proot runner: %v", err)
+	}
+
+	output, err := r.Run(context.Background(), "", "echo hello from proot", nil, nil, false)
+	if err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+	if output != "hello from proot" {
+		t.Errorf("Expected output %q, got %q", "hello from proot", output)
+	}
+}
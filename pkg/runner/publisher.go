@@ -0,0 +1,173 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ArtifactPublisher uploads the artifacts and the logs of executions to a
+// storage service (see WithPublisher)
+type ArtifactPublisher interface {
+	// Publish stores size bytes read from body under key, and returns the
+	// URL of the object
+	Publish(ctx context.Context, key string, body io.Reader, size int64) (string, error)
+}
+
+// PublishedObject is an artifact or a log uploaded by an ArtifactPublisher
+type PublishedObject struct {
+	// Key is the key of the object: the execution ID followed by
+	// "/stdout.log", "/stderr.log" or "/artifacts/" and the path of the artifact
+	Key string `json:"key"`
+
+	// URL is the URL of the object, as returned by the publisher
+	URL string `json:"url"`
+
+	// Size is the size of the object in bytes
+	Size int64 `json:"size"`
+}
+
+// WithPublisher uploads the artifacts declared with WithArtifacts and the
+// output of the command (as read by the caller) once the execution has
+// completed, keyed by the execution ID (see Execution.Published)
+func WithPublisher(p ArtifactPublisher) ExecOption {
+	return func(c *execConfig) {
+		c.publisher = p
+	}
+}
+
+// Published returns the objects uploaded by the publisher set with
+// WithPublisher. It must be called after Wait.
+func (e *Execution) Published() ([]PublishedObject, error) {
+	return e.published, e.publishedErr
+}
+
+// LocalPublisher is an ArtifactPublisher copying the objects to a directory
+type LocalPublisher struct {
+	// Dir is the directory the objects are copied to
+	Dir string
+}
+
+// Publish implements ArtifactPublisher
+func (p *LocalPublisher) Publish(ctx context.Context, key string, body io.Reader, size int64) (string, error) {
+	dest := filepath.Join(p.Dir, filepath.FromSlash(path.Clean("/"+key)))
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		_ = f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return "file://" + filepath.ToSlash(dest), nil
+}
+
+// outputSpool keeps a copy of the output read from the pipes of an
+// execution in temporary files, so it can be published after it
+type outputSpool struct {
+	mu    sync.Mutex
+	files map[string]*os.File
+}
+
+// newOutputSpool creates the spool files of stdout and stderr, and tees the
+// pipes of the execution into them
+func newOutputSpool(e *Execution) (*outputSpool, error) {
+	s := &outputSpool{files: map[string]*os.File{}}
+	for _, name := range []string{"stdout", "stderr"} {
+		f, err := os.CreateTemp("", "runner-"+name+"-*.log")
+		if err != nil {
+			s.remove()
+			return nil, fmt.Errorf("failed to create %s spool: %w", name, err)
+		}
+		s.files[name] = f
+	}
+	e.Stdout = &spoolReader{ReadCloser: e.Stdout, spool: s, file: s.files["stdout"]}
+	e.Stderr = &spoolReader{ReadCloser: e.Stderr, spool: s, file: s.files["stderr"]}
+	return s, nil
+}
+
+// remove closes and removes the spool files
+func (s *outputSpool) remove() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.files {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+	s.files = nil
+}
+
+// spoolReader copies what is read from a pipe to a spool file
+type spoolReader struct {
+	io.ReadCloser
+	spool *outputSpool
+	file  *os.File
+}
+
+func (r *spoolReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.spool.mu.Lock()
+		if r.spool.files != nil {
+			_, _ = r.file.Write(p[:n])
+		}
+		r.spool.mu.Unlock()
+	}
+	return n, err
+}
+
+// publishExecution uploads the logs and the artifacts of a completed execution
+func publishExecution(ctx context.Context, e *Execution, p ArtifactPublisher, spool *outputSpool) ([]PublishedObject, error) {
+	var published []PublishedObject
+	var errs []error
+
+	upload := func(key, localPath string) {
+		f, err := os.Open(localPath)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to publish %s: %w", key, err))
+			return
+		}
+		defer func() { _ = f.Close() }()
+
+		info, err := f.Stat()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to publish %s: %w", key, err))
+			return
+		}
+		url, err := p.Publish(ctx, key, f, info.Size())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to publish %s: %w", key, err))
+			return
+		}
+		e.logger.Debug("Published %s as %s", key, url)
+		published = append(published, PublishedObject{Key: key, URL: url, Size: info.Size()})
+	}
+
+	spool.mu.Lock()
+	logs := map[string]string{}
+	for name, f := range spool.files {
+		logs[name] = f.Name()
+		_ = f.Sync()
+	}
+	spool.mu.Unlock()
+	for _, name := range []string{"stdout", "stderr"} {
+		upload(e.ID+"/"+name+".log", logs[name])
+	}
+
+	for _, a := range e.artifacts {
+		upload(e.ID+"/artifacts/"+strings.TrimPrefix(filepath.ToSlash(a.Path), "/"), a.LocalPath)
+	}
+	return published, errors.Join(errs...)
+}
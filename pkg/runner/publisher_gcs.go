@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// gceTokenURL is where the metadata server of Google Cloud hands out the
+// tokens of the service account of the instance
+const gceTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// GCSPublisherOptions is the options for a GCSPublisher
+type GCSPublisherOptions struct {
+	// Bucket is the bucket the objects are uploaded to
+	Bucket string
+
+	// Prefix is prepended to the names of the objects
+	Prefix string
+
+	// Token returns the OAuth2 access token of the requests. By default,
+	// the token of the service account of the instance is obtained from
+	// the metadata server.
+	Token func(ctx context.Context) (string, error)
+
+	// Endpoint is the URL of the service (defaults to https://storage.googleapis.com)
+	Endpoint string
+
+	// Client is the HTTP client used (defaults to http.DefaultClient)
+	Client *http.Client
+}
+
+// GCSPublisher is an ArtifactPublisher uploading the objects to a Google
+// Cloud Storage bucket with the JSON API
+type GCSPublisher struct {
+	options GCSPublisherOptions
+}
+
+// NewGCSPublisher creates a new GCSPublisher
+func NewGCSPublisher(options GCSPublisherOptions) (*GCSPublisher, error) {
+	if options.Bucket == "" {
+		return nil, fmt.Errorf("gcs publisher requires a bucket")
+	}
+	if options.Endpoint == "" {
+		options.Endpoint = "https://storage.googleapis.com"
+	}
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+	p := &GCSPublisher{options: options}
+	if p.options.Token == nil {
+		p.options.Token = p.metadataToken
+	}
+	return p, nil
+}
+
+// Publish implements ArtifactPublisher
+func (p *GCSPublisher) Publish(ctx context.Context, key string, body io.Reader, size int64) (string, error) {
+	token, err := p.options.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	name := strings.TrimPrefix(p.options.Prefix+key, "/")
+	endpoint := strings.TrimSuffix(p.options.Endpoint, "/")
+	uploadURL := endpoint + "/upload/storage/v1/b/" + url.PathEscape(p.options.Bucket) +
+		"/o?uploadType=media&name=" + url.QueryEscape(name)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, body)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := p.options.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("upload failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return "gs://" + p.options.Bucket + "/" + name, nil
+}
+
+// metadataToken gets the token of the service account of the instance
+func (p *GCSPublisher) metadataToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gceTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := p.options.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned %s", resp.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
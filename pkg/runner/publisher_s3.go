@@ -0,0 +1,207 @@
+package runner
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// unsignedPayload is the payload hash of requests whose body is not signed
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3PublisherOptions is the options for an S3Publisher
+type S3PublisherOptions struct {
+	// Bucket is the bucket the objects are uploaded to
+	Bucket string
+
+	// Prefix is prepended to the keys of the objects
+	Prefix string
+
+	// Region is the region of the bucket (defaults to $AWS_REGION, or us-east-1)
+	Region string
+
+	// Endpoint is the URL of the service, for S3 compatible services
+	// (defaults to https://s3.<region>.amazonaws.com)
+	Endpoint string
+
+	// AccessKeyID, SecretAccessKey and SessionToken are the credentials
+	// (default to $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and $AWS_SESSION_TOKEN)
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Client is the HTTP client used (defaults to http.DefaultClient)
+	Client *http.Client
+}
+
+// S3Publisher is an ArtifactPublisher uploading the objects to an S3 bucket
+// (or a bucket of an S3 compatible service) with path-style requests signed
+// with AWS Signature Version 4
+type S3Publisher struct {
+	options S3PublisherOptions
+	now     func() time.Time
+}
+
+// NewS3Publisher creates a new S3Publisher
+func NewS3Publisher(options S3PublisherOptions) (*S3Publisher, error) {
+	if options.Bucket == "" {
+		return nil, fmt.Errorf("s3 publisher requires a bucket")
+	}
+	if options.Region == "" {
+		options.Region = os.Getenv("AWS_REGION")
+	}
+	if options.Region == "" {
+		options.Region = "us-east-1"
+	}
+	if options.Endpoint == "" {
+		options.Endpoint = "https://s3." + options.Region + ".amazonaws.com"
+	}
+	if options.AccessKeyID == "" {
+		options.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		options.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		options.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if options.AccessKeyID == "" || options.SecretAccessKey == "" {
+		return nil, fmt.Errorf("s3 publisher requires credentials")
+	}
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+	return &S3Publisher{options: options, now: time.Now}, nil
+}
+
+// Publish implements ArtifactPublisher
+func (p *S3Publisher) Publish(ctx context.Context, key string, body io.Reader, size int64) (string, error) {
+	objectURL := strings.TrimSuffix(p.options.Endpoint, "/") + "/" + p.options.Bucket + "/" +
+		escapeKey(strings.TrimPrefix(p.options.Prefix+key, "/"))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL, body)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if p.options.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", p.options.SessionToken)
+	}
+	signV4(req, unsignedPayload, p.options.AccessKeyID, p.options.SecretAccessKey, p.options.Region, "s3", p.now())
+
+	resp, err := p.options.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("upload failed: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return objectURL, nil
+}
+
+// escapeKey escapes the segments of an object key for a URL path
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// signV4 signs a request with AWS Signature Version 4, adding the
+// X-Amz-Date, X-Amz-Content-Sha256 and Authorization headers
+func signV4(req *http.Request, payloadHash, accessKey, secretKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	// the host and all the x-amz-* headers are signed
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalPath := req.URL.EscapedPath()
+	if canonicalPath == "" {
+		canonicalPath = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalPath,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns the query string with sorted, strictly escaped parameters
+func canonicalQuery(values url.Values) string {
+	var params []string
+	for key, vals := range values {
+		for _, v := range vals {
+			params = append(params, awsEscape(key)+"="+awsEscape(v))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// awsEscape escapes everything but the unreserved characters of RFC 3986
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
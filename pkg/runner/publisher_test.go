@@ -0,0 +1,166 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStart_Publisher tests that the logs and artifacts are published after Wait
+func TestStart_Publisher(t *testing.T) {
+	dir := t.TempDir()
+	dest := t.TempDir()
+
+	runner, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create runner: %v", err)
+	}
+
+	script := "echo out; echo err >&2; printf hello > " + filepath.Join(dir, "out.txt")
+	e, err := Start(context.Background(), runner, "sh", []string{"-c", script}, nil, nil,
+		WithArtifacts(ArtifactSpec{Pattern: filepath.Join(dir, "*.txt")}),
+		WithPublisher(&LocalPublisher{Dir: dest}))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	_ = e.Stdin.Close()
+	_, _ = io.ReadAll(e.Stdout)
+	_, _ = io.ReadAll(e.Stderr)
+	if err := e.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	published, err := e.Published()
+	if err != nil {
+		t.Fatalf("Published failed: %v", err)
+	}
+	if len(published) != 3 {
+		t.Fatalf("Expected 3 published objects, got %+v", published)
+	}
+
+	expected := map[string]string{
+		e.ID + "/stdout.log": "out\n",
+		e.ID + "/stderr.log": "err\n",
+		e.ID + "/artifacts/" + strings.TrimPrefix(filepath.ToSlash(filepath.Join(dir, "out.txt")), "/"): "hello",
+	}
+	for _, obj := range published {
+		content, ok := expected[obj.Key]
+		if !ok {
+			t.Errorf("Unexpected object %q", obj.Key)
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(obj.Key)))
+		if err != nil {
+			t.Errorf("Object %q not found: %v", obj.Key, err)
+			continue
+		}
+		if string(data) != content || obj.Size != int64(len(content)) {
+			t.Errorf("Unexpected object %q: %q (size %d)", obj.Key, data, obj.Size)
+		}
+	}
+}
+
+// TestSignV4 tests the signature with the get-vanilla case of the AWS test suite
+func TestSignV4(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signV4(req, sha256Hex(nil), "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("Unexpected signature:\n got: %s\nwant: %s", got, expected)
+	}
+}
+
+// recordingServer records the requests it gets
+type recordingServer struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func (s *recordingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, string(body))
+}
+
+func TestS3Publisher(t *testing.T) {
+	rec := &recordingServer{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	p, err := NewS3Publisher(S3PublisherOptions{
+		Bucket:          "artifacts",
+		Prefix:          "runs/",
+		Endpoint:        srv.URL,
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, err := p.Publish(context.Background(), "abc/artifacts/out file.txt", strings.NewReader("hello"), 5)
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if url != srv.URL+"/artifacts/runs/abc/artifacts/out%20file.txt" {
+		t.Errorf("Unexpected URL: %s", url)
+	}
+
+	if len(rec.requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(rec.requests))
+	}
+	r := rec.requests[0]
+	if r.Method != http.MethodPut || r.URL.EscapedPath() != "/artifacts/runs/abc/artifacts/out%20file.txt" {
+		t.Errorf("Unexpected request: %s %s", r.Method, r.URL.EscapedPath())
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+		t.Errorf("Unexpected authorization: %s", r.Header.Get("Authorization"))
+	}
+	if r.Header.Get("X-Amz-Content-Sha256") != unsignedPayload || rec.bodies[0] != "hello" {
+		t.Errorf("Unexpected payload: %s %q", r.Header.Get("X-Amz-Content-Sha256"), rec.bodies[0])
+	}
+}
+
+func TestGCSPublisher(t *testing.T) {
+	rec := &recordingServer{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	p, err := NewGCSPublisher(GCSPublisherOptions{
+		Bucket:   "artifacts",
+		Endpoint: srv.URL,
+		Token:    func(ctx context.Context) (string, error) { return "token", nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, err := p.Publish(context.Background(), "abc/stdout.log", strings.NewReader("hello"), 5)
+	if err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	if url != "gs://artifacts/abc/stdout.log" {
+		t.Errorf("Unexpected URL: %s", url)
+	}
+
+	r := rec.requests[0]
+	if r.URL.Path != "/upload/storage/v1/b/artifacts/o" || r.URL.Query().Get("name") != "abc/stdout.log" {
+		t.Errorf("Unexpected request: %s", r.URL)
+	}
+	if r.Header.Get("Authorization") != "Bearer token" || rec.bodies[0] != "hello" {
+		t.Errorf("Unexpected request: %s %q", r.Header.Get("Authorization"), rec.bodies[0])
+	}
+}
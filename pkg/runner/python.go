@@ -0,0 +1,479 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// pythonRequirementsStamp is the file (inside the virtualenv) recording the
+// hash of the requirements that were installed
+const pythonRequirementsStamp = ".go-restricted-runner-requirements"
+
+// Python implements the Runner interface for Python scripts executed inside a
+// managed virtualenv.
+//
+// The virtualenv is created (and the declared requirements installed) by
+// Prepare, which runs unrestricted because it usually needs network access
+// to a package index. Commands are then executed through a sandbox runner
+// (Landrun by default) configured with a preset tuned for CPython: the
+// virtualenv and the base interpreter installation are readable and
+// executable, and everything else follows the usual restriction options.
+type Python struct {
+	logger  Logger
+	options PythonOptions
+
+	mu       sync.Mutex
+	prepared bool
+	sandbox  Runner
+}
+
+// PythonOptions is the options for the Python runner
+type PythonOptions struct {
+	// Python is the interpreter used to create the virtualenv (defaults to "python3")
+	Python string `json:"python"`
+
+	// Venv is the virtualenv directory. When empty, a shared virtualenv keyed by
+	// the interpreter and requirements is used from the user cache directory.
+	Venv string `json:"venv"`
+
+	// Requirements are pip requirement specifiers installed during Prepare
+	Requirements []string `json:"requirements"`
+
+	// RequirementsFile is a pip requirements file installed during Prepare
+	RequirementsFile string `json:"requirements_file"`
+
+	// Sandbox is the runner used to execute commands: "landrun" (default), "firejail" or "exec"
+	Sandbox Type `json:"sandbox"`
+
+	// Restrictions applied on top of the CPython preset
+	AllowReadFolders  []string `json:"allow_read_folders"`
+	AllowWriteFolders []string `json:"allow_write_folders"`
+	AllowNetworking   bool     `json:"allow_networking"`
+
+	// ReportFileChanges records the files changed in AllowWriteFolders (see Execution.FileChanges)
+	ReportFileChanges bool `json:"report_file_changes"`
+
+	// Clock and locale settings
+	DeterminismOptions
+
+	// Variables of the commands read from .env files
+	EnvFileOptions
+
+	// Experimental features
+	ExperimentalOptions
+
+	// CA certificates trusted by the command
+	CABundleOptions
+
+	// Access to the credential agents of the host
+	AgentOptions
+
+	// Access to the display server of the host
+	DisplayOptions
+
+	// Hostname aliases, only supported by the firejail sandbox
+	HostsOptions
+
+	// Timeout of the commands
+	TimeoutOptions
+}
+
+// NewPythonOptions creates a new PythonOptions from Options
+func NewPythonOptions(options Options) (PythonOptions, error) {
+	var opts PythonOptions
+	jsonStr, err := options.ToJSON()
+	if err != nil {
+		return PythonOptions{}, err
+	}
+	err = json.Unmarshal([]byte(jsonStr), &opts)
+	return opts, err
+}
+
+// NewPython creates a new Python runner with the provided logger.
+// If logger is nil, a default logger is created.
+func NewPython(options Options, logger Logger) (*Python, error) {
+	logger = defaultLogger(logger)
+
+	pythonOpts, err := NewPythonOptions(options)
+	if err != nil {
+		logger.Debug("Failed to parse python options: %v", err)
+		return nil, fmt.Errorf("failed to parse python options: %w", err)
+	}
+	if err := pythonOpts.resolveFakeTime(); err != nil {
+		return nil, fmt.Errorf("invalid python options: %w", err)
+	}
+	if err := pythonOpts.validateCABundle(); err != nil {
+		return nil, fmt.Errorf("invalid python options: %w", err)
+	}
+	if err := pythonOpts.validateTimeouts(); err != nil {
+		return nil, fmt.Errorf("invalid python options: %w", err)
+	}
+	if err := pythonOpts.loadEnvFiles(); err != nil {
+		return nil, fmt.Errorf("invalid python options: %w", err)
+	}
+
+	if pythonOpts.Python == "" {
+		pythonOpts.Python = "python3"
+	}
+
+	switch pythonOpts.Sandbox {
+	case "":
+		pythonOpts.Sandbox = TypeLandrun
+	case TypeLandrun, TypeFirejail, TypeExec:
+	default:
+		return nil, fmt.Errorf("unsupported python sandbox: %s", pythonOpts.Sandbox)
+	}
+	if len(pythonOpts.ExtraHosts) > 0 && pythonOpts.Sandbox != TypeFirejail {
+		return nil, fmt.Errorf("extra_hosts requires the firejail sandbox")
+	}
+
+	if pythonOpts.Venv == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine cache directory for the virtualenv: %w", err)
+		}
+		pythonOpts.Venv = filepath.Join(cacheDir, "go-restricted-runner", "venvs", pythonOpts.requirementsHash())
+	}
+
+	return &Python{
+		logger:  logger,
+		options: pythonOpts,
+	}, nil
+}
+
+// policyFiles returns the requirements file, for the fingerprint of the
+// runner
+func (o PythonOptions) policyFiles() []string {
+	if o.RequirementsFile == "" {
+		return nil
+	}
+	return []string{o.RequirementsFile}
+}
+
+// requirementsHash returns a stable identifier for the interpreter and requirements
+func (o *PythonOptions) requirementsHash() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "python=%s\n", o.Python)
+	for _, req := range o.Requirements {
+		fmt.Fprintf(h, "req=%s\n", req)
+	}
+	if o.RequirementsFile != "" {
+		fmt.Fprintf(h, "file=%s\n", o.RequirementsFile)
+		if content, err := os.ReadFile(o.RequirementsFile); err == nil {
+			h.Write(content)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// venvBinDir returns the directory holding the virtualenv executables
+func (r *Python) venvBinDir() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(r.options.Venv, "Scripts")
+	}
+	return filepath.Join(r.options.Venv, "bin")
+}
+
+// venvPython returns the interpreter of the virtualenv
+func (r *Python) venvPython() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(r.venvBinDir(), "python.exe")
+	}
+	return filepath.Join(r.venvBinDir(), "python")
+}
+
+// Prepare creates the virtualenv if needed and installs the declared requirements.
+//
+// It is safe to call Prepare several times: requirements are only reinstalled
+// when they change. Run and RunWithPipes call it automatically, but calling it
+// explicitly lets callers pay the installation cost (and see its errors) ahead
+// of time.
+func (r *Python) Prepare(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.prepared {
+		return nil
+	}
+
+	if _, err := os.Stat(r.venvPython()); err != nil {
+		r.logger.Debug("Creating python virtualenv at %s", r.options.Venv)
+		if err := os.MkdirAll(filepath.Dir(r.options.Venv), 0o755); err != nil {
+			return fmt.Errorf("failed to create virtualenv parent directory: %w", err)
+		}
+		if err := r.runHost(ctx, r.options.Python, "-m", "venv", r.options.Venv); err != nil {
+			return fmt.Errorf("failed to create virtualenv: %w", err)
+		}
+	}
+
+	if err := r.installRequirements(ctx); err != nil {
+		return err
+	}
+
+	basePrefix, err := r.outputHost(ctx, r.venvPython(), "-c", "import sys; print(sys.base_prefix)")
+	if err != nil {
+		return fmt.Errorf("failed to determine python base prefix: %w", err)
+	}
+
+	sandbox, err := New(r.options.Sandbox, r.sandboxOptions(basePrefix), r.logger)
+	if err != nil {
+		return fmt.Errorf("failed to create %s sandbox for python: %w", r.options.Sandbox, err)
+	}
+
+	r.sandbox = sandbox
+	r.prepared = true
+	return nil
+}
+
+// installRequirements installs the declared requirements unless the stamp file
+// shows they are already installed
+func (r *Python) installRequirements(ctx context.Context) error {
+	if len(r.options.Requirements) == 0 && r.options.RequirementsFile == "" {
+		return nil
+	}
+
+	hash := r.options.requirementsHash()
+	stampFile := filepath.Join(r.options.Venv, pythonRequirementsStamp)
+	if stamp, err := os.ReadFile(stampFile); err == nil && strings.TrimSpace(string(stamp)) == hash {
+		r.logger.Debug("Python requirements already installed in %s", r.options.Venv)
+		return nil
+	}
+
+	args := []string{"-m", "pip", "install", "--disable-pip-version-check", "--no-input"}
+	if r.options.RequirementsFile != "" {
+		args = append(args, "-r", r.options.RequirementsFile)
+	}
+	args = append(args, r.options.Requirements...)
+
+	r.logger.Debug("Installing python requirements: %v", args)
+	if err := r.runHost(ctx, r.venvPython(), args...); err != nil {
+		return fmt.Errorf("failed to install python requirements: %w", err)
+	}
+
+	if err := os.WriteFile(stampFile, []byte(hash+"\n"), 0o644); err != nil {
+		r.logger.Debug("Warning: failed to write requirements stamp file: %v", err)
+	}
+	return nil
+}
+
+// runHost runs an unrestricted command on the host, including its output in the error.
+// The CA bundle is trusted by the command, so pip can go through the same proxy as the sandbox.
+func (r *Python) runHost(ctx context.Context, name string, args ...string) error {
+	cmd := commandContext(ctx, name, args...)
+	if vars := r.options.caBundleVars(r.options.CABundle); len(vars) > 0 {
+		cmd.Env = append(os.Environ(), vars...)
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// outputHost runs an unrestricted command on the host and returns its trimmed stdout
+func (r *Python) outputHost(ctx context.Context, name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := commandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// sandboxOptions returns the options of the sandbox runner: the CPython preset
+// merged with the configured restrictions
+func (r *Python) sandboxOptions(basePrefix string) Options {
+	opts := r.presetOptions(basePrefix)
+	if r.options.ReportFileChanges && r.options.Sandbox != TypeExec {
+		opts["report_file_changes"] = true
+	}
+	if r.options.CABundle != "" {
+		opts["ca_bundle"] = r.options.CABundle
+	}
+	if r.options.AllowSSHAgent {
+		opts["allow_ssh_agent"] = true
+	}
+	if r.options.AllowGPGAgent {
+		opts["allow_gpg_agent"] = true
+	}
+	if r.options.AllowKeyring {
+		opts["allow_keyring"] = true
+	}
+	if r.options.AllowDisplay {
+		opts["allow_display"] = true
+	}
+	if len(r.options.Experimental) > 0 {
+		experimental, _ := opts["experimental"].([]Feature)
+		opts["experimental"] = append(experimental, r.options.Experimental...)
+	}
+	if r.options.Timeout != "" {
+		opts["timeout"] = r.options.Timeout
+	}
+	if r.options.KillGracePeriod != "" {
+		opts["kill_grace_period"] = r.options.KillGracePeriod
+	}
+	return opts
+}
+
+// presetOptions returns the CPython preset for the sandbox runner
+func (r *Python) presetOptions(basePrefix string) Options {
+	readFolders := append([]string{}, r.options.AllowReadFolders...)
+	writeFolders := append([]string{}, r.options.AllowWriteFolders...)
+
+	switch r.options.Sandbox {
+	case TypeLandrun:
+		// The interpreter needs its own installation, the shared libraries it
+		// links against and the system configuration (locale, certificates...)
+		readExec := []string{r.options.Venv, "/usr", "/bin", "/lib"}
+		if basePrefix != "" && !contains(readExec, basePrefix) {
+			readExec = append(readExec, basePrefix)
+		}
+		if _, err := os.Stat("/lib64"); err == nil {
+			readExec = append(readExec, "/lib64")
+		}
+		readFolders = append(readFolders, "/etc")
+
+		// the rules are applied in a helper process, as Landlock would
+		// restrict the calling process for good
+		return Options{
+			"allow_read_folders":      readFolders,
+			"allow_read_exec_folders": readExec,
+			"allow_write_folders":     writeFolders,
+			"allow_networking":        r.options.AllowNetworking,
+			"experimental":            []Feature{FeatureLandlockHelper},
+		}
+	case TypeFirejail:
+		// The default firejail profile already applies seccomp, caps.drop all and noroot
+		readFolders = append(readFolders, r.options.Venv)
+		if basePrefix != "" {
+			readFolders = append(readFolders, basePrefix)
+		}
+		opts := Options{
+			"allow_read_folders":  readFolders,
+			"allow_write_folders": writeFolders,
+			"allow_networking":    r.options.AllowNetworking,
+		}
+		if len(r.options.ExtraHosts) > 0 {
+			opts["extra_hosts"] = r.options.ExtraHosts
+		}
+		return opts
+	default:
+		return Options{}
+	}
+}
+
+// venvEnv returns the environment that activates the virtualenv, followed by env
+func (r *Python) venvEnv(env []string) []string {
+	venvEnv := []string{
+		"VIRTUAL_ENV=" + r.options.Venv,
+		"PATH=" + r.venvBinDir() + string(os.PathListSeparator) + os.Getenv("PATH"),
+		"PYTHONNOUSERSITE=1",
+		"PYTHONDONTWRITEBYTECODE=1",
+	}
+	return append(venvEnv, env...)
+}
+
+// resolveVenvCommand maps bare commands (python, pip...) to the virtualenv
+// executables, as the PATH of the child is not used to resolve them
+func (r *Python) resolveVenvCommand(cmd string) string {
+	if strings.ContainsAny(cmd, `/\`) {
+		return cmd
+	}
+	candidate := filepath.Join(r.venvBinDir(), cmd)
+	if runtime.GOOS == "windows" && filepath.Ext(candidate) == "" {
+		candidate += ".exe"
+	}
+	if _, err := os.Stat(candidate); err == nil {
+		return candidate
+	}
+	return cmd
+}
+
+// Run executes a command with the virtualenv activated and returns the output.
+// It implements the Runner interface.
+//
+// The command is executed by the sandbox runner, so the shell and tmpfile
+// parameters follow its semantics.
+func (r *Python) Run(ctx context.Context, shell string, command string,
+	env []string, params map[string]interface{}, tmpfile bool,
+) (string, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+
+	if err := r.Prepare(ctx); err != nil {
+		return "", err
+	}
+
+	logger.Debug("Python: executing command in %s sandbox with virtualenv %s", r.options.Sandbox, r.options.Venv)
+	return r.sandbox.Run(ctx, shell, command, r.venvEnv(env), params, tmpfile)
+}
+
+// RunEx executes a command with the virtualenv activated like Run, returning its exit code and
+// both output streams. It implements the Runner interface.
+func (r *Python) RunEx(ctx context.Context, req RunRequest) (*RunResult, error) {
+	return runEx(ctx, r, req)
+}
+
+// RunWithPipes executes a command with the virtualenv activated and access to
+// stdin/stdout/stderr pipes. It implements the Runner interface.
+//
+// Bare command names available in the virtualenv (python, pip, installed
+// console scripts) are resolved to the virtualenv executables.
+func (r *Python) RunWithPipes(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (
+	stdin io.WriteCloser,
+	stdout io.ReadCloser,
+	stderr io.ReadCloser,
+	wait func() error,
+	err error,
+) {
+	e, err := r.start(ctx, cmd, args, env, params)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return e.Stdin, e.Stdout, e.Stderr, e.Wait, nil
+}
+
+// start executes a command with the virtualenv activated and returns an
+// execution handle for it. It is used by RunWithPipes and Start.
+func (r *Python) start(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+
+	if err := r.Prepare(ctx); err != nil {
+		return nil, err
+	}
+
+	resolved := r.resolveVenvCommand(cmd)
+	logger.Debug("RunWithPipes: executing python command %s in %s sandbox", resolved, r.options.Sandbox)
+	return Start(ctx, r.sandbox, resolved, args, r.venvEnv(env), params)
+}
+
+// CheckImplicitRequirements checks if the runner meets its implicit requirements.
+// Python runner requires the interpreter and the requirements of its sandbox runner.
+func (r *Python) CheckImplicitRequirements() error {
+	if !common.CheckExecutableExists(r.options.Python) {
+		return fmt.Errorf("python interpreter %s not found in PATH", r.options.Python)
+	}
+
+	sandbox, err := New(r.options.Sandbox, r.sandboxOptions(""), r.logger)
+	if err != nil {
+		return fmt.Errorf("python sandbox %s not available: %w", r.options.Sandbox, err)
+	}
+	return sandbox.CheckImplicitRequirements()
+}
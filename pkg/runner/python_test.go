@@ -0,0 +1,163 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+func TestNewPython(t *testing.T) {
+	logger, _ := common.NewLogger("test-python: ", "", common.LogLevelInfo, false)
+
+	t.Run("defaults", func(t *testing.T) {
+		r, err := NewPython(Options{}, logger)
+		if err != nil {
+			t.Fatalf("Failed to create python runner: %v", err)
+		}
+		if r.options.Python != "python3" {
+			t.Errorf("Expected default interpreter python3, got %q", r.options.Python)
+		}
+		if r.options.Sandbox != TypeLandrun {
+			t.Errorf("Expected default sandbox landrun, got %q", r.options.Sandbox)
+		}
+		if r.options.Venv == "" {
+			t.Errorf("Expected a managed virtualenv directory")
+		}
+	})
+
+	t.Run("managed venv depends on requirements", func(t *testing.T) {
+		r1, _ := NewPython(Options{"requirements": []interface{}{"requests==2.32.3"}}, logger)
+		r2, _ := NewPython(Options{"requirements": []interface{}{"requests==2.32.3"}}, logger)
+		r3, _ := NewPython(Options{"requirements": []interface{}{"requests==2.31.0"}}, logger)
+		if r1.options.Venv != r2.options.Venv {
+			t.Errorf("Expected identical requirements to share a virtualenv: %s != %s", r1.options.Venv, r2.options.Venv)
+		}
+		if r1.options.Venv == r3.options.Venv {
+			t.Errorf("Expected different requirements to use different virtualenvs")
+		}
+	})
+
+	t.Run("unsupported sandbox", func(t *testing.T) {
+		if _, err := NewPython(Options{"sandbox": "docker"}, logger); err == nil {
+			t.Errorf("Expected error for unsupported sandbox")
+		}
+	})
+}
+
+func TestPython_sandboxOptions(t *testing.T) {
+	logger, _ := common.NewLogger("test-python: ", "", common.LogLevelInfo, false)
+
+	r, err := NewPython(Options{
+		"venv":                "/opt/venvs/tool",
+		"allow_write_folders": []interface{}{"/tmp/out"},
+		"sandbox":             "firejail",
+	}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create python runner: %v", err)
+	}
+
+	got := r.sandboxOptions("/usr/local")
+	want := Options{
+		"allow_read_folders":  []string{"/opt/venvs/tool", "/usr/local"},
+		"allow_write_folders": []string{"/tmp/out"},
+		"allow_networking":    false,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sandboxOptions() = %v, want %v", got, want)
+	}
+
+	r.options.Sandbox = TypeLandrun
+	landrunOpts, err := NewLandrunOptions(r.sandboxOptions("/usr/local"))
+	if err != nil {
+		t.Fatalf("Failed to parse landrun preset: %v", err)
+	}
+	if !contains(landrunOpts.AllowReadExecFolders, "/opt/venvs/tool") || !contains(landrunOpts.AllowReadExecFolders, "/usr/local") {
+		t.Errorf("Expected venv and base prefix to be executable, got %v", landrunOpts.AllowReadExecFolders)
+	}
+	if landrunOpts.BestEffort {
+		t.Errorf("Expected the landrun preset to fail without Landlock")
+	}
+	if !landrunOpts.experimentEnabled(FeatureLandlockHelper) {
+		t.Errorf("Expected the landrun preset to apply the rules in the Landlock helper")
+	}
+}
+
+func TestPython_RunLandrunUnrestricted(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Landlock is only available on Linux")
+	}
+	if !common.CheckExecutableExists("python3") {
+		t.Skip("Skipping python test if python3 is not available")
+	}
+
+	logger, _ := common.NewLogger("test-python: ", "", common.LogLevelInfo, false)
+	venv := filepath.Join(t.TempDir(), "venv")
+	r, err := NewPython(Options{"venv": venv}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create python runner: %v", err)
+	}
+	ctx := context.Background()
+	if err := r.Prepare(ctx); err != nil {
+		t.Skipf("Skipping python test, cannot create virtualenv or Landlock is not available: %v", err)
+	}
+	if _, err := r.Run(ctx, "", `python -c "print(1)"`, nil, nil, false); err != nil {
+		t.Skipf("Skipping python test, cannot run in the landrun sandbox: %v", err)
+	}
+
+	// the calling process can still write out of the folders of the sandbox
+	path := filepath.Join(t.TempDir(), "after-run")
+	if err := os.WriteFile(path, []byte("ok"), 0o600); err != nil {
+		t.Fatalf("The calling process was restricted by Run: %v", err)
+	}
+}
+
+func TestPython_Run(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping python virtualenv test on Windows")
+	}
+	if !common.CheckExecutableExists("python3") {
+		t.Skip("Skipping python test if python3 is not available")
+	}
+
+	logger, _ := common.NewLogger("test-python: ", "", common.LogLevelInfo, false)
+
+	venv := filepath.Join(t.TempDir(), "venv")
+	r, err := NewPython(Options{"venv": venv, "sandbox": "exec"}, logger)
+	if err != nil {
+		t.Fatalf("Failed to create python runner: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := r.Prepare(ctx); err != nil {
+		t.Skipf("Skipping python test, cannot create virtualenv: %v", err)
+	}
+
+	output, err := r.Run(ctx, "", `python -c "import sys; print(sys.prefix)"`, nil, nil, false)
+	if err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+	if output != venv {
+		t.Errorf("Expected command to run in virtualenv %q, got %q", venv, output)
+	}
+
+	stdin, stdout, stderr, wait, err := r.RunWithPipes(ctx, "python", []string{"-c", "import os; print(os.environ['VIRTUAL_ENV'])"}, nil, nil)
+	if err != nil {
+		t.Fatalf("RunWithPipes failed: %v", err)
+	}
+	_ = stdin.Close()
+	out, _ := io.ReadAll(stdout)
+	_, _ = io.ReadAll(stderr)
+	if err := wait(); err != nil {
+		t.Fatalf("wait() failed: %v", err)
+	}
+	if strings.TrimSpace(string(out)) != venv {
+		t.Errorf("Expected VIRTUAL_ENV=%q, got %q", venv, strings.TrimSpace(string(out)))
+	}
+}
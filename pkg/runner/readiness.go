@@ -0,0 +1,269 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// ErrNotReady is returned by Execution.WaitReady when the readiness probe
+// did not succeed before its timeout
+var ErrNotReady = errors.New("command not ready")
+
+const (
+	// defaultProbeInterval is the time between probe attempts
+	defaultProbeInterval = 100 * time.Millisecond
+	// defaultProbeTimeout is the time the command has to become ready
+	defaultProbeTimeout = 30 * time.Second
+)
+
+// ReadinessProbe defines when a command that starts a server is ready to
+// accept connections. Exactly one of TCPAddress, HTTPURL and LogPattern
+// must be set.
+//
+// TCPAddress and HTTPURL are processed as templates with the params of the
+// execution, so they can refer to the ports allocated with WithFreePorts.
+type ReadinessProbe struct {
+	// TCPAddress is an address ("host:port") the command is ready once it
+	// accepts TCP connections
+	TCPAddress string
+
+	// HTTPURL is a URL the command is ready once a GET returns a status
+	// lower than 400
+	HTTPURL string
+
+	// LogPattern is a regular expression matched against every line of the
+	// output (stdout and stderr) of the command. The output must be read by
+	// the caller for the lines to be matched.
+	LogPattern string
+
+	// Interval is the time between TCP or HTTP attempts (defaults to 100ms)
+	Interval time.Duration
+
+	// Timeout is the time the command has to become ready (defaults to 30s)
+	Timeout time.Duration
+
+	// OnReady is called (in its own goroutine) once the command is ready
+	OnReady func()
+}
+
+// WithReadinessProbe probes the command until it is ready, as reported by
+// Execution.WaitReady
+func WithReadinessProbe(probe ReadinessProbe) ExecOption {
+	return func(c *execConfig) {
+		c.readiness = &probe
+	}
+}
+
+// readiness is the state of the readiness probe of an execution
+type readiness struct {
+	once  sync.Once
+	ready chan struct{}
+	err   error
+}
+
+// done records the result of the probe, only the first time it is called
+func (rd *readiness) done(err error, onReady func()) {
+	rd.once.Do(func() {
+		rd.err = err
+		close(rd.ready)
+		if err == nil && onReady != nil {
+			go onReady()
+		}
+	})
+}
+
+// WaitReady waits until the readiness probe of the execution succeeds. It
+// returns an error wrapping ErrNotReady if the probe times out, and nil
+// immediately for executions started without WithReadinessProbe.
+func (e *Execution) WaitReady(ctx context.Context) error {
+	if e.readiness == nil {
+		return nil
+	}
+	select {
+	case <-e.readiness.ready:
+		return e.readiness.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// readinessProber probes the command of an execution
+type readinessProber struct {
+	probe    *ReadinessProbe
+	timeout  time.Duration
+	interval time.Duration
+
+	// check is the TCP or HTTP check, or nil for log patterns
+	check   func(ctx context.Context) error
+	pattern *regexp.Regexp
+}
+
+// newReadinessProber validates a probe, processing its templates with params.
+// It is called before the command starts, so invalid probes do not start it.
+func newReadinessProber(probe *ReadinessProbe, params map[string]interface{}) (*readinessProber, error) {
+	p := &readinessProber{probe: probe, timeout: probe.Timeout, interval: probe.Interval}
+	if p.timeout <= 0 {
+		p.timeout = defaultProbeTimeout
+	}
+	if p.interval <= 0 {
+		p.interval = defaultProbeInterval
+	}
+
+	switch {
+	case probe.LogPattern != "":
+		re, err := regexp.Compile(probe.LogPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid readiness log pattern: %w", err)
+		}
+		p.pattern = re
+
+	case probe.TCPAddress != "":
+		address, err := common.ProcessTemplate(probe.TCPAddress, params)
+		if err != nil {
+			return nil, fmt.Errorf("invalid readiness address: %w", err)
+		}
+		p.check = func(ctx context.Context) error {
+			conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}
+
+	case probe.HTTPURL != "":
+		url, err := common.ProcessTemplate(probe.HTTPURL, params)
+		if err != nil {
+			return nil, fmt.Errorf("invalid readiness URL: %w", err)
+		}
+		p.check = func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			if resp.StatusCode >= 400 {
+				return fmt.Errorf("unexpected status %s", resp.Status)
+			}
+			return nil
+		}
+
+	default:
+		return nil, fmt.Errorf("readiness probe requires a TCP address, an HTTP URL or a log pattern")
+	}
+	return p, nil
+}
+
+// start starts probing the command of an execution
+func (p *readinessProber) start(ctx context.Context, e *Execution) {
+	rd := &readiness{ready: make(chan struct{})}
+	e.readiness = rd
+	e.exitHooks = append(e.exitHooks, func() {
+		rd.done(fmt.Errorf("%w: the command exited", ErrNotReady), nil)
+	})
+
+	if p.pattern != nil {
+		match := func() { rd.done(nil, p.probe.OnReady) }
+		e.Stdout = &lineMatcher{ReadCloser: e.Stdout, re: p.pattern, match: match}
+		e.Stderr = &lineMatcher{ReadCloser: e.Stderr, re: p.pattern, match: match}
+	}
+
+	go func() {
+		probeCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		var lastErr error
+		for {
+			if p.check != nil {
+				attemptCtx, cancelAttempt := context.WithTimeout(probeCtx, 10*p.interval)
+				lastErr = p.check(attemptCtx)
+				cancelAttempt()
+				if lastErr == nil {
+					e.logger.Debug("Execution %s is ready", e.ID)
+					rd.done(nil, p.probe.OnReady)
+					return
+				}
+			}
+
+			select {
+			case <-rd.ready:
+				return
+			case <-probeCtx.Done():
+				if lastErr != nil {
+					rd.done(fmt.Errorf("%w after %v: %v", ErrNotReady, p.timeout, lastErr), nil)
+				} else {
+					rd.done(fmt.Errorf("%w after %v", ErrNotReady, p.timeout), nil)
+				}
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// lineMatcher calls match when a line of the output read matches a regular expression
+type lineMatcher struct {
+	io.ReadCloser
+	re    *regexp.Regexp
+	match func()
+
+	mu      sync.Mutex
+	partial []byte
+	matched bool
+}
+
+func (m *lineMatcher) Read(p []byte) (int, error) {
+	n, err := m.ReadCloser.Read(p)
+	if n > 0 {
+		m.scan(p[:n])
+	}
+	return n, err
+}
+
+// scan matches the complete lines in data, keeping the last partial line
+func (m *lineMatcher) scan(data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.matched {
+		return
+	}
+	m.partial = append(m.partial, data...)
+	for {
+		i := bytes.IndexByte(m.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := m.partial[:i]
+		m.partial = m.partial[i+1:]
+		if m.re.Match(line) {
+			m.matched = true
+			m.partial = nil
+			m.match()
+			return
+		}
+	}
+	// a line without newline can be the prompt of the server
+	if m.re.Match(m.partial) {
+		m.matched = true
+		m.partial = nil
+		m.match()
+	}
+}
@@ -0,0 +1,135 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startCatWithProbe starts `cat` (which runs until its stdin is closed) with a readiness probe
+func startCatWithProbe(t *testing.T, probe ReadinessProbe, params map[string]interface{}) *Execution {
+	t.Helper()
+
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	e, err := Start(context.Background(), r, "cat", nil, nil, params, WithReadinessProbe(probe))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = e.Stdin.Close()
+		_, _ = io.ReadAll(e.Stdout)
+		_, _ = io.ReadAll(e.Stderr)
+		_ = e.Wait()
+	})
+	return e
+}
+
+func TestWaitReady_TCP(t *testing.T) {
+	ports, err := AllocatePorts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ready := make(chan struct{})
+	e := startCatWithProbe(t, ReadinessProbe{
+		TCPAddress: "127.0.0.1:{{.port}}",
+		Interval:   20 * time.Millisecond,
+		OnReady:    func() { close(ready) },
+	}, map[string]interface{}{"port": ports[0]})
+
+	// the "server" starts listening after a while
+	time.Sleep(100 * time.Millisecond)
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(ports[0])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.WaitReady(ctx); err != nil {
+		t.Fatalf("WaitReady failed: %v", err)
+	}
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		t.Errorf("OnReady was not called")
+	}
+}
+
+func TestWaitReady_HTTP(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	e := startCatWithProbe(t, ReadinessProbe{HTTPURL: srv.URL, Interval: 10 * time.Millisecond}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.WaitReady(ctx); err != nil {
+		t.Fatalf("WaitReady failed: %v", err)
+	}
+	if n := calls.Load(); n < 3 {
+		t.Errorf("WaitReady returned after %d calls, want the server to be ready", n)
+	}
+}
+
+func TestWaitReady_LogPattern(t *testing.T) {
+	e := startCatWithProbe(t, ReadinessProbe{LogPattern: `listening on port \d+`}, nil)
+
+	go func() {
+		_, _ = io.WriteString(e.Stdin, "starting\n")
+		_, _ = io.WriteString(e.Stdin, "listening on port 8080\n")
+	}()
+	go func() { _, _ = io.Copy(io.Discard, e.Stdout) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.WaitReady(ctx); err != nil {
+		t.Fatalf("WaitReady failed: %v", err)
+	}
+}
+
+func TestWaitReady_Timeout(t *testing.T) {
+	ports, err := AllocatePorts(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := startCatWithProbe(t, ReadinessProbe{
+		TCPAddress: net.JoinHostPort("127.0.0.1", strconv.Itoa(ports[0])),
+		Interval:   10 * time.Millisecond,
+		Timeout:    100 * time.Millisecond,
+	}, nil)
+
+	if err := e.WaitReady(context.Background()); !errors.Is(err, ErrNotReady) {
+		t.Errorf("WaitReady() error = %v, want ErrNotReady", err)
+	}
+}
+
+func TestWithReadinessProbe_Invalid(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	if _, err := Start(context.Background(), r, "true", nil, nil, nil, WithReadinessProbe(ReadinessProbe{})); err == nil {
+		t.Errorf("Start should fail with an empty probe")
+	}
+	if _, err := Start(context.Background(), r, "true", nil, nil, nil, WithReadinessProbe(ReadinessProbe{LogPattern: "("})); err == nil {
+		t.Errorf("Start should fail with an invalid log pattern")
+	}
+}
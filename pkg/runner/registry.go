@@ -0,0 +1,314 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrRunnerNotRegistered is returned by Registry methods for unknown names
+var ErrRunnerNotRegistered = errors.New("runner not registered")
+
+// RunnerConfig is the configuration of a runner in a Registry
+type RunnerConfig struct {
+	// Type is the type of runner to create
+	Type Type `json:"type"`
+
+	// Options is the configuration of the runner
+	Options Options `json:"options"`
+
+	// Tenant is the tenant whose defaults are applied to Options when the
+	// Registry has an OptionsResolver
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Registry manages named runner configurations (for example, one per tenant
+// and tool), creating the runners lazily on first use.
+//
+// Runners are created with New, so their implicit requirements are checked
+// when they are created. Failures are not cached: the next Get retries.
+// Created runners can be health checked and evicted, and are created again
+// from their configuration when needed.
+type Registry struct {
+	logger Logger
+
+	mu         sync.Mutex
+	entries    map[string]*registryEntry
+	resolver   *OptionsResolver
+	decrypters []Decrypter
+	secrets    []SecretResolver
+}
+
+// registryEntry is a named configuration and its runner, once created
+type registryEntry struct {
+	config RunnerConfig
+
+	// mu serializes the creation of the runner
+	mu       sync.Mutex
+	runner   Runner
+	lastUsed time.Time
+}
+
+// RegistryHealth is the result of checking a runner of a Registry
+type RegistryHealth struct {
+	// Name of the runner
+	Name string
+	// Err is the requirements check error, or nil if the runner is healthy
+	Err error
+}
+
+// NewRegistry creates a new, empty Registry with the provided logger.
+// If logger is nil, a default logger is created.
+func NewRegistry(logger Logger) *Registry {
+	return &Registry{
+		logger:  defaultLogger(logger),
+		entries: map[string]*registryEntry{},
+	}
+}
+
+// SetResolver resolves the options of the runners created from now on with
+// the global and tenant defaults and the floors of the resolver, the options
+// of the configurations being the per-call overrides
+func (reg *Registry) SetResolver(resolver *OptionsResolver) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.resolver = resolver
+}
+
+// SetDecrypters decrypts the encrypted option values of the configurations
+// (see DecryptOptions) when their runners are created, so the plaintext is
+// only kept in memory by the runners
+func (reg *Registry) SetDecrypters(decrypters ...Decrypter) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.decrypters = decrypters
+}
+
+// SetSecretResolvers resolves the secret references of the options (see
+// ResolveSecretRefs) when the runners are prepared (see Prepare), so rotated
+// secrets are picked up by the runners prepared again after an eviction
+func (reg *Registry) SetSecretResolvers(resolvers ...SecretResolver) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.secrets = resolvers
+}
+
+// Register adds a named runner configuration. Registering an existing name
+// replaces its configuration, discarding the runner created for the old one.
+// With a resolver, configurations violating its floors are rejected.
+func (reg *Registry) Register(name string, config RunnerConfig) error {
+	if name == "" {
+		return fmt.Errorf("runner name cannot be empty")
+	}
+	if config.Type == "" {
+		return fmt.Errorf("runner %q has no type", name)
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if reg.resolver != nil {
+		if _, err := reg.resolver.Resolve(config.Tenant, config.Options); err != nil {
+			return fmt.Errorf("invalid options of runner %q: %w", name, err)
+		}
+	}
+
+	if _, ok := reg.entries[name]; ok {
+		reg.logger.Debug("Registry: replacing runner %q", name)
+	}
+	reg.entries[name] = &registryEntry{config: config}
+	return nil
+}
+
+// Unregister removes a named runner configuration and its runner
+func (reg *Registry) Unregister(name string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.entries, name)
+}
+
+// Names returns the registered names, sorted
+func (reg *Registry) Names() []string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	names := make([]string, 0, len(reg.entries))
+	for name := range reg.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Config returns the configuration registered for a name
+func (reg *Registry) Config(name string) (RunnerConfig, bool) {
+	e := reg.entry(name)
+	if e == nil {
+		return RunnerConfig{}, false
+	}
+	return e.config, true
+}
+
+// Get returns the runner registered with the given name, preparing it (see
+// Prepare) if it does not exist yet.
+func (reg *Registry) Get(name string) (Runner, error) {
+	return reg.prepare(context.Background(), name)
+}
+
+// Prepare creates the runner registered with the given name, if it does not
+// exist yet, resolving the secret references of its options with ctx. The
+// runners with a preparation step of their own (e.g. Python, installing its
+// requirements) are prepared too. Calling it before Get lets callers bound
+// the time spent reaching the secrets managers, and see their errors, ahead
+// of time.
+func (reg *Registry) Prepare(ctx context.Context, name string) error {
+	r, err := reg.prepare(ctx, name)
+	if err != nil {
+		return err
+	}
+	if p, ok := r.(preparer); ok {
+		if err := p.Prepare(ctx); err != nil {
+			return fmt.Errorf("failed to prepare runner %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// preparer is implemented by the runners with a preparation step (see
+// Python.Prepare)
+type preparer interface {
+	Prepare(ctx context.Context) error
+}
+
+// prepare returns the runner registered with the given name, creating it if
+// it does not exist yet
+func (reg *Registry) prepare(ctx context.Context, name string) (Runner, error) {
+	e := reg.entry(name)
+	if e == nil {
+		return nil, fmt.Errorf("%w: %s", ErrRunnerNotRegistered, name)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.runner == nil {
+		options, err := reg.resolve(ctx, e.config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid options of runner %q: %w", name, err)
+		}
+		reg.logger.Debug("Registry: creating %s runner %q", e.config.Type, name)
+		r, err := New(e.config.Type, options, reg.logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create runner %q: %w", name, err)
+		}
+		e.runner = r
+	}
+	e.lastUsed = time.Now()
+	return e.runner, nil
+}
+
+// Check checks the implicit requirements of a runner, creating it if needed.
+// Runners that fail the check are evicted.
+func (reg *Registry) Check(name string) error {
+	r, err := reg.Get(name)
+	if err != nil {
+		return err
+	}
+	if err := r.CheckImplicitRequirements(); err != nil {
+		reg.logger.Debug("Registry: runner %q failed the health check: %v", name, err)
+		reg.Evict(name)
+		return fmt.Errorf("runner %q is not healthy: %w", name, err)
+	}
+	return nil
+}
+
+// CheckAll checks the runners that have been created, evicting the ones
+// that fail, and returns the results sorted by name. Runners that have not
+// been created yet are not created just to be checked.
+func (reg *Registry) CheckAll() []RegistryHealth {
+	var res []RegistryHealth
+	for _, name := range reg.Names() {
+		e := reg.entry(name)
+		if e == nil {
+			continue
+		}
+		e.mu.Lock()
+		r := e.runner
+		e.mu.Unlock()
+		if r == nil {
+			continue
+		}
+
+		err := r.CheckImplicitRequirements()
+		if err != nil {
+			reg.logger.Debug("Registry: runner %q failed the health check: %v", name, err)
+			reg.Evict(name)
+		}
+		res = append(res, RegistryHealth{Name: name, Err: err})
+	}
+	return res
+}
+
+// Evict discards the runner created for a name, keeping its configuration:
+// the next Get creates a new runner
+func (reg *Registry) Evict(name string) {
+	e := reg.entry(name)
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.runner = nil
+}
+
+// EvictIdle discards the runners that have not been returned by Get for
+// longer than maxIdle, and returns their names
+func (reg *Registry) EvictIdle(maxIdle time.Duration) []string {
+	var evicted []string
+	for _, name := range reg.Names() {
+		e := reg.entry(name)
+		if e == nil {
+			continue
+		}
+		e.mu.Lock()
+		if e.runner != nil && time.Since(e.lastUsed) > maxIdle {
+			reg.logger.Debug("Registry: evicting idle runner %q", name)
+			e.runner = nil
+			evicted = append(evicted, name)
+		}
+		e.mu.Unlock()
+	}
+	return evicted
+}
+
+// resolve returns the options of a configuration, decrypted and resolved
+// with the decrypters, resolver and secret resolvers of the registry (if any)
+func (reg *Registry) resolve(ctx context.Context, config RunnerConfig) (Options, error) {
+	reg.mu.Lock()
+	resolver, decrypters, secrets := reg.resolver, reg.decrypters, reg.secrets
+	reg.mu.Unlock()
+
+	// encrypted values are never passed to the runners, failing without a
+	// decrypter for them
+	options, err := DecryptOptions(ctx, config.Options, decrypters...)
+	if err != nil {
+		return nil, err
+	}
+	if resolver != nil {
+		if options, err = resolver.Resolve(config.Tenant, options); err != nil {
+			return nil, err
+		}
+	}
+	// the defaults of the policy can reference secrets too
+	return ResolveSecretRefs(ctx, options, secrets...)
+}
+
+// entry returns the entry of a name, or nil
+func (reg *Registry) entry(name string) *registryEntry {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.entries[name]
+}
@@ -0,0 +1,92 @@
+package runner
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry(nil)
+
+	if err := reg.Register("tenant-a/echo", RunnerConfig{Type: TypeExec}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := reg.Register("tenant-b/broken", RunnerConfig{Type: "unknown"}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := reg.Register("", RunnerConfig{Type: TypeExec}); err == nil {
+		t.Errorf("Register should fail with an empty name")
+	}
+
+	if got := reg.Names(); len(got) != 2 || got[0] != "tenant-a/echo" || got[1] != "tenant-b/broken" {
+		t.Errorf("Names() = %v", got)
+	}
+
+	t.Run("lazy construction", func(t *testing.T) {
+		r1, err := reg.Get("tenant-a/echo")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		r2, err := reg.Get("tenant-a/echo")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if r1 != r2 {
+			t.Errorf("Get should return the same runner until it is evicted")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := reg.Get("tenant-b/broken"); err == nil {
+			t.Errorf("Get should fail for an unknown runner type")
+		}
+		if _, err := reg.Get("missing"); !errors.Is(err, ErrRunnerNotRegistered) {
+			t.Errorf("Get() error = %v, want ErrRunnerNotRegistered", err)
+		}
+	})
+
+	t.Run("health checks", func(t *testing.T) {
+		if err := reg.Check("tenant-a/echo"); err != nil {
+			t.Errorf("Check failed: %v", err)
+		}
+		health := reg.CheckAll()
+		if len(health) != 1 || health[0].Name != "tenant-a/echo" || health[0].Err != nil {
+			t.Errorf("CheckAll() = %+v, want only the created runner, healthy", health)
+		}
+	})
+
+	t.Run("eviction", func(t *testing.T) {
+		r1, _ := reg.Get("tenant-a/echo")
+		reg.Evict("tenant-a/echo")
+		r2, err := reg.Get("tenant-a/echo")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if r1 == r2 {
+			t.Errorf("Get should create a new runner after Evict")
+		}
+
+		if evicted := reg.EvictIdle(time.Hour); len(evicted) != 0 {
+			t.Errorf("EvictIdle(1h) = %v, want nothing evicted", evicted)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if evicted := reg.EvictIdle(time.Millisecond); len(evicted) != 1 || evicted[0] != "tenant-a/echo" {
+			t.Errorf("EvictIdle(1ms) = %v, want [tenant-a/echo]", evicted)
+		}
+	})
+
+	t.Run("replace and unregister", func(t *testing.T) {
+		if err := reg.Register("tenant-a/echo", RunnerConfig{Type: TypeExec, Options: Options{"shell": "bash"}}); err != nil {
+			t.Fatalf("Register failed: %v", err)
+		}
+		if cfg, ok := reg.Config("tenant-a/echo"); !ok || cfg.Options["shell"] != "bash" {
+			t.Errorf("Config() = %+v, %v, want the new configuration", cfg, ok)
+		}
+
+		reg.Unregister("tenant-a/echo")
+		if _, err := reg.Get("tenant-a/echo"); !errors.Is(err, ErrRunnerNotRegistered) {
+			t.Errorf("Get() error = %v after Unregister, want ErrRunnerNotRegistered", err)
+		}
+	})
+}
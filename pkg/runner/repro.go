@@ -0,0 +1,481 @@
+package runner
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// WithReproBundle writes a bundle describing the execution to path (a
+// gzipped tarball), to be attached to bug reports: the options of the
+// runner, the command lines it ran (e.g. the docker or firejail arguments),
+// the profiles and rules it rendered, the names of the environment variables
+// of the command, and the versions of this package, Go, the OS and the
+// sandbox tool.
+//
+// The bundle is written once the execution has completed, or when it fails
+// to start. Secrets are redacted: the values of the environment variables
+// passed to the command, and of the options and parameters whose names look
+// like credentials (e.g. "token" or "password"), are replaced with
+// RedactedValue wherever they appear.
+func WithReproBundle(path string) ExecOption {
+	return func(c *execConfig) {
+		c.reproBundle = path
+	}
+}
+
+// RedactedValue replaces the secrets in repro bundles
+const RedactedValue = "[REDACTED]"
+
+// reproBundleVersion is the version of the layout of repro bundles
+const reproBundleVersion = 1
+
+// reproManifest is the manifest.json file of a repro bundle
+type reproManifest struct {
+	Version     int                    `json:"version"`
+	Created     time.Time              `json:"created"`
+	ExecutionID string                 `json:"execution_id,omitempty"`
+	Runner      Type                   `json:"runner,omitempty"`
+	Fingerprint string                 `json:"fingerprint,omitempty"`
+	Options     interface{}            `json:"options,omitempty"`
+	Command     string                 `json:"command"`
+	Args        []string               `json:"args"`
+	EnvNames    []string               `json:"env_names"`
+	Params      map[string]interface{} `json:"params,omitempty"`
+	Commands    []reproCommand         `json:"commands"`
+	Files       []string               `json:"files,omitempty"`
+	Versions    reproVersions          `json:"versions"`
+	Error       string                 `json:"error,omitempty"`
+	ExitCode    *int                   `json:"exit_code,omitempty"`
+}
+
+// reproCommand is a command line run by the runner
+type reproCommand struct {
+	Args     []string `json:"args"`
+	Dir      string   `json:"dir,omitempty"`
+	EnvNames []string `json:"env_names"`
+}
+
+// reproVersions are the versions of the components involved in an execution
+type reproVersions struct {
+	Module string `json:"module"`
+	Go     string `json:"go"`
+	OS     string `json:"os"`
+	Arch   string `json:"arch"`
+	Kernel string `json:"kernel,omitempty"`
+	Tool   string `json:"tool,omitempty"`
+}
+
+// reproFile is a file rendered by the runner (e.g. a sandbox profile)
+type reproFile struct {
+	name string
+	data []byte
+}
+
+// reproRecorder collects what the runners do for an execution, through the
+// context of the execution
+type reproRecorder struct {
+	mu       sync.Mutex
+	commands []*exec.Cmd
+	files    []reproFile
+}
+
+// reproRecorderKey is the context key of the repro recorder
+type reproRecorderKey struct{}
+
+// withReproRecorder returns a context recording the execution in rec
+func withReproRecorder(ctx context.Context, rec *reproRecorder) context.Context {
+	return context.WithValue(ctx, reproRecorderKey{}, rec)
+}
+
+// reproRecorderFrom returns the repro recorder of the context, or nil
+func reproRecorderFrom(ctx context.Context) *reproRecorder {
+	rec, _ := ctx.Value(reproRecorderKey{}).(*reproRecorder)
+	return rec
+}
+
+// recordCommand records a command created for the execution. Its arguments
+// and environment are read when the bundle is written, so the changes made
+// until it is started (e.g. by applyUmask) are included.
+func (rec *reproRecorder) recordCommand(cmd *exec.Cmd) {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.commands = append(rec.commands, cmd)
+}
+
+// recordReproFile records a file rendered for the execution of the context
+func recordReproFile(ctx context.Context, name string, data []byte) {
+	rec := reproRecorderFrom(ctx)
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.files = append(rec.files, reproFile{name: name, data: append([]byte(nil), data...)})
+}
+
+// secretNamePattern matches the names of the options, parameters and
+// environment variables holding credentials
+var secretNamePattern = regexp.MustCompile(`(?i)(token|secret|passw|credential|auth|api_?key|private_?key|access_?key|session_?key)`)
+
+// reproRedactor replaces secrets in the contents of a bundle
+type reproRedactor struct {
+	secrets []string
+}
+
+// addSecret registers a value to redact. Very short values are ignored, as
+// redacting them would make the bundle unreadable.
+func (r *reproRedactor) addSecret(value string) {
+	if len(value) >= 4 {
+		r.secrets = append(r.secrets, value)
+	}
+}
+
+// addEnv registers the values of environment variables
+func (r *reproRedactor) addEnv(env []string) {
+	for _, e := range env {
+		if _, value, ok := strings.Cut(e, "="); ok {
+			r.addSecret(value)
+		}
+	}
+}
+
+// addSecretEnv registers the values of the environment variables whose
+// names look like credentials
+func (r *reproRedactor) addSecretEnv(env []string) {
+	for _, e := range env {
+		if name, value, ok := strings.Cut(e, "="); ok && secretNamePattern.MatchString(name) {
+			r.addSecret(value)
+		}
+	}
+}
+
+// addNamed registers the values of the fields of a decoded JSON value whose
+// names look like credentials
+func (r *reproRedactor) addNamed(value interface{}, secret bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			r.addNamed(field, secret || secretNamePattern.MatchString(key))
+		}
+	case []interface{}:
+		for _, item := range v {
+			r.addNamed(item, secret)
+		}
+	case string:
+		if secret {
+			r.addSecret(v)
+		}
+	case nil:
+	default:
+		if secret {
+			r.addSecret(fmt.Sprint(v))
+		}
+	}
+}
+
+// redact replaces the secrets in s, longest first so a secret containing
+// another one is fully replaced
+func (r *reproRedactor) redact(s string) string {
+	sort.Slice(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, RedactedValue)
+	}
+	return s
+}
+
+// redactAll replaces the secrets in a list of strings
+func (r *reproRedactor) redactAll(list []string) []string {
+	res := make([]string, len(list))
+	for i, s := range list {
+		res[i] = r.redact(s)
+	}
+	return res
+}
+
+// redactValue replaces the secrets in the strings of a decoded JSON value
+func (r *reproRedactor) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, field := range v {
+			res[r.redact(key)] = r.redactValue(field)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			res[i] = r.redactValue(item)
+		}
+		return res
+	case string:
+		return r.redact(v)
+	}
+	return value
+}
+
+// reproRequest is what a bundle is made from
+type reproRequest struct {
+	path   string
+	runner Runner
+	id     string
+	cmd    string
+	args   []string
+	env    []string
+	params map[string]interface{}
+	rec    *reproRecorder
+}
+
+// write writes the bundle, with the error the execution failed with, if any.
+// exited is false when the command could not be started.
+func (req *reproRequest) write(err error, exited bool) error {
+	redactor := &reproRedactor{}
+	redactor.addEnv(req.env)
+	for _, secret := range common.Secrets() {
+		redactor.addSecret(secret)
+	}
+	runnerType, options := runnerPolicy(req.runner)
+	var decodedOptions, decodedParams interface{}
+	if data, jsonErr := json.Marshal(options); jsonErr == nil {
+		_ = json.Unmarshal(data, &decodedOptions)
+	}
+	if data, jsonErr := json.Marshal(req.params); jsonErr == nil {
+		_ = json.Unmarshal(data, &decodedParams)
+	}
+	redactor.addNamed(decodedOptions, false)
+	redactor.addNamed(decodedParams, false)
+
+	manifest := reproManifest{
+		Version:     reproBundleVersion,
+		Created:     time.Now().UTC(),
+		ExecutionID: req.id,
+		Runner:      runnerType,
+		Fingerprint: Fingerprint(req.runner),
+		Command:     redactor.redact(req.cmd),
+		Args:        redactor.redactAll(req.args),
+		EnvNames:    envNames(req.env),
+		Versions:    reproVersionsOf(runnerType),
+	}
+	if decodedOptions != nil {
+		manifest.Options = redactor.redactValue(decodedOptions)
+	}
+	if params, ok := redactor.redactValue(decodedParams).(map[string]interface{}); ok {
+		manifest.Params = params
+	}
+	if err != nil {
+		manifest.Error = redactor.redact(err.Error())
+	}
+	if exited {
+		code := exitCode(err)
+		manifest.ExitCode = &code
+	}
+
+	req.rec.mu.Lock()
+	for _, cmd := range req.rec.commands {
+		redactor.addSecretEnv(cmd.Env)
+	}
+	for _, cmd := range req.rec.commands {
+		manifest.Commands = append(manifest.Commands, reproCommand{
+			Args:     redactor.redactAll(cmd.Args),
+			Dir:      cmd.Dir,
+			EnvNames: envNames(cmd.Environ()),
+		})
+	}
+	files := req.rec.files
+	req.rec.mu.Unlock()
+	for _, f := range files {
+		manifest.Files = append(manifest.Files, "files/"+f.name)
+	}
+
+	data, jsonErr := json.MarshalIndent(manifest, "", "  ")
+	if jsonErr != nil {
+		return jsonErr
+	}
+	entries := []reproFile{{name: "manifest.json", data: data}}
+	for _, f := range files {
+		entries = append(entries, reproFile{name: "files/" + f.name, data: []byte(redactor.redact(string(f.data)))})
+	}
+	return writeTarGz(req.path, entries, manifest.Created)
+}
+
+// writeLogged writes the bundle, logging the failures
+func (req *reproRequest) writeLogged(err error, exited bool) {
+	if writeErr := req.write(err, exited); writeErr != nil {
+		common.GetLogger().Error("Failed to write the repro bundle %s: %v", req.path, writeErr)
+		return
+	}
+	common.GetLogger().Info("Wrote the repro bundle of execution %s to %s", req.id, req.path)
+}
+
+// writeTarGz writes files to a gzipped tarball
+func writeTarGz(path string, files []reproFile, modTime time.Time) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		header := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.data)), ModTime: modTime}
+		if err = tw.WriteHeader(header); err != nil {
+			break
+		}
+		if _, err = tw.Write(f.data); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// envNames returns the sorted names of environment variables
+func envNames(env []string) []string {
+	names := []string{}
+	for _, e := range env {
+		name, _, _ := strings.Cut(e, "=")
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runnerPolicy returns the type and the parsed options of a runner
+func runnerPolicy(r Runner) (Type, interface{}) {
+	switch r := r.(type) {
+	case *Exec:
+		return TypeExec, r.options
+	case *SandboxExec:
+		return TypeSandboxExec, r.options
+	case *Firejail:
+		return TypeFirejail, r.options
+	case *Landrun:
+		return TypeLandrun, r.options
+	case *Nsjail:
+		return TypeNsjail, r.options
+	case *Docker:
+		return r.opts.runnerType(), r.opts
+	case *ADB:
+		return TypeADB, r.options
+	case *Proot:
+		return TypeProot, r.options
+	case *Deno:
+		return TypeDeno, r.options
+	case *Python:
+		return TypePython, r.options
+	case *WindowsRestricted:
+		return TypeWindowsRestricted, r.options
+	case *Unshare:
+		return TypeUnshare, r.options
+	case *ApprovalGate:
+		return runnerPolicy(r.runner)
+	case *PolicyGate:
+		return runnerPolicy(r.runner)
+	}
+	return "", nil
+}
+
+// toolVersionCommands are the commands printing the version of the tool of
+// each runner type
+var toolVersionCommands = map[Type][]string{
+	TypeFirejail: {"firejail", "--version"},
+	TypeLandrun:  {"landrun", "--version"},
+	TypeDocker:   {"docker", "version", "--format", "{{.Client.Version}} (server {{.Server.Version}})"},
+	TypePodman:   {"podman", "version", "--format", "{{.Client.Version}}"},
+	TypeADB:      {"adb", "version"},
+	TypeProot:    {"proot", "--version"},
+	TypeDeno:     {"deno", "--version"},
+	TypePython:   {"python3", "--version"},
+	TypeUnshare:  {"unshare", "--version"},
+}
+
+// reproVersionsOf returns the versions of the components used by a runner type
+func reproVersionsOf(runnerType Type) reproVersions {
+	versions := reproVersions{
+		Module: "(devel)",
+		Go:     runtime.Version(),
+		OS:     runtime.GOOS,
+		Arch:   runtime.GOARCH,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path == modulePath {
+			versions.Module = info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath {
+				versions.Module = dep.Version
+			}
+		}
+	}
+	if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		versions.Kernel = strings.TrimSpace(string(release))
+	}
+
+	if _, ok := toolVersionCommands[runnerType]; ok {
+		tool, err := toolVersion(context.Background(), runnerType)
+		if err != nil {
+			tool = fmt.Sprintf("unknown (%v)", err)
+		}
+		versions.Tool = tool
+	}
+	return versions
+}
+
+// toolVersion returns the first line printed by the version command of the
+// tool of a runner type, or "" when the type has no tool
+func toolVersion(ctx context.Context, runnerType Type) (string, error) {
+	args, ok := toolVersionCommands[runnerType]
+	if !ok {
+		return "", nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", args[0], err)
+	}
+	version, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	return version, nil
+}
+
+// modulePath is the path of the module of this package
+const modulePath = "github.com/inercia/go-restricted-runner"
+
+// runnerOptions returns the type and the options of a runner, as Options
+func runnerOptions(r Runner) (Type, Options, error) {
+	runnerType, parsed := runnerPolicy(r)
+	options := Options{}
+	if parsed != nil {
+		data, err := json.Marshal(parsed)
+		if err == nil {
+			err = json.Unmarshal(data, &options)
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read the options of the runner: %w", err)
+		}
+	}
+	return runnerType, options, nil
+}
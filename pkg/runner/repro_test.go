@@ -0,0 +1,134 @@
+package runner
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// readReproBundle returns the files of a repro bundle
+func readReproBundle(t *testing.T, path string) map[string]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open the bundle: %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("failed to read the bundle: %v", err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("failed to read the bundle: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("failed to read the bundle: %v", err)
+		}
+		files[header.Name] = string(data)
+	}
+}
+
+func TestWithReproBundle(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+
+	r, err := NewExec(Options{"umask": "077"}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "repro.tar.gz")
+	e, err := Start(context.Background(), r, "sh", []string{"-c", "echo $API_KEY; exit 3"},
+		[]string{"API_KEY=hunter2-secret"}, map[string]interface{}{"auth_token": "tok-123456"}, WithReproBundle(path))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	_ = e.Stdin.Close()
+	_, _ = io.ReadAll(e.Stdout)
+	_, _ = io.ReadAll(e.Stderr)
+	_ = e.Wait()
+
+	files := readReproBundle(t, path)
+	data, ok := files["manifest.json"]
+	if !ok {
+		t.Fatalf("the bundle has no manifest: %v", files)
+	}
+	if strings.Contains(data, "hunter2-secret") || strings.Contains(data, "tok-123456") {
+		t.Errorf("the manifest contains secrets: %s", data)
+	}
+
+	var manifest reproManifest
+	if err := json.Unmarshal([]byte(data), &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	if manifest.Runner != TypeExec || manifest.ExecutionID != e.ID {
+		t.Errorf("manifest runner = %q, execution = %q", manifest.Runner, manifest.ExecutionID)
+	}
+	if manifest.ExitCode == nil || *manifest.ExitCode != 3 {
+		t.Errorf("manifest exit code = %v, want 3", manifest.ExitCode)
+	}
+	if strings.Join(manifest.EnvNames, ",") != "API_KEY" {
+		t.Errorf("manifest env names = %v, want API_KEY", manifest.EnvNames)
+	}
+	if manifest.Params["auth_token"] != RedactedValue {
+		t.Errorf("manifest params = %v, want the token redacted", manifest.Params)
+	}
+	// the command is recorded as started, through the umask shell
+	if len(manifest.Commands) != 1 || manifest.Commands[0].Args[0] != "/bin/sh" ||
+		!strings.Contains(strings.Join(manifest.Commands[0].Args, " "), "umask 077") {
+		t.Errorf("manifest commands = %+v, want the umask wrapper", manifest.Commands)
+	}
+	if manifest.Versions.Go != runtime.Version() || manifest.Versions.OS != runtime.GOOS {
+		t.Errorf("manifest versions = %+v", manifest.Versions)
+	}
+}
+
+func TestWithReproBundle_startFailure(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "repro.tar.gz")
+	if _, err := Start(context.Background(), r, "/nonexistent/command", nil, nil, nil, WithReproBundle(path)); err == nil {
+		t.Fatalf("Start should fail")
+	}
+
+	var manifest reproManifest
+	if err := json.Unmarshal([]byte(readReproBundle(t, path)["manifest.json"]), &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	if manifest.Error == "" || manifest.ExitCode != nil {
+		t.Errorf("manifest error = %q, exit code = %v, want the start error only", manifest.Error, manifest.ExitCode)
+	}
+}
+
+func TestReproRedactor(t *testing.T) {
+	redactor := &reproRedactor{}
+	redactor.addEnv([]string{"TOKEN=abcd1234", "SHORT=ab"})
+	redactor.addSecretEnv([]string{"HOME=/home/user", "DB_PASSWORD=swordfish"})
+	redactor.addNamed(map[string]interface{}{
+		"image":    "alpine",
+		"registry": map[string]interface{}{"password": "p4ssw0rd", "user": "bob"},
+	}, false)
+
+	got := redactor.redact("-e TOKEN=abcd1234 -e SHORT=ab HOME=/home/user swordfish p4ssw0rd alpine bob")
+	want := "-e TOKEN=[REDACTED] -e SHORT=ab HOME=/home/user [REDACTED] [REDACTED] alpine bob"
+	if got != want {
+		t.Errorf("redact() = %q, want %q", got, want)
+	}
+}
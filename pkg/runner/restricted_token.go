@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// RestrictedTokenOptions runs the commands with a restricted access token on
+// Windows. Job Objects limit the resources of the commands, but not their
+// authority: a command runs with all the groups and privileges of the user
+// running this process, and can write anywhere they can. A restricted token
+// drops the privileges, makes groups such as the Administrators deny-only,
+// and lowers the integrity level, so the command cannot write to the objects
+// of higher integrity (i.e. most of the filesystem and the registry).
+type RestrictedTokenOptions struct {
+	// RestrictedToken is the token of the commands (Windows only)
+	RestrictedToken *RestrictedToken `json:"restricted_token"`
+}
+
+// RestrictedToken describes the restrictions of the token of the commands,
+// derived from the token of this process
+type RestrictedToken struct {
+	// DropPrivileges removes all the privileges of the token, except
+	// SeChangeNotifyPrivilege
+	DropPrivileges bool `json:"drop_privileges"`
+
+	// IntegrityLevel is the integrity level of the token: "low" or "medium"
+	// (default: the level of this process). Commands of low integrity can
+	// only write to the folders labeled as low integrity.
+	IntegrityLevel string `json:"integrity_level"`
+
+	// DisableGroups are the groups of the token made deny-only: they do not
+	// grant access, but still deny it. They are given by SID (e.g.
+	// "S-1-5-32-544") or by account name (e.g. "BUILTIN\\Administrators").
+	DisableGroups []string `json:"disable_groups"`
+}
+
+// integrityLevelSIDs are the SIDs of the mandatory integrity levels
+var integrityLevelSIDs = map[string]string{
+	"low":    "S-1-16-4096",
+	"medium": "S-1-16-8192",
+}
+
+// validateRestrictedToken checks the restricted token options, only
+// supported on Windows
+func (o RestrictedTokenOptions) validateRestrictedToken() error {
+	t := o.RestrictedToken
+	if t == nil {
+		return nil
+	}
+	if runtime.GOOS != "windows" {
+		return fmt.Errorf("restricted_token requires Windows: %w", ErrNotSupported)
+	}
+	if _, ok := integrityLevelSIDs[t.IntegrityLevel]; t.IntegrityLevel != "" && !ok {
+		return fmt.Errorf("invalid integrity_level %q: must be low or medium", t.IntegrityLevel)
+	}
+	for _, group := range t.DisableGroups {
+		if group == "" {
+			return fmt.Errorf("invalid empty group in disable_groups")
+		}
+	}
+	return nil
+}
+
+// applyRestrictedToken makes a command, not started yet, run with the
+// restricted token, returning a function releasing the token once the
+// command has started (or failed to)
+func (o RestrictedTokenOptions) applyRestrictedToken(logger Logger, cmd *exec.Cmd) (func(), error) {
+	if o.RestrictedToken == nil {
+		return func() {}, nil
+	}
+	logger.Debug("Running command with a restricted token: %+v", *o.RestrictedToken)
+	return o.RestrictedToken.apply(cmd)
+}
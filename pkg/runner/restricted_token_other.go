@@ -0,0 +1,10 @@
+//go:build !windows
+
+package runner
+
+import "os/exec"
+
+// apply is only supported on Windows, where access tokens exist
+func (t *RestrictedToken) apply(cmd *exec.Cmd) (func(), error) {
+	return nil, ErrNotSupported
+}
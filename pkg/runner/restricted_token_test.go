@@ -0,0 +1,53 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestNewExec_restrictedToken(t *testing.T) {
+	options := Options{"restricted_token": map[string]interface{}{"integrity_level": "low"}}
+	_, err := NewExec(options, nil)
+	if runtime.GOOS != "windows" {
+		if !errors.Is(err, ErrNotSupported) {
+			t.Errorf("NewExec() with a restricted token = %v, want ErrNotSupported", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Errorf("NewExec failed: %v", err)
+	}
+
+	for _, token := range []map[string]interface{}{
+		{"integrity_level": "system"},
+		{"disable_groups": []string{""}},
+	} {
+		if _, err := NewExec(Options{"restricted_token": token}, nil); err == nil {
+			t.Errorf("NewExec() with the restricted token %v should fail", token)
+		}
+	}
+}
+
+func TestExec_restrictedToken(t *testing.T) {
+	if runtime.GOOS != "windows" {
+		t.Skip("restricted tokens require Windows")
+	}
+	r, err := NewExec(Options{"restricted_token": map[string]interface{}{
+		"drop_privileges": true,
+		"integrity_level": "low",
+		"disable_groups":  []string{"S-1-5-32-544"},
+	}}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	output, err := r.Run(context.Background(), "cmd", "whoami /groups", nil, nil, false)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(output, "Low Mandatory Level") {
+		t.Errorf("the command does not run with low integrity:\n%s", output)
+	}
+}
@@ -0,0 +1,92 @@
+//go:build windows
+
+package runner
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Flags of CreateRestrictedToken
+const (
+	disableMaxPrivilege = 0x1
+)
+
+var procCreateRestrictedToken = windows.NewLazySystemDLL("advapi32.dll").NewProc("CreateRestrictedToken")
+
+// apply creates the restricted token from the token of this process, and
+// makes the command run with it (with CreateProcessAsUser)
+func (t *RestrictedToken) apply(cmd *exec.Cmd) (func(), error) {
+	var current windows.Token
+	access := uint32(windows.TOKEN_DUPLICATE | windows.TOKEN_QUERY | windows.TOKEN_ASSIGN_PRIMARY |
+		windows.TOKEN_ADJUST_DEFAULT | windows.TOKEN_ADJUST_SESSIONID)
+	if err := windows.OpenProcessToken(windows.CurrentProcess(), access, &current); err != nil {
+		return nil, fmt.Errorf("failed to open the token of the process: %w", err)
+	}
+	defer func() { _ = current.Close() }()
+
+	disabled := make([]windows.SIDAndAttributes, 0, len(t.DisableGroups))
+	for _, group := range t.DisableGroups {
+		sid, err := groupSID(group)
+		if err != nil {
+			return nil, err
+		}
+		disabled = append(disabled, windows.SIDAndAttributes{Sid: sid})
+	}
+	var flags uintptr
+	if t.DropPrivileges {
+		flags |= disableMaxPrivilege
+	}
+	var disabledPtr uintptr
+	if len(disabled) > 0 {
+		disabledPtr = uintptr(unsafe.Pointer(&disabled[0]))
+	}
+
+	var restricted windows.Token
+	r, _, err := procCreateRestrictedToken.Call(uintptr(current), flags, uintptr(len(disabled)), disabledPtr,
+		0, 0, 0, 0, uintptr(unsafe.Pointer(&restricted)))
+	if r == 0 {
+		return nil, fmt.Errorf("failed to create the restricted token: %w", err)
+	}
+
+	if t.IntegrityLevel != "" {
+		sid, err := windows.StringToSid(integrityLevelSIDs[t.IntegrityLevel])
+		if err != nil {
+			_ = restricted.Close()
+			return nil, err
+		}
+		label := windows.Tokenmandatorylabel{Label: windows.SIDAndAttributes{Sid: sid, Attributes: windows.SE_GROUP_INTEGRITY}}
+		if err := windows.SetTokenInformation(restricted, windows.TokenIntegrityLevel,
+			(*byte)(unsafe.Pointer(&label)), label.Size()); err != nil {
+			_ = restricted.Close()
+			return nil, fmt.Errorf("failed to set the %s integrity level: %w", t.IntegrityLevel, err)
+		}
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Token = syscall.Token(restricted)
+	return func() { _ = restricted.Close() }, nil
+}
+
+// groupSID returns the SID of a group given by SID or account name
+func groupSID(group string) (*windows.SID, error) {
+	if strings.HasPrefix(strings.ToUpper(group), "S-") {
+		sid, err := windows.StringToSid(group)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group SID %q: %w", group, err)
+		}
+		return sid, nil
+	}
+	sid, _, _, err := windows.LookupSID("", group)
+	if err != nil {
+		return nil, fmt.Errorf("unknown group %q: %w", group, err)
+	}
+	return sid, nil
+}
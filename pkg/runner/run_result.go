@@ -0,0 +1,221 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// RunRequest is a command run with RunEx, with the parameters of Run
+type RunRequest struct {
+	// Shell is the shell to use for execution (empty for default)
+	Shell string
+
+	// Command is the command to execute
+	Command string
+
+	// Env are the environment variables, in KEY=VALUE format
+	Env []string
+
+	// Params are the template parameters for variable substitution
+	Params map[string]interface{}
+
+	// TmpFile is whether to use a temporary file for the command
+	TmpFile bool
+
+	// MaxOutputBytes is the maximum size of Stdout and Stderr in the
+	// result, each (0 for no limit)
+	MaxOutputBytes int
+
+	// Stdout and Stderr receive the output of the command while it runs, if
+	// not nil, so long-running commands can be followed (see RunStreaming).
+	// Their errors are ignored, and they are not written to concurrently.
+	// The output of the runners without a capture (see RunExFromRun) is
+	// written to Stdout once the command has finished.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// RunResult is the result of a command run with RunEx
+type RunResult struct {
+	// ExitCode is the exit code of the command: 0 on success, the exit code
+	// of the command when it failed, or 128 plus the signal number when it
+	// was killed by a signal (see ExitStatus.Code)
+	ExitCode int
+
+	// Status is the exit status of the command, normalized across runners
+	Status ExitStatus
+
+	// Stdout and Stderr are the output of the command, not trimmed
+	Stdout []byte
+	Stderr []byte
+
+	// Duration is how long the command took
+	Duration time.Duration
+	// Truncated is whether Stdout or Stderr were cut to MaxOutputBytes, or
+	// to the output limits of the runner (see OutputLimitOptions)
+	// Truncated is whether Stdout or Stderr were cut to MaxOutputBytes
+	Truncated bool
+
+	// Host describes the host and the backend of the runner
+	Host HostInfo
+}
+
+// runCaptureKey is the context key of the runCapture of a RunEx call
+type runCaptureKey struct{}
+
+// runCapture receives the buffers of the output of the command run by a Run
+// call, for RunEx. Runners running several commands (e.g. the Python
+// runner preparing its virtualenv) capture the output of the last one, and
+// stream the output of all of them.
+type runCapture struct {
+	mu     sync.Mutex
+	stdout *bytes.Buffer
+	stderr *bytes.Buffer
+
+	// streamStdout and streamStderr receive the output while the commands run
+	streamStdout io.Writer
+	streamStderr io.Writer
+
+	// truncated is set when the output limits of the runner discarded some
+	// output (see OutputLimitOptions)
+	truncated bool
+}
+
+// captureRunOutput makes the buffers receiving the output of the command of
+// a Run call the output of RunEx, when called by it. It returns the writers
+// the command must write its output to: the buffers, or the buffers and the
+// writers streaming the output of RunEx.
+func captureRunOutput(ctx context.Context, stdout *bytes.Buffer, stderr *bytes.Buffer) (io.Writer, io.Writer) {
+	c, ok := ctx.Value(runCaptureKey{}).(*runCapture)
+	if !ok {
+		return stdout, stderr
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stdout, c.stderr = stdout, stderr
+	return teeOutput(stdout, c.streamStdout), teeOutput(stderr, c.streamStderr)
+}
+
+// markRunOutputTruncated records that the output limits of the runner
+// discarded some output of the command of a Run call, for RunEx
+func markRunOutputTruncated(ctx context.Context) {
+	if c, ok := ctx.Value(runCaptureKey{}).(*runCapture); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.truncated = true
+	}
+}
+
+// teeOutput returns a writer writing to a buffer and to stream, if not nil
+func teeOutput(buf *bytes.Buffer, stream io.Writer) io.Writer {
+	if stream == nil {
+		return buf
+	}
+	return &streamWriter{buf: buf, stream: stream}
+}
+
+// streamWriter writes to a buffer and to a stream, ignoring the errors of
+// the stream (which is not written to after the first one), so a failing
+// consumer does not fail the command
+type streamWriter struct {
+	buf    *bytes.Buffer
+	stream io.Writer
+	failed bool
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	n, err := w.buf.Write(p)
+	if !w.failed {
+		if _, streamErr := w.stream.Write(p); streamErr != nil {
+			w.failed = true
+		}
+	}
+	return n, err
+}
+
+// RunEx executes a command with a runner, returning a RunResult (see
+// RunnerEx). Runners not implementing RunnerEx run the command with their Run
+// method, as in RunExFromRun.
+func RunEx(ctx context.Context, r Runner, req RunRequest) (*RunResult, error) {
+	if rx, ok := r.(RunnerEx); ok {
+		return rx.RunEx(ctx, req)
+	}
+	return runEx(ctx, r, req)
+}
+
+// RunExFromRun implements RunEx with the Run method of a runner, for the
+// runner types provided by other modules (see Register). Only the trimmed
+// output of the successful commands is available, in Stdout.
+func RunExFromRun(ctx context.Context, r Runner, req RunRequest) (*RunResult, error) {
+	return runEx(ctx, r, req)
+}
+
+// runEx implements RunEx with the Run method of a runner. Runs failing with
+// the exit status of the command return a result, while commands that could
+// not run, were interrupted by ctx or the timeout of the runner, or failed in
+// the backend return an error.
+func runEx(ctx context.Context, r Runner, req RunRequest) (*RunResult, error) {
+	capture := &runCapture{streamStdout: req.Stdout, streamStderr: req.Stderr}
+	ctx = context.WithValue(ctx, runCaptureKey{}, capture)
+
+	started := time.Now()
+	output, err := r.Run(ctx, req.Shell, req.Command, req.Env, req.Params, req.TmpFile)
+	if errors.Is(err, ErrOutputTruncated) {
+		// reported with Truncated
+		err = nil
+	}
+	result := &RunResult{
+		Duration: time.Since(started),
+		Status:   NormalizeExit(r, err),
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("command interrupted: %w (%v)", ctx.Err(), err)
+		}
+		if errors.Is(err, ErrTimeout) {
+			return nil, err
+		}
+		switch result.Status.Kind {
+		case ErrorKindUnknown, ErrorKindBackend, ErrorKindNoSpace:
+			return nil, err
+		case ErrorKindPermissionDenied:
+			if result.Status.Raw == -1 {
+				// the command could not be started
+				return nil, err
+			}
+		}
+	}
+	result.ExitCode = result.Status.Code
+	result.Host = hostInfoOf(r)
+
+	capture.mu.Lock()
+	defer capture.mu.Unlock()
+	result.Truncated = capture.truncated
+	if capture.stdout != nil {
+		result.Stdout = capture.stdout.Bytes()
+		result.Stderr = capture.stderr.Bytes()
+	} else {
+		// runners without a capture (see RunExFromRun)
+		result.Stdout = []byte(output)
+		if req.Stdout != nil {
+			_, _ = req.Stdout.Write(result.Stdout)
+		}
+	}
+	result.Stdout, result.Truncated = truncateOutput(result.Stdout, req.MaxOutputBytes, result.Truncated)
+	result.Stderr, result.Truncated = truncateOutput(result.Stderr, req.MaxOutputBytes, result.Truncated)
+	return result, nil
+}
+
+// truncateOutput cuts output to max bytes (if not 0), also returning
+// whether it or the previous output was truncated
+func truncateOutput(output []byte, max int, truncated bool) ([]byte, bool) {
+	if max > 0 && len(output) > max {
+		return output[:max], true
+	}
+	return output, truncated
+}
@@ -0,0 +1,101 @@
+package runner
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRunEx(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	ctx := context.Background()
+
+	result, err := r.RunEx(ctx, RunRequest{Shell: "/bin/sh", Command: "echo ' out '; echo warning >&2"})
+	if err != nil {
+		t.Fatalf("RunEx failed: %v", err)
+	}
+	if result.ExitCode != 0 || string(result.Stdout) != " out \n" || string(result.Stderr) != "warning\n" || result.Duration <= 0 {
+		t.Errorf("RunEx() = %+v", result)
+	}
+
+	result, err = r.RunEx(ctx, RunRequest{Shell: "/bin/sh", Command: "echo partial; echo failed >&2; exit 3"})
+	if err != nil {
+		t.Fatalf("RunEx of a failing command returned an error: %v", err)
+	}
+	if result.ExitCode != 3 || result.Status.Kind != ErrorKindFailed || string(result.Stdout) != "partial\n" || string(result.Stderr) != "failed\n" {
+		t.Errorf("RunEx() of a failing command = %+v", result)
+	}
+
+	result, err = r.RunEx(ctx, RunRequest{Shell: "/bin/sh", Command: "echo 0123456789; echo abc >&2", MaxOutputBytes: 4})
+	if err != nil {
+		t.Fatalf("RunEx failed: %v", err)
+	}
+	if !result.Truncated || string(result.Stdout) != "0123" || string(result.Stderr) != "abc\n" {
+		t.Errorf("RunEx() with MaxOutputBytes = %+v", result)
+	}
+
+	if _, err := r.RunEx(ctx, RunRequest{Shell: "/nonexistent/shell", Command: "echo hello"}); err == nil {
+		t.Errorf("RunEx() with a missing shell should fail")
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := r.RunEx(timeout, RunRequest{Shell: "/bin/sh", Command: "sleep 2"}); err == nil {
+		t.Errorf("RunEx() of an interrupted command should fail")
+	}
+}
+
+// runOnly implements Run only
+type runOnly struct{ Runner }
+
+func (r runOnly) Run(ctx context.Context, shell string, command string, env []string, params map[string]interface{}, tmpfile bool) (string, error) {
+	return "  " + command + "  ", nil
+}
+
+func TestRunExFromRun(t *testing.T) {
+	result, err := RunExFromRun(context.Background(), runOnly{}, RunRequest{Command: "hello"})
+	if err != nil || result.ExitCode != 0 || string(result.Stdout) != "  hello  " {
+		t.Errorf("RunExFromRun() = %+v, %v", result, err)
+	}
+}
+
+func TestRunExRunner(t *testing.T) {
+	for _, r := range []Runner{
+		(*ADB)(nil), (*Deno)(nil), (*Docker)(nil), (*Exec)(nil), (*Firejail)(nil),
+		(*Landrun)(nil), (*Nsjail)(nil), (*Proot)(nil), (*Python)(nil), (*SandboxExec)(nil),
+		(*Unshare)(nil), (*WindowsRestricted)(nil), (*PolicyGate)(nil), (*ApprovalGate)(nil),
+		(*WarmPool)(nil), (*Session)(nil),
+	} {
+		if _, ok := r.(RunnerEx); !ok {
+			t.Errorf("%T does not implement RunnerEx", r)
+		}
+	}
+
+	// runners without RunEx run the command with Run
+	result, err := RunEx(context.Background(), runOnly{}, RunRequest{Command: "hello"})
+	if err != nil || result.ExitCode != 0 || string(result.Stdout) != "  hello  " {
+		t.Errorf("RunEx() of a runner without RunEx = %+v, %v", result, err)
+	}
+	if _, ok := Runner(runOnly{}).(RunnerEx); ok {
+		t.Fatalf("runOnly should not implement RunnerEx")
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	result, err = RunEx(context.Background(), r, RunRequest{Shell: "/bin/sh", Command: "echo failed >&2; exit 3"})
+	if err != nil || result.ExitCode != 3 || string(result.Stderr) != "failed\n" {
+		t.Errorf("RunEx() = %+v, %v", result, err)
+	}
+}
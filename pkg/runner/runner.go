@@ -2,7 +2,8 @@
 //
 // This package defines the Runner interface and implementations for executing
 // commands in various isolation environments including direct execution,
-// firejail (Linux), sandbox-exec (macOS), and Docker containers.
+// firejail (Linux), sandbox-exec (macOS), Docker containers and Android
+// devices (adb).
 package runner
 
 import (
@@ -10,8 +11,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-
-	"github.com/inercia/go-restricted-runner/pkg/common"
 )
 
 // Type is an identifier for the type of runner to use.
@@ -36,11 +35,48 @@ const (
 	// Implicit requirements: OS=linux, kernel>=5.13 with Landlock enabled
 	TypeLandrun Type = "landrun"
 
+	// TypeNsjail is the Linux-specific nsjail runner
+	// Implicit requirements: OS=linux, executables=[nsjail]
+	TypeNsjail Type = "nsjail"
+
 	// TypeDocker is the Docker-based runner
 	// Implicit requirements: executables=[docker]
 	TypeDocker Type = "docker"
+
+	// TypePodman is the Docker runner driving Podman, usually rootless
+	// Implicit requirements: executables=[podman]
+	TypePodman Type = "podman"
+
+	// TypeADB runs commands on an Android device or emulator through adb
+	// Implicit requirements: executables=[adb], a connected device
+	TypeADB Type = "adb"
+
+	// TypeProot runs commands inside an alternative root filesystem with proot
+	// Implicit requirements: OS=linux, executables=[proot]
+	TypeProot Type = "proot"
+
+	// TypeDeno runs JavaScript/TypeScript tools under the Deno permission system
+	// Implicit requirements: executables=[deno]
+	TypeDeno Type = "deno"
+
+	// TypePython runs Python scripts in a managed virtualenv through a sandbox runner
+	// Implicit requirements: executables=[python3], plus those of the sandbox runner
+	TypePython Type = "python"
+
+	// TypeWindowsRestricted runs commands in an AppContainer and a Job Object
+	// Implicit requirements: OS=windows
+	TypeWindowsRestricted Type = "windows-restricted"
+
+	// TypeUnshare runs commands in new namespaces (and optionally a chroot) with unshare
+	// Implicit requirements: OS=linux, executables=[unshare]
+	TypeUnshare Type = "unshare"
 )
 
+// LogCategoryEnvironment is the logging category of the lines logged for every
+// environment variable passed to a command, which can be sampled with
+// common.Logger.SetSampling in services running many commands
+const LogCategoryEnvironment = "environment"
+
 // Options is a map of options for the runner
 type Options map[string]interface{}
 
@@ -118,48 +154,119 @@ type Runner interface {
 	CheckImplicitRequirements() error
 }
 
-// New creates a new Runner based on the given type.
+// RunnerEx is implemented by the runners that can report the exit code and
+// both output streams of their commands. All the runners of this package
+// implement it, while other runners are supported by RunEx with their Run
+// method.
+type RunnerEx interface {
+	Runner
+
+	// RunEx executes a command like Run, returning a RunResult with its exit
+	// code, its standard output and error (not trimmed) and its duration.
+	//
+	// Commands exiting with a non-zero status return a result, and no
+	// error, so they can be told apart from the commands that could not be
+	// run: an error is returned when the command could not be started, was
+	// interrupted by ctx or failed in the backend (e.g. the Docker daemon).
+	RunEx(ctx context.Context, req RunRequest) (*RunResult, error)
+}
+
+// New creates a new Runner based on the given type, built-in or registered
+// with Register.
+//
+// The options can reference a profile loaded with UseProfiles (see
+// ProfileKey), which is applied first. Deprecated options are logged as
+// warnings and replaced with their current names (see Migrate), and the
+// experimental features enabled are logged.
 //
 // Parameters:
 //   - runnerType: The type of runner to create
 //   - options: Configuration options for the runner
-//   - logger: Logger for debug output (uses global logger if nil)
+//   - logger: Logger for debug output (a *common.Logger or an adapter, uses the global logger if nil)
 //
 // Returns:
 //   - A Runner instance if successful
 //   - An error if creation fails or requirements are not met
-func New(runnerType Type, options Options, logger *common.Logger) (Runner, error) {
+func New(runnerType Type, options Options, logger Logger) (Runner, error) {
+	logger = defaultLogger(logger)
 	var runner Runner
 	var err error
 
-	// Create the runner instance based on type
-	switch runnerType {
-	case TypeExec:
-		runner, err = NewExec(options, logger)
-	case TypeSandboxExec:
-		runner, err = NewSandboxExec(options, logger)
-	case TypeFirejail:
-		runner, err = NewFirejail(options, logger)
-	case TypeLandrun:
-		runner, err = NewLandrun(options, logger)
-	case TypeDocker:
-		runner, err = NewDocker(options, logger)
-	default:
-		return nil, fmt.Errorf("unknown runner type: %s", runnerType)
+	// Apply the profile referenced by the options, if any
+	options, err = applyProfile(runnerType, options, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Evaluate the options with the policy engine, if any
+	engine := currentPolicyEngine()
+	if engine != nil {
+		options, err = applyPolicyEngine(engine, runnerType, options, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Replace deprecated options and report experimental features
+	options, err = checkOptions(runnerType, options, logger)
+	if err != nil {
+		return nil, err
 	}
 
-	// Check if runner creation failed
+	// Create the runner instance based on type
+	runner, err = newRunner(runnerType, options, logger)
 	if err != nil {
 		return nil, err
 	}
 
 	// Check implicit requirements for the created runner
 	if err := runner.CheckImplicitRequirements(); err != nil {
-		if logger != nil {
-			logger.Debug("Runner %s failed implicit requirements check: %v", runnerType, err)
-		}
+		logger.Debug("Runner %s failed implicit requirements check: %v", runnerType, err)
 		return nil, err
 	}
 
+	// Evaluate each execution with the policy engine, if any
+	if engine != nil {
+		return NewPolicyGate(runner, engine, logger)
+	}
+
 	return runner, nil
 }
+
+// newRunner creates a runner of a type, built-in or registered, without
+// checking its implicit requirements
+func newRunner(runnerType Type, options Options, logger Logger) (Runner, error) {
+	switch runnerType {
+	case TypeExec:
+		return NewExec(options, logger)
+	case TypeSandboxExec:
+		return NewSandboxExec(options, logger)
+	case TypeFirejail:
+		return NewFirejail(options, logger)
+	case TypeLandrun:
+		return NewLandrun(options, logger)
+	case TypeNsjail:
+		return NewNsjail(options, logger)
+	case TypeDocker:
+		return NewDocker(options, logger)
+	case TypePodman:
+		return NewPodman(options, logger)
+	case TypeADB:
+		return NewADB(options, logger)
+	case TypeProot:
+		return NewProot(options, logger)
+	case TypeDeno:
+		return NewDeno(options, logger)
+	case TypePython:
+		return NewPython(options, logger)
+	case TypeWindowsRestricted:
+		return NewWindowsRestricted(options, logger)
+	case TypeUnshare:
+		return NewUnshare(options, logger)
+	}
+	backend, ok := registeredBackend(runnerType)
+	if !ok {
+		return nil, fmt.Errorf("unknown runner type: %s", runnerType)
+	}
+	return backend.New(options, logger)
+}
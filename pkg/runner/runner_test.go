@@ -94,3 +94,11 @@ func TestImplicitRequirements(t *testing.T) {
 		}
 	})
 }
+
+func TestNew_nilCommonLogger(t *testing.T) {
+	// a nil *common.Logger must not be used when the requirements fail
+	_, err := New(TypeUnshare, Options{"rootfs": "/nonexistent/rootfs", "unshare_path": "true"}, (*common.Logger)(nil))
+	if err == nil {
+		t.Error("New() should fail without the root filesystem")
+	}
+}
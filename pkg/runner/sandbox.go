@@ -5,12 +5,12 @@ import (
 	"context"
 	_ "embed"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
 	"text/template"
@@ -23,7 +23,7 @@ var sandboxProfileTemplate string
 
 // SandboxExec implements the Runner interface using macOS sandbox-exec
 type SandboxExec struct {
-	logger     *common.Logger
+	logger     Logger
 	profileTpl *template.Template
 	options    SandboxExecOptions
 }
@@ -38,6 +38,57 @@ type SandboxExecOptions struct {
 	AllowReadFiles    []string `json:"allow_read_files"`
 	AllowWriteFiles   []string `json:"allow_write_files"`
 	CustomProfile     string   `json:"custom_profile"`
+
+	// AllowLoopback allows connections to and from localhost when networking is disabled
+	AllowLoopback bool `json:"allow_loopback"`
+
+	// ReportFileChanges records the files changed in AllowWriteFolders (see Execution.FileChanges)
+	ReportFileChanges bool `json:"report_file_changes"`
+
+	// Umask and file mode policy
+	FileModeOptions
+
+	// Core dump policy
+	CoreDumpOptions
+
+	// Sandbox verification with canaries
+	CanaryOptions
+
+	// Command run in the sandbox before the commands
+	PreflightOptions
+
+	// Folders searched for executables
+	PathOptions
+
+	// Clock and locale settings
+	DeterminismOptions
+
+	// Variables of the commands read from .env files
+	EnvFileOptions
+
+	// Experimental features
+	ExperimentalOptions
+
+	// CA certificates trusted by the command
+	CABundleOptions
+
+	// Access to the credential agents of the host
+	AgentOptions
+
+	// Access to the display server of the host
+	DisplayOptions
+
+	// Gatekeeper quarantine and TCC-protected folders
+	MacOSProtectionOptions
+
+	// Architecture of the command
+	ArchitectureOptions
+
+	// Timeout of the commands
+	TimeoutOptions
+
+	// Limits of the output of the commands
+	OutputLimitOptions
 }
 
 // NewSandboxExecOptions creates a new SandboxExecOptions from Options
@@ -53,10 +104,8 @@ func NewSandboxExecOptions(options Options) (SandboxExecOptions, error) {
 
 // NewSandboxExec creates a new SandboxExec runner with the provided logger.
 // If logger is nil, a default logger is created.
-func NewSandboxExec(options Options, logger *common.Logger) (*SandboxExec, error) {
-	if logger == nil {
-		logger = common.GetLogger()
-	}
+func NewSandboxExec(options Options, logger Logger) (*SandboxExec, error) {
+	logger = defaultLogger(logger)
 
 	// Parse the sandbox profile template
 	profileTpl, err := template.New("sandbox-profile").Parse(sandboxProfileTemplate)
@@ -71,6 +120,47 @@ func NewSandboxExec(options Options, logger *common.Logger) (*SandboxExec, error
 		logger.Debug("Failed to parse sandbox options: %v", err)
 		return nil, fmt.Errorf("failed to parse sandbox options: %w", err)
 	}
+	sandboxOpts.normalizeRules()
+	if err := sandboxOpts.validate(); err != nil {
+		return nil, fmt.Errorf("invalid sandbox options: %w", err)
+	}
+	if err := sandboxOpts.validateCABundle(); err != nil {
+		return nil, fmt.Errorf("invalid sandbox options: %w", err)
+	}
+	if err := sandboxOpts.validateTimeouts(); err != nil {
+		return nil, fmt.Errorf("invalid sandbox options: %w", err)
+	}
+	if err := sandboxOpts.loadEnvFiles(); err != nil {
+		return nil, fmt.Errorf("invalid sandbox options: %w", err)
+	}
+	if err := sandboxOpts.validateOutputLimits(); err != nil {
+		return nil, fmt.Errorf("invalid sandbox options: %w", err)
+	}
+	if err := sandboxOpts.validateCoreDumps(); err != nil {
+		return nil, fmt.Errorf("invalid sandbox options: %w", err)
+	}
+	if err := sandboxOpts.validateCanaries(); err != nil {
+		return nil, fmt.Errorf("invalid sandbox options: %w", err)
+	}
+	if err := sandboxOpts.validatePreflight(); err != nil {
+		return nil, fmt.Errorf("invalid sandbox options: %w", err)
+	}
+	if err := sandboxOpts.validateExtraPath(); err != nil {
+		return nil, fmt.Errorf("invalid sandbox options: %w", err)
+	}
+	if err := sandboxOpts.validatePinnedExecutables(); err != nil {
+		return nil, fmt.Errorf("invalid sandbox options: %w", err)
+	}
+	if err := sandboxOpts.validateMacOSProtection(); err != nil {
+		return nil, fmt.Errorf("invalid sandbox options: %w", err)
+	}
+	if err := sandboxOpts.validateArchitecture(); err != nil {
+		return nil, fmt.Errorf("invalid sandbox options: %w", err)
+	}
+	if sandboxOpts.FakeTime != "" {
+		// SIP removes the DYLD_* variables when executing system binaries like sandbox-exec
+		return nil, fmt.Errorf("fake_time is not supported by the sandbox-exec runner")
+	}
 
 	return &SandboxExec{
 		logger:     logger,
@@ -84,6 +174,19 @@ func NewSandboxExec(options Options, logger *common.Logger) (*SandboxExec, error
 //
 // note: tmpfile is ignored for sandbox because it's not supported
 func (r *SandboxExec) Run(ctx context.Context, shell string, command string, env []string, params map[string]interface{}, tmpfile bool) (string, error) {
+	return r.options.runWithTimeout(ctx, func(ctx context.Context) (string, error) {
+		return r.run(ctx, shell, command, env, params, tmpfile)
+	})
+}
+
+// run is Run, with the context bounded by the timeout options
+func (r *SandboxExec) run(ctx context.Context, shell string, command string, env []string, params map[string]interface{}, tmpfile bool) (string, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+	env = r.options.pathEnv(env)
+	env = r.options.caBundleEnv(env, r.options.CABundle)
+
 	fullCmd := command
 
 	// Check if context is done
@@ -94,166 +197,168 @@ func (r *SandboxExec) Run(ctx context.Context, shell string, command string, env
 		// Continue execution
 	}
 
-	// replace template variables in allow read and write folders and files
-	if len(r.options.AllowReadFolders) > 0 {
-		r.options.AllowReadFolders = common.ProcessTemplateListFlexible(r.options.AllowReadFolders, params)
-	}
-	if len(r.options.AllowWriteFolders) > 0 {
-		r.options.AllowWriteFolders = common.ProcessTemplateListFlexible(r.options.AllowWriteFolders, params)
-	}
-	if len(r.options.AllowReadFiles) > 0 {
-		r.options.AllowReadFiles = common.ProcessTemplateListFlexible(r.options.AllowReadFiles, params)
-
-		// For macOS sandbox, we need to allow read access to parent directories
-		// of files to enable directory traversal
-		for _, filePath := range r.options.AllowReadFiles {
-			dir := filepath.Dir(filePath)
-			// Add parent directory if not already in the list
-			if !contains(r.options.AllowReadFolders, dir) {
-				r.options.AllowReadFolders = append(r.options.AllowReadFolders, dir)
-				r.logger.Debug("[DEBUG] Added parent directory to allow list: %s", dir)
-			}
-		}
+	if err := r.options.verifySandbox(ctx, logger, r.canaryPolicy(ctx, params), r.start, params); err != nil {
+		return "", err
 	}
-	if len(r.options.AllowWriteFiles) > 0 {
-		r.options.AllowWriteFiles = common.ProcessTemplateListFlexible(r.options.AllowWriteFiles, params)
-
-		// For macOS sandbox, we need to allow write access to parent directories
-		// of files to enable directory traversal
-		for _, filePath := range r.options.AllowWriteFiles {
-			dir := filepath.Dir(filePath)
-			// Add parent directory if not already in the list
-			if !contains(r.options.AllowWriteFolders, dir) {
-				r.options.AllowWriteFolders = append(r.options.AllowWriteFolders, dir)
-				r.logger.Debug("[DEBUG] Added parent directory to allow list: %s", dir)
-			}
-		}
+	if err := r.options.runPreflight(ctx, logger, "/bin/sh", r.start, params); err != nil {
+		return "", err
+	}
+
+	toolsDir, removeTools, err := r.options.linkHermeticTools(logger)
+	if err != nil {
+		return "", err
+	}
+	defer removeTools()
+	env = hermeticEnv(env, toolsDir)
+	if err := r.options.verifyPinnedExecutables(logger, command); err != nil {
+		return "", err
+	}
+
+	// replace template variables in allow read and write folders and files
+	profileOpts := r.profileOptions(params)
+	protectedFolders := profileOpts.allowedFolders()
+	if err := r.options.checkMacOSProtections(logger, protectedFolders, r.options.ExtraPath, command); err != nil {
+		return "", err
 	}
 
 	// Generate the profile by rendering the template
 	var profileBuf bytes.Buffer
-	if err := r.profileTpl.Execute(&profileBuf, r.options); err != nil {
-		r.logger.Debug("Failed to render sandbox profile template: %v", err)
+	if err := r.profileTpl.Execute(&profileBuf, profileOpts); err != nil {
+		logger.Debug("Failed to render sandbox profile template: %v", err)
 		return "", fmt.Errorf("failed to render sandbox profile: %w", err)
 	}
 
 	profile := profileBuf.String()
-	r.logger.Debug("Sandbox options: %+v", r.options)
-	r.logger.Debug("Generated sandbox profile:\n%s", profile)
+	logger.Debug("Sandbox options: %+v", profileOpts)
+	logger.Debug("Generated sandbox profile:\n%s", profile)
 
 	// Create a temporary file for the sandbox profile
 	profileFile, err := os.CreateTemp("", "sandbox-profile-*.sb")
 	if err != nil {
-		r.logger.Debug("Failed to create temporary profile file: %v", err)
+		logger.Debug("Failed to create temporary profile file: %v", err)
 		return "", fmt.Errorf("failed to create temporary profile file: %w", err)
 	}
 	defer func() {
 		profileFilePath := profileFile.Name()
 		if err := profileFile.Close(); err != nil {
-			r.logger.Debug("Warning: failed to close profile file: %v", err)
+			logger.Debug("Warning: failed to close profile file: %v", err)
 		}
 		if err := os.Remove(profileFilePath); err != nil {
-			r.logger.Debug("Warning: failed to remove temporary profile file: %v", err)
+			logger.Debug("Warning: failed to remove temporary profile file: %v", err)
 		}
 	}()
 
 	// Write the profile to the temporary file
 	if _, err := profileFile.WriteString(profile); err != nil {
-		r.logger.Debug("Failed to write profile to temporary file: %v", err)
+		logger.Debug("Failed to write profile to temporary file: %v", err)
 		return "", fmt.Errorf("failed to write profile to temporary file: %w", err)
 	}
 
 	// Flush data to ensure it's written to disk
 	if err := profileFile.Sync(); err != nil {
-		r.logger.Debug("Failed to sync profile file: %v", err)
+		logger.Debug("Failed to sync profile file: %v", err)
 		return "", fmt.Errorf("failed to sync profile file: %w", err)
 	}
 
 	var execCmd *exec.Cmd
 
 	// Check if we can optimize by running a single executable directly
-	if isSingleExecutableCommand(fullCmd) {
-		r.logger.Debug("Optimization: running single executable command directly: %s", fullCmd)
-		execCmd = exec.CommandContext(ctx, "sandbox-exec", "-f", profileFile.Name(), fullCmd)
+	if r.options.directCommand(fullCmd) {
+		logger.Debug("Optimization: running single executable command directly: %s", fullCmd)
+		execCmd = commandContext(ctx, r.options.lookPath("sandbox-exec"), "-f", profileFile.Name(), fullCmd)
 	} else {
 		// Create a temporary file for the command
 		tmpScript, err := os.CreateTemp("", "sandbox-script-*.sh")
 		if err != nil {
-			r.logger.Debug("Failed to create temporary command file: %v", err)
+			logger.Debug("Failed to create temporary command file: %v", err)
 			return "", fmt.Errorf("failed to create temporary command file: %w", err)
 		}
 		// Ensure temporary file is deleted when this function exits
 		defer func() {
 			tmpScriptPath := tmpScript.Name()
 			if err := tmpScript.Close(); err != nil {
-				r.logger.Debug("Warning: failed to close script file: %v", err)
+				logger.Debug("Warning: failed to close script file: %v", err)
 			}
 			if err := os.Remove(tmpScriptPath); err != nil {
-				r.logger.Debug("Warning: failed to remove temporary script file: %v", err)
+				logger.Debug("Warning: failed to remove temporary script file: %v", err)
 			}
 		}()
 
 		// Write the command to the temporary file
 		if _, err := tmpScript.WriteString(fullCmd); err != nil {
-			r.logger.Debug("Failed to write command to temporary file: %v", err)
+			logger.Debug("Failed to write command to temporary file: %v", err)
 			return "", fmt.Errorf("failed to write command to temporary file: %w", err)
 		}
 
 		// Flush data to ensure it's written to disk
 		if err := tmpScript.Sync(); err != nil {
-			r.logger.Debug("Failed to sync script file: %v", err)
+			logger.Debug("Failed to sync script file: %v", err)
 			return "", fmt.Errorf("failed to sync script file: %w", err)
 		}
 
 		// Make the temporary file executable
 		if err := os.Chmod(tmpScript.Name(), 0o700); err != nil {
-			r.logger.Debug("Failed to make temporary file executable: %v", err)
+			logger.Debug("Failed to make temporary file executable: %v", err)
 			return "", fmt.Errorf("failed to make temporary file executable: %w", err)
 		}
 
-		execCmd = exec.CommandContext(ctx, "sandbox-exec", "-f", profileFile.Name(), tmpScript.Name())
+		execCmd = commandContext(ctx, r.options.lookPath("sandbox-exec"), "-f", profileFile.Name(), tmpScript.Name())
 	}
 
-	r.logger.Debug("Created command: %s", execCmd.String())
+	logger.Debug("Created command: %s", execCmd.String())
 
 	// Set environment variables if provided
 	if len(env) > 0 {
-		r.logger.Debug("Adding %d environment variables to command", len(env))
+		logger.Debug("Adding %d environment variables to command", len(env))
 		for _, e := range env {
-			r.logger.Debug("... adding environment variable: %s", e)
+			categoryLogger(logger, LogCategoryEnvironment).Debug("... adding environment variable: %s", e)
 		}
 		execCmd.Env = append(os.Environ(), env...)
 	}
+	execCmd.Env = r.options.agentEnv(execCmd.Env)
+	execCmd.Env = r.options.scrubDisplayEnv(execCmd.Env)
+
+	r.options.applyArchitecture(logger, execCmd)
+	applyUmask(logger, execCmd, r.options.Umask)
+	defer r.options.applyCoreDumps(logger, execCmd)()
 
 	// Capture output
 	var stdout, stderr bytes.Buffer
-	execCmd.Stdout = &stdout
-	execCmd.Stderr = &stderr
+	execCmd.Stdout, execCmd.Stderr = captureRunOutput(ctx, &stdout, &stderr)
+	var truncated func() error
+	execCmd.Stdout, execCmd.Stderr, truncated = r.options.limitOutput(ctx, execCmd.Stdout, execCmd.Stderr)
 
 	// Run the command
-	r.logger.Debug("Executing command")
+	logger.Debug("Executing command")
+	defer r.options.normalizeFileModes(logger, profileOpts.AllowWriteFolders)
 
 	if err := execCmd.Run(); err != nil {
 		// If there's error output, include it in the error
 		if stderr.Len() > 0 {
 			errMsg := strings.TrimSpace(stderr.String())
-			r.logger.Debug("Command failed with stderr: %s", errMsg)
-			return "", errors.New(errMsg)
+			logger.Debug("Command failed with stderr: %s", errMsg)
+			return "", classifyMacOSDenial(newCommandError(errMsg, err), protectedFolders, r.options.ExtraPath, command)
 		}
-		r.logger.Debug("Command failed with error: %v", err)
-		return "", err
+		logger.Debug("Command failed with error: %v", err)
+		return "", classifyMacOSDenial(err, protectedFolders, r.options.ExtraPath, command)
 	}
 
 	// Get the output
 	outputStr := strings.TrimSpace(stdout.String())
 
-	r.logger.Debug("Command executed successfully, output length: %d bytes", len(outputStr))
+	logger.Debug("Command executed successfully, output length: %d bytes", len(outputStr))
 	if stderr.Len() > 0 {
-		r.logger.Debug("Command generated stderr (but no error): %s", strings.TrimSpace(stderr.String()))
+		logger.Debug("Command generated stderr (but no error): %s", strings.TrimSpace(stderr.String()))
 	}
 
 	// Return the stdout output
-	return outputStr, nil
+	return outputStr, truncated()
+}
+
+// RunEx executes a command within the macOS sandbox like Run, returning its exit code and
+// both output streams. It implements the Runner interface.
+func (r *SandboxExec) RunEx(ctx context.Context, req RunRequest) (*RunResult, error) {
+	return runEx(ctx, r, req)
 }
 
 // RunWithPipes executes a command with access to stdin/stdout/stderr pipes within the macOS sandbox.
@@ -268,157 +373,237 @@ func (r *SandboxExec) RunWithPipes(ctx context.Context, cmd string, args []strin
 	wait func() error,
 	err error,
 ) {
+	e, err := r.start(ctx, cmd, args, env, params)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return e.Stdin, e.Stdout, e.Stderr, e.Wait, nil
+}
+
+// start executes a command in the macOS sandbox and returns an execution handle for it.
+// It is used by RunWithPipes and Start.
+func (r *SandboxExec) start(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	return r.options.startWithTimeout(ctx, func(ctx context.Context) (*Execution, error) {
+		return r.startCommand(ctx, cmd, args, env, params)
+	})
+}
+
+// startCommand is start, with the context bounded by the timeout options
+func (r *SandboxExec) startCommand(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+	env = r.options.pathEnv(env)
+	env = r.options.caBundleEnv(env, r.options.CABundle)
+
 	// Check if context is already done
 	select {
 	case <-ctx.Done():
-		return nil, nil, nil, nil, ctx.Err()
+		return nil, ctx.Err()
 	default:
 		// Continue execution
 	}
 
-	r.logger.Debug("RunWithPipes: executing command in sandbox: %s with args: %v", cmd, args)
+	if err := r.options.verifySandbox(ctx, logger, r.canaryPolicy(ctx, params), r.start, params); err != nil {
+		return nil, err
+	}
+	if err := r.options.runPreflight(ctx, logger, "/bin/sh", r.start, params); err != nil {
+		return nil, err
+	}
 
-	// Process template variables in allow read and write folders and files
-	if len(r.options.AllowReadFolders) > 0 {
-		r.options.AllowReadFolders = common.ProcessTemplateListFlexible(r.options.AllowReadFolders, params)
+	// the hermetic tools are removed once the command completes (see startProcess)
+	toolsDir, removeTools, err := r.options.linkHermeticTools(logger)
+	if err != nil {
+		return nil, err
+	}
+	started := false
+	defer func() {
+		if !started {
+			removeTools()
+		}
+	}()
+	env = hermeticEnv(env, toolsDir)
+	if cmd, err = r.options.hermeticCommand(ctx, toolsDir, cmd); err != nil {
+		return nil, err
 	}
-	if len(r.options.AllowWriteFolders) > 0 {
-		r.options.AllowWriteFolders = common.ProcessTemplateListFlexible(r.options.AllowWriteFolders, params)
+	if err := r.options.verifyPinnedExecutables(logger, append([]string{cmd}, args...)...); err != nil {
+		return nil, err
 	}
-	if len(r.options.AllowReadFiles) > 0 {
-		r.options.AllowReadFiles = common.ProcessTemplateListFlexible(r.options.AllowReadFiles, params)
+
+	logger.Debug("RunWithPipes: executing command in sandbox: %s with args: %v", cmd, args)
+
+	// Process template variables in allow read and write folders and files
+	profileOpts := r.profileOptions(params)
+	if loopbackNetworkFrom(ctx) {
+		if profileOpts.CustomProfile != "" {
+			return nil, fmt.Errorf("cannot isolate the network with a custom profile: %w", ErrNotSupported)
+		}
+		profileOpts.AllowNetworking = false
+		profileOpts.AllowLoopback = true
 	}
-	if len(r.options.AllowWriteFiles) > 0 {
-		r.options.AllowWriteFiles = common.ProcessTemplateListFlexible(r.options.AllowWriteFiles, params)
+	protectedFolders := profileOpts.allowedFolders()
+	if err := r.options.checkMacOSProtections(logger, protectedFolders, r.options.ExtraPath, append([]string{cmd}, args...)...); err != nil {
+		return nil, err
 	}
 
 	// Generate the sandbox profile
 	var profileBuf bytes.Buffer
-	if err := r.profileTpl.Execute(&profileBuf, r.options); err != nil {
-		r.logger.Debug("Failed to render sandbox profile template: %v", err)
-		return nil, nil, nil, nil, fmt.Errorf("failed to render sandbox profile: %w", err)
+	if err := r.profileTpl.Execute(&profileBuf, profileOpts); err != nil {
+		logger.Debug("Failed to render sandbox profile template: %v", err)
+		return nil, fmt.Errorf("failed to render sandbox profile: %w", err)
 	}
 
+	recordReproFile(ctx, "sandbox.sb", profileBuf.Bytes())
+
 	// Create a temporary file for the sandbox profile
 	profileFile, err := os.CreateTemp("", "sandbox-profile-*.sb")
 	if err != nil {
-		r.logger.Debug("Failed to create temporary profile file: %v", err)
-		return nil, nil, nil, nil, fmt.Errorf("failed to create temporary profile file: %w", err)
+		logger.Debug("Failed to create temporary profile file: %v", err)
+		return nil, fmt.Errorf("failed to create temporary profile file: %w", err)
 	}
 
 	// Write the profile to the file
 	if _, err := profileFile.Write(profileBuf.Bytes()); err != nil {
 		if closeErr := profileFile.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close profile file: %v", closeErr)
+			logger.Debug("Warning: failed to close profile file: %v", closeErr)
 		}
 		if removeErr := os.Remove(profileFile.Name()); removeErr != nil {
-			r.logger.Debug("Warning: failed to remove profile file: %v", removeErr)
+			logger.Debug("Warning: failed to remove profile file: %v", removeErr)
 		}
-		r.logger.Debug("Failed to write sandbox profile: %v", err)
-		return nil, nil, nil, nil, fmt.Errorf("failed to write sandbox profile: %w", err)
+		logger.Debug("Failed to write sandbox profile: %v", err)
+		return nil, fmt.Errorf("failed to write sandbox profile: %w", err)
 	}
 
 	// Close the file so sandbox-exec can read it
 	if err := profileFile.Close(); err != nil {
 		if removeErr := os.Remove(profileFile.Name()); removeErr != nil {
-			r.logger.Debug("Warning: failed to remove profile file: %v", removeErr)
+			logger.Debug("Warning: failed to remove profile file: %v", removeErr)
 		}
-		r.logger.Debug("Failed to close profile file: %v", err)
-		return nil, nil, nil, nil, fmt.Errorf("failed to close profile file: %w", err)
+		logger.Debug("Failed to close profile file: %v", err)
+		return nil, fmt.Errorf("failed to close profile file: %w", err)
 	}
 
-	r.logger.Debug("Created sandbox profile at: %s", profileFile.Name())
+	logger.Debug("Created sandbox profile at: %s", profileFile.Name())
 
 	// Build the command with sandbox-exec
 	// sandbox-exec -f <profile> <cmd> <args...>
 	sandboxArgs := []string{"-f", profileFile.Name(), cmd}
 	sandboxArgs = append(sandboxArgs, args...)
 
-	execCmd := exec.CommandContext(ctx, "sandbox-exec", sandboxArgs...)
+	execCmd := commandContext(ctx, r.options.lookPath("sandbox-exec"), sandboxArgs...)
 
 	// Set environment variables if provided
 	if len(env) > 0 {
-		r.logger.Debug("Adding %d environment variables to command", len(env))
+		logger.Debug("Adding %d environment variables to command", len(env))
 		execCmd.Env = append(os.Environ(), env...)
 	}
+	execCmd.Env = r.options.agentEnv(execCmd.Env)
+	execCmd.Env = r.options.scrubDisplayEnv(execCmd.Env)
+	execCmd.ExtraFiles = extraFilesFrom(ctx)
 
-	// Create pipes for stdin, stdout, and stderr
-	stdinPipe, err := execCmd.StdinPipe()
-	if err != nil {
-		if removeErr := os.Remove(profileFile.Name()); removeErr != nil {
-			r.logger.Debug("Warning: failed to remove profile file: %v", removeErr)
-		}
-		r.logger.Debug("Failed to create stdin pipe: %v", err)
-		return nil, nil, nil, nil, errors.New("failed to create stdin pipe: " + err.Error())
-	}
+	r.options.applyArchitecture(logger, execCmd)
+	applyUmask(logger, execCmd, r.options.Umask)
+	collectCores := r.options.applyCoreDumps(logger, execCmd)
 
-	stdoutPipe, err := execCmd.StdoutPipe()
-	if err != nil {
-		if closeErr := stdinPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdin pipe: %v", closeErr)
-		}
+	started = true
+	return startProcess(logger, execCmd, func() {
+		r.options.normalizeFileModes(logger, profileOpts.AllowWriteFolders)
+		collectCores()
+		removeTools()
 		if removeErr := os.Remove(profileFile.Name()); removeErr != nil {
-			r.logger.Debug("Warning: failed to remove profile file: %v", removeErr)
+			logger.Debug("Warning: failed to remove sandbox profile file %s: %v", profileFile.Name(), removeErr)
 		}
-		r.logger.Debug("Failed to create stdout pipe: %v", err)
-		return nil, nil, nil, nil, errors.New("failed to create stdout pipe: " + err.Error())
-	}
+	})
+}
 
-	stderrPipe, err := execCmd.StderrPipe()
-	if err != nil {
-		if closeErr := stdinPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdin pipe: %v", closeErr)
-		}
-		if closeErr := stdoutPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdout pipe: %v", closeErr)
+// profileOptions returns the options used to render the profile of a call:
+// the runner options with template variables replaced with params, the
+// parent directories of the allowed files and the staged inputs, workspace
+// and scratch directories.
+// The runner options are not modified, so templates are evaluated on every call.
+func (r *SandboxExec) profileOptions(params map[string]interface{}) SandboxExecOptions {
+	opts := r.options
+	opts.AllowReadFolders = common.ProcessTemplateListFlexible(r.options.AllowReadFolders, params)
+	opts.AllowWriteFolders = common.ProcessTemplateListFlexible(r.options.AllowWriteFolders, params)
+	opts.AllowReadFiles = common.ProcessTemplateListFlexible(r.options.AllowReadFiles, params)
+	opts.AllowWriteFiles = common.ProcessTemplateListFlexible(r.options.AllowWriteFiles, params)
+	if r.options.CABundle != "" {
+		opts.AllowReadFiles = append(opts.AllowReadFiles, r.options.CABundle)
+	}
+
+	// For macOS sandbox, we need to allow access to parent directories
+	// of files to enable directory traversal
+	for _, filePath := range opts.AllowReadFiles {
+		if dir := filepath.Dir(filePath); !contains(opts.AllowReadFolders, dir) {
+			opts.AllowReadFolders = append(opts.AllowReadFolders, dir)
+			r.logger.Debug("Added parent directory to allow list: %s", dir)
 		}
-		if removeErr := os.Remove(profileFile.Name()); removeErr != nil {
-			r.logger.Debug("Warning: failed to remove profile file: %v", removeErr)
+	}
+	for _, filePath := range opts.AllowWriteFiles {
+		if dir := filepath.Dir(filePath); !contains(opts.AllowWriteFolders, dir) {
+			opts.AllowWriteFolders = append(opts.AllowWriteFolders, dir)
+			r.logger.Debug("Added parent directory to allow list: %s", dir)
 		}
-		r.logger.Debug("Failed to create stderr pipe: %v", err)
-		return nil, nil, nil, nil, errors.New("failed to create stderr pipe: " + err.Error())
 	}
 
-	// Start the command
-	r.logger.Debug("Starting sandboxed command with pipes")
-	if err := execCmd.Start(); err != nil {
-		if closeErr := stdinPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdin pipe: %v", closeErr)
-		}
-		if closeErr := stdoutPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stdout pipe: %v", closeErr)
-		}
-		if closeErr := stderrPipe.Close(); closeErr != nil {
-			r.logger.Debug("Warning: failed to close stderr pipe: %v", closeErr)
-		}
-		if removeErr := os.Remove(profileFile.Name()); removeErr != nil {
-			r.logger.Debug("Warning: failed to remove profile file: %v", removeErr)
+	opts.AllowReadFolders = withInputsDir(opts.AllowReadFolders, params)
+	opts.AllowWriteFolders = withWritableDirs(opts.AllowWriteFolders, params)
+	opts.normalizeRules()
+	return opts
+}
+
+// sandboxImportRe matches the files imported by a sandbox profile
+var sandboxImportRe = regexp.MustCompile(`\(import\s+"([^"]+)"\s*\)`)
+
+// policyFiles returns the files imported by the custom profile (with an
+// absolute path), for the fingerprint of the runner
+func (o SandboxExecOptions) policyFiles() []string {
+	var files []string
+	for _, m := range sandboxImportRe.FindAllStringSubmatch(o.CustomProfile, -1) {
+		if filepath.IsAbs(m[1]) {
+			files = append(files, m[1])
 		}
-		r.logger.Debug("Failed to start command: %v", err)
-		return nil, nil, nil, nil, errors.New("failed to start command: " + err.Error())
 	}
+	return files
+}
 
-	r.logger.Debug("Sandboxed command started successfully with PID: %d", execCmd.Process.Pid)
+// normalizeRules sorts and deduplicates the paths of the profile
+func (o *SandboxExecOptions) normalizeRules() {
+	o.AllowReadFolders = normalizePaths(o.AllowReadFolders)
+	o.AllowWriteFolders = normalizePaths(o.AllowWriteFolders)
+	o.AllowReadFiles = normalizePaths(o.AllowReadFiles)
+	o.AllowWriteFiles = normalizePaths(o.AllowWriteFiles)
+}
 
-	// Create wait function that waits for the command to complete and cleans up
-	waitFunc := func() error {
-		r.logger.Debug("Waiting for sandboxed command to complete")
-		err := execCmd.Wait()
+// policyWarnings returns the conflicting paths of the profile
+func (r *SandboxExec) policyWarnings(context.Context) []PolicyWarning {
+	return r.policyConflicts()
+}
 
-		// Clean up the profile file
-		if removeErr := os.Remove(profileFile.Name()); removeErr != nil {
-			r.logger.Debug("Warning: failed to remove sandbox profile file %s: %v", profileFile.Name(), removeErr)
-		}
+// policyConflicts returns the paths both read-only and writable in the
+// profile, where they are writable
+func (r *SandboxExec) policyConflicts() []PolicyWarning {
+	return pathConflictWarnings(TypeSandboxExec,
+		map[string][]string{"allow_read_folders": r.options.AllowReadFolders, "allow_read_files": r.options.AllowReadFiles},
+		map[string][]string{"allow_write_folders": r.options.AllowWriteFolders, "allow_write_files": r.options.AllowWriteFiles},
+		false)
+}
 
-		if err != nil {
-			r.logger.Debug("Sandboxed command completed with error: %v", err)
-			return err
-		}
-		r.logger.Debug("Sandboxed command completed successfully")
-		return nil
+// canaryPolicy returns what the canaries verify (see CanaryOptions). The
+// profile allows reading by default, so only the canary paths are checked,
+// and the loopback network may be allowed for the execution.
+func (r *SandboxExec) canaryPolicy(ctx context.Context, params map[string]interface{}) canaryPolicy {
+	return canaryPolicy{
+		networkDenied: !r.options.AllowNetworking && r.options.CustomProfile == "" && !loopbackNetworkFrom(ctx),
 	}
+}
 
-	return stdinPipe, stdoutPipe, stderrPipe, waitFunc, nil
+// fileChangeFolders returns the writable folders when file changes must be reported
+func (r *SandboxExec) fileChangeFolders(params map[string]interface{}) []string {
+	if !r.options.ReportFileChanges {
+		return nil
+	}
+	return common.ProcessTemplateListFlexible(r.options.AllowWriteFolders, params)
 }
 
 // CheckImplicitRequirements checks if the runner meets its implicit requirements.
@@ -430,9 +615,9 @@ func (r *SandboxExec) CheckImplicitRequirements() error {
 	}
 
 	// Check if sandbox-exec is available
-	if !common.CheckExecutableExists("sandbox-exec") {
+	if !common.CheckExecutableExists("sandbox-exec", r.options.ExtraPath...) {
 		return fmt.Errorf("sandbox-exec executable not found in PATH")
 	}
 
-	return nil
+	return r.options.checkArchitecture()
 }
@@ -24,6 +24,11 @@
 (allow network*)
 {{ else }}
 (deny network*)
+{{ if .AllowLoopback }}
+(allow network-bind (local ip "localhost:*"))
+(allow network-inbound (local ip "localhost:*"))
+(allow network-outbound (remote ip "localhost:*"))
+{{ end }}
 {{ end }}
 
 {{ if .AllowUserFolders }}
@@ -48,5 +53,21 @@
 (allow file-write* (literal "{{ . }}"))
 {{ end }}
 
+;; Deny the sockets of the credential agents
+{{ range .BlockedAgentPaths }}
+(deny file-read* file-write* (subpath "{{ . }}"))
+(deny network-outbound (remote unix-socket (subpath "{{ . }}")))
+{{ end }}
+
+;; Deny the sockets of the display server
+{{ range .BlockedDisplayPaths }}
+(deny file-read* file-write* (subpath "{{ . }}"))
+(deny network-outbound (remote unix-socket (subpath "{{ . }}")))
+{{ end }}
+
+{{ if not .AllowKeyring }}
+(deny mach-lookup (global-name "com.apple.SecurityServer"))
+{{ end }}
+
 {{ end }}
 
@@ -0,0 +1,380 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// ErrJobNotFound is returned by the Scheduler for unknown job names
+var ErrJobNotFound = errors.New("scheduled job not found")
+
+// OverlapPolicy is what a Scheduler does when a job is due while its
+// previous run is still in progress
+type OverlapPolicy string
+
+const (
+	// OverlapSkip skips the run, recording it as skipped in the history
+	OverlapSkip OverlapPolicy = ""
+	// OverlapAllow starts the run anyway, concurrently with the previous one
+	OverlapAllow OverlapPolicy = "allow"
+	// OverlapReplace kills the previous run and starts the new one
+	OverlapReplace OverlapPolicy = "replace"
+)
+
+// ScheduledJob is a command run periodically by a Scheduler
+type ScheduledJob struct {
+	// Name uniquely identifies the job
+	Name string
+
+	// Schedule is a cron expression with 5 fields (minute, hour, day of
+	// month, month and day of week), a macro such as "@daily", or
+	// "@every <duration>"
+	Schedule string
+
+	// Location is the time zone of the schedule (defaults to time.Local)
+	Location *time.Location
+
+	// Runner is the runner used to execute the command
+	Runner Runner
+
+	// Cmd, Args, Env and Params are passed to Start
+	Cmd    string
+	Args   []string
+	Env    []string
+	Params map[string]interface{}
+
+	// Overlap is the policy for runs due while the previous one is in progress
+	Overlap OverlapPolicy
+
+	// Jitter delays every run by a random duration up to this value, so
+	// jobs with the same schedule do not start at the same time
+	Jitter time.Duration
+
+	// Timeout kills the runs lasting longer than this (no limit by default)
+	Timeout time.Duration
+
+	// HistorySize is the number of runs kept in the history of the job (20 by default)
+	HistorySize int
+
+	// MaxOutputBytes is the amount of output kept per run (4096 by default)
+	MaxOutputBytes int
+}
+
+// JobRun is a run of a ScheduledJob
+type JobRun struct {
+	// ExecutionID is the ID of the execution (empty for skipped runs)
+	ExecutionID string
+
+	// ScheduledAt is when the run was due, and StartedAt and FinishedAt
+	// when the command started and completed
+	ScheduledAt time.Time
+	StartedAt   time.Time
+	FinishedAt  time.Time
+
+	// Skipped is set for runs skipped because the previous one was in progress
+	Skipped bool
+
+	// ExitCode is the exit code of the command, or -1 if unknown
+	ExitCode int
+
+	// Err is the error of the run, if any
+	Err error
+
+	// Output is the beginning of the output of the command (stdout and stderr)
+	Output string
+}
+
+// Scheduler runs commands on cron schedules.
+//
+// Jobs run with the restrictions of their runner: the scheduler only
+// decides when they start.
+type Scheduler struct {
+	logger Logger
+
+	mu      sync.Mutex
+	jobs    map[string]*schedulerEntry
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+// schedulerEntry is the state of a job in the scheduler
+type schedulerEntry struct {
+	job      ScheduledJob
+	schedule *cronSchedule
+	stop     context.CancelFunc
+
+	// the following fields are protected by the scheduler mutex
+	active  map[string]context.CancelFunc
+	history []JobRun
+}
+
+// NewScheduler creates a new Scheduler with the provided logger.
+// If logger is nil, a default logger is created.
+func NewScheduler(logger Logger) *Scheduler {
+	logger = defaultLogger(logger)
+	return &Scheduler{
+		logger: logger,
+		jobs:   map[string]*schedulerEntry{},
+	}
+}
+
+// Add adds a job, replacing the job with the same name. Jobs added to a
+// started scheduler are scheduled right away.
+func (s *Scheduler) Add(job ScheduledJob) error {
+	if job.Name == "" {
+		return fmt.Errorf("scheduled job requires a name")
+	}
+	if job.Runner == nil {
+		return fmt.Errorf("scheduled job %s requires a runner", job.Name)
+	}
+	schedule, err := parseCron(job.Schedule)
+	if err != nil {
+		return fmt.Errorf("scheduled job %s: %w", job.Name, err)
+	}
+	if job.Location == nil {
+		job.Location = time.Local
+	}
+	if job.HistorySize <= 0 {
+		job.HistorySize = 20
+	}
+	if job.MaxOutputBytes <= 0 {
+		job.MaxOutputBytes = 4096
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.jobs[job.Name]; ok && old.stop != nil {
+		old.stop()
+	}
+	entry := &schedulerEntry{job: job, schedule: schedule, active: map[string]context.CancelFunc{}}
+	s.jobs[job.Name] = entry
+	if s.ctx != nil {
+		s.startLocked(entry)
+	}
+	return nil
+}
+
+// Remove removes a job. Its runs in progress are not interrupted.
+func (s *Scheduler) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.jobs[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, name)
+	}
+	if entry.stop != nil {
+		entry.stop()
+	}
+	delete(s.jobs, name)
+	return nil
+}
+
+// Start starts scheduling the jobs, until ctx is cancelled or Stop is called
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx != nil {
+		return
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	for _, entry := range s.jobs {
+		s.startLocked(entry)
+	}
+}
+
+// Stop stops scheduling the jobs, kills their runs in progress and waits
+// for them to complete
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.ctx, s.cancel = nil, nil
+	s.mu.Unlock()
+
+	s.running.Wait()
+}
+
+// History returns the last runs of a job, oldest first
+func (s *Scheduler) History(name string) ([]JobRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.jobs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, name)
+	}
+	return append([]JobRun(nil), entry.history...), nil
+}
+
+// startLocked starts the loop scheduling the runs of a job.
+// It must be called with the scheduler mutex held.
+func (s *Scheduler) startLocked(entry *schedulerEntry) {
+	// runs are started with the context of the scheduler, so removing a
+	// job does not interrupt them
+	schedulerCtx := s.ctx
+	ctx, stop := context.WithCancel(schedulerCtx)
+	entry.stop = stop
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		s.loop(ctx, schedulerCtx, entry)
+	}()
+}
+
+// loop waits for the runs of a job to be due and fires them, until ctx is cancelled
+func (s *Scheduler) loop(ctx context.Context, schedulerCtx context.Context, entry *schedulerEntry) {
+	job := entry.job
+	next := time.Now().In(job.Location)
+	for {
+		next = entry.schedule.next(next)
+		if next.IsZero() {
+			s.logger.Info("Scheduler: job %s will never run again", job.Name)
+			return
+		}
+
+		delay := time.Until(next)
+		if job.Jitter > 0 {
+			delay += rand.N(job.Jitter)
+		}
+		s.logger.Debug("Scheduler: next run of job %s at %v", job.Name, next)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.fire(schedulerCtx, entry, next)
+
+		// runs missed while the machine was suspended are not caught up
+		if now := time.Now().In(job.Location); now.After(next) {
+			next = now
+		}
+	}
+}
+
+// fire starts a run of a job, applying its overlap policy
+func (s *Scheduler) fire(ctx context.Context, entry *schedulerEntry, scheduledAt time.Time) {
+	job := entry.job
+
+	s.mu.Lock()
+	if len(entry.active) > 0 {
+		switch job.Overlap {
+		case OverlapAllow:
+		case OverlapReplace:
+			s.logger.Debug("Scheduler: killing the previous run of job %s", job.Name)
+			for _, cancel := range entry.active {
+				cancel()
+			}
+		default:
+			s.logger.Debug("Scheduler: skipping run of job %s, the previous one is in progress", job.Name)
+			s.recordLocked(entry, JobRun{ScheduledAt: scheduledAt, Skipped: true, ExitCode: -1})
+			s.mu.Unlock()
+			return
+		}
+	}
+
+	var runCtx context.Context
+	var cancel context.CancelFunc
+	if job.Timeout > 0 {
+		runCtx, cancel = context.WithTimeout(ctx, job.Timeout)
+	} else {
+		runCtx, cancel = context.WithCancel(ctx)
+	}
+	runID := newExecutionID()
+	entry.active[runID] = cancel
+	s.mu.Unlock()
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer cancel()
+
+		run := s.run(runCtx, job, scheduledAt)
+
+		s.mu.Lock()
+		delete(entry.active, runID)
+		s.recordLocked(entry, run)
+		s.mu.Unlock()
+	}()
+}
+
+// run executes the command of a job and waits for it
+func (s *Scheduler) run(ctx context.Context, job ScheduledJob, scheduledAt time.Time) JobRun {
+	run := JobRun{ScheduledAt: scheduledAt, StartedAt: time.Now()}
+
+	e, err := Start(ctx, job.Runner, job.Cmd, job.Args, job.Env, job.Params)
+	if err != nil {
+		run.Err = err
+		run.ExitCode = -1
+		run.FinishedAt = time.Now()
+		return run
+	}
+	run.ExecutionID = e.ID
+	_ = e.Stdin.Close()
+
+	output := &limitedBuffer{max: job.MaxOutputBytes}
+	var wg sync.WaitGroup
+	for _, r := range []io.Reader{e.Stdout, e.Stderr} {
+		wg.Add(1)
+		go func(r io.Reader) {
+			defer wg.Done()
+			_, _ = io.Copy(output, r)
+		}(r)
+	}
+	wg.Wait()
+
+	run.Err = e.Wait()
+	run.ExitCode = exitCode(run.Err)
+	run.Output = output.String()
+	run.FinishedAt = time.Now()
+	if run.Err != nil {
+		s.logger.Info("Scheduler: run of job %s failed: %v", job.Name, run.Err)
+	}
+	return run
+}
+
+// recordLocked adds a run to the history of a job.
+// It must be called with the scheduler mutex held.
+func (s *Scheduler) recordLocked(entry *schedulerEntry, run JobRun) {
+	entry.history = append(entry.history, run)
+	if extra := len(entry.history) - entry.job.HistorySize; extra > 0 {
+		entry.history = append([]JobRun(nil), entry.history[extra:]...)
+	}
+}
+
+// limitedBuffer keeps the first max bytes written to it, discarding the rest
+type limitedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.max - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitForRuns waits until a job has at least n runs in its history
+func waitForRuns(t *testing.T, s *Scheduler, name string, n int) []JobRun {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		runs, err := s.History(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(runs) >= n {
+			return runs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %d runs of %s", n, name)
+	return nil
+}
+
+func TestScheduler_Runs(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewScheduler(nil)
+	if err := s.Add(ScheduledJob{
+		Name:        "hello",
+		Schedule:    "@every 50ms",
+		Runner:      r,
+		Cmd:         "echo",
+		Args:        []string{"hello"},
+		HistorySize: 2,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.Start(context.Background())
+
+	runs := waitForRuns(t, s, "hello", 2)
+	for _, run := range runs {
+		if run.Err != nil || run.Skipped || run.Output != "hello\n" || run.ExecutionID == "" {
+			t.Errorf("unexpected run: %+v", run)
+		}
+	}
+
+	// the history is bounded
+	time.Sleep(150 * time.Millisecond)
+	s.Stop()
+	if runs, _ := s.History("hello"); len(runs) != 2 {
+		t.Errorf("expected 2 runs in the history, got %d", len(runs))
+	}
+}
+
+func TestScheduler_OverlapSkip(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewScheduler(nil)
+	if err := s.Add(ScheduledJob{
+		Name:     "slow",
+		Schedule: "@every 30ms",
+		Runner:   r,
+		Cmd:      "sleep",
+		Args:     []string{"0.2"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.Start(context.Background())
+	defer s.Stop()
+
+	runs := waitForRuns(t, s, "slow", 2)
+	if !runs[0].Skipped {
+		t.Errorf("expected the first recorded run to be skipped, got %+v", runs[0])
+	}
+}
+
+func TestScheduler_OverlapReplace(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewScheduler(nil)
+	if err := s.Add(ScheduledJob{
+		Name:     "long",
+		Schedule: "@every 100ms",
+		Runner:   r,
+		Cmd:      "sleep",
+		Args:     []string{"10"},
+		Overlap:  OverlapReplace,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s.Start(context.Background())
+	defer s.Stop()
+
+	runs := waitForRuns(t, s, "long", 1)
+	if runs[0].Skipped || runs[0].Err == nil {
+		t.Errorf("expected the first run to be killed, got %+v", runs[0])
+	}
+}
+
+func TestScheduler_Errors(t *testing.T) {
+	s := NewScheduler(nil)
+	r, _ := NewExec(Options{}, nil)
+
+	if err := s.Add(ScheduledJob{Name: "bad", Schedule: "every day", Runner: r}); err == nil {
+		t.Error("expected an error for an invalid schedule")
+	}
+	if err := s.Add(ScheduledJob{Name: "norunner", Schedule: "@daily"}); err == nil {
+		t.Error("expected an error without a runner")
+	}
+	if _, err := s.History("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("expected ErrJobNotFound, got %v", err)
+	}
+	if err := s.Remove("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("expected ErrJobNotFound, got %v", err)
+	}
+}
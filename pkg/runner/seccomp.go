@@ -0,0 +1,368 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Built-in seccomp profiles (see SeccompOptions)
+const (
+	// SeccompProfileDefault denies the system calls reaching the kernel
+	// keyrings, other processes, kernel modules and mounts
+	SeccompProfileDefault = "default"
+
+	// SeccompProfileStrict also denies the system calls creating or joining
+	// namespaces, changing the root, io_uring, the clock, the hostname, and
+	// the memory policies of the host
+	SeccompProfileStrict = "strict"
+)
+
+// seccompDefaultDenied are the system calls denied by the default profile
+var seccompDefaultDenied = []string{
+	"acct", "add_key", "bpf", "delete_module", "finit_module", "init_module", "kexec_load", "keyctl", "mount",
+	"perf_event_open", "pivot_root", "process_vm_readv", "process_vm_writev", "ptrace", "reboot",
+	"request_key", "swapoff", "swapon", "umount2", "userfaultfd",
+}
+
+// seccompStrictDenied are the system calls denied by the strict profile, on
+// top of those of the default one
+var seccompStrictDenied = []string{
+	"adjtimex", "chroot", "clock_adjtime", "clock_settime", "fanotify_init", "fsconfig", "fsmount", "fsopen",
+	"fspick", "io_uring_enter", "io_uring_register", "io_uring_setup", "kcmp", "lookup_dcookie", "mbind",
+	"migrate_pages", "mount_setattr", "move_mount", "move_pages", "name_to_handle_at", "nfsservctl",
+	"open_by_handle_at", "open_tree", "quotactl", "set_mempolicy", "setdomainname", "sethostname", "setns",
+	"settimeofday", "syslog", "unshare", "vhangup",
+}
+
+// Actions of the seccomp profiles, as named by libseccomp
+const (
+	seccompActAllow       = "SCMP_ACT_ALLOW"
+	seccompActErrno       = "SCMP_ACT_ERRNO"
+	seccompActKill        = "SCMP_ACT_KILL"
+	seccompActKillThread  = "SCMP_ACT_KILL_THREAD"
+	seccompActKillProcess = "SCMP_ACT_KILL_PROCESS"
+	seccompActLog         = "SCMP_ACT_LOG"
+	seccompActTrap        = "SCMP_ACT_TRAP"
+)
+
+// seccompActions are the actions the profiles can use
+var seccompActions = []string{
+	seccompActAllow, seccompActErrno, seccompActKill, seccompActKillThread, seccompActKillProcess,
+	seccompActLog, seccompActTrap, "SCMP_ACT_TRACE", "SCMP_ACT_NOTIFY",
+}
+
+// errnoEPERM is the error of the system calls denied without an errnoRet
+const errnoEPERM = 1
+
+// SeccompOptions filter the system calls of the commands (Linux only)
+type SeccompOptions struct {
+	// SeccompProfile is the filter of the system calls of the commands: a
+	// built-in profile ("default" or "strict"), the path of a profile file,
+	// or a profile in JSON, in the format of the seccomp profiles of Docker.
+	// The runners filtering the commands in the host (Exec and Landrun) only
+	// support the profiles allowing the system calls by default, without
+	// argument filters.
+	SeccompProfile string `json:"seccomp_profile"`
+
+	// seccomp is the profile loaded, if any (see loadSeccompProfile)
+	seccomp *seccompProfile
+}
+
+// seccompProfile is a seccomp profile, in the format of Docker
+type seccompProfile struct {
+	DefaultAction   string        `json:"defaultAction"`
+	DefaultErrnoRet *uint         `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string      `json:"architectures,omitempty"`
+	Syscalls        []seccompRule `json:"syscalls,omitempty"`
+}
+
+// seccompRule is the action of some system calls in a seccomp profile
+type seccompRule struct {
+	Names    []string          `json:"names"`
+	Action   string            `json:"action"`
+	ErrnoRet *uint             `json:"errnoRet,omitempty"`
+	Args     []json.RawMessage `json:"args,omitempty"`
+	Includes json.RawMessage   `json:"includes,omitempty"`
+	Excludes json.RawMessage   `json:"excludes,omitempty"`
+}
+
+// conditional returns whether the rule depends on the arguments of the
+// system calls, or on the capabilities, architecture or kernel of the host
+func (r seccompRule) conditional() bool {
+	isSet := func(raw json.RawMessage) bool {
+		s := strings.TrimSpace(string(raw))
+		return s != "" && s != "{}" && s != "null"
+	}
+	return len(r.Args) > 0 || isSet(r.Includes) || isSet(r.Excludes)
+}
+
+// errno returns the error of the system calls of an SCMP_ACT_ERRNO rule
+func (r seccompRule) errno() uint {
+	if r.ErrnoRet != nil {
+		return *r.ErrnoRet
+	}
+	return errnoEPERM
+}
+
+// denyProfile returns the profile allowing every system call but those denied
+// (failing with EPERM)
+func denyProfile(denied ...[]string) *seccompProfile {
+	var names []string
+	for _, list := range denied {
+		names = append(names, list...)
+	}
+	slices.Sort(names)
+	return &seccompProfile{
+		DefaultAction: seccompActAllow,
+		Syscalls:      []seccompRule{{Names: names, Action: seccompActErrno}},
+	}
+}
+
+// builtin returns whether the profile is a built-in one
+func (o SeccompOptions) builtin() bool {
+	value := strings.TrimSpace(o.SeccompProfile)
+	return value == SeccompProfileDefault || value == SeccompProfileStrict
+}
+
+// seccompFingerprint returns the profile loaded, for the fingerprint of the
+// runner
+func (o SeccompOptions) seccompFingerprint() *seccompProfile {
+	return o.seccomp
+}
+
+// loadSeccompProfile loads and checks the profile of the options, if any
+func (o *SeccompOptions) loadSeccompProfile() error {
+	var data []byte
+	switch value := strings.TrimSpace(o.SeccompProfile); {
+	case o.SeccompProfile == "":
+		return nil
+	case value == SeccompProfileDefault:
+		o.seccomp = denyProfile(seccompDefaultDenied)
+		return nil
+	case value == SeccompProfileStrict:
+		o.seccomp = denyProfile(seccompDefaultDenied, seccompStrictDenied)
+		return nil
+	case strings.HasPrefix(value, "{"):
+		data = []byte(value)
+	default:
+		var err error
+		if data, err = os.ReadFile(value); err != nil {
+			return fmt.Errorf("failed to read the seccomp profile: %w", err)
+		}
+	}
+
+	var profile seccompProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return fmt.Errorf("invalid seccomp profile: %w", err)
+	}
+	if !slices.Contains(seccompActions, profile.DefaultAction) {
+		return fmt.Errorf("invalid seccomp profile: unknown default action %q", profile.DefaultAction)
+	}
+	for _, rule := range profile.Syscalls {
+		if len(rule.Names) == 0 {
+			return fmt.Errorf("invalid seccomp profile: a rule has no system call names")
+		}
+		if !slices.Contains(seccompActions, rule.Action) {
+			return fmt.Errorf("invalid seccomp profile: unknown action %q for %s", rule.Action,
+				strings.Join(rule.Names, ", "))
+		}
+	}
+	o.seccomp = &profile
+	return nil
+}
+
+// hostRules returns the rules of the profile denying system calls, when
+// the profile can be translated to a filter of the host: the system calls
+// must be allowed by default, and the rules cannot be conditional. The
+// rules allowing system calls are dropped.
+func (p *seccompProfile) hostRules() ([]seccompRule, error) {
+	if p.DefaultAction != seccompActAllow && p.DefaultAction != seccompActLog {
+		return nil, fmt.Errorf("only the seccomp profiles allowing the system calls by default are supported: %w",
+			ErrNotSupported)
+	}
+	var rules []seccompRule
+	for _, rule := range p.Syscalls {
+		switch {
+		case rule.Action == seccompActAllow:
+			continue
+		case rule.conditional():
+			return nil, fmt.Errorf("the seccomp rule of %s depends on the arguments or the host: %w",
+				strings.Join(rule.Names, ", "), ErrNotSupported)
+		case rule.Action == "SCMP_ACT_TRACE" || rule.Action == "SCMP_ACT_NOTIFY":
+			return nil, fmt.Errorf("the seccomp action %s is not supported: %w", rule.Action, ErrNotSupported)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// kafelActions are the names of the actions of the profiles in the Kafel
+// language of nsjail
+var kafelActions = map[string]string{
+	seccompActAllow:       "ALLOW",
+	seccompActKill:        "KILL",
+	seccompActKillThread:  "KILL",
+	seccompActKillProcess: "KILL_PROCESS",
+	seccompActLog:         "LOG",
+	seccompActTrap:        "TRAP(0)",
+}
+
+// kafel translates the profile to a policy in the Kafel language of nsjail
+func (p *seccompProfile) kafel() (string, error) {
+	action := func(name string, errno uint) (string, error) {
+		if name == seccompActErrno {
+			return fmt.Sprintf("ERRNO(%d)", errno), nil
+		}
+		if kafel, ok := kafelActions[name]; ok {
+			return kafel, nil
+		}
+		return "", fmt.Errorf("the seccomp action %s is not supported by nsjail: %w", name, ErrNotSupported)
+	}
+
+	var policy strings.Builder
+	for _, rule := range p.Syscalls {
+		if rule.conditional() {
+			return "", fmt.Errorf("the seccomp rule of %s depends on the arguments or the host: %w",
+				strings.Join(rule.Names, ", "), ErrNotSupported)
+		}
+		kafel, err := action(rule.Action, rule.errno())
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&policy, "%s { %s } ", kafel, strings.Join(rule.Names, ", "))
+	}
+	defaultErrno := uint(errnoEPERM)
+	if p.DefaultErrnoRet != nil {
+		defaultErrno = *p.DefaultErrnoRet
+	}
+	kafel, err := action(p.DefaultAction, defaultErrno)
+	if err != nil {
+		return "", err
+	}
+	return policy.String() + "DEFAULT " + kafel, nil
+}
+
+// firejailSeccomp returns the seccomp lines of a firejail profile. The
+// default profile is the default filter of firejail, and the strict one adds
+// its system calls. The other profiles replace the filter of firejail with
+// their denied system calls (seccomp.drop) or, when denying the system calls
+// by default, with their allowed ones (seccomp.keep). The keyring system
+// calls are allowed with allowKeyring in the built-in profiles.
+func (o SeccompOptions) firejailSeccomp(allowKeyring bool) (string, error) {
+	var keyring []string
+	if allowKeyring {
+		keyring = []string{"!add_key", "!keyctl", "!request_key"}
+	}
+	switch strings.TrimSpace(o.SeccompProfile) {
+	case "", SeccompProfileDefault:
+		return strings.TrimSpace("seccomp " + strings.Join(keyring, ",")), nil
+	case SeccompProfileStrict:
+		return "seccomp " + strings.Join(append(keyring, seccompStrictDenied...), ","), nil
+	}
+
+	var allowed, denied []string
+	for _, rule := range o.seccomp.Syscalls {
+		if rule.conditional() {
+			return "", fmt.Errorf("the seccomp rule of %s depends on the arguments or the host: %w",
+				strings.Join(rule.Names, ", "), ErrNotSupported)
+		}
+		if rule.Action == seccompActAllow || rule.Action == seccompActLog {
+			allowed = append(allowed, rule.Names...)
+		} else {
+			denied = append(denied, rule.Names...)
+		}
+	}
+	if o.seccomp.DefaultAction == seccompActAllow || o.seccomp.DefaultAction == seccompActLog {
+		if len(denied) == 0 {
+			return "", nil
+		}
+		return "seccomp.drop " + strings.Join(denied, ","), nil
+	}
+	if len(allowed) == 0 {
+		return "", fmt.Errorf("the seccomp profile denies every system call")
+	}
+	return "seccomp.keep " + strings.Join(allowed, ","), nil
+}
+
+// seccompJSON returns the profile in the JSON format of Docker
+func (o SeccompOptions) seccompJSON() ([]byte, error) {
+	value := strings.TrimSpace(o.SeccompProfile)
+	switch {
+	case o.builtin():
+		return json.Marshal(o.seccomp)
+	case strings.HasPrefix(value, "{"):
+		return []byte(value), nil
+	}
+	return os.ReadFile(value)
+}
+
+// dockerSeccompFile returns the file of the profile passed to the engine
+// with --security-opt, or "" for the default profile of the engine. The
+// strict and JSON profiles are written to the cache of the user, in files
+// named after their content.
+func (o SeccompOptions) dockerSeccompFile() (string, error) {
+	value := strings.TrimSpace(o.SeccompProfile)
+	switch {
+	case o.seccomp == nil || value == SeccompProfileDefault:
+		return "", nil
+	case !o.builtin() && !strings.HasPrefix(value, "{"):
+		return value, nil
+	}
+
+	data, err := o.seccompJSON()
+	if err != nil {
+		return "", err
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		cache = os.TempDir()
+	}
+	sum := sha256.Sum256(data)
+	path := filepath.Join(cache, "go-restricted-runner", "seccomp", hex.EncodeToString(sum[:8])+".json")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", fmt.Errorf("failed to write the seccomp profile: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", fmt.Errorf("failed to write the seccomp profile: %w", err)
+	}
+	return path, nil
+}
+
+// validateSeccompFilter checks the profile can be translated to a filter
+// installed in the host (see applySeccomp)
+func (o SeccompOptions) validateSeccompFilter() error {
+	if o.seccomp == nil {
+		return nil
+	}
+	return checkSeccompFilter(o.seccomp)
+}
+
+// applySeccomp changes cmd so the system calls of the command are filtered
+// with the profile, when set. The filter is installed by a helper process
+// right before it executes the command, so this must be called before any
+// other helper (e.g. isolateNamespaces) wraps the command.
+func (o SeccompOptions) applySeccomp(logger Logger, cmd *exec.Cmd) error {
+	if o.seccomp == nil || cmd.Err != nil {
+		return nil
+	}
+	logger.Debug("Filtering the system calls of the command with the %q seccomp profile", o.seccompName())
+	return seccompInHelper(cmd, o.seccomp)
+}
+
+// seccompName returns the name of the profile, for the logs
+func (o SeccompOptions) seccompName() string {
+	if o.builtin() || !strings.HasPrefix(strings.TrimSpace(o.SeccompProfile), "{") {
+		return o.SeccompProfile
+	}
+	return "custom"
+}
@@ -0,0 +1,216 @@
+//go:build linux
+
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// seccompHelperEnv is set (to the JSON seccomp profile) when this executable
+// is started as the helper that installs the seccomp filter before running
+// the command
+const seccompHelperEnv = "RUNNER_SECCOMP_HELPER"
+
+// seccompSyscalls are the system calls that can be denied by the filters
+// installed in the host, with their numbers in this architecture. They are
+// available in every Linux architecture.
+var seccompSyscalls = map[string]uint32{
+	"accept4": unix.SYS_ACCEPT4, "acct": unix.SYS_ACCT, "add_key": unix.SYS_ADD_KEY,
+	"adjtimex": unix.SYS_ADJTIMEX, "bind": unix.SYS_BIND, "bpf": unix.SYS_BPF, "capset": unix.SYS_CAPSET,
+	"chroot": unix.SYS_CHROOT, "clock_adjtime": unix.SYS_CLOCK_ADJTIME, "clock_settime": unix.SYS_CLOCK_SETTIME,
+	"connect": unix.SYS_CONNECT, "delete_module": unix.SYS_DELETE_MODULE, "execveat": unix.SYS_EXECVEAT,
+	"fanotify_init": unix.SYS_FANOTIFY_INIT, "finit_module": unix.SYS_FINIT_MODULE, "fsconfig": unix.SYS_FSCONFIG,
+	"fsmount": unix.SYS_FSMOUNT, "fsopen": unix.SYS_FSOPEN, "fspick": unix.SYS_FSPICK,
+	"init_module": unix.SYS_INIT_MODULE, "io_uring_enter": unix.SYS_IO_URING_ENTER,
+	"io_uring_register": unix.SYS_IO_URING_REGISTER, "io_uring_setup": unix.SYS_IO_URING_SETUP,
+	"kcmp": unix.SYS_KCMP, "kexec_load": unix.SYS_KEXEC_LOAD, "keyctl": unix.SYS_KEYCTL,
+	"listen": unix.SYS_LISTEN, "lookup_dcookie": unix.SYS_LOOKUP_DCOOKIE, "mbind": unix.SYS_MBIND,
+	"memfd_create": unix.SYS_MEMFD_CREATE, "migrate_pages": unix.SYS_MIGRATE_PAGES, "mknodat": unix.SYS_MKNODAT,
+	"mount": unix.SYS_MOUNT, "mount_setattr": unix.SYS_MOUNT_SETATTR, "move_mount": unix.SYS_MOVE_MOUNT,
+	"move_pages": unix.SYS_MOVE_PAGES, "mq_open": unix.SYS_MQ_OPEN, "msgget": unix.SYS_MSGGET,
+	"name_to_handle_at": unix.SYS_NAME_TO_HANDLE_AT, "nfsservctl": unix.SYS_NFSSERVCTL,
+	"open_by_handle_at": unix.SYS_OPEN_BY_HANDLE_AT, "open_tree": unix.SYS_OPEN_TREE,
+	"perf_event_open": unix.SYS_PERF_EVENT_OPEN, "personality": unix.SYS_PERSONALITY,
+	"pidfd_getfd": unix.SYS_PIDFD_GETFD, "pivot_root": unix.SYS_PIVOT_ROOT,
+	"process_madvise": unix.SYS_PROCESS_MADVISE, "process_vm_readv": unix.SYS_PROCESS_VM_READV,
+	"process_vm_writev": unix.SYS_PROCESS_VM_WRITEV, "ptrace": unix.SYS_PTRACE, "quotactl": unix.SYS_QUOTACTL,
+	"reboot": unix.SYS_REBOOT, "request_key": unix.SYS_REQUEST_KEY, "semget": unix.SYS_SEMGET,
+	"set_mempolicy": unix.SYS_SET_MEMPOLICY, "setdomainname": unix.SYS_SETDOMAINNAME,
+	"setgid": unix.SYS_SETGID, "sethostname": unix.SYS_SETHOSTNAME, "setns": unix.SYS_SETNS,
+	"setuid": unix.SYS_SETUID, "settimeofday": unix.SYS_SETTIMEOFDAY, "shmget": unix.SYS_SHMGET,
+	"socket": unix.SYS_SOCKET, "socketpair": unix.SYS_SOCKETPAIR, "swapoff": unix.SYS_SWAPOFF,
+	"swapon": unix.SYS_SWAPON, "syslog": unix.SYS_SYSLOG, "umount2": unix.SYS_UMOUNT2,
+	"uname": unix.SYS_UNAME, "unshare": unix.SYS_UNSHARE, "userfaultfd": unix.SYS_USERFAULTFD,
+	"vhangup": unix.SYS_VHANGUP,
+}
+
+// seccompAuditArchs are the architectures of the system calls checked by
+// the filters, by GOARCH
+var seccompAuditArchs = map[string]uint32{
+	"386":      unix.AUDIT_ARCH_I386,
+	"amd64":    unix.AUDIT_ARCH_X86_64,
+	"arm":      unix.AUDIT_ARCH_ARM,
+	"arm64":    unix.AUDIT_ARCH_AARCH64,
+	"loong64":  unix.AUDIT_ARCH_LOONGARCH64,
+	"mips":     unix.AUDIT_ARCH_MIPS,
+	"mipsle":   unix.AUDIT_ARCH_MIPSEL,
+	"mips64":   unix.AUDIT_ARCH_MIPS64,
+	"mips64le": unix.AUDIT_ARCH_MIPSEL64,
+	"ppc64":    unix.AUDIT_ARCH_PPC64,
+	"ppc64le":  unix.AUDIT_ARCH_PPC64LE,
+	"riscv64":  unix.AUDIT_ARCH_RISCV64,
+	"s390x":    unix.AUDIT_ARCH_S390X,
+}
+
+// x32SyscallBit is set in the numbers of the system calls of the x32 ABI,
+// which are always denied on amd64 so they cannot bypass the filter
+const x32SyscallBit = 0x40000000
+
+// Offsets of the fields of struct seccomp_data
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+)
+
+// seccompReturn returns the return value of the filter for an action
+func seccompReturn(action string, errno uint) uint32 {
+	switch action {
+	case seccompActAllow:
+		return unix.SECCOMP_RET_ALLOW
+	case seccompActErrno:
+		return unix.SECCOMP_RET_ERRNO | uint32(errno&unix.SECCOMP_RET_DATA)
+	case seccompActKillProcess:
+		return unix.SECCOMP_RET_KILL_PROCESS
+	case seccompActLog:
+		return unix.SECCOMP_RET_LOG
+	case seccompActTrap:
+		return unix.SECCOMP_RET_TRAP
+	}
+	return unix.SECCOMP_RET_KILL_THREAD
+}
+
+// seccompFilter translates a profile to a BPF program for this architecture
+func seccompFilter(p *seccompProfile) ([]unix.SockFilter, error) {
+	arch, ok := seccompAuditArchs[runtime.GOARCH]
+	if !ok {
+		return nil, fmt.Errorf("seccomp filters are not supported on %s: %w", runtime.GOARCH, ErrNotSupported)
+	}
+	rules, err := p.hostRules()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := func(code uint16, k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k}
+	}
+	jump := func(code uint16, k uint32, jt uint8, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+	}
+
+	// the system calls of other architectures are killed
+	filter := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataArch),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arch, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataNr),
+	}
+	if runtime.GOARCH == "amd64" {
+		filter = append(filter,
+			jump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32SyscallBit, 0, 1),
+			stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS))
+	}
+	for _, rule := range rules {
+		ret := seccompReturn(rule.Action, rule.errno())
+		for _, name := range rule.Names {
+			nr, ok := seccompSyscalls[name]
+			if !ok {
+				return nil, fmt.Errorf("the system call %q cannot be filtered in the host: %w", name, ErrNotSupported)
+			}
+			filter = append(filter,
+				jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, 0, 1),
+				stmt(unix.BPF_RET|unix.BPF_K, ret))
+		}
+	}
+	return append(filter, stmt(unix.BPF_RET|unix.BPF_K, seccompReturn(p.DefaultAction, 0))), nil
+}
+
+// checkSeccompFilter checks a profile can be translated to a filter
+func checkSeccompFilter(p *seccompProfile) error {
+	_, err := seccompFilter(p)
+	return err
+}
+
+// installSeccompFilter installs the filter of a profile in the current
+// thread, which keeps it when it executes a command
+func installSeccompFilter(p *seccompProfile) error {
+	filter, err := seccompFilter(p)
+	if err != nil {
+		return err
+	}
+	// unprivileged processes can only install filters without new privileges
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0); err != nil {
+		return fmt.Errorf("failed to install the seccomp filter: %w", err)
+	}
+	return nil
+}
+
+// seccompInHelper changes cmd so it is started through this executable: the
+// helper mode (see runSeccompHelper) installs the filter of the profile and
+// then executes the command, so only the command is filtered.
+func seccompInHelper(cmd *exec.Cmd, p *seccompProfile) error {
+	self, err := helperExecutable("seccomp helper")
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to configure the seccomp helper: %w", err)
+	}
+
+	cmd.Args = append([]string{"runner-seccomp-helper", cmd.Path}, cmd.Args...)
+	cmd.Path = self
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, seccompHelperEnv+"="+string(data))
+	return nil
+}
+
+// runSeccompHelper installs the filter of its profile and executes the
+// command in os.Args[1:] (its path followed by its arguments). It never
+// returns.
+func runSeccompHelper() {
+	runtime.LockOSThread()
+
+	var profile seccompProfile
+	if err := json.Unmarshal([]byte(os.Getenv(seccompHelperEnv)), &profile); err != nil {
+		fmt.Fprintf(os.Stderr, "runner: invalid seccomp helper configuration: %v\n", err)
+		os.Exit(126)
+	}
+	_ = os.Unsetenv(seccompHelperEnv)
+
+	if len(os.Args) < 3 {
+		fmt.Fprintln(os.Stderr, "runner: missing command for the seccomp helper")
+		os.Exit(126)
+	}
+	if err := installSeccompFilter(&profile); err != nil {
+		fmt.Fprintf(os.Stderr, "runner: %v\n", err)
+		os.Exit(126)
+	}
+
+	err := syscall.Exec(os.Args[1], os.Args[2:], os.Environ())
+	fmt.Fprintf(os.Stderr, "runner: failed to execute %s: %v\n", os.Args[1], err)
+	os.Exit(127)
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package runner
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// checkSeccompFilter fails, as seccomp filters only exist on Linux
+func checkSeccompFilter(p *seccompProfile) error {
+	return fmt.Errorf("seccomp_profile requires Linux: %w", ErrNotSupported)
+}
+
+// seccompInHelper is only supported on Linux, where seccomp filters exist
+func seccompInHelper(cmd *exec.Cmd, p *seccompProfile) error {
+	return fmt.Errorf("seccomp_profile requires Linux: %w", ErrNotSupported)
+}
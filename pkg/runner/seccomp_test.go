@@ -0,0 +1,195 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSeccompOptions_loadSeccompProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.json")
+	if err := os.WriteFile(path, []byte(`{"defaultAction": "SCMP_ACT_ALLOW",
+		"syscalls": [{"names": ["uname"], "action": "SCMP_ACT_ERRNO", "errnoRet": 38}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		profile string
+		denied  string
+		wantErr bool
+	}{
+		{"none", "", "", false},
+		{"default", "default", "ptrace", false},
+		{"strict", " strict ", "unshare", false},
+		{"file", path, "uname", false},
+		{"json", `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["uname"], "action": "SCMP_ACT_KILL"}]}`, "uname", false},
+		{"missing file", filepath.Join(t.TempDir(), "missing.json"), "", true},
+		{"invalid json", `{"defaultAction": `, "", true},
+		{"unknown action", `{"defaultAction": "SCMP_ACT_DENY"}`, "", true},
+		{"no names", `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"action": "SCMP_ACT_ERRNO"}]}`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := SeccompOptions{SeccompProfile: tt.profile}
+			err := opts.loadSeccompProfile()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadSeccompProfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.denied == "" {
+				return
+			}
+			rules, err := opts.seccomp.hostRules()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rules) != 1 || !strings.Contains(strings.Join(rules[0].Names, ","), tt.denied) {
+				t.Errorf("hostRules() = %v, want %s denied", rules, tt.denied)
+			}
+		})
+	}
+}
+
+func TestSeccompProfile_hostRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+	}{
+		{"deny by default", `{"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_ALLOW"}]}`},
+		{"arguments", `{"defaultAction": "SCMP_ACT_ALLOW",
+			"syscalls": [{"names": ["personality"], "action": "SCMP_ACT_ERRNO", "args": [{"index": 0, "value": 8, "op": "SCMP_CMP_EQ"}]}]}`},
+		{"notify", `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["uname"], "action": "SCMP_ACT_NOTIFY"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := SeccompOptions{SeccompProfile: tt.profile}
+			if err := opts.loadSeccompProfile(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := opts.seccomp.hostRules(); !errors.Is(err, ErrNotSupported) {
+				t.Errorf("hostRules() error = %v, want ErrNotSupported", err)
+			}
+		})
+	}
+}
+
+func TestSeccompProfile_kafel(t *testing.T) {
+	opts := SeccompOptions{SeccompProfile: SeccompProfileDefault}
+	if err := opts.loadSeccompProfile(); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := opts.seccomp.kafel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(policy, "ERRNO(1) { acct, add_key,") || !strings.HasSuffix(policy, "} DEFAULT ALLOW") {
+		t.Errorf("kafel() = %q", policy)
+	}
+
+	opts = SeccompOptions{SeccompProfile: `{"defaultAction": "SCMP_ACT_KILL_PROCESS",
+		"syscalls": [{"names": ["read", "write"], "action": "SCMP_ACT_ALLOW"}]}`}
+	if err := opts.loadSeccompProfile(); err != nil {
+		t.Fatal(err)
+	}
+	if policy, err := opts.seccomp.kafel(); err != nil || policy != "ALLOW { read, write } DEFAULT KILL_PROCESS" {
+		t.Errorf("kafel() = %q, %v", policy, err)
+	}
+}
+
+func TestSeccompOptions_firejailSeccomp(t *testing.T) {
+	tests := []struct {
+		name         string
+		profile      string
+		allowKeyring bool
+		want         string
+	}{
+		{"none", "", false, "seccomp"},
+		{"keyring", "default", true, "seccomp !add_key,!keyctl,!request_key"},
+		{"strict", "strict", false, "seccomp adjtimex,chroot,"},
+		{"drop", `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["uname"], "action": "SCMP_ACT_ERRNO"}]}`,
+			false, "seccomp.drop uname"},
+		{"keep", `{"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [{"names": ["read", "exit"], "action": "SCMP_ACT_ALLOW"}]}`,
+			false, "seccomp.keep read,exit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := SeccompOptions{SeccompProfile: tt.profile}
+			if err := opts.loadSeccompProfile(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := opts.firejailSeccomp(tt.allowKeyring)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(got, tt.want) {
+				t.Errorf("firejailSeccomp() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDockerOptions_seccomp(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	opts, err := NewDockerOptions(Options{"image": "alpine:latest", "seccomp_profile": "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd := strings.Join(opts.GetBaseDockerCommand(nil), " "); strings.Contains(cmd, "seccomp") {
+		t.Errorf("the default profile of the engine should be used, got %q", cmd)
+	}
+
+	opts, err = NewDockerOptions(Options{"image": "alpine:latest", "seccomp_profile": "strict"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := strings.Join(opts.GetBaseDockerCommand(nil), " ")
+	if !strings.Contains(cmd, "--security-opt "+shellQuote("seccomp="+opts.seccompFile)) {
+		t.Fatalf("GetBaseDockerCommand() = %q, want the profile file", cmd)
+	}
+	data, err := os.ReadFile(opts.seccompFile)
+	if err != nil || !strings.Contains(string(data), `"unshare"`) {
+		t.Errorf("profile file = %q, %v", data, err)
+	}
+}
+
+func TestExec_seccompProfile(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		if _, err := NewExec(Options{"seccomp_profile": "default"}, nil); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("NewExec() error = %v, want ErrNotSupported", err)
+		}
+		return
+	}
+
+	profile := `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["uname"], "action": "SCMP_ACT_ERRNO"}]}`
+	r, err := NewExec(Options{"seccomp_profile": profile}, nil)
+	if err != nil {
+		if errors.Is(err, ErrNotSupported) {
+			t.Skipf("seccomp filters are not available: %v", err)
+		}
+		t.Fatal(err)
+	}
+	output, err := r.Run(context.Background(), "sh", "uname || echo denied", nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "denied") {
+		t.Errorf("expected uname to be denied, got %q", output)
+	}
+
+	if _, err := NewExec(Options{"seccomp_profile": `{"defaultAction": "SCMP_ACT_ERRNO"}`}, nil); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("NewExec() error = %v, want ErrNotSupported for a profile denying by default", err)
+	}
+}
+
+func TestNsjail_seccompProfile(t *testing.T) {
+	_, err := NewNsjail(Options{"seccomp_profile": "strict", "seccomp_policy": "DEFAULT ALLOW"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "cannot be combined") {
+		t.Errorf("NewNsjail() error = %v, want seccomp_policy and seccomp_profile rejected", err)
+	}
+}
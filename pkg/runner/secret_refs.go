@@ -0,0 +1,262 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// secretRefKey is the key of the maps referencing a secret, as in
+// {"secret_ref": "vault:kv/data/ci#token"}
+const secretRefKey = "secret_ref"
+
+// SecretResolver resolves references to the secrets of a secrets manager,
+// written in the options as "${<scheme>:<reference>}" strings or
+// {"secret_ref": "<scheme>:<reference>"} maps
+type SecretResolver interface {
+	// Scheme is the scheme of the references (e.g. "vault")
+	Scheme() string
+
+	// Resolve returns the secret of a reference (without the scheme)
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// ResolveSecretRefs returns a copy of the options with the secret references
+// (in any nested map or list) replaced by their secrets. References are
+// either strings with the whole "${<scheme>:<reference>}" syntax, for the
+// schemes of the resolvers, or {"secret_ref": "<scheme>:<reference>"} maps,
+// whose scheme must be the one of a resolver. Other values are never
+// resolved, even when they start with a scheme (e.g. "vault:1.15"). The
+// secrets are registered for redaction (see common.RegisterSecret), so they
+// never show in the logs.
+func ResolveSecretRefs(ctx context.Context, options Options, resolvers ...SecretResolver) (Options, error) {
+	if options == nil || len(resolvers) == 0 {
+		return options, nil
+	}
+	byScheme := map[string]SecretResolver{}
+	for _, r := range resolvers {
+		byScheme[r.Scheme()] = r
+	}
+	resolved, err := resolveSecretValue(ctx, "", map[string]interface{}(options), byScheme)
+	if err != nil {
+		return nil, err
+	}
+	return Options(resolved.(map[string]interface{})), nil
+}
+
+// resolveSecretValue resolves the references of an option value, returning
+// a copy of maps and lists
+func resolveSecretValue(ctx context.Context, path string, value interface{}, resolvers map[string]SecretResolver) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if !strings.HasPrefix(v, "${") || !strings.HasSuffix(v, "}") {
+			return v, nil
+		}
+		scheme, ref, ok := strings.Cut(v[2:len(v)-1], ":")
+		if _, known := resolvers[scheme]; !ok || !known {
+			// e.g. a shell expansion like ${HOME:-/tmp}
+			return v, nil
+		}
+		return resolveSecretRef(ctx, path, scheme, ref, resolvers)
+	case Options:
+		return resolveSecretValue(ctx, path, map[string]interface{}(v), resolvers)
+	case map[string]interface{}:
+		if refValue, isRef := v[secretRefKey]; isRef && len(v) == 1 {
+			s, _ := refValue.(string)
+			scheme, ref, ok := strings.Cut(s, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid secret reference of option %s: <scheme>:<reference> required", path)
+			}
+			return resolveSecretRef(ctx, path, scheme, ref, resolvers)
+		}
+		res := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			itemPath := key
+			if path != "" {
+				itemPath = path + "." + key
+			}
+			resolved, err := resolveSecretValue(ctx, itemPath, item, resolvers)
+			if err != nil {
+				return nil, err
+			}
+			res[key] = resolved
+		}
+		return res, nil
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			resolved, err := resolveSecretValue(ctx, fmt.Sprintf("%s[%d]", path, i), item, resolvers)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = resolved
+		}
+		return res, nil
+	case []string:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = item
+		}
+		return resolveSecretValue(ctx, path, items, resolvers)
+	default:
+		return v, nil
+	}
+}
+
+// resolveSecretRef resolves a reference with the resolver of its scheme,
+// registering the secret for redaction
+func resolveSecretRef(ctx context.Context, path string, scheme string, ref string, resolvers map[string]SecretResolver) (string, error) {
+	r, ok := resolvers[scheme]
+	if !ok {
+		return "", fmt.Errorf("failed to resolve the secret of option %s: no resolver for %q", path, scheme)
+	}
+	secret, err := r.Resolve(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the secret of option %s: %w", path, err)
+	}
+	common.RegisterSecret(secret)
+	return secret, nil
+}
+
+// VaultResolver resolves "vault:<path>#<field>" references with the HTTP API
+// of HashiCorp Vault, reading the field of the secret at the path (e.g.
+// "${vault:kv/data/ci#token}"). Both KV version 1 and 2 engines are supported.
+type VaultResolver struct {
+	// Address is the address of Vault (default: $VAULT_ADDR)
+	Address string
+
+	// Token is the Vault token (default: $VAULT_TOKEN)
+	Token string
+
+	// Namespace is the Vault Enterprise namespace (default: $VAULT_NAMESPACE)
+	Namespace string
+
+	// Client is the HTTP client (default: http.DefaultClient)
+	Client *http.Client
+}
+
+// Scheme returns "vault"
+func (r VaultResolver) Scheme() string {
+	return "vault"
+}
+
+// Resolve reads the field of a secret
+func (r VaultResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("invalid vault reference %q: <path>#<field> required", ref)
+	}
+	address := firstNonEmpty(r.Address, os.Getenv("VAULT_ADDR"))
+	if address == "" {
+		return "", fmt.Errorf("no vault address")
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	url := strings.TrimSuffix(address, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", firstNonEmpty(r.Token, os.Getenv("VAULT_TOKEN")))
+	if ns := firstNonEmpty(r.Namespace, os.Getenv("VAULT_NAMESPACE")); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %s for %s", resp.Status, path)
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", fmt.Errorf("invalid vault response for %s: %w", path, err)
+	}
+	data := secret.Data
+	// KV version 2 nests the fields of the secret
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, isMetadata := data["metadata"]; isMetadata {
+			data = nested
+		}
+	}
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("field %s not found in the vault secret %s", field, path)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+// SSMResolver resolves "ssm:<name>" references to the (decrypted) parameters
+// of the AWS Systems Manager Parameter Store, with the AWS CLI and its usual
+// credentials (e.g. "${ssm:/prod/ci/token}")
+type SSMResolver struct {
+	// Region is the AWS region (default: the region of the AWS CLI)
+	Region string
+
+	// Profile is the AWS CLI profile (default: the default profile)
+	Profile string
+
+	// Binary is the AWS CLI executable (default: "aws")
+	Binary string
+}
+
+// Scheme returns "ssm"
+func (r SSMResolver) Scheme() string {
+	return "ssm"
+}
+
+// Resolve reads the parameter
+func (r SSMResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	if ref == "" {
+		return "", fmt.Errorf("invalid ssm reference: a parameter name is required")
+	}
+	args := []string{"ssm", "get-parameter", "--name", ref, "--with-decryption",
+		"--query", "Parameter.Value", "--output", "text"}
+	if r.Region != "" {
+		args = append(args, "--region", r.Region)
+	}
+	if r.Profile != "" {
+		args = append(args, "--profile", r.Profile)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, firstNonEmpty(r.Binary, "aws"), args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to get the ssm parameter %s: %w: %s", ref, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSuffix(stdout.String(), "\n"), nil
+}
+
+// firstNonEmpty returns the first non empty string
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
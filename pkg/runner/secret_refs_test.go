@@ -0,0 +1,191 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func newFakeVault(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch req.URL.Path {
+		case "/v1/kv/data/ci":
+			_, _ = w.Write([]byte(`{"data": {"data": {"token": "vault-kv2-token"}, "metadata": {"version": 3}}}`))
+		case "/v1/secret/ci":
+			_, _ = w.Write([]byte(`{"data": {"token": "vault-kv1-token"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestResolveSecretRefs_vault(t *testing.T) {
+	server := newFakeVault(t)
+	vault := VaultResolver{Address: server.URL, Token: "root"}
+	ctx := context.Background()
+
+	options := Options{
+		"image":           "alpine:3",
+		"prepare_command": "${vault:kv/data/ci#token}",
+		"env":             []string{"${vault:secret/ci#token}"},
+	}
+	resolved, err := ResolveSecretRefs(ctx, options, vault)
+	if err != nil {
+		t.Fatalf("ResolveSecretRefs failed: %v", err)
+	}
+	if resolved["prepare_command"] != "vault-kv2-token" || resolved["image"] != "alpine:3" {
+		t.Errorf("ResolveSecretRefs() = %v", resolved)
+	}
+	if env := resolved["env"].([]interface{}); env[0] != "vault-kv1-token" {
+		t.Errorf("resolved env = %v", env)
+	}
+	if options["prepare_command"] != "${vault:kv/data/ci#token}" {
+		t.Errorf("the options were modified")
+	}
+
+	for _, ref := range []string{"${vault:kv/data/ci}", "${vault:kv/data/ci#password}", "${vault:kv/data/missing#token}"} {
+		if _, err := ResolveSecretRefs(ctx, Options{"x": ref}, vault); err == nil {
+			t.Errorf("ResolveSecretRefs(%q) should fail", ref)
+		}
+	}
+	if _, err := ResolveSecretRefs(ctx, Options{"x": "${vault:kv/data/ci#token}"}, VaultResolver{Address: server.URL}); err == nil {
+		t.Errorf("ResolveSecretRefs() without a token should fail")
+	}
+}
+
+func TestResolveSecretRefs_syntax(t *testing.T) {
+	server := newFakeVault(t)
+	vault := VaultResolver{Address: server.URL, Token: "root"}
+	ctx := context.Background()
+
+	// values looking like references are not resolved
+	plain := Options{
+		"image":     "vault:1.15",
+		"mounts":    []string{"vault:/data"},
+		"env":       []string{"ssm:foo", "TOKEN=${vault:kv/data/ci#token}"},
+		"shell":     "${HOME:-/bin/sh}",
+		"workdir":   "${ssm:/prod/ci/token}",
+		"labels":    map[string]interface{}{"secret_ref": "vault:kv/data/ci#token", "team": "ci"},
+		"transform": "$vault:kv/data/ci#token}",
+	}
+	resolved, err := ResolveSecretRefs(ctx, plain, vault)
+	if err != nil {
+		t.Fatalf("ResolveSecretRefs failed: %v", err)
+	}
+	for key, value := range plain {
+		if got := fmt.Sprint(resolved[key]); got != fmt.Sprint(value) {
+			t.Errorf("option %s resolved to %v, want %v", key, got, value)
+		}
+	}
+
+	resolved, err = ResolveSecretRefs(ctx, Options{
+		"prepare_command": map[string]interface{}{"secret_ref": "vault:kv/data/ci#token"},
+		"env":             []interface{}{Options{"secret_ref": "vault:secret/ci#token"}},
+	}, vault)
+	if err != nil {
+		t.Fatalf("ResolveSecretRefs failed: %v", err)
+	}
+	if resolved["prepare_command"] != "vault-kv2-token" {
+		t.Errorf("resolved prepare_command = %v", resolved["prepare_command"])
+	}
+	if env := resolved["env"].([]interface{}); env[0] != "vault-kv1-token" {
+		t.Errorf("resolved env = %v", env)
+	}
+
+	for _, ref := range []interface{}{"ssm:/prod/ci/token", "kv/data/ci#token", 42} {
+		if _, err := ResolveSecretRefs(ctx, Options{"x": map[string]interface{}{"secret_ref": ref}}, vault); err == nil {
+			t.Errorf("ResolveSecretRefs() of the secret_ref %v should fail", ref)
+		}
+	}
+}
+
+func TestResolveSecretRefs_redaction(t *testing.T) {
+	server := newFakeVault(t)
+	if _, err := ResolveSecretRefs(context.Background(), Options{"x": "${vault:kv/data/ci#token}"},
+		VaultResolver{Address: server.URL, Token: "root"}); err != nil {
+		t.Fatalf("ResolveSecretRefs failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	logger.Info("running with token %s", "vault-kv2-token")
+	if strings.Contains(buf.String(), "vault-kv2-token") || !strings.Contains(buf.String(), "[REDACTED]") {
+		t.Errorf("the secret was not redacted: %q", buf.String())
+	}
+}
+
+func TestResolveSecretRefs_ssm(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	dir := t.TempDir()
+	aws := filepath.Join(dir, "aws")
+	script := "#!/bin/sh\n[ \"$4\" = /prod/ci/token ] || { echo \"ParameterNotFound\" >&2; exit 254; }\necho ssm-token-value\n"
+	if err := os.WriteFile(aws, []byte(script), 0o755); err != nil {
+		t.Fatalf("failed to write the fake aws: %v", err)
+	}
+	ssm := SSMResolver{Binary: aws, Region: "eu-west-1"}
+
+	resolved, err := ResolveSecretRefs(context.Background(), Options{"token": "${ssm:/prod/ci/token}"}, ssm)
+	if err != nil {
+		t.Fatalf("ResolveSecretRefs failed: %v", err)
+	}
+	if resolved["token"] != "ssm-token-value" {
+		t.Errorf("ResolveSecretRefs() = %v", resolved)
+	}
+	_, err = ResolveSecretRefs(context.Background(), Options{"token": "${ssm:/prod/missing}"}, ssm)
+	if err == nil || !strings.Contains(err.Error(), "ParameterNotFound") {
+		t.Errorf("ResolveSecretRefs() of a missing parameter = %v", err)
+	}
+}
+
+func TestRegistry_secretResolvers(t *testing.T) {
+	server := newFakeVault(t)
+	reg := NewRegistry(nil)
+	reg.SetSecretResolvers(VaultResolver{Address: server.URL, Token: "root"})
+
+	if err := reg.Register("missing", RunnerConfig{Type: TypeExec, Options: Options{"shell": "${vault:kv/data/missing#sh}"}}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if _, err := reg.Get("missing"); err == nil {
+		t.Errorf("Get() with an unresolvable secret should fail")
+	}
+
+	// the options are only resolved when the runner is prepared
+	if err := reg.Register("exec", RunnerConfig{Type: TypeExec, Options: Options{"shell": "${vault:kv/data/ci#token}"}}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if config, _ := reg.Config("exec"); config.Options["shell"] != "${vault:kv/data/ci#token}" {
+		t.Errorf("the registered options were resolved: %v", config.Options)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := reg.Prepare(ctx, "exec"); err == nil {
+		t.Errorf("Prepare() with a cancelled context should fail")
+	}
+	if err := reg.Prepare(context.Background(), "exec"); err != nil {
+		t.Fatalf("Prepare failed: %v", err)
+	}
+	r, err := reg.Get("exec")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if shell := r.(*Exec).options.Shell; shell != "vault-kv2-token" {
+		t.Errorf("shell of the prepared runner = %q", shell)
+	}
+}
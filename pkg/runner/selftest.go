@@ -0,0 +1,474 @@
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// SelfTestStatus is the result of a self test check
+type SelfTestStatus string
+
+const (
+	// SelfTestPass is a check that verified the restriction
+	SelfTestPass SelfTestStatus = "pass"
+
+	// SelfTestFail is a check that found the restriction not enforced
+	// (or the runner not working)
+	SelfTestFail SelfTestStatus = "fail"
+
+	// SelfTestSkip is a check that could not run, e.g. because the runner
+	// is not available on the host
+	SelfTestSkip SelfTestStatus = "skip"
+)
+
+// SelfTestCheck is a canary command run through a runner
+type SelfTestCheck struct {
+	// Runner is the runner type the check was run with
+	Runner Type `json:"runner"`
+
+	// Name identifies the check (e.g. "read_denied")
+	Name string `json:"name"`
+
+	// Status is the result of the check
+	Status SelfTestStatus `json:"status"`
+
+	// Detail explains failures and skips
+	Detail string `json:"detail,omitempty"`
+
+	// Duration is the time the check took
+	Duration time.Duration `json:"duration"`
+}
+
+// SelfTestReport is the result of SelfTest
+type SelfTestReport struct {
+	// Started is when the self test started
+	Started time.Time `json:"started"`
+
+	// OS, Arch and Kernel describe the host
+	OS     string `json:"os"`
+	Arch   string `json:"arch"`
+	Kernel string `json:"kernel,omitempty"`
+
+	// Checks are the checks run, by runner
+	Checks []SelfTestCheck `json:"checks"`
+}
+
+// Passed returns whether no check failed
+func (r *SelfTestReport) Passed() bool {
+	for _, c := range r.Checks {
+		if c.Status == SelfTestFail {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteText writes the report as a table
+func (r *SelfTestReport) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "RUNNER\tCHECK\tSTATUS\tDETAIL\n")
+	for _, c := range r.Checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Runner, c.Name, strings.ToUpper(string(c.Status)), c.Detail)
+	}
+	result := "PASSED"
+	if !r.Passed() {
+		result = "FAILED"
+	}
+	fmt.Fprintf(tw, "\nself test %s on %s/%s %s\n", result, r.OS, r.Arch, r.Kernel)
+	return tw.Flush()
+}
+
+// selfTestImage is the image the Docker runner is tested with
+const selfTestImage = "alpine:latest"
+
+// selfTestCheckTimeout bounds every canary command
+const selfTestCheckTimeout = 30 * time.Second
+
+// selfTestBackend describes how a runner type is tested
+type selfTestBackend struct {
+	runner Type
+
+	// options are the options of the runner: no network, and only the
+	// writable folder of the environment allowed
+	options func(env *selfTestEnv) Options
+
+	// hostFilesystem is whether the command sees the filesystem of the host,
+	// so reading the secret and writing outside the writable folder must be
+	// denied (instead of being impossible)
+	hostFilesystem bool
+
+	// readDenied is whether the runner denies reading the secret
+	readDenied bool
+
+	// interfaces checks the network is blocked from the network interfaces
+	// seen by the command, instead of with a connection to the host
+	interfaces bool
+
+	// isolated runs the checks in a child process, as the runner restricts
+	// the process running it
+	isolated bool
+}
+
+// selfTestBackends are the runners tested, in order. The Exec runner does
+// not restrict commands, so it is only used to check the canaries work.
+var selfTestBackends = []selfTestBackend{
+	{
+		runner: TypeFirejail,
+		options: func(env *selfTestEnv) Options {
+			return Options{"allow_write_folders": []string{env.writable}}
+		},
+		hostFilesystem: true,
+		readDenied:     true,
+	},
+	{
+		runner: TypeLandrun,
+		options: func(env *selfTestEnv) Options {
+			return Options{
+				"allow_read_exec_folders": existingPaths([]string{"/bin", "/sbin", "/usr", "/lib", "/lib64", "/etc"}),
+				"allow_write_folders":     []string{env.writable, "/dev"},
+				// Landlock only restricts the network with rules for some ports
+				"allow_connect_tcp": []uint16{9},
+			}
+		},
+		hostFilesystem: true,
+		readDenied:     true,
+		isolated:       true,
+	},
+	{
+		runner: TypeNsjail,
+		options: func(env *selfTestEnv) Options {
+			return Options{"allow_write_folders": []string{env.writable}}
+		},
+		hostFilesystem: true,
+		readDenied:     true,
+	},
+	{
+		runner: TypeSandboxExec,
+		options: func(env *selfTestEnv) Options {
+			return Options{"allow_write_folders": []string{env.writable}}
+		},
+		hostFilesystem: true,
+	},
+	{
+		runner: TypeDocker,
+		options: func(env *selfTestEnv) Options {
+			return Options{"image": selfTestImage, "allow_networking": false}
+		},
+		readDenied: true,
+		interfaces: true,
+	},
+	{
+		runner: TypePodman,
+		options: func(env *selfTestEnv) Options {
+			return Options{"image": selfTestImage, "allow_networking": false}
+		},
+		readDenied: true,
+		interfaces: true,
+	},
+}
+
+// SelfTest runs canary commands through every runner available on the host,
+// verifying that their restrictions are actually enforced: that a secret
+// planted outside the allowed folders cannot be read, that files cannot be
+// written outside the writable folders (while they can inside them), and
+// that the network is blocked. Operators can run it after upgrading the
+// kernel or the sandbox tools of a host.
+//
+// The runner types registered with a SelfTestSpec are tested after the
+// built-in ones. The runners tested can be restricted to the given types. The runners that
+// are not available are reported as skipped. The Docker runner is tested
+// with the alpine:latest image, which must have been pulled.
+func SelfTest(ctx context.Context, logger Logger, types ...Type) (*SelfTestReport, error) {
+	logger = defaultLogger(logger)
+	report := &SelfTestReport{
+		Started: time.Now(),
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+	}
+	if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		report.Kernel = strings.TrimSpace(string(release))
+	}
+
+	env, err := newSelfTestEnv()
+	if err != nil {
+		return nil, err
+	}
+	defer env.close()
+	env.detectNetworkClient(ctx, logger)
+
+	for _, backend := range append(selfTestBackends, registeredSelfTestBackends()...) {
+		if len(types) > 0 && !containsType(types, backend.runner) {
+			continue
+		}
+		if _, err := New(backend.runner, backend.options(env), logger); err != nil {
+			report.Checks = append(report.Checks, SelfTestCheck{
+				Runner: backend.runner, Name: "available", Status: SelfTestSkip, Detail: err.Error(),
+			})
+			continue
+		}
+
+		logger.Info("Self test of the %s runner", backend.runner)
+		var checks []SelfTestCheck
+		if backend.isolated {
+			checks, err = runIsolatedSelfTest(ctx, selfTestHelperConfig{
+				Runner:        backend.runner,
+				Dir:           env.dir,
+				SecretContent: env.secretContent,
+				NetworkClient: env.networkClient,
+			})
+			if err != nil {
+				checks = []SelfTestCheck{{Runner: backend.runner, Name: "run", Status: SelfTestFail, Detail: err.Error()}}
+			}
+		} else {
+			checks = backend.run(ctx, env, logger)
+		}
+		report.Checks = append(report.Checks, checks...)
+	}
+	return report, nil
+}
+
+// selfTestEnv are the files and the listener the canaries use
+type selfTestEnv struct {
+	dir string
+	// secret is a file outside the allowed folders, with secretContent
+	secret        string
+	secretContent string
+	// writable is the folder the runners can write to
+	writable string
+	// denied is a folder outside the allowed folders
+	denied string
+
+	listener *canaryListener
+	// networkClient is whether the host has a client (curl, nc or bash) to
+	// connect to the listener
+	networkClient bool
+}
+
+// newSelfTestEnv plants the secret and starts the listener
+func newSelfTestEnv() (*selfTestEnv, error) {
+	dir, err := os.MkdirTemp("", "runner-selftest-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the self test folder: %w", err)
+	}
+	env := selfTestEnvAt(dir, "canary-"+randomToken())
+	for _, folder := range []string{env.writable, env.denied} {
+		if err := os.MkdirAll(folder, 0o755); err != nil {
+			env.close()
+			return nil, fmt.Errorf("failed to create the self test folder: %w", err)
+		}
+	}
+	if err := os.WriteFile(env.secret, []byte(env.secretContent+"\n"), 0o644); err != nil {
+		env.close()
+		return nil, fmt.Errorf("failed to plant the self test secret: %w", err)
+	}
+	if err := env.listen(); err != nil {
+		env.close()
+		return nil, err
+	}
+	return env, nil
+}
+
+// selfTestEnvAt returns the environment planted in dir
+func selfTestEnvAt(dir string, secretContent string) *selfTestEnv {
+	return &selfTestEnv{
+		dir:           dir,
+		secret:        filepath.Join(dir, "secret", "canary.txt"),
+		secretContent: secretContent,
+		writable:      filepath.Join(dir, "writable"),
+		denied:        filepath.Join(dir, "secret"),
+	}
+}
+
+// listen starts the listener counting the connections of the commands
+func (env *selfTestEnv) listen() error {
+	var err error
+	env.listener, err = newCanaryListener()
+	return err
+}
+
+// close removes the files and stops the listener
+func (env *selfTestEnv) close() {
+	if env.listener != nil {
+		env.listener.close()
+	}
+	_ = os.RemoveAll(env.dir)
+}
+
+// connected runs the connect script with the runner and returns whether the
+// listener got a connection
+func (env *selfTestEnv) connected(ctx context.Context, r Runner) bool {
+	before := env.listener.connections.Load()
+	_, _ = r.Run(ctx, "", env.listener.connectScript(), nil, nil, false)
+	return env.listener.connectedSince(before)
+}
+
+// detectNetworkClient checks the connect script works without restrictions
+func (env *selfTestEnv) detectNetworkClient(ctx context.Context, logger Logger) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	r, err := NewExec(Options{}, logger)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, selfTestCheckTimeout)
+	defer cancel()
+	env.networkClient = env.connected(ctx, r)
+}
+
+// run runs the checks of the backend
+func (b selfTestBackend) run(ctx context.Context, env *selfTestEnv, logger Logger) []SelfTestCheck {
+	logger = defaultLogger(logger)
+	r, err := New(b.runner, b.options(env), logger)
+	if err != nil {
+		return []SelfTestCheck{{Runner: b.runner, Name: "available", Status: SelfTestSkip, Detail: err.Error()}}
+	}
+
+	var checks []SelfTestCheck
+	check := func(name string, fn func(ctx context.Context) (SelfTestStatus, string)) {
+		ctx, cancel := context.WithTimeout(ctx, selfTestCheckTimeout)
+		defer cancel()
+		started := time.Now()
+		status, detail := fn(ctx)
+		checks = append(checks, SelfTestCheck{
+			Runner: b.runner, Name: name, Status: status, Detail: detail, Duration: time.Since(started),
+		})
+		if status == SelfTestFail {
+			logger.Error("Self test %s of the %s runner failed: %s", name, b.runner, detail)
+		}
+	}
+
+	check("run", func(ctx context.Context) (SelfTestStatus, string) {
+		token := randomToken()
+		output, err := r.Run(ctx, "", "echo "+token, nil, nil, false)
+		if err != nil {
+			return SelfTestFail, fmt.Sprintf("the canary command failed: %v", err)
+		}
+		if !strings.Contains(output, token) {
+			return SelfTestFail, fmt.Sprintf("unexpected output %q", output)
+		}
+		return SelfTestPass, ""
+	})
+
+	check("read_denied", func(ctx context.Context) (SelfTestStatus, string) {
+		if !b.readDenied {
+			return SelfTestSkip, "the runner allows reading the host filesystem by default"
+		}
+		output, _ := r.Run(ctx, "", "cat "+env.secret, nil, nil, false)
+		if strings.Contains(output, env.secretContent) {
+			return SelfTestFail, fmt.Sprintf("the secret planted in %s was read", env.secret)
+		}
+		return SelfTestPass, ""
+	})
+
+	check("write_denied", func(ctx context.Context) (SelfTestStatus, string) {
+		if !b.hostFilesystem {
+			return SelfTestSkip, "the command does not see the host filesystem"
+		}
+		path := filepath.Join(env.denied, "written.txt")
+		_, _ = r.Run(ctx, "", "echo canary > "+path, nil, nil, false)
+		if _, err := os.Stat(path); err == nil {
+			_ = os.Remove(path)
+			return SelfTestFail, fmt.Sprintf("%s was written outside the writable folders", path)
+		}
+		return SelfTestPass, ""
+	})
+
+	check("write_allowed", func(ctx context.Context) (SelfTestStatus, string) {
+		if !b.hostFilesystem {
+			return SelfTestSkip, "the command does not see the host filesystem"
+		}
+		path := filepath.Join(env.writable, "written.txt")
+		if _, err := r.Run(ctx, "", "echo canary > "+path, nil, nil, false); err != nil {
+			return SelfTestFail, fmt.Sprintf("writing to the writable folder failed: %v", err)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return SelfTestFail, fmt.Sprintf("%s was not written", path)
+		}
+		_ = os.Remove(path)
+		return SelfTestPass, ""
+	})
+
+	check("network_blocked", func(ctx context.Context) (SelfTestStatus, string) {
+		if b.interfaces {
+			output, err := r.Run(ctx, "", "ls /sys/class/net", nil, nil, false)
+			if err != nil {
+				return SelfTestFail, fmt.Sprintf("listing the network interfaces failed: %v", err)
+			}
+			if ifaces := strings.Fields(output); len(ifaces) != 1 || ifaces[0] != "lo" {
+				return SelfTestFail, fmt.Sprintf("network interfaces %v, want only lo", ifaces)
+			}
+			return SelfTestPass, ""
+		}
+		if !env.networkClient {
+			return SelfTestSkip, "no client (curl, nc or bash) could connect to the host without restrictions"
+		}
+		if env.connected(ctx, r) {
+			return SelfTestFail, "the command connected to a server of the host"
+		}
+		return SelfTestPass, ""
+	})
+
+	return checks
+}
+
+// selfTestHelperEnv is set when this executable is started as the helper
+// running the self test of an isolated backend
+const selfTestHelperEnv = "RUNNER_SELFTEST_HELPER"
+
+// selfTestHelperConfig is the configuration of the self test helper
+type selfTestHelperConfig struct {
+	Runner        Type   `json:"runner"`
+	Dir           string `json:"dir"`
+	SecretContent string `json:"secret_content"`
+	NetworkClient bool   `json:"network_client"`
+}
+
+// runSelfTestHelper runs the checks of a backend and writes them to stdout
+// as JSON. It never returns.
+func runSelfTestHelper() {
+	var config selfTestHelperConfig
+	if err := json.Unmarshal([]byte(os.Getenv(selfTestHelperEnv)), &config); err != nil {
+		fmt.Fprintf(os.Stderr, "runner-selftest-helper: invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+	os.Unsetenv(selfTestHelperEnv)
+
+	// the folder is planted (and removed) by the parent, as the runner can
+	// restrict this process
+	env := selfTestEnvAt(config.Dir, config.SecretContent)
+	if err := env.listen(); err != nil {
+		fmt.Fprintf(os.Stderr, "runner-selftest-helper: %v\n", err)
+		os.Exit(1)
+	}
+	env.networkClient = config.NetworkClient
+
+	var checks []SelfTestCheck
+	for _, backend := range selfTestBackends {
+		if backend.runner == config.Runner {
+			checks = backend.run(context.Background(), env, defaultLogger(nil))
+		}
+	}
+
+	if err := json.NewEncoder(os.Stdout).Encode(checks); err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// randomToken returns a random string for the canaries
+func randomToken() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
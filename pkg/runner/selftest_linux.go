@@ -0,0 +1,43 @@
+//go:build linux
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// runIsolatedSelfTest runs the checks of a backend in a child process (see
+// runSelfTestHelper), as the Landrun runner restricts the process running
+// the commands, irreversibly
+func runIsolatedSelfTest(ctx context.Context, config selfTestHelperConfig) ([]SelfTestCheck, error) {
+	self, err := helperExecutable("self test helper")
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, self)
+	cmd.Args = []string{"runner-selftest-helper"}
+	cmd.Env = append(os.Environ(), selfTestHelperEnv+"="+string(data))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("the self test helper failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var checks []SelfTestCheck
+	if err := json.Unmarshal(stdout.Bytes(), &checks); err != nil {
+		return nil, fmt.Errorf("invalid output of the self test helper: %w", err)
+	}
+	return checks, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package runner
+
+import (
+	"context"
+	"fmt"
+)
+
+// runIsolatedSelfTest is only needed on Linux, for the Landrun runner
+func runIsolatedSelfTest(ctx context.Context, config selfTestHelperConfig) ([]SelfTestCheck, error) {
+	return nil, fmt.Errorf("isolated self tests require Linux: %w", ErrNotSupported)
+}
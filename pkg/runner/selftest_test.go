@@ -0,0 +1,125 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSelfTestReport(t *testing.T) {
+	report := &SelfTestReport{OS: "linux", Arch: "amd64", Checks: []SelfTestCheck{
+		{Runner: TypeFirejail, Name: "run", Status: SelfTestPass},
+		{Runner: TypeDocker, Name: "available", Status: SelfTestSkip, Detail: "docker not found"},
+	}}
+	if !report.Passed() {
+		t.Errorf("Passed() = false, want true with passes and skips only")
+	}
+
+	report.Checks = append(report.Checks, SelfTestCheck{Runner: TypeFirejail, Name: "read_denied", Status: SelfTestFail})
+	if report.Passed() {
+		t.Errorf("Passed() = true, want false with a failure")
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	for _, want := range []string{"read_denied", "FAIL", "docker not found", "self test FAILED"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("WriteText() = %q, want %q", buf.String(), want)
+		}
+	}
+}
+
+func TestSelfTest_types(t *testing.T) {
+	report, err := SelfTest(context.Background(), nil, TypeSandboxExec)
+	if err != nil {
+		t.Fatalf("SelfTest failed: %v", err)
+	}
+	for _, c := range report.Checks {
+		if c.Runner != TypeSandboxExec {
+			t.Errorf("SelfTest() checked the %s runner, want only sandbox-exec", c.Runner)
+		}
+	}
+	if runtime.GOOS != "darwin" {
+		if len(report.Checks) != 1 || report.Checks[0].Status != SelfTestSkip {
+			t.Errorf("SelfTest() = %+v, want sandbox-exec skipped", report.Checks)
+		}
+	}
+}
+
+// TestSelfTest_canaries checks the canaries detect a runner enforcing no
+// restrictions
+func TestSelfTest_canaries(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+
+	env, err := newSelfTestEnv()
+	if err != nil {
+		t.Fatalf("newSelfTestEnv failed: %v", err)
+	}
+	defer env.close()
+	env.detectNetworkClient(context.Background(), nil)
+
+	backend := selfTestBackend{
+		runner:         TypeExec,
+		options:        func(*selfTestEnv) Options { return Options{} },
+		hostFilesystem: true,
+		readDenied:     true,
+	}
+	got := map[string]SelfTestStatus{}
+	for _, c := range backend.run(context.Background(), env, nil) {
+		got[c.Name] = c.Status
+	}
+
+	want := map[string]SelfTestStatus{
+		"run":           SelfTestPass,
+		"read_denied":   SelfTestFail,
+		"write_denied":  SelfTestFail,
+		"write_allowed": SelfTestPass,
+	}
+	if env.networkClient {
+		want["network_blocked"] = SelfTestFail
+	} else {
+		want["network_blocked"] = SelfTestSkip
+	}
+	for name, status := range want {
+		if got[name] != status {
+			t.Errorf("check %s = %q, want %q", name, got[name], status)
+		}
+	}
+}
+
+func TestRunIsolatedSelfTest(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the helper is only used on Linux")
+	}
+
+	env, err := newSelfTestEnv()
+	if err != nil {
+		t.Fatalf("newSelfTestEnv failed: %v", err)
+	}
+	defer env.close()
+
+	// the helper only runs the backends of SelfTest, so the result depends
+	// on the host: it must only return the checks of the runner requested
+	checks, err := runIsolatedSelfTest(context.Background(), selfTestHelperConfig{
+		Runner:        TypeFirejail,
+		Dir:           env.dir,
+		SecretContent: env.secretContent,
+	})
+	if err != nil {
+		t.Fatalf("runIsolatedSelfTest failed: %v", err)
+	}
+	if len(checks) == 0 {
+		t.Fatalf("runIsolatedSelfTest() returned no checks")
+	}
+	for _, c := range checks {
+		if c.Runner != TypeFirejail {
+			t.Errorf("runIsolatedSelfTest() checked the %s runner, want firejail", c.Runner)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// LoginSessionOptions runs the commands of a runner in a fresh login session
+// of another user (Linux only).
+//
+// Every command is started by systemd as a transient service that opens a
+// PAM session for the user, so it gets its own logind session, the limits
+// configured for the user (pam_limits, the user slice) and a clean
+// environment. Stopping the unit when the execution completes (or is
+// cancelled) kills every process it left behind.
+type LoginSessionOptions struct {
+	// User is the name (or numeric ID) of the user the command runs as
+	User string `json:"user"`
+
+	// PAMService is the PAM service used to open the session (defaults to "login")
+	PAMService string `json:"pam_service"`
+
+	// Slice is the systemd slice the unit is placed in (e.g. "restricted.slice")
+	Slice string `json:"slice"`
+
+	// Properties are extra unit properties, e.g. "MemoryMax=512M" or "TasksMax=64"
+	Properties []string `json:"properties"`
+}
+
+// systemdRunPath is the command used to start login sessions
+const systemdRunPath = "systemd-run"
+
+// loginSessionUnitPrefix is the prefix of the units of the login sessions
+const loginSessionUnitPrefix = "restricted-runner-"
+
+// validate checks the options of the login session
+func (o *LoginSessionOptions) validate() error {
+	if o == nil {
+		return nil
+	}
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("login sessions are only available on Linux: %w", ErrNotSupported)
+	}
+	if o.User == "" {
+		return fmt.Errorf("login_session requires a user")
+	}
+	if _, _, err := o.lookupUser(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// lookupUser returns the user and group IDs of the target user
+func (o *LoginSessionOptions) lookupUser() (int, int, error) {
+	u, err := user.Lookup(o.User)
+	if err != nil {
+		u, err = user.LookupId(o.User)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("unknown login session user %q: %w", o.User, err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid uid %q for user %q: %w", u.Uid, o.User, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid gid %q for user %q: %w", u.Gid, o.User, err)
+	}
+	return uid, gid, nil
+}
+
+// pamService returns the PAM service used to open the session
+func (o *LoginSessionOptions) pamService() string {
+	if o.PAMService != "" {
+		return o.PAMService
+	}
+	return "login"
+}
+
+// grant gives the target user the ownership of the given paths (e.g. the
+// temporary script of the command), so the session can read them
+func (o *LoginSessionOptions) grant(paths ...string) error {
+	uid, gid, err := o.lookupUser()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return fmt.Errorf("failed to give %s to user %s: %w", path, o.User, err)
+		}
+	}
+	return nil
+}
+
+// systemdRunArgs returns the systemd-run arguments for running the command
+// (path and args, including args[0]) in the given unit.
+//
+// Only the explicit env is passed to the session: the rest of its environment
+// is set up by PAM for the target user.
+func (o *LoginSessionOptions) systemdRunArgs(unit string, path string, args []string,
+	env []string, dir string, loopback bool, namespaces namespaceSetup,
+) []string {
+	runArgs := []string{
+		"--quiet",
+		"--collect",
+		"--wait",
+		"--pipe",
+		"--service-type=exec",
+		"--unit=" + unit,
+		"--uid=" + o.User,
+		"--property=PAMName=" + o.pamService(),
+	}
+	if o.Slice != "" {
+		runArgs = append(runArgs, "--slice="+o.Slice)
+	}
+	if loopback {
+		// a private network namespace with only the loopback interface
+		runArgs = append(runArgs, "--property=PrivateNetwork=yes")
+	}
+	if namespaces.PrivatePIDs {
+		// requires systemd 257
+		runArgs = append(runArgs, "--property=PrivatePIDs=yes")
+	}
+	if namespaces.PrivateIPC {
+		runArgs = append(runArgs, "--property=PrivateIPC=yes")
+	}
+	if namespaces.ReadOnlyRoot {
+		runArgs = append(runArgs, "--property=ProtectSystem=strict")
+		if len(namespaces.Writable) > 0 {
+			runArgs = append(runArgs, "--property=ReadWritePaths="+strings.Join(namespaces.Writable, " "))
+		}
+	}
+	for _, prop := range o.Properties {
+		runArgs = append(runArgs, "--property="+prop)
+	}
+	if dir != "" {
+		runArgs = append(runArgs, "--working-directory="+dir)
+	}
+	for _, e := range env {
+		runArgs = append(runArgs, "--setenv="+e)
+	}
+	runArgs = append(runArgs, "--", path)
+	if len(args) > 1 {
+		runArgs = append(runArgs, args[1:]...)
+	}
+	return runArgs
+}
+
+// wrap rewrites the command so it runs in a new login session, and returns
+// the unit of the session. The unit must be stopped (see stopLoginSession)
+// once the command has completed, so no process survives it.
+func (o *LoginSessionOptions) wrap(ctx context.Context, logger Logger, execCmd *exec.Cmd, env []string,
+	namespaces namespaceSetup,
+) (string, error) {
+	if len(extraFilesFrom(ctx)) > 0 {
+		return "", fmt.Errorf("extra files cannot be passed to login sessions: %w", ErrNotSupported)
+	}
+
+	unit := loginSessionUnitPrefix + newExecutionID() + ".service"
+	runArgs := o.systemdRunArgs(unit, execCmd.Path, execCmd.Args, env, execCmd.Dir, loopbackNetworkFrom(ctx), namespaces)
+
+	logger.Debug("Running command as %s in login session unit %s", o.User, unit)
+	if execCmd.Err == nil {
+		runPath, err := exec.LookPath(systemdRunPath)
+		if err != nil {
+			return "", fmt.Errorf("login sessions require %s: %w", systemdRunPath, err)
+		}
+		execCmd.Path = runPath
+	}
+	execCmd.Args = append([]string{systemdRunPath}, runArgs...)
+	execCmd.Env = nil
+	execCmd.Dir = ""
+
+	// Killing systemd-run does not stop the service: stop the unit first
+	if execCmd.Cancel != nil {
+		cancel := execCmd.Cancel
+		execCmd.Cancel = func() error {
+			stopLoginSession(logger, unit)
+			return cancel()
+		}
+	}
+
+	return unit, nil
+}
+
+// stopLoginSession stops the unit of a login session, killing its processes.
+// Units that already completed are gone, so errors are only logged.
+func stopLoginSession(logger Logger, unit string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if output, err := commandContext(ctx, "systemctl", "stop", unit).CombinedOutput(); err != nil {
+		logger.Debug("Login session unit %s not stopped: %v: %s", unit, err, string(output))
+	}
+}
+
+// loginSessionBackend implements execution operations for commands running
+// in a login session, whose processes are not children of the runner
+type loginSessionBackend struct {
+	// unit is the systemd unit of the session
+	unit string
+}
+
+func (b *loginSessionBackend) pause() error {
+	return b.kill("SIGSTOP")
+}
+
+func (b *loginSessionBackend) resume() error {
+	return b.kill("SIGCONT")
+}
+
+func (b *loginSessionBackend) signal(sig syscall.Signal) error {
+	return b.kill(strconv.Itoa(int(sig)))
+}
+
+// kill sends a signal to all the processes of the unit
+func (b *loginSessionBackend) kill(sig string) error {
+	if output, err := exec.Command("systemctl", "kill", "--signal="+sig, b.unit).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to send %s to unit %s: %w: %s", sig, b.unit, err, string(output))
+	}
+	return nil
+}
+
+// checkLoginSessionRequirements checks the tools used to start login sessions
+func checkLoginSessionRequirements() error {
+	if !common.CheckExecutableExists(systemdRunPath) {
+		return fmt.Errorf("%s executable not found in PATH", systemdRunPath)
+	}
+	if !common.CheckExecutableExists("systemctl") {
+		return fmt.Errorf("systemctl executable not found in PATH")
+	}
+	return nil
+}
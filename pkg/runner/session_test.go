@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestLoginSessionOptions_systemdRunArgs(t *testing.T) {
+	o := &LoginSessionOptions{
+		User:       "nobody",
+		Slice:      "restricted.slice",
+		Properties: []string{"MemoryMax=512M"},
+	}
+
+	args := o.systemdRunArgs("restricted-runner-1.service", "/bin/echo", []string{"echo", "hello"},
+		[]string{"FOO=bar"}, "/tmp", true, namespaceSetup{PrivatePIDs: true, ReadOnlyRoot: true, Writable: []string{"/srv/a", "/srv/b"}})
+	expected := []string{
+		"--quiet", "--collect", "--wait", "--pipe", "--service-type=exec",
+		"--unit=restricted-runner-1.service",
+		"--uid=nobody",
+		"--property=PAMName=login",
+		"--slice=restricted.slice",
+		"--property=PrivateNetwork=yes",
+		"--property=PrivatePIDs=yes",
+		"--property=ProtectSystem=strict",
+		"--property=ReadWritePaths=/srv/a /srv/b",
+		"--property=MemoryMax=512M",
+		"--working-directory=/tmp",
+		"--setenv=FOO=bar",
+		"--", "/bin/echo", "hello",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("unexpected arguments:\n got %v\nwant %v", args, expected)
+	}
+
+	o = &LoginSessionOptions{User: "nobody", PAMService: "su"}
+	args = o.systemdRunArgs("u.service", "/bin/true", []string{"true"}, nil, "", false, namespaceSetup{})
+	expected = []string{
+		"--quiet", "--collect", "--wait", "--pipe", "--service-type=exec",
+		"--unit=u.service", "--uid=nobody", "--property=PAMName=su",
+		"--", "/bin/true",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("unexpected arguments:\n got %v\nwant %v", args, expected)
+	}
+}
+
+func TestLoginSessionOptions_validate(t *testing.T) {
+	var none *LoginSessionOptions
+	if err := none.validate(); err != nil {
+		t.Errorf("expected no error without a login session, got %v", err)
+	}
+
+	if runtime.GOOS != "linux" {
+		err := (&LoginSessionOptions{User: "nobody"}).validate()
+		if !errors.Is(err, ErrNotSupported) {
+			t.Errorf("expected ErrNotSupported, got %v", err)
+		}
+		return
+	}
+
+	if err := (&LoginSessionOptions{}).validate(); err == nil {
+		t.Error("expected an error without a user")
+	}
+	if err := (&LoginSessionOptions{User: "no-such-user-for-runner-tests"}).validate(); err == nil {
+		t.Error("expected an error for an unknown user")
+	}
+	if err := (&LoginSessionOptions{User: "0"}).validate(); err != nil {
+		t.Errorf("expected numeric user IDs to be accepted, got %v", err)
+	}
+}
+
+func TestNewExec_loginSession(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("login sessions are only available on Linux")
+	}
+
+	r, err := NewExec(Options{
+		"login_session": map[string]interface{}{
+			"user":       "root",
+			"properties": []string{"TasksMax=16"},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("failed to create runner: %v", err)
+	}
+	if r.options.LoginSession == nil || r.options.LoginSession.User != "root" {
+		t.Fatalf("login session not parsed: %+v", r.options.LoginSession)
+	}
+	if got := r.options.LoginSession.Properties; !reflect.DeepEqual(got, []string{"TasksMax=16"}) {
+		t.Errorf("unexpected properties: %v", got)
+	}
+}
@@ -0,0 +1,167 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// defaultGracePeriod is the time a command has to exit after its shutdown action
+const defaultGracePeriod = 10 * time.Second
+
+// ShutdownAction is how a command (typically a server) is asked to exit
+// when the context of its execution is cancelled, before it is killed.
+// The actions set are run in order: HTTP request, command and signal.
+type ShutdownAction struct {
+	// HTTPURL is a URL requested to shut down the server. It is processed as
+	// a template with the params of the execution.
+	HTTPURL string
+
+	// HTTPMethod is the method of the request (defaults to POST)
+	HTTPMethod string
+
+	// Command is a command run in the host to shut down the server (e.g. a
+	// client of its admin API). Its arguments are processed as templates.
+	Command []string
+
+	// Signal is sent to the command and all its children (e.g. syscall.SIGTERM).
+	// It is not supported on Windows.
+	Signal syscall.Signal
+
+	// GracePeriod is the time the command has to exit after the actions,
+	// before it is killed (defaults to 10s)
+	GracePeriod time.Duration
+}
+
+// WithShutdown runs a shutdown action when the context of the execution is
+// cancelled, giving the command a grace period to exit before killing it.
+// Without it, cancelling the context terminates the command immediately.
+func WithShutdown(action ShutdownAction) ExecOption {
+	return func(c *execConfig) {
+		c.shutdown = &action
+	}
+}
+
+// validate checks that the action does something
+func (a *ShutdownAction) validate() error {
+	if a.HTTPURL == "" && len(a.Command) == 0 && a.Signal == 0 {
+		return fmt.Errorf("shutdown action requires an HTTP URL, a command or a signal")
+	}
+	return nil
+}
+
+// gracefulContext returns the context the command must be started with: it
+// is not cancelled with ctx, but once the shutdown action has been run and
+// the grace period has elapsed (or the execution has completed). The
+// returned watch function starts watching ctx once the execution has
+// started, and kill cancels the context right away.
+func gracefulContext(ctx context.Context, action *ShutdownAction, params map[string]interface{}) (
+	runCtx context.Context, watch func(e *Execution), kill context.CancelFunc,
+) {
+	runCtx, kill = context.WithCancel(context.WithoutCancel(ctx))
+
+	watch = func(e *Execution) {
+		exited := make(chan struct{})
+		e.exitHooks = append(e.exitHooks, func() {
+			close(exited)
+			kill()
+		})
+
+		go func() {
+			select {
+			case <-exited:
+				return
+			case <-ctx.Done():
+			}
+
+			grace := action.GracePeriod
+			if grace <= 0 {
+				grace = defaultGracePeriod
+			}
+			e.logger.Debug("Shutting down execution %s (grace period %v)", e.ID, grace)
+
+			actionCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), grace)
+			defer cancel()
+			if err := action.run(actionCtx, e, params); err != nil {
+				e.logger.Debug("Shutdown action of execution %s failed: %v", e.ID, err)
+			}
+
+			select {
+			case <-exited:
+				e.logger.Debug("Execution %s exited gracefully", e.ID)
+			case <-actionCtx.Done():
+				e.logger.Debug("Execution %s did not exit in %v, killing it", e.ID, grace)
+				// cancelling the context would only terminate the command, which already had its chance
+				if e.backend != nil {
+					if err := e.backend.signal(syscall.SIGKILL); err != nil {
+						e.logger.Debug("Failed to kill execution %s: %v", e.ID, err)
+					}
+				}
+				kill()
+			}
+		}()
+	}
+	return runCtx, watch, kill
+}
+
+// run runs the shutdown actions, returning the first error
+func (a *ShutdownAction) run(ctx context.Context, e *Execution, params map[string]interface{}) error {
+	var firstErr error
+	record := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if a.HTTPURL != "" {
+		record(a.request(ctx, params))
+	}
+	if len(a.Command) > 0 {
+		args := common.ProcessTemplateListFlexible(a.Command, params)
+		output, err := commandContext(ctx, args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			record(fmt.Errorf("shutdown command failed: %w: %s", err, strings.TrimSpace(string(output))))
+		}
+	}
+	if a.Signal != 0 {
+		if e.backend == nil {
+			record(fmt.Errorf("cannot send %v: %w", a.Signal, ErrNotSupported))
+		} else {
+			record(e.backend.signal(a.Signal))
+		}
+	}
+	return firstErr
+}
+
+// request sends the shutdown HTTP request
+func (a *ShutdownAction) request(ctx context.Context, params map[string]interface{}) error {
+	url, err := common.ProcessTemplate(a.HTTPURL, params)
+	if err != nil {
+		return fmt.Errorf("invalid shutdown URL: %w", err)
+	}
+	method := a.HTTPMethod
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("shutdown request failed: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("shutdown request failed: %s", resp.Status)
+	}
+	return nil
+}
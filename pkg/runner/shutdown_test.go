@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// trapScript runs until it gets a SIGTERM, when it says goodbye and exits
+const trapScript = `trap 'echo bye; exit 0' TERM; echo started; while :; do sleep 0.05; done`
+
+// startWithShutdown starts a shell script with a shutdown action, returning
+// the execution and the function cancelling its context
+func startWithShutdown(t *testing.T, script string, action ShutdownAction) (*Execution, context.CancelFunc) {
+	t.Helper()
+
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	e, err := Start(ctx, r, "sh", []string{"-c", script}, nil, nil, WithShutdown(action))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	go func() { _, _ = io.Copy(io.Discard, e.Stderr) }()
+
+	// wait for the script to install its trap
+	buf := make([]byte, len("started\n"))
+	if _, err := io.ReadFull(e.Stdout, buf); err != nil {
+		t.Fatalf("failed to read from the command: %v", err)
+	}
+	return e, cancel
+}
+
+func TestShutdown_Signal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals are not supported on Windows")
+	}
+
+	e, cancel := startWithShutdown(t, trapScript, ShutdownAction{
+		Signal:      syscall.SIGTERM,
+		GracePeriod: 5 * time.Second,
+	})
+	cancel()
+
+	output, _ := io.ReadAll(e.Stdout)
+	if err := e.Wait(); err != nil {
+		t.Fatalf("expected a graceful exit, got: %v", err)
+	}
+	if strings.TrimSpace(string(output)) != "bye" {
+		t.Errorf("expected the trap to run, got output %q", output)
+	}
+}
+
+func TestShutdown_GracePeriodExpires(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals are not supported on Windows")
+	}
+
+	// the script ignores the signal, so it must be killed
+	e, cancel := startWithShutdown(t, `trap '' TERM; echo started; while :; do sleep 0.05; done`, ShutdownAction{
+		Signal:      syscall.SIGTERM,
+		GracePeriod: 200 * time.Millisecond,
+	})
+	start := time.Now()
+	cancel()
+
+	_, _ = io.ReadAll(e.Stdout)
+	if err := e.Wait(); err == nil {
+		t.Fatal("expected the killed command to fail")
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("expected the command to be killed after the grace period, took %v", elapsed)
+	}
+}
+
+func TestShutdown_HTTP(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/shutdown" {
+			requests.Add(1)
+		}
+	}))
+	defer srv.Close()
+
+	e, cancel := startWithShutdown(t, `echo started; while :; do sleep 0.05; done`, ShutdownAction{
+		HTTPURL:     srv.URL + "/shutdown",
+		GracePeriod: 100 * time.Millisecond,
+	})
+	cancel()
+
+	_, _ = io.ReadAll(e.Stdout)
+	_ = e.Wait()
+	if requests.Load() != 1 {
+		t.Errorf("expected one shutdown request, got %d", requests.Load())
+	}
+}
+
+func TestShutdown_NotCancelled(t *testing.T) {
+	e, _ := startWithShutdown(t, `echo started; exit 0`, ShutdownAction{
+		Command: []string{"false"},
+	})
+
+	_, _ = io.ReadAll(e.Stdout)
+	if err := e.Wait(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestShutdown_Validation(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Start(context.Background(), r, "true", nil, nil, nil, WithShutdown(ShutdownAction{})); err == nil {
+		t.Fatal("expected an error for an empty shutdown action")
+	}
+}
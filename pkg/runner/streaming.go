@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"sync"
+)
+
+// maxStreamLine is the size of the longest line passed to the callbacks of
+// RunStreaming: longer lines are passed in pieces of this size
+const maxStreamLine = 64 * 1024
+
+// RunStreaming runs a command with the RunEx method of a runner, calling
+// onStdout and onStderr (when not nil) with every line of its output while
+// it runs, instead of only returning the output once it has finished. The
+// lines are passed without their line ending, and are only valid until the
+// callback returns. The callbacks of a stream are not called concurrently,
+// and the last line (even without a newline) is passed before RunStreaming
+// returns. The Stdout and Stderr writers of the request are replaced.
+func RunStreaming(ctx context.Context, r Runner, req RunRequest, onStdout func(line []byte), onStderr func(line []byte)) (
+	*RunResult, error) {
+	var writers []*lineWriter
+	if onStdout != nil {
+		w := &lineWriter{onLine: onStdout}
+		req.Stdout = w
+		writers = append(writers, w)
+	}
+	if onStderr != nil {
+		w := &lineWriter{onLine: onStderr}
+		req.Stderr = w
+		writers = append(writers, w)
+	}
+
+	result, err := RunEx(ctx, r, req)
+	for _, w := range writers {
+		w.flush()
+	}
+	return result, err
+}
+
+// lineWriter calls a function with every line written to it
+type lineWriter struct {
+	mu     sync.Mutex
+	onLine func(line []byte)
+	// partial is the last line written, until its newline is
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.partial = append(w.partial, p...)
+			for len(w.partial) >= maxStreamLine {
+				w.onLine(w.partial[:maxStreamLine])
+				w.partial = append(w.partial[:0], w.partial[maxStreamLine:]...)
+			}
+			break
+		}
+		line := p[:i]
+		if len(w.partial) > 0 {
+			line = append(w.partial, line...)
+		}
+		w.emit(line)
+		w.partial = w.partial[:0]
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// flush passes the last line, if it did not end with a newline
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.emit(w.partial)
+		w.partial = nil
+	}
+}
+
+// emit passes a line to the function, in pieces if it is too long
+func (w *lineWriter) emit(line []byte) {
+	for len(line) > maxStreamLine {
+		w.onLine(line[:maxStreamLine])
+		line = line[maxStreamLine:]
+	}
+	w.onLine(bytes.TrimSuffix(line, []byte("\r")))
+}
@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := &lineWriter{onLine: func(line []byte) { lines = append(lines, string(line)) }}
+	for _, chunk := range []string{"first\nsec", "ond\r\n", "\nthi", "rd"} {
+		if n, err := w.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+	w.flush()
+	want := []string{"first", "second", "", "third"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+
+	lines = nil
+	_, _ = w.Write([]byte(strings.Repeat("x", maxStreamLine+10) + "\n"))
+	if len(lines) != 2 || len(lines[0]) != maxStreamLine || len(lines[1]) != 10 {
+		t.Errorf("a long line was passed in %d pieces", len(lines))
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("broken") }
+
+func TestRunStreaming(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test uses a POSIX shell")
+	}
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	ctx := context.Background()
+
+	// the first line arrives before the command finishes
+	started := time.Now()
+	var stdout, stderr []string
+	var firstLine time.Duration
+	result, err := RunStreaming(ctx, r, RunRequest{Shell: "sh", Command: "echo one; echo oops >&2; sleep 0.5; printf two"},
+		func(line []byte) {
+			if stdout == nil {
+				firstLine = time.Since(started)
+			}
+			stdout = append(stdout, string(line))
+		},
+		func(line []byte) { stderr = append(stderr, string(line)) })
+	if err != nil {
+		t.Fatalf("RunStreaming failed: %v", err)
+	}
+	if !reflect.DeepEqual(stdout, []string{"one", "two"}) || !reflect.DeepEqual(stderr, []string{"oops"}) {
+		t.Errorf("streamed %q and %q", stdout, stderr)
+	}
+	if firstLine >= result.Duration {
+		t.Errorf("the first line arrived after %v, when the command finished after %v", firstLine, result.Duration)
+	}
+	if string(result.Stdout) != "one\ntwo" {
+		t.Errorf("Stdout = %q", result.Stdout)
+	}
+
+	// a failing writer does not fail the command
+	result, err = r.RunEx(ctx, RunRequest{Shell: "sh", Command: "echo hello", Stdout: failingWriter{}})
+	if err != nil || string(result.Stdout) != "hello\n" {
+		t.Errorf("RunEx() with a failing writer = %v, %v", result, err)
+	}
+}
+
+func TestRunExFromRun_Streaming(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := RunExFromRun(context.Background(), runOnly{}, RunRequest{Command: "done", Stdout: &buf}); err != nil {
+		t.Fatalf("RunExFromRun failed: %v", err)
+	}
+	if buf.String() != "  done  " {
+		t.Errorf("the output written once finished = %q", buf.String())
+	}
+}
@@ -0,0 +1,240 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+)
+
+// RunnerSupport describes the support of a runner type on the host
+type RunnerSupport struct {
+	// Runner is the runner type
+	Runner Type `json:"runner"`
+
+	// Available is whether runners of the type can be created on the host
+	Available bool `json:"available"`
+
+	// Reason explains why the runner type is not available
+	Reason string `json:"reason,omitempty"`
+
+	// Version is the version of the tool the runner type uses (e.g. the
+	// firejail executable), when it has one and it is installed
+	Version string `json:"version,omitempty"`
+
+	// Features are the isolation features the runner type supports on the
+	// host (see SupportMatrix)
+	Features []string `json:"features,omitempty"`
+
+	// Experimental are the experimental features that apply to the runner type
+	Experimental []Feature `json:"experimental,omitempty"`
+}
+
+// SupportMatrixReport is the result of SupportMatrix
+type SupportMatrixReport struct {
+	// OS, Arch and Kernel describe the host
+	OS     string `json:"os"`
+	Arch   string `json:"arch"`
+	Kernel string `json:"kernel,omitempty"`
+
+	// LandlockABI is the Landlock ABI version of the kernel, or 0 when
+	// Landlock is not available
+	LandlockABI int `json:"landlock_abi,omitempty"`
+
+	// Runners are the runner types, in the order of Types
+	Runners []RunnerSupport `json:"runners"`
+}
+
+// Runner returns the support of a runner type, if it is in the report
+func (r *SupportMatrixReport) Runner(runnerType Type) (RunnerSupport, bool) {
+	for _, s := range r.Runners {
+		if s.Runner == runnerType {
+			return s, true
+		}
+	}
+	return RunnerSupport{}, false
+}
+
+// Available returns the runner types available on the host
+func (r *SupportMatrixReport) Available() []Type {
+	var types []Type
+	for _, s := range r.Runners {
+		if s.Available {
+			types = append(types, s.Runner)
+		}
+	}
+	return types
+}
+
+// WriteText writes the report as a table
+func (r *SupportMatrixReport) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "RUNNER\tAVAILABLE\tVERSION\tFEATURES\tREASON\n")
+	for _, s := range r.Runners {
+		available := "no"
+		if s.Available {
+			available = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.Runner, available, s.Version, strings.Join(s.Features, ","), s.Reason)
+	}
+	fmt.Fprintf(tw, "\nhost %s/%s %s", r.OS, r.Arch, r.Kernel)
+	if r.LandlockABI > 0 {
+		fmt.Fprintf(tw, " (landlock ABI %d)", r.LandlockABI)
+	}
+	fmt.Fprintf(tw, "\n")
+	return tw.Flush()
+}
+
+// supportProbeOptions are the options the runner types that need some are
+// created with to check they are available
+var supportProbeOptions = map[Type]Options{
+	TypeDocker: {"image": selfTestImage},
+	TypePodman: {"image": selfTestImage},
+	TypeProot:  {"rootfs": "/"},
+}
+
+// isolationFeatures are the restrictions enforced by each built-in runner
+// type: "filesystem" (the files the commands can read and write), "network"
+// (the network they can reach), "container" (they run in a container, not
+// in the host) and "device" (they run in another device)
+var isolationFeatures = map[Type][]string{
+	TypeSandboxExec:       {"filesystem", "network"},
+	TypeFirejail:          {"filesystem", "network"},
+	TypeLandrun:           {"filesystem", "network"},
+	TypeNsjail:            {"filesystem", "network"},
+	TypeDocker:            {"filesystem", "network", "container"},
+	TypePodman:            {"filesystem", "network", "container"},
+	TypeADB:               {"device"},
+	TypeProot:             {"filesystem"},
+	TypeDeno:              {"filesystem", "network"},
+	TypePython:            {"filesystem", "network"},
+	TypeWindowsRestricted: {"filesystem", "network"},
+	TypeUnshare:           {"filesystem", "network"},
+}
+
+// optionFeatures are the features provided by the options the runner types
+// embed, with the requirements of the host, if any
+var optionFeatures = []struct {
+	name    string
+	options interface{}
+	check   func() error
+}{
+	{"timeout", TimeoutOptions{}, nil},
+	{"output_limits", OutputLimitOptions{}, nil},
+	{"preflight", PreflightOptions{}, nil},
+	{"private_pids", NamespaceOptions{}, NamespaceOptions{PrivatePIDs: true}.validateNamespaces},
+	{"read_only_root", NamespaceOptions{}, NamespaceOptions{ReadOnlyRoot: true}.validateNamespaces},
+	{"private_ipc", NamespaceOptions{}, NamespaceOptions{PrivateIPC: true}.validateNamespaces},
+	{"private_uts", NamespaceOptions{}, NamespaceOptions{PrivateUTS: true}.validateNamespaces},
+	{"ephemeral_uid", EphemeralUIDOptions{}, func() error {
+		opts := EphemeralUIDOptions{EphemeralUID: true}
+		if err := opts.validateEphemeralUID(NamespaceOptions{}); err != nil {
+			return err
+		}
+		return opts.checkEphemeralUID()
+	}},
+	{"seccomp", SeccompOptions{}, func() error { return checkSeccompFilter(denyProfile(seccompDefaultDenied)) }},
+}
+
+// SupportMatrix reports, for the host, every runner type available to New
+// (see Types): whether it is available, the version of its tool, and the
+// features it supports, so installers and user interfaces can offer the
+// isolation available without probing the host themselves.
+//
+// The features are machine-readable names: the isolation the runner type
+// enforces ("filesystem", "network", "container" and "device"), and the
+// options supported on the host ("timeout", "output_limits", "preflight",
+// "private_pids", "read_only_root", "private_ipc", "private_uts",
+// "ephemeral_uid" and "seccomp").
+// Landrun only restricts the network with Landlock ABI 4 or newer. The
+// features of the registered types are not known.
+//
+// A runner type is available when a runner with its default options can be
+// created, which checks its implicit requirements: the Docker and Podman
+// types are checked with the alpine:latest image (which is not pulled), and
+// the Proot type with the root of the host as root filesystem.
+func SupportMatrix(ctx context.Context, logger Logger) *SupportMatrixReport {
+	report := &SupportMatrixReport{
+		OS:   runtime.GOOS,
+		Arch: runtime.GOARCH,
+	}
+	if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		report.Kernel = strings.TrimSpace(string(release))
+	}
+	if abi, err := landlockABI(); err == nil {
+		report.LandlockABI = abi
+	}
+
+	for _, t := range Types() {
+		support := RunnerSupport{Runner: t, Available: true}
+		if _, err := New(t, supportOptions(t), logger); err != nil {
+			support.Available = false
+			support.Reason = err.Error()
+		}
+		if version, err := toolVersion(ctx, t); err == nil {
+			support.Version = version
+		}
+		support.Features = supportedFeatures(t, report.LandlockABI)
+		for _, f := range Features() {
+			if containsType(experimentalFeatures[f], t) {
+				support.Experimental = append(support.Experimental, f)
+			}
+		}
+		report.Runners = append(report.Runners, support)
+	}
+	return report
+}
+
+// supportOptions returns the options a runner type is checked with
+func supportOptions(runnerType Type) Options {
+	if options, ok := supportProbeOptions[runnerType]; ok {
+		return options
+	}
+	if backend, ok := registeredBackend(runnerType); ok && backend.SelfTest != nil {
+		return backend.SelfTest.Options(os.TempDir())
+	}
+	return Options{}
+}
+
+// supportedFeatures returns the features of a runner type on the host
+func supportedFeatures(runnerType Type, landlockABI int) []string {
+	var features []string
+	for _, f := range isolationFeatures[runnerType] {
+		if runnerType == TypeLandrun && f == "network" && landlockABI < 4 {
+			continue
+		}
+		features = append(features, f)
+	}
+
+	options, ok := optionStructs[runnerType]
+	if !ok {
+		return features
+	}
+	for _, f := range optionFeatures {
+		if !embedsOptions(options, f.options) {
+			continue
+		}
+		if f.check != nil && f.check() != nil {
+			continue
+		}
+		features = append(features, f.name)
+	}
+	return features
+}
+
+// embedsOptions returns whether the options struct of a runner type embeds
+// the given options
+func embedsOptions(options interface{}, embedded interface{}) bool {
+	t := reflect.TypeOf(options)
+	want := reflect.TypeOf(embedded)
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.Anonymous && f.Type == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSupportMatrix(t *testing.T) {
+	report := SupportMatrix(context.Background(), nil)
+	if report.OS != runtime.GOOS || report.Arch != runtime.GOARCH {
+		t.Errorf("host = %s/%s, want %s/%s", report.OS, report.Arch, runtime.GOOS, runtime.GOARCH)
+	}
+	if len(report.Runners) != len(Types()) {
+		t.Fatalf("the report has %d runners, want %d", len(report.Runners), len(Types()))
+	}
+	for i, s := range report.Runners {
+		if s.Runner != Types()[i] {
+			t.Errorf("runner %d = %s, want %s", i, s.Runner, Types()[i])
+		}
+		if !s.Available && s.Reason == "" {
+			t.Errorf("the %s runner is not available without a reason", s.Runner)
+		}
+	}
+
+	exec, ok := report.Runner(TypeExec)
+	if !ok || !exec.Available {
+		t.Fatalf("the exec runner should be available: %+v", exec)
+	}
+	if !contains(exec.Features, "timeout") {
+		t.Errorf("the exec runner features %v should include timeout", exec.Features)
+	}
+	if runtime.GOOS == "linux" && !contains(exec.Features, "private_pids") {
+		t.Errorf("the exec runner features %v should include private_pids on Linux", exec.Features)
+	}
+	if runtime.GOOS != "linux" && contains(exec.Features, "private_pids") {
+		t.Errorf("the exec runner features %v should not include private_pids", exec.Features)
+	}
+	if available := report.Available(); len(available) == 0 || available[0] != TypeExec {
+		t.Errorf("Available() = %v, want the exec runner first", available)
+	}
+
+	landrun, _ := report.Runner(TypeLandrun)
+	if len(landrun.Experimental) != 1 || landrun.Experimental[0] != FeatureLandlockHelper {
+		t.Errorf("the landrun experimental features = %v", landrun.Experimental)
+	}
+
+	sandbox, _ := report.Runner(TypeSandboxExec)
+	if runtime.GOOS != "darwin" && sandbox.Available {
+		t.Errorf("the sandbox-exec runner should not be available on %s", runtime.GOOS)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "exec") {
+		t.Errorf("the text report misses the exec runner:\n%s", buf.String())
+	}
+	if _, err := json.Marshal(report); err != nil {
+		t.Errorf("the report cannot be marshalled: %v", err)
+	}
+}
+
+func TestSupportedFeatures(t *testing.T) {
+	if features := supportedFeatures(TypeLandrun, 3); contains(features, "network") {
+		t.Errorf("landrun with Landlock ABI 3 should not restrict the network: %v", features)
+	}
+	if features := supportedFeatures(TypeLandrun, 4); !contains(features, "network") {
+		t.Errorf("landrun with Landlock ABI 4 should restrict the network: %v", features)
+	}
+	if features := supportedFeatures(TypeDocker, 0); contains(features, "private_pids") {
+		t.Errorf("the docker runner has no namespace options: %v", features)
+	}
+	if features := supportedFeatures("unknown", 0); len(features) != 0 {
+		t.Errorf("the features of an unknown type = %v", features)
+	}
+}
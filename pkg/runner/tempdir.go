@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	// ScratchDirParam is the template parameter holding the scratch directory
+	// of an execution (see WithTempDirProvider)
+	ScratchDirParam = "scratch_dir"
+
+	// ScratchDirEnv is the environment variable holding the scratch directory
+	// of an execution (see WithTempDirProvider)
+	ScratchDirEnv = "SCRATCH_DIR"
+)
+
+// TempDirMode is the filesystem used for capping the size of scratch directories
+type TempDirMode string
+
+const (
+	// TempDirTmpfs mounts a tmpfs filesystem, backed by memory
+	TempDirTmpfs TempDirMode = "tmpfs"
+
+	// TempDirLoop mounts an ext4 filesystem stored in an image file in the
+	// volume, through a loop device
+	TempDirLoop TempDirMode = "loop"
+)
+
+// TempDirOptions configures a TempDirProvider
+type TempDirOptions struct {
+	// Dir is the folder where the scratch directories (and the images of
+	// the loop devices) are created. It defaults to the temporary directory.
+	Dir string `json:"dir"`
+
+	// SizeLimit is the maximum size of every scratch directory, in bytes
+	SizeLimit int64 `json:"size_limit"`
+
+	// Mode is the filesystem of the scratch directories (default: tmpfs)
+	Mode TempDirMode `json:"mode"`
+}
+
+// TempDirProvider creates per-execution scratch directories with a size cap,
+// so commands filling their scratch space get "no space left on device"
+// errors instead of filling the root filesystem. Mounting the filesystems
+// requires root (or CAP_SYS_ADMIN) on Linux.
+type TempDirProvider struct {
+	options TempDirOptions
+}
+
+// NewTempDirProvider returns a provider of scratch directories.
+// ErrNotSupported is returned on systems other than Linux.
+func NewTempDirProvider(options TempDirOptions) (*TempDirProvider, error) {
+	if options.SizeLimit <= 0 {
+		return nil, fmt.Errorf("the size limit of the scratch directories must be positive")
+	}
+	switch options.Mode {
+	case "":
+		options.Mode = TempDirTmpfs
+	case TempDirTmpfs, TempDirLoop:
+	default:
+		return nil, fmt.Errorf("unknown scratch directory mode %q", options.Mode)
+	}
+	if options.Dir == "" {
+		options.Dir = os.TempDir()
+	}
+	if err := checkTempDirMode(options.Mode); err != nil {
+		return nil, err
+	}
+	return &TempDirProvider{options: options}, nil
+}
+
+// TempDir is a scratch directory created by a TempDirProvider
+type TempDir struct {
+	path    string
+	unmount func() error
+
+	once sync.Once
+	err  error
+}
+
+// Create mounts a new scratch directory. It must be removed with Remove.
+func (p *TempDirProvider) Create(ctx context.Context) (*TempDir, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	path, err := os.MkdirTemp(p.options.Dir, "go-restricted-runner-scratch-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	unmount, err := mountTempDir(ctx, p.options, path)
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, fmt.Errorf("failed to mount scratch directory %s: %w", path, err)
+	}
+	return &TempDir{path: path, unmount: unmount}, nil
+}
+
+// Path returns the path of the scratch directory
+func (d *TempDir) Path() string {
+	return d.path
+}
+
+// Remove unmounts and removes the scratch directory. Removing it again
+// returns the same result.
+func (d *TempDir) Remove() error {
+	d.once.Do(func() {
+		if err := d.unmount(); err != nil {
+			d.err = fmt.Errorf("failed to unmount scratch directory %s: %w", d.path, err)
+			return
+		}
+		if err := os.RemoveAll(d.path); err != nil {
+			d.err = fmt.Errorf("failed to remove scratch directory %s: %w", d.path, err)
+		}
+	})
+	return d.err
+}
+
+// WithTempDirProvider gives the command a scratch directory of the provider,
+// writable in every backend and removed once the execution has completed.
+// The command finds it in the SCRATCH_DIR and TMPDIR environment variables,
+// and the runner options can refer to it with the scratch_dir template
+// parameter.
+func WithTempDirProvider(p *TempDirProvider) ExecOption {
+	return func(c *execConfig) {
+		c.tempDirs = p
+	}
+}
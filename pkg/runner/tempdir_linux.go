@@ -0,0 +1,100 @@
+//go:build linux
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// checkTempDirMode verifies that the tools used by the mode are available
+func checkTempDirMode(mode TempDirMode) error {
+	if mode != TempDirLoop {
+		return nil
+	}
+	for _, tool := range []string{"mkfs.ext4", "mount"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return fmt.Errorf("%s is required for loop scratch directories: %w", tool, err)
+		}
+	}
+	return nil
+}
+
+// mountTempDir mounts a filesystem with the size limit in path, and returns
+// the function unmounting it
+func mountTempDir(ctx context.Context, options TempDirOptions, path string) (func() error, error) {
+	if options.Mode == TempDirLoop {
+		return mountLoopTempDir(ctx, options, path)
+	}
+
+	data := fmt.Sprintf("size=%d,mode=0700,uid=%d,gid=%d", options.SizeLimit, os.Getuid(), os.Getgid())
+	if err := syscall.Mount("tmpfs", path, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, data); err != nil {
+		if errors.Is(err, syscall.EPERM) {
+			return nil, fmt.Errorf("%w (mounting a tmpfs requires CAP_SYS_ADMIN)", err)
+		}
+		return nil, err
+	}
+	return func() error { return unmountTempDir(path) }, nil
+}
+
+// mountLoopTempDir mounts an ext4 image of the size limit, stored next to path
+func mountLoopTempDir(ctx context.Context, options TempDirOptions, path string) (func() error, error) {
+	image := path + ".img"
+	f, err := os.OpenFile(image, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	// the image is sparse, so only the blocks written use the volume
+	err = f.Truncate(options.SizeLimit)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	removeImage := func() { _ = os.Remove(image) }
+	if err != nil {
+		removeImage()
+		return nil, err
+	}
+
+	if output, err := exec.CommandContext(ctx, "mkfs.ext4", "-q", "-F", "-m", "0", image).CombinedOutput(); err != nil {
+		removeImage()
+		return nil, fmt.Errorf("mkfs.ext4 failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	if output, err := exec.CommandContext(ctx, "mount", "-o", "loop,nosuid,nodev", image, path).CombinedOutput(); err != nil {
+		removeImage()
+		return nil, fmt.Errorf("mount failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	unmount := func() error {
+		if err := unmountTempDir(path); err != nil {
+			return err
+		}
+		removeImage()
+		return nil
+	}
+
+	// the root of the new filesystem belongs to root, and contains lost+found
+	_ = os.Remove(filepath.Join(path, "lost+found"))
+	if err := os.Chown(path, os.Getuid(), os.Getgid()); err == nil {
+		err = os.Chmod(path, 0o700)
+	}
+	if err != nil {
+		_ = unmount()
+		return nil, err
+	}
+	return unmount, nil
+}
+
+// unmountTempDir unmounts a scratch directory, detaching it when it is busy
+// (e.g. a process left by the command still has files open)
+func unmountTempDir(path string) error {
+	err := syscall.Unmount(path, 0)
+	if errors.Is(err, syscall.EBUSY) {
+		err = syscall.Unmount(path, syscall.MNT_DETACH)
+	}
+	return err
+}
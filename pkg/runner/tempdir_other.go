@@ -0,0 +1,18 @@
+//go:build !linux
+
+package runner
+
+import (
+	"context"
+	"fmt"
+)
+
+// checkTempDirMode fails, as scratch directories are only supported on Linux
+func checkTempDirMode(mode TempDirMode) error {
+	return fmt.Errorf("scratch directories with a size limit require Linux: %w", ErrNotSupported)
+}
+
+// mountTempDir is only supported on Linux
+func mountTempDir(ctx context.Context, options TempDirOptions, path string) (func() error, error) {
+	return nil, fmt.Errorf("scratch directories with a size limit require Linux: %w", ErrNotSupported)
+}
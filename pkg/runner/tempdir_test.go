@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// newTestTempDirProvider returns a provider of scratch directories, skipping
+// the test where they cannot be mounted
+func newTestTempDirProvider(t *testing.T, mode TempDirMode, size int64) *TempDirProvider {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("scratch directories require Linux")
+	}
+	p, err := NewTempDirProvider(TempDirOptions{Dir: t.TempDir(), SizeLimit: size, Mode: mode})
+	if err != nil {
+		t.Skipf("scratch directories not available: %v", err)
+	}
+	d, err := p.Create(context.Background())
+	if err != nil {
+		t.Skipf("scratch directories cannot be mounted: %v", err)
+	}
+	_ = d.Remove()
+	return p
+}
+
+func TestNewTempDirProvider_validation(t *testing.T) {
+	if _, err := NewTempDirProvider(TempDirOptions{}); err == nil {
+		t.Errorf("Expected an error without a size limit")
+	}
+	if _, err := NewTempDirProvider(TempDirOptions{SizeLimit: 1 << 20, Mode: "zram"}); err == nil {
+		t.Errorf("Expected an error for an unknown mode")
+	}
+	if runtime.GOOS != "linux" {
+		if _, err := NewTempDirProvider(TempDirOptions{SizeLimit: 1 << 20}); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("NewTempDirProvider() = %v, want ErrNotSupported", err)
+		}
+	}
+}
+
+func TestTempDirProvider(t *testing.T) {
+	for _, mode := range []TempDirMode{TempDirTmpfs, TempDirLoop} {
+		t.Run(string(mode), func(t *testing.T) {
+			p := newTestTempDirProvider(t, mode, 4<<20)
+			d, err := p.Create(context.Background())
+			if err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			if entries, err := os.ReadDir(d.Path()); err != nil || len(entries) != 0 {
+				t.Errorf("the scratch directory is not empty: %v, %v", entries, err)
+			}
+
+			// writing beyond the size limit fails
+			err = os.WriteFile(filepath.Join(d.Path(), "big"), bytes.Repeat([]byte("x"), 8<<20), 0o600)
+			if !errors.Is(err, syscall.ENOSPC) {
+				t.Errorf("writing beyond the limit = %v, want ENOSPC", err)
+			}
+
+			if err := d.Remove(); err != nil {
+				t.Fatalf("Remove failed: %v", err)
+			}
+			if _, err := os.Stat(d.Path()); !os.IsNotExist(err) {
+				t.Errorf("Expected the scratch directory to be removed, got %v", err)
+			}
+			if entries, _ := os.ReadDir(filepath.Dir(d.Path())); len(entries) != 0 {
+				t.Errorf("files left in the volume: %v", entries)
+			}
+		})
+	}
+}
+
+// TestStart_TempDirProvider tests that executions get their own scratch directory
+func TestStart_TempDirProvider(t *testing.T) {
+	p := newTestTempDirProvider(t, TempDirTmpfs, 1<<20)
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+
+	e, err := Start(context.Background(), r, "sh", []string{"-c", `echo "$TMPDIR"; test "$TMPDIR" = "$SCRATCH_DIR" && touch "$TMPDIR/x"`},
+		nil, nil, WithTempDirProvider(p))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	_ = e.Stdin.Close()
+	output, _ := io.ReadAll(e.Stdout)
+	_, _ = io.ReadAll(e.Stderr)
+	if err := e.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	dir := strings.TrimSpace(string(output))
+	if dir == "" {
+		t.Fatalf("TMPDIR is not set")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected the scratch directory %s to be removed, got %v", dir, err)
+	}
+}
@@ -0,0 +1,143 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// ErrTimeout is returned (wrapped) when a command is terminated after the
+// timeout of its runner
+var ErrTimeout = errors.New("command timed out")
+
+// TimeoutOptions bound the time the commands of a runner can run. They are
+// understood by all the runners.
+type TimeoutOptions struct {
+	// Timeout is the maximum time a command can run (e.g. "30s"): it is then
+	// asked to terminate (SIGTERM), and killed after KillGracePeriod. There
+	// is no timeout by default.
+	Timeout string `json:"timeout"`
+
+	// KillGracePeriod is the time a command has to exit after being asked to
+	// terminate, by the timeout or by cancelling its context, before it is
+	// killed (e.g. "10s", defaults to 5s)
+	KillGracePeriod string `json:"kill_grace_period"`
+}
+
+// parseTimeoutDuration parses a positive duration of the timeout options
+func parseTimeoutDuration(name string, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive duration like \"30s\"", name, value)
+	}
+	return d, nil
+}
+
+// validateTimeouts checks the durations of the timeout options
+func (o TimeoutOptions) validateTimeouts() error {
+	if _, err := parseTimeoutDuration("timeout", o.Timeout); err != nil {
+		return err
+	}
+	_, err := parseTimeoutDuration("kill_grace_period", o.KillGracePeriod)
+	return err
+}
+
+// timeout returns the timeout of the commands, or 0 for none
+func (o TimeoutOptions) timeout() time.Duration {
+	d, _ := parseTimeoutDuration("timeout", o.Timeout)
+	return d
+}
+
+// killGracePeriod returns the grace period of the commands, or 0 for the default
+func (o TimeoutOptions) killGracePeriod() time.Duration {
+	d, _ := parseTimeoutDuration("kill_grace_period", o.KillGracePeriod)
+	return d
+}
+
+// timeoutContext returns the context the commands run with: with the kill
+// grace period of the options, and cancelled (with ErrTimeout as its cause)
+// once the timeout has elapsed
+func (o TimeoutOptions) timeoutContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if grace := o.killGracePeriod(); grace > 0 {
+		ctx = withKillGracePeriod(ctx, grace)
+	}
+	timeout := o.timeout()
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w after %v", ErrTimeout, timeout))
+}
+
+// timeoutError returns the error of a command run with a context of
+// timeoutContext, wrapping ErrTimeout when the command was terminated by
+// the timeout
+func timeoutError(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, ErrTimeout) {
+		return err
+	}
+	if cause := context.Cause(ctx); errors.Is(cause, ErrTimeout) {
+		return fmt.Errorf("%w: %w", cause, err)
+	}
+	return err
+}
+
+// runWithTimeout runs a command with run, bounded by the timeout options
+func (o TimeoutOptions) runWithTimeout(ctx context.Context, run func(ctx context.Context) (string, error)) (string, error) {
+	ctx, cancel := o.timeoutContext(ctx)
+	defer cancel()
+	output, err := run(ctx)
+	return output, timeoutError(ctx, err)
+}
+
+// startWithTimeout starts a command with start, bounded by the timeout
+// options until it has been waited for
+func (o TimeoutOptions) startWithTimeout(ctx context.Context, start func(ctx context.Context) (*Execution, error)) (*Execution, error) {
+	ctx, cancel := o.timeoutContext(ctx)
+	e, err := start(ctx)
+	if err != nil {
+		cancel()
+		return nil, timeoutError(ctx, err)
+	}
+	wait := e.wait
+	e.wait = func() error {
+		err := timeoutError(ctx, wait())
+		cancel()
+		return err
+	}
+	return e, nil
+}
+
+// killGracePeriodKey is the context key of the kill grace period of the commands
+type killGracePeriodKey struct{}
+
+// withKillGracePeriod returns a context where the commands have the given
+// time to exit after being asked to terminate (see commandContext)
+func withKillGracePeriod(ctx context.Context, grace time.Duration) context.Context {
+	return context.WithValue(ctx, killGracePeriodKey{}, grace)
+}
+
+// killGracePeriodFrom returns the time the commands have to exit after
+// being asked to terminate (commandWaitDelay by default)
+func killGracePeriodFrom(ctx context.Context) time.Duration {
+	if grace, ok := ctx.Value(killGracePeriodKey{}).(time.Duration); ok && grace > 0 {
+		return grace
+	}
+	return commandWaitDelay
+}
+
+// stopContainer stops a container with the engine given, asking its init
+// process to terminate and killing it after the grace period
+func stopContainer(engine string, container string, grace time.Duration) error {
+	seconds := strconv.Itoa(int(math.Ceil(grace.Seconds())))
+	if output, err := exec.Command(engine, "stop", "-t", seconds, container).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s stop failed: %w: %s", engine, err, string(output))
+	}
+	return nil
+}
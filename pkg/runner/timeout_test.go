@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeoutOptions_Validate(t *testing.T) {
+	for _, options := range []Options{
+		{"timeout": "forever"},
+		{"timeout": "-1s"},
+		{"kill_grace_period": "0s"},
+	} {
+		if _, err := NewExec(options, nil); err == nil {
+			t.Errorf("NewExec(%v) should fail", options)
+		}
+		if _, err := NewDocker(Options{"image": "alpine:latest", "timeout": options["timeout"], "kill_grace_period": options["kill_grace_period"]}, nil); err == nil {
+			t.Errorf("NewDocker(%v) should fail", options)
+		}
+	}
+
+	r, err := NewExec(Options{"timeout": "30s", "kill_grace_period": "2s"}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	if r.options.timeout() != 30*time.Second || r.options.killGracePeriod() != 2*time.Second {
+		t.Errorf("timeouts = %v, %v", r.options.timeout(), r.options.killGracePeriod())
+	}
+
+	ctx, cancel := r.options.timeoutContext(context.Background())
+	defer cancel()
+	if grace := killGracePeriodFrom(ctx); grace != 2*time.Second {
+		t.Errorf("killGracePeriodFrom() = %v, want 2s", grace)
+	}
+	if grace := killGracePeriodFrom(context.Background()); grace != commandWaitDelay {
+		t.Errorf("killGracePeriodFrom() = %v, want the default %v", grace, commandWaitDelay)
+	}
+}
+
+func TestExec_Timeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test uses a POSIX shell")
+	}
+	r, err := NewExec(Options{"timeout": "200ms", "kill_grace_period": "300ms"}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	ctx := context.Background()
+
+	if output, err := r.Run(ctx, "sh", "echo fast", nil, nil, false); err != nil || output != "fast" {
+		t.Errorf("Run() of a fast command = %q, %v", output, err)
+	}
+
+	start := time.Now()
+	_, err = r.Run(ctx, "sh", "sleep 10", nil, nil, false)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Run() = %v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("the command was terminated after %v", elapsed)
+	}
+
+	if _, err := r.RunEx(ctx, RunRequest{Shell: "sh", Command: "sleep 10"}); !errors.Is(err, ErrTimeout) {
+		t.Errorf("RunEx() = %v, want ErrTimeout", err)
+	}
+}
+
+func TestExec_TimeoutKillGracePeriod(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test uses signals")
+	}
+	r, err := NewExec(Options{"timeout": "200ms", "kill_grace_period": "500ms"}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+
+	// the command is asked to terminate, and can clean up in the grace period
+	e, err := Start(context.Background(), r, "sh", []string{"-c", "trap 'echo terminated; exit 0' TERM; while true; do sleep 0.1; done"}, nil, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	output, _ := io.ReadAll(e.Stdout)
+	_ = e.Wait()
+	if !strings.Contains(string(output), "terminated") {
+		t.Errorf("the command was not asked to terminate: %q", output)
+	}
+
+	// commands ignoring the request are killed after the grace period
+	start := time.Now()
+	e, err = Start(context.Background(), r, "sh", []string{"-c", "trap '' TERM; exec sleep 10"}, nil, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	_, _ = io.ReadAll(e.Stdout)
+	if err := e.Wait(); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Wait() = %v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed < 700*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("the command was killed after %v, want the timeout plus the grace period", elapsed)
+	}
+}
@@ -0,0 +1,224 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// TranscriptOptions configures the recording of a transcript of an execution
+// in the asciicast v2 format, so it can be replayed with `asciinema play`.
+type TranscriptOptions struct {
+	// Path is the file the transcript is written to. When neither Path nor
+	// Writer are set, the transcript is kept in memory and returned by
+	// Execution.Transcript.
+	Path string
+
+	// Writer is where the transcript is written to
+	Writer io.Writer
+
+	// Width and Height are the terminal size recorded in the header
+	// (80x24 by default)
+	Width  int
+	Height int
+
+	// Title is the title recorded in the header
+	Title string
+
+	// RecordInput records what is written to the standard input of the
+	// command too (as "i" events)
+	RecordInput bool
+}
+
+// WithTranscript records the output of the execution (stdout and stderr, as
+// read by the caller) with its timing, for the audit and debugging of
+// interactive sessions.
+func WithTranscript(opts TranscriptOptions) ExecOption {
+	return func(c *execConfig) {
+		c.transcript = &opts
+	}
+}
+
+// Transcript returns the transcript of the execution when it was recorded in
+// memory, and the error found while recording it, if any. It must be called
+// after Wait.
+func (e *Execution) Transcript() ([]byte, error) {
+	return e.transcript, e.transcriptErr
+}
+
+// asciicastHeader is the first line of an asciicast v2 file
+type asciicastHeader struct {
+	Version   int    `json:"version"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Timestamp int64  `json:"timestamp"`
+	Title     string `json:"title,omitempty"`
+}
+
+// transcriptRecorder writes the events of an execution in asciicast format
+type transcriptRecorder struct {
+	opts  *TranscriptOptions
+	start time.Time
+
+	mu     sync.Mutex
+	w      io.Writer
+	file   *os.File
+	buf    *bytes.Buffer
+	err    error
+	closed bool
+
+	// pending holds the incomplete UTF-8 sequence at the end of the last
+	// chunk of each event type
+	pending map[string][]byte
+}
+
+// newTranscriptRecorder opens the destination of the transcript and writes its header
+func newTranscriptRecorder(opts *TranscriptOptions) (*transcriptRecorder, error) {
+	rec := &transcriptRecorder{
+		opts:    opts,
+		start:   time.Now(),
+		pending: map[string][]byte{},
+	}
+
+	switch {
+	case opts.Path != "":
+		f, err := os.OpenFile(opts.Path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create transcript: %w", err)
+		}
+		rec.file = f
+		rec.w = f
+	case opts.Writer != nil:
+		rec.w = opts.Writer
+	default:
+		rec.buf = &bytes.Buffer{}
+		rec.w = rec.buf
+	}
+
+	header := asciicastHeader{
+		Version:   2,
+		Width:     opts.Width,
+		Height:    opts.Height,
+		Timestamp: rec.start.Unix(),
+		Title:     opts.Title,
+	}
+	if header.Width <= 0 {
+		header.Width = 80
+	}
+	if header.Height <= 0 {
+		header.Height = 24
+	}
+	rec.writeLine(header)
+	if rec.err != nil {
+		rec.close()
+		return nil, fmt.Errorf("failed to write transcript: %w", rec.err)
+	}
+	return rec, nil
+}
+
+// record adds an event ("o" for output, "i" for input) with the given data
+func (rec *transcriptRecorder) record(kind string, data []byte) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.closed || rec.err != nil {
+		return
+	}
+
+	// events are JSON strings, so multi-byte characters split between reads
+	// are kept until they are complete
+	data = append(rec.pending[kind], data...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	rec.pending[kind] = append([]byte(nil), data[cut:]...)
+	if cut == 0 {
+		return
+	}
+
+	elapsed := time.Since(rec.start).Seconds()
+	rec.writeLine([]interface{}{elapsed, kind, string(data[:cut])})
+}
+
+// writeLine writes a JSON line, recording the first error
+func (rec *transcriptRecorder) writeLine(v interface{}) {
+	line, err := json.Marshal(v)
+	if err == nil {
+		_, err = rec.w.Write(append(line, '\n'))
+	}
+	if err != nil && rec.err == nil {
+		rec.err = err
+	}
+}
+
+// close stops recording and returns the transcript kept in memory, if any
+func (rec *transcriptRecorder) close() ([]byte, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.closed {
+		return nil, rec.err
+	}
+	rec.closed = true
+
+	if rec.file != nil {
+		if err := rec.file.Close(); err != nil && rec.err == nil {
+			rec.err = err
+		}
+	}
+	if rec.buf != nil {
+		return rec.buf.Bytes(), rec.err
+	}
+	return nil, rec.err
+}
+
+// attach records the pipes of the execution and stops recording once it has completed
+func (rec *transcriptRecorder) attach(e *Execution) {
+	e.Stdout = &transcriptReader{ReadCloser: e.Stdout, rec: rec}
+	e.Stderr = &transcriptReader{ReadCloser: e.Stderr, rec: rec}
+	if rec.opts.RecordInput {
+		e.Stdin = &transcriptWriter{WriteCloser: e.Stdin, rec: rec}
+	}
+	e.exitHooks = append(e.exitHooks, func() {
+		e.transcript, e.transcriptErr = rec.close()
+	})
+}
+
+// transcriptReader records the output read from a pipe
+type transcriptReader struct {
+	io.ReadCloser
+	rec *transcriptRecorder
+}
+
+func (r *transcriptReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.rec.record("o", p[:n])
+	}
+	return n, err
+}
+
+// transcriptWriter records the input written to a pipe
+type transcriptWriter struct {
+	io.WriteCloser
+	rec *transcriptRecorder
+}
+
+func (w *transcriptWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	if n > 0 {
+		w.rec.record("i", p[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,149 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// parseTranscript returns the header and the events of an asciicast transcript
+func parseTranscript(t *testing.T, data []byte) (asciicastHeader, [][]interface{}) {
+	t.Helper()
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	if !scanner.Scan() {
+		t.Fatal("empty transcript")
+	}
+	var header asciicastHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		t.Fatalf("invalid header %q: %v", scanner.Text(), err)
+	}
+
+	var events [][]interface{}
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", scanner.Text(), err)
+		}
+		if len(event) != 3 {
+			t.Fatalf("invalid event %q", scanner.Text())
+		}
+		events = append(events, event)
+	}
+	return header, events
+}
+
+// eventsData concatenates the data of the events of the given kind
+func eventsData(events [][]interface{}, kind string) string {
+	var sb strings.Builder
+	for _, event := range events {
+		if event[1] == kind {
+			sb.WriteString(event[2].(string))
+		}
+	}
+	return sb.String()
+}
+
+func TestTranscript_InMemory(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := Start(context.Background(), r, "cat", nil, nil, nil,
+		WithTranscript(TranscriptOptions{Title: "test", RecordInput: true}))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	go func() {
+		_, _ = io.WriteString(e.Stdin, "héllo\n")
+		_ = e.Stdin.Close()
+	}()
+	_, _ = io.ReadAll(e.Stdout)
+	_, _ = io.ReadAll(e.Stderr)
+	if err := e.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	data, err := e.Transcript()
+	if err != nil {
+		t.Fatalf("Transcript failed: %v", err)
+	}
+	header, events := parseTranscript(t, data)
+	if header.Version != 2 || header.Width != 80 || header.Height != 24 || header.Title != "test" {
+		t.Errorf("unexpected header: %+v", header)
+	}
+	if got := eventsData(events, "i"); got != "héllo\n" {
+		t.Errorf("expected input to be recorded, got %q", got)
+	}
+	if got := eventsData(events, "o"); got != "héllo\n" {
+		t.Errorf("expected output to be recorded, got %q", got)
+	}
+}
+
+func TestTranscript_Path(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "session.cast")
+
+	e, err := Start(context.Background(), r, "sh", []string{"-c", "echo out; echo err >&2"}, nil, nil,
+		WithTranscript(TranscriptOptions{Path: path, Width: 120, Height: 40}))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	_, _ = io.ReadAll(e.Stdout)
+	_, _ = io.ReadAll(e.Stderr)
+	if err := e.Wait(); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	if data, err := e.Transcript(); err != nil || data != nil {
+		t.Errorf("expected no in-memory transcript, got %q, %v", data, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, events := parseTranscript(t, data)
+	if header.Width != 120 || header.Height != 40 {
+		t.Errorf("unexpected header: %+v", header)
+	}
+	output := eventsData(events, "o")
+	if !strings.Contains(output, "out\n") || !strings.Contains(output, "err\n") {
+		t.Errorf("expected stdout and stderr to be recorded, got %q", output)
+	}
+}
+
+func TestTranscriptRecorder_SplitRunes(t *testing.T) {
+	rec, err := newTranscriptRecorder(&TranscriptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "é" is split between two reads
+	data := []byte("aé")
+	rec.record("o", data[:2])
+	rec.record("o", data[2:])
+
+	transcript, err := rec.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, events := parseTranscript(t, transcript)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %v", events)
+	}
+	if got := eventsData(events, "o"); got != "aé" {
+		t.Errorf("expected %q, got %q", "aé", got)
+	}
+}
@@ -0,0 +1,363 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// Unshare implements the Runner interface using the unshare tool of
+// util-linux on Linux.
+//
+// The commands run in new user, mount, PID, IPC, UTS and cgroup namespaces,
+// with a private /proc, and optionally chrooted into another root
+// filesystem. Unless networking is allowed, they also run in a new network
+// namespace where only the loopback interface is up. This provides a
+// minimal isolation on servers where only util-linux is available (no
+// firejail, bwrap or Docker), but the filesystem of the host is not
+// restricted: use a root filesystem to hide it.
+type Unshare struct {
+	logger  Logger
+	options UnshareOptions
+}
+
+// UnshareOptions is the options for the Unshare runner
+type UnshareOptions struct {
+	// UnsharePath is the unshare executable to use (defaults to "unshare" in PATH)
+	UnsharePath string `json:"unshare_path"`
+
+	// RootFS is the directory the commands are chrooted into (none by default)
+	RootFS string `json:"rootfs"`
+
+	// WorkDir is the working directory of the commands (inside RootFS, if any)
+	WorkDir string `json:"workdir"`
+
+	// Shell is the shell running the commands (defaults to "/bin/sh")
+	Shell string `json:"shell"`
+
+	// AllowNetworking keeps the network namespace of the host
+	AllowNetworking bool `json:"allow_networking"`
+
+	// MapRootUser makes the commands run as root in their user namespace
+	// (otherwise they run as the current user)
+	MapRootUser bool `json:"map_root_user"`
+
+	// Umask and file mode policy
+	FileModeOptions
+
+	// Core dump policy
+	CoreDumpOptions
+
+	// Clock and locale settings
+	DeterminismOptions
+
+	// Variables of the commands read from .env files
+	EnvFileOptions
+
+	// Experimental features
+	ExperimentalOptions
+
+	// Command run in the sandbox before the commands
+	PreflightOptions
+
+	// Timeout of the commands
+	TimeoutOptions
+
+	// Limits of the output of the commands
+	OutputLimitOptions
+}
+
+// NewUnshareOptions creates a new UnshareOptions from Options
+func NewUnshareOptions(options Options) (UnshareOptions, error) {
+	var opts UnshareOptions
+	jsonStr, err := options.ToJSON()
+	if err != nil {
+		return UnshareOptions{}, err
+	}
+	err = json.Unmarshal([]byte(jsonStr), &opts)
+	return opts, err
+}
+
+// NewUnshare creates a new Unshare runner with the provided logger.
+// If logger is nil, a default logger is created.
+func NewUnshare(options Options, logger Logger) (*Unshare, error) {
+	logger = defaultLogger(logger)
+
+	unshareOpts, err := NewUnshareOptions(options)
+	if err != nil {
+		logger.Debug("Failed to parse unshare options: %v", err)
+		return nil, fmt.Errorf("failed to parse unshare options: %w", err)
+	}
+	if err := unshareOpts.validate(); err != nil {
+		return nil, fmt.Errorf("invalid unshare options: %w", err)
+	}
+	if err := unshareOpts.validateTimeouts(); err != nil {
+		return nil, fmt.Errorf("invalid unshare options: %w", err)
+	}
+	if err := unshareOpts.loadEnvFiles(); err != nil {
+		return nil, fmt.Errorf("invalid unshare options: %w", err)
+	}
+	if err := unshareOpts.validateCoreDumps(); err != nil {
+		return nil, fmt.Errorf("invalid unshare options: %w", err)
+	}
+	if err := unshareOpts.validateOutputLimits(); err != nil {
+		return nil, fmt.Errorf("invalid unshare options: %w", err)
+	}
+	if err := unshareOpts.validatePreflight(); err != nil {
+		return nil, fmt.Errorf("invalid unshare options: %w", err)
+	}
+
+	return &Unshare{
+		logger:  logger,
+		options: unshareOpts,
+	}, nil
+}
+
+// unsharePath returns the unshare executable to use
+func (r *Unshare) unsharePath() string {
+	if r.options.UnsharePath != "" {
+		return r.options.UnsharePath
+	}
+	return "unshare"
+}
+
+// unshareArgs builds the unshare arguments creating the namespaces of the
+// commands. Template variables in the root filesystem and the working
+// directory are replaced with the given params.
+//
+// The network namespace is not created by unshare, as the loopback
+// interface of a new namespace is down (see isolateLoopback).
+func (r *Unshare) unshareArgs(params map[string]interface{}) []string {
+	args := []string{"--user"}
+	if r.options.MapRootUser {
+		args = append(args, "--map-root-user")
+	} else {
+		args = append(args, "--map-current-user")
+	}
+	args = append(args, "--mount", "--uts", "--ipc", "--cgroup", "--pid", "--fork", "--kill-child", "--mount-proc")
+
+	if r.options.RootFS != "" {
+		rootFS := common.ProcessTemplateListFlexible([]string{r.options.RootFS}, params)[0]
+		args = append(args, "--root="+rootFS)
+	}
+	if r.options.WorkDir != "" {
+		workDir := common.ProcessTemplateListFlexible([]string{r.options.WorkDir}, params)[0]
+		args = append(args, "--wd="+workDir)
+	}
+	return append(args, "--")
+}
+
+// shell returns the shell running the commands
+func (r *Unshare) shell(shell string) string {
+	if shell != "" {
+		return shell
+	}
+	if r.options.Shell != "" {
+		return r.options.Shell
+	}
+	// the host $SHELL may not exist in the root filesystem
+	return "/bin/sh"
+}
+
+// isolateNetwork runs execCmd in a new network namespace unless networking
+// is allowed (and not disabled for this execution)
+func (r *Unshare) isolateNetwork(ctx context.Context, logger Logger, execCmd *exec.Cmd) error {
+	if r.options.AllowNetworking && !loopbackNetworkFrom(ctx) {
+		return nil
+	}
+	logger.Debug("Isolating the network of the command")
+	return isolateLoopback(execCmd)
+}
+
+// Run executes a command in the namespaces and returns the output.
+// It implements the Runner interface.
+//
+// note: tmpfile is ignored, as the host temporary directory is not visible
+// in the root filesystem
+func (r *Unshare) Run(ctx context.Context, shell string, command string,
+	env []string, params map[string]interface{}, tmpfile bool,
+) (string, error) {
+	return r.options.runWithTimeout(ctx, func(ctx context.Context) (string, error) {
+		return r.run(ctx, shell, command, env, params)
+	})
+}
+
+// run is Run, with the context bounded by the timeout options
+func (r *Unshare) run(ctx context.Context, shell string, command string,
+	env []string, params map[string]interface{},
+) (string, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+
+	// Check if context is done
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	default:
+		// Continue execution
+	}
+
+	if err := r.options.runPreflight(ctx, logger, r.shell(""), r.start, params); err != nil {
+		return "", err
+	}
+
+	args := r.unshareArgs(params)
+	args = append(args, r.shell(shell), "-c", command)
+
+	execCmd := commandContext(ctx, r.unsharePath(), args...)
+	logger.Debug("Created command: %s", execCmd.String())
+
+	// Set environment variables if provided
+	if len(env) > 0 {
+		logger.Debug("Adding %d environment variables to command", len(env))
+		for _, e := range env {
+			categoryLogger(logger, LogCategoryEnvironment).Debug("... adding environment variable: %s", e)
+		}
+		execCmd.Env = append(os.Environ(), env...)
+	}
+
+	applyUmask(logger, execCmd, r.options.Umask)
+	defer r.options.applyCoreDumps(logger, execCmd)()
+
+	if err := r.isolateNetwork(ctx, logger, execCmd); err != nil {
+		return "", err
+	}
+
+	// Capture output
+	var stdout, stderr bytes.Buffer
+	execCmd.Stdout, execCmd.Stderr = captureRunOutput(ctx, &stdout, &stderr)
+	var truncated func() error
+	execCmd.Stdout, execCmd.Stderr, truncated = r.options.limitOutput(ctx, execCmd.Stdout, execCmd.Stderr)
+
+	// Run the command
+	logger.Debug("Executing command")
+
+	if err := execCmd.Run(); err != nil {
+		// If there's error output, include it in the error
+		if stderr.Len() > 0 {
+			errMsg := strings.TrimSpace(stderr.String())
+			logger.Debug("Command failed with stderr: %s", errMsg)
+			return "", newCommandError(errMsg, err)
+		}
+		logger.Debug("Command failed with error: %v", err)
+		return "", err
+	}
+
+	output := strings.TrimSpace(stdout.String())
+	logger.Debug("Command executed successfully, output length: %d bytes", len(output))
+	if stderr.Len() > 0 {
+		logger.Debug("Command generated stderr (but no error): %s", strings.TrimSpace(stderr.String()))
+	}
+	return output, truncated()
+}
+
+// RunEx executes a command in the namespaces like Run, returning its exit
+// code and both output streams. It implements the Runner interface.
+func (r *Unshare) RunEx(ctx context.Context, req RunRequest) (*RunResult, error) {
+	return runEx(ctx, r, req)
+}
+
+// RunWithPipes executes a command in the namespaces with access to its
+// stdin/stdout/stderr pipes. It implements the Runner interface.
+//
+// The command is resolved inside the root filesystem, if any.
+func (r *Unshare) RunWithPipes(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (
+	stdin io.WriteCloser,
+	stdout io.ReadCloser,
+	stderr io.ReadCloser,
+	wait func() error,
+	err error,
+) {
+	e, err := r.start(ctx, cmd, args, env, params)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return e.Stdin, e.Stdout, e.Stderr, e.Wait, nil
+}
+
+// start executes a command in the namespaces and returns an execution
+// handle for it. It is used by RunWithPipes and Start.
+func (r *Unshare) start(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	return r.options.startWithTimeout(ctx, func(ctx context.Context) (*Execution, error) {
+		return r.startCommand(ctx, cmd, args, env, params)
+	})
+}
+
+// startCommand is start, with the context bounded by the timeout options
+func (r *Unshare) startCommand(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	logger := contextLogger(ctx, r.logger)
+	env = r.options.withEnvFiles(env)
+	env = r.options.pinEnv(env)
+
+	// Check if context is already done
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+		// Continue execution
+	}
+
+	if err := r.options.runPreflight(ctx, logger, r.shell(""), r.start, params); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("RunWithPipes: executing command in namespaces: %s with args: %v", cmd, args)
+
+	unshareArgs := r.unshareArgs(params)
+	unshareArgs = append(unshareArgs, cmd)
+	unshareArgs = append(unshareArgs, args...)
+
+	execCmd := commandContext(ctx, r.unsharePath(), unshareArgs...)
+
+	// Set environment variables if provided
+	if len(env) > 0 {
+		logger.Debug("Adding %d environment variables to command", len(env))
+		execCmd.Env = append(os.Environ(), env...)
+	}
+	execCmd.ExtraFiles = extraFilesFrom(ctx)
+
+	applyUmask(logger, execCmd, r.options.Umask)
+	collectCores := r.options.applyCoreDumps(logger, execCmd)
+
+	if err := r.isolateNetwork(ctx, logger, execCmd); err != nil {
+		collectCores()
+		return nil, err
+	}
+
+	return startProcess(logger, execCmd, collectCores)
+}
+
+// CheckImplicitRequirements checks if the runner meets its implicit requirements.
+// Unshare runner requires Linux, the unshare executable and an existing root
+// filesystem, when one is used.
+func (r *Unshare) CheckImplicitRequirements() error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("unshare runner requires Linux")
+	}
+
+	if !common.CheckExecutableExists(r.unsharePath()) {
+		return fmt.Errorf("unshare executable not found in PATH")
+	}
+
+	// The root filesystem can only be checked when it does not depend on template params
+	if r.options.RootFS != "" && !strings.Contains(r.options.RootFS, "{{") {
+		info, err := os.Stat(r.options.RootFS)
+		if err != nil {
+			return fmt.Errorf("unshare root filesystem not available: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("unshare root filesystem %s is not a directory", r.options.RootFS)
+		}
+	}
+
+	return nil
+}
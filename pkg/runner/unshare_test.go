@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUnshare_unshareArgs(t *testing.T) {
+	r, err := NewUnshare(Options{"rootfs": "/srv/{{.distro}}", "workdir": "/work"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := strings.Join(r.unshareArgs(map[string]interface{}{"distro": "alpine"}), " ")
+	for _, want := range []string{"--user --map-current-user", "--pid --fork --kill-child --mount-proc", "--root=/srv/alpine", "--wd=/work --"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("unshareArgs() = %q, want %q", args, want)
+		}
+	}
+
+	r.options.MapRootUser = true
+	if args := r.unshareArgs(nil); args[1] != "--map-root-user" {
+		t.Errorf("unshareArgs() = %q, want the root user mapped", args)
+	}
+}
+
+// newTestUnshare creates an Unshare runner, skipping the test when the
+// namespaces cannot be created in this host
+func newTestUnshare(t *testing.T, options Options) *Unshare {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("unshare runner requires Linux")
+	}
+	if _, err := exec.LookPath("unshare"); err != nil {
+		t.Skip("unshare not found")
+	}
+	r, err := NewUnshare(options, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Run(context.Background(), "", "true", nil, nil, false); err != nil {
+		t.Skipf("namespaces not available: %v", err)
+	}
+	return r
+}
+
+func TestUnshare_Run(t *testing.T) {
+	r := newTestUnshare(t, Options{})
+
+	output, err := r.Run(context.Background(), "", `echo "$$ $(grep -c : /proc/net/dev) $FOO"`, []string{"FOO=bar"}, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "1 1 bar" {
+		t.Errorf("Run() = %q, want the command as PID 1 with only the loopback interface", output)
+	}
+
+	interfaces, err := exec.Command("grep", "-c", ":", "/proc/net/dev").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = newTestUnshare(t, Options{"allow_networking": true})
+	output, err = r.Run(context.Background(), "", "grep -c : /proc/net/dev", nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host := strings.TrimSpace(string(interfaces)); output != host {
+		t.Errorf("Run() = %q interfaces, want the %s of the host", output, host)
+	}
+}
+
+func TestUnshare_RunWithPipes(t *testing.T) {
+	r := newTestUnshare(t, Options{})
+
+	stdin, stdout, _, wait, err := r.RunWithPipes(context.Background(), "cat", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stdin.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	_ = stdin.Close()
+	buf := make([]byte, 16)
+	n, _ := stdout.Read(buf)
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("output = %q", buf[:n])
+	}
+}
+
+func TestUnshare_CheckImplicitRequirements(t *testing.T) {
+	r, err := NewUnshare(Options{"rootfs": "/nonexistent/rootfs", "unshare_path": "true"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CheckImplicitRequirements(); err == nil {
+		t.Error("CheckImplicitRequirements() should fail without the root filesystem")
+	}
+}
@@ -8,8 +8,8 @@ import (
 )
 
 // isSingleExecutableCommand checks if the command string is a single word (no spaces or shell metacharacters)
-// and if that word is an existing executable (absolute/relative path or in PATH).
-func isSingleExecutableCommand(command string) bool {
+// and if that word is an existing executable (absolute/relative path, or in PATH or extraPaths).
+func isSingleExecutableCommand(command string, extraPaths ...string) bool {
 	cmd := strings.TrimSpace(command)
 	if cmd == "" {
 		return false
@@ -28,7 +28,7 @@ func isSingleExecutableCommand(command string) bool {
 		return !info.IsDir() && mode&0111 != 0 // executable by someone
 	}
 	// Otherwise, check if it's in PATH
-	return common.CheckExecutableExists(cmd)
+	return common.CheckExecutableExists(cmd, extraPaths...)
 }
 
 // contains checks if a string slice contains a specific string
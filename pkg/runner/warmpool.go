@@ -0,0 +1,440 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultWarmPoolGCInterval is the interval of the garbage collection of the
+// warm pools when the options do not set it
+const defaultWarmPoolGCInterval = 30 * time.Second
+
+// WarmPoolOptions is the options for a WarmPool
+type WarmPoolOptions struct {
+	// Size is the number of sandboxes of the pool, idle or running a
+	// command (defaults to 1). Commands started while all of them are in use
+	// run in a sandbox created for them, destroyed after them.
+	Size int
+
+	// TTL is the maximum age of a sandbox, after which it is destroyed
+	// instead of being reused (0 for no limit)
+	TTL time.Duration
+
+	// MaxIdle is the maximum time a sandbox waits in the pool for a
+	// command, after which it is destroyed (0 for no limit)
+	MaxIdle time.Duration
+
+	// MaxReuses is the number of commands run in a sandbox, after which it
+	// is destroyed (0 for no limit, 1 for a fresh sandbox per command)
+	MaxReuses int
+
+	// GCInterval is the interval between the collections of the expired
+	// sandboxes, and the refills of the pool (defaults to 30 seconds)
+	GCInterval time.Duration
+}
+
+// WarmPoolStats are the counters of a WarmPool
+type WarmPoolStats struct {
+	// Hits and Misses are the commands started in an idle sandbox of the
+	// pool, and in a sandbox created for them
+	Hits   int `json:"hits"`
+	Misses int `json:"misses"`
+
+	// Created is the number of sandboxes created, and Failed the number
+	// of sandboxes that could not be created
+	Created int `json:"created"`
+	Failed  int `json:"failed"`
+
+	// Expired, Evicted and Retired are the sandboxes destroyed for their
+	// TTL, their idle time and their number of reuses, and Discarded those
+	// destroyed after their command because the pool was full
+	Expired   int `json:"expired"`
+	Evicted   int `json:"evicted"`
+	Retired   int `json:"retired"`
+	Discarded int `json:"discarded"`
+
+	// Idle and InUse are the sandboxes in the pool and running commands
+	Idle  int `json:"idle"`
+	InUse int `json:"in_use"`
+}
+
+// HitRate returns the ratio of the commands started in an idle sandbox
+func (s WarmPoolStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// WarmPool keeps sandboxes of a runner (e.g. Docker containers) created in
+// advance, so commands start without waiting for a new one. Every command
+// runs alone in a sandbox, which goes back to the pool once the command has
+// been waited for.
+//
+// As the sandboxes keep the changes of the commands run in them, and drift
+// from their image while they live, they are destroyed once they reach
+// their TTL, their maximum idle time or their maximum number of reuses. A
+// background garbage collection destroys the expired sandboxes and refills
+// the pool.
+//
+// A WarmPool is a Runner, so Start, Connect, pools... can start commands in
+// it. The params of the pool are used, and those of the commands are
+// ignored.
+type WarmPool struct {
+	runner  Runner
+	opener  sessionOpener
+	params  map[string]interface{}
+	options WarmPoolOptions
+	logger  Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	// fills are the sandboxes being created by fill
+	fills sync.WaitGroup
+
+	mu      sync.Mutex
+	idle    []*warmSandbox
+	filling int
+	closed  bool
+	stats   WarmPoolStats
+}
+
+// warmSandbox is a sandbox of a WarmPool
+type warmSandbox struct {
+	backend   sessionBackend
+	created   time.Time
+	idleSince time.Time
+	uses      int
+}
+
+// NewWarmPool creates a pool of sandboxes of a runner, with the template
+// variables of params applied, and starts filling it. ErrNotSupported is
+// returned for runners without sessions (see OpenSession).
+// If logger is nil, a default logger is created.
+func NewWarmPool(r Runner, params map[string]interface{}, options WarmPoolOptions, logger Logger) (*WarmPool, error) {
+	logger = defaultLogger(logger)
+	opener, ok := r.(sessionOpener)
+	if !ok {
+		return nil, fmt.Errorf("warm pools: %w", ErrNotSupported)
+	}
+	if options.Size < 0 || options.TTL < 0 || options.MaxIdle < 0 || options.MaxReuses < 0 || options.GCInterval < 0 {
+		return nil, fmt.Errorf("invalid warm pool options: limits must be positive")
+	}
+	if options.Size == 0 {
+		options.Size = 1
+	}
+	if options.GCInterval == 0 {
+		options.GCInterval = defaultWarmPoolGCInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &WarmPool{
+		runner:  r,
+		opener:  opener,
+		params:  params,
+		options: options,
+		logger:  logger,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	p.fill()
+	go p.collect()
+	return p, nil
+}
+
+// Run executes a command in a sandbox of the pool and returns its output.
+// It implements the Runner interface.
+func (p *WarmPool) Run(ctx context.Context, shell string, command string, env []string, params map[string]interface{}, tmpfile bool) (string, error) {
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	e, err := p.start(ctx, shell, []string{"-c", command}, env, params)
+	if err != nil {
+		return "", err
+	}
+	_ = e.Stdin.Close()
+	var stdout, stderr bytes.Buffer
+	stdoutWriter, stderrWriter := captureRunOutput(ctx, &stdout, &stderr)
+	stderrDone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(stderrWriter, e.Stderr)
+		close(stderrDone)
+	}()
+	_, readErr := io.Copy(stdoutWriter, e.Stdout)
+	<-stderrDone
+	if err := e.Wait(); err != nil {
+		return "", fmt.Errorf("command failed: %w, stderr: %s", err, stderr.String())
+	}
+	if readErr != nil {
+		return "", readErr
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// RunEx executes a command in a sandbox of the pool like Run, returning its
+// exit code and both output streams. It implements the Runner interface.
+func (p *WarmPool) RunEx(ctx context.Context, req RunRequest) (*RunResult, error) {
+	return runEx(ctx, p, req)
+}
+
+// RunWithPipes starts a command in a sandbox of the pool. It implements the
+// Runner interface.
+func (p *WarmPool) RunWithPipes(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (
+	stdin io.WriteCloser,
+	stdout io.ReadCloser,
+	stderr io.ReadCloser,
+	wait func() error,
+	err error,
+) {
+	e, err := p.start(ctx, cmd, args, env, params)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return e.Stdin, e.Stdout, e.Stderr, e.Wait, nil
+}
+
+// CheckImplicitRequirements checks the requirements of the runner of the
+// pool, and returns ErrSessionClosed once the pool is closed
+func (p *WarmPool) CheckImplicitRequirements() error {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return ErrSessionClosed
+	}
+	return p.runner.CheckImplicitRequirements()
+}
+
+// start starts a command in an idle sandbox, or in a new one when there is
+// none, which goes back to the pool once the command has been waited for
+func (p *WarmPool) start(ctx context.Context, cmd string, args []string, env []string, _ map[string]interface{}) (*Execution, error) {
+	s, err := p.take(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := s.backend.start(ctx, cmd, args, env)
+	if err != nil {
+		// the state of the sandbox is unknown
+		s.backend.close()
+		p.mu.Lock()
+		p.stats.InUse--
+		p.mu.Unlock()
+		p.fill()
+		return nil, err
+	}
+	e.exitHooks = append(e.exitHooks, func() { p.put(s) })
+	return e, nil
+}
+
+// take returns an idle sandbox, destroying the expired ones, or creates a
+// new one when there is none
+func (p *WarmPool) take(ctx context.Context) (*warmSandbox, error) {
+	now := time.Now()
+	var expired []*warmSandbox
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+	var s *warmSandbox
+	for s == nil && len(p.idle) > 0 {
+		// the most recently used sandbox, so the others can be evicted
+		last := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if reason := p.expiredLocked(last, now); reason != "" {
+			expired = append(expired, last)
+			continue
+		}
+		s = last
+	}
+	if s != nil {
+		p.stats.Hits++
+	} else {
+		p.stats.Misses++
+	}
+	p.stats.InUse++
+	p.stats.Idle = len(p.idle)
+	p.mu.Unlock()
+
+	p.destroy(expired)
+	if s == nil {
+		p.logger.Debug("Warm pool: no idle sandbox, creating one")
+		var err error
+		if s, err = p.open(ctx); err != nil {
+			p.mu.Lock()
+			p.stats.InUse--
+			p.mu.Unlock()
+			return nil, err
+		}
+	}
+	s.uses++
+	p.fill()
+	return s, nil
+}
+
+// put returns a sandbox to the pool once its command has completed, unless
+// it has reached its limits or the pool is closed
+func (p *WarmPool) put(s *warmSandbox) {
+	now := time.Now()
+	p.mu.Lock()
+	p.stats.InUse--
+	keep := !p.closed
+	switch {
+	case !keep:
+	case p.options.MaxReuses > 0 && s.uses >= p.options.MaxReuses:
+		p.stats.Retired++
+		keep = false
+	case p.options.TTL > 0 && now.Sub(s.created) >= p.options.TTL:
+		p.stats.Expired++
+		keep = false
+	case len(p.idle)+p.stats.InUse >= p.options.Size:
+		p.stats.Discarded++
+		keep = false
+	}
+	if keep {
+		s.idleSince = now
+		p.idle = append(p.idle, s)
+		p.stats.Idle = len(p.idle)
+	}
+	p.mu.Unlock()
+
+	if !keep {
+		p.logger.Debug("Warm pool: destroying a sandbox after %d commands", s.uses)
+		s.backend.close()
+		p.fill()
+	}
+}
+
+// expiredLocked returns why an idle sandbox must be destroyed, if it must,
+// counting it in the stats
+func (p *WarmPool) expiredLocked(s *warmSandbox, now time.Time) string {
+	switch {
+	case p.options.TTL > 0 && now.Sub(s.created) >= p.options.TTL:
+		p.stats.Expired++
+		return "TTL"
+	case p.options.MaxIdle > 0 && now.Sub(s.idleSince) >= p.options.MaxIdle:
+		p.stats.Evicted++
+		return "idle time"
+	}
+	return ""
+}
+
+// open creates a sandbox
+func (p *WarmPool) open(ctx context.Context) (*warmSandbox, error) {
+	backend, err := p.opener.openSession(ctx, p.params)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		p.stats.Failed++
+		return nil, fmt.Errorf("failed to create a sandbox of the warm pool: %w", err)
+	}
+	p.stats.Created++
+	now := time.Now()
+	return &warmSandbox{backend: backend, created: now, idleSince: now}, nil
+}
+
+// fill creates the sandboxes missing in the pool in the background
+func (p *WarmPool) fill() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for !p.closed && len(p.idle)+p.stats.InUse+p.filling < p.options.Size {
+		p.filling++
+		p.fills.Add(1)
+		go func() {
+			defer p.fills.Done()
+			s, err := p.open(p.ctx)
+			p.mu.Lock()
+			p.filling--
+			if err == nil && !p.closed {
+				p.idle = append(p.idle, s)
+				p.stats.Idle = len(p.idle)
+				s = nil
+			}
+			p.mu.Unlock()
+			if err != nil {
+				p.logger.Warn("Warm pool: %v", err)
+			} else if s != nil {
+				s.backend.close()
+			}
+		}()
+	}
+}
+
+// collect destroys the expired idle sandboxes and refills the pool
+// periodically, until the pool is closed
+func (p *WarmPool) collect() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.options.GCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		var expired []*warmSandbox
+		p.mu.Lock()
+		idle := p.idle[:0]
+		for _, s := range p.idle {
+			if reason := p.expiredLocked(s, now); reason != "" {
+				p.logger.Debug("Warm pool: destroying a sandbox for its %s", reason)
+				expired = append(expired, s)
+				continue
+			}
+			idle = append(idle, s)
+		}
+		p.idle = idle
+		p.stats.Idle = len(p.idle)
+		p.mu.Unlock()
+
+		p.destroy(expired)
+		p.fill()
+	}
+}
+
+// destroy destroys sandboxes removed from the pool
+func (p *WarmPool) destroy(sandboxes []*warmSandbox) {
+	for _, s := range sandboxes {
+		s.backend.close()
+	}
+}
+
+// Stats returns the counters of the pool
+func (p *WarmPool) Stats() WarmPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// Close destroys the idle sandboxes and those being created, and stops the
+// garbage collection. No more commands can be started, and the sandboxes of the running commands
+// are destroyed once they have been waited for.
+func (p *WarmPool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.stats.Idle = 0
+	p.mu.Unlock()
+
+	p.cancel()
+	<-p.done
+	// the sandboxes created by fill after closing are destroyed by fill
+	p.fills.Wait()
+	p.destroy(idle)
+	return nil
+}
@@ -0,0 +1,200 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSessionRunner is an exec runner opening fake sessions
+type fakeSessionRunner struct {
+	*Exec
+	opened atomic.Int32
+	closed atomic.Int32
+}
+
+func (r *fakeSessionRunner) openSession(ctx context.Context, params map[string]interface{}) (sessionBackend, error) {
+	r.opened.Add(1)
+	return &countingSessionBackend{fakeSessionBackend: fakeSessionBackend{r: r.Exec}, closed: &r.closed}, nil
+}
+
+// countingSessionBackend counts the sandboxes closed in its runner
+type countingSessionBackend struct {
+	fakeSessionBackend
+	closed *atomic.Int32
+}
+
+func (b *countingSessionBackend) close() {
+	b.closed.Add(1)
+}
+
+// newTestWarmPool returns a warm pool of fake sessions, once it is filled
+func newTestWarmPool(t *testing.T, options WarmPoolOptions) (*WarmPool, *fakeSessionRunner) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	exec, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	r := &fakeSessionRunner{Exec: exec}
+	p, err := NewWarmPool(r, nil, options, nil)
+	if err != nil {
+		t.Fatalf("NewWarmPool failed: %v", err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	waitWarmPool(t, p, func(s WarmPoolStats) bool { return s.Idle == p.options.Size })
+	return p, r
+}
+
+// waitWarmPool waits for the stats of the pool to satisfy a condition
+func waitWarmPool(t *testing.T, p *WarmPool, cond func(WarmPoolStats) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond(p.Stats()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("stats = %+v", p.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWarmPool_reuse(t *testing.T) {
+	p, r := newTestWarmPool(t, WarmPoolOptions{Size: 2, MaxReuses: 2})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		output, err := p.Run(ctx, "", "echo hello", nil, nil, false)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if output != "hello" {
+			t.Errorf("Run() = %q, want the output without the trailing newline", output)
+		}
+	}
+
+	// the same sandbox is reused until its limit, then replaced
+	waitWarmPool(t, p, func(s WarmPoolStats) bool { return s.Idle == 2 })
+	stats := p.Stats()
+	if stats.Hits != 3 || stats.Misses != 0 || stats.Retired != 1 || stats.HitRate() != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if opened, closed := r.opened.Load(), r.closed.Load(); opened != 3 || closed != 1 {
+		t.Errorf("opened %d and closed %d sandboxes, want 3 and 1", opened, closed)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if closed := r.closed.Load(); closed != 3 {
+		t.Errorf("closed %d sandboxes, want all of them", closed)
+	}
+	if _, err := p.Run(ctx, "", "true", nil, nil, false); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("Run() error = %v, want ErrSessionClosed", err)
+	}
+}
+
+func TestWarmPool_miss(t *testing.T) {
+	p, _ := newTestWarmPool(t, WarmPoolOptions{Size: 1})
+	ctx := context.Background()
+
+	first, err := Start(ctx, p, "cat", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	// every command runs alone in a sandbox
+	second, err := Start(ctx, p, "cat", nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	stats := p.Stats()
+	if stats.InUse != 2 || stats.Hits != 1 || stats.Misses != 1 || stats.HitRate() != 0.5 {
+		t.Errorf("stats = %+v", stats)
+	}
+	for _, e := range []*Execution{first, second} {
+		_ = e.Stdin.Close()
+		if err := e.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := p.Stats(); stats.InUse != 0 || stats.Idle != 1 || stats.Discarded != 1 {
+		t.Errorf("stats = %+v, want a sandbox back in the pool", stats)
+	}
+}
+
+func TestWarmPool_gc(t *testing.T) {
+	p, r := newTestWarmPool(t, WarmPoolOptions{Size: 1, MaxIdle: 20 * time.Millisecond, GCInterval: 10 * time.Millisecond})
+
+	// the idle sandboxes are evicted and replaced
+	waitWarmPool(t, p, func(s WarmPoolStats) bool { return s.Evicted >= 2 && s.Idle == 1 })
+	if opened := r.opened.Load(); opened < 3 {
+		t.Errorf("opened %d sandboxes, want the evicted ones replaced", opened)
+	}
+
+	p, _ = newTestWarmPool(t, WarmPoolOptions{Size: 1, TTL: 50 * time.Millisecond, GCInterval: time.Hour})
+	time.Sleep(60 * time.Millisecond)
+	if _, err := p.Run(context.Background(), "", "true", nil, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if stats := p.Stats(); stats.Expired != 1 || stats.Misses != 1 {
+		t.Errorf("stats = %+v, want the expired sandbox replaced", stats)
+	}
+}
+
+// slowSessionRunner is a fake session runner whose sandboxes are created
+// once released
+type slowSessionRunner struct {
+	fakeSessionRunner
+	release chan struct{}
+}
+
+func (r *slowSessionRunner) openSession(ctx context.Context, params map[string]interface{}) (sessionBackend, error) {
+	<-r.release
+	return r.fakeSessionRunner.openSession(ctx, params)
+}
+
+func TestWarmPool_closeWhileFilling(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	exec, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	r := &slowSessionRunner{fakeSessionRunner: fakeSessionRunner{Exec: exec}, release: make(chan struct{})}
+	p, err := NewWarmPool(r, nil, WarmPoolOptions{Size: 2}, nil)
+	if err != nil {
+		t.Fatalf("NewWarmPool failed: %v", err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		_ = p.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatalf("Close() returned while sandboxes were being created")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(r.release)
+	<-closed
+	if opened, destroyed := r.opened.Load(), r.closed.Load(); opened != 2 || destroyed != 2 {
+		t.Errorf("opened %d and closed %d sandboxes, want all of them closed by Close", opened, destroyed)
+	}
+}
+
+func TestNewWarmPool_notSupported(t *testing.T) {
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewWarmPool(r, nil, WarmPoolOptions{}, nil); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("NewWarmPool() error = %v, want ErrNotSupported", err)
+	}
+}
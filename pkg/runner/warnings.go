@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// policyWarningsTimeout is the timeout of the probes of the sandbox tools
+// done to find the weakened restrictions
+const policyWarningsTimeout = 5 * time.Second
+
+// PolicyWarning reports a restriction requested by the options of a runner
+// that is weakened or not enforced on this host, such as Landlock degraded
+// with best_effort on an old kernel
+type PolicyWarning struct {
+	// Runner is the type of the runner
+	Runner Type `json:"runner"`
+
+	// Feature is the weakened restriction (e.g. "landlock_network")
+	Feature string `json:"feature"`
+
+	// Option is the option requesting the restriction, if any
+	Option string `json:"option,omitempty"`
+
+	// Message describes what is not enforced
+	Message string `json:"message"`
+}
+
+// String returns a description of the warning
+func (w PolicyWarning) String() string {
+	if w.Option != "" {
+		return fmt.Sprintf("%s runner: %s (%s): %s", w.Runner, w.Feature, w.Option, w.Message)
+	}
+	return fmt.Sprintf("%s runner: %s: %s", w.Runner, w.Feature, w.Message)
+}
+
+// policyWarner is implemented by the runners whose restrictions can be
+// weakened by the host
+type policyWarner interface {
+	policyWarnings(ctx context.Context) []PolicyWarning
+}
+
+// NewWithWarnings creates a runner like New, also returning the restrictions
+// of its options that are weakened or not enforced on this host, so callers
+// can surface them to their users. The warnings are also logged.
+func NewWithWarnings(runnerType Type, options Options, logger Logger) (Runner, []PolicyWarning, error) {
+	r, err := New(runnerType, options, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	warnings := Warnings(r)
+	for _, w := range warnings {
+		defaultLogger(logger).Warn("Weakened sandbox: %s", w)
+	}
+	return r, warnings, nil
+}
+
+// Warnings returns the restrictions of the options of a runner that are
+// weakened or not enforced on this host. The sandbox tools may be probed.
+func Warnings(r Runner) []PolicyWarning {
+	w, ok := r.(policyWarner)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), policyWarningsTimeout)
+	defer cancel()
+	return w.policyWarnings(ctx)
+}
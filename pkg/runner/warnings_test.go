@@ -0,0 +1,115 @@
+package runner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeTool writes an executable script named name to a folder prepended to
+// the PATH
+func fakeTool(t *testing.T, name string, script string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatalf("failed to write the fake %s: %v", name, err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// warningFeatures returns the features of the warnings
+func warningFeatures(warnings []PolicyWarning) string {
+	var features []string
+	for _, w := range warnings {
+		features = append(features, w.Feature)
+	}
+	return strings.Join(features, ",")
+}
+
+func TestNewWithWarnings_docker(t *testing.T) {
+	fakeTool(t, "docker", `[ "$1" = info ] && echo '["name=apparmor","name=cgroupns"]'; exit 0`)
+
+	r, warnings, err := NewWithWarnings(TypeDocker, Options{"image": "alpine"}, nil)
+	if err != nil {
+		t.Fatalf("NewWithWarnings failed: %v", err)
+	}
+	if r == nil || warningFeatures(warnings) != "userns,seccomp" {
+		t.Errorf("warnings = %v, want userns and seccomp", warnings)
+	}
+	if !strings.Contains(warnings[0].String(), "docker runner: userns (user)") {
+		t.Errorf("String() = %q", warnings[0].String())
+	}
+
+	r, err = NewDocker(Options{"image": "alpine", "user": "1000:1000"}, nil)
+	if err != nil {
+		t.Fatalf("NewDocker failed: %v", err)
+	}
+	if got := Warnings(r); warningFeatures(got) != "seccomp" {
+		t.Errorf("warnings of a non-root container = %v", got)
+	}
+}
+
+func TestWarnings_dockerHardened(t *testing.T) {
+	fakeTool(t, "docker", `[ "$1" = info ] && echo '["name=seccomp,profile=builtin","name=rootless"]'; exit 0`)
+	r, err := NewDocker(Options{"image": "alpine"}, nil)
+	if err != nil {
+		t.Fatalf("NewDocker failed: %v", err)
+	}
+	if got := Warnings(r); len(got) != 0 {
+		t.Errorf("warnings of a hardened daemon = %v", got)
+	}
+}
+
+func TestWarnings_firejail(t *testing.T) {
+	fakeTool(t, "firejail", `echo "firejail version 0.9.72"; echo "   - seccomp-bpf support is disabled"`)
+	r, err := NewFirejail(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewFirejail failed: %v", err)
+	}
+	if got := Warnings(r); warningFeatures(got) != "seccomp" {
+		t.Errorf("warnings = %v, want seccomp", got)
+	}
+}
+
+func TestWarnings_landrun(t *testing.T) {
+	abi, abiErr := 3, error(nil)
+	defer func(orig func() (int, error)) { landlockABI = orig }(landlockABI)
+	landlockABI = func() (int, error) { return abi, abiErr }
+
+	tests := []struct {
+		name    string
+		options Options
+		abi     int
+		err     error
+		want    string
+	}{
+		{"restricted", Options{"allow_connect_tcp": []int{443}}, 4, nil, ""},
+		{"network not restricted", Options{}, 4, nil, "network"},
+		{"old kernel", Options{"allow_connect_tcp": []int{443}, "best_effort": true}, 3, nil, "landlock_network"},
+		{"old kernel without best effort", Options{"allow_connect_tcp": []int{443}}, 3, nil, ""},
+		{"no landlock", Options{"allow_networking": true, "best_effort": true}, 0, errors.New("no landlock"), "landlock"},
+		{"read-only root", Options{"allow_networking": true, "read_only_root": true}, 0, errors.New("no landlock"), "landlock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			abi, abiErr = tt.abi, tt.err
+			r, err := NewLandrun(tt.options, nil)
+			if err != nil {
+				t.Fatalf("NewLandrun failed: %v", err)
+			}
+			if got := warningFeatures(Warnings(r)); got != tt.want {
+				t.Errorf("warnings = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := Warnings(&Exec{}); got != nil {
+		t.Errorf("warnings of the exec runner = %v", got)
+	}
+}
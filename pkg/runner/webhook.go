@@ -0,0 +1,236 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ExecutionStatus is the outcome of an execution reported to webhooks
+type ExecutionStatus string
+
+const (
+	// StatusSucceeded executions exited with 0
+	StatusSucceeded ExecutionStatus = "succeeded"
+	// StatusFailed executions exited with another code, or failed to run
+	StatusFailed ExecutionStatus = "failed"
+	// StatusCancelled executions were killed because their context was cancelled
+	StatusCancelled ExecutionStatus = "cancelled"
+)
+
+// ExecutionSummary is the JSON document POSTed to webhooks when an execution completes
+type ExecutionSummary struct {
+	ExecutionID string          `json:"execution_id"`
+	Status      ExecutionStatus `json:"status"`
+	ExitCode    int             `json:"exit_code"`
+	Error       string          `json:"error,omitempty"`
+	StartedAt   time.Time       `json:"started_at"`
+	FinishedAt  time.Time       `json:"finished_at"`
+	DurationMs  int64           `json:"duration_ms"`
+	Fingerprint string          `json:"fingerprint,omitempty"`
+	Host        HostInfo        `json:"host"`
+}
+
+// WebhookOptions is the options for a WebhookNotifier
+type WebhookOptions struct {
+	// URLs are the webhooks notified
+	URLs []string
+
+	// Secret signs the notifications with HMAC-SHA256 (see WebhookNotifier)
+	Secret []byte
+
+	// MaxAttempts is the number of attempts per webhook (3 by default)
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry, doubled for the next ones (1s by default)
+	Backoff time.Duration
+
+	// Client is the HTTP client used (defaults to a client with a 10s timeout)
+	Client *http.Client
+}
+
+// WebhookNotifier POSTs an ExecutionSummary to webhooks when executions
+// complete (see WithNotifier).
+//
+// Failed deliveries (network errors, 429 and 5xx responses) are retried.
+// When a secret is set, the X-Runner-Signature header of the requests is
+// "sha256=" followed by the hex encoded HMAC-SHA256 of the X-Runner-Timestamp
+// header, a dot and the body, so receivers can authenticate the
+// notifications and reject old ones.
+type WebhookNotifier struct {
+	logger  Logger
+	options WebhookOptions
+	pending sync.WaitGroup
+}
+
+// NewWebhookNotifier creates a new WebhookNotifier with the provided logger.
+// If logger is nil, a default logger is created.
+func NewWebhookNotifier(options WebhookOptions, logger Logger) (*WebhookNotifier, error) {
+	logger = defaultLogger(logger)
+	if len(options.URLs) == 0 {
+		return nil, fmt.Errorf("webhook notifier requires at least one URL")
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 3
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = time.Second
+	}
+	if options.Client == nil {
+		options.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &WebhookNotifier{logger: logger, options: options}, nil
+}
+
+// WithNotifier notifies the webhooks of the notifier when the execution
+// completes. Notifications are sent in the background: use
+// WebhookNotifier.Wait to wait for them (e.g. before exiting).
+func WithNotifier(n *WebhookNotifier) ExecOption {
+	return func(c *execConfig) {
+		c.notifier = n
+	}
+}
+
+// Wait waits for the notifications in progress
+func (n *WebhookNotifier) Wait() {
+	n.pending.Wait()
+}
+
+// Notify sends a summary to all the webhooks, returning the errors of the
+// webhooks that could not be notified
+func (n *WebhookNotifier) Notify(ctx context.Context, summary ExecutionSummary) error {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, url := range n.options.URLs {
+		if err := n.deliver(ctx, url, body); err != nil {
+			n.logger.Info("Failed to notify webhook %s of execution %s: %v", url, summary.ExecutionID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// notifyAsync sends a summary in the background
+func (n *WebhookNotifier) notifyAsync(summary ExecutionSummary) {
+	n.pending.Add(1)
+	go func() {
+		defer n.pending.Done()
+		_ = n.Notify(context.Background(), summary)
+	}()
+}
+
+// deliver POSTs the body to a webhook, retrying failed attempts
+func (n *WebhookNotifier) deliver(ctx context.Context, url string, body []byte) error {
+	backoff := n.options.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = n.post(ctx, url, body)
+		if err == nil || !retry || attempt >= n.options.MaxAttempts {
+			return err
+		}
+
+		n.logger.Debug("Webhook %s failed (attempt %d): %v", url, attempt, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends one request, and returns whether a failure can be retried
+func (n *WebhookNotifier) post(ctx context.Context, url string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.options.Secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Runner-Timestamp", timestamp)
+		req.Header.Set("X-Runner-Signature", "sha256="+signWebhook(n.options.Secret, timestamp, body))
+	}
+
+	resp, err := n.options.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
+
+// signWebhook returns the hex encoded signature of a notification
+func signWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks the X-Runner-Signature header of a
+// notification, rejecting notifications older than maxAge (if not zero)
+func VerifyWebhookSignature(secret []byte, timestamp, signature string, body []byte, maxAge time.Duration) error {
+	expected := "sha256=" + signWebhook(secret, timestamp, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Errorf("invalid webhook signature")
+	}
+	if maxAge > 0 {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid webhook timestamp: %w", err)
+		}
+		if age := time.Since(time.Unix(ts, 0)); age > maxAge {
+			return fmt.Errorf("webhook notification too old (%v)", age.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// summarize builds the summary of a completed execution
+func summarize(ctx context.Context, e *Execution, err error, fingerprint string, host HostInfo,
+	started, finished time.Time) ExecutionSummary {
+	s := ExecutionSummary{
+		ExecutionID: e.ID,
+		Status:      StatusSucceeded,
+		ExitCode:    exitCode(err),
+		StartedAt:   started,
+		FinishedAt:  finished,
+		DurationMs:  finished.Sub(started).Milliseconds(),
+		Fingerprint: fingerprint,
+		Host:        host,
+	}
+	if err != nil {
+		s.Error = err.Error()
+		s.Status = StatusFailed
+		if ctx.Err() != nil {
+			s.Status = StatusCancelled
+		}
+	}
+	return s
+}
@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifier_Retries(t *testing.T) {
+	secret := []byte("secret")
+
+	var attempts atomic.Int32
+	var mu sync.Mutex
+	var received []ExecutionSummary
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := VerifyWebhookSignature(secret, r.Header.Get("X-Runner-Timestamp"),
+			r.Header.Get("X-Runner-Signature"), body, time.Minute); err != nil {
+			t.Errorf("invalid signature: %v", err)
+		}
+		// the first attempt fails
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var s ExecutionSummary
+		if err := json.Unmarshal(body, &s); err != nil {
+			t.Errorf("invalid body: %v", err)
+		}
+		mu.Lock()
+		received = append(received, s)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	n, err := NewWebhookNotifier(WebhookOptions{
+		URLs:    []string{srv.URL},
+		Secret:  secret,
+		Backoff: 10 * time.Millisecond,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := Start(context.Background(), r, "sh", []string{"-c", "exit 4"}, nil, nil, WithNotifier(n))
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	_, _ = io.ReadAll(e.Stdout)
+	_, _ = io.ReadAll(e.Stderr)
+	_ = e.Wait()
+	n.Wait()
+
+	if attempts.Load() != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts.Load())
+	}
+	if len(received) != 1 {
+		t.Fatalf("expected 1 notification, got %d", len(received))
+	}
+	s := received[0]
+	if s.ExecutionID != e.ID || s.Status != StatusFailed || s.ExitCode != 4 || s.Fingerprint != Fingerprint(r) ||
+		s.Host.Runner != TypeExec {
+		t.Errorf("unexpected summary: %+v", s)
+	}
+}
+
+func TestWebhookNotifier_NoRetryOnClientErrors(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	n, err := NewWebhookNotifier(WebhookOptions{URLs: []string{srv.URL}, Backoff: time.Millisecond}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), ExecutionSummary{ExecutionID: "x"}); err == nil {
+		t.Error("expected an error")
+	}
+	if attempts.Load() != 1 {
+		t.Errorf("expected 1 attempt, got %d", attempts.Load())
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"execution_id":"x"}`)
+	sig := "sha256=" + signWebhook(secret, "1000", body)
+
+	if err := VerifyWebhookSignature(secret, "1000", sig, body, 0); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := VerifyWebhookSignature(secret, "1000", sig, []byte("{}"), 0); err == nil {
+		t.Error("expected an error for a different body")
+	}
+	if err := VerifyWebhookSignature(secret, "1000", sig, body, time.Minute); err == nil {
+		t.Error("expected an error for an old notification")
+	}
+}
@@ -0,0 +1,331 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/docker/go-units"
+
+	"github.com/inercia/go-restricted-runner/pkg/common"
+)
+
+// WindowsRestricted implements the Runner interface on Windows, running the
+// commands in an AppContainer and a Job Object.
+//
+// The AppContainer restricts the filesystem and the network: the commands
+// can only open the files and folders granted to every AppContainer (e.g.
+// the system folders) and those of the allow_* options, which are granted to
+// the AppContainer of the command while it runs, and they have no network
+// access without allow_networking. The Job Object limits the memory, the CPU
+// and the processes of the commands, and kills them all when the command
+// completes or is cancelled.
+type WindowsRestricted struct {
+	logger  Logger
+	options WindowsRestrictedOptions
+}
+
+// WindowsRestrictedOptions is the options for the WindowsRestricted runner
+type WindowsRestrictedOptions struct {
+	// Shell runs the commands (defaults to %COMSPEC%)
+	Shell string `json:"shell"`
+
+	// AllowNetworking gives access to the network (the internetClient and
+	// privateNetworkClientServer capabilities)
+	AllowNetworking bool `json:"allow_networking"`
+
+	// Files and folders granted to the AppContainer of the commands
+	AllowReadFolders  []string `json:"allow_read_folders"`
+	AllowWriteFolders []string `json:"allow_write_folders"`
+	AllowReadFiles    []string `json:"allow_read_files"`
+	AllowWriteFiles   []string `json:"allow_write_files"`
+
+	// Memory is the memory limit of all the processes of a command (e.g. "512m")
+	Memory string `json:"memory"`
+
+	// CPUs is the CPU time available to a command, in CPUs (e.g. 1.5)
+	CPUs float64 `json:"cpus"`
+
+	// PidsLimit is the number of processes a command can run at once
+	PidsLimit int `json:"pids_limit"`
+
+	// Variables of the commands read from .env files
+	EnvFileOptions
+
+	// Command run in the sandbox before the commands
+	PreflightOptions
+
+	// Timeout of the commands
+	TimeoutOptions
+
+	// Limits of the output of the commands
+	OutputLimitOptions
+}
+
+// windowsSandbox is the sandbox of a command: the AppContainer and its
+// grants, and the limits of the Job Object
+type windowsSandbox struct {
+	// readPaths and writePaths are granted to the AppContainer
+	readPaths  []string
+	writePaths []string
+
+	// network gives the network capabilities to the AppContainer
+	network bool
+
+	// memory is the memory limit of the job, in bytes (0 for no limit)
+	memory uint64
+
+	// cpuRate is the CPU rate of the job, in 1/100 of a percent of all the
+	// CPUs (0 for no limit)
+	cpuRate uint32
+
+	// processes is the maximum number of active processes (0 for no limit)
+	processes uint32
+}
+
+// NewWindowsRestrictedOptions creates a new WindowsRestrictedOptions from Options
+func NewWindowsRestrictedOptions(options Options) (WindowsRestrictedOptions, error) {
+	var opts WindowsRestrictedOptions
+	jsonStr, err := options.ToJSON()
+	if err != nil {
+		return WindowsRestrictedOptions{}, err
+	}
+	err = json.Unmarshal([]byte(jsonStr), &opts)
+	return opts, err
+}
+
+// NewWindowsRestricted creates a new WindowsRestricted runner with the
+// provided logger. If logger is nil, a default logger is created.
+func NewWindowsRestricted(options Options, logger Logger) (*WindowsRestricted, error) {
+	logger = defaultLogger(logger)
+
+	opts, err := NewWindowsRestrictedOptions(options)
+	if err != nil {
+		logger.Debug("Failed to parse windows-restricted options: %v", err)
+		return nil, fmt.Errorf("failed to parse windows-restricted options: %w", err)
+	}
+	if err := opts.validate(); err != nil {
+		return nil, fmt.Errorf("invalid windows-restricted options: %w", err)
+	}
+	if err := opts.validateTimeouts(); err != nil {
+		return nil, fmt.Errorf("invalid windows-restricted options: %w", err)
+	}
+	if err := opts.loadEnvFiles(); err != nil {
+		return nil, fmt.Errorf("invalid windows-restricted options: %w", err)
+	}
+	if err := opts.validateOutputLimits(); err != nil {
+		return nil, fmt.Errorf("invalid windows-restricted options: %w", err)
+	}
+	if err := opts.validatePreflight(); err != nil {
+		return nil, fmt.Errorf("invalid windows-restricted options: %w", err)
+	}
+
+	return &WindowsRestricted{
+		logger:  logger,
+		options: opts,
+	}, nil
+}
+
+// validate checks the limits of the job
+func (o WindowsRestrictedOptions) validate() error {
+	if o.Memory != "" {
+		if bytes, err := units.RAMInBytes(o.Memory); err != nil || bytes <= 0 {
+			return fmt.Errorf("invalid memory %q", o.Memory)
+		}
+	}
+	if o.CPUs < 0 || math.IsNaN(o.CPUs) {
+		return fmt.Errorf("invalid cpus %v: must be positive", o.CPUs)
+	}
+	if o.PidsLimit < 0 {
+		return fmt.Errorf("invalid pids_limit %d: must be positive", o.PidsLimit)
+	}
+	return nil
+}
+
+// sandbox returns the sandbox of a command, with template variables in the
+// paths replaced with params
+func (r *WindowsRestricted) sandbox(params map[string]interface{}) windowsSandbox {
+	s := windowsSandbox{
+		readPaths: append(common.ProcessTemplateListFlexible(r.options.AllowReadFolders, params),
+			common.ProcessTemplateListFlexible(r.options.AllowReadFiles, params)...),
+		writePaths: append(common.ProcessTemplateListFlexible(r.options.AllowWriteFolders, params),
+			common.ProcessTemplateListFlexible(r.options.AllowWriteFiles, params)...),
+		network:   r.options.AllowNetworking,
+		processes: uint32(r.options.PidsLimit),
+	}
+	s.readPaths = withInputsDir(s.readPaths, params)
+	s.writePaths = withWritableDirs(s.writePaths, params)
+	if bytes, err := units.RAMInBytes(r.options.Memory); r.options.Memory != "" && err == nil {
+		s.memory = uint64(bytes)
+	}
+	if r.options.CPUs > 0 {
+		// the rate is relative to all the CPUs of the host
+		rate := r.options.CPUs / float64(runtime.NumCPU()) * 10000
+		s.cpuRate = uint32(math.Max(1, math.Min(10000, rate)))
+	}
+	return s
+}
+
+// Run executes a command in the sandbox and returns the output.
+// It implements the Runner interface.
+//
+// note: tmpfile is ignored, as the commands are passed to the shell directly
+func (r *WindowsRestricted) Run(ctx context.Context, shell string, command string,
+	env []string, params map[string]interface{}, tmpfile bool,
+) (string, error) {
+	return r.options.runWithTimeout(ctx, func(ctx context.Context) (string, error) {
+		return r.run(ctx, shell, command, env, params)
+	})
+}
+
+// run is Run, with the context bounded by the timeout options
+func (r *WindowsRestricted) run(ctx context.Context, shell string, command string,
+	env []string, params map[string]interface{},
+) (string, error) {
+	logger := contextLogger(ctx, r.logger)
+
+	// Check if context is done
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	default:
+		// Continue execution
+	}
+
+	if err := r.options.runPreflight(ctx, logger, getShell(r.options.Shell), r.start, params); err != nil {
+		return "", err
+	}
+	env = r.options.withEnvFiles(env)
+
+	if shell == "" {
+		shell = r.options.Shell
+	}
+	shellPath, args := getShellCommandArgs(getShell(shell), command)
+	execCmd := commandContext(ctx, shellPath, args...)
+	logger.Debug("Created command: %s with args %v", shellPath, args)
+
+	// Set environment variables if provided
+	if len(env) > 0 {
+		logger.Debug("Adding %d environment variables to command", len(env))
+		for _, e := range env {
+			categoryLogger(logger, LogCategoryEnvironment).Debug("... adding environment variable: %s", e)
+		}
+		execCmd.Env = append(os.Environ(), env...)
+	}
+
+	release, err := applyWindowsSandbox(logger, execCmd, r.sandbox(params))
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	// Capture output
+	var stdout, stderr bytes.Buffer
+	execCmd.Stdout, execCmd.Stderr = captureRunOutput(ctx, &stdout, &stderr)
+	var truncated func() error
+	execCmd.Stdout, execCmd.Stderr, truncated = r.options.limitOutput(ctx, execCmd.Stdout, execCmd.Stderr)
+
+	// Run the command
+	logger.Debug("Executing command")
+
+	if err := execCmd.Run(); err != nil {
+		// If there's error output, include it in the error
+		if stderr.Len() > 0 {
+			errMsg := strings.TrimSpace(stderr.String())
+			logger.Debug("Command failed with stderr: %s", errMsg)
+			return "", newCommandError(errMsg, err)
+		}
+		logger.Debug("Command failed with error: %v", err)
+		return "", err
+	}
+
+	output := strings.TrimSpace(stdout.String())
+	logger.Debug("Command executed successfully, output length: %d bytes", len(output))
+	if stderr.Len() > 0 {
+		logger.Debug("Command generated stderr (but no error): '%s'", strings.TrimSpace(stderr.String()))
+	}
+	return output, truncated()
+}
+
+// RunEx executes a command in the sandbox like Run, returning its exit code
+// and both output streams. It implements the Runner interface.
+func (r *WindowsRestricted) RunEx(ctx context.Context, req RunRequest) (*RunResult, error) {
+	return runEx(ctx, r, req)
+}
+
+// RunWithPipes executes a command in the sandbox with access to its
+// stdin/stdout/stderr pipes. It implements the Runner interface.
+//
+// The command is executed directly without a shell.
+func (r *WindowsRestricted) RunWithPipes(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (
+	stdin io.WriteCloser,
+	stdout io.ReadCloser,
+	stderr io.ReadCloser,
+	wait func() error,
+	err error,
+) {
+	e, err := r.start(ctx, cmd, args, env, params)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return e.Stdin, e.Stdout, e.Stderr, e.Wait, nil
+}
+
+// start executes a command in the sandbox and returns an execution handle
+// for it. It is used by RunWithPipes and Start.
+func (r *WindowsRestricted) start(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	return r.options.startWithTimeout(ctx, func(ctx context.Context) (*Execution, error) {
+		return r.startCommand(ctx, cmd, args, env, params)
+	})
+}
+
+// startCommand is start, with the context bounded by the timeout options
+func (r *WindowsRestricted) startCommand(ctx context.Context, cmd string, args []string, env []string, params map[string]interface{}) (*Execution, error) {
+	logger := contextLogger(ctx, r.logger)
+
+	// Check if context is already done
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+		// Continue execution
+	}
+
+	if err := r.options.runPreflight(ctx, logger, getShell(r.options.Shell), r.start, params); err != nil {
+		return nil, err
+	}
+	if err := checkNoExtraFiles(ctx, "the windows-restricted runner's"); err != nil {
+		return nil, err
+	}
+	env = r.options.withEnvFiles(env)
+
+	logger.Debug("RunWithPipes: executing command: %s with args: %v", cmd, args)
+	execCmd := commandContext(ctx, cmd, args...)
+
+	// Set environment variables if provided
+	if len(env) > 0 {
+		logger.Debug("Adding %d environment variables to command", len(env))
+		execCmd.Env = append(os.Environ(), env...)
+	}
+
+	release, err := applyWindowsSandbox(logger, execCmd, r.sandbox(params))
+	if err != nil {
+		return nil, err
+	}
+	return startProcess(logger, execCmd, release)
+}
+
+// CheckImplicitRequirements checks if the runner meets its implicit requirements.
+// WindowsRestricted runner requires Windows.
+func (r *WindowsRestricted) CheckImplicitRequirements() error {
+	if runtime.GOOS != "windows" {
+		return fmt.Errorf("windows-restricted runner requires Windows")
+	}
+	return nil
+}
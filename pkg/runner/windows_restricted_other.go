@@ -0,0 +1,13 @@
+//go:build !windows
+
+package runner
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// applyWindowsSandbox is not supported outside Windows
+func applyWindowsSandbox(logger Logger, cmd *exec.Cmd, s windowsSandbox) (func(), error) {
+	return nil, fmt.Errorf("the windows-restricted runner requires Windows: %w", ErrNotSupported)
+}
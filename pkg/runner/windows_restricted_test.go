@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestNewWindowsRestricted_options(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		wantErr string
+	}{
+		{"defaults", Options{}, ""},
+		{"limits", Options{"memory": "512m", "cpus": 1.5, "pids_limit": 16}, ""},
+		{"invalid memory", Options{"memory": "lots"}, "invalid memory"},
+		{"negative cpus", Options{"cpus": -1}, "invalid cpus"},
+		{"negative pids", Options{"pids_limit": -1}, "invalid pids_limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewWindowsRestricted(tt.options, nil)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("NewWindowsRestricted() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("NewWindowsRestricted() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWindowsRestricted_sandbox(t *testing.T) {
+	r, err := NewWindowsRestricted(Options{
+		"allow_read_folders": []interface{}{"{{.project}}"},
+		"allow_write_files":  []interface{}{"out.txt"},
+		"allow_networking":   true,
+		"memory":             "1g",
+		"cpus":               float64(runtime.NumCPU()) * 2,
+		"pids_limit":         8,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := r.sandbox(map[string]interface{}{"project": "/src/project"})
+	if len(s.readPaths) != 1 || s.readPaths[0] != "/src/project" {
+		t.Errorf("readPaths = %v", s.readPaths)
+	}
+	if len(s.writePaths) != 1 || s.writePaths[0] != "out.txt" {
+		t.Errorf("writePaths = %v", s.writePaths)
+	}
+	if !s.network || s.memory != 1<<30 || s.processes != 8 {
+		t.Errorf("sandbox() = %+v", s)
+	}
+	if s.cpuRate != 10000 {
+		t.Errorf("cpuRate = %d, want it capped to all the CPUs", s.cpuRate)
+	}
+
+	r.options.CPUs = 0.0001
+	if s := r.sandbox(nil); s.cpuRate != 1 {
+		t.Errorf("cpuRate = %d, want the minimum rate", s.cpuRate)
+	}
+}
+
+func TestWindowsRestricted_requirements(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the runner is supported on Windows")
+	}
+	r, err := NewWindowsRestricted(Options{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CheckImplicitRequirements(); err == nil {
+		t.Error("CheckImplicitRequirements() should fail outside Windows")
+	}
+	if _, err := New(TypeWindowsRestricted, Options{}, nil); err == nil {
+		t.Error("New() should fail outside Windows")
+	}
+	if _, err := r.Run(context.Background(), "", "echo hello", nil, nil, false); err == nil {
+		t.Error("Run() should fail outside Windows")
+	}
+}
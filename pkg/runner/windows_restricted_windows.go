@@ -0,0 +1,383 @@
+//go:build windows
+
+package runner
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// windowsSandboxHelperEnv carries the configuration of the sandbox helper
+// (see runWindowsSandboxHelper)
+const windowsSandboxHelperEnv = "RUNNER_WINDOWS_SANDBOX_HELPER"
+
+// procThreadAttributeSecurityCapabilities is PROC_THREAD_ATTRIBUTE_SECURITY_CAPABILITIES
+const procThreadAttributeSecurityCapabilities = 0x00020009
+
+// Flags of JOBOBJECT_CPU_RATE_CONTROL_INFORMATION
+const (
+	jobObjectCPURateControlEnable  = 0x1
+	jobObjectCPURateControlHardCap = 0x4
+)
+
+// SIDs of the capabilities given to the AppContainer with allow_networking
+// (internetClient, internetClientServer and privateNetworkClientServer)
+var networkCapabilities = []string{"S-1-15-3-1", "S-1-15-3-2", "S-1-15-3-3"}
+
+var (
+	userenv                       = windows.NewLazySystemDLL("userenv.dll")
+	procCreateAppContainerProfile = userenv.NewProc("CreateAppContainerProfile")
+	procDeleteAppContainerProfile = userenv.NewProc("DeleteAppContainerProfile")
+)
+
+// securityCapabilities is SECURITY_CAPABILITIES
+type securityCapabilities struct {
+	AppContainerSid *windows.SID
+	Capabilities    *windows.SIDAndAttributes
+	CapabilityCount uint32
+	Reserved        uint32
+}
+
+// jobObjectCPURateControlInformation is JOBOBJECT_CPU_RATE_CONTROL_INFORMATION
+type jobObjectCPURateControlInformation struct {
+	ControlFlags uint32
+	CPURate      uint32
+}
+
+// windowsSandboxHelperConfig is the configuration of the sandbox helper
+type windowsSandboxHelperConfig struct {
+	AppContainerSID string   `json:"app_container_sid"`
+	Capabilities    []string `json:"capabilities,omitempty"`
+	JobMemory       uint64   `json:"job_memory,omitempty"`
+	ActiveProcesses uint32   `json:"active_processes,omitempty"`
+	CPURate         uint32   `json:"cpu_rate,omitempty"`
+	Application     string   `json:"application"`
+	CommandLine     string   `json:"command_line"`
+}
+
+// applyWindowsSandbox changes cmd so it runs in the sandbox s. It creates an
+// AppContainer profile for the command and grants it access to the paths of
+// s, and makes the command be started through this executable: the helper
+// mode (see runWindowsSandboxHelper) creates the Job Object and starts the
+// command in the AppContainer. The function returned revokes the grants
+// and deletes the profile, and must be called once the command completes.
+func applyWindowsSandbox(logger Logger, cmd *exec.Cmd, s windowsSandbox) (func(), error) {
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	self, err := helperExecutable("sandbox helper")
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	name := "go-restricted-runner-" + hex.EncodeToString(suffix)
+	sid, err := createAppContainerProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Created the AppContainer profile %s (%s)", name, sid)
+
+	var granted []string
+	release := func() {
+		for _, path := range granted {
+			if err := setPathAccess(path, sid, windows.REVOKE_ACCESS, 0); err != nil {
+				logger.Debug("Failed to revoke the access to %s: %v", path, err)
+			}
+		}
+		if err := deleteAppContainerProfile(name); err != nil {
+			logger.Debug("Failed to delete the AppContainer profile %s: %v", name, err)
+		}
+		_ = windows.FreeSid(sid)
+	}
+
+	grant := func(paths []string, access windows.ACCESS_MASK) error {
+		for _, path := range paths {
+			path, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(path); err != nil {
+				logger.Debug("Not granting the access to %s: %v", path, err)
+				continue
+			}
+			if err := setPathAccess(path, sid, windows.GRANT_ACCESS, access); err != nil {
+				return fmt.Errorf("failed to grant the access to %s: %w", path, err)
+			}
+			granted = append(granted, path)
+		}
+		return nil
+	}
+	read := windows.ACCESS_MASK(windows.GENERIC_READ | windows.GENERIC_EXECUTE)
+	if err := grant(s.readPaths, read); err != nil {
+		release()
+		return nil, err
+	}
+	if err := grant(s.writePaths, read|windows.GENERIC_WRITE|windows.DELETE); err != nil {
+		release()
+		return nil, err
+	}
+
+	config := windowsSandboxHelperConfig{
+		AppContainerSID: sid.String(),
+		JobMemory:       s.memory,
+		ActiveProcesses: s.processes,
+		CPURate:         s.cpuRate,
+		Application:     cmd.Path,
+		CommandLine:     windows.ComposeCommandLine(cmd.Args),
+	}
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.CmdLine != "" {
+		config.CommandLine = cmd.SysProcAttr.CmdLine
+		cmd.SysProcAttr.CmdLine = ""
+	}
+	if s.network {
+		config.Capabilities = networkCapabilities
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("failed to configure the sandbox helper: %w", err)
+	}
+
+	cmd.Args = []string{"runner-windows-sandbox-helper"}
+	cmd.Path = self
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, windowsSandboxHelperEnv+"="+string(data))
+	return release, nil
+}
+
+// createAppContainerProfile creates an AppContainer profile without
+// capabilities, returning its SID (to be released with FreeSid)
+func createAppContainerProfile(name string) (*windows.SID, error) {
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	var sid *windows.SID
+	r, _, _ := procCreateAppContainerProfile.Call(uintptr(unsafe.Pointer(namePtr)), uintptr(unsafe.Pointer(namePtr)),
+		uintptr(unsafe.Pointer(namePtr)), 0, 0, uintptr(unsafe.Pointer(&sid)))
+	if r != 0 {
+		return nil, fmt.Errorf("failed to create the AppContainer profile: HRESULT 0x%08x", r)
+	}
+	return sid, nil
+}
+
+// deleteAppContainerProfile deletes an AppContainer profile
+func deleteAppContainerProfile(name string) error {
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	if r, _, _ := procDeleteAppContainerProfile.Call(uintptr(unsafe.Pointer(namePtr))); r != 0 {
+		return fmt.Errorf("HRESULT 0x%08x", r)
+	}
+	return nil
+}
+
+// setPathAccess grants (or revokes) the access of a SID to a file or folder,
+// inherited by the contents of the folders
+func setPathAccess(path string, sid *windows.SID, mode windows.ACCESS_MODE, access windows.ACCESS_MASK) error {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	inheritance := uint32(windows.NO_INHERITANCE)
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		inheritance = windows.SUB_CONTAINERS_AND_OBJECTS_INHERIT
+	}
+	acl, err := windows.ACLFromEntries([]windows.EXPLICIT_ACCESS{{
+		AccessPermissions: access,
+		AccessMode:        mode,
+		Inheritance:       inheritance,
+		Trustee: windows.TRUSTEE{
+			TrusteeForm:  windows.TRUSTEE_IS_SID,
+			TrusteeType:  windows.TRUSTEE_IS_WELL_KNOWN_GROUP,
+			TrusteeValue: windows.TrusteeValueFromSID(sid),
+		},
+	}}, dacl)
+	if err != nil {
+		return err
+	}
+	return windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION, nil, nil, acl, nil)
+}
+
+// runWindowsSandboxHelper starts the command of its configuration in the
+// AppContainer, in a Job Object with the limits of the configuration, and
+// exits with the exit code of the command once it completes. The processes
+// left in the job are killed when the helper exits. It never returns.
+func runWindowsSandboxHelper() {
+	var config windowsSandboxHelperConfig
+	if err := json.Unmarshal([]byte(os.Getenv(windowsSandboxHelperEnv)), &config); err != nil {
+		fmt.Fprintf(os.Stderr, "runner: invalid sandbox helper configuration: %v\n", err)
+		os.Exit(126)
+	}
+	_ = os.Unsetenv(windowsSandboxHelperEnv)
+
+	code, err := runInWindowsSandbox(&config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "runner: %v\n", err)
+		os.Exit(126)
+	}
+	os.Exit(int(code))
+}
+
+// runInWindowsSandbox runs the command of the configuration in the sandbox,
+// returning its exit code
+func runInWindowsSandbox(config *windowsSandboxHelperConfig) (uint32, error) {
+	job, err := newSandboxJob(config)
+	if err != nil {
+		return 0, err
+	}
+	// the job is never closed: the processes left are killed when the helper exits
+
+	appContainer, err := windows.StringToSid(config.AppContainerSID)
+	if err != nil {
+		return 0, fmt.Errorf("invalid AppContainer SID: %w", err)
+	}
+	capabilities := make([]windows.SIDAndAttributes, 0, len(config.Capabilities))
+	for _, capability := range config.Capabilities {
+		sid, err := windows.StringToSid(capability)
+		if err != nil {
+			return 0, fmt.Errorf("invalid capability SID: %w", err)
+		}
+		capabilities = append(capabilities, windows.SIDAndAttributes{Sid: sid, Attributes: windows.SE_GROUP_ENABLED})
+	}
+	security := securityCapabilities{AppContainerSid: appContainer, CapabilityCount: uint32(len(capabilities))}
+	if len(capabilities) > 0 {
+		security.Capabilities = &capabilities[0]
+	}
+
+	// only the standard handles are inherited by the command
+	var handles []windows.Handle
+	stdHandles := [3]windows.Handle{}
+	for i, std := range []uint32{windows.STD_INPUT_HANDLE, windows.STD_OUTPUT_HANDLE, windows.STD_ERROR_HANDLE} {
+		h, err := windows.GetStdHandle(std)
+		if err != nil || h == 0 || h == windows.InvalidHandle {
+			continue
+		}
+		stdHandles[i] = h
+		if err := windows.SetHandleInformation(h, windows.HANDLE_FLAG_INHERIT, windows.HANDLE_FLAG_INHERIT); err != nil {
+			continue
+		}
+		duplicate := false
+		for _, other := range handles {
+			duplicate = duplicate || other == h
+		}
+		if !duplicate {
+			handles = append(handles, h)
+		}
+	}
+
+	attributes, err := windows.NewProcThreadAttributeList(2)
+	if err != nil {
+		return 0, err
+	}
+	defer attributes.Delete()
+	if err := attributes.Update(procThreadAttributeSecurityCapabilities,
+		unsafe.Pointer(&security), unsafe.Sizeof(security)); err != nil {
+		return 0, fmt.Errorf("failed to set the AppContainer: %w", err)
+	}
+	if len(handles) > 0 {
+		if err := attributes.Update(windows.PROC_THREAD_ATTRIBUTE_HANDLE_LIST,
+			unsafe.Pointer(&handles[0]), uintptr(len(handles))*unsafe.Sizeof(handles[0])); err != nil {
+			return 0, fmt.Errorf("failed to set the inherited handles: %w", err)
+		}
+	}
+
+	startup := windows.StartupInfoEx{ProcThreadAttributeList: attributes.List()}
+	startup.Cb = uint32(unsafe.Sizeof(startup))
+	startup.Flags = windows.STARTF_USESTDHANDLES
+	startup.StdInput, startup.StdOutput, startup.StdErr = stdHandles[0], stdHandles[1], stdHandles[2]
+
+	application, err := windows.UTF16PtrFromString(config.Application)
+	if err != nil {
+		return 0, err
+	}
+	commandLine, err := windows.UTF16PtrFromString(config.CommandLine)
+	if err != nil {
+		return 0, err
+	}
+	var process windows.ProcessInformation
+	if err := windows.CreateProcess(application, commandLine, nil, nil, len(handles) > 0,
+		windows.CREATE_SUSPENDED|windows.EXTENDED_STARTUPINFO_PRESENT, nil, nil,
+		&startup.StartupInfo, &process); err != nil {
+		return 0, fmt.Errorf("failed to execute %s: %w", config.Application, err)
+	}
+	defer func() {
+		_ = windows.CloseHandle(process.Thread)
+		_ = windows.CloseHandle(process.Process)
+	}()
+
+	if err := windows.AssignProcessToJobObject(job, process.Process); err != nil {
+		_ = windows.TerminateProcess(process.Process, 126)
+		return 0, fmt.Errorf("failed to assign the command to the job: %w", err)
+	}
+	if _, err := windows.ResumeThread(process.Thread); err != nil {
+		_ = windows.TerminateProcess(process.Process, 126)
+		return 0, fmt.Errorf("failed to resume the command: %w", err)
+	}
+
+	if _, err := windows.WaitForSingleObject(process.Process, windows.INFINITE); err != nil {
+		return 0, fmt.Errorf("failed to wait for the command: %w", err)
+	}
+	var code uint32
+	if err := windows.GetExitCodeProcess(process.Process, &code); err != nil {
+		return 0, fmt.Errorf("failed to get the exit code of the command: %w", err)
+	}
+	return code, nil
+}
+
+// newSandboxJob creates the Job Object of the command, killing its
+// processes when it is closed, with the limits of the configuration
+func newSandboxJob(config *windowsSandboxHelperConfig) (windows.Handle, error) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create the job: %w", err)
+	}
+
+	var limits windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+	limits.BasicLimitInformation.LimitFlags = windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+	if config.JobMemory > 0 {
+		limits.BasicLimitInformation.LimitFlags |= windows.JOB_OBJECT_LIMIT_JOB_MEMORY
+		limits.JobMemoryLimit = uintptr(config.JobMemory)
+	}
+	if config.ActiveProcesses > 0 {
+		limits.BasicLimitInformation.LimitFlags |= windows.JOB_OBJECT_LIMIT_ACTIVE_PROCESS
+		limits.BasicLimitInformation.ActiveProcessLimit = config.ActiveProcesses
+	}
+	if _, err := windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&limits)), uint32(unsafe.Sizeof(limits))); err != nil {
+		_ = windows.CloseHandle(job)
+		return 0, fmt.Errorf("failed to set the limits of the job: %w", err)
+	}
+
+	if config.CPURate > 0 {
+		rate := jobObjectCPURateControlInformation{
+			ControlFlags: jobObjectCPURateControlEnable | jobObjectCPURateControlHardCap,
+			CPURate:      config.CPURate,
+		}
+		if _, err := windows.SetInformationJobObject(job, windows.JobObjectCpuRateControlInformation,
+			uintptr(unsafe.Pointer(&rate)), uint32(unsafe.Sizeof(rate))); err != nil {
+			_ = windows.CloseHandle(job)
+			return 0, fmt.Errorf("failed to set the CPU rate of the job: %w", err)
+		}
+	}
+	return job, nil
+}
@@ -0,0 +1,244 @@
+package runner
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// WorkspaceDirParam is the template parameter holding the directory of the
+	// workspace of an execution (see WithWorkspace)
+	WorkspaceDirParam = "workspace_dir"
+
+	// WorkspaceDirEnv is the environment variable holding the directory of the
+	// workspace of an execution (see WithWorkspace)
+	WorkspaceDirEnv = "WORKSPACE_DIR"
+)
+
+// ErrWorkspaceDestroyed is returned when using a destroyed Workspace
+var ErrWorkspaceDestroyed = errors.New("workspace destroyed")
+
+// Workspace is a managed host directory shared by several executions. The
+// executions started with WithWorkspace can read and write it in every
+// backend (it is added to the writable folders, or mounted in the container),
+// so the steps of a tool share their state deliberately instead of through
+// the temporary folders of the host.
+type Workspace struct {
+	dir string
+
+	mu        sync.Mutex
+	destroyed bool
+}
+
+// CreateWorkspace creates a workspace in dir, or in a new temporary
+// directory when dir is empty. The directory is removed by Destroy.
+func CreateWorkspace(dir string) (*Workspace, error) {
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "go-restricted-runner-workspace-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create workspace directory: %w", err)
+		}
+		dir = tmp
+	} else {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid workspace directory %s: %w", dir, err)
+		}
+		if err := os.MkdirAll(abs, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create workspace directory: %w", err)
+		}
+		dir = abs
+	}
+	return &Workspace{dir: dir}, nil
+}
+
+// Dir returns the directory of the workspace
+func (w *Workspace) Dir() string {
+	return w.dir
+}
+
+// Populate writes files (keyed by their path relative to the workspace) in
+// the workspace, replacing the existing ones.
+func (w *Workspace) Populate(files map[string][]byte) error {
+	if err := w.check(); err != nil {
+		return err
+	}
+	for name, content := range files {
+		dest, err := stagedPath(w.dir, name)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(dest, content, 0o644); err != nil {
+			return fmt.Errorf("failed to write workspace file %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// PopulatePaths is like Populate, but copies the content of host files (the
+// values of the map) into the workspace.
+func (w *Workspace) PopulatePaths(paths map[string]string) error {
+	if err := w.check(); err != nil {
+		return err
+	}
+	for name, src := range paths {
+		dest, err := stagedPath(w.dir, name)
+		if err != nil {
+			return err
+		}
+		if err := copyWorkspaceFile(src, dest); err != nil {
+			return fmt.Errorf("failed to copy workspace file %s from %s: %w", name, src, err)
+		}
+	}
+	return nil
+}
+
+// copyWorkspaceFile copies a host file to the workspace
+func copyWorkspaceFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Snapshot writes the content of the workspace to out as a gzipped tar
+// archive, with paths relative to the workspace. Symbolic links are stored
+// as links, and other special files are skipped.
+func (w *Workspace) Snapshot(out io.Writer) error {
+	if err := w.check(); err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.WalkDir(w.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == w.dir {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case !info.Mode().IsRegular() && !info.IsDir():
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(w.dir, path)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to snapshot workspace %s: %w", w.dir, err)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Destroy removes the directory of the workspace. The workspace cannot be
+// used afterwards, and destroying it again does nothing.
+func (w *Workspace) Destroy() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.destroyed {
+		return nil
+	}
+	w.destroyed = true
+	if err := os.RemoveAll(w.dir); err != nil {
+		return fmt.Errorf("failed to remove workspace %s: %w", w.dir, err)
+	}
+	return nil
+}
+
+// check returns ErrWorkspaceDestroyed once the workspace has been destroyed
+func (w *Workspace) check() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.destroyed {
+		return ErrWorkspaceDestroyed
+	}
+	return nil
+}
+
+// WithWorkspace makes a workspace readable and writable by the command. The
+// command finds its directory in the WORKSPACE_DIR environment variable, and
+// the runner options can refer to it with the workspace_dir template
+// parameter. The workspace is not removed when the execution completes.
+func WithWorkspace(ws *Workspace) ExecOption {
+	return func(c *execConfig) {
+		c.workspace = ws
+	}
+}
+
+// writableDirs returns the host directories of params the command can write:
+// the workspace and the scratch directory, if any
+func writableDirs(params map[string]interface{}) []string {
+	var dirs []string
+	for _, key := range []string{WorkspaceDirParam, ScratchDirParam} {
+		if dir, _ := params[key].(string); dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// withWritableDirs returns the folders plus the writable directories in params
+func withWritableDirs(folders []string, params map[string]interface{}) []string {
+	result := folders
+	for _, dir := range writableDirs(params) {
+		if !contains(result, dir) {
+			result = append(append([]string{}, result...), dir)
+		}
+	}
+	return result
+}
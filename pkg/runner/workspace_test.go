@@ -0,0 +1,146 @@
+package runner
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWorkspace(t *testing.T) {
+	ws, err := CreateWorkspace("")
+	if err != nil {
+		t.Fatalf("CreateWorkspace failed: %v", err)
+	}
+	defer func() { _ = ws.Destroy() }()
+
+	src := filepath.Join(t.TempDir(), "src.txt")
+	if err := os.WriteFile(src, []byte("from host\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	if err := ws.Populate(map[string][]byte{"data/in.txt": []byte("inline\n")}); err != nil {
+		t.Fatalf("Populate failed: %v", err)
+	}
+	if err := ws.PopulatePaths(map[string]string{"copy.txt": src}); err != nil {
+		t.Fatalf("PopulatePaths failed: %v", err)
+	}
+	// existing files are replaced
+	if err := ws.Populate(map[string][]byte{"data/in.txt": []byte("replaced\n")}); err != nil {
+		t.Fatalf("Populate failed: %v", err)
+	}
+	if err := ws.Populate(map[string][]byte{"../escape": nil}); err == nil {
+		t.Errorf("Expected an error for a name escaping the workspace")
+	}
+
+	var buf bytes.Buffer
+	if err := ws.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("Snapshot is not gzipped: %v", err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read the snapshot: %v", err)
+		}
+		content, _ := io.ReadAll(tr)
+		files[header.Name] = string(content)
+	}
+	want := map[string]string{"copy.txt": "from host\n", "data/": "", "data/in.txt": "replaced\n"}
+	if len(files) != len(want) {
+		t.Errorf("Snapshot contains %v, want %v", files, want)
+	}
+	for name, content := range want {
+		if files[name] != content {
+			t.Errorf("Snapshot file %s = %q, want %q", name, files[name], content)
+		}
+	}
+
+	if err := ws.Destroy(); err != nil {
+		t.Fatalf("Destroy failed: %v", err)
+	}
+	if _, err := os.Stat(ws.Dir()); !os.IsNotExist(err) {
+		t.Errorf("Expected the workspace %s to be removed, got %v", ws.Dir(), err)
+	}
+	if err := ws.Populate(map[string][]byte{"x": nil}); !errors.Is(err, ErrWorkspaceDestroyed) {
+		t.Errorf("Populate() after Destroy = %v, want ErrWorkspaceDestroyed", err)
+	}
+	if err := ws.Destroy(); err != nil {
+		t.Errorf("Destroy() twice = %v", err)
+	}
+}
+
+// TestStart_Workspace tests that executions share the files of a workspace
+func TestStart_Workspace(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix commands")
+	}
+	ws, err := CreateWorkspace(filepath.Join(t.TempDir(), "ws"))
+	if err != nil {
+		t.Fatalf("CreateWorkspace failed: %v", err)
+	}
+	defer func() { _ = ws.Destroy() }()
+
+	r, err := NewExec(Options{}, nil)
+	if err != nil {
+		t.Fatalf("NewExec failed: %v", err)
+	}
+	run := func(script string) string {
+		e, err := Start(context.Background(), r, "sh", []string{"-c", script}, nil, nil, WithWorkspace(ws))
+		if err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		_ = e.Stdin.Close()
+		output, _ := io.ReadAll(e.Stdout)
+		_, _ = io.ReadAll(e.Stderr)
+		if err := e.Wait(); err != nil {
+			t.Fatalf("Wait failed: %v", err)
+		}
+		return strings.TrimSpace(string(output))
+	}
+
+	if dir := run(`echo step1 > "$WORKSPACE_DIR/state"; echo "$WORKSPACE_DIR"`); dir != ws.Dir() {
+		t.Errorf("WORKSPACE_DIR = %q, want %q", dir, ws.Dir())
+	}
+	if output := run(`cat "$WORKSPACE_DIR/state"`); output != "step1" {
+		t.Errorf("the second step read %q", output)
+	}
+
+	_ = ws.Destroy()
+	if _, err := Start(context.Background(), r, "true", nil, nil, nil, WithWorkspace(ws)); !errors.Is(err, ErrWorkspaceDestroyed) {
+		t.Errorf("Start() with a destroyed workspace = %v, want ErrWorkspaceDestroyed", err)
+	}
+}
+
+// TestWithWritableDirs tests that the workspace is added to the writable folders
+func TestWithWritableDirs(t *testing.T) {
+	folders := []string{"/data"}
+
+	if got := withWritableDirs(folders, nil); len(got) != 1 {
+		t.Errorf("Expected folders unchanged without a workspace, got %v", got)
+	}
+	got := withWritableDirs(folders, map[string]interface{}{WorkspaceDirParam: "/tmp/ws"})
+	if len(got) != 2 || got[1] != "/tmp/ws" || len(folders) != 1 {
+		t.Errorf("Expected the workspace to be added, got %v", got)
+	}
+
+	r := &Firejail{options: FirejailOptions{AllowWriteFolders: []string{"/data"}}}
+	opts := r.profileOptions(map[string]interface{}{WorkspaceDirParam: "/tmp/ws"})
+	if !contains(opts.AllowWriteFolders, "/tmp/ws") {
+		t.Errorf("Expected the workspace in the firejail writable folders, got %v", opts.AllowWriteFolders)
+	}
+}